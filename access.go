@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAccessSpan = 24 * time.Hour
+	defaultAccessStep = 30 * time.Second
+	// Window edges are refined between samples to accessTolerance.
+	accessTolerance = time.Second
+
+	defaultAccessMaxRangeKM  = 1000
+	defaultAccessMinSunAngle = 30
+
+	astronomicalUnitKm = 149597870.7
+)
+
+// AccessConstraints decide when the observer can image the target.
+type AccessConstraints struct {
+	MaxRangeKM float64 `json:"max_range_km"`
+	// MinSunAngleDeg is the least angle, seen from the observer, between
+	// the target and the Sun.
+	MinSunAngleDeg float64 `json:"min_sun_angle_deg"`
+	// TargetSunlit requires the target to be out of Earth's shadow, and
+	// ObserverEclipsed the observer to be in it.
+	TargetSunlit     bool `json:"target_sunlit"`
+	ObserverEclipsed bool `json:"observer_eclipsed"`
+}
+
+// met reports whether the constraints hold with the observer, target and
+// Sun at these TEME positions. The Earth must also not block the line of
+// sight. The sun angle does not matter while the Earth hides the Sun from
+// the observer.
+func (k AccessConstraints) met(observer, target, sun [3]float64) bool {
+	if norm(sub(target, observer)) > k.MaxRangeKM || occulted(observer, target) {
+		return false
+	}
+	observerSunlit := sunlit(observer, sun)
+	if observerSunlit && sunAngle(observer, target, sun) < k.MinSunAngleDeg {
+		return false
+	}
+	if k.TargetSunlit && !sunlit(target, sun) {
+		return false
+	}
+	return !k.ObserverEclipsed || !observerSunlit
+}
+
+// occulted reports whether the Earth blocks the line of sight from observer
+// to target.
+func occulted(observer, target [3]float64) bool {
+	los := sub(target, observer)
+	// The point of the line of sight nearest the Earth's center.
+	s := math.Max(0, math.Min(1, -dot(observer, los)/dot(los, los)))
+	return norm([3]float64{observer[0] + s*los[0], observer[1] + s*los[1], observer[2] + s*los[2]}) < earthRadiusKm
+}
+
+// sunAngle is the angle in degrees between the target and the Sun as seen
+// from the observer.
+func sunAngle(observer, target, sun [3]float64) float64 {
+	return angleDeg(sub(target, observer), sub(sun, observer))
+}
+
+func angleDeg(a, b [3]float64) float64 {
+	return math.Acos(math.Max(-1, math.Min(1, dot(a, b)/(norm(a)*norm(b))))) * 180 / math.Pi
+}
+
+// sunPosition is the Sun's position in km at t in the mean equator and
+// equinox of date, which TEME is close enough to for shadows, after the
+// Astronomical Almanac's low-precision formulas (about 0.01°).
+func sunPosition(t time.Time) [3]float64 {
+	jd := float64(t.UnixNano())/86400e9 + 2440587.5
+	tut1 := (jd - 2451545) / 36525
+	rad := math.Pi / 180
+	meanLon := 280.460 + 36000.771*tut1
+	anomaly := (357.5291092 + 35999.05034*tut1) * rad
+	eclLon := (meanLon + 1.914666471*math.Sin(anomaly) + 0.019994643*math.Sin(2*anomaly)) * rad
+	dist := (1.000140612 - 0.016708617*math.Cos(anomaly) - 0.000139589*math.Cos(2*anomaly)) * astronomicalUnitKm
+	obliquity := (23.439291 - 0.0130042*tut1) * rad
+	return [3]float64{
+		dist * math.Cos(eclLon),
+		dist * math.Cos(obliquity) * math.Sin(eclLon),
+		dist * math.Sin(obliquity) * math.Sin(eclLon),
+	}
+}
+
+// sunlit reports whether a position is outside Earth's shadow, taken as a
+// cylinder behind the Earth.
+func sunlit(r, sun [3]float64) bool {
+	n := norm(sun)
+	u := [3]float64{sun[0] / n, sun[1] / n, sun[2] / n}
+	along := dot(r, u)
+	if along >= 0 {
+		return true
+	}
+	return norm([3]float64{r[0] - along*u[0], r[1] - along*u[1], r[2] - along*u[2]}) > earthRadiusKm
+}
+
+func sub(a, b [3]float64) [3]float64 { return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+func dot(a, b [3]float64) float64    { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+func norm(a [3]float64) float64      { return math.Sqrt(dot(a, a)) }
+
+// AccessWindow is a span in which the observer can image the target, in
+// the terms of a mission's collection window.
+type AccessWindow struct {
+	CollectionWindowStart int64 `json:"collection_window_start"`
+	CollectionWindowEnd   int64 `json:"collection_window_end"`
+	// TCA and MinRangeKM are the closest approach within the window.
+	TCA        int64   `json:"tca"`
+	MinRangeKM float64 `json:"min_range_km"`
+	// Coverage is what the observer's sensor sees of the target at TCA,
+	// when it has one registered.
+	Coverage *SensorCoverage `json:"coverage,omitempty"`
+}
+
+// accessWindows samples from start to end every step and brackets each
+// span where the constraints hold to accessTolerance. Windows open at start
+// or end are cut there.
+func accessWindows(pair orbitPair, k AccessConstraints, start, end time.Time, step time.Duration) ([]AccessWindow, error) {
+	visible := func(t time.Time) (bool, error) {
+		o, err := pair.observer.At(t)
+		if err != nil {
+			return false, fmt.Errorf("observer at %s: %w", t.Format(time.RFC3339), err)
+		}
+		tg, err := pair.target.At(t)
+		if err != nil {
+			return false, fmt.Errorf("target at %s: %w", t.Format(time.RFC3339), err)
+		}
+		return k.met(o.Position, tg.Position, sunPosition(t)), nil
+	}
+	// edge is the first time after lo at which visibility differs from was.
+	edge := func(lo, hi time.Time, was bool) (time.Time, error) {
+		for hi.Sub(lo) > accessTolerance {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			v, err := visible(mid)
+			if err != nil {
+				return mid, err
+			}
+			if v == was {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return hi, nil
+	}
+
+	windows := []AccessWindow{}
+	var open time.Time
+	was := false
+	prev := start
+	for t := start; ; t = t.Add(step) {
+		if t.After(end) {
+			t = end
+		}
+		v, err := visible(t)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case v && !was && t.Equal(start):
+			open = start
+		case v && !was:
+			if open, err = edge(prev, t, false); err != nil {
+				return nil, err
+			}
+		case !v && was:
+			closed, err := edge(prev, t, true)
+			if err != nil {
+				return nil, err
+			}
+			if windows, err = appendWindow(windows, pair, open, closed.Add(-accessTolerance)); err != nil {
+				return nil, err
+			}
+		}
+		was, prev = v, t
+		if !t.Before(end) {
+			break
+		}
+	}
+	if was {
+		return appendWindow(windows, pair, open, end)
+	}
+	return windows, nil
+}
+
+func appendWindow(windows []AccessWindow, pair orbitPair, start, end time.Time) ([]AccessWindow, error) {
+	start, end = start.Round(time.Second), end.Round(time.Second)
+	if end.Before(start) {
+		end = start
+	}
+	g, err := closestApproach(pair.target, pair.observer, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return append(windows, AccessWindow{
+		CollectionWindowStart: start.Unix(),
+		CollectionWindowEnd:   end.Unix(),
+		TCA:                   g.TCA,
+		MinRangeKM:            g.MinRangeKM,
+	}), nil
+}
+
+// AccessWindowsResponse is the body of GET /access-windows.
+type AccessWindowsResponse struct {
+	Observer    string            `json:"observer"`
+	Target      string            `json:"target"`
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	StepSeconds float64           `json:"step_seconds"`
+	Constraints AccessConstraints `json:"constraints"`
+	// ObserverTLE and TargetTLE are the element sets propagated.
+	ObserverTLE TLE            `json:"observer_tle"`
+	TargetTLE   TLE            `json:"target_tle"`
+	Windows     []AccessWindow `json:"windows"`
+}
+
+// parseAccessConstraints reads ?max_range_km=, ?min_sun_angle=,
+// ?target_sunlit= and ?observer_eclipsed=.
+func parseAccessConstraints(c *gin.Context) (AccessConstraints, error) {
+	k := AccessConstraints{MaxRangeKM: defaultAccessMaxRangeKM, MinSunAngleDeg: defaultAccessMinSunAngle, TargetSunlit: true}
+	if v := c.Query("max_range_km"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f > 0) || math.IsInf(f, 0) {
+			return k, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'max_range_km' parameter. Must be a positive number.")
+		}
+		k.MaxRangeKM = f
+	}
+	if v := c.Query("min_sun_angle"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f >= 0 && f < 180) {
+			return k, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'min_sun_angle' parameter. Must be degrees from 0 up to 180.")
+		}
+		k.MinSunAngleDeg = f
+	}
+	for _, flag := range []struct {
+		name string
+		v    *bool
+	}{{"target_sunlit", &k.TargetSunlit}, {"observer_eclipsed", &k.ObserverEclipsed}} {
+		if v := c.Query(flag.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return k, newProblem(http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid '%s' parameter. Must be true or false.", flag.name))
+			}
+			*flag.v = b
+		}
+	}
+	return k, nil
+}
+
+// getAccessWindows answers with the spans from ?start= to ?end= in which
+// ?observer= can image ?target=, as candidate collection windows for new
+// missions.
+func (api *API) getAccessWindows(c *gin.Context) {
+	observer, target := c.Query("observer"), c.Query("target")
+	if observer == "" || target == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Missing 'observer' or 'target' parameter. Both must be satellite IDs.")
+		return
+	}
+	k, err := parseAccessConstraints(c)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	start, end, step, err := parseTimeWindow(c, defaultAccessSpan, defaultAccessStep)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	pair, err := api.orbits(c.Request.Context(), target, observer, start.Add(end.Sub(start)/2))
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	windows, err := accessWindows(pair, k, start, end, step)
+	if err != nil {
+		respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, err.Error())
+		return
+	}
+	for i := range windows {
+		windows[i].Coverage = api.sensorCoverage(observer, target, windows[i].MinRangeKM)
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.IndentedJSON(http.StatusOK, AccessWindowsResponse{
+		Observer: observer, Target: target, Start: start, End: end, StepSeconds: step.Seconds(),
+		Constraints: k, ObserverTLE: pair.observerTLE, TargetTLE: pair.targetTLE, Windows: windows,
+	})
+}
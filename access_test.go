@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSunPosition(t *testing.T) {
+	tests := []struct {
+		at      time.Time
+		dec, ra float64
+		au      float64
+	}{
+		// The March equinox and June solstice of 2024.
+		{time.Date(2024, 3, 20, 3, 6, 0, 0, time.UTC), 0, 0, 0.9960},
+		{time.Date(2024, 6, 20, 20, 51, 0, 0, time.UTC), 23.44, 90, 1.0163},
+	}
+	for _, tt := range tests {
+		s := sunPosition(tt.at)
+		r := norm(s)
+		dec := math.Asin(s[2]/r) * 180 / math.Pi
+		ra := math.Mod(math.Atan2(s[1], s[0])*180/math.Pi+360, 360)
+		if math.Abs(dec-tt.dec) > 0.02 || math.Abs(math.Remainder(ra-tt.ra, 360)) > 0.02 || math.Abs(r/astronomicalUnitKm-tt.au) > 0.0005 {
+			t.Errorf("%v: dec %.3f ra %.3f %.4f au, want %.2f %.2f %.4f", tt.at, dec, ra, r/astronomicalUnitKm, tt.dec, tt.ra, tt.au)
+		}
+	}
+}
+
+func TestSunlit(t *testing.T) {
+	sun := [3]float64{astronomicalUnitKm, 0, 0}
+	tests := []struct {
+		r    [3]float64
+		want bool
+	}{
+		{[3]float64{7000, 0, 0}, true},
+		{[3]float64{0, 7000, 0}, true},
+		{[3]float64{-7000, 0, 0}, false},
+		{[3]float64{-7000, 6000, 0}, false},
+		{[3]float64{-7000, 0, 6500}, true},
+	}
+	for _, tt := range tests {
+		if got := sunlit(tt.r, sun); got != tt.want {
+			t.Errorf("sunlit(%v) = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestAccessWindows(t *testing.T) {
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	target, _ := newSGP4(iss)
+	observer, _ := newSGP4(shiftedISS(t))
+	pair := orbitPair{target: target, observer: observer}
+	k := AccessConstraints{MaxRangeKM: 300, MinSunAngleDeg: 30, TargetSunlit: true}
+	start := iss.Epoch.Truncate(time.Second)
+	end := start.Add(6 * time.Hour)
+	windows, err := accessWindows(pair, k, start, end, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same windows, found second by second.
+	var want [][2]int64
+	for at := start; !at.After(end); at = at.Add(time.Second) {
+		o, _ := observer.At(at)
+		tg, _ := target.At(at)
+		met := k.met(o.Position, tg.Position, sunPosition(at))
+		switch n := len(want); {
+		case met && (n == 0 || want[n-1][1] != at.Unix()-1):
+			want = append(want, [2]int64{at.Unix(), at.Unix()})
+		case met:
+			want[n-1][1] = at.Unix()
+		}
+	}
+	if len(want) == 0 || len(windows) != len(want) {
+		t.Fatalf("found %d windows, want %d", len(windows), len(want))
+	}
+	for i, w := range windows {
+		if d := w.CollectionWindowStart - want[i][0]; d < -1 || d > 1 {
+			t.Errorf("window %d starts %d, want %d", i, w.CollectionWindowStart, want[i][0])
+		}
+		if d := w.CollectionWindowEnd - want[i][1]; d < -1 || d > 1 {
+			t.Errorf("window %d ends %d, want %d", i, w.CollectionWindowEnd, want[i][1])
+		}
+		if w.TCA < w.CollectionWindowStart || w.TCA > w.CollectionWindowEnd || w.MinRangeKM > k.MaxRangeKM {
+			t.Errorf("window %d: %+v", i, w)
+		}
+	}
+
+	// Without constraints that bind, the whole span is one window.
+	k = AccessConstraints{MaxRangeKM: 1e6}
+	windows, _ = accessWindows(pair, k, start, start.Add(time.Hour), time.Minute)
+	if len(windows) != 1 || windows[0].CollectionWindowStart != start.Unix() || windows[0].CollectionWindowEnd != start.Add(time.Hour).Unix() {
+		t.Errorf("unconstrained windows %+v", windows)
+	}
+}
+
+func TestGetAccessWindows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	shifted := shiftedISS(t)
+	api := &API{Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, "")}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive, TLE: &iss})
+	api.Satellites.Put(ctx, Satellite{ID: "chaser", NoradID: 25544, Name: "Chaser", Status: SatelliteActive, TLE: &shifted})
+	router := gin.New()
+	router.GET("/access-windows", api.getAccessWindows)
+	do := func(query string) (*httptest.ResponseRecorder, map[string]any) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/access-windows?"+query, nil))
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	at := "&start=" + iss.Epoch.Truncate(time.Second).Format(time.RFC3339)
+	tests := []struct {
+		query  string
+		want   int
+		code   ErrorCode
+		detail string
+	}{
+		{"target=iss", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"observer=chaser&target=iss&max_range_km=0", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"observer=chaser&target=iss&min_sun_angle=180", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"observer=chaser&target=iss&target_sunlit=maybe", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"observer=chaser&target=iss&step=1" + at, http.StatusBadRequest, CodeLimitExceeded, ""},
+		{"observer=nope&target=iss" + at, http.StatusNotFound, CodeSatelliteNotFound, "observer nope: satellite not found"},
+	}
+	for _, tt := range tests {
+		w, body := do(tt.query)
+		if w.Code != tt.want || body["code"] != string(tt.code) || tt.detail != "" && body["detail"] != tt.detail {
+			t.Errorf("%s: %d %v, want %d %s", tt.query, w.Code, body, tt.want, tt.code)
+		}
+	}
+
+	w, body := do("observer=chaser&target=iss&max_range_km=300&end=" + iss.Epoch.Add(6*time.Hour).Format(time.RFC3339) + at)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	windows, _ := body["windows"].([]any)
+	constraints, _ := body["constraints"].(map[string]any)
+	if len(windows) == 0 || constraints["max_range_km"] != 300.0 || constraints["min_sun_angle_deg"] != 30.0 || constraints["target_sunlit"] != true {
+		t.Errorf("response %s", w.Body)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+// imageMissionTTL is how long the meter remembers which mission an image
+// was ingested into, to charge the requests for it.
+const imageMissionTTL = 10 * time.Minute
+
+// MissionStorage is what one mission of one tenant stores, and what has
+// been served for it.
+type MissionStorage struct {
+	ID        string `dynamodbav:"id" json:"-"`
+	Tenant    string `dynamodbav:"tenant,omitempty" json:"tenant,omitempty"`
+	MissionID string `dynamodbav:"mission_id" json:"mission_id,omitempty"`
+	// Images and StoredBytes count the ingested originals linked to the
+	// mission, as uploaded.
+	Images      int64 `dynamodbav:"images" json:"images"`
+	StoredBytes int64 `dynamodbav:"stored_bytes" json:"stored_bytes"`
+	// Requests and ServedBytes count the answered requests for the mission
+	// and its images, and their response bodies before compression.
+	Requests    int64 `dynamodbav:"requests" json:"requests"`
+	ServedBytes int64 `dynamodbav:"served_bytes" json:"served_bytes"`
+}
+
+func (s *MissionStorage) add(o *MissionStorage) {
+	s.Images += o.Images
+	s.StoredBytes += o.StoredBytes
+	s.Requests += o.Requests
+	s.ServedBytes += o.ServedBytes
+}
+
+// storageID keys the mission's account in STORAGE_TABLE.
+func storageID(tenant, missionID string) string {
+	return tenant + "/" + missionID
+}
+
+// StorageMeter accounts stored and served bytes per tenant and mission.
+// With STORAGE_TABLE set every instance adds its counts to DynamoDB each
+// minute; without it each keeps its own in memory.
+type StorageMeter struct {
+	mu sync.Mutex
+	// accounts are the totals without STORAGE_TABLE, and the counts not
+	// yet added to it with one.
+	accounts map[string]*MissionStorage
+	// images remembers the mission each image was ingested into.
+	images *memoryKV
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newStorageMeter(db *dynamodb.Client, table string) *StorageMeter {
+	m := &StorageMeter{accounts: map[string]*MissionStorage{}, images: newMemoryKV(), table: table}
+	if m.table != "" {
+		m.db = db
+	}
+	return m
+}
+
+// Start adds the counts to STORAGE_TABLE every usageFlushInterval until ctx
+// is done.
+func (m *StorageMeter) Start(ctx context.Context) {
+	if m.db == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.flush(ctx)
+			}
+		}
+	}()
+}
+
+// AddStored counts images and bytes stored for mission of tenant; both are
+// negative for originals replaced.
+func (m *StorageMeter) AddStored(tenant, missionID string, images, bytes int64) {
+	if m == nil || missionID == "" {
+		return
+	}
+	m.merge(&MissionStorage{Tenant: tenant, MissionID: missionID, Images: images, StoredBytes: bytes})
+}
+
+// AddServed counts a response of bytes for mission of tenant.
+func (m *StorageMeter) AddServed(tenant, missionID string, bytes int64) {
+	if m == nil || missionID == "" {
+		return
+	}
+	m.merge(&MissionStorage{Tenant: tenant, MissionID: missionID, Requests: 1, ServedBytes: bytes})
+}
+
+func (m *StorageMeter) merge(s *MissionStorage) {
+	id := storageID(s.Tenant, s.MissionID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if have, ok := m.accounts[id]; ok {
+		have.add(s)
+		return
+	}
+	s.ID = id
+	m.accounts[id] = s
+}
+
+// pending copies the accounts kept in memory that keep selects.
+func (m *StorageMeter) pending(keep func(*MissionStorage) bool) map[string]MissionStorage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := map[string]MissionStorage{}
+	for id, s := range m.accounts {
+		if keep(s) {
+			found[id] = *s
+		}
+	}
+	return found
+}
+
+// flush adds the pending counts to STORAGE_TABLE. Counts that could not be
+// written are kept for the next flush.
+func (m *StorageMeter) flush(ctx context.Context) {
+	m.mu.Lock()
+	pending := m.accounts
+	m.accounts = map[string]*MissionStorage{}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, usageWriteTimeout)
+	defer cancel()
+	for _, s := range pending {
+		if err := m.write(ctx, s); err != nil {
+			usageFailures.Inc()
+			slog.ErrorContext(ctx, "failed to record storage", "tenant", s.Tenant, "mission", s.MissionID, "err", err)
+			m.merge(s)
+		}
+	}
+}
+
+// write adds s to its item, creating it on the first write.
+func (m *StorageMeter) write(ctx context.Context, s *MissionStorage) error {
+	n := func(v int64) types.AttributeValue {
+		return &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+	}
+	_, err := m.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(m.table),
+		Key:              map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: s.ID}},
+		UpdateExpression: aws.String("SET #tenant = :tenant, #mission = :mission ADD #images :images, #stored :stored, #requests :requests, #served :served"),
+		ExpressionAttributeNames: map[string]string{
+			"#tenant": "tenant", "#mission": "mission_id", "#images": "images",
+			"#stored": "stored_bytes", "#requests": "requests", "#served": "served_bytes",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tenant":   &types.AttributeValueMemberS{Value: s.Tenant},
+			":mission":  &types.AttributeValueMemberS{Value: s.MissionID},
+			":images":   n(s.Images),
+			":stored":   n(s.StoredBytes),
+			":requests": n(s.Requests),
+			":served":   n(s.ServedBytes),
+		},
+	})
+	return err
+}
+
+// Get returns mission's account, counting what this instance has not yet
+// added to STORAGE_TABLE.
+func (m *StorageMeter) Get(ctx context.Context, tenant, missionID string) (MissionStorage, error) {
+	id := storageID(tenant, missionID)
+	found := MissionStorage{ID: id, Tenant: tenant, MissionID: missionID}
+	if m.db != nil {
+		out, err := m.db.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(m.table),
+			Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		})
+		if err != nil {
+			return found, err
+		}
+		if out.Item != nil {
+			if err := attributevalue.UnmarshalMap(out.Item, &found); err != nil {
+				return found, err
+			}
+		}
+	}
+	if s, ok := m.pending(func(s *MissionStorage) bool { return s.ID == id })[id]; ok {
+		found.add(&s)
+	}
+	return found, nil
+}
+
+// Query returns the accounts of tenant's missions, or every tenant's for
+// "*", the most stored first.
+func (m *StorageMeter) Query(ctx context.Context, tenant string) ([]MissionStorage, error) {
+	all := tenant == "*"
+	accounts := m.pending(func(s *MissionStorage) bool { return all || s.Tenant == tenant })
+	if m.db != nil {
+		input := &dynamodb.ScanInput{TableName: aws.String(m.table)}
+		if !all {
+			input.FilterExpression = aws.String("#tenant = :tenant")
+			input.ExpressionAttributeNames = map[string]string{"#tenant": "tenant"}
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{":tenant": &types.AttributeValueMemberS{Value: tenant}}
+		}
+		paginator := dynamodb.NewScanPaginator(m.db, input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			var stored []MissionStorage
+			if err := attributevalue.UnmarshalListOfMaps(page.Items, &stored); err != nil {
+				return nil, err
+			}
+			for _, s := range stored {
+				if have, ok := accounts[s.ID]; ok {
+					s.add(&have)
+				}
+				accounts[s.ID] = s
+			}
+		}
+	}
+	found := []MissionStorage{}
+	for _, s := range accounts {
+		found = append(found, s)
+	}
+	slices.SortFunc(found, func(a, b MissionStorage) int {
+		return cmp.Or(cmp.Compare(b.StoredBytes, a.StoredBytes), cmp.Compare(b.ServedBytes, a.ServedBytes),
+			cmp.Compare(a.Tenant, b.Tenant), cmp.Compare(a.MissionID, b.MissionID))
+	})
+	return found, nil
+}
+
+// rememberImage notes the mission image id of tenant was ingested into.
+func (m *StorageMeter) rememberImage(tenant, id, missionID string) {
+	m.images.Set(context.Background(), storageID(tenant, id), []byte(missionID), imageMissionTTL)
+}
+
+// imageMission returns the mission image id was ingested into, or "" for
+// none.
+func (m *StorageMeter) imageMission(ctx context.Context, images ImageStore, id string) string {
+	tenant := tenantFrom(ctx)
+	if v, ok := m.images.Get(ctx, storageID(tenant, id)); ok {
+		return string(v)
+	}
+	record, err := images.ImageRecord(ctx, id)
+	if err != nil {
+		return ""
+	}
+	var missionID string
+	if record != nil && record.Ingest != nil {
+		missionID = record.Ingest.MissionID
+	}
+	m.rememberImage(tenant, id, missionID)
+	return missionID
+}
+
+// accountStorage moves image id's original from the mission its last upload
+// was counted for to rec's. With KEEP_IMAGE_VERSIONS the bytes of a
+// replaced upload are still stored, so they stay counted.
+func (api *API) accountStorage(ctx context.Context, id string, rec IngestRecord) {
+	if api.Storage == nil {
+		return
+	}
+	tenant := tenantFrom(ctx)
+	prev, err := api.Images.ImageRecord(ctx, id)
+	if err == nil && prev != nil && prev.Ingest != nil && prev.Ingest.Status == IngestIngested {
+		replaced := prev.Ingest.Bytes
+		if api.Config.KeepImageVersions && prev.Ingest.SHA256 != rec.SHA256 {
+			replaced = 0
+		}
+		api.Storage.AddStored(tenant, prev.Ingest.MissionID, -1, -replaced)
+	}
+	api.Storage.AddStored(tenant, rec.MissionID, 1, rec.Bytes)
+	api.Storage.rememberImage(tenant, id, rec.MissionID)
+}
+
+// meterServed charges the response to c to the mission it was for: the one
+// a /mission/:id route names, or the one an /image/:id route's image was
+// ingested into.
+func (api *API) meterServed(c *gin.Context) {
+	if api.Storage == nil || c.Writer.Status() >= http.StatusBadRequest {
+		return
+	}
+	ctx := c.Request.Context()
+	route := strings.TrimPrefix(c.FullPath(), apiV1Prefix)
+	var missionID string
+	switch {
+	case strings.HasPrefix(route, "/mission/:id"):
+		missionID = c.Param("id")
+	case strings.HasPrefix(route, "/image/:id"):
+		missionID = api.Storage.imageMission(ctx, api.Images, c.Param("id"))
+	}
+	api.Storage.AddServed(tenantFrom(ctx), missionID, int64(max(c.Writer.Size(), 0)))
+}
+
+// StorageReport is the body of GET /admin/storage.
+type StorageReport struct {
+	// Missions are the accounts, the most stored first.
+	Missions []MissionStorage `json:"missions"`
+	// Totals sum Missions, without a mission ID.
+	Totals MissionStorage `json:"totals"`
+}
+
+// getStorageReport reports the stored and served bytes of every mission of
+// ?tenant=, or of every tenant without it. Callers other than ADMIN_TOKEN
+// see only their own tenant's.
+func (api *API) getStorageReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenant := c.DefaultQuery("tenant", "*")
+	switch {
+	case !api.Config.MultiTenant:
+		tenant = ""
+	case principalFrom(ctx) != adminPrincipal:
+		tenant = tenantFrom(ctx)
+	}
+	missions, err := api.Storage.Query(ctx, tenant)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to query storage", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to query storage")
+		return
+	}
+	report := StorageReport{Missions: missions}
+	for _, s := range missions {
+		report.Totals.add(&s)
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
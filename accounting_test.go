@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestStorageAccounting(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	for _, id := range []string{"leo", "geo"} {
+		if err := putMission(ctx, meta, &Mission{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reprocessed, err := seedImage(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        store,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+		Storage:   newStorageMeter(nil, ""),
+	}
+	ingest := func(id string, data []byte) {
+		t.Helper()
+		_, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ingestImage(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ingest("leo-01", frame)
+	ingest("leo-02", frame)
+	// A reprocessed upload replaces the original it was counted for.
+	ingest("leo-02", reprocessed)
+	ingest("geo-01", frame)
+
+	router := gin.New()
+	router.Use(api.meterUsage())
+	router.GET("/image/:id", api.getSatImageByID)
+	router.GET("/mission/:id", api.getMissionById)
+	router.GET("/admin/storage", api.getStorageReport)
+	get := func(path string, v any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+		if v != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	get("/image/leo-01", nil)
+	get("/image/leo-01", nil)
+
+	var mission ExpandedMission
+	get("/mission/leo?expand=storage", &mission)
+	want := MissionStorage{MissionID: "leo", Images: 2, StoredBytes: int64(len(frame) + len(reprocessed)), Requests: 2, ServedBytes: int64(2 * len(frame))}
+	if mission.Storage == nil || *mission.Storage != want {
+		t.Errorf("leo storage %+v, want %+v", mission.Storage, want)
+	}
+
+	var report StorageReport
+	get("/admin/storage", &report)
+	if len(report.Missions) != 2 || report.Missions[0].MissionID != "leo" || report.Missions[1].MissionID != "geo" {
+		t.Fatalf("report %+v", report.Missions)
+	}
+	// The expanded mission counted as a request for leo too.
+	if report.Totals.Images != 3 || report.Totals.StoredBytes != int64(2*len(frame)+len(reprocessed)) || report.Totals.Requests != 3 {
+		t.Errorf("totals %+v", report.Totals)
+	}
+}
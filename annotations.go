@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+)
+
+const (
+	maxAnnotations           = 500
+	defaultAnnotationColor   = "#ffff00"
+	defaultAnnotationStroke  = 2
+	maxAnnotationStroke      = 32
+	annotationLabelPadding   = 2
+	annotationLabelMaxLength = 200
+)
+
+var annotationLabelBackground = color.NRGBA{0, 0, 0, 0xb0}
+
+// Annotation is one piece of analyst markup in full-resolution source pixel
+// coordinates. Boxes use X, Y, Width and Height; circles are centred on X, Y
+// with Radius; text labels are anchored at their top-left corner X, Y.
+type Annotation struct {
+	Type        string `json:"type"`
+	X           int    `json:"x"`
+	Y           int    `json:"y"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	Radius      int    `json:"radius,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Color       string `json:"color,omitempty"`
+	StrokeWidth int    `json:"stroke_width,omitempty"`
+}
+
+type annotationSet struct {
+	ID          string       `json:"id"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+func annotationsKey(id string) string {
+	return fmt.Sprintf("annotations/%s.json", id)
+}
+
+func (a Annotation) validate() error {
+	switch a.Type {
+	case "box":
+		if a.Width <= 0 || a.Height <= 0 {
+			return errors.New("box annotations need a positive width and height")
+		}
+	case "circle":
+		if a.Radius <= 0 {
+			return errors.New("circle annotations need a positive radius")
+		}
+	case "text":
+		if a.Label == "" {
+			return errors.New("text annotations need a label")
+		}
+	default:
+		return errors.New("annotation type must be box, circle, or text")
+	}
+	if len(a.Label) > annotationLabelMaxLength {
+		return fmt.Errorf("annotation labels must be at most %d characters", annotationLabelMaxLength)
+	}
+	if a.StrokeWidth < 0 || a.StrokeWidth > maxAnnotationStroke {
+		return fmt.Errorf("annotation stroke_width must be between 0 and %d", maxAnnotationStroke)
+	}
+	if a.Color != "" {
+		if _, err := parseHexColor(a.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHexColor reads #rgb or #rrggbb.
+func parseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid annotation color %q, use #rrggbb", s)
+	}
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// loadAnnotations returns the stored annotations for id, or none if the image
+// has never been annotated.
+func (api *API) loadAnnotations(ctx context.Context, bucketName, id string) ([]Annotation, error) {
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(annotationsKey(id)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var set annotationSet
+	if err := json.NewDecoder(out.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode annotations: %w", err)
+	}
+	return set.Annotations, nil
+}
+
+func (api *API) getAnnotations(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	annotations, err := api.loadAnnotations(c.Request.Context(), bucketName, id)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to load annotations", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to retrieve annotations")
+		return
+	}
+	if annotations == nil {
+		annotations = []Annotation{}
+	}
+
+	c.IndentedJSON(http.StatusOK, annotationSet{ID: id, Annotations: annotations})
+}
+
+// putAnnotations replaces the full set of annotations stored for an image.
+func (api *API) putAnnotations(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	var set annotationSet
+	if err := c.ShouldBindJSON(&set); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"annotations\": [...]}.")
+		return
+	}
+	if len(set.Annotations) > maxAnnotations {
+		respondError(c, http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("At most %d annotations may be stored per image.", maxAnnotations))
+		return
+	}
+	for i, a := range set.Annotations {
+		if err := a.validate(); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("annotation %d: %v", i, err))
+			return
+		}
+	}
+	if set.Annotations == nil {
+		set.Annotations = []Annotation{}
+	}
+	set.ID = id
+
+	ctx := c.Request.Context()
+	if _, err := api.S3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(imageKey(id)),
+	}); err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		slog.ErrorContext(ctx, "s3 HeadObject failed", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to store annotations")
+		return
+	}
+
+	body, err := json.Marshal(set)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to store annotations")
+		return
+	}
+	_, err = api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(annotationsKey(id)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "s3 PutObject failed", "key", annotationsKey(id), "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to store annotations")
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, set)
+}
+
+// drawAnnotations burns annotations into img, which shows the source region
+// in full-resolution pixels at whatever size it has been resized to. Stroke
+// widths and labels are in output pixels so markup stays legible on
+// downscaled renders. The result is 8-bit.
+func drawAnnotations(img image.Image, annotations []Annotation, region image.Rectangle) *image.NRGBA {
+	dst := imaging.Clone(img)
+	b := dst.Bounds()
+	sx := float64(b.Dx()) / float64(region.Dx())
+	sy := float64(b.Dy()) / float64(region.Dy())
+	toOut := func(x, y int) image.Point {
+		return image.Pt(
+			int(math.Round(float64(x-region.Min.X)*sx)),
+			int(math.Round(float64(y-region.Min.Y)*sy)),
+		)
+	}
+
+	for _, a := range annotations {
+		col, err := parseHexColor(a.Color)
+		if a.Color == "" || err != nil {
+			col, _ = parseHexColor(defaultAnnotationColor)
+		}
+		stroke := a.StrokeWidth
+		if stroke == 0 {
+			stroke = defaultAnnotationStroke
+		}
+
+		switch a.Type {
+		case "box":
+			r := image.Rectangle{Min: toOut(a.X, a.Y), Max: toOut(a.X+a.Width, a.Y+a.Height)}
+			strokeRect(dst, r, stroke, col)
+			if a.Label != "" {
+				drawLabel(dst, a.Label, image.Pt(r.Min.X, r.Min.Y-labelHeight()-stroke), col)
+			}
+		case "circle":
+			center := toOut(a.X, a.Y)
+			radius := float64(a.Radius) * (sx + sy) / 2
+			strokeCircle(dst, center, radius, stroke, col)
+			if a.Label != "" {
+				drawLabel(dst, a.Label, image.Pt(center.X-int(radius), center.Y-int(radius)-labelHeight()-stroke), col)
+			}
+		case "text":
+			drawLabel(dst, a.Label, toOut(a.X, a.Y), col)
+		}
+	}
+
+	return dst
+}
+
+func strokeRect(dst *image.NRGBA, r image.Rectangle, stroke int, col color.Color) {
+	src := image.NewUniform(col)
+	edges := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+stroke),
+		image.Rect(r.Min.X, r.Max.Y-stroke, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+stroke, r.Max.Y),
+		image.Rect(r.Max.X-stroke, r.Min.Y, r.Max.X, r.Max.Y),
+	}
+	for _, e := range edges {
+		draw.Draw(dst, e, src, image.Point{}, draw.Over)
+	}
+}
+
+func strokeCircle(dst *image.NRGBA, center image.Point, radius float64, stroke int, col color.NRGBA) {
+	half := float64(stroke) / 2
+	reach := int(math.Ceil(radius + half))
+	box := image.Rect(center.X-reach, center.Y-reach, center.X+reach+1, center.Y+reach+1).Intersect(dst.Bounds())
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		for x := box.Min.X; x < box.Max.X; x++ {
+			d := math.Hypot(float64(x-center.X), float64(y-center.Y))
+			if math.Abs(d-radius) <= half {
+				dst.SetNRGBA(x, y, col)
+			}
+		}
+	}
+}
+
+func labelHeight() int {
+	return basicfont.Face7x13.Height + 2*annotationLabelPadding
+}
+
+// drawLabel writes text on a translucent backing box with its top-left corner
+// at pt, nudged back inside the image when it would fall off an edge.
+func drawLabel(dst *image.NRGBA, text string, pt image.Point, col color.Color) {
+	face := basicfont.Face7x13
+	w := len(text)*face.Advance + 2*annotationLabelPadding
+	h := labelHeight()
+
+	b := dst.Bounds()
+	pt.X = max(b.Min.X, min(pt.X, b.Max.X-w))
+	pt.Y = max(b.Min.Y, min(pt.Y, b.Max.Y-h))
+
+	bg := image.Rect(pt.X, pt.Y, pt.X+w, pt.Y+h)
+	draw.Draw(dst, bg, image.NewUniform(annotationLabelBackground), image.Point{}, draw.Over)
+
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(col), Face: face}
+	drawCaption(d, text, pt.X+annotationLabelPadding, pt.Y+annotationLabelPadding+face.Ascent)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseHexColor(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want color.NRGBA
+		ok   bool
+	}{
+		{"#ff8000", color.NRGBA{0xff, 0x80, 0x00, 0xff}, true},
+		{"#F80", color.NRGBA{0xff, 0x88, 0x00, 0xff}, true},
+		{"00ff00", color.NRGBA{0x00, 0xff, 0x00, 0xff}, true},
+		{"#ff80", color.NRGBA{}, false},
+		{"#gg0000", color.NRGBA{}, false},
+		{"", color.NRGBA{}, false},
+	} {
+		got, err := parseHexColor(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestAnnotationValidate(t *testing.T) {
+	for _, tt := range []struct {
+		a  Annotation
+		ok bool
+	}{
+		{Annotation{Type: "box", Width: 10, Height: 5}, true},
+		{Annotation{Type: "box", Width: 10}, false},
+		{Annotation{Type: "circle", Radius: 3, Color: "#0f0"}, true},
+		{Annotation{Type: "circle"}, false},
+		{Annotation{Type: "text", Label: "target"}, true},
+		{Annotation{Type: "text"}, false},
+		{Annotation{Type: "arrow"}, false},
+		{Annotation{Type: "box", Width: 1, Height: 1, StrokeWidth: maxAnnotationStroke + 1}, false},
+		{Annotation{Type: "box", Width: 1, Height: 1, Color: "red"}, false},
+		{Annotation{Type: "text", Label: strings.Repeat("x", annotationLabelMaxLength+1)}, false},
+	} {
+		if err := tt.a.validate(); (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v", tt.a, err)
+		}
+	}
+}
+
+func TestDrawAnnotations(t *testing.T) {
+	// A 200×200 region rendered at 100×100, so source pixels are halved.
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 0xff
+	}
+	out := drawAnnotations(src, []Annotation{
+		{Type: "box", X: 40, Y: 40, Width: 100, Height: 100},
+		{Type: "circle", X: 160, Y: 160, Radius: 20, Color: "#00ff00", StrokeWidth: 1},
+	}, image.Rect(0, 0, 200, 200))
+
+	yellow, green, black := color.NRGBA{0xff, 0xff, 0, 0xff}, color.NRGBA{0, 0xff, 0, 0xff}, color.NRGBA{0, 0, 0, 0xff}
+	for _, tt := range []struct {
+		name string
+		x, y int
+		want color.NRGBA
+	}{
+		{"box left edge", 20, 45, yellow},
+		{"box bottom edge", 45, 69, yellow},
+		{"inside the box", 45, 45, black},
+		{"circle", 90, 80, green},
+		{"circle centre", 80, 80, black},
+	} {
+		if got := out.NRGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if src.NRGBAAt(20, 45) != black {
+		t.Error("source drawn on")
+	}
+}
+
+func TestAnnotationsRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := testFSStore(t)
+	putTestObject(t, store, imageKey("a"), testObject(10))
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store}
+	router := gin.New()
+	router.GET("/image/:id/annotations", api.getAnnotations)
+	router.PUT("/image/:id/annotations", api.putAnnotations)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	read := func(path string) annotationSet {
+		t.Helper()
+		var set annotationSet
+		if w := do(http.MethodGet, path, ""); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &set) != nil {
+			t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+		return set
+	}
+
+	if set := read("/image/a/annotations"); set.ID != "a" || set.Annotations == nil || len(set.Annotations) != 0 {
+		t.Errorf("before any PUT: %+v", set)
+	}
+	if w := do(http.MethodPut, "/image/a/annotations", `{"annotations": [{"type": "box", "x": 1, "y": 2, "width": 3, "height": 4, "label": "debris"}]}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+	if set := read("/image/a/annotations"); len(set.Annotations) != 1 || set.Annotations[0] != (Annotation{Type: "box", X: 1, Y: 2, Width: 3, Height: 4, Label: "debris"}) {
+		t.Errorf("after PUT: %+v", set)
+	}
+
+	for _, tt := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/image/a/annotations", `{"annotations": [{"type": "box"}]}`, http.StatusBadRequest},
+		{"/image/a/annotations", `[]`, http.StatusBadRequest},
+		{"/image/a/annotations", `{"annotations": [` + strings.Repeat(`{"type": "text", "label": "x"},`, maxAnnotations) + `{"type": "text", "label": "x"}]}`, http.StatusBadRequest},
+		{"/image/gone/annotations", `{"annotations": []}`, http.StatusNotFound},
+	} {
+		if w := do(http.MethodPut, tt.path, tt.body); w.Code != tt.want {
+			t.Errorf("PUT %s %.40s: %d, want %d", tt.path, tt.body, w.Code, tt.want)
+		}
+	}
+	if set := read("/image/a/annotations"); len(set.Annotations) != 1 {
+		t.Errorf("rejected PUTs changed the set: %+v", set)
+	}
+}
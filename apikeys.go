@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// apiKeyPrefix starts every API key, which is
+	// "sak_<id>_<64 hex digit secret>".
+	apiKeyPrefix = "sak_"
+	// With API_KEYS_TABLE set, the keys are reread every apiKeyRefresh, so
+	// a key revoked through another instance stops working within it.
+	apiKeyRefresh    = time.Minute
+	maxAPIKeys       = 1000
+	maxAPIKeyNameLen = 100
+)
+
+var (
+	errInvalidAPIKey  = errors.New("invalid API key")
+	errAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is a credential granting its scopes until it expires or is
+// deleted. Only the SHA-256 hash of its secret is kept.
+type APIKey struct {
+	ID     string   `dynamodbav:"id" json:"id"`
+	Name   string   `dynamodbav:"name,omitempty" json:"name,omitempty"`
+	Scopes []string `dynamodbav:"scopes" json:"scopes"`
+	// Tenant is the tenant the key acts for with MULTI_TENANT: the one the
+	// request that created it acted for.
+	Tenant string `dynamodbav:"tenant,omitempty" json:"tenant,omitempty"`
+	Hash   string `dynamodbav:"hash" json:"-"`
+	// Key is the credential itself. It is only returned by the request that
+	// created the key.
+	Key     string `dynamodbav:"-" json:"key,omitempty"`
+	Created int64  `dynamodbav:"created" json:"created"`
+	// Expires is when the key stops working; zero means never.
+	Expires int64 `dynamodbav:"expires,omitempty" json:"expires,omitempty"`
+}
+
+func (k *APIKey) expired(now time.Time) bool {
+	return k.Expires != 0 && now.Unix() >= k.Expires
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore holds the API keys. When API_KEYS_TABLE is set they are saved
+// to DynamoDB and shared by every instance; without it they live only in
+// this process and are lost on restart.
+type APIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newAPIKeyStore(db *dynamodb.Client, table string) *APIKeyStore {
+	s := &APIKeyStore{keys: make(map[string]*APIKey), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the keys and rereads them until ctx is done.
+func (s *APIKeyStore) Start(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load API keys", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(apiKeyRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload API keys", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Create saves k with a new ID, secret and creation time, and returns it
+// with Key set.
+func (s *APIKeyStore) Create(ctx context.Context, k APIKey) (APIKey, error) {
+	s.mu.RLock()
+	n := len(s.keys)
+	s.mu.RUnlock()
+	if n >= maxAPIKeys {
+		return APIKey{}, newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("At most %d API keys may exist.", maxAPIKeys))
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	secret := hex.EncodeToString(b)
+	k.ID = newJobID()
+	k.Hash = hashAPIKeySecret(secret)
+	k.Created = time.Now().Unix()
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(k)
+		if err != nil {
+			return APIKey{}, fmt.Errorf("marshal API key: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return APIKey{}, err
+		}
+	}
+	stored := k
+	s.mu.Lock()
+	s.keys[k.ID] = &stored
+	s.mu.Unlock()
+	k.Key = apiKeyPrefix + k.ID + "_" + secret
+	return k, nil
+}
+
+// Get returns the key without its secret.
+func (s *APIKeyStore) Get(id string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	return *k, nil
+}
+
+// List returns every key, oldest first, without their secrets.
+func (s *APIKeyStore) List() []APIKey {
+	s.mu.RLock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, *k)
+	}
+	s.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created != keys[j].Created {
+			return keys[i].Created < keys[j].Created
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// Delete revokes a key.
+func (s *APIKeyStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	if s.db != nil {
+		_, err := s.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	delete(s.keys, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Authenticate returns the key token is, or errInvalidAPIKey if it is
+// malformed, unknown, revoked or expired. A key created through another
+// instance since the last refresh is read from the table.
+func (s *APIKeyStore) Authenticate(ctx context.Context, token string) (APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, apiKeyPrefix), "_")
+	if s == nil || !ok || id == "" || secret == "" {
+		return APIKey{}, errInvalidAPIKey
+	}
+	s.mu.RLock()
+	k, found := s.keys[id]
+	s.mu.RUnlock()
+	if !found && s.db != nil {
+		out, err := s.db.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+		})
+		if err != nil {
+			return APIKey{}, fmt.Errorf("get API key: %w", err)
+		}
+		if out.Item != nil {
+			k = &APIKey{}
+			if err := attributevalue.UnmarshalMap(out.Item, k); err != nil {
+				return APIKey{}, fmt.Errorf("unmarshal API key: %w", err)
+			}
+			s.mu.Lock()
+			s.keys[id] = k
+			s.mu.Unlock()
+		}
+	}
+	if k == nil || subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(k.Hash)) != 1 || k.expired(time.Now()) {
+		return APIKey{}, errInvalidAPIKey
+	}
+	return *k, nil
+}
+
+// refresh replaces the keys with those in API_KEYS_TABLE.
+func (s *APIKeyStore) refresh(ctx context.Context) error {
+	keys := make(map[string]*APIKey)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []APIKey
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for i := range items {
+			keys[items[i].ID] = &items[i]
+		}
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// apiKeyRequest is the body of POST /api-keys.
+type apiKeyRequest struct {
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes"`
+	// Expires is a unix time; zero means the key never expires.
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// validate checks req and returns it as a key to create.
+func (req apiKeyRequest) validate(now time.Time) (APIKey, error) {
+	if len(req.Name) > maxAPIKeyNameLen {
+		return APIKey{}, newProblem(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("'name' must be at most %d characters.", maxAPIKeyNameLen))
+	}
+	if len(req.Scopes) == 0 {
+		return APIKey{}, newProblem(http.StatusBadRequest, CodeInvalidBody, "'scopes' must name at least one scope.")
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(scopes, scope) {
+			return APIKey{}, newProblem(http.StatusBadRequest, CodeInvalidBody,
+				fmt.Sprintf("Unknown scope %q. Must be one of %s.", scope, strings.Join(scopes, ", ")))
+		}
+	}
+	if req.Expires != 0 && req.Expires <= now.Unix() {
+		return APIKey{}, newProblem(http.StatusBadRequest, CodeInvalidBody, "'expires' must be in the future.")
+	}
+	return APIKey{Name: req.Name, Scopes: slices.Compact(slices.Sorted(slices.Values(req.Scopes))), Expires: req.Expires}, nil
+}
+
+// APIKeyListResponse is the body of GET /api-keys.
+type APIKeyListResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
+}
+
+// postAPIKey creates an API key. The response carries the key, which is not
+// shown again.
+func (api *API) postAPIKey(c *gin.Context) {
+	var req apiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"name\": \"...\", \"scopes\": [...]}.")
+		return
+	}
+	key, err := req.validate(time.Now())
+	if err == nil {
+		key.Tenant = tenantFrom(c.Request.Context())
+		key, err = api.Keys.Create(c.Request.Context(), key)
+	}
+	if err != nil {
+		var p *Problem
+		if !errors.As(err, &p) {
+			slog.ErrorContext(c.Request.Context(), "failed to save API key", "err", err)
+		}
+		respondProblem(c, problemFor(err))
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "created API key", "id", key.ID, "scopes", key.Scopes, "tenant", key.Tenant)
+	c.IndentedJSON(http.StatusCreated, key)
+}
+
+// getAPIKeys lists the keys of the caller's tenant, or every key for
+// ADMIN_TOKEN.
+func (api *API) getAPIKeys(c *gin.Context) {
+	keys := []APIKey{}
+	for _, key := range api.Keys.List() {
+		if api.ownsTenant(c.Request.Context(), key.Tenant) {
+			keys = append(keys, key)
+		}
+	}
+	c.IndentedJSON(http.StatusOK, APIKeyListResponse{APIKeys: keys})
+}
+
+// tenantAPIKey returns the key id of the caller's tenant. Another tenant's
+// is not found.
+func (api *API) tenantAPIKey(ctx context.Context, id string) (APIKey, error) {
+	key, err := api.Keys.Get(id)
+	if err == nil && !api.ownsTenant(ctx, key.Tenant) {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (api *API) getAPIKey(c *gin.Context) {
+	key, err := api.tenantAPIKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeAPIKeyNotFound, "API key not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, key)
+}
+
+func (api *API) deleteAPIKey(c *gin.Context) {
+	_, err := api.tenantAPIKey(c.Request.Context(), c.Param("id"))
+	if err == nil {
+		err = api.Keys.Delete(c.Request.Context(), c.Param("id"))
+	}
+	if err != nil {
+		if errors.Is(err, errAPIKeyNotFound) {
+			respondError(c, http.StatusNotFound, CodeAPIKeyNotFound, "API key not found")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to delete API key", "id", c.Param("id"), "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete API key")
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "deleted API key", "id", c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeyStoreAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	s := newAPIKeyStore(nil, "")
+	key, err := s.Create(ctx, APIKey{Name: "scheduler", Scopes: []string{ScopeMissionsRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key.Key, apiKeyPrefix+key.ID+"_") || key.Hash == "" {
+		t.Fatalf("created key = %+v", key)
+	}
+	expired, err := s.Create(ctx, APIKey{Scopes: []string{ScopeAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.keys[expired.ID].Expires = time.Now().Add(-time.Minute).Unix()
+	revoked, err := s.Create(ctx, APIKey{Scopes: []string{ScopeAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The wrong secret differs from the key in its last character.
+	wrong := key.Key[:len(key.Key)-1] + "0"
+	if wrong == key.Key {
+		wrong = key.Key[:len(key.Key)-1] + "1"
+	}
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", key.Key, true},
+		{"wrong secret", wrong, false},
+		{"no secret", apiKeyPrefix + key.ID, false},
+		{"unknown", apiKeyPrefix + "0123_abcd", false},
+		{"expired", expired.Key, false},
+		{"revoked", revoked.Key, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Authenticate(ctx, tt.token)
+			if tt.ok {
+				if err != nil || got.ID != key.ID || got.Key != "" {
+					t.Errorf("Authenticate = %+v, %v", got, err)
+				}
+			} else if !errors.Is(err, errInvalidAPIKey) {
+				t.Errorf("err = %v, want errInvalidAPIKey", err)
+			}
+		})
+	}
+	var unset *APIKeyStore
+	if _, err := unset.Authenticate(ctx, key.Key); !errors.Is(err, errInvalidAPIKey) {
+		t.Errorf("nil store: err = %v", err)
+	}
+}
+
+func TestAPIKeyRequestValidate(t *testing.T) {
+	now := time.Unix(1791936000, 0)
+	tests := []struct {
+		name string
+		req  apiKeyRequest
+		ok   bool
+	}{
+		{"scopes", apiKeyRequest{Name: "dashboard", Scopes: []string{ScopeImagesRead, ScopeMissionsRead, ScopeImagesRead}}, true},
+		{"expires", apiKeyRequest{Scopes: []string{ScopeAdmin}, Expires: now.Unix() + 1}, true},
+		{"no scopes", apiKeyRequest{Name: "dashboard"}, false},
+		{"unknown scope", apiKeyRequest{Scopes: []string{"missions:delete"}}, false},
+		{"expired", apiKeyRequest{Scopes: []string{ScopeAdmin}, Expires: now.Unix()}, false},
+		{"long name", apiKeyRequest{Name: strings.Repeat("k", maxAPIKeyNameLen+1), Scopes: []string{ScopeAdmin}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.req.validate(now)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !slices.IsSorted(key.Scopes) {
+				t.Errorf("scopes = %v, want sorted and deduplicated", key.Scopes)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	keys := newAPIKeyStore(nil, "")
+	reader, err := keys.Create(ctx, APIKey{Scopes: []string{ScopeMissionsRead, ScopeImagesRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := keys.Create(ctx, APIKey{Scopes: []string{ScopeAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		required bool
+		scope    string
+		auth     string
+		query    string
+		want     int
+	}{
+		{name: "anonymous read", scope: ScopeMissionsRead, want: http.StatusNoContent},
+		{name: "anonymous write", scope: ScopeMissionsWrite, want: http.StatusUnauthorized},
+		{name: "anonymous image write", scope: ScopeImagesWrite, want: http.StatusUnauthorized},
+		{name: "anonymous read, auth required", required: true, scope: ScopeMissionsRead, want: http.StatusUnauthorized},
+		{name: "key with scope", required: true, scope: ScopeImagesRead, auth: "Bearer " + reader.Key, want: http.StatusNoContent},
+		{name: "key without scope", scope: ScopeMissionsWrite, auth: "Bearer " + reader.Key, want: http.StatusForbidden},
+		{name: "key without scope for anonymous scope", scope: ScopeImagesWrite, auth: "Bearer " + reader.Key, want: http.StatusForbidden},
+		{name: "admin key", scope: ScopeMissionsWrite, auth: "Bearer " + admin.Key, want: http.StatusNoContent},
+		{name: "admin token", scope: ScopeAdmin, auth: "Bearer secret", want: http.StatusNoContent},
+		{name: "invalid key", scope: ScopeMissionsRead, auth: "Bearer " + apiKeyPrefix + reader.ID + "_guess", want: http.StatusUnauthorized},
+		{name: "query token", required: true, scope: ScopeMissionsRead, query: "?access_token=" + reader.Key, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{AdminToken: "secret", AuthRequired: tt.required}
+			router := gin.New()
+			var got *Principal
+			handler := func(c *gin.Context) {
+				if p, ok := c.Get(principalKey); ok {
+					got = p.(*Principal)
+				}
+				c.Status(http.StatusNoContent)
+			}
+			router.GET("/header", requireScope(cfg, &Credentials{Keys: keys}, tt.scope, false), handler)
+			router.GET("/stream", requireScope(cfg, &Credentials{Keys: keys}, tt.scope, true), handler)
+
+			path := "/header"
+			if tt.query != "" {
+				path = "/stream" + tt.query
+			}
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if (w.Header().Get("WWW-Authenticate") != "") != (w.Code == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q", w.Header().Get("WWW-Authenticate"))
+			}
+			if w.Code == http.StatusNoContent && (got == nil) != (tt.auth == "" && tt.query == "") {
+				t.Errorf("principal = %+v", got)
+			}
+		})
+	}
+}
+
+func TestAPIKeyRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{Config: &Config{AdminToken: "secret"}, Keys: newAPIKeyStore(nil, "")}
+	router := gin.New()
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api-keys", `{"name": "operator", "scopes": ["missions:write"]}`, "secret")
+	var created APIKey
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil || created.ID == "" || created.Key == "" {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), `"hash"`) {
+		t.Errorf("create returned the hash: %s", w.Body)
+	}
+	if w := do(http.MethodPost, "/api-keys", `{"scopes": ["everything"]}`, "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid create: status = %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api-keys", "", created.Key); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), string(CodeForbidden)) {
+		t.Errorf("list with a missions:write key: %d %s", w.Code, w.Body)
+	}
+
+	w = do(http.MethodGet, "/api-keys", "", "secret")
+	var list APIKeyListResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list.APIKeys) != 1 || list.APIKeys[0].Key != "" {
+		t.Errorf("list: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/api-keys/"+created.ID, "", "secret"); w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Key) {
+		t.Errorf("get: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/api-keys/"+created.ID, "", "secret"); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api-keys/"+created.ID, "", "secret"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), string(CodeAPIKeyNotFound)) {
+		t.Errorf("get after delete: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/mission/m1/invalidate", "", created.Key); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status = %d", w.Code)
+	}
+
+	// Writes, and plate solving, are refused to anonymous callers.
+	for _, route := range [][2]string{
+		{http.MethodPost, "/image/a/platesolve"},
+		{http.MethodPut, "/image/a/detections"},
+		{http.MethodPut, "/image/a/annotations"},
+		{http.MethodPost, "/images/a/derivatives"},
+	} {
+		req := httptest.NewRequest(route[0], route[1], strings.NewReader("{}"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: status = %d", route[0], route[1], w.Code)
+		}
+	}
+}
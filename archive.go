@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+type archiveEntry struct {
+	ID          string `json:"id"`
+	File        string `json:"file"`
+	CaptureTime int64  `json:"capture_time,omitempty"`
+}
+
+type archiveManifest struct {
+	MissionID string         `json:"mission_id"`
+	Created   int64          `json:"created"`
+	Images    []archiveEntry `json:"images"`
+	Skipped   []string       `json:"skipped,omitempty"`
+}
+
+// getMissionArchive streams a zip of the mission's images. Without processing
+// parameters the originals are copied in unchanged; with them (the same ones
+// /image/:id takes) each image is processed before it is added. The archive
+// ends with a manifest.json listing what it holds. Nothing is staged on disk,
+// so a failure part-way through can only be logged and the stream cut short.
+func (api *API) getMissionArchive(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	opts, err := parseProcessOptions(c, api.Overlay)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	if err := api.gateFormat(c.Request.Context(), &opts, c.GetHeader("Accept")); err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+
+	// The archive is already streaming when images are processed, so it
+	// waits for capacity instead of failing part-way.
+	ctx := waitForCapacity(c.Request.Context())
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+	if len(mission.ImageIDs) == 0 {
+		respondError(c, http.StatusNotFound, CodeMissionEmpty, "mission has no images")
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "mission-"+id+".zip"))
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	manifest := archiveManifest{MissionID: id, Created: time.Now().Unix(), Images: []archiveEntry{}}
+	for _, imageID := range mission.ImageIDs {
+		entry, err := api.writeArchiveEntry(ctx, zw, bucketName, imageID, opts)
+		if err != nil {
+			// Once an entry has started, the archive cannot be repaired.
+			if entry != nil || ctx.Err() != nil {
+				slog.WarnContext(ctx, "archive aborted", "mission", id, "image", imageID, "err", err)
+				return
+			}
+			slog.WarnContext(ctx, "archive skipped", "mission", id, "image", imageID, "err", err)
+			manifest.Skipped = append(manifest.Skipped, imageID)
+			continue
+		}
+		manifest.Images = append(manifest.Images, *entry)
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err == nil {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "archive finish failed", "mission", id, "err", err)
+	}
+}
+
+// writeArchiveEntry adds one image to zw. It returns a nil entry with the
+// error if nothing was written for the image, and the partial entry if the
+// failure came after its zip header.
+func (api *API) writeArchiveEntry(ctx context.Context, zw *zip.Writer, bucketName, id string, opts ProcessOptions) (*archiveEntry, error) {
+	key := imageKey(id)
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}
+	if opts.NeedsProcessing() || opts.StripMetadata {
+		in.Range = firstPart()
+	}
+	out, err := api.S3.GetObject(ctx, in, s3Accelerate(bucketName)...)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	captured := parseCaptureTime(out.Metadata, out.LastModified)
+	entry := &archiveEntry{ID: id, File: id + "." + extensionFor(aws.ToString(out.ContentType))}
+	if !captured.IsZero() {
+		entry.CaptureTime = captured.Unix()
+	}
+
+	var body io.Reader = out.Body
+	if opts.Annotate {
+		opts.Annotations, err = api.loadAnnotations(ctx, bucketName, id)
+		if err != nil {
+			return nil, fmt.Errorf("load annotations: %w", err)
+		}
+	}
+	if err := api.loadObjectOverlay(ctx, id, &opts); err != nil {
+		return nil, fmt.Errorf("load detections: %w", err)
+	}
+	if !opts.NeedsProcessing() && opts.StripMetadata {
+		raw, err := api.readObject(ctx, bucketName, key, out, out.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer putBuffer(raw)
+		data := raw.Bytes()
+		stripped, format, err := stripDownload(data)
+		if err != nil {
+			return nil, err
+		}
+		if format == nil {
+			body = bytes.NewReader(stripped)
+		} else {
+			opts.Format = format
+			out.Body = memoryBody{bytes.NewReader(data)}
+			out.ContentLength = aws.Int64(int64(len(data)))
+		}
+	}
+
+	// Processed images are rendered in memory first, so a failure here
+	// leaves the archive untouched and the image is just skipped.
+	if opts.NeedsProcessing() {
+		img, geo, release, err := api.renderProcessed(ctx, bucketName, key, out, opts)
+		if err != nil {
+			return nil, err
+		}
+		buf := getBuffer()
+		defer putBuffer(buf)
+		err = encodeProcessed(buf, img, opts, geo)
+		release()
+		if err != nil {
+			return nil, err
+		}
+		body = buf
+		entry.File = id + "." + opts.Format.Extension
+	}
+
+	// Image formats are already compressed, so entries are stored as-is.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.File, Method: zip.Store, Modified: captured})
+	if err != nil {
+		return entry, err
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// extensionFor maps a stored object's content type to a file extension,
+// defaulting to jpg like the image keys themselves.
+func extensionFor(contentType string) string {
+	for _, f := range outputFormats {
+		if f.ContentType == contentType {
+			return f.Extension
+		}
+	}
+	return "jpg"
+}
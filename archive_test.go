@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestExtensionFor(t *testing.T) {
+	for contentType, want := range map[string]string{
+		"image/png":                "png",
+		"image/tiff":               "tif",
+		"image/jpeg":               "jpg",
+		"application/octet-stream": "jpg",
+		"":                         "jpg",
+	} {
+		if got := extensionFor(contentType); got != want {
+			t.Errorf("extensionFor(%q) = %s, want %s", contentType, got, want)
+		}
+	}
+}
+
+func TestGetMissionArchive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := testFSStore(t)
+	frames := map[string][]byte{}
+	for i, id := range []string{"a", "b"} {
+		frame, err := seedImage(0, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames[id] = frame
+		if _, err := store.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String("bucket"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(frame), ContentType: aws.String("image/jpeg"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := testSQLStore(t)
+	for _, m := range []Mission{{ID: "m1", ImageIDs: []string{"a", "gone", "b"}}, {ID: "empty"}} {
+		data, _ := json.Marshal(m)
+		if _, err := db.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", m.ID, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store, MissionDB: db, Images: db, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	router := gin.New()
+	router.GET("/mission/:id/archive", api.getMissionArchive)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	// open reads the archive's files by name.
+	open := func(w *httptest.ResponseRecorder) map[string][]byte {
+		t.Helper()
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("archive: %d %v", w.Code, err)
+		}
+		files := map[string][]byte{}
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name], _ = io.ReadAll(r)
+			r.Close()
+		}
+		return files
+	}
+
+	w := get("/mission/m1/archive")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("archive: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	files := open(w)
+	if len(files) != 3 || !bytes.Equal(files["a.jpg"], frames["a"]) || !bytes.Equal(files["b.jpg"], frames["b"]) {
+		t.Errorf("archive holds %d files", len(files))
+	}
+	var manifest archiveManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil || manifest.MissionID != "m1" ||
+		len(manifest.Images) != 2 || manifest.Images[0].File != "a.jpg" || manifest.Images[1].ID != "b" ||
+		len(manifest.Skipped) != 1 || manifest.Skipped[0] != "gone" {
+		t.Errorf("manifest %+v, %v", manifest, err)
+	}
+
+	// With processing params every image is rendered before it is added.
+	files = open(get("/mission/m1/archive?format=png&width=64"))
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(files["a.png"]))
+	if err != nil || format != "png" || cfg.Width != 64 || files["b.png"] == nil {
+		t.Errorf("processed archive: a %dx%d %s %v, %d files", cfg.Width, cfg.Height, format, err, len(files))
+	}
+
+	for path, want := range map[string]int{
+		"/mission/none/archive":          http.StatusNotFound,
+		"/mission/empty/archive":         http.StatusNotFound,
+		"/mission/m1/archive?format=bmp": http.StatusBadRequest,
+	} {
+		if w := get(path); w.Code != want {
+			t.Errorf("GET %s: %d, want %d", path, w.Code, want)
+		}
+	}
+}
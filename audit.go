@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// auditDayIndex is the AUDIT_TABLE index partitioned by UTC day and
+	// sorted by time, which GET /admin/audit queries a day at a time.
+	auditDayIndex = "day-time"
+	// Without AUDIT_TABLE the newest auditMemoryEntries are kept.
+	auditMemoryEntries = 10000
+	auditWriteTimeout  = 5 * time.Second
+	// GET /admin/audit covers at most maxAuditRange, the last day by
+	// default, and returns up to maxAuditLimit entries.
+	maxAuditRange     = 31 * 24 * time.Hour
+	defaultAuditRange = 24 * time.Hour
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditExempt are the routes that take a body but change nothing.
+var auditExempt = map[string]bool{
+	"/graphql":                 true,
+	"/admin/debug/pprof/*name": true,
+}
+
+// AuditEntry records one request that could change state: who made it, what
+// it asked for and how it was answered.
+type AuditEntry struct {
+	ID string `dynamodbav:"id" json:"id"`
+	// Day is the UTC date of Time, the partition of auditDayIndex.
+	Day string `dynamodbav:"day" json:"-"`
+	// Time is a unix time in milliseconds.
+	Time int64 `dynamodbav:"time" json:"time"`
+	// Principal is the caller's ID, or empty for an anonymous request.
+	Principal string `dynamodbav:"principal,omitempty" json:"principal,omitempty"`
+	// Tenant is who the request acted for, with MULTI_TENANT set.
+	Tenant   string `dynamodbav:"tenant,omitempty" json:"tenant,omitempty"`
+	ClientIP string `dynamodbav:"client_ip" json:"client_ip"`
+	Method   string `dynamodbav:"method" json:"method"`
+	// Route is the matched pattern, such as /api/v1/mission/:id/invalidate.
+	Route     string `dynamodbav:"route" json:"route"`
+	Path      string `dynamodbav:"path" json:"path"`
+	MissionID string `dynamodbav:"mission_id,omitempty" json:"mission_id,omitempty"`
+	// Resource is the ID in the path, such as the image, webhook or API key.
+	Resource   string `dynamodbav:"resource,omitempty" json:"resource,omitempty"`
+	Status     int    `dynamodbav:"status" json:"status"`
+	BodySHA256 string `dynamodbav:"body_sha256,omitempty" json:"body_sha256,omitempty"`
+	RequestID  string `dynamodbav:"request_id,omitempty" json:"request_id,omitempty"`
+}
+
+// auditFilter selects entries for GET /admin/audit.
+type auditFilter struct {
+	Principal    string
+	MissionID    string
+	Tenant       string
+	Since, Until time.Time
+	Limit        int
+}
+
+func (f auditFilter) matches(e AuditEntry) bool {
+	t := time.UnixMilli(e.Time)
+	return (f.Principal == "" || e.Principal == f.Principal) &&
+		(f.MissionID == "" || e.MissionID == f.MissionID) &&
+		(f.Tenant == "" || e.Tenant == f.Tenant) &&
+		!t.Before(f.Since) && !t.After(f.Until)
+}
+
+// AuditLog is the append-only record of mutating requests. With AUDIT_TABLE
+// set entries are written to DynamoDB, never overwritten, and shared by
+// every instance; without it each instance keeps its newest entries in
+// memory.
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newAuditLog(db *dynamodb.Client, table string) *AuditLog {
+	l := &AuditLog{table: table}
+	if l.table != "" {
+		l.db = db
+	}
+	return l
+}
+
+// Record appends e, giving it an ID and day.
+func (l *AuditLog) Record(ctx context.Context, e AuditEntry) error {
+	e.ID = newJobID()
+	e.Day = time.UnixMilli(e.Time).UTC().Format(time.DateOnly)
+	if l.db == nil {
+		l.mu.Lock()
+		l.entries = append(l.entries, e)
+		if len(l.entries) > auditMemoryEntries {
+			l.entries = l.entries[len(l.entries)-auditMemoryEntries:]
+		}
+		l.mu.Unlock()
+		return nil
+	}
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	_, err = l.db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(l.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	return err
+}
+
+// Query returns the entries f selects, newest first, and reports whether
+// more matched than f.Limit.
+func (l *AuditLog) Query(ctx context.Context, f auditFilter) ([]AuditEntry, bool, error) {
+	if l.db == nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		var found []AuditEntry
+		for i := len(l.entries) - 1; i >= 0; i-- {
+			if !f.matches(l.entries[i]) {
+				continue
+			}
+			if len(found) == f.Limit {
+				return found, true, nil
+			}
+			found = append(found, l.entries[i])
+		}
+		return found, false, nil
+	}
+
+	var found []AuditEntry
+	for day := f.Until.UTC().Truncate(24 * time.Hour); !day.Before(f.Since.UTC().Truncate(24 * time.Hour)); day = day.AddDate(0, 0, -1) {
+		input := &dynamodb.QueryInput{
+			TableName:                aws.String(l.table),
+			IndexName:                aws.String(auditDayIndex),
+			KeyConditionExpression:   aws.String("#day = :day AND #time BETWEEN :since AND :until"),
+			ExpressionAttributeNames: map[string]string{"#day": "day", "#time": "time"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":day":   &types.AttributeValueMemberS{Value: day.Format(time.DateOnly)},
+				":since": &types.AttributeValueMemberN{Value: strconv.FormatInt(f.Since.UnixMilli(), 10)},
+				":until": &types.AttributeValueMemberN{Value: strconv.FormatInt(f.Until.UnixMilli(), 10)},
+			},
+			ScanIndexForward: aws.Bool(false),
+		}
+		var filters []string
+		if f.Principal != "" {
+			filters = append(filters, "principal = :principal")
+			input.ExpressionAttributeValues[":principal"] = &types.AttributeValueMemberS{Value: f.Principal}
+		}
+		if f.MissionID != "" {
+			filters = append(filters, "mission_id = :mission")
+			input.ExpressionAttributeValues[":mission"] = &types.AttributeValueMemberS{Value: f.MissionID}
+		}
+		if f.Tenant != "" {
+			filters = append(filters, "tenant = :tenant")
+			input.ExpressionAttributeValues[":tenant"] = &types.AttributeValueMemberS{Value: f.Tenant}
+		}
+		if len(filters) > 0 {
+			input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+		}
+		paginator := dynamodb.NewQueryPaginator(l.db, input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, false, err
+			}
+			var entries []AuditEntry
+			if err := attributevalue.UnmarshalListOfMaps(page.Items, &entries); err != nil {
+				return nil, false, err
+			}
+			for _, e := range entries {
+				if len(found) == f.Limit {
+					return found, true, nil
+				}
+				found = append(found, e)
+			}
+		}
+	}
+	return found, false, nil
+}
+
+// auditRequests records every request other than GET, HEAD and OPTIONS to a
+// route that may change state, once it has been answered, including those
+// refused for lacking credentials. It runs after limitRequests, which has
+// read the body into memory.
+func (api *API) auditRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		var digest string
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, CodeInvalidBody, "failed to read request body")
+				return
+			}
+			if len(body) > 0 {
+				sum := sha256.Sum256(body)
+				digest = hex.EncodeToString(sum[:])
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" || auditExempt[route] {
+			return
+		}
+		e := AuditEntry{
+			Time:       time.Now().UnixMilli(),
+			ClientIP:   c.ClientIP(),
+			Method:     c.Request.Method,
+			Route:      route,
+			Path:       c.Request.URL.Path,
+			Resource:   c.Param("id"),
+			Status:     c.Writer.Status(),
+			BodySHA256: digest,
+			RequestID:  requestIDFrom(c.Request.Context()),
+			Tenant:     tenantFrom(c.Request.Context()),
+		}
+		if e.Resource == "" {
+			e.Resource = c.Param("subject")
+		}
+		if strings.Contains(route, "/mission/:id") {
+			e.MissionID = e.Resource
+		}
+		if p, ok := c.Get(principalKey); ok {
+			e.Principal = p.(*Principal).ID
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), auditWriteTimeout)
+		defer cancel()
+		if err := api.Audit.Record(ctx, e); err != nil {
+			auditFailures.Inc()
+			slog.ErrorContext(ctx, "failed to record audit entry", "route", route, "principal", e.Principal, "status", e.Status, "err", err)
+		}
+	}
+}
+
+// parseAuditTime reads a unix time in seconds or an RFC 3339 time.
+func parseAuditTime(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// AuditResponse is the body of GET /admin/audit.
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	// Truncated is set when more entries matched than were returned. Ask
+	// again with until set to the time of the last one.
+	Truncated bool `json:"truncated"`
+}
+
+// getAudit lists the audit entries matching ?user=, ?mission=, ?since= and
+// ?until=, newest first. Callers other than ADMIN_TOKEN see only their own
+// tenant's.
+func (api *API) getAudit(c *gin.Context) {
+	f := auditFilter{Principal: c.Query("user"), MissionID: c.Query("mission"), Until: time.Now(), Limit: defaultAuditLimit}
+	f.Tenant = api.listedTenant(c.Request.Context())
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"until", &f.Until}, {"since", &f.Since}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := parseAuditTime(v)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid '%s' parameter. Must be a unix time or an RFC 3339 time.", p.name))
+				return
+			}
+			*p.dst = t
+		}
+	}
+	if f.Since.IsZero() {
+		f.Since = f.Until.Add(-defaultAuditRange)
+	}
+	if f.Since.After(f.Until) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'since' parameter. Must not be after 'until'.")
+		return
+	}
+	if f.Until.Sub(f.Since) > maxAuditRange {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("The range from 'since' to 'until' may cover at most %d days.", int(maxAuditRange.Hours()/24)))
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'limit' parameter. Must be between 1 and %d.", maxAuditLimit))
+			return
+		}
+		f.Limit = n
+	}
+
+	entries, truncated, err := api.Audit.Query(c.Request.Context(), f)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to query audit log", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to query audit log")
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	c.IndentedJSON(http.StatusOK, AuditResponse{Entries: entries, Truncated: truncated})
+}
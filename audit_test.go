@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuditRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &Config{AdminToken: "secret"}
+	api := &API{Config: cfg, Audit: newAuditLog(nil, "")}
+	router := gin.New()
+	router.Use(api.auditRequests())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.POST("/mission/:id/invalidate", api.require(ScopeMissionsWrite), ok)
+	router.PUT("/image/:id/annotations", api.require(ScopeImagesWrite), ok)
+	router.GET("/mission/:id", api.require(ScopeMissionsRead), ok)
+	router.POST("/graphql", ok)
+	router.GET("/admin/audit", api.require(ScopeAdmin), api.getAudit)
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do(http.MethodPost, "/mission/m1/invalidate", "", "secret")
+	do(http.MethodPost, "/mission/m2/invalidate", "", "")
+	do(http.MethodPut, "/image/i1/annotations", `{"annotations": []}`, "secret")
+	do(http.MethodGet, "/mission/m1", "", "")
+	do(http.MethodPost, "/graphql", `{"query": "{ missions { id } }"}`, "")
+	do(http.MethodDelete, "/unrouted", "", "")
+
+	audit := func(query string) AuditResponse {
+		t.Helper()
+		w := do(http.MethodGet, "/admin/audit"+query, "", "secret")
+		var resp AuditResponse
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("GET /admin/audit%s: %d %s", query, w.Code, w.Body)
+		}
+		return resp
+	}
+	routes := func(entries []AuditEntry) []string {
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Method+" "+e.Path+" "+strconv.Itoa(e.Status))
+		}
+		return got
+	}
+
+	all := audit("")
+	want := []string{"PUT /image/i1/annotations 204", "POST /mission/m2/invalidate 401", "POST /mission/m1/invalidate 204"}
+	if got := routes(all.Entries); !slices.Equal(got, want) {
+		t.Fatalf("entries = %v, want %v", got, want)
+	}
+	sum := sha256.Sum256([]byte(`{"annotations": []}`))
+	if e := all.Entries[0]; e.BodySHA256 != hex.EncodeToString(sum[:]) || e.Resource != "i1" || e.MissionID != "" || e.Principal != "admin" {
+		t.Errorf("annotation entry = %+v", e)
+	}
+	if e := all.Entries[2]; e.Principal != "admin" || e.MissionID != "m1" || e.Route != "/mission/:id/invalidate" || e.BodySHA256 != "" {
+		t.Errorf("invalidate entry = %+v", e)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?user=admin", []string{"PUT /image/i1/annotations 204", "POST /mission/m1/invalidate 204"}},
+		{"?mission=m2", []string{"POST /mission/m2/invalidate 401"}},
+		{"?limit=1", []string{"PUT /image/i1/annotations 204"}},
+		{"?until=" + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), nil},
+	}
+	for _, tt := range tests {
+		if got := routes(audit(tt.query).Entries); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: entries = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+	if !audit("?limit=1").Truncated {
+		t.Error("limited response not marked truncated")
+	}
+
+	for _, query := range []string{"?since=yesterday", "?since=2026-01-01T00:00:00Z", "?limit=0", "?since=2026-10-02T00:00:00Z&until=2026-10-01T00:00:00Z"} {
+		if w := do(http.MethodGet, "/admin/audit"+query, "", "secret"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes an API key can be granted. ScopeAdmin implies every other scope.
+// ScopeMissionsApprove is for POST /mission/:id/approve, which approvers may
+// do but operators may not.
+const (
+	ScopeMissionsRead    = "missions:read"
+	ScopeMissionsWrite   = "missions:write"
+	ScopeMissionsApprove = "missions:approve"
+	ScopeImagesRead      = "images:read"
+	ScopeImagesWrite     = "images:write"
+	ScopeAdmin           = "admin"
+	// ScopeMaintenance is for the /admin/maintenance operations, so
+	// operators can fix stale state without holding ScopeAdmin.
+	ScopeMaintenance = "maintenance"
+)
+
+var scopes = []string{ScopeMissionsRead, ScopeMissionsWrite, ScopeMissionsApprove, ScopeImagesRead, ScopeImagesWrite, ScopeAdmin, ScopeMaintenance}
+
+// anonymousScopes are what a request without credentials may do unless
+// AUTH_REQUIRED is set: reading. Writes, and routes that spend the server's
+// third-party credentials such as plate solving, always need a credential.
+var anonymousScopes = []string{ScopeMissionsRead, ScopeImagesRead}
+
+// principalKey is the gin context key of the authenticated *Principal.
+const principalKey = "principal"
+
+type principalContextKey struct{}
+
+// principalFrom returns the caller ctx's request authenticated as, or nil,
+// for work such as jobs that outlives the gin context.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// Principal is the caller a request authenticated as.
+type Principal struct {
+	// ID is "admin" for ADMIN_TOKEN, the key's ID for an API key and the
+	// subject of an OIDC token.
+	ID     string
+	Scopes []string
+	// Roles are those claimed by an OIDC token and those assigned
+	// through /role-assignments.
+	Roles []string
+	// Tenant is the tenant an API key was created for or an OIDC token
+	// claims, if any.
+	Tenant string
+}
+
+// Credentials are what bearer tokens are checked against besides
+// ADMIN_TOKEN, and the roles assigned to their holders. Any may be nil.
+type Credentials struct {
+	Keys  *APIKeyStore
+	OIDC  *OIDCVerifier
+	Roles *RoleStore
+}
+
+// adminPrincipal is the holder of ADMIN_TOKEN.
+var adminPrincipal = &Principal{ID: "admin", Scopes: []string{ScopeAdmin}}
+
+// can reports whether p, or an anonymous caller when p is nil, has scope.
+// Every caller has the empty scope, unless AUTH_REQUIRED refuses anonymous
+// ones.
+func (p *Principal) can(scope string, cfg *Config) bool {
+	if p == nil {
+		return !cfg.AuthRequired && (scope == "" || slices.Contains(anonymousScopes, scope))
+	}
+	return scope == "" || slices.Contains(p.Scopes, ScopeAdmin) || slices.Contains(p.Scopes, scope)
+}
+
+// authenticate returns who the bearer token belongs to: an API key, the
+// subject of a token from OIDC_ISSUER, or the holder of ADMIN_TOKEN or
+// MAINTENANCE_TOKEN, with
+// the scopes of the roles assigned to it. An empty token is the holder of
+// the request's verified client certificate, if TLS_CLIENT_SCOPES grants it
+// any, and otherwise anonymous, a nil Principal.
+func authenticate(ctx context.Context, cfg *Config, creds *Credentials, token string) (*Principal, error) {
+	if creds == nil {
+		creds = &Credentials{}
+	}
+	p, err := identify(ctx, cfg, creds, token)
+	if p != nil && p != adminPrincipal && p != maintenancePrincipal && creds.Roles != nil {
+		p = creds.Roles.grant(p, func(tenant string) bool {
+			return !cfg.MultiTenant || cmp.Or(tenant, cfg.DefaultTenant) == cmp.Or(p.Tenant, cfg.DefaultTenant)
+		})
+	}
+	return p, err
+}
+
+func identify(ctx context.Context, cfg *Config, creds *Credentials, token string) (*Principal, error) {
+	switch {
+	case token == "":
+		return certPrincipal(ctx, cfg), nil
+	case creds.OIDC != nil && isJWT(token):
+		p, err := creds.OIDC.Verify(ctx, token)
+		if errors.Is(err, errInvalidToken) {
+			return nil, newProblem(http.StatusUnauthorized, CodeUnauthorized, err.Error())
+		}
+		return p, err
+	case strings.HasPrefix(token, apiKeyPrefix):
+		key, err := creds.Keys.Authenticate(ctx, token)
+		if errors.Is(err, errInvalidAPIKey) {
+			return nil, newProblem(http.StatusUnauthorized, CodeUnauthorized, "invalid, expired or revoked API key")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{ID: key.ID, Scopes: key.Scopes, Tenant: key.Tenant}, nil
+	case cfg.MaintenanceToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MaintenanceToken)) == 1:
+		return maintenancePrincipal, nil
+	case cfg.AdminToken == "":
+		return nil, newProblem(http.StatusForbidden, CodeAdminDisabled, "the bearer token is not an API key or OIDC token, and ADMIN_TOKEN is unset")
+	case subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1:
+		return adminPrincipal, nil
+	}
+	return nil, newProblem(http.StatusUnauthorized, CodeUnauthorized, "missing or invalid bearer token")
+}
+
+// authorize returns the caller the bearer token belongs to, or the problem
+// to answer with when it lacks scope.
+func authorize(ctx context.Context, cfg *Config, creds *Credentials, token, scope string) (*Principal, error) {
+	p, err := authenticate(ctx, cfg, creds, token)
+	switch {
+	case err != nil:
+		var problem *Problem
+		if !errors.As(err, &problem) {
+			slog.ErrorContext(ctx, "failed to authenticate request", "err", err)
+			return nil, newProblem(http.StatusInternalServerError, CodeInternal, "credentials could not be checked")
+		}
+		return nil, err
+	case p.can(scope, cfg):
+		return p, nil
+	case p == nil:
+		return nil, newProblem(http.StatusUnauthorized, CodeUnauthorized, "missing bearer token")
+	}
+	return nil, newProblem(http.StatusForbidden, CodeForbidden, fmt.Sprintf("the credentials lack the %s scope", scope))
+}
+
+// bearerToken returns the token of an Authorization header value. A header
+// with another scheme yields a token no one holds, so the request is
+// refused rather than treated as anonymous.
+func bearerToken(h string) string {
+	if h == "" {
+		return ""
+	}
+	if token, ok := strings.CutPrefix(h, "Bearer "); ok {
+		return token
+	}
+	return "\x00"
+}
+
+// requireScope refuses requests whose caller lacks scope, and stores the
+// caller for the handler under principalKey and in the request context with
+// its tenant. Administrative routes need ScopeAdmin; with ADMIN_TOKEN unset
+// and no admin API keys they cannot be reached, so a deployment never
+// exposes them by accident. When query is
+// set the token may also come from ?access_token=, for browser WebSocket
+// and EventSource clients, which cannot send headers.
+func requireScope(cfg *Config, creds *Credentials, scope string, query bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+		if token == "" && query {
+			token = c.Query("access_token")
+		}
+		p, err := authorize(c.Request.Context(), cfg, creds, token, scope)
+		if err != nil {
+			problem := problemFor(err)
+			if problem.Status == http.StatusUnauthorized {
+				c.Header("WWW-Authenticate", "Bearer")
+			}
+			respondProblem(c, problem)
+			return
+		}
+		tenant, err := resolveTenant(cfg, p, c.GetHeader(tenantHeader))
+		if err != nil {
+			respondProblem(c, problemFor(err))
+			return
+		}
+		ctx := withTenant(c.Request.Context(), tenant)
+		if p != nil {
+			c.Set(principalKey, p)
+			ctx = context.WithValue(ctx, principalContextKey{}, p)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// require is requireScope for the server's own configuration and
+// credentials.
+func (api *API) require(scope string) gin.HandlerFunc {
+	return requireScope(api.Config, api.credentials(), scope, false)
+}
+
+// requireStream is require for streaming routes, which also take the token
+// from ?access_token=.
+func (api *API) requireStream(scope string) gin.HandlerFunc {
+	return requireScope(api.Config, api.credentials(), scope, true)
+}
+
+func (api *API) credentials() *Credentials {
+	return &Credentials{Keys: api.Keys, OIDC: api.OIDC, Roles: api.Roles}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	batchMaxImages        = 1000
+	batchQueueSize        = 64
+	defaultProcessWorkers = 4
+)
+
+type batchRequest struct {
+	IDs []string `json:"ids"`
+	// Spec holds /image/:id query parameters, e.g. {"width": 1024,
+	// "format": "png", "scale": "percentile:1,99"}.
+	Spec map[string]any `json:"spec"`
+}
+
+type batchTask struct {
+	tenant  string
+	usage   *usageTally
+	jobID   string
+	index   int
+	imageID string
+	opts    ProcessOptions
+	done    func(JobItem)
+}
+
+// BatchProcessor runs the per-image work of batch processing jobs on a fixed
+// pool of workers shared by every job, so a large batch cannot starve the
+// server. PROCESS_WORKERS sets the pool size (default 4).
+type BatchProcessor struct {
+	api     *API
+	workers int
+	tasks   chan batchTask
+}
+
+func newBatchProcessor(api *API, workers int) *BatchProcessor {
+	return &BatchProcessor{api: api, workers: workers, tasks: make(chan batchTask, batchQueueSize)}
+}
+
+func (p *BatchProcessor) Start(ctx context.Context) {
+	ctx = waitForCapacity(ctx)
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-p.tasks:
+					t.done(p.api.processBatchImage(withUsageTally(withTenant(ctx, t.tenant), t.usage), t))
+				}
+			}
+		}()
+	}
+}
+
+func batchOutputPrefix(jobID string) string {
+	return fmt.Sprintf("processed/%s/", jobID)
+}
+
+// postProcessJob starts a job applying one processing spec to every listed
+// image. Outputs are written to processed/<job>/<id>.<ext> and listed in a
+// manifest.json alongside them, which is the job's output.
+func (api *API) postProcessJob(c *gin.Context) {
+	var req batchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"ids\": [...], \"spec\": {...}}.")
+		return
+	}
+	job, err := api.submitProcessJob(c.Request.Context(), req)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	c.JSON(http.StatusAccepted, newJobAccepted(job))
+}
+
+// submitProcessJob validates req and queues its process job.
+func (api *API) submitProcessJob(ctx context.Context, req batchRequest) (Job, error) {
+	if len(req.IDs) == 0 || len(req.IDs) > batchMaxImages {
+		return Job{}, newProblem(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Between 1 and %d image IDs are required.", batchMaxImages))
+	}
+	if err := api.Config.Limits.checkQuery(batchSpecValues(req.Spec)); err != nil {
+		return Job{}, err.problem()
+	}
+	opts, err := parseBatchSpec(req.Spec, api.Overlay)
+	if err != nil {
+		return Job{}, newProblem(http.StatusBadRequest, CodeInvalidBody, err.Error())
+	}
+	if err := api.gateFormat(ctx, &opts, ""); err != nil {
+		return Job{}, err
+	}
+
+	job, err := api.Jobs.Submit(ctx, "process", req, func(j *Job) {
+		j.Items = make([]JobItem, len(req.IDs))
+		for i, id := range req.IDs {
+			j.Items[i] = JobItem{ID: id, Status: JobQueued}
+		}
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to submit process job", "err", err)
+		return Job{}, newProblem(http.StatusInternalServerError, CodeInternal, "failed to start job")
+	}
+	return job, nil
+}
+
+// batchSpecValues is a batch job's spec as the query parameters it stands for.
+func batchSpecValues(spec map[string]any) url.Values {
+	q := url.Values{}
+	for k, v := range spec {
+		q.Set(k, fmt.Sprint(v))
+	}
+	return q
+}
+
+func parseBatchSpec(spec map[string]any, overlay *OverlaySpec) (ProcessOptions, error) {
+	opts, err := parseProcessValues(batchSpecValues(spec), "", overlay)
+	if err != nil {
+		return opts, err
+	}
+	if opts.Format == nil {
+		return opts, errors.New("Invalid 'spec'. It must include at least one processing parameter.")
+	}
+	return opts, nil
+}
+
+// runProcessJob feeds the job's images to the shared pool and, once all have
+// finished, writes the manifest. Images that succeeded on an earlier attempt
+// are not processed again. The attempt fails only if no image succeeded.
+func (api *API) runProcessJob(ctx context.Context, job Job) (string, string, error) {
+	bucketName := api.Config.ImagesBucket
+	var req batchRequest
+	if err := json.Unmarshal(job.Params, &req); err != nil {
+		return "", "", permanent(fmt.Errorf("decode params: %w", err))
+	}
+	opts, err := parseBatchSpec(req.Spec, api.Overlay)
+	if err != nil {
+		return "", "", permanent(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	finished := 0
+	for _, item := range job.Items {
+		if item.Status == JobSucceeded {
+			finished++
+		}
+	}
+	api.Jobs.SetProgress(job.ID, 0.99*float64(finished)/float64(len(job.Items)))
+
+	for i, item := range job.Items {
+		if item.Status == JobSucceeded {
+			continue
+		}
+		wg.Add(1)
+		api.Batch.tasks <- batchTask{tenant: tenantFrom(ctx), usage: usageTallyFrom(ctx), jobID: job.ID, index: i, imageID: item.ID, opts: opts, done: func(item JobItem) {
+			defer wg.Done()
+			mu.Lock()
+			finished++
+			progress := float64(finished) / float64(len(job.Items))
+			mu.Unlock()
+			api.Jobs.Update(job.ID, func(j *Job) {
+				j.Items[i] = item
+				j.Progress = 0.99 * progress
+			})
+		}}
+	}
+	wg.Wait()
+
+	job, err = api.Jobs.Get(ctx, job.ID)
+	if err != nil {
+		return "", "", err
+	}
+	succeeded := 0
+	for _, item := range job.Items {
+		if item.Status == JobSucceeded {
+			succeeded++
+		}
+	}
+
+	manifest, err := json.MarshalIndent(gin.H{"job_id": job.ID, "items": job.Items}, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("encode manifest: %w", err)
+	}
+	key := batchOutputPrefix(job.ID) + "manifest.json"
+	_, err = api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(manifest),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "s3 PutObject failed", "key", key, "err", err)
+		return "", "", fmt.Errorf("store manifest: %w", err)
+	}
+
+	if succeeded == 0 {
+		return "", "", fmt.Errorf("all %d images failed", len(job.Items))
+	}
+	return key, "application/json", nil
+}
+
+// processBatchImage renders one image of a batch and stores the result.
+func (api *API) processBatchImage(ctx context.Context, t batchTask) JobItem {
+	bucketName := api.Config.ImagesBucket
+	item := JobItem{ID: t.imageID, Status: JobFailed}
+	api.Jobs.Update(t.jobID, func(j *Job) { j.Items[t.index].Status = JobRunning })
+
+	opts := t.opts
+	if opts.Annotate {
+		anns, err := api.loadAnnotations(ctx, bucketName, t.imageID)
+		if err != nil {
+			item.Error = "failed to retrieve annotations"
+			slog.ErrorContext(ctx, "batch image failed to load annotations", "job", t.jobID, "image", t.imageID, "err", err)
+			return item
+		}
+		opts.Annotations = anns
+	}
+	if err := api.loadObjectOverlay(ctx, t.imageID, &opts); err != nil {
+		item.Error = "failed to retrieve detections"
+		slog.ErrorContext(ctx, "batch image failed to load detections", "job", t.jobID, "image", t.imageID, "err", err)
+		return item
+	}
+
+	key := imageKey(t.imageID)
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+		Range:  firstPart(),
+	}, s3Accelerate(bucketName)...)
+	if err != nil {
+		item.Error = "object not found"
+		slog.ErrorContext(ctx, "batch image failed", "job", t.jobID, "image", t.imageID, "err", err)
+		return item
+	}
+	defer out.Body.Close()
+
+	img, geo, release, err := api.renderProcessed(ctx, bucketName, key, out, opts)
+	if err != nil {
+		item.Error = "failed to process image"
+		if errors.Is(err, errCropOutside) {
+			item.Error = err.Error()
+		}
+		slog.ErrorContext(ctx, "batch image failed", "job", t.jobID, "image", t.imageID, "err", err)
+		return item
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	err = encodeProcessed(buf, img, opts, geo)
+	release()
+	if err != nil {
+		item.Error = "failed to encode image"
+		slog.ErrorContext(ctx, "batch image failed to encode", "job", t.jobID, "image", t.imageID, "err", err)
+		return item
+	}
+
+	outKey := batchOutputPrefix(t.jobID) + t.imageID + "." + opts.Format.Extension
+	_, err = api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(outKey),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String(opts.Format.ContentType),
+	})
+	if err != nil {
+		item.Error = "failed to store output"
+		slog.ErrorContext(ctx, "s3 PutObject failed", "key", outKey, "err", err)
+		return item
+	}
+
+	item.Status = JobSucceeded
+	item.Output = outKey
+	return item
+}
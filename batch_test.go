@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestParseBatchSpec(t *testing.T) {
+	opts, err := parseBatchSpec(map[string]any{"width": 64, "format": "png"}, nil)
+	if err != nil || opts.Width != 64 || opts.Format == nil || opts.Format.Name != "png" {
+		t.Errorf("spec parsed to %+v, %v", opts, err)
+	}
+	for _, spec := range []map[string]any{nil, {"format": "bmp"}, {"scale": "gamma"}} {
+		if _, err := parseBatchSpec(spec, nil); err == nil {
+			t.Errorf("spec %v accepted", spec)
+		}
+	}
+}
+
+func TestRunProcessJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, store, imageKey("a"), frame)
+	api := &API{
+		Config: &Config{ImagesBucket: "bucket", Limits: RequestLimits{
+			MaxDimension: defaultMaxOutputDimension, MaxCropPixels: defaultMaxImagePixels,
+			MaxBodyBytes: defaultMaxBodyBytes, MaxOps: defaultMaxRequestOps,
+		}},
+		S3:      store,
+		Limiter: newProcessLimiter(0, defaultQueueTimeout),
+		Jobs:    newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers),
+	}
+	api.Batch = newBatchProcessor(api, defaultProcessWorkers)
+	api.Batch.Start(ctx)
+
+	for _, req := range []batchRequest{
+		{Spec: map[string]any{"width": 64}},
+		{IDs: []string{"a"}},
+		{IDs: []string{"a"}, Spec: map[string]any{"width": "wide", "format": "bmp"}},
+	} {
+		if _, err := api.submitProcessJob(ctx, req); problemFor(err).Status != http.StatusBadRequest {
+			t.Errorf("submitted %+v: %v", req, err)
+		}
+	}
+
+	job, err := api.submitProcessJob(ctx, batchRequest{IDs: []string{"a", "gone"}, Spec: map[string]any{"width": 64, "format": "png"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, contentType, err := api.runProcessJob(ctx, job)
+	if err != nil || key != batchOutputPrefix(job.ID)+"manifest.json" || contentType != "application/json" {
+		t.Fatalf("runProcessJob = %s, %s, %v", key, contentType, err)
+	}
+	job, err = api.Jobs.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, gone := job.Items[0], job.Items[1]
+	if a.Status != JobSucceeded || a.Output != batchOutputPrefix(job.ID)+"a.png" || gone.Status != JobFailed || gone.Error != "object not found" {
+		t.Fatalf("items %+v", job.Items)
+	}
+	out, err := store.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(a.Output)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(out.Body)
+	if cfg, format, err := image.DecodeConfig(&buf); err != nil || format != "png" || cfg.Width != 64 {
+		t.Errorf("output a %dx%d %s: %v", cfg.Width, cfg.Height, format, err)
+	}
+
+	// A job with nothing to show for it fails, so it is retried.
+	job, err = api.submitProcessJob(ctx, batchRequest{IDs: []string{"gone"}, Spec: map[string]any{"width": 64}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := api.runProcessJob(ctx, job); err == nil || isPermanent(err) {
+		t.Errorf("all failed: %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a small key/value store with per-key expiry, shared by whatever
+// needs to cache DynamoDB reads. It is best effort: backend failures are
+// logged and reported as misses, never as request errors.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores value under key for ttl, or until deleted if ttl is 0.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+	Delete(ctx context.Context, keys ...string)
+	// DeletePrefix deletes every key starting with prefix and returns how
+	// many there were. Unlike the rest it reports failures, for the
+	// operators who asked for it.
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// newCache returns a Redis-backed Cache when raw, REDIS_URL, is set, so that every
+// instance shares one cache, and a per-process one otherwise.
+// REDIS_URL takes the form redis://[user:password@]host:port[/db], or
+// rediss:// for TLS as ElastiCache in-transit encryption requires.
+func newCache(raw string) (Cache, error) {
+	if raw == "" {
+		return newMemoryKV(), nil
+	}
+	r, err := newRedisCache(raw)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("caching in redis", "addr", r.addr)
+	return r, nil
+}
+
+// memoryKVMaxEntries bounds the per-process cache. Expired entries are
+// dropped first when it fills, then arbitrary ones.
+const memoryKVMaxEntries = 10000
+
+type kvEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// memoryKV is the in-process Cache.
+type memoryKV struct {
+	mu    sync.Mutex
+	items map[string]kvEntry
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{items: make(map[string]kvEntry)}
+}
+
+func (m *memoryKV) Get(_ context.Context, key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(m.items, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (m *memoryKV) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.items[key]; !ok && len(m.items) >= memoryKVMaxEntries {
+		m.evict()
+	}
+	e := kvEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	m.items[key] = e
+}
+
+func (m *memoryKV) Delete(_ context.Context, keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		delete(m.items, k)
+	}
+}
+
+func (m *memoryKV) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for k := range m.items {
+		if strings.HasPrefix(k, prefix) {
+			delete(m.items, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// evict makes room for one entry. The caller holds m.mu.
+func (m *memoryKV) evict() {
+	now := time.Now()
+	for k, e := range m.items {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(m.items, k)
+		}
+	}
+	for k := range m.items {
+		if len(m.items) < memoryKVMaxEntries {
+			break
+		}
+		delete(m.items, k)
+	}
+}
+
+const (
+	redisTimeout  = 500 * time.Millisecond
+	redisMaxIdle  = 8
+	redisDialWait = time.Second
+	// redisScanCount is the keys SCAN is asked to look at per call.
+	redisScanCount = 1000
+)
+
+// redisCache is a Cache speaking the Redis protocol directly. It needs only
+// GET, SET, DEL and SCAN, so a client library would add little.
+type redisCache struct {
+	addr     string
+	user     string
+	password string
+	db       int
+	tls      *tls.Config
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func newRedisCache(raw string) (*redisCache, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	r := &redisCache{addr: u.Host, idle: make(chan *redisConn, redisMaxIdle)}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		r.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return r, nil
+}
+
+func (r *redisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	reply, err := r.do(ctx, "GET", key)
+	if err != nil {
+		slog.ErrorContext(ctx, "redis GET failed", "key", key, "err", err)
+		return nil, false
+	}
+	value, ok := reply.([]byte)
+	return value, ok
+}
+
+func (r *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	if _, err := r.do(ctx, args...); err != nil {
+		slog.ErrorContext(ctx, "redis SET failed", "key", key, "err", err)
+	}
+}
+
+func (r *redisCache) Delete(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if _, err := r.do(ctx, append([]string{"DEL"}, keys...)...); err != nil {
+		slog.ErrorContext(ctx, "redis DEL failed", "err", err)
+	}
+}
+
+// DeletePrefix walks the keyspace with SCAN, which unlike KEYS does not
+// block the server, deleting each batch of matches as it goes.
+func (r *redisCache) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+	cursor, n := "0", 0
+	for {
+		reply, err := r.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return n, fmt.Errorf("redis SCAN: %w", err)
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return n, fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]any)
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				if b, ok := k.([]byte); ok {
+					args = append(args, string(b))
+				}
+			}
+			reply, err := r.do(ctx, args...)
+			if err != nil {
+				return n, fmt.Errorf("redis DEL: %w", err)
+			}
+			deleted, _ := reply.(int64)
+			n += int(deleted)
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return n, nil
+		}
+	}
+}
+
+// redisGlobEscaper quotes the characters SCAN MATCH patterns treat
+// specially.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// do runs one command on a pooled connection. Connections that fail at the
+// network level are discarded rather than returned to the pool.
+func (r *redisCache) do(ctx context.Context, args ...string) (any, error) {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(ctx, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case r.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+func (r *redisCache) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: redisDialWait}
+	nc, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	if r.tls != nil {
+		nc = tls.Client(nc, r.tls)
+	}
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if r.password != "" {
+		auth := []string{"AUTH", r.password}
+		if r.user != "" {
+			auth = []string{"AUTH", r.user, r.password}
+		}
+		if _, err := c.do(ctx, auth...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(r.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	deadline := time.Now().Add(redisTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply parses one RESP reply. Bulk strings come back as []byte, a nil
+// bulk string as nil, and integers as int64.
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
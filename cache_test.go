@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryKV(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryKV()
+	m.Set(ctx, "a", []byte("1"), 0)
+	m.Set(ctx, "b", []byte("2"), time.Millisecond)
+	if v, ok := m.Get(ctx, "a"); !ok || string(v) != "1" {
+		t.Errorf("Get(a) = %q, %v", v, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := m.Get(ctx, "b"); ok {
+		t.Error("expired entry served")
+	}
+	m.Set(ctx, "p:1", nil, 0)
+	m.Set(ctx, "p:2", nil, 0)
+	if n, err := m.DeletePrefix(ctx, "p:"); n != 2 || err != nil {
+		t.Errorf("DeletePrefix(p:) = %d, %v", n, err)
+	}
+	m.Delete(ctx, "a", "missing")
+	if _, ok := m.Get(ctx, "a"); ok {
+		t.Error("deleted entry served")
+	}
+
+	for i := range memoryKVMaxEntries + 10 {
+		m.Set(ctx, strconv.Itoa(i), nil, 0)
+	}
+	if n := len(m.items); n > memoryKVMaxEntries {
+		t.Errorf("holds %d entries, limit %d", n, memoryKVMaxEntries)
+	}
+}
+
+func TestNewRedisCache(t *testing.T) {
+	tests := []struct {
+		url                  string
+		addr, user, password string
+		db                   int
+		tls, wantErr         bool
+	}{
+		{url: "redis://localhost", addr: "localhost:6379"},
+		{url: "redis://:pw@cache:6380/2", addr: "cache:6380", password: "pw", db: 2},
+		{url: "rediss://app:pw@cache.example.com", addr: "cache.example.com:6379", user: "app", password: "pw", tls: true},
+		{url: "http://cache:6379", wantErr: true},
+		{url: "redis://cache/db1", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := newRedisCache(tt.url)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("newRedisCache(%q) succeeded", tt.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newRedisCache(%q): %v", tt.url, err)
+			continue
+		}
+		if r.addr != tt.addr || r.user != tt.user || r.password != tt.password || r.db != tt.db || (r.tls != nil) != tt.tls {
+			t.Errorf("newRedisCache(%q) = addr %q user %q password %q db %d tls %v", tt.url, r.addr, r.user, r.password, r.db, r.tls != nil)
+		}
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    any
+		wantErr bool
+	}{
+		{in: "+OK\r\n", want: "OK"},
+		{in: ":42\r\n", want: int64(42)},
+		{in: "$5\r\nhello\r\n", want: []byte("hello")},
+		{in: "$0\r\n\r\n", want: []byte{}},
+		{in: "$-1\r\n", want: nil},
+		{in: "$7\r\nwith\r\nx\r\n", want: []byte("with\r\nx")},
+		{in: "*2\r\n$1\r\na\r\n:1\r\n", want: []any{[]byte("a"), int64(1)}},
+		{in: "-ERR wrong type\r\n", wantErr: true},
+		{in: "$5\r\nhi\r\n", wantErr: true},
+		{in: "?\r\n", wantErr: true},
+		{in: "\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		c := &redisConn{r: bufio.NewReader(strings.NewReader(tt.in))}
+		got, err := c.readReply()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readReply(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readReply(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// fakeRedis answers AUTH, SELECT, GET, SET, DEL and SCAN over RESP and records
+// every command it receives.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+	mu       sync.Mutex
+	data     map[string]string
+	commands [][]string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, data: map[string]string{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			authed = args[len(args)-1] == f.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "GET":
+			if v, ok := f.data[args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case args[0] == "SET":
+			f.data[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case args[0] == "DEL":
+			deleted := 0
+			for _, k := range args[1:] {
+				if _, ok := f.data[k]; ok {
+					delete(f.data, k)
+					deleted++
+				}
+			}
+			reply = fmt.Sprintf(":%d\r\n", deleted)
+		case args[0] == "SCAN":
+			// One key per call, to exercise the cursor, which is the
+			// last key returned. Only trailing-* patterns are
+			// understood.
+			prefix := strings.ReplaceAll(strings.TrimSuffix(args[3], "*"), `\`, "")
+			after, _ := strings.CutPrefix(args[1], "after:")
+			var keys []string
+			for k := range f.data {
+				if strings.HasPrefix(k, prefix) && (args[1] == "0" || k > after) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			next, page := "0", ""
+			if len(keys) > 0 {
+				page = fmt.Sprintf("$%d\r\n%s\r\n", len(keys[0]), keys[0])
+				next = "after:" + keys[0]
+			}
+			reply = fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n%s", len(next), next, strings.Count(page, "$"), page)
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	f := newFakeRedis(t, "pw")
+	r, err := newRedisCache("redis://:pw@" + f.ln.Addr().String() + "/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, ok := r.Get(ctx, "k"); ok {
+		t.Error("hit on an empty server")
+	}
+	value := "binary\r\n\x00value"
+	r.Set(ctx, "k", []byte(value), 1500*time.Millisecond)
+	r.Set(ctx, "forever", []byte("v"), 0)
+	if got, ok := r.Get(ctx, "k"); !ok || string(got) != value {
+		t.Errorf("Get(k) = %q, %v", got, ok)
+	}
+	r.Delete(ctx, "k", "forever")
+	if _, ok := r.Get(ctx, "k"); ok {
+		t.Error("deleted key served")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	want := [][]string{
+		{"AUTH", "pw"},
+		{"SELECT", "3"},
+		{"GET", "k"},
+		{"SET", "k", value, "PX", "1500"},
+		{"SET", "forever", "v"},
+		{"GET", "k"},
+		{"DEL", "k", "forever"},
+		{"GET", "k"},
+	}
+	if !reflect.DeepEqual(f.commands, want) {
+		t.Errorf("commands = %q, want %q (one pooled connection)", f.commands, want)
+	}
+}
+
+func TestRedisCacheFailuresAreMisses(t *testing.T) {
+	f := newFakeRedis(t, "pw")
+	r, err := newRedisCache("redis://:wrong@" + f.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	r.Set(ctx, "k", []byte("v"), 0)
+	if _, ok := r.Get(ctx, "k"); ok {
+		t.Error("unauthenticated Get reported a hit")
+	}
+
+	f.ln.Close()
+	closed, err := newRedisCache("redis://" + f.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := closed.Get(ctx, "k"); ok {
+		t.Error("Get against a closed server reported a hit")
+	}
+}
+
+func TestRedisCacheDeletePrefix(t *testing.T) {
+	f := newFakeRedis(t, "")
+	r, err := newRedisCache("redis://" + f.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, k := range []string{"sat:mission:m1", "sat:mission:m2", "sat:missions:gen", "other"} {
+		r.Set(ctx, k, []byte("v"), 0)
+	}
+	if n, err := r.DeletePrefix(ctx, "sat:mission"); n != 3 || err != nil {
+		t.Errorf("DeletePrefix = %d, %v", n, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.data) != 1 || f.data["other"] != "v" {
+		t.Errorf("left %v", f.data)
+	}
+}
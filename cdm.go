@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Conjunctions are kept until conjunctionRetention after their TCA, or
+	// after they are received if that is later. In CONJUNCTIONS_TABLE the
+	// expires attribute should be its TTL.
+	conjunctionRetention = 30 * 24 * time.Hour
+	// With CONJUNCTIONS_TABLE set, conjunctions are reread every
+	// conjunctionRefresh, so ones received by another instance appear
+	// within it.
+	conjunctionRefresh = time.Minute
+	maxConjunctions    = 100000
+
+	defaultCDMProposalPc = 1e-4
+	// Proposed missions collect from proposalWindow before TCA to as long
+	// after it.
+	proposalWindow = 10 * time.Minute
+)
+
+var errConjunctionNotFound = errors.New("conjunction not found")
+
+// ConjunctionObject is one of the two objects of a conjunction.
+type ConjunctionObject struct {
+	// Designator is the object's number in CatalogName, the NORAD catalog
+	// for SATCAT.
+	Designator              string `dynamodbav:"designator" json:"designator"`
+	CatalogName             string `dynamodbav:"catalog_name,omitempty" json:"catalog_name,omitempty"`
+	Name                    string `dynamodbav:"name,omitempty" json:"name,omitempty"`
+	InternationalDesignator string `dynamodbav:"international_designator,omitempty" json:"international_designator,omitempty"`
+	// Satellite is the catalog entry with this NORAD ID, if there is one.
+	Satellite string `dynamodbav:"satellite,omitempty" json:"satellite,omitempty"`
+	// Position and Velocity are at TCA, in km and km/s in RefFrame.
+	RefFrame string     `dynamodbav:"ref_frame,omitempty" json:"ref_frame,omitempty"`
+	Position [3]float64 `dynamodbav:"position" json:"position"`
+	Velocity [3]float64 `dynamodbav:"velocity" json:"velocity"`
+}
+
+// Conjunction is a close approach between two objects, read from a CCSDS
+// Conjunction Data Message. A later message with the same ID replaces it.
+type Conjunction struct {
+	ID         string    `dynamodbav:"id" json:"id"`
+	Originator string    `dynamodbav:"originator,omitempty" json:"originator,omitempty"`
+	Created    time.Time `dynamodbav:"created" json:"created"`
+	TCA        time.Time `dynamodbav:"tca" json:"tca"`
+	// MissDistanceM is in metres and RelativeSpeedMS in m/s.
+	MissDistanceM              float64              `dynamodbav:"miss_distance_m" json:"miss_distance_m"`
+	RelativeSpeedMS            float64              `dynamodbav:"relative_speed_ms,omitempty" json:"relative_speed_ms,omitempty"`
+	CollisionProbability       float64              `dynamodbav:"collision_probability" json:"collision_probability"`
+	CollisionProbabilityMethod string               `dynamodbav:"collision_probability_method,omitempty" json:"collision_probability_method,omitempty"`
+	Objects                    [2]ConjunctionObject `dynamodbav:"objects" json:"objects"`
+	Received                   int64                `dynamodbav:"received" json:"received"`
+	// Expires is the unix time the conjunction is dropped.
+	Expires int64 `dynamodbav:"expires" json:"-"`
+}
+
+// involves reports whether the satellite is either of the objects.
+func (c *Conjunction) involves(satellite string) bool {
+	return c.Objects[0].Satellite == satellite || c.Objects[1].Satellite == satellite
+}
+
+// cdmField is one keyword and its value, in the order the message gives
+// them.
+type cdmField struct {
+	key, value string
+}
+
+// kvnFields reads a CDM in keyword = value notation. Units in square
+// brackets after a value are dropped: the CDM fixes each keyword's unit.
+func kvnFields(data []byte) ([]cdmField, error) {
+	var fields []cdmField
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "COMMENT") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d is not KEYWORD = value", n)
+		}
+		value = strings.TrimSpace(value)
+		if i := strings.LastIndexByte(value, '['); i > 0 && strings.HasSuffix(value, "]") {
+			value = strings.TrimSpace(value[:i])
+		}
+		fields = append(fields, cdmField{strings.ToUpper(strings.TrimSpace(key)), value})
+	}
+	return fields, scanner.Err()
+}
+
+// xmlFields reads a CDM in XML, taking each element without children as a
+// keyword. The elements' nesting carries nothing that the keywords and
+// their order do not.
+func xmlFields(data []byte) ([]cdmField, error) {
+	var fields []cdmField
+	type open struct {
+		name   string
+		text   strings.Builder
+		parent bool
+	}
+	var stack []*open
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) > 0 {
+				stack[len(stack)-1].parent = true
+			}
+			stack = append(stack, &open{name: t.Name.Local})
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !e.parent {
+				fields = append(fields, cdmField{strings.ToUpper(e.name), strings.TrimSpace(e.text.String())})
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("the document has no elements")
+	}
+	return fields, nil
+}
+
+// parseCCSDSTime reads a CCSDS UTC time, as a calendar date or a day of
+// the year.
+func parseCCSDSTime(v string) (time.Time, error) {
+	v = strings.TrimSuffix(v, "Z")
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-002T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a CCSDS time such as 2010-03-13T22:37:52.618", v)
+}
+
+// parseCDM builds a conjunction from a message's fields, ignoring keywords
+// it does not keep.
+func parseCDM(fields []cdmField) (Conjunction, error) {
+	var c Conjunction
+	obj := -1
+	seen := map[string]bool{}
+	axis := map[string]int{"X": 0, "Y": 1, "Z": 2, "X_DOT": 0, "Y_DOT": 1, "Z_DOT": 2}
+	for _, f := range fields {
+		var err error
+		number := func(dst *float64) {
+			if *dst, err = strconv.ParseFloat(f.value, 64); err != nil {
+				err = fmt.Errorf("%s %q is not a number", f.key, f.value)
+			}
+		}
+		switch f.key {
+		case "MESSAGE_ID":
+			c.ID = f.value
+		case "ORIGINATOR":
+			c.Originator = f.value
+		case "CREATION_DATE":
+			c.Created, err = parseCCSDSTime(f.value)
+		case "TCA":
+			c.TCA, err = parseCCSDSTime(f.value)
+		case "MISS_DISTANCE":
+			number(&c.MissDistanceM)
+		case "RELATIVE_SPEED":
+			number(&c.RelativeSpeedMS)
+		case "COLLISION_PROBABILITY":
+			number(&c.CollisionProbability)
+			if err == nil && (c.CollisionProbability < 0 || c.CollisionProbability > 1) {
+				err = fmt.Errorf("COLLISION_PROBABILITY %s is not from 0 to 1", f.value)
+			}
+		case "COLLISION_PROBABILITY_METHOD":
+			c.CollisionProbabilityMethod = f.value
+		case "OBJECT":
+			switch f.value {
+			case "OBJECT1":
+				obj = 0
+			case "OBJECT2":
+				obj = 1
+			default:
+				err = fmt.Errorf("OBJECT %q is not OBJECT1 or OBJECT2", f.value)
+			}
+		}
+		if err != nil {
+			return c, err
+		}
+		seen[f.key] = true
+		if obj < 0 {
+			continue
+		}
+		o := &c.Objects[obj]
+		switch f.key {
+		case "OBJECT_DESIGNATOR":
+			o.Designator = f.value
+		case "CATALOG_NAME":
+			o.CatalogName = f.value
+		case "OBJECT_NAME":
+			o.Name = f.value
+		case "INTERNATIONAL_DESIGNATOR":
+			o.InternationalDesignator = f.value
+		case "REF_FRAME":
+			o.RefFrame = f.value
+		case "X", "Y", "Z":
+			number(&o.Position[axis[f.key]])
+		case "X_DOT", "Y_DOT", "Z_DOT":
+			number(&o.Velocity[axis[f.key]])
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+	for _, key := range []string{"MESSAGE_ID", "CREATION_DATE", "TCA", "MISS_DISTANCE"} {
+		if !seen[key] {
+			return c, fmt.Errorf("%s is missing", key)
+		}
+	}
+	for i, o := range c.Objects {
+		if o.Designator == "" {
+			return c, fmt.Errorf("OBJECT%d has no OBJECT_DESIGNATOR", i+1)
+		}
+	}
+	return c, nil
+}
+
+// readCDM parses a message in XML when the body is XML, and in keyword =
+// value notation otherwise.
+func readCDM(contentType string, data []byte) (Conjunction, error) {
+	var fields []cdmField
+	var err error
+	if strings.Contains(contentType, "xml") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		fields, err = xmlFields(data)
+	} else {
+		fields, err = kvnFields(data)
+	}
+	if err != nil {
+		return Conjunction{}, err
+	}
+	return parseCDM(fields)
+}
+
+// ConjunctionStore holds the conjunctions received. When
+// CONJUNCTIONS_TABLE is set they are saved to DynamoDB and shared by every
+// instance; without it they live only in this process. With MULTI_TENANT
+// each tenant's are keyed by tenantRecordID, like its missions, and a
+// context acting for a tenant sees only those, under their own IDs.
+type ConjunctionStore struct {
+	mu           sync.RWMutex
+	conjunctions map[string]*Conjunction
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newConjunctionStore(db *dynamodb.Client, table string) *ConjunctionStore {
+	s := &ConjunctionStore{conjunctions: make(map[string]*Conjunction), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the conjunctions and rereads them until ctx is done.
+func (s *ConjunctionStore) Start(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load conjunctions", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(conjunctionRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload conjunctions", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Put creates or replaces the conjunction c.ID of ctx's tenant, reporting
+// whether it is new. Conjunctions past their retention are dropped first.
+func (s *ConjunctionStore) Put(ctx context.Context, c Conjunction) (Conjunction, bool, error) {
+	c.Received = time.Now().Unix()
+	c.Expires = max(c.TCA.Unix(), c.Received) + int64(conjunctionRetention.Seconds())
+	stored := c
+	stored.ID = tenantRecordID(ctx, c.ID)
+	s.mu.Lock()
+	s.expire(c.Received)
+	_, exists := s.conjunctions[stored.ID]
+	n := len(s.conjunctions)
+	s.mu.Unlock()
+	if !exists && n >= maxConjunctions {
+		return Conjunction{}, false, newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("At most %d conjunctions are kept.", maxConjunctions))
+	}
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(stored)
+		if err != nil {
+			return Conjunction{}, false, fmt.Errorf("marshal conjunction: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return Conjunction{}, false, err
+		}
+	}
+	s.mu.Lock()
+	s.conjunctions[stored.ID] = &stored
+	s.mu.Unlock()
+	return c, !exists, nil
+}
+
+// expire drops the conjunctions that expired before now. s.mu must be held.
+func (s *ConjunctionStore) expire(now int64) {
+	for id, c := range s.conjunctions {
+		if c.Expires < now {
+			delete(s.conjunctions, id)
+		}
+	}
+}
+
+// Get returns the conjunction id of ctx's tenant.
+func (s *ConjunctionStore) Get(ctx context.Context, id string) (Conjunction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.conjunctions[tenantRecordID(ctx, id)]
+	if !ok || c.Expires < time.Now().Unix() {
+		return Conjunction{}, errConjunctionNotFound
+	}
+	conj := *c
+	conj.ID = id
+	return conj, nil
+}
+
+// List returns ctx's tenant's unexpired conjunctions involving satellite,
+// or every one when it is empty, with a collision probability of at least
+// minPc, in TCA order.
+func (s *ConjunctionStore) List(ctx context.Context, satellite string, minPc float64) []Conjunction {
+	now := time.Now().Unix()
+	scoped := tenantFrom(ctx) != ""
+	prefix := tenantRecordID(ctx, "")
+	s.mu.RLock()
+	list := []Conjunction{}
+	for key, c := range s.conjunctions {
+		id, ok := strings.CutPrefix(key, prefix)
+		if scoped && !ok {
+			continue
+		}
+		if c.Expires >= now && (satellite == "" || c.involves(satellite)) && c.CollisionProbability >= minPc {
+			conj := *c
+			conj.ID = id
+			list = append(list, conj)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].TCA.Equal(list[j].TCA) {
+			return list[i].TCA.Before(list[j].TCA)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// refresh replaces the conjunctions with CONJUNCTIONS_TABLE's.
+func (s *ConjunctionStore) refresh(ctx context.Context) error {
+	conjunctions := make(map[string]*Conjunction)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []Conjunction
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for i := range items {
+			conjunctions[items[i].ID] = &items[i]
+		}
+	}
+	s.mu.Lock()
+	s.conjunctions = conjunctions
+	s.expire(time.Now().Unix())
+	s.mu.Unlock()
+	return nil
+}
+
+// proposeMission is a characterization mission for a conjunction: the
+// first object's satellite imaging the second's around TCA. It reports
+// false unless both are in the catalog.
+func proposeMission(c Conjunction) (Mission, bool) {
+	observer, target := c.Objects[0], c.Objects[1]
+	if observer.Satellite == "" || target.Satellite == "" {
+		return Mission{}, false
+	}
+	name := func(o ConjunctionObject) string { return cmp.Or(o.Name, o.Designator) }
+	tca := c.TCA.Unix()
+	return Mission{
+		ID:                    "cdm-" + c.ID,
+		Name:                  fmt.Sprintf("Characterize %s near %s", name(target), name(observer)),
+		Status:                "proposed",
+		Priority:              1,
+		TargetSatelliteID:     target.Satellite,
+		ObserverSatelliteID:   observer.Satellite,
+		TCA:                   tca,
+		MinRangeKM:            c.MissDistanceM / 1000,
+		CollectionWindowStart: tca - int64(proposalWindow.Seconds()),
+		CollectionWindowEnd:   tca + int64(proposalWindow.Seconds()),
+		CollectionType:        "characterization",
+		PointingTarget:        target.Satellite,
+		ImageIDs:              []string{},
+	}, true
+}
+
+// CDMResponse is the body of POST /cdm.
+type CDMResponse struct {
+	Conjunction Conjunction `json:"conjunction"`
+	// ProposedMissions are characterization missions for the conjunction,
+	// for the writer of MISSION_TABLE to create. This server does not store
+	// them.
+	ProposedMissions []Mission `json:"proposed_missions,omitempty"`
+}
+
+// postCDM reads a CCSDS Conjunction Data Message, links its objects to the
+// catalog by NORAD ID and stores the conjunction. With ?propose=true, a
+// conjunction at least as probable as CDM_PROPOSAL_PC comes back with a
+// proposed characterization mission, its feasibility scored at TCA.
+func (api *API) postCDM(c *gin.Context) {
+	ctx := c.Request.Context()
+	propose, err := strconv.ParseBool(c.DefaultQuery("propose", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'propose' parameter. Must be true or false.")
+		return
+	}
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "failed to read request body")
+		return
+	}
+	conj, err := readCDM(c.ContentType(), data)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid CDM: "+err.Error()+".")
+		return
+	}
+	for i := range conj.Objects {
+		o := &conj.Objects[i]
+		if o.CatalogName != "" && o.CatalogName != "SATCAT" {
+			continue
+		}
+		if norad, err := strconv.Atoi(o.Designator); err == nil {
+			if sat, ok := api.Satellites.ByNorad(norad); ok {
+				o.Satellite = sat.ID
+			}
+		}
+	}
+
+	conj, created, err := api.Conjunctions.Put(ctx, conj)
+	if err != nil {
+		var p *Problem
+		if !errors.As(err, &p) {
+			slog.ErrorContext(ctx, "failed to save conjunction", "err", err)
+		}
+		respondProblem(c, problemFor(err))
+		return
+	}
+	cdmReceived.WithLabelValues(strconv.FormatBool(conj.Objects[0].Satellite != "" || conj.Objects[1].Satellite != "")).Inc()
+	slog.InfoContext(ctx, "saved conjunction", "id", conj.ID, "tca", conj.TCA, "pc", conj.CollisionProbability, "created", created)
+
+	resp := CDMResponse{Conjunction: conj}
+	if propose && conj.CollisionProbability >= api.CDMProposalPc {
+		if m, ok := proposeMission(conj); ok {
+			k := AccessConstraints{MaxRangeKM: defaultAccessMaxRangeKM, MinSunAngleDeg: defaultAccessMinSunAngle, TargetSunlit: true}
+			if pair, err := api.orbits(ctx, m.TargetSatelliteID, m.ObserverSatelliteID, conj.TCA); err == nil {
+				if f, err := assessFeasibility(pair, k, conj.TCA.Truncate(time.Second)); err == nil {
+					m.Feasibility = &f
+				}
+			}
+			resp.ProposedMissions = []Mission{m}
+			slog.InfoContext(ctx, "proposed characterization mission", "conjunction", conj.ID, "target", m.TargetSatelliteID, "observer", m.ObserverSatelliteID)
+		}
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.IndentedJSON(status, resp)
+}
+
+// ConjunctionListResponse is the body of GET /conjunctions.
+type ConjunctionListResponse struct {
+	Conjunctions []Conjunction `json:"conjunctions"`
+}
+
+func (api *API) getConjunctions(c *gin.Context) {
+	minPc := 0.0
+	if v := c.Query("min_pc"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f >= 0 && f <= 1) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'min_pc' parameter. Must be a probability from 0 to 1.")
+			return
+		}
+		minPc = f
+	}
+	c.IndentedJSON(http.StatusOK, ConjunctionListResponse{Conjunctions: api.Conjunctions.List(c.Request.Context(), c.Query("satellite"), minPc)})
+}
+
+func (api *API) getConjunction(c *gin.Context) {
+	conj, err := api.Conjunctions.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeConjunctionNotFound, "conjunction not found")
+		return
+	}
+	conditionalJSON(c, conj, time.Unix(conj.Received, 0))
+}
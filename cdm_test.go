@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testCDM is the example CDM of CCSDS 508.0-B-1, trimmed, with its TCA
+// moved to where the ISS element set of the tests is current.
+const testCDM = `CCSDS_CDM_VERS = 1.0
+COMMENT Example trimmed from CCSDS 508.0-B-1
+CREATION_DATE = 2008-09-20T12:31:12.000
+ORIGINATOR = JSPOC
+MESSAGE_FOR = SATELLITE A
+MESSAGE_ID = 201113719185
+TCA = 2008-09-20T13:10:00.000
+MISS_DISTANCE = 715 [m]
+RELATIVE_SPEED = 14762 [m/s]
+COLLISION_PROBABILITY = 4.835E-04
+COLLISION_PROBABILITY_METHOD = FOSTER-1992
+OBJECT = OBJECT1
+OBJECT_DESIGNATOR = 12345
+CATALOG_NAME = SATCAT
+OBJECT_NAME = SATELLITE A
+INTERNATIONAL_DESIGNATOR = 1997-030E
+REF_FRAME = EME2000
+X = 2570.097065 [km]
+Y = 2244.654904 [km]
+Z = 6281.497978 [km]
+X_DOT = 4.418769571 [km/s]
+Y_DOT = 4.833547743 [km/s]
+Z_DOT = -3.526774282 [km/s]
+OBJECT = OBJECT2
+OBJECT_DESIGNATOR = 30337
+CATALOG_NAME = SATCAT
+OBJECT_NAME = FENGYUN 1C DEB
+INTERNATIONAL_DESIGNATOR = 1999-025AA
+REF_FRAME = EME2000
+X = 2569.540800 [km]
+Y = 2245.093614 [km]
+Z = 6281.599946 [km]
+X_DOT = -2.888612500 [km/s]
+Y_DOT = -6.007247516 [km/s]
+Z_DOT = 3.328770172 [km/s]
+`
+
+const testCDMXML = `<?xml version="1.0" encoding="UTF-8"?>
+<cdm id="CCSDS_CDM_VERS" version="1.0">
+  <header>
+    <CREATION_DATE>2008-09-20T12:31:12.000</CREATION_DATE>
+    <ORIGINATOR>JSPOC</ORIGINATOR>
+    <MESSAGE_ID>201113719185</MESSAGE_ID>
+  </header>
+  <body>
+    <relativeMetadataData>
+      <TCA>2008-09-20T13:10:00.000</TCA>
+      <MISS_DISTANCE units="m">715</MISS_DISTANCE>
+      <RELATIVE_SPEED units="m/s">14762</RELATIVE_SPEED>
+      <COLLISION_PROBABILITY>4.835E-04</COLLISION_PROBABILITY>
+      <COLLISION_PROBABILITY_METHOD>FOSTER-1992</COLLISION_PROBABILITY_METHOD>
+    </relativeMetadataData>
+    <segment>
+      <metadata>
+        <OBJECT>OBJECT1</OBJECT>
+        <OBJECT_DESIGNATOR>12345</OBJECT_DESIGNATOR>
+        <CATALOG_NAME>SATCAT</CATALOG_NAME>
+        <OBJECT_NAME>SATELLITE A</OBJECT_NAME>
+        <INTERNATIONAL_DESIGNATOR>1997-030E</INTERNATIONAL_DESIGNATOR>
+        <REF_FRAME>EME2000</REF_FRAME>
+      </metadata>
+      <data>
+        <stateVector>
+          <X units="km">2570.097065</X><Y units="km">2244.654904</Y><Z units="km">6281.497978</Z>
+          <X_DOT units="km/s">4.418769571</X_DOT><Y_DOT units="km/s">4.833547743</Y_DOT><Z_DOT units="km/s">-3.526774282</Z_DOT>
+        </stateVector>
+      </data>
+    </segment>
+    <segment>
+      <metadata>
+        <OBJECT>OBJECT2</OBJECT>
+        <OBJECT_DESIGNATOR>30337</OBJECT_DESIGNATOR>
+        <CATALOG_NAME>SATCAT</CATALOG_NAME>
+        <OBJECT_NAME>FENGYUN 1C DEB</OBJECT_NAME>
+        <INTERNATIONAL_DESIGNATOR>1999-025AA</INTERNATIONAL_DESIGNATOR>
+        <REF_FRAME>EME2000</REF_FRAME>
+      </metadata>
+      <data>
+        <stateVector>
+          <X units="km">2569.540800</X><Y units="km">2245.093614</Y><Z units="km">6281.599946</Z>
+          <X_DOT units="km/s">-2.888612500</X_DOT><Y_DOT units="km/s">-6.007247516</Y_DOT><Z_DOT units="km/s">3.328770172</Z_DOT>
+        </stateVector>
+      </data>
+    </segment>
+  </body>
+</cdm>`
+
+func TestReadCDM(t *testing.T) {
+	want := Conjunction{
+		ID:                         "201113719185",
+		Originator:                 "JSPOC",
+		Created:                    time.Date(2008, 9, 20, 12, 31, 12, 0, time.UTC),
+		TCA:                        time.Date(2008, 9, 20, 13, 10, 0, 0, time.UTC),
+		MissDistanceM:              715,
+		RelativeSpeedMS:            14762,
+		CollisionProbability:       4.835e-4,
+		CollisionProbabilityMethod: "FOSTER-1992",
+		Objects: [2]ConjunctionObject{
+			{Designator: "12345", CatalogName: "SATCAT", Name: "SATELLITE A", InternationalDesignator: "1997-030E", RefFrame: "EME2000",
+				Position: [3]float64{2570.097065, 2244.654904, 6281.497978}, Velocity: [3]float64{4.418769571, 4.833547743, -3.526774282}},
+			{Designator: "30337", CatalogName: "SATCAT", Name: "FENGYUN 1C DEB", InternationalDesignator: "1999-025AA", RefFrame: "EME2000",
+				Position: [3]float64{2569.5408, 2245.093614, 6281.599946}, Velocity: [3]float64{-2.8886125, -6.007247516, 3.328770172}},
+		},
+	}
+	for _, tt := range []struct{ name, contentType, body string }{
+		{"kvn", "text/plain", testCDM},
+		{"xml", "application/xml", testCDMXML},
+		{"sniffed xml", "", testCDMXML},
+	} {
+		got, err := readCDM(tt.contentType, []byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, want)
+		}
+	}
+
+	// The day-of-year form of a time.
+	doy := strings.Replace(testCDM, "TCA = 2008-09-20T13:10:00.000", "TCA = 2008-264T13:10:00.000", 1)
+	if got, err := readCDM("", []byte(doy)); err != nil || !got.TCA.Equal(want.TCA) {
+		t.Errorf("day-of-year TCA = %v, %v", got.TCA, err)
+	}
+
+	for _, tt := range []struct{ name, old, new, want string }{
+		{"no message id", "MESSAGE_ID = 201113719185\n", "", "MESSAGE_ID is missing"},
+		{"bad tca", "TCA = 2008-09-20T13:10:00.000", "TCA = tomorrow", `"tomorrow" is not a CCSDS time`},
+		{"bad number", "MISS_DISTANCE = 715 [m]", "MISS_DISTANCE = far", `MISS_DISTANCE "far" is not a number`},
+		{"bad pc", "COLLISION_PROBABILITY = 4.835E-04", "COLLISION_PROBABILITY = 2", "is not from 0 to 1"},
+		{"bad object", "OBJECT = OBJECT2", "OBJECT = OBJECT3", `OBJECT "OBJECT3" is not OBJECT1 or OBJECT2`},
+		{"no designator", "OBJECT_DESIGNATOR = 30337\n", "", "OBJECT2 has no OBJECT_DESIGNATOR"},
+		{"not kvn", "ORIGINATOR = JSPOC", "ORIGINATOR JSPOC", "line 4 is not KEYWORD = value"},
+	} {
+		_, err := readCDM("text/plain", []byte(strings.Replace(testCDM, tt.old, tt.new, 1)))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := readCDM("application/xml", []byte("<cdm><header>")); err == nil {
+		t.Error("truncated XML was accepted")
+	}
+}
+
+func TestConjunctionStore(t *testing.T) {
+	ctx := context.Background()
+	s := newConjunctionStore(nil, "")
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, c := range []Conjunction{
+		{ID: "b", TCA: now.Add(2 * time.Hour), CollisionProbability: 1e-3, Objects: [2]ConjunctionObject{{Satellite: "iss"}, {}}},
+		{ID: "a", TCA: now.Add(time.Hour), CollisionProbability: 1e-6, Objects: [2]ConjunctionObject{{}, {Satellite: "iss"}}},
+		{ID: "c", TCA: now.Add(time.Hour), CollisionProbability: 1e-2},
+	} {
+		if _, created, err := s.Put(ctx, c); err != nil || !created {
+			t.Fatalf("Put(%s) = %v, %v", c.ID, created, err)
+		}
+	}
+	if _, created, _ := s.Put(ctx, Conjunction{ID: "c", TCA: now.Add(3 * time.Hour)}); created {
+		t.Error("replacing c reported it as new")
+	}
+
+	ids := func(list []Conjunction) string {
+		var out []string
+		for _, c := range list {
+			out = append(out, c.ID)
+		}
+		return strings.Join(out, ",")
+	}
+	for _, tt := range []struct {
+		satellite string
+		minPc     float64
+		want      string
+	}{
+		{"", 0, "a,b,c"},
+		{"iss", 0, "a,b"},
+		{"iss", 1e-4, "b"},
+		{"other", 0, ""},
+	} {
+		if got := ids(s.List(ctx, tt.satellite, tt.minPc)); got != tt.want {
+			t.Errorf("List(%q, %g) = %s, want %s", tt.satellite, tt.minPc, got, tt.want)
+		}
+	}
+
+	// A conjunction long past its TCA is kept for conjunctionRetention
+	// after it arrives, and then dropped.
+	c, _, _ := s.Put(ctx, Conjunction{ID: "old", TCA: now.Add(-365 * 24 * time.Hour)})
+	if c.Expires != c.Received+int64(conjunctionRetention.Seconds()) {
+		t.Errorf("old conjunction expires %d, received %d", c.Expires, c.Received)
+	}
+	s.mu.Lock()
+	s.conjunctions["old"].Expires = now.Unix() - 1
+	s.mu.Unlock()
+	if _, err := s.Get(ctx, "old"); err != errConjunctionNotFound {
+		t.Errorf("Get(old) error = %v", err)
+	}
+	s.Put(ctx, Conjunction{ID: "d", TCA: now})
+	if _, ok := s.conjunctions["old"]; ok {
+		t.Error("Put kept an expired conjunction")
+	}
+}
+
+func TestPostCDM(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	shifted := shiftedISS(t)
+	api := &API{Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, ""), Conjunctions: newConjunctionStore(nil, ""), CDMProposalPc: defaultCDMProposalPc}
+	api.Satellites.Put(ctx, Satellite{ID: "sat-a", NoradID: 12345, Name: "Satellite A", Status: SatelliteActive, TLE: &shifted})
+	api.Satellites.Put(ctx, Satellite{ID: "fy1c-deb", NoradID: 30337, Name: "Fengyun 1C debris", Status: SatelliteActive, TLE: &iss})
+	router := gin.New()
+	router.POST("/cdm", api.postCDM)
+	router.GET("/conjunctions", api.getConjunctions)
+	router.GET("/conjunction/:id", api.getConjunction)
+	do := func(method, path, contentType, body string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp map[string]any
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, body := do(http.MethodPost, "/cdm?propose=true", "text/plain", testCDM)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /cdm = %d %s", w.Code, w.Body)
+	}
+	var resp CDMResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Conjunction.Objects[0].Satellite != "sat-a" || resp.Conjunction.Objects[1].Satellite != "fy1c-deb" {
+		t.Errorf("objects not linked: %+v", resp.Conjunction.Objects)
+	}
+	if len(resp.ProposedMissions) != 1 {
+		t.Fatalf("proposed missions %v", body["proposed_missions"])
+	}
+	m := resp.ProposedMissions[0]
+	tca := resp.Conjunction.TCA.Unix()
+	if m.ID != "cdm-201113719185" || m.Status != "proposed" || m.ObserverSatelliteID != "sat-a" || m.TargetSatelliteID != "fy1c-deb" ||
+		m.TCA != tca || m.CollectionWindowStart != tca-600 || m.CollectionWindowEnd != tca+600 || m.MinRangeKM != 0.715 || m.Feasibility == nil {
+		t.Errorf("proposed mission %+v", m)
+	}
+
+	// The same message again replaces the conjunction, and without
+	// ?propose=true nothing is proposed.
+	w, body = do(http.MethodPost, "/cdm", "application/xml", testCDMXML)
+	if w.Code != http.StatusOK || body["proposed_missions"] != nil {
+		t.Errorf("POST /cdm again = %d %s", w.Code, w.Body)
+	}
+	// Below CDM_PROPOSAL_PC nothing is proposed either.
+	unlikely := strings.NewReplacer("COLLISION_PROBABILITY = 4.835E-04", "COLLISION_PROBABILITY = 4.835E-07", "MESSAGE_ID = 201113719185", "MESSAGE_ID = 2").Replace(testCDM)
+	if w, body = do(http.MethodPost, "/cdm?propose=true", "", unlikely); w.Code != http.StatusCreated || body["proposed_missions"] != nil {
+		t.Errorf("POST /cdm unlikely = %d %s", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		want               int
+		code               ErrorCode
+	}{
+		{http.MethodPost, "/cdm", "MESSAGE_ID = 1\n", http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/cdm?propose=maybe", testCDM, http.StatusBadRequest, CodeInvalidParameter},
+		{http.MethodGet, "/conjunctions?min_pc=2", "", http.StatusBadRequest, CodeInvalidParameter},
+		{http.MethodGet, "/conjunction/none", "", http.StatusNotFound, CodeConjunctionNotFound},
+	} {
+		w, body := do(tt.method, tt.path, "", tt.body)
+		if w.Code != tt.want || body["code"] != string(tt.code) {
+			t.Errorf("%s %s = %d %v, want %d %s", tt.method, tt.path, w.Code, body, tt.want, tt.code)
+		}
+	}
+
+	w, body = do(http.MethodGet, "/conjunctions?satellite=sat-a&min_pc=1e-4", "", "")
+	if list, _ := body["conjunctions"].([]any); w.Code != http.StatusOK || len(list) != 1 {
+		t.Errorf("GET /conjunctions = %d %s", w.Code, w.Body)
+	}
+	if w, _ = do(http.MethodGet, "/conjunction/201113719185", "", ""); w.Code != http.StatusOK {
+		t.Errorf("GET /conjunction/201113719185 = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestConjunctionTenants(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{
+		Config:       &Config{AdminToken: "secret", MultiTenant: true, DefaultTenant: defaultTenant},
+		Keys:         newAPIKeyStore(nil, ""),
+		Roles:        newRoleStore(nil, ""),
+		Satellites:   newSatelliteStore(nil, ""),
+		TLEs:         newTLEStore(nil, ""),
+		Conjunctions: newConjunctionStore(nil, ""),
+	}
+	router := gin.New()
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	do := func(method, path, body, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set(tenantHeader, tenant)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	list := func(tenant string) []string {
+		t.Helper()
+		var resp ConjunctionListResponse
+		if w := do(http.MethodGet, "/conjunctions", "", tenant); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("GET /conjunctions as %s = %d %s", tenant, w.Code, w.Body)
+		}
+		var ids []string
+		for _, c := range resp.Conjunctions {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	// The same message is new to each tenant.
+	for _, tenant := range []string{"acme", "globex"} {
+		if w := do(http.MethodPost, "/cdm", testCDM, tenant); w.Code != http.StatusCreated {
+			t.Fatalf("POST /cdm as %s = %d %s", tenant, w.Code, w.Body)
+		}
+	}
+	other := strings.Replace(testCDM, "MESSAGE_ID = 201113719185", "MESSAGE_ID = 2", 1)
+	if w := do(http.MethodPost, "/cdm", other, "globex"); w.Code != http.StatusCreated {
+		t.Fatalf("POST /cdm as globex = %d %s", w.Code, w.Body)
+	}
+
+	if got := list("acme"); !slices.Equal(got, []string{"201113719185"}) {
+		t.Errorf("acme lists %v", got)
+	}
+	if got := list("globex"); len(got) != 2 {
+		t.Errorf("globex lists %v", got)
+	}
+	if w := do(http.MethodGet, "/conjunction/2", "", "acme"); w.Code != http.StatusNotFound {
+		t.Errorf("globex's conjunction as acme = %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/conjunction/2", "", "globex"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id": "2"`) {
+		t.Errorf("GET /conjunction/2 as globex = %d %s", w.Code, w.Body)
+	}
+	if _, err := api.Conjunctions.Get(context.Background(), "globex/2"); err != nil {
+		t.Errorf("unscoped globex/2: %v", err)
+	}
+}
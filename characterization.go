@@ -0,0 +1,352 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// A characterization reads at most maxCharacterizationImages images,
+	// those of the target's newest missions.
+	maxCharacterizationImages  = 10000
+	characterizationBestFrames = 10
+	// A light curve needs tumbleMinSamples measured frames for a period,
+	// and a period tumbleMinPower of the variance explained to be reported.
+	tumbleMinSamples = 8
+	tumbleMinPower   = 0.3
+	// The periodogram samples frequencies tumbleOversample times finer than
+	// one cycle over the light curve, up to tumbleMaxFrequencies of them.
+	tumbleOversample     = 5
+	tumbleMaxFrequencies = 20000
+	tumbleRefineSteps    = 50
+)
+
+// BestFrame is one of the frames that resolve the target most finely.
+type BestFrame struct {
+	ImageID     string  `json:"image_id"`
+	MissionID   string  `json:"mission_id"`
+	CaptureTime int64   `json:"capture_time"`
+	RangeKM     float64 `json:"range_km"`
+	// PixelScaleM and TargetPixels are set for frames whose observer has a
+	// sensor registered.
+	PixelScaleM  float64 `json:"pixel_scale_m,omitempty"`
+	TargetPixels float64 `json:"target_pixels,omitempty"`
+}
+
+// BrightnessStats summarize the instrumental magnitudes of the unsaturated
+// frames with stored photometry. Lower is brighter.
+type BrightnessStats struct {
+	Samples   int     `json:"samples"`
+	Saturated int     `json:"saturated"`
+	Brightest float64 `json:"brightest_mag"`
+	Faintest  float64 `json:"faintest_mag"`
+	Mean      float64 `json:"mean_mag"`
+	Median    float64 `json:"median_mag"`
+	StdDev    float64 `json:"stddev_mag"`
+}
+
+// LightCurvePeriod is the strongest brightness period of one mission's
+// light curve. Power is the share of the magnitudes' variance a sinusoid at
+// the period explains, from 0 to 1.
+type LightCurvePeriod struct {
+	MissionID string  `json:"mission_id"`
+	Samples   int     `json:"samples"`
+	SpanS     int64   `json:"span_s"`
+	PeriodS   float64 `json:"period_s,omitempty"`
+	Power     float64 `json:"power,omitempty"`
+}
+
+// TargetCharacterization is the body of GET /satellite/:id/characterization.
+type TargetCharacterization struct {
+	SatelliteID string `json:"satellite_id"`
+	Missions    int    `json:"missions"`
+	Images      int    `json:"images"`
+	// Truncated is set when only the newest missions' images were read.
+	Truncated     bool             `json:"truncated,omitempty"`
+	FirstObserved int64            `json:"first_observed,omitempty"`
+	LastObserved  int64            `json:"last_observed,omitempty"`
+	BestFrames    []BestFrame      `json:"best_frames"`
+	Brightness    *BrightnessStats `json:"brightness,omitempty"`
+	// Tumble is the strongest of LightCurves, when it is strong enough.
+	Tumble      *LightCurvePeriod  `json:"tumble,omitempty"`
+	LightCurves []LightCurvePeriod `json:"light_curves"`
+}
+
+// targetMissions returns ctx's tenant's missions of which sat is the target,
+// from the mission index when it has loaded and otherwise by reading every
+// mission.
+func (api *API) targetMissions(ctx context.Context, sat string) ([]Mission, error) {
+	var targeting []Mission
+	if index := api.Stream.Index(); index != nil {
+		if missions, ok := index.Satellite(tenantFrom(ctx), sat); ok {
+			for _, m := range missions {
+				if m.TargetSatelliteID == sat {
+					targeting = append(targeting, m)
+				}
+			}
+			return targeting, nil
+		}
+	}
+	token := ""
+	for {
+		missions, next, err := api.MissionDB.Missions(ctx, streamIndexPage, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range missions {
+			if m.TargetSatelliteID == sat {
+				targeting = append(targeting, m)
+			}
+		}
+		if next == "" {
+			return targeting, nil
+		}
+		token = next
+	}
+}
+
+// characterize summarizes the stored geometry and photometry of the images
+// of missions, all of one target.
+func characterize(sat string, missions []Mission, records map[string]*ImageRecord, truncated bool) TargetCharacterization {
+	out := TargetCharacterization{SatelliteID: sat, Missions: len(missions), Truncated: truncated, BestFrames: []BestFrame{}, LightCurves: []LightCurvePeriod{}}
+	var mags []float64
+	var saturated int
+	seen := map[string]bool{}
+	for _, m := range missions {
+		var times, curve []float64
+		for _, id := range m.ImageIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			out.Images++
+			r := records[id]
+			if r == nil {
+				continue
+			}
+			if g := r.Geometry; g != nil && g.RangeKM > 0 {
+				f := BestFrame{ImageID: id, MissionID: m.ID, CaptureTime: g.CaptureTime, RangeKM: g.RangeKM}
+				if g.Coverage != nil {
+					f.PixelScaleM, f.TargetPixels = g.Coverage.PixelScaleM, g.Coverage.TargetPixels
+				}
+				out.BestFrames = append(out.BestFrames, f)
+				out.observed(g.CaptureTime)
+			}
+			p := r.Photometry
+			if p == nil {
+				continue
+			}
+			out.observed(p.CaptureTime)
+			if p.Saturated {
+				saturated++
+				continue
+			}
+			if p.Flux > 0 {
+				mag := -2.5 * math.Log10(p.Flux)
+				mags = append(mags, mag)
+				if p.CaptureTime > 0 {
+					times, curve = append(times, float64(p.CaptureTime)), append(curve, mag)
+				}
+			}
+		}
+		if len(curve) >= tumbleMinSamples {
+			lc := LightCurvePeriod{MissionID: m.ID, Samples: len(curve), SpanS: int64(slices.Max(times) - slices.Min(times))}
+			lc.PeriodS, lc.Power = lombScargle(times, curve)
+			out.LightCurves = append(out.LightCurves, lc)
+		}
+	}
+
+	// Frames whose pixel scale is known come first, finest first, then the
+	// rest by range.
+	scale := func(f BestFrame) float64 {
+		if f.PixelScaleM > 0 {
+			return f.PixelScaleM
+		}
+		return math.Inf(1)
+	}
+	slices.SortFunc(out.BestFrames, func(a, b BestFrame) int {
+		return cmp.Or(
+			cmp.Compare(scale(a), scale(b)),
+			cmp.Compare(a.RangeKM, b.RangeKM),
+			cmp.Compare(a.ImageID, b.ImageID),
+		)
+	})
+	out.BestFrames = out.BestFrames[:min(len(out.BestFrames), characterizationBestFrames)]
+
+	if len(mags) > 0 || saturated > 0 {
+		out.Brightness = brightnessStats(mags)
+		out.Brightness.Saturated = saturated
+	}
+	for i, lc := range out.LightCurves {
+		if lc.Power >= tumbleMinPower && (out.Tumble == nil || lc.Power > out.Tumble.Power) {
+			out.Tumble = &out.LightCurves[i]
+		}
+	}
+	return out
+}
+
+func (c *TargetCharacterization) observed(t int64) {
+	if t <= 0 {
+		return
+	}
+	if c.FirstObserved == 0 || t < c.FirstObserved {
+		c.FirstObserved = t
+	}
+	c.LastObserved = max(c.LastObserved, t)
+}
+
+func brightnessStats(mags []float64) *BrightnessStats {
+	s := &BrightnessStats{Samples: len(mags)}
+	if len(mags) == 0 {
+		return s
+	}
+	sorted := slices.Sorted(slices.Values(mags))
+	var sum float64
+	for _, m := range sorted {
+		sum += m
+	}
+	mean := sum / float64(len(sorted))
+	var squares float64
+	for _, m := range sorted {
+		squares += (m - mean) * (m - mean)
+	}
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	round := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+	s.Brightest, s.Faintest = round(sorted[0]), round(sorted[len(sorted)-1])
+	s.Mean, s.Median = round(mean), round(median)
+	s.StdDev = round(math.Sqrt(squares / float64(len(sorted))))
+	return s
+}
+
+// lombScargle finds the period, in seconds, at which the Lomb-Scargle
+// periodogram of y sampled at times t peaks, and its power normalized to
+// the share of y's variance explained. Periods run from twice the median
+// spacing of the samples to half their span, so that at least two cycles
+// are seen; it returns zeros when that range is empty.
+func lombScargle(t, y []float64) (period, power float64) {
+	n := len(t)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return cmp.Compare(t[a], t[b]) })
+	var gaps []float64
+	for i := 1; i < n; i++ {
+		if d := t[order[i]] - t[order[i-1]]; d > 0 {
+			gaps = append(gaps, d)
+		}
+	}
+	span := t[order[n-1]] - t[order[0]]
+	if len(gaps) == 0 || span <= 0 {
+		return 0, 0
+	}
+	slices.Sort(gaps)
+	fmin, fmax := 2/span, 1/(2*gaps[len(gaps)/2])
+	if fmax <= fmin {
+		return 0, 0
+	}
+	df := max(1/(tumbleOversample*span), (fmax-fmin)/tumbleMaxFrequencies)
+
+	var mean float64
+	for _, v := range y {
+		mean += v
+	}
+	mean /= float64(n)
+	dy := make([]float64, n)
+	var total float64
+	for i, v := range y {
+		dy[i] = v - mean
+		total += dy[i] * dy[i]
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	t0 := t[order[0]]
+	at := func(f float64) float64 {
+		w := 2 * math.Pi * f
+		var s2, c2 float64
+		for _, ti := range t {
+			s2 += math.Sin(2 * w * (ti - t0))
+			c2 += math.Cos(2 * w * (ti - t0))
+		}
+		tau := math.Atan2(s2, c2) / (2 * w)
+		var yc, ys, cc, ss float64
+		for i, ti := range t {
+			c, s := math.Cos(w*(ti-t0-tau)), math.Sin(w*(ti-t0-tau))
+			yc += dy[i] * c
+			ys += dy[i] * s
+			cc += c * c
+			ss += s * s
+		}
+		if cc == 0 || ss == 0 {
+			return 0
+		}
+		return (yc*yc/cc + ys*ys/ss) / total
+	}
+	var best float64
+	for f := fmin; f <= fmax; f += df {
+		if p := at(f); p > power {
+			best, power = f, p
+		}
+	}
+	if power == 0 {
+		return 0, 0
+	}
+	// The grid is coarse over a short light curve; refine between its
+	// neighbours of the peak.
+	for f := max(fmin, best-df); f <= min(fmax, best+df); f += df / tumbleRefineSteps {
+		if p := at(f); p > power {
+			best, power = f, p
+		}
+	}
+	period = 1 / best
+	return math.Round(period*1000) / 1000, math.Round(power*1000) / 1000
+}
+
+// getCharacterization summarizes what the target's missions have collected
+// of it, from the geometry and photometry stored on their images.
+func (api *API) getCharacterization(c *gin.Context) {
+	ctx := c.Request.Context()
+	sat := c.Param("id")
+	if _, err := api.Satellites.Get(sat); err != nil {
+		respondError(c, http.StatusNotFound, CodeSatelliteNotFound, "satellite not found")
+		return
+	}
+	missions, err := api.targetMissions(ctx, sat)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list missions", "satellite", sat, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve missions")
+		return
+	}
+
+	// The newest missions' images are read first, up to the limit.
+	slices.SortStableFunc(missions, func(a, b Mission) int {
+		return cmp.Or(cmp.Compare(b.CollectionWindowStart, a.CollectionWindowStart), cmp.Compare(a.ID, b.ID))
+	})
+	var ids []string
+	truncated := false
+	for i, m := range missions {
+		if len(ids)+len(m.ImageIDs) > maxCharacterizationImages {
+			missions, truncated = missions[:i], true
+			break
+		}
+		ids = append(ids, m.ImageIDs...)
+	}
+	records, err := api.Images.ImageRecords(ctx, ids)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image records", "satellite", sat, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, characterize(sat, missions, records, truncated))
+}
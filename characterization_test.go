@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLombScargle(t *testing.T) {
+	tests := []struct {
+		name      string
+		n         int
+		step      float64
+		period    float64
+		wantPower float64
+	}{
+		{"tumbling", 60, 20, 300, 0.9},
+		{"slow", 40, 30, 500, 0.9},
+		// Too few samples a cycle to see the period.
+		{"undersampled", 30, 100, 150, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var times, mags []float64
+			for i := range tt.n {
+				// Uneven spacing, as frames are not captured on a clock.
+				ti := float64(i)*tt.step + 3*math.Sin(float64(i))
+				times = append(times, 1.7e9+ti)
+				mags = append(mags, 10+0.5*math.Sin(2*math.Pi*ti/tt.period))
+			}
+			period, power := lombScargle(times, mags)
+			if tt.wantPower == 0 {
+				if math.Abs(period-tt.period) < tt.period*0.05 {
+					t.Errorf("found period %v in undersampled data", period)
+				}
+				return
+			}
+			if math.Abs(period-tt.period) > tt.period*0.02 || power < tt.wantPower || power > 1 {
+				t.Errorf("period %v power %v, want %v", period, power, tt.period)
+			}
+		})
+	}
+	if period, power := lombScargle([]float64{1, 1, 1}, []float64{1, 2, 3}); period != 0 || power != 0 {
+		t.Errorf("no span: %v %v", period, power)
+	}
+}
+
+func TestCharacterization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	meta := testSQLStore(t)
+	start := int64(1_700_000_000)
+	var curve []string
+	for i := range 30 {
+		id := fmt.Sprintf("lc%02d", i)
+		curve = append(curve, id)
+		ti := start + int64(i*20)
+		mag := 10 + 0.5*math.Sin(2*math.Pi*float64(ti-start)/240)
+		meta.SetImageAttribute(ctx, id, "photometry", Photometry{Flux: math.Pow(10, -mag/2.5), CaptureTime: ti})
+	}
+	meta.SetImageAttribute(ctx, "lc00", "photometry", Photometry{Flux: 1e9, Saturated: true, CaptureTime: start})
+	meta.SetImageAttribute(ctx, "near", "geometry", ObservationGeometry{CaptureTime: start - 100, RangeKM: 5, Coverage: &SensorCoverage{PixelScaleM: 0.05, TargetPixels: 80}})
+	meta.SetImageAttribute(ctx, "far", "geometry", ObservationGeometry{CaptureTime: start - 200, RangeKM: 50, Coverage: &SensorCoverage{PixelScaleM: 0.5, TargetPixels: 8}})
+	meta.SetImageAttribute(ctx, "nosensor", "geometry", ObservationGeometry{CaptureTime: start - 300, RangeKM: 2})
+	for _, m := range []*Mission{
+		{ID: "m1", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", CollectionWindowStart: start, ImageIDs: curve},
+		{ID: "m2", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", CollectionWindowStart: start - 1000, ImageIDs: []string{"far", "nosensor", "near", "lc01"}},
+		{ID: "m3", TargetSatelliteID: "chaser", ObserverSatelliteID: "iss", ImageIDs: []string{"other"}},
+	} {
+		if err := putMission(ctx, meta, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{MissionDB: meta, Images: meta, Satellites: newSatelliteStore(nil, "")}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive})
+	router := gin.New()
+	router.GET("/satellite/:id/characterization", api.getCharacterization)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/satellite/sputnik/characterization", nil))
+	var problem Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if w.Code != http.StatusNotFound || problem.Code != CodeSatelliteNotFound {
+		t.Errorf("unknown satellite: %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/satellite/iss/characterization", nil))
+	var got TargetCharacterization
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	// lc01 is in both missions and counted once.
+	if got.Missions != 2 || got.Images != 33 || got.Truncated || got.FirstObserved != start-300 || got.LastObserved != start+29*20 {
+		t.Errorf("summary %+v", got)
+	}
+	var frames []string
+	for _, f := range got.BestFrames {
+		frames = append(frames, f.ImageID)
+	}
+	if fmt.Sprint(frames) != "[near far nosensor]" || got.BestFrames[0].MissionID != "m2" || got.BestFrames[0].TargetPixels != 80 {
+		t.Errorf("best frames %+v", got.BestFrames)
+	}
+	if b := got.Brightness; b == nil || b.Samples != 29 || b.Saturated != 1 || b.Brightest > 9.51 || b.Faintest < 10.49 || math.Abs(b.Mean-10) > 0.1 {
+		t.Errorf("brightness %+v", got.Brightness)
+	}
+	if len(got.LightCurves) != 1 || got.Tumble == nil || got.Tumble.MissionID != "m1" || math.Abs(got.Tumble.PeriodS-240) > 5 || got.Tumble.Samples != 29 {
+		t.Errorf("tumble %+v, light curves %+v", got.Tumble, got.LightCurves)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// checksumHeader carries the hex SHA-256 of a whole response body.
+	checksumHeader = "X-Content-SHA256"
+	// checksumMetadata is the object metadata in which an uploader may
+	// declare the hex SHA-256 of an upload, as x-amz-meta-sha256.
+	checksumMetadata = "sha256"
+)
+
+// contentSHA256 is the hex SHA-256 of data.
+func contentSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// objectChecksum is the hex SHA-256 of the whole object, and the one its
+// uploader declared in its sha256 metadata or as S3's SHA-256 checksum, or
+// "" when it declared none.
+func (api *API) objectChecksum(ctx context.Context, bucketName, key string) (sum, declared string, err error) {
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(bucketName),
+		Key:          aws.String(key),
+		ChecksumMode: s3types.ChecksumModeEnabled,
+	}, s3Accelerate(bucketName)...)
+	if err != nil {
+		return "", "", err
+	}
+	defer out.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, out.Body); err != nil {
+		return "", "", fmt.Errorf("read %s: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), declaredChecksum(out.Metadata, aws.ToString(out.ChecksumSHA256)), nil
+}
+
+// declaredChecksum is the hex SHA-256 in an object's sha256 metadata, or
+// else its S3 checksum, which is base64. A multipart upload's S3 checksum
+// covers its parts' checksums rather than the object, so it is ignored.
+func declaredChecksum(metadata map[string]string, s3Checksum string) string {
+	if v := strings.ToLower(strings.TrimSpace(metadata[checksumMetadata])); v != "" {
+		return v
+	}
+	if raw, err := base64.StdEncoding.DecodeString(s3Checksum); err == nil && len(raw) == sha256.Size {
+		return hex.EncodeToString(raw)
+	}
+	return ""
+}
+
+// imageChecksum is the SHA-256 of image id recorded at ingest, or declared
+// in metadata, the object's metadata, when it was not ingested. It is ""
+// when there is neither.
+func (api *API) imageChecksum(ctx context.Context, id string, metadata map[string]string) string {
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+	}
+	if record != nil && record.Ingest != nil && record.Ingest.SHA256 != "" {
+		return record.Ingest.SHA256
+	}
+	return declaredChecksum(metadata, "")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeclaredChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("frame"))
+	hexSum := contentSHA256([]byte("frame"))
+	tests := []struct {
+		name       string
+		metadata   map[string]string
+		s3Checksum string
+		want       string
+	}{
+		{"none", nil, "", ""},
+		{"metadata", map[string]string{"sha256": " " + strings.ToUpper(hexSum) + " "}, "", hexSum},
+		{"s3 checksum", nil, base64.StdEncoding.EncodeToString(sum[:]), hexSum},
+		{"metadata first", map[string]string{"sha256": "abc"}, base64.StdEncoding.EncodeToString(sum[:]), "abc"},
+		{"multipart", nil, base64.StdEncoding.EncodeToString(sum[:]) + "-3", ""},
+	}
+	for _, tt := range tests {
+		if got := declaredChecksum(tt.metadata, tt.s3Checksum); got != tt.want {
+			t.Errorf("%s: declaredChecksum = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChecksumVerification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := contentSHA256(frame)
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern)},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        store,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+	put := func(id string, data []byte, declared string) {
+		t.Helper()
+		in := &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(data)}
+		if declared != "" {
+			in.Metadata = map[string]string{checksumMetadata: declared}
+		}
+		if _, err := store.PutObject(ctx, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("good", frame, sum)
+	put("bad", frame, contentSHA256([]byte("another frame")))
+	for id, want := range map[string]string{"good": IngestIngested, "bad": IngestRejected} {
+		if err := api.ingestImage(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		record, err := meta.ImageRecord(ctx, id)
+		if err != nil || record == nil || record.Ingest == nil {
+			t.Fatalf("%s: record %+v, %v", id, record, err)
+		}
+		if record.Ingest.Status != want || record.Ingest.SHA256 != sum || record.Ingest.Verified != (want == IngestIngested) {
+			t.Errorf("%s: ingest %+v", id, record.Ingest)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/image/:id", api.getSatImageByID)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	for _, path := range []string{"/image/good", "/image/good?verify=true"} {
+		w := get(path)
+		if w.Code != http.StatusOK || w.Header().Get(checksumHeader) != sum || !bytes.Equal(w.Body.Bytes(), frame) {
+			t.Errorf("GET %s: %d %s=%q", path, w.Code, checksumHeader, w.Header().Get(checksumHeader))
+		}
+	}
+	if w := get("/image/good?verify=true&width=64"); w.Code != http.StatusBadRequest {
+		t.Errorf("verify with processing: %d", w.Code)
+	}
+
+	put("good", append(bytes.Clone(frame), 0), "")
+	w := get("/image/good?verify=true")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), string(CodeChecksumMismatch)) {
+		t.Errorf("verify of a changed object: %d %s", w.Code, w.Body)
+	}
+
+	put("unrecorded", frame, "")
+	if w := get("/image/unrecorded?verify=true"); w.Code != http.StatusConflict {
+		t.Errorf("verify with no checksum: %d %s", w.Code, w.Body)
+	}
+	if w := get("/image/unrecorded"); w.Code != http.StatusOK || w.Header().Get(checksumHeader) != "" {
+		t.Errorf("GET with no checksum: %d %s=%q", w.Code, checksumHeader, w.Header().Get(checksumHeader))
+	}
+}
@@ -0,0 +1,437 @@
+// Package client calls the satellite image server's /api/v1 over HTTP.
+//
+//	c, err := client.New("https://sat.example.com")
+//	for m, err := range c.Missions(ctx, 100) {
+//		...
+//	}
+//	img, err := c.GetImage(ctx, id, &client.ImageOptions{Width: 1024, Format: "png"})
+//	defer img.Body.Close()
+//
+// Idempotent requests are retried on connection errors, 429, 502, 503 and
+// 504, honouring Retry-After. Error responses are returned as *Problem.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix      = "/api/v1"
+	defaultRetries = 3
+	defaultBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// Client is safe for concurrent use.
+type Client struct {
+	base    *url.URL
+	http    *http.Client
+	token   string
+	agent   string
+	retries int
+	backoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithAdminToken sends token as the bearer token: the server's ADMIN_TOKEN,
+// or an API key with the scopes of the calls made, such as missions:write
+// for InvalidateMission.
+func WithAdminToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithUserAgent sets the User-Agent header, so the server's logs show which
+// service is calling.
+func WithUserAgent(agent string) Option {
+	return func(c *Client) { c.agent = agent }
+}
+
+// WithRetries sets how many times a failed idempotent request is retried
+// (default 3) and the delay before the first retry (default 500ms), which
+// doubles each time. Zero retries disables them.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = n, backoff }
+}
+
+// New returns a client for the server at baseURL, such as
+// "https://sat.example.com".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("client: base URL %q is not an http or https URL", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + apiPrefix
+	c := &Client{base: u, http: http.DefaultClient, agent: "sat-image-client", retries: defaultRetries, backoff: defaultBackoff}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Problem is an error response from the server. Code is stable and meant to
+// be compared, for example against "MISSION_NOT_FOUND"; Detail is for
+// people.
+type Problem struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"request_id"`
+}
+
+func (p *Problem) Error() string {
+	msg := fmt.Sprintf("sat image server: %d %s", p.Status, p.Code)
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	if p.RequestID != "" {
+		msg += " (request " + p.RequestID + ")"
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	var p *Problem
+	return errors.As(err, &p) && p.Status == http.StatusNotFound
+}
+
+// url joins path, whose segments are already escaped, to the base URL.
+func (c *Client) url(path string, q url.Values) string {
+	u := *c.base
+	unescaped, _ := url.PathUnescape(path)
+	u.Path += unescaped
+	u.RawPath = c.base.EscapedPath() + path
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// do sends the request, retrying if it is idempotent, and returns the
+// response when its status is below 400. The caller closes its body.
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, body any) (*http.Response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	idempotent := method == http.MethodGet || method == http.MethodHead || method == http.MethodPut
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.url(path, q), bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept", "application/json, application/problem+json, */*")
+		req.Header.Set("User-Agent", c.agent)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.http.Do(req)
+		retry := idempotent && attempt < c.retries
+		if err != nil {
+			if !retry || ctx.Err() != nil {
+				return nil, err
+			}
+			if err := c.wait(ctx, attempt, ""); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		if retry && retryable(resp.StatusCode) {
+			after := resp.Header.Get("Retry-After")
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if err := c.wait(ctx, attempt, after); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		return nil, readProblem(resp)
+	}
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// wait sleeps before retry attempt+1: for Retry-After seconds when the
+// server sent it, otherwise for the doubling backoff with jitter.
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter string) error {
+	d := min(c.backoff<<attempt, maxBackoff)
+	d = d/2 + rand.N(d/2+1)
+	if s, err := strconv.Atoi(retryAfter); err == nil && s >= 0 {
+		d = min(time.Duration(s)*time.Second, maxBackoff)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func readProblem(resp *http.Response) error {
+	p := &Problem{Status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, p) != nil || p.Code == "" {
+		p.Status, p.Title, p.Detail = resp.StatusCode, http.StatusText(resp.StatusCode), strings.TrimSpace(string(data))
+	}
+	if p.RequestID == "" {
+		p.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return p
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, q url.Values, v any) error {
+	return c.sendJSON(ctx, http.MethodGet, path, q, nil, v)
+}
+
+func (c *Client) sendJSON(ctx context.Context, method, path string, q url.Values, body, v any) error {
+	resp, err := c.do(ctx, method, path, q, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("client: decode %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// paginate walks the pages fetch returns until one has no next token.
+func paginate[T any](ctx context.Context, fetch func(ctx context.Context, token string) ([]T, string, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		token := ""
+		for {
+			items, next, err := fetch(ctx, token)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if next == "" {
+				return
+			}
+			token = next
+		}
+	}
+}
+
+// ListMissions fetches one page of at most count missions (the server caps
+// it at 100), starting after token, which is empty for the first page.
+func (c *Client) ListMissions(ctx context.Context, count int, token string) (*MissionPage, error) {
+	q := url.Values{}
+	if count > 0 {
+		q.Set("count", strconv.Itoa(count))
+	}
+	if token != "" {
+		q.Set("nextToken", token)
+	}
+	var page MissionPage
+	if err := c.getJSON(ctx, "/missions", q, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Missions iterates over every mission, fetching pages of pageSize as it
+// goes. Iteration stops at the first error, which is yielded.
+func (c *Client) Missions(ctx context.Context, pageSize int) iter.Seq2[Mission, error] {
+	return paginate(ctx, func(ctx context.Context, token string) ([]Mission, string, error) {
+		page, err := c.ListMissions(ctx, pageSize, token)
+		if err != nil {
+			return nil, "", err
+		}
+		return page.Missions, page.NextToken, nil
+	})
+}
+
+func (c *Client) GetMission(ctx context.Context, id string) (*Mission, error) {
+	var m Mission
+	if err := c.getJSON(ctx, "/mission/"+url.PathEscape(id), nil, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MissionImages lists the mission's images with their metadata, keeping only
+// those with a quality score of at least minQuality when it is positive.
+func (c *Client) MissionImages(ctx context.Context, id string, minQuality float64) (*MissionImages, error) {
+	q := url.Values{}
+	if minQuality > 0 {
+		q.Set("minQuality", strconv.FormatFloat(minQuality, 'f', -1, 64))
+	}
+	var images MissionImages
+	if err := c.getJSON(ctx, "/mission/"+url.PathEscape(id)+"/images", q, &images); err != nil {
+		return nil, err
+	}
+	return &images, nil
+}
+
+// InvalidateMission drops the server's cached copy of a mission. It needs
+// WithAdminToken with the missions:write scope.
+func (c *Client) InvalidateMission(ctx context.Context, id string) error {
+	return c.sendJSON(ctx, http.MethodPost, "/mission/"+url.PathEscape(id)+"/invalidate", nil, nil, nil)
+}
+
+// RecomputeMissionGeometry recomputes the mission's TCA, minimum range and
+// relative velocity from the latest TLEs, searching its collection window,
+// and returns the updated mission.
+func (c *Client) RecomputeMissionGeometry(ctx context.Context, id string) (*Mission, error) {
+	var resp struct {
+		Mission *Mission `json:"mission"`
+	}
+	if err := c.sendJSON(ctx, http.MethodPost, "/missions/"+url.PathEscape(id)+"/recompute-geometry", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Mission, nil
+}
+
+func (c *Client) ImageMetadata(ctx context.Context, id string) (*ImageMetadata, error) {
+	var meta ImageMetadata
+	if err := c.getJSON(ctx, "/image/"+url.PathEscape(id)+"/metadata", nil, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// ImageVersions lists the ingested versions of an image and their
+// provenance, newest first.
+func (c *Client) ImageVersions(ctx context.Context, id string) (*ImageVersions, error) {
+	var versions ImageVersions
+	if err := c.getJSON(ctx, "/image/"+url.PathEscape(id)+"/versions", nil, &versions); err != nil {
+		return nil, err
+	}
+	return &versions, nil
+}
+
+// JobFilter narrows ListJobs and Jobs. Empty fields match every job.
+type JobFilter struct {
+	Type   string
+	Status string
+}
+
+// ListJobs fetches one page of at most limit jobs (server default 50),
+// newest first, starting after token.
+func (c *Client) ListJobs(ctx context.Context, filter JobFilter, limit int, token string) (*JobPage, error) {
+	q := url.Values{}
+	if filter.Type != "" {
+		q.Set("type", filter.Type)
+	}
+	if filter.Status != "" {
+		q.Set("status", filter.Status)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if token != "" {
+		q.Set("nextToken", token)
+	}
+	var page JobPage
+	if err := c.getJSON(ctx, "/jobs", q, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Jobs iterates over every job matching filter, newest first.
+func (c *Client) Jobs(ctx context.Context, filter JobFilter, pageSize int) iter.Seq2[Job, error] {
+	return paginate(ctx, func(ctx context.Context, token string) ([]Job, string, error) {
+		page, err := c.ListJobs(ctx, filter, pageSize, token)
+		if err != nil {
+			return nil, "", err
+		}
+		return page.Jobs, page.NextToken, nil
+	})
+}
+
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.getJSON(ctx, "/jobs/"+url.PathEscape(id), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WaitJob polls the job every interval until it has finished or ctx ends.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		job, err := c.GetJob(ctx, id)
+		if err != nil || job.Done() {
+			return job, err
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// JobOutput downloads a finished job's output. The caller closes the body.
+func (c *Client) JobOutput(ctx context.Context, id string) (*Object, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/output", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newObject(resp), nil
+}
+
+// SubmitProcessJob starts a batch job applying opts to every image in ids.
+// It is not retried, since a retry could start a second job.
+func (c *Client) SubmitProcessJob(ctx context.Context, ids []string, opts *ImageOptions) (*JobAccepted, error) {
+	spec := map[string]string{}
+	for k, v := range opts.values() {
+		spec[k] = v[0]
+	}
+	var accepted JobAccepted
+	body := map[string]any{"ids": ids, "spec": spec}
+	if err := c.sendJSON(ctx, http.MethodPost, "/jobs/process", nil, body, &accepted); err != nil {
+		return nil, err
+	}
+	return &accepted, nil
+}
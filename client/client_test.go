@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient(t *testing.T, h http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, append([]Option{WithRetries(2, time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMissionsIterator(t *testing.T) {
+	pages := map[string]MissionPage{
+		"":   {Missions: []Mission{{ID: "a"}, {ID: "b"}}, NextToken: "t1"},
+		"t1": {Missions: []Mission{{ID: "c"}}},
+	}
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/missions" || r.URL.Query().Get("count") != "2" {
+			t.Errorf("request %s", r.URL)
+		}
+		json.NewEncoder(w).Encode(pages[r.URL.Query().Get("nextToken")])
+	})
+
+	var ids []string
+	for m, err := range c.Missions(context.Background(), 2) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.ID)
+	}
+	if len(ids) != 3 || ids[2] != "c" {
+		t.Errorf("missions = %v", ids)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(Mission{ID: "m1"})
+	})
+	m, err := c.GetMission(context.Background(), "m1")
+	if err != nil || m.ID != "m1" || calls.Load() != 3 {
+		t.Errorf("GetMission = %+v, %v after %d calls", m, err, calls.Load())
+	}
+
+	// A POST could start a second job, so it is sent once.
+	calls.Store(0)
+	_, err = c.SubmitProcessJob(context.Background(), []string{"x"}, nil)
+	if err == nil || calls.Load() != 1 {
+		t.Errorf("SubmitProcessJob = %v after %d calls, want one failed call", err, calls.Load())
+	}
+}
+
+func TestProblem(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"request_id":"r1","type":"about:blank","title":"Not Found","status":404,"code":"MISSION_NOT_FOUND","detail":"mission not found"}`)
+	})
+	_, err := c.GetMission(context.Background(), "nope")
+	var p *Problem
+	if !errors.As(err, &p) || p.Code != "MISSION_NOT_FOUND" || p.RequestID != "r1" || !IsNotFound(err) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		want := "crop=10%2C20%2C30%2C40&format=png&scale=percentile%3A1%2C99&stripMetadata=true&width=800"
+		if r.URL.EscapedPath() != "/api/v1/image/a%2Fb" || r.URL.RawQuery != want {
+			t.Errorf("request %s %s, want query %s", r.URL.Path, r.URL.RawQuery, want)
+		}
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, "png")
+	})
+	obj, err := c.GetImage(context.Background(), "a/b", &ImageOptions{
+		Width: 800, Format: "png", Scale: "percentile:1,99", StripMetadata: true,
+		Crop: image.Rect(10, 20, 40, 60),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Body.Close()
+	body, _ := io.ReadAll(obj.Body)
+	if obj.ContentType != "image/png" || string(body) != "png" {
+		t.Errorf("object %+v %q", obj, body)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, base := range []string{"", "sat.example.com", "ftp://sat.example.com"} {
+		if _, err := New(base); err == nil {
+			t.Errorf("New(%q) succeeded", base)
+		}
+	}
+	c, err := New("https://sat.example.com/prefix/")
+	if err != nil || c.url("/missions", nil) != "https://sat.example.com/prefix/api/v1/missions" {
+		t.Errorf("New = %v, %v", c, err)
+	}
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ImageOptions are the processing parameters of GET /image/:id. Zero fields
+// are left unset, so a nil or zero ImageOptions downloads the original.
+type ImageOptions struct {
+	Width, Height int
+	// Crop is cut out of the full-resolution source before anything else.
+	Crop     image.Rectangle
+	Contrast float64
+	// Format is jpeg, png, webp, avif or tiff. When empty on a processed
+	// request the server negotiates it from Accept.
+	Format      string
+	Quality     int
+	Lossless    bool
+	Compression string
+	// Scale is a radiometric stretch such as "minmax" or "percentile:1,99".
+	Scale             string
+	StarSuppress      bool
+	StarSuppressCell  int
+	StarSuppressSigma float64
+	// Overlay is banner text, or "none" to drop the server default.
+	Overlay         string
+	OverlayPosition string
+	OverlayOpacity  float64
+	Annotations     bool
+	StripMetadata   bool
+	// Verify has the server check the original against its recorded
+	// checksum before sending it. It cannot be combined with processing.
+	Verify bool
+}
+
+func (o *ImageOptions) values() url.Values {
+	q := url.Values{}
+	if o == nil {
+		return q
+	}
+	setInt := func(name string, v int) {
+		if v != 0 {
+			q.Set(name, strconv.Itoa(v))
+		}
+	}
+	setFloat := func(name string, v float64) {
+		if v != 0 {
+			q.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	setString := func(name, v string) {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	setBool := func(name string, v bool) {
+		if v {
+			q.Set(name, "true")
+		}
+	}
+	setInt("width", o.Width)
+	setInt("height", o.Height)
+	if !o.Crop.Empty() {
+		q.Set("crop", fmt.Sprintf("%d,%d,%d,%d", o.Crop.Min.X, o.Crop.Min.Y, o.Crop.Dx(), o.Crop.Dy()))
+	}
+	setFloat("contrast", o.Contrast)
+	setString("format", o.Format)
+	setInt("quality", o.Quality)
+	setBool("lossless", o.Lossless)
+	setString("compression", o.Compression)
+	setString("scale", o.Scale)
+	setBool("starsuppress", o.StarSuppress)
+	setInt("starsuppress_cell", o.StarSuppressCell)
+	setFloat("starsuppress_sigma", o.StarSuppressSigma)
+	setString("overlay", o.Overlay)
+	setString("overlay_position", o.OverlayPosition)
+	setFloat("overlay_opacity", o.OverlayOpacity)
+	setBool("annotations", o.Annotations)
+	setBool("stripMetadata", o.StripMetadata)
+	setBool("verify", o.Verify)
+	return q
+}
+
+// Object is a downloaded image or job output. The caller closes Body.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	// ContentLength is -1 when the server streamed the body.
+	ContentLength int64
+	ETag          string
+	// SHA256 is the hex checksum of the whole body, when the server sent one.
+	SHA256 string
+}
+
+func newObject(resp *http.Response) *Object {
+	return &Object{
+		Body:          resp.Body,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		ETag:          resp.Header.Get("ETag"),
+		SHA256:        resp.Header.Get("X-Content-SHA256"),
+	}
+}
+
+// GetImage downloads an image, processed as opts asks.
+func (c *Client) GetImage(ctx context.Context, id string, opts *ImageOptions) (*Object, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/image/"+url.PathEscape(id), opts.values(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return newObject(resp), nil
+}
+
+// GetThumbnail downloads the JPEG preview of an image at size 64, 128, 256
+// or 512 pixels, or the server default of 256 when size is 0.
+func (c *Client) GetThumbnail(ctx context.Context, id string, size int) (*Object, error) {
+	q := url.Values{}
+	if size != 0 {
+		q.Set("size", strconv.Itoa(size))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/image/"+url.PathEscape(id)+"/thumbnail", q, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newObject(resp), nil
+}
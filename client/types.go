@@ -0,0 +1,375 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// These mirror the JSON the server sends. The server's own types live in
+// package main, which cannot be imported, so a test there checks that the
+// field names here stay in step with it.
+
+type Mission struct {
+	ID                    string   `json:"id"`
+	Name                  string   `json:"name"`
+	Status                string   `json:"status"`
+	Priority              int      `json:"priority"`
+	TargetSatelliteID     string   `json:"target_satellite_id"`
+	ObserverSatelliteID   string   `json:"observer_satellite_id"`
+	TCA                   int64    `json:"tca"`
+	MinRangeKM            float64  `json:"min_range_km"`
+	RelativeVelocityKMS   float64  `json:"relative_velocity_kms,omitempty"`
+	CollectionWindowStart int64    `json:"collection_window_start"`
+	CollectionWindowEnd   int64    `json:"collection_window_end"`
+	CollectionType        string   `json:"collection_type"`
+	PointingTarget        string   `json:"pointing_target"`
+	ImageIDs              []string `json:"image_ids"`
+	// UpdatedAt is the Unix time of the mission's last change, or 0.
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+	// GeometryUpdatedAt is the Unix time TCA, MinRangeKM and
+	// RelativeVelocityKMS were last recomputed, or 0.
+	GeometryUpdatedAt int64 `json:"geometry_updated_at,omitempty"`
+	// Feasibility is scored at TCA when the geometry is recomputed.
+	Feasibility *Feasibility `json:"feasibility,omitempty"`
+	// Schedule is where the schedule optimizer last placed the collection.
+	Schedule *ScheduleAssignment `json:"schedule,omitempty"`
+}
+
+// Feasibility scores whether the observer can make a mission's collection.
+// Score runs from 0 to 1, and Issues lists the constraints that fail.
+type Feasibility struct {
+	Score            float64  `json:"score"`
+	Feasible         bool     `json:"feasible"`
+	Issues           []string `json:"issues,omitempty"`
+	PhaseAngleDeg    float64  `json:"phase_angle_deg"`
+	TargetSunlit     bool     `json:"target_sunlit"`
+	ObserverEclipsed bool     `json:"observer_eclipsed"`
+}
+
+// ScheduleAssignment is a mission's place in the observer's schedule, from
+// Start to End in Unix time. Rank is its priority order among the missions
+// scheduled together, and SlewDeg the turn from the previous collection.
+type ScheduleAssignment struct {
+	Start       int64   `json:"start"`
+	End         int64   `json:"end"`
+	Rank        int     `json:"rank"`
+	Frames      int     `json:"frames"`
+	DataMB      float64 `json:"data_mb"`
+	SlewDeg     float64 `json:"slew_deg"`
+	ScheduledAt int64   `json:"scheduled_at"`
+}
+
+// MissionPage is one page of GET /missions. NextToken is empty on the last
+// page.
+type MissionPage struct {
+	Missions  []Mission `json:"missions"`
+	NextToken string    `json:"nextToken,omitempty"`
+}
+
+type QualityMetrics struct {
+	Score        float64 `json:"score"`
+	BlurVariance float64 `json:"blur_variance"`
+	SNR          float64 `json:"snr_db"`
+	SaturatedPct float64 `json:"saturated_pct"`
+	Computed     int64   `json:"computed"`
+}
+
+type Photometry struct {
+	X           float64 `json:"x"`
+	Y           float64 `json:"y"`
+	FWHM        float64 `json:"fwhm"`
+	Flux        float64 `json:"flux"`
+	SNR         float64 `json:"snr"`
+	Peak        float64 `json:"peak"`
+	Background  float64 `json:"background"`
+	Aperture    float64 `json:"aperture"`
+	Saturated   bool    `json:"saturated"`
+	CaptureTime int64   `json:"capture_time"`
+	Measured    int64   `json:"measured"`
+}
+
+// Ingest is the outcome of ingesting an uploaded image. Status is
+// "ingested", "rejected", with Error saying why, or "duplicate" for an
+// upload deleted as a copy of DuplicateOf.
+type Ingest struct {
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Format      string          `json:"format,omitempty"`
+	Width       int             `json:"width,omitempty"`
+	Height      int             `json:"height,omitempty"`
+	Bytes       int64           `json:"bytes"`
+	SHA256      string          `json:"sha256,omitempty"`
+	Verified    bool            `json:"sha256_verified,omitempty"`
+	DuplicateOf *DuplicateMatch `json:"duplicate_of,omitempty"`
+	MissionID   string          `json:"mission_id,omitempty"`
+	// ContentAddressed says the upload is stored once for its checksum.
+	ContentAddressed bool  `json:"content_addressed,omitempty"`
+	Ingested         int64 `json:"ingested"`
+}
+
+// DuplicateMatch is the stored image an upload copies: Match is "exact" for
+// the same bytes or "near" for a perceptual hash Distance bits away.
+type DuplicateMatch struct {
+	ImageID  string `json:"image_id"`
+	Match    string `json:"match"`
+	Distance int    `json:"distance"`
+}
+
+// ImageVersion is one ingested upload of an image. Kept says a copy of it
+// was kept, so it can be downloaded after it is replaced.
+type ImageVersion struct {
+	Version    int         `json:"version"`
+	SHA256     string      `json:"sha256"`
+	Bytes      int64       `json:"bytes"`
+	Format     string      `json:"format,omitempty"`
+	Uploaded   int64       `json:"uploaded,omitempty"`
+	Ingested   int64       `json:"ingested"`
+	Kept       bool        `json:"kept,omitempty"`
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Provenance is how a version was made, as its uploader declared it.
+type Provenance struct {
+	SourceVersion string            `json:"source_version,omitempty"`
+	Software      string            `json:"software,omitempty"`
+	Processing    map[string]string `json:"processing,omitempty"`
+}
+
+// ImageVersions lists an image's versions, newest first.
+type ImageVersions struct {
+	ImageID  string         `json:"image_id"`
+	Versions []ImageVersion `json:"versions"`
+}
+
+// Footprint is the ground an image covers: BBox is west, south, east, north
+// in degrees, and Polygon its outline of [longitude, latitude] positions, or
+// one position for a point. Source is geotiff or exif.
+type Footprint struct {
+	BBox    [4]float64   `json:"bbox"`
+	Polygon [][2]float64 `json:"polygon"`
+	Source  string       `json:"source"`
+	CRS     string       `json:"crs,omitempty"`
+}
+
+// ImageRecord is an image's ingest-time metadata, as listed by
+// MissionImages.
+type ImageRecord struct {
+	ID            string               `json:"id"`
+	Quality       *QualityMetrics      `json:"quality,omitempty"`
+	Photometry    *Photometry          `json:"photometry,omitempty"`
+	Geometry      *ObservationGeometry `json:"geometry,omitempty"`
+	Contamination *Contamination       `json:"contamination,omitempty"`
+	WCS           *WCS                 `json:"wcs,omitempty"`
+	Detections    []Detection          `json:"detections,omitempty"`
+	Objects       *ObjectDetections    `json:"objects,omitempty"`
+	PHash         string               `json:"phash,omitempty"`
+	EXIF          map[string]string    `json:"exif,omitempty"`
+	FITS          map[string]string    `json:"fits,omitempty"`
+	Footprint     *Footprint           `json:"footprint,omitempty"`
+	Ingest        *Ingest              `json:"ingest,omitempty"`
+	// Versions are the image's ingested uploads, oldest first.
+	Versions []ImageVersion `json:"versions,omitempty"`
+	// Location is where the original is, when it was uploaded under
+	// another extension or placed elsewhere; nil is images/<id>.jpg in the
+	// server's images bucket.
+	Location *ObjectLocation `json:"location,omitempty"`
+	Updated  int64           `json:"updated,omitempty"`
+}
+
+// ObjectLocation is the bucket, region and key of an original that is not
+// images/<id>.jpg in the server's images bucket.
+type ObjectLocation struct {
+	Bucket string `json:"bucket"`
+	Region string `json:"region,omitempty"`
+	Key    string `json:"key"`
+	// ContentType is the original's, for an upload stored without one.
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Contamination is the percentage of an earth-background frame that is
+// cloud, and that is space beyond the Earth's limb.
+type Contamination struct {
+	CloudPct float64 `json:"cloud_pct"`
+	LimbPct  float64 `json:"limb_pct"`
+	Computed int64   `json:"computed"`
+}
+
+// ObjectDetections are the objects a detection model found in an image.
+type ObjectDetections struct {
+	Model    string            `json:"model"`
+	Detected int64             `json:"detected"`
+	Objects  []ObjectDetection `json:"objects"`
+}
+
+// ObjectDetection is one object found. Box is x0, y0, x1, y1 in
+// full-resolution pixels.
+type ObjectDetection struct {
+	Label      string       `json:"label"`
+	Confidence float64      `json:"confidence"`
+	Box        [4]float64   `json:"box"`
+	Polygon    [][2]float64 `json:"polygon,omitempty"`
+}
+
+// ObservationGeometry is how the mission's observer saw its target when an
+// image was captured. SunVector is in the sensor frame, with +Z on the
+// target; TargetRADeg and TargetDecDeg are on the TEME equator.
+type ObservationGeometry struct {
+	MissionID        string     `json:"mission_id"`
+	CaptureTime      int64      `json:"capture_time"`
+	RangeKM          float64    `json:"range_km"`
+	RangeRateKMS     float64    `json:"range_rate_kms"`
+	PhaseAngleDeg    float64    `json:"phase_angle_deg"`
+	SunVector        [3]float64 `json:"sun_vector"`
+	TargetRADeg      float64    `json:"target_ra_deg"`
+	TargetDecDeg     float64    `json:"target_dec_deg"`
+	TargetSunlit     bool       `json:"target_sunlit"`
+	ObserverEclipsed bool       `json:"observer_eclipsed"`
+	EarthBackground  bool       `json:"earth_background"`
+	TargetTLEEpoch   time.Time  `json:"target_tle_epoch"`
+	ObserverTLEEpoch time.Time  `json:"observer_tle_epoch"`
+	Coverage         *Coverage  `json:"coverage,omitempty"`
+	Computed         int64      `json:"computed"`
+}
+
+// Coverage is what the observer's sensor saw of the target: the span of a
+// pixel and of the field at its range, in metres, and the target's expected
+// extent in pixels from its radar cross-section.
+type Coverage struct {
+	RangeKM      float64    `json:"range_km"`
+	PixelScaleM  float64    `json:"pixel_scale_m"`
+	FootprintM   [2]float64 `json:"footprint_m"`
+	TargetPixels float64    `json:"target_pixels,omitempty"`
+	TargetFill   float64    `json:"target_fill,omitempty"`
+}
+
+// WCS is an image's plate solution in the FITS world coordinate system.
+// CRPix is 1-based; RADeg and DecDeg are the image centre, RollDeg the
+// position angle of the image's up direction and PlateScale in arcseconds
+// per pixel.
+type WCS struct {
+	Projection string        `json:"projection"`
+	CRPix      [2]float64    `json:"crpix"`
+	CRVal      [2]float64    `json:"crval"`
+	CD         [2][2]float64 `json:"cd"`
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	RADeg      float64       `json:"ra_deg"`
+	DecDeg     float64       `json:"dec_deg"`
+	RollDeg    float64       `json:"roll_deg"`
+	PlateScale float64       `json:"plate_scale_arcsec"`
+	Mirrored   bool          `json:"mirrored"`
+	Solver     string        `json:"solver"`
+	SolverJob  string        `json:"solver_job,omitempty"`
+	Solved     int64         `json:"solved"`
+}
+
+// Detection is one measured direction to the target, in ICRF. X and Y are
+// the pixel it was measured at, when it came from the image; Source is
+// photometry or analyst.
+type Detection struct {
+	Time   time.Time `json:"time"`
+	RADeg  float64   `json:"ra_deg"`
+	DecDeg float64   `json:"dec_deg"`
+	X      *float64  `json:"x,omitempty"`
+	Y      *float64  `json:"y,omitempty"`
+	SNR    float64   `json:"snr,omitempty"`
+	Source string    `json:"source"`
+}
+
+type MissionImages struct {
+	MissionID string        `json:"mission_id"`
+	Images    []ImageRecord `json:"images"`
+}
+
+type GeoCorners struct {
+	UpperLeft  [2]float64 `json:"upper_left"`
+	UpperRight [2]float64 `json:"upper_right"`
+	LowerLeft  [2]float64 `json:"lower_left"`
+	LowerRight [2]float64 `json:"lower_right"`
+	Center     [2]float64 `json:"center"`
+}
+
+// GeoInfo is the georeferencing of a GeoTIFF source.
+type GeoInfo struct {
+	CRS          string     `json:"crs,omitempty"`
+	GeoTransform [6]float64 `json:"geotransform"`
+	Corners      GeoCorners `json:"corners"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+}
+
+type ImageMetadata struct {
+	ID            string               `json:"id"`
+	Key           string               `json:"key"`
+	ContentType   string               `json:"content_type,omitempty"`
+	ContentLength int64                `json:"content_length"`
+	ETag          string               `json:"etag,omitempty"`
+	LastModified  int64                `json:"last_modified,omitempty"`
+	CaptureTime   int64                `json:"capture_time,omitempty"`
+	Format        string               `json:"format,omitempty"`
+	Width         int                  `json:"width"`
+	Height        int                  `json:"height"`
+	Geo           *GeoInfo             `json:"geo,omitempty"`
+	EXIF          map[string]string    `json:"exif,omitempty"`
+	FITS          map[string]string    `json:"fits,omitempty"`
+	Quality       *QualityMetrics      `json:"quality,omitempty"`
+	Photometry    *Photometry          `json:"photometry,omitempty"`
+	Geometry      *ObservationGeometry `json:"geometry,omitempty"`
+	Contamination *Contamination       `json:"contamination,omitempty"`
+	WCS           *WCS                 `json:"wcs,omitempty"`
+	Objects       *ObjectDetections    `json:"objects,omitempty"`
+	PHash         string               `json:"phash,omitempty"`
+	Footprint     *Footprint           `json:"footprint,omitempty"`
+	Ingest        *Ingest              `json:"ingest,omitempty"`
+}
+
+// Job states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+type JobItem struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Job struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	Status      string  `json:"status"`
+	Progress    float64 `json:"progress"`
+	Error       string  `json:"error,omitempty"`
+	Output      string  `json:"output,omitempty"`
+	ContentType string  `json:"content_type,omitempty"`
+	Attempts    int     `json:"attempts"`
+	MaxAttempts int     `json:"max_attempts"`
+	NextAttempt int64   `json:"next_attempt,omitempty"`
+	// Params is the job's input as submitted.
+	Params  json.RawMessage `json:"params,omitempty"`
+	Created int64           `json:"created"`
+	Updated int64           `json:"updated"`
+	Items   []JobItem       `json:"items,omitempty"`
+}
+
+// Done reports whether the job has finished, successfully or not.
+func (j *Job) Done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// JobPage is one page of GET /jobs. Listed jobs have no Items.
+type JobPage struct {
+	Jobs      []Job  `json:"jobs"`
+	NextToken string `json:"nextToken,omitempty"`
+}
+
+// JobAccepted is the reply to a request that started a job.
+type JobAccepted struct {
+	JobID     string `json:"job_id"`
+	Status    string `json:"status"`
+	StatusURL string `json:"status_url"`
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"sat-thumbnail-server/client"
+)
+
+// jsonFields lists the JSON member names t encodes, with nested structs as
+// parent.child.
+func jsonFields(t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil
+	}
+	var fields []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		fields = append(fields, prefix+name)
+		fields = append(fields, jsonFields(f.Type, prefix+name+".")...)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+func TestClientTypesMatchServer(t *testing.T) {
+	pairs := []struct {
+		server, client any
+	}{
+		{Mission{}, client.Mission{}},
+		{PaginatedMissionsResponse{}, client.MissionPage{}},
+		{MissionImagesResponse{}, client.MissionImages{}},
+		{ImageMetadata{}, client.ImageMetadata{}},
+		{ImageVersionsResponse{}, client.ImageVersions{}},
+		{Job{}, client.Job{}},
+		{JobListResponse{}, client.JobPage{}},
+		{JobAccepted{}, client.JobAccepted{}},
+	}
+	for _, p := range pairs {
+		st, ct := reflect.TypeOf(p.server), reflect.TypeOf(p.client)
+		if got, want := jsonFields(ct, ""), jsonFields(st, ""); !slices.Equal(got, want) {
+			t.Errorf("client.%s fields\n%v\nwant those of %s\n%v", ct.Name(), got, st.Name(), want)
+		}
+	}
+}
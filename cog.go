@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/image/tiff"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// cogHeaderBytes is how much of the object is peeked to find the IFDs.
+	// COGs keep every IFD ahead of the pixel data, usually well inside this.
+	cogHeaderBytes = 64 << 10
+	// cogMinBytes is the size below which reading the whole object is cheaper
+	// than issuing several ranged GETs.
+	cogMinBytes = 8 << 20
+	// cogRangeGap merges tile reads separated by less than this many bytes.
+	cogRangeGap = 32 << 10
+	// cogMaxSpanBytes caps a single ranged read. Larger runs of tiles are
+	// split, and a tile larger than this is treated as a corrupt file.
+	cogMaxSpanBytes  = 64 << 20
+	cogReadParallel  = 8
+	cogMaxOverviews  = 32
+	tagNewSubfile    = 254
+	tagCompression   = 259
+	tagStripOffsets  = 273
+	tagRowsPerStrip  = 278
+	tagStripCounts   = 279
+	tagPlanarConfig  = 284
+	tagTileWidth     = 322
+	tagTileLength    = 323
+	tagTileOffsets   = 324
+	tagTileByteCount = 325
+)
+
+var errNotCOG = errors.New("not a cloud optimized GeoTIFF")
+
+// cogCopiedTags are the pixel-format tags carried from a COG level into the
+// synthetic TIFF assembled from its tiles.
+var cogCopiedTags = map[uint16]bool{
+	258: true, // BitsPerSample
+	259: true, // Compression
+	262: true, // PhotometricInterpretation
+	277: true, // SamplesPerPixel
+	284: true, // PlanarConfiguration
+	317: true, // Predictor
+	320: true, // ColorMap
+	338: true, // ExtraSamples
+	339: true, // SampleFormat
+}
+
+// cogCompressions are the codecs golang.org/x/image/tiff can decode. JPEG-in-
+// TIFF COGs fall back to the full-object path.
+var cogCompressions = map[uint64]bool{1: true, 5: true, 8: true, 32773: true, 32946: true}
+
+func isTIFFHeader(head []byte) bool {
+	return len(head) >= 4 && (string(head[:4]) == "II*\x00" || string(head[:4]) == "MM\x00*")
+}
+
+// s3RangeReader is an io.ReaderAt over a stored object. Reads inside the
+// already-fetched head are served locally; anything else is a ranged GET.
+type s3RangeReader struct {
+	ctx    context.Context
+	client ObjectStore
+	bucket string
+	key    string
+	head   []byte
+}
+
+func (r *s3RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) <= int64(len(r.head)) {
+		return copy(p, r.head[off:]), nil
+	}
+
+	out, err := r.client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)),
+	}, s3Accelerate(r.bucket)...)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+	return io.ReadFull(out.Body, p)
+}
+
+// cogLevel is one resolution of the image. A stripped TIFF is treated as
+// tiled with full-width tiles one strip high, so crops of it read only the
+// rows they cover.
+type cogLevel struct {
+	width, height         int
+	tileWidth, tileHeight int
+	offsets, counts       []uint64
+	entries               []tiffEntry
+	strips                bool
+}
+
+type cogSource struct {
+	r      io.ReaderAt
+	size   int64
+	order  binary.ByteOrder
+	levels []cogLevel // full resolution first, then overviews by size
+	geo    *GeoInfo
+}
+
+// openCOG walks every IFD of a tiled or stripped TIFF of size bytes. It
+// returns errNotCOG for TIFFs that are planar or use a codec we cannot decode.
+func openCOG(r io.ReaderAt, size int64) (*cogSource, error) {
+	order, off, err := readTIFFHeader(r)
+	if err != nil {
+		return nil, errNotCOG
+	}
+
+	src := &cogSource{r: r, size: size, order: order}
+	for i := 0; off != 0 && i < cogMaxOverviews; i++ {
+		entries, next, err := readIFD(r, order, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+
+		byTag := make(map[uint16]tiffEntry, len(entries))
+		for _, e := range entries {
+			byTag[e.tag] = e
+		}
+		first := func(tag uint16, def uint64) uint64 {
+			if v := entryUints(order, byTag[tag]); len(v) > 0 {
+				return v[0]
+			}
+			return def
+		}
+
+		// Skip transparency masks; keep the main image and reduced overviews.
+		if first(tagNewSubfile, 0)&4 != 0 {
+			continue
+		}
+		_, tiled := byTag[tagTileOffsets]
+		_, stripped := byTag[tagStripOffsets]
+		if (!tiled && !stripped) || first(tagPlanarConfig, 1) != 1 || !cogCompressions[first(tagCompression, 1)] {
+			if len(src.levels) == 0 {
+				return nil, errNotCOG
+			}
+			continue
+		}
+
+		level := cogLevel{
+			width:      int(first(tagImageWidth, 0)),
+			height:     int(first(tagImageLength, 0)),
+			tileWidth:  int(first(tagTileWidth, 0)),
+			tileHeight: int(first(tagTileLength, 0)),
+			offsets:    entryUints(order, byTag[tagTileOffsets]),
+			counts:     entryUints(order, byTag[tagTileByteCount]),
+			entries:    entries,
+		}
+		if !tiled {
+			level.strips = true
+			level.tileWidth = level.width
+			level.tileHeight = int(min(first(tagRowsPerStrip, uint64(level.height)), uint64(level.height)))
+			level.offsets = entryUints(order, byTag[tagStripOffsets])
+			level.counts = entryUints(order, byTag[tagStripCounts])
+		}
+		across := (level.width + level.tileWidth - 1) / max(level.tileWidth, 1)
+		down := (level.height + level.tileHeight - 1) / max(level.tileHeight, 1)
+		if level.tileWidth == 0 || level.tileHeight == 0 || len(level.offsets) < across*down || len(level.counts) < across*down {
+			return nil, fmt.Errorf("tiff: malformed tile layout at IFD %d", i)
+		}
+		for t := range across * down {
+			off, n := level.offsets[t], level.counts[t]
+			if off > uint64(size) || n > uint64(size)-off || n > cogMaxSpanBytes {
+				return nil, fmt.Errorf("tiff: tile %d at IFD %d lies outside the %d-byte file", t, i, size)
+			}
+		}
+
+		if len(src.levels) == 0 {
+			src.geo = geoFromEntries(order, entries)
+		}
+		src.levels = append(src.levels, level)
+	}
+
+	if len(src.levels) == 0 {
+		return nil, errNotCOG
+	}
+	sort.SliceStable(src.levels, func(i, j int) bool { return src.levels[i].width > src.levels[j].width })
+	return src, nil
+}
+
+// pickLevel returns the smallest level that still covers region (in
+// full-resolution pixels) at no less than outW x outH.
+func (s *cogSource) pickLevel(region image.Rectangle, outW, outH int) int {
+	best := 0
+	for i, lv := range s.levels {
+		f := float64(s.levels[0].width) / float64(lv.width)
+		if float64(region.Dx())/f >= float64(outW) && float64(region.Dy())/f >= float64(outH) {
+			best = i
+		}
+	}
+	return best
+}
+
+// readRegion fetches only the tiles of level i that intersect r and decodes
+// them by wrapping them in a minimal tiled TIFF.
+func (s *cogSource) readRegion(ctx context.Context, i int, r image.Rectangle) (image.Image, error) {
+	lv := s.levels[i]
+	tw, th := lv.tileWidth, lv.tileHeight
+	across := (lv.width + tw - 1) / tw
+
+	tx0, ty0 := r.Min.X/tw, r.Min.Y/th
+	tx1, ty1 := (r.Max.X-1)/tw, (r.Max.Y-1)/th
+	cols, rows := tx1-tx0+1, ty1-ty0+1
+
+	type tileRef struct {
+		slot          int
+		offset, count int64
+	}
+	refs := make([]tileRef, 0, cols*rows)
+	var total int64
+	for ty := ty0; ty <= ty1; ty++ {
+		for tx := tx0; tx <= tx1; tx++ {
+			idx := ty*across + tx
+			refs = append(refs, tileRef{
+				slot:   (ty-ty0)*cols + (tx - tx0),
+				offset: int64(lv.offsets[idx]),
+				count:  int64(lv.counts[idx]),
+			})
+			total += int64(lv.counts[idx])
+		}
+	}
+	// Each tile lies inside the file, which openCOG checked, but tiles that
+	// overlap could still add up to more than it holds.
+	if total > s.size {
+		return nil, fmt.Errorf("tiff: %d bytes of tiles in a %d-byte file", total, s.size)
+	}
+
+	// Coalesce nearby tiles into spans so a region costs a few GETs rather
+	// than one per tile.
+	sort.Slice(refs, func(a, b int) bool { return refs[a].offset < refs[b].offset })
+	type span struct {
+		start, end int64
+		refs       []tileRef
+	}
+	var spans []span
+	for _, ref := range refs {
+		if n := len(spans); n > 0 && ref.offset <= spans[n-1].end+cogRangeGap && ref.offset+ref.count-spans[n-1].start <= cogMaxSpanBytes {
+			spans[n-1].end = max(spans[n-1].end, ref.offset+ref.count)
+			spans[n-1].refs = append(spans[n-1].refs, ref)
+			continue
+		}
+		spans = append(spans, span{start: ref.offset, end: ref.offset + ref.count, refs: []tileRef{ref}})
+	}
+
+	tiles := make([][]byte, cols*rows)
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(cogReadParallel)
+	for _, sp := range spans {
+		g.Go(func() error {
+			buf := make([]byte, sp.end-sp.start)
+			if _, err := s.r.ReadAt(buf, sp.start); err != nil {
+				return fmt.Errorf("read tiles at %d: %w", sp.start, err)
+			}
+			for _, ref := range sp.refs {
+				tiles[ref.slot] = buf[ref.offset-sp.start : ref.offset-sp.start+ref.count]
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(make([]byte, 8))
+	offsets := make([]uint32, len(tiles))
+	counts := make([]uint32, len(tiles))
+	for i, t := range tiles {
+		offsets[i] = uint32(out.Len())
+		counts[i] = uint32(len(t))
+		out.Write(t)
+	}
+
+	var entries []tiffEntry
+	for _, e := range lv.entries {
+		if cogCopiedTags[e.tag] {
+			entries = append(entries, e)
+		}
+	}
+	if lv.strips {
+		// The last strip of the image may be short, so the band ends
+		// where the image does.
+		entries = append(entries,
+			longEntry(s.order, tagImageWidth, uint32(tw)),
+			longEntry(s.order, tagImageLength, uint32(min((ty1+1)*th, lv.height)-ty0*th)),
+			longEntry(s.order, tagRowsPerStrip, uint32(th)),
+			longEntry(s.order, tagStripOffsets, offsets...),
+			longEntry(s.order, tagStripCounts, counts...),
+		)
+	} else {
+		entries = append(entries,
+			longEntry(s.order, tagImageWidth, uint32(cols*tw)),
+			longEntry(s.order, tagImageLength, uint32(rows*th)),
+			longEntry(s.order, tagTileWidth, uint32(tw)),
+			longEntry(s.order, tagTileLength, uint32(th)),
+			longEntry(s.order, tagTileOffsets, offsets...),
+			longEntry(s.order, tagTileByteCount, counts...),
+		)
+	}
+	ifd := appendIFD(&out, s.order, entries)
+
+	synthetic := out.Bytes()
+	if s.order == binary.LittleEndian {
+		copy(synthetic, "II*\x00")
+	} else {
+		copy(synthetic, "MM\x00*")
+	}
+	s.order.PutUint32(synthetic[4:8], uint32(ifd))
+
+	img, err := tiff.Decode(bytes.NewReader(synthetic))
+	if err != nil {
+		return nil, fmt.Errorf("decode tiles: %w", err)
+	}
+	return cropImage(img, r.Sub(image.Pt(tx0*tw, ty0*th))), nil
+}
+
+// renderCOG serves a crop/resize request from the overview closest to the
+// requested output size, reading only the tiles that cover the region. Like
+// renderProcessed it returns the share of api.Limiter to release after
+// encoding, sized to the tiles actually decoded.
+func (api *API) renderCOG(ctx context.Context, bucketName, key string, head []byte, size int64, opts ProcessOptions) (image.Image, *GeoInfo, func(), error) {
+	src, err := openCOG(&s3RangeReader{ctx: ctx, client: api.S3, bucket: bucketName, key: key, head: head}, size)
+	if err != nil {
+		// A TIFF whose IFDs cannot be walked may still decode in full.
+		if !errors.Is(err, errNotCOG) {
+			slog.WarnContext(ctx, "reading image in full", "key", key, "err", err)
+		}
+		return nil, nil, nil, errNotCOG
+	}
+
+	full := image.Rect(0, 0, src.levels[0].width, src.levels[0].height)
+	region := full
+	if opts.Crop != nil {
+		region = opts.Crop.Intersect(full)
+		if region.Empty() {
+			return nil, nil, nil, errCropOutside
+		}
+	}
+
+	outW, outH := opts.Width, opts.Height
+	switch {
+	case outW == 0 && outH == 0:
+		outW, outH = region.Dx(), region.Dy()
+	case outW == 0:
+		outW = int(math.Round(float64(outH) * float64(region.Dx()) / float64(region.Dy())))
+	case outH == 0:
+		outH = int(math.Round(float64(outW) * float64(region.Dy()) / float64(region.Dx())))
+	}
+
+	i := src.pickLevel(region, outW, outH)
+	lv := src.levels[i]
+	fx := float64(lv.width) / float64(full.Dx())
+	fy := float64(lv.height) / float64(full.Dy())
+	levelRegion := image.Rect(
+		int(math.Floor(float64(region.Min.X)*fx)),
+		int(math.Floor(float64(region.Min.Y)*fy)),
+		int(math.Ceil(float64(region.Max.X)*fx)),
+		int(math.Ceil(float64(region.Max.Y)*fy)),
+	).Intersect(image.Rect(0, 0, lv.width, lv.height))
+
+	pixels := lv.coveredPixels(levelRegion)
+	if err := checkPixels(pixels); err != nil {
+		return nil, nil, nil, err
+	}
+	release, err := api.Limiter.Acquire(ctx, pixels*bytesPerDecodedPixel)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	img, err := src.readRegion(ctx, i, levelRegion)
+	if err != nil {
+		release()
+		return nil, nil, nil, err
+	}
+
+	var geo *GeoInfo
+	if src.geo != nil && opts.Format.Name == "tiff" && !opts.StripMetadata {
+		geo = src.geo.cropped(region)
+	}
+
+	return processImage(img, region, opts), geo, release, nil
+}
+
+// coveredPixels is the size of the tile grid readRegion decodes for r.
+func (lv cogLevel) coveredPixels(r image.Rectangle) int64 {
+	tw, th := lv.tileWidth, lv.tileHeight
+	cols := (r.Max.X-1)/tw - r.Min.X/tw + 1
+	rows := (r.Max.Y-1)/th - r.Min.Y/th + 1
+	return int64(cols*tw) * int64(rows*th)
+}
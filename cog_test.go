@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func testPixel(x, y int) uint8 { return uint8(x*5 + y*3) }
+
+func shortsEntry(order binary.ByteOrder, tag uint16, values ...uint16) tiffEntry {
+	e := tiffEntry{tag: tag, datatype: tiffShort, count: uint32(len(values)), value: make([]byte, 2*len(values))}
+	for i, v := range values {
+		order.PutUint16(e.value[i*2:], v)
+	}
+	return e
+}
+
+type testLevel struct {
+	width, height, tile int
+	compression         uint16
+	// shareTiles points every tile at the first one's bytes.
+	shareTiles bool
+}
+
+// testCOG builds an uncompressed 8-bit grayscale tiled TIFF with one IFD
+// per level. Level i holds testPixel scaled down by 2^i.
+func testCOG(levels ...testLevel) []byte {
+	order := binary.LittleEndian
+	var out bytes.Buffer
+	out.WriteString("II*\x00\x00\x00\x00\x00")
+	prevNext := 4
+	for i, lv := range levels {
+		var offsets, counts []uint32
+		for ty := 0; ty*lv.tile < lv.height; ty++ {
+			for tx := 0; tx*lv.tile < lv.width; tx++ {
+				if lv.shareTiles && len(offsets) > 0 {
+					offsets = append(offsets, offsets[0])
+					counts = append(counts, counts[0])
+					continue
+				}
+				offsets = append(offsets, uint32(out.Len()))
+				counts = append(counts, uint32(lv.tile*lv.tile))
+				for y := range lv.tile {
+					for x := range lv.tile {
+						out.WriteByte(testPixel((tx*lv.tile+x)<<i, (ty*lv.tile+y)<<i))
+					}
+				}
+			}
+		}
+		compression := lv.compression
+		if compression == 0 {
+			compression = 1
+		}
+		entries := []tiffEntry{
+			longEntry(order, tagImageWidth, uint32(lv.width)),
+			longEntry(order, tagImageLength, uint32(lv.height)),
+			shortsEntry(order, 258, 8),
+			shortsEntry(order, tagCompression, compression),
+			shortsEntry(order, 262, 1),
+			shortsEntry(order, 277, 1),
+			longEntry(order, tagTileWidth, uint32(lv.tile)),
+			longEntry(order, tagTileLength, uint32(lv.tile)),
+			longEntry(order, tagTileOffsets, offsets...),
+			longEntry(order, tagTileByteCount, counts...),
+		}
+		if i > 0 {
+			entries = append(entries, longEntry(order, tagNewSubfile, 1))
+		}
+		ifd := appendIFD(&out, order, entries)
+		order.PutUint32(out.Bytes()[prevNext:], uint32(ifd))
+		prevNext = ifd + 2 + 12*len(entries)
+	}
+	return out.Bytes()
+}
+
+func TestOpenCOG(t *testing.T) {
+	data := testCOG(testLevel{width: 64, height: 48, tile: 16}, testLevel{width: 32, height: 24, tile: 16})
+	src, err := openCOG(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.levels) != 2 {
+		t.Fatalf("found %d levels, want 2", len(src.levels))
+	}
+	for i, want := range [][4]int{{64, 48, 16, 16}, {32, 24, 16, 16}} {
+		lv := src.levels[i]
+		if got := [4]int{lv.width, lv.height, lv.tileWidth, lv.tileHeight}; got != want || lv.strips {
+			t.Errorf("level %d = %v strips=%v, want %v", i, got, lv.strips, want)
+		}
+	}
+	if got := src.pickLevel(image.Rect(0, 0, 64, 48), 20, 10); got != 1 {
+		t.Errorf("pickLevel for a small output = %d, want 1", got)
+	}
+	if got := src.pickLevel(image.Rect(0, 0, 64, 48), 60, 40); got != 0 {
+		t.Errorf("pickLevel for a full-size output = %d, want 0", got)
+	}
+}
+
+func TestCOGReadRegion(t *testing.T) {
+	data := testCOG(testLevel{width: 64, height: 48, tile: 16}, testLevel{width: 32, height: 24, tile: 16})
+	src, err := openCOG(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		level int
+		r     image.Rectangle
+	}{
+		{0, image.Rect(5, 7, 40, 30)},
+		{0, image.Rect(0, 0, 64, 48)},
+		{0, image.Rect(48, 32, 64, 48)},
+		{1, image.Rect(3, 3, 29, 20)},
+	} {
+		img, err := src.readRegion(context.Background(), tt.level, tt.r)
+		if err != nil {
+			t.Fatalf("readRegion(%d, %v): %v", tt.level, tt.r, err)
+		}
+		if img.Bounds().Size() != tt.r.Size() {
+			t.Fatalf("readRegion(%d, %v) is %v", tt.level, tt.r, img.Bounds())
+		}
+		b := img.Bounds()
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				got := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+				want := testPixel((tt.r.Min.X+x)<<tt.level, (tt.r.Min.Y+y)<<tt.level)
+				if got != want {
+					t.Fatalf("readRegion(%d, %v) pixel %d,%d = %d, want %d", tt.level, tt.r, x, y, got, want)
+				}
+			}
+		}
+	}
+	if got := src.levels[0].coveredPixels(image.Rect(5, 7, 40, 30)); got != 48*32 {
+		t.Errorf("coveredPixels = %d, want %d", got, 48*32)
+	}
+}
+
+func TestOpenCOGRejects(t *testing.T) {
+	tiled := testCOG(testLevel{width: 64, height: 48, tile: 16})
+
+	if _, err := openCOG(bytes.NewReader([]byte("\x89PNG\r\n\x1a\n")), 8); !errors.Is(err, errNotCOG) {
+		t.Errorf("PNG error = %v, want errNotCOG", err)
+	}
+	jpegTIFF := testCOG(testLevel{width: 64, height: 48, tile: 16, compression: 7})
+	if _, err := openCOG(bytes.NewReader(jpegTIFF), int64(len(jpegTIFF))); !errors.Is(err, errNotCOG) {
+		t.Errorf("JPEG-in-TIFF error = %v, want errNotCOG", err)
+	}
+
+	// A size smaller than the file puts the last tiles past its end.
+	if _, err := openCOG(bytes.NewReader(tiled), 1000); err == nil || errors.Is(err, errNotCOG) {
+		t.Errorf("tiles past the end of the object: error = %v", err)
+	}
+
+	// Tiles overlapping one another are each inside the file, but a region
+	// covering all of them would read more bytes than it holds.
+	shared := testCOG(testLevel{width: 64, height: 48, tile: 16, shareTiles: true})
+	src, err := openCOG(bytes.NewReader(shared), int64(len(shared)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.readRegion(context.Background(), 0, image.Rect(0, 0, 64, 48)); err == nil {
+		t.Error("read tiles adding up to more than the file holds")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bodies smaller than this go out as they are; gzip framing would eat most
+// of the saving.
+const minCompressBytes = 1024
+
+var gzipPool = sync.Pool{New: func() any {
+	gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+	return gz
+}}
+
+// compressResponses gzips JSON, CSV and other text responses for clients
+// that accept it. Images, archives and video are already compressed and are
+// passed through untouched, as are byte ranges and bodies below
+// minCompressBytes.
+func compressResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &gzipWriter{
+			ResponseWriter: c.Writer,
+			accepts:        acceptsGzip(c.GetHeader("Accept-Encoding")),
+		}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through "*", with a non-zero quality.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressible reports whether a response of this Content-Type is worth
+// compressing.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || mt == "application/json" ||
+		strings.HasSuffix(mt, "+json") || mt == "application/xml" || mt == "image/svg+xml"
+}
+
+// gzipWriter decides on the first write, once the handler has set its
+// headers, whether to compress the body.
+type gzipWriter struct {
+	gin.ResponseWriter
+	accepts bool
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) decide(n int) {
+	w.decided = true
+	h := w.Header()
+	if !compressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	status := w.Status()
+	if !w.accepts || n < minCompressBytes || h.Get("Content-Encoding") != "" ||
+		status == http.StatusPartialContent || status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	// The compressed bytes are a different representation, so a strong
+	// validator becomes weak. If-None-Match compares weakly and still matches.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.gz = gzipPool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decide(len(p))
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.gz.Write(p)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipPool.Put(w.gz)
+	w.gz = nil
+}
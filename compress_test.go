@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=1.0, br", true},
+		{"br, deflate", false},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.5", true},
+		{"gzip;q=0, *", true},
+		{"identity", false},
+		{"gzipped", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
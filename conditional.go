@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etagMatches reports whether an If-None-Match header lists etag. The
+// comparison is weak, as RFC 9110 requires for If-None-Match, so W/ prefixes
+// are ignored on both sides.
+func etagMatches(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers the request with 304 if its If-None-Match names etag
+// or, when it sends no If-None-Match, if lastModified is no later than its
+// If-Modified-Since. Callers set the validator and caching headers first so
+// they go out with the 304 too.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	match := false
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		match = etagMatches(inm, etag)
+	} else if ims := c.GetHeader("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		match = err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	if !match {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// conditionalJSON writes v as indented JSON with an ETag hashed from the
+// body, answering 304 when the client's copy is current. lastModified may be
+// zero when the data has no modification time. Clients are told to
+// revalidate every time, which is what makes polling cheap.
+func conditionalJSON(c *gin.Context, v any, lastModified time.Time) {
+	body, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to encode response", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to encode response")
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	c.Header("Cache-Control", "private, no-cache")
+	if notModified(c, etag, lastModified) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header, etag string
+		want         bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`"abc"`, `"abd"`, false},
+		{`"x", "abc"`, `"abc"`, true},
+		{`"x",  "y"`, `"abc"`, false},
+		{`*`, `"abc"`, true},
+		{`W/"abc"`, `"abc"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{`W/"abc"`, `W/"abc"`, true},
+		{``, `"abc"`, false},
+		{`"abc"`, ``, false},
+		{`abc`, `"abc"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestProcessedETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, store, imageKey("a"), frame)
+	for _, memory := range []*MemoryCache{nil, newMemoryCache(defaultMemoryCacheMB<<20, defaultMemoryCacheTTL)} {
+		api := &API{
+			Config: &Config{ImagesBucket: "bucket", Limits: RequestLimits{
+				MaxDimension: defaultMaxOutputDimension, MaxCropPixels: defaultMaxImagePixels,
+				MaxBodyBytes: defaultMaxBodyBytes, MaxOps: defaultMaxRequestOps,
+			}},
+			S3:      store,
+			Limiter: newProcessLimiter(0, defaultQueueTimeout),
+			Memory:  memory,
+		}
+		router := gin.New()
+		router.GET("/image/:id", api.getSatImageByID)
+		get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			if ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			router.ServeHTTP(w, r)
+			return w
+		}
+
+		w := get("/image/a?width=64&format=png", "")
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" || w.Header().Get("Vary") != "" {
+			t.Fatalf("processed: %d, ETag %q, Vary %q", w.Code, etag, w.Header().Get("Vary"))
+		}
+		if got := get("/image/a?format=png&width=64", "").Header().Get("ETag"); got != etag {
+			t.Errorf("reordered query has ETag %s, want %s", got, etag)
+		}
+		if got := get("/image/a?width=65&format=png", "").Header().Get("ETag"); got == etag {
+			t.Errorf("another width has ETag %s too", got)
+		}
+		if w := get("/image/a?width=64&format=png", `"other", `+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match: %d, %d bytes, ETag %q", w.Code, w.Body.Len(), w.Header().Get("ETag"))
+		}
+		if w := get("/image/a?width=64&format=png", `"other"`); w.Code != http.StatusOK {
+			t.Errorf("stale If-None-Match: %d", w.Code)
+		}
+		if w := get("/image/a?width=64", ""); w.Header().Get("Vary") != "Accept" {
+			t.Errorf("negotiated format has Vary %q", w.Header().Get("Vary"))
+		}
+	}
+}
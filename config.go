@@ -0,0 +1,670 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+const defaultCORSOrigin = "https://mission.austinlopez.work"
+
+// Config holds every setting, loaded and validated once at startup and
+// passed to the components that need it.
+type Config struct {
+	Port          int
+	ImagesBucket  string
+	MissionTable  string
+	ImageTable    string
+	JobsTable     string
+	WebhooksTable string
+	APIKeysTable  string
+	RolesTable    string
+	AuditTable    string
+	UsageTable    string
+	StorageTable  string
+	FlagsTable    string
+	// SatellitesTable holds the satellite catalog; empty keeps it in
+	// memory.
+	SatellitesTable string
+	// TLETable holds the satellites' TLE history; empty keeps it in
+	// memory.
+	TLETable string
+	// ConjunctionsTable holds the conjunctions read from CDMs; empty keeps
+	// them in memory.
+	ConjunctionsTable string
+	// SensorsTable holds the observer satellites' sensors; empty keeps them
+	// in memory.
+	SensorsTable string
+	// DownlinksTable holds the downlinks recorded for missions; empty keeps
+	// them in memory.
+	DownlinksTable string
+	// DownlinkGrace is how long after its collection window a mission may
+	// go without a downlink before it is overdue.
+	DownlinkGrace time.Duration
+	// IntegrityInterval is how often every tenant's images are checked
+	// against their records and missions, making IntegrityRepair; 0 turns
+	// the scheduled checks off.
+	IntegrityInterval time.Duration
+	IntegrityRepair   []string
+	// HistoryTable holds when missions were created and changed
+	// status; empty keeps it in memory.
+	HistoryTable string
+	// HashesTable holds the images' perceptual hashes, searched by GET
+	// /images/similar/:id; empty keeps them in memory.
+	HashesTable string
+	// FootprintsTable indexes the images' footprints by geohash cell for
+	// GET /images/search; empty keeps them in memory.
+	FootprintsTable string
+	// CDMProposalPc is the least collision probability for which POST /cdm
+	// proposes a mission.
+	CDMProposalPc float64
+	// PlateSolveURL is the astrometry.net server that plate solves images,
+	// authenticated with PlateSolveAPIKey; empty turns plate solving off.
+	PlateSolveURL    string
+	PlateSolveAPIKey string
+	// TLE is where satellites' TLEs are fetched from.
+	TLE TLEConfig
+	// Detector is the model that finds objects in ingested images.
+	Detector DetectorConfig
+	// Lifecycle tiers originals and expires derivatives by age.
+	Lifecycle LifecycleConfig
+	// Layout places originals by IMAGE_KEY_TEMPLATE and BUCKET_ROUTES.
+	Layout LayoutConfig
+	// FeatureFlags are the feature flags' defaults, from FEATURE_FLAGS.
+	FeatureFlags map[string]FeatureFlag
+	// MissionStreamARN is the DynamoDB stream of MissionTable to read
+	// changes from; empty leaves it unread.
+	MissionStreamARN string
+	// EventsARN is the SNS topic or EventBridge bus the events are
+	// published to; empty publishes them only to this server's clients.
+	EventsARN string
+	// IngestMissionPattern matches the IDs of ingested images, its first
+	// group capturing the ID of the mission to link each to; nil links
+	// none.
+	IngestMissionPattern *regexp.Regexp
+	// Duplicates is what ingest does with an upload that copies a stored
+	// image: keep, link or reject it. DuplicateDistance is the most
+	// perceptual hash bits a near copy differs in.
+	Duplicates        string
+	DuplicateDistance int
+	// ContentAddressed stores ingested originals once per checksum, with
+	// the references to each counted in ContentRefsTable; empty keeps the
+	// counts in memory.
+	ContentAddressed bool
+	ContentRefsTable string
+	// KeepImageVersions keeps a copy of each ingested upload, so earlier
+	// versions of a reprocessed image can still be downloaded.
+	KeepImageVersions bool
+	AdminToken        string
+	// MaintenanceToken grants only ScopeMaintenance.
+	MaintenanceToken string
+	// OIDC is the identity provider whose tokens are accepted.
+	OIDC OIDCConfig
+	// AuthRequired refuses requests without credentials, which may
+	// otherwise read and render imagery.
+	AuthRequired bool
+	// MultiTenant scopes every request's data to the tenant its
+	// credentials act for; DefaultTenant is that of anonymous callers and
+	// of credentials without one.
+	MultiTenant   bool
+	DefaultTenant string
+	// URLSigningKeys sign image URLs that work without credentials. The
+	// first signs new URLs; all of them are accepted.
+	URLSigningKeys []string
+	// Encryption is how objects the server writes are encrypted at rest.
+	Encryption      EncryptionConfig
+	CORS            CORSConfig
+	StorageBackend  string
+	StorageEndpoint string
+	StorageRoot     string
+	MetadataBackend string
+	DatabaseURL     string
+	// RequestTimeout bounds the JSON endpoints and ProcessingTimeout the
+	// ones that read or render imagery; zero disables either.
+	RequestTimeout    time.Duration
+	ProcessingTimeout time.Duration
+	Limits            RequestLimits
+	// MaxImagePixels is the largest source, in pixels, that is decoded in
+	// full.
+	MaxImagePixels int64
+	// FITSScale is the stretch FITS data is decoded to 16 bits with.
+	FITSScale RadiometricScale
+	// RateLimits are the requests each client may make per route class;
+	// a class without one is unlimited.
+	RateLimits map[string]RateLimit
+	// LegacyRoutes serves the v1 routes at their unversioned paths as
+	// well, marked deprecated with LegacySunset as their removal date.
+	LegacyRoutes bool
+	LegacySunset time.Time
+	// SwaggerUI serves an interactive view of /openapi.json at /docs.
+	SwaggerUI bool
+	// GRPCPort is where the gRPC service listens; zero leaves it off.
+	GRPCPort int
+	// TLS is how both listeners terminate TLS, if they do.
+	TLS TLSConfig
+
+	// MemoryCacheBytes bounds the per-instance cache of responses, whose
+	// entries live for MemoryCacheTTL; 0 turns it off.
+	MemoryCacheBytes int64
+	MemoryCacheTTL   time.Duration
+	// ProcessMemory is the byte budget of concurrent image processing; 0
+	// makes it half the container's memory limit. A request waits up to
+	// ProcessQueueTimeout for its share.
+	ProcessMemory       int64
+	ProcessQueueTimeout time.Duration
+	// ProcessWorkers, DerivativeWorkers and JobWorkers size the pools of
+	// batch images, derivative generation and background jobs.
+	ProcessWorkers    int
+	DerivativeWorkers int
+	JobWorkers        int
+	// DerivativesQueueURL is the SQS queue of upload notifications to
+	// generate derivatives for; empty leaves it unpolled.
+	DerivativesQueueURL string
+	// JobMaxAttempts and WebhookMaxAttempts are how often a job is run and
+	// a webhook delivery sent before it is given up on.
+	JobMaxAttempts     int
+	WebhookMaxAttempts int
+	// JobsStatusIndex is JobsTable's global secondary index on status.
+	JobsStatusIndex string
+	// DerivedCacheTTL is how long an unused processed response is kept in
+	// the bucket, 0 turning the cache off, and DerivedCacheMaxBytes how
+	// large the cache may grow, 0 leaving it unbounded.
+	DerivedCacheTTL      time.Duration
+	DerivedCacheMaxBytes int64
+	// MissionCacheTTL is how long missions are cached; 0 turns it off.
+	MissionCacheTTL time.Duration
+	// RedisURL is the Redis shared by every instance's mission cache;
+	// empty caches per instance.
+	RedisURL string
+	// S3AccelerateBuckets are read through S3 Transfer Acceleration; "*"
+	// names every bucket.
+	S3AccelerateBuckets []string
+	// DownloadPartSize and DownloadConcurrency are the part size and number
+	// of parallel ranged GETs large objects are read with.
+	DownloadPartSize    int64
+	DownloadConcurrency int
+	// Overlay is the banner stamped on processed imagery; nil stamps none.
+	Overlay *OverlaySpec
+}
+
+// settingName is the form of the keys in a config file, which are the
+// environment variable names.
+var settingName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// loadConfig reads the configuration from the environment, after filling in
+// whatever it leaves unset from the YAML file at path, if any, and then from
+// the -local defaults when local is set. The file is a flat map from
+// environment variable names to values, with lists joined by commas:
+//
+//	SAT_IMAGES_BUCKET: sat-images
+//	MISSION_TABLE: missions
+//	CORS_ORIGINS: [https://a.example.com, https://b.example.com]
+//
+// Every problem found is reported at once rather than one per restart.
+func loadConfig(path string, local bool) (*Config, error) {
+	if path != "" {
+		if err := applyConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if local {
+		applyLocalDefaults()
+	}
+
+	cfg := &Config{
+		Port:              8080,
+		ImagesBucket:      os.Getenv("SAT_IMAGES_BUCKET"),
+		MissionTable:      os.Getenv("MISSION_TABLE"),
+		ImageTable:        os.Getenv("IMAGE_TABLE"),
+		JobsTable:         os.Getenv("JOBS_TABLE"),
+		WebhooksTable:     os.Getenv("WEBHOOKS_TABLE"),
+		APIKeysTable:      os.Getenv("API_KEYS_TABLE"),
+		RolesTable:        os.Getenv("ROLES_TABLE"),
+		AuditTable:        os.Getenv("AUDIT_TABLE"),
+		UsageTable:        os.Getenv("USAGE_TABLE"),
+		StorageTable:      os.Getenv("STORAGE_TABLE"),
+		FlagsTable:        os.Getenv("FEATURE_FLAGS_TABLE"),
+		SatellitesTable:   os.Getenv("SATELLITES_TABLE"),
+		TLETable:          os.Getenv("TLE_TABLE"),
+		ConjunctionsTable: os.Getenv("CONJUNCTIONS_TABLE"),
+		SensorsTable:      os.Getenv("SENSORS_TABLE"),
+		DownlinksTable:    os.Getenv("DOWNLINKS_TABLE"),
+		DownlinkGrace:     defaultDownlinkGrace,
+		HistoryTable:      os.Getenv("MISSION_HISTORY_TABLE"),
+		HashesTable:       os.Getenv("IMAGE_HASHES_TABLE"),
+		FootprintsTable:   os.Getenv("IMAGE_FOOTPRINTS_TABLE"),
+		ContentRefsTable:  os.Getenv("CONTENT_REFS_TABLE"),
+		CDMProposalPc:     defaultCDMProposalPc,
+		PlateSolveURL:     os.Getenv("PLATESOLVE_URL"),
+		PlateSolveAPIKey:  os.Getenv("PLATESOLVE_API_KEY"),
+		MissionStreamARN:  os.Getenv("MISSION_STREAM_ARN"),
+		EventsARN:         os.Getenv("EVENTS_ARN"),
+		AdminToken:        os.Getenv("ADMIN_TOKEN"),
+		MaintenanceToken:  os.Getenv("MAINTENANCE_TOKEN"),
+		StorageBackend:    strings.ToLower(os.Getenv("STORAGE_BACKEND")),
+		StorageEndpoint:   os.Getenv("STORAGE_ENDPOINT"),
+		StorageRoot:       os.Getenv("STORAGE_ROOT"),
+		MetadataBackend:   strings.ToLower(os.Getenv("METADATA_BACKEND")),
+		DatabaseURL:       os.Getenv("DATABASE_URL"),
+		DefaultTenant:     os.Getenv("DEFAULT_TENANT"),
+		JobsStatusIndex:   cmp.Or(os.Getenv("JOBS_STATUS_INDEX"), defaultJobStatusIndex),
+		RedisURL:          os.Getenv("REDIS_URL"),
+
+		DerivativesQueueURL: os.Getenv("DERIVATIVES_QUEUE_URL"),
+		S3AccelerateBuckets: splitList(os.Getenv("S3_ACCELERATE_BUCKETS")),
+
+		RequestTimeout:    defaultRequestTimeout,
+		ProcessingTimeout: defaultProcessingTimeout,
+		Limits: RequestLimits{
+			MaxDimension:  defaultMaxOutputDimension,
+			MaxCropPixels: defaultMaxImagePixels,
+			MaxBodyBytes:  defaultMaxBodyBytes,
+			MaxOps:        defaultMaxRequestOps,
+		},
+		MaxImagePixels: defaultMaxImagePixels,
+		FITSScale:      defaultFITSScale,
+		LegacyRoutes:   true,
+		LegacySunset:   defaultLegacySunset,
+
+		MemoryCacheBytes:    defaultMemoryCacheMB << 20,
+		MemoryCacheTTL:      defaultMemoryCacheTTL,
+		ProcessQueueTimeout: defaultQueueTimeout,
+		ProcessWorkers:      defaultProcessWorkers,
+		DerivativeWorkers:   defaultDerivativeWorkers,
+		JobWorkers:          defaultJobWorkers,
+		JobMaxAttempts:      defaultJobAttempts,
+		WebhookMaxAttempts:  defaultWebhookAttempts,
+		DerivedCacheTTL:     defaultDerivedTTL,
+		MissionCacheTTL:     defaultMissionCacheTTL,
+		DownloadPartSize:    defaultDownloadPartMB << 20,
+		DownloadConcurrency: defaultDownloadConcurrency,
+	}
+	if cfg.StorageBackend == "" {
+		cfg.StorageBackend = "s3"
+	}
+	if cfg.MetadataBackend == "" {
+		cfg.MetadataBackend = "dynamodb"
+	}
+	if cfg.DefaultTenant == "" {
+		cfg.DefaultTenant = defaultTenant
+	}
+
+	var errs []error
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{
+		{"PORT", &cfg.Port},
+		{"GRPC_PORT", &cfg.GRPCPort},
+	} {
+		if v := os.Getenv(p.name); v != "" {
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 1 || port > 65535 {
+				errs = append(errs, fmt.Errorf("%s %q is not a port number", p.name, v))
+			}
+			*p.dst = port
+		}
+	}
+	if cfg.GRPCPort != 0 && cfg.GRPCPort == cfg.Port {
+		errs = append(errs, fmt.Errorf("GRPC_PORT %d is the same as PORT", cfg.GRPCPort))
+	}
+	for _, t := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"REQUEST_TIMEOUT", &cfg.RequestTimeout},
+		{"PROCESSING_TIMEOUT", &cfg.ProcessingTimeout},
+		{"DOWNLINK_GRACE", &cfg.DownlinkGrace},
+		{"INTEGRITY_INTERVAL", &cfg.IntegrityInterval},
+		{"MEMORY_CACHE_TTL", &cfg.MemoryCacheTTL},
+		{"PROCESS_QUEUE_TIMEOUT", &cfg.ProcessQueueTimeout},
+		{"DERIVED_CACHE_TTL", &cfg.DerivedCacheTTL},
+		{"MISSION_CACHE_TTL", &cfg.MissionCacheTTL},
+	} {
+		if v := os.Getenv(t.name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("%s %q is not a duration", t.name, v))
+			}
+			*t.dst = d
+		}
+	}
+	for _, l := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"MAX_OUTPUT_DIMENSION", &cfg.Limits.MaxDimension},
+		{"MAX_CROP_PIXELS", &cfg.Limits.MaxCropPixels},
+		{"MAX_BODY_BYTES", &cfg.Limits.MaxBodyBytes},
+		{"MAX_REQUEST_OPS", &cfg.Limits.MaxOps},
+		{"MAX_IMAGE_PIXELS", &cfg.MaxImagePixels},
+	} {
+		if v := os.Getenv(l.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				errs = append(errs, fmt.Errorf("%s %q is not a positive integer", l.name, v))
+			}
+			*l.dst = n
+		}
+	}
+	for _, n := range []struct {
+		name string
+		dst  *int
+	}{
+		{"PROCESS_WORKERS", &cfg.ProcessWorkers},
+		{"DERIVATIVE_WORKERS", &cfg.DerivativeWorkers},
+		{"JOB_WORKERS", &cfg.JobWorkers},
+		{"JOB_MAX_ATTEMPTS", &cfg.JobMaxAttempts},
+		{"WEBHOOK_MAX_ATTEMPTS", &cfg.WebhookMaxAttempts},
+		{"S3_DOWNLOAD_CONCURRENCY", &cfg.DownloadConcurrency},
+	} {
+		if v := os.Getenv(n.name); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i <= 0 {
+				errs = append(errs, fmt.Errorf("%s %q is not a positive integer", n.name, v))
+			}
+			*n.dst = i
+		}
+	}
+	// Sizes are set in MB. Zero turns the memory cache off and leaves the
+	// derived cache unbounded; the others must be positive.
+	for _, s := range []struct {
+		name   string
+		dst    *int64
+		zeroOK bool
+	}{
+		{"MEMORY_CACHE_MB", &cfg.MemoryCacheBytes, true},
+		{"PROCESS_MEMORY_MB", &cfg.ProcessMemory, false},
+		{"DERIVED_CACHE_MAX_MB", &cfg.DerivedCacheMaxBytes, true},
+		{"S3_DOWNLOAD_PART_MB", &cfg.DownloadPartSize, false},
+	} {
+		if v := os.Getenv(s.name); v != "" {
+			mb, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || mb < 0 || mb == 0 && !s.zeroOK || mb > math.MaxInt64>>20 {
+				errs = append(errs, fmt.Errorf("%s %q is not a size in MB", s.name, v))
+			}
+			*s.dst = mb << 20
+		}
+	}
+	if cfg.RedisURL != "" {
+		if _, err := newRedisCache(cfg.RedisURL); err != nil {
+			errs = append(errs, fmt.Errorf("REDIS_URL is not a redis:// or rediss:// URL: %w", err))
+		}
+	}
+	for _, b := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"LEGACY_ROUTES", &cfg.LegacyRoutes},
+		{"SWAGGER_UI", &cfg.SwaggerUI},
+		{"AUTH_REQUIRED", &cfg.AuthRequired},
+		{"MULTI_TENANT", &cfg.MultiTenant},
+		{"CONTENT_ADDRESSED_STORAGE", &cfg.ContentAddressed},
+		{"KEEP_IMAGE_VERSIONS", &cfg.KeepImageVersions},
+	} {
+		if v := os.Getenv(b.name); v != "" {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q is not a boolean", b.name, v))
+			}
+			*b.dst = enabled
+		}
+	}
+	cfg.URLSigningKeys = splitList(os.Getenv("URL_SIGNING_KEYS"))
+	for i, key := range cfg.URLSigningKeys {
+		if len(key) < minSigningKeyLen {
+			errs = append(errs, fmt.Errorf("URL_SIGNING_KEYS entry %d is shorter than %d characters", i+1, minSigningKeyLen))
+		}
+	}
+	cfg.RateLimits = make(map[string]RateLimit)
+	for _, class := range rateClasses {
+		name := "RATE_LIMIT_" + strings.ToUpper(class)
+		if v := os.Getenv(name); v != "" {
+			limit, err := parseRateLimit(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %w", name, err))
+			}
+			cfg.RateLimits[class] = limit
+		}
+	}
+	if v := os.Getenv("CDM_PROPOSAL_PC"); v != "" {
+		pc, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(pc >= 0 && pc <= 1) {
+			errs = append(errs, fmt.Errorf("CDM_PROPOSAL_PC %q is not a probability from 0 to 1", v))
+		}
+		cfg.CDMProposalPc = pc
+	}
+	if v := os.Getenv("FITS_SCALE"); v != "" {
+		scale, err := parseScale(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("FITS_SCALE %q is not minmax, percentile, percentile:lo,hi or fixed:lo,hi", v))
+		} else {
+			cfg.FITSScale = *scale
+		}
+	}
+	if u, err := url.Parse(cfg.PlateSolveURL); cfg.PlateSolveURL != "" && (err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "") {
+		errs = append(errs, fmt.Errorf("PLATESOLVE_URL %q is not an http or https URL", cfg.PlateSolveURL))
+	}
+	if v := os.Getenv("LEGACY_ROUTES_SUNSET"); v != "" {
+		sunset, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("LEGACY_ROUTES_SUNSET %q is not a date like 2027-04-30", v))
+		}
+		cfg.LegacySunset = sunset
+	}
+	cfg.IngestMissionPattern = regexp.MustCompile(defaultIngestMissionPattern)
+	if v, ok := os.LookupEnv("INGEST_MISSION_PATTERN"); ok {
+		cfg.IngestMissionPattern = nil
+		if v != "" {
+			re, err := regexp.Compile(v)
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Errorf("INGEST_MISSION_PATTERN %q is not a regular expression: %w", v, err))
+			case re.NumSubexp() == 0:
+				errs = append(errs, fmt.Errorf("INGEST_MISSION_PATTERN %q has no group to capture the mission ID", v))
+			default:
+				cfg.IngestMissionPattern = re
+			}
+		}
+	}
+	cfg.Duplicates = duplicatesKeep
+	if v := os.Getenv("INGEST_DUPLICATES"); v != "" {
+		switch v {
+		case duplicatesKeep, duplicatesLink, duplicatesReject:
+			cfg.Duplicates = v
+		default:
+			errs = append(errs, fmt.Errorf("INGEST_DUPLICATES %q is not keep, link or reject", v))
+		}
+	}
+	if repair, err := parseRepairs(os.Getenv("INTEGRITY_REPAIR")); err != nil {
+		errs = append(errs, fmt.Errorf("INTEGRITY_REPAIR: %w", err))
+	} else {
+		cfg.IntegrityRepair = repair
+	}
+	cfg.DuplicateDistance = defaultDuplicateDistance
+	if v := os.Getenv("INGEST_DUPLICATE_DISTANCE"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > maxSimilarDistance {
+			errs = append(errs, fmt.Errorf("INGEST_DUPLICATE_DISTANCE %q is not a number of bits from 0 to %d", v, maxSimilarDistance))
+		}
+		cfg.DuplicateDistance = d
+	}
+	cors, corsErrs := loadCORSConfig()
+	cfg.CORS = cors
+	errs = append(errs, corsErrs...)
+	oidc, oidcErrs := loadOIDCConfig()
+	cfg.OIDC = oidc
+	errs = append(errs, oidcErrs...)
+	encryption, encryptionErrs := loadEncryptionConfig()
+	cfg.Encryption = encryption
+	errs = append(errs, encryptionErrs...)
+	flags, flagErrs := loadFeatureFlags()
+	cfg.FeatureFlags = flags
+	errs = append(errs, flagErrs...)
+	tleCfg, tleErrs := loadTLEConfig()
+	cfg.TLE = tleCfg
+	errs = append(errs, tleErrs...)
+	detector, detectorErrs := loadDetectorConfig()
+	cfg.Detector = detector
+	errs = append(errs, detectorErrs...)
+	lifecycle, lifecycleErrs := loadLifecycleConfig()
+	cfg.Lifecycle = lifecycle
+	errs = append(errs, lifecycleErrs...)
+	layout, layoutErrs := loadLayoutConfig()
+	cfg.Layout = layout
+	errs = append(errs, layoutErrs...)
+	tlsCfg, tlsErrs := loadTLSConfig()
+	cfg.TLS = tlsCfg
+	errs = append(errs, tlsErrs...)
+	overlay, overlayErrs := loadOverlayConfig()
+	cfg.Overlay = overlay
+	errs = append(errs, overlayErrs...)
+	return cfg, errors.Join(append(errs, cfg.validate()...)...)
+}
+
+func (cfg *Config) validate() []error {
+	var errs []error
+	if cfg.ImagesBucket == "" {
+		errs = append(errs, errors.New("SAT_IMAGES_BUCKET is required"))
+	}
+
+	switch cfg.StorageBackend {
+	case "s3", "gcs":
+	case "minio":
+		if cfg.StorageEndpoint == "" {
+			errs = append(errs, errors.New("STORAGE_ENDPOINT is required with STORAGE_BACKEND=minio"))
+		}
+	case "filesystem":
+		if cfg.StorageRoot == "" {
+			errs = append(errs, errors.New("STORAGE_ROOT is required with STORAGE_BACKEND=filesystem"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_BACKEND %q is not one of s3, minio, gcs or filesystem", cfg.StorageBackend))
+	}
+
+	switch cfg.MetadataBackend {
+	case "dynamodb":
+		if cfg.MissionTable == "" {
+			errs = append(errs, errors.New("MISSION_TABLE is required with METADATA_BACKEND=dynamodb"))
+		}
+	case "postgres", "sqlite":
+		if cfg.DatabaseURL == "" {
+			errs = append(errs, fmt.Errorf("DATABASE_URL is required with METADATA_BACKEND=%s", cfg.MetadataBackend))
+		}
+		for _, name := range []string{cfg.MissionTable, cfg.ImageTable} {
+			if name != "" && !sqlIdentifier.MatchString(name) {
+				errs = append(errs, fmt.Errorf("table name %q is not a valid SQL identifier", name))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("METADATA_BACKEND %q is not one of dynamodb, postgres or sqlite", cfg.MetadataBackend))
+	}
+	if cfg.MissionStreamARN != "" && cfg.MetadataBackend != "dynamodb" {
+		errs = append(errs, fmt.Errorf("MISSION_STREAM_ARN needs METADATA_BACKEND=dynamodb, not %s", cfg.MetadataBackend))
+	}
+	if cfg.EventsARN != "" {
+		if _, err := eventsTarget(cfg.EventsARN); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.Encryption.enabled() && cfg.StorageBackend != "s3" && cfg.StorageBackend != "minio" {
+		errs = append(errs, fmt.Errorf("SSE_KMS_KEY_ID and SSE_KMS_TENANT_KEYS need STORAGE_BACKEND=s3 or minio, not %s", cfg.StorageBackend))
+	}
+	if cfg.Lifecycle.transitions() && cfg.StorageBackend != "s3" && cfg.StorageBackend != "filesystem" {
+		errs = append(errs, fmt.Errorf("LIFECYCLE_IA_DAYS and LIFECYCLE_ARCHIVE_DAYS need STORAGE_BACKEND=s3 or filesystem, not %s", cfg.StorageBackend))
+	}
+	if cfg.Layout.enabled() && cfg.ContentAddressed {
+		errs = append(errs, errors.New("IMAGE_KEY_TEMPLATE and BUCKET_ROUTES cannot be used with CONTENT_ADDRESSED_STORAGE"))
+	}
+	for _, r := range cfg.Layout.Routes {
+		if r.Tenant != "" && !cfg.MultiTenant {
+			errs = append(errs, fmt.Errorf("BUCKET_ROUTES entry for tenant %s needs MULTI_TENANT", r.Tenant))
+		}
+		if r.Region != "" && cfg.StorageBackend != "s3" {
+			errs = append(errs, fmt.Errorf("BUCKET_ROUTES region %s needs STORAGE_BACKEND=s3, not %s", r.Region, cfg.StorageBackend))
+		}
+	}
+	if len(cfg.Encryption.TenantKMSKeys) > 0 && !cfg.MultiTenant {
+		errs = append(errs, errors.New("SSE_KMS_TENANT_KEYS needs MULTI_TENANT"))
+	}
+	for _, name := range featureFlags {
+		if len(cfg.FeatureFlags[name].Tenants) > 0 && !cfg.MultiTenant {
+			errs = append(errs, fmt.Errorf("FEATURE_FLAGS entries for %s tenants need MULTI_TENANT", name))
+		}
+	}
+	if cfg.MaintenanceToken != "" && cfg.MaintenanceToken == cfg.AdminToken {
+		errs = append(errs, errors.New("MAINTENANCE_TOKEN must differ from ADMIN_TOKEN"))
+	}
+	errs = append(errs, cfg.TLS.validate()...)
+	if cfg.TLS.HTTPPort != 0 && (cfg.TLS.HTTPPort == cfg.Port || cfg.TLS.HTTPPort == cfg.GRPCPort) {
+		errs = append(errs, fmt.Errorf("TLS_HTTP_PORT %d is the same as PORT or GRPC_PORT", cfg.TLS.HTTPPort))
+	}
+	if cfg.MemoryCacheBytes > 0 && cfg.MemoryCacheTTL == 0 {
+		errs = append(errs, errors.New("MEMORY_CACHE_TTL must be positive; set MEMORY_CACHE_MB=0 to turn the cache off"))
+	}
+	if !tenantName.MatchString(cfg.DefaultTenant) {
+		errs = append(errs, fmt.Errorf("DEFAULT_TENANT %q is not lower-case letters, digits and hyphens", cfg.DefaultTenant))
+	}
+	return errs
+}
+
+// applyConfigFile sets the variables in the file that are not already set,
+// so the environment always wins.
+func applyConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	var settings map[string]any
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	var errs []error
+	for name, v := range settings {
+		if !settingName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("config file key %q is not an environment variable name", name))
+			continue
+		}
+		value, err := settingValue(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file key %s: %w", name, err))
+			continue
+		}
+		if _, set := os.LookupEnv(name); !set {
+			os.Setenv(name, value)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func settingValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			if _, nested := item.([]any); nested || strings.Contains(s, ",") {
+				return "", errors.New("list items cannot themselves be lists or contain commas")
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	case map[string]any:
+		return "", errors.New("nested maps are not supported")
+	}
+	return fmt.Sprint(v), nil
+}
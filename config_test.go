@@ -0,0 +1,294 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var configVars = []string{
+	"PORT", "SAT_IMAGES_BUCKET", "MISSION_TABLE", "IMAGE_TABLE", "JOBS_TABLE", "ADMIN_TOKEN",
+	"CORS_PRESET", "CORS_ORIGINS", "CORS_METHODS", "CORS_HEADERS", "CORS_EXPOSE_HEADERS",
+	"CORS_ALLOW_CREDENTIALS", "CORS_MAX_AGE", "STORAGE_BACKEND", "STORAGE_ENDPOINT", "STORAGE_ROOT", "METADATA_BACKEND",
+	"DATABASE_URL", "DERIVED_CACHE_TTL", "REQUEST_TIMEOUT", "PROCESSING_TIMEOUT",
+	"MAX_OUTPUT_DIMENSION", "MAX_CROP_PIXELS", "MAX_BODY_BYTES", "MAX_REQUEST_OPS", "MAX_IMAGE_PIXELS", "FITS_SCALE",
+	"LEGACY_ROUTES", "LEGACY_ROUTES_SUNSET", "SWAGGER_UI", "GRPC_PORT",
+	"WEBHOOKS_TABLE", "MISSION_STREAM_ARN", "EVENTS_ARN", "INGEST_MISSION_PATTERN",
+	"INGEST_DUPLICATES", "INGEST_DUPLICATE_DISTANCE",
+	"API_KEYS_TABLE", "ROLES_TABLE", "AUDIT_TABLE", "USAGE_TABLE", "STORAGE_TABLE", "FEATURE_FLAGS_TABLE", "SATELLITES_TABLE", "TLE_TABLE", "CONJUNCTIONS_TABLE", "SENSORS_TABLE", "DOWNLINKS_TABLE", "DOWNLINK_GRACE", "INTEGRITY_INTERVAL", "INTEGRITY_REPAIR", "MISSION_HISTORY_TABLE", "IMAGE_HASHES_TABLE", "IMAGE_FOOTPRINTS_TABLE", "CONTENT_ADDRESSED_STORAGE", "CONTENT_REFS_TABLE", "KEEP_IMAGE_VERSIONS", "IMAGE_KEY_TEMPLATE", "BUCKET_ROUTES", "CDM_PROPOSAL_PC", "PLATESOLVE_URL", "PLATESOLVE_API_KEY", "DETECTOR", "DETECTOR_ENDPOINT", "DETECTOR_MODEL", "DETECTOR_COMMAND", "DETECTOR_MIN_CONFIDENCE", "LIFECYCLE_IA_DAYS", "LIFECYCLE_ARCHIVE_DAYS", "LIFECYCLE_ARCHIVE_CLASS", "LIFECYCLE_DERIVED_DAYS", "LIFECYCLE_RESTORE_DAYS", "LIFECYCLE_RESTORE_TIER", "TLE_SOURCE", "TLE_SOURCE_URL", "TLE_REFRESH", "SPACETRACK_USERNAME", "SPACETRACK_PASSWORD", "FEATURE_FLAGS", "AUTH_REQUIRED",
+	"OIDC_ISSUER", "OIDC_AUDIENCE", "OIDC_ROLES_CLAIM", "OIDC_ROLES",
+	"RATE_LIMIT_JSON", "RATE_LIMIT_IMAGE", "URL_SIGNING_KEYS",
+	"MULTI_TENANT", "DEFAULT_TENANT", "OIDC_TENANT_CLAIM", "SSE_KMS_KEY_ID", "SSE_KMS_TENANT_KEYS",
+	"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_AUTOCERT_DOMAINS", "TLS_AUTOCERT_CACHE", "TLS_AUTOCERT_EMAIL", "TLS_HTTP_PORT",
+	"TLS_CLIENT_CA_FILE", "TLS_CLIENT_CERT_REQUIRED", "TLS_CLIENT_SCOPES", "HTTP2_CLEARTEXT",
+	"MAINTENANCE_TOKEN",
+	"MEMORY_CACHE_MB", "MEMORY_CACHE_TTL", "PROCESS_MEMORY_MB", "PROCESS_QUEUE_TIMEOUT", "PROCESS_WORKERS",
+	"JOB_MAX_ATTEMPTS", "JOBS_STATUS_INDEX", "JOB_WORKERS", "DERIVATIVES_QUEUE_URL", "DERIVATIVE_WORKERS",
+	"DERIVED_CACHE_MAX_MB", "MISSION_CACHE_TTL", "WEBHOOK_MAX_ATTEMPTS", "REDIS_URL",
+	"S3_ACCELERATE_BUCKETS", "S3_DOWNLOAD_PART_MB", "S3_DOWNLOAD_CONCURRENCY",
+	"OVERLAY_TEXT", "OVERLAY_POSITION", "OVERLAY_OPACITY", "OVERLAY_COLOR", "OVERLAY_BACKGROUND", "OVERLAY_LOCKED",
+}
+
+// clearConfigEnv unsets every setting for the test, restoring them after.
+func clearConfigEnv(t *testing.T) {
+	for _, name := range configVars {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr []string
+	}{
+		{
+			name: "minimal",
+			env:  map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m"},
+		},
+		{
+			name:    "nothing set",
+			wantErr: []string{"SAT_IMAGES_BUCKET is required", "MISSION_TABLE is required"},
+		},
+		{
+			name:    "bad port",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "PORT": "80000"},
+			wantErr: []string{`PORT "80000"`},
+		},
+		{
+			name:    "grpc port clash",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "PORT": "9000", "GRPC_PORT": "9000"},
+			wantErr: []string{"GRPC_PORT 9000 is the same as PORT"},
+		},
+		{
+			name: "stream without dynamodb",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "METADATA_BACKEND": "sqlite", "DATABASE_URL": "sat.db",
+				"MISSION_STREAM_ARN": "arn:aws:dynamodb:us-east-1:123456789012:table/missions/stream/2026-10-14T00:00:00.000"},
+			wantErr: []string{"MISSION_STREAM_ARN needs METADATA_BACKEND=dynamodb, not sqlite"},
+		},
+		{
+			name: "events target not a topic or bus",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m",
+				"EVENTS_ARN": "arn:aws:sqs:us-east-1:123456789012:mission-events"},
+			wantErr: []string{`EVENTS_ARN "arn:aws:sqs:us-east-1:123456789012:mission-events" is not an SNS topic or EventBridge bus`},
+		},
+		{
+			name: "ingest pattern without a group",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m",
+				"INGEST_MISSION_PATTERN": `^[a-z-]+-\d+$`},
+			wantErr: []string{"has no group to capture the mission ID"},
+		},
+		{
+			name: "bad duplicates",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m",
+				"INGEST_DUPLICATES": "merge", "INGEST_DUPLICATE_DISTANCE": "64"},
+			wantErr: []string{`INGEST_DUPLICATES "merge"`, `INGEST_DUPLICATE_DISTANCE "64"`},
+		},
+		{
+			name: "bad oidc",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m",
+				"OIDC_ISSUER": "http://idp.example.com/", "OIDC_ROLES": "sat-ops=missions:read missions:delete,analysts"},
+			wantErr: []string{`OIDC_ISSUER "http://idp.example.com/" is not an https URL`, `unknown role or scope "missions:delete"`, `OIDC_ROLES entry "analysts" is not claim=role`},
+		},
+		{
+			name: "bad origins",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m",
+				"CORS_ORIGINS": "https://ok.example.com, mission.example.com, https://x.example.com/app"},
+			wantErr: []string{`"mission.example.com"`, `"https://x.example.com/app"`},
+		},
+		{
+			name:    "empty origins",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "CORS_ORIGINS": " , "},
+			wantErr: []string{"lists no origins"},
+		},
+		{
+			name: "bad legacy routes",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m",
+				"LEGACY_ROUTES": "sometimes", "LEGACY_ROUTES_SUNSET": "next spring"},
+			wantErr: []string{`LEGACY_ROUTES "sometimes"`, `LEGACY_ROUTES_SUNSET "next spring"`},
+		},
+		{
+			name:    "bad timeouts",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "REQUEST_TIMEOUT": "soon", "PROCESSING_TIMEOUT": "-1s", "DOWNLINK_GRACE": "6"},
+			wantErr: []string{`REQUEST_TIMEOUT "soon"`, `PROCESSING_TIMEOUT "-1s"`, `DOWNLINK_GRACE "6"`},
+		},
+		{
+			name:    "bad limits",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "MAX_OUTPUT_DIMENSION": "0", "MAX_BODY_BYTES": "1MB", "MAX_IMAGE_PIXELS": "-1", "FITS_SCALE": "asinh"},
+			wantErr: []string{`MAX_OUTPUT_DIMENSION "0"`, `MAX_BODY_BYTES "1MB"`, `MAX_IMAGE_PIXELS "-1"`, `FITS_SCALE "asinh"`},
+		},
+		{
+			name: "bad component settings",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m",
+				"MEMORY_CACHE_MB": "-1", "PROCESS_MEMORY_MB": "0", "PROCESS_WORKERS": "four", "JOB_WORKERS": "0",
+				"JOB_MAX_ATTEMPTS": "-2", "DERIVATIVE_WORKERS": "2.5", "WEBHOOK_MAX_ATTEMPTS": "x",
+				"S3_DOWNLOAD_PART_MB": "16MB", "S3_DOWNLOAD_CONCURRENCY": "0", "DERIVED_CACHE_MAX_MB": "big",
+				"MISSION_CACHE_TTL": "-1s", "PROCESS_QUEUE_TIMEOUT": "5", "REDIS_URL": "memcached://cache:11211"},
+			wantErr: []string{`MEMORY_CACHE_MB "-1"`, `PROCESS_MEMORY_MB "0"`, `PROCESS_WORKERS "four"`, `JOB_WORKERS "0"`,
+				`JOB_MAX_ATTEMPTS "-2"`, `DERIVATIVE_WORKERS "2.5"`, `WEBHOOK_MAX_ATTEMPTS "x"`,
+				`S3_DOWNLOAD_PART_MB "16MB"`, `S3_DOWNLOAD_CONCURRENCY "0"`, `DERIVED_CACHE_MAX_MB "big"`,
+				`MISSION_CACHE_TTL "-1s"`, `PROCESS_QUEUE_TIMEOUT "5"`, "REDIS_URL"},
+		},
+		{
+			name:    "memory cache without a ttl",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "MEMORY_CACHE_TTL": "0s"},
+			wantErr: []string{"MEMORY_CACHE_TTL must be positive"},
+		},
+		{
+			name: "bad overlay",
+			env: map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "OVERLAY_TEXT": "SECRET",
+				"OVERLAY_POSITION": "left", "OVERLAY_COLOR": "red", "OVERLAY_LOCKED": "always"},
+			wantErr: []string{"overlay_position", "OVERLAY_COLOR", `OVERLAY_LOCKED "always"`},
+		},
+		{
+			name:    "bad rate limits",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "RATE_LIMIT_JSON": "600", "RATE_LIMIT_IMAGE": "60/fortnight"},
+			wantErr: []string{`RATE_LIMIT_JSON "600" is not a limit`, `RATE_LIMIT_IMAGE "60/fortnight" is not a limit`},
+		},
+		{
+			name:    "short signing key",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "URL_SIGNING_KEYS": strings.Repeat("k", 32) + ",hunter2"},
+			wantErr: []string{"URL_SIGNING_KEYS entry 2 is shorter than 32 characters"},
+		},
+		{
+			name:    "bad default tenant",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "MULTI_TENANT": "true", "DEFAULT_TENANT": "Acme Corp"},
+			wantErr: []string{`DEFAULT_TENANT "Acme Corp" is not lower-case letters, digits and hyphens`},
+		},
+		{
+			name:    "tls",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "TLS_CERT_FILE": "cert.pem", "TLS_AUTOCERT_DOMAINS": "sat.example.com", "HTTP2_CLEARTEXT": "true", "TLS_HTTP_PORT": "8080", "TLS_CLIENT_SCOPES": "viewer,missions:delete"},
+			wantErr: []string{"TLS_CERT_FILE and TLS_KEY_FILE must be set together", "cannot both be set", "HTTP2_CLEARTEXT cannot be set with TLS", "TLS_HTTP_PORT 8080 is the same as PORT", `unknown role or scope "missions:delete"`, "need TLS_CLIENT_CA_FILE"},
+		},
+		{
+			name:    "maintenance token reuses admin token",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "ADMIN_TOKEN": "t", "MAINTENANCE_TOKEN": "t"},
+			wantErr: []string{"MAINTENANCE_TOKEN must differ from ADMIN_TOKEN"},
+		},
+		{
+			name:    "feature flags",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "FEATURE_FLAGS": "avif=off,tiles:acme=on,webp=on,tiles=maybe"},
+			wantErr: []string{`unknown flag "webp"`, `"tiles=maybe" is not flag=on`, "FEATURE_FLAGS entries for tiles tenants need MULTI_TENANT"},
+		},
+		{
+			name:    "tle source",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "TLE_SOURCE": "spacetrack", "TLE_REFRESH": "10s", "TLE_SOURCE_URL": "ftp://mirror"},
+			wantErr: []string{"needs SPACETRACK_USERNAME and SPACETRACK_PASSWORD", `TLE_REFRESH "10s" is not a duration of at least 1m`, `TLE_SOURCE_URL "ftp://mirror" is not an http or https URL`},
+		},
+		{
+			name:    "cdm proposal pc",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "CDM_PROPOSAL_PC": "2"},
+			wantErr: []string{`CDM_PROPOSAL_PC "2" is not a probability from 0 to 1`},
+		},
+		{
+			name:    "plate solver url",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "PLATESOLVE_URL": "nova.astrometry.net"},
+			wantErr: []string{`PLATESOLVE_URL "nova.astrometry.net" is not an http or https URL`},
+		},
+		{
+			name:    "detector",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "DETECTOR": "sagemaker", "DETECTOR_MIN_CONFIDENCE": "50"},
+			wantErr: []string{"DETECTOR=sagemaker needs DETECTOR_ENDPOINT", `DETECTOR_MIN_CONFIDENCE "50" is not a confidence from 0 to 1`},
+		},
+		{
+			name:    "lifecycle",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "STORAGE_BACKEND": "gcs", "LIFECYCLE_IA_DAYS": "30", "LIFECYCLE_ARCHIVE_DAYS": "30", "LIFECYCLE_ARCHIVE_CLASS": "tape", "LIFECYCLE_RESTORE_DAYS": "0"},
+			wantErr: []string{"LIFECYCLE_RESTORE_DAYS \"0\" is not a number of days from 1", "LIFECYCLE_ARCHIVE_DAYS 30 is not after LIFECYCLE_IA_DAYS 30", `LIFECYCLE_ARCHIVE_CLASS "tape" is not GLACIER, DEEP_ARCHIVE or GLACIER_IR`, "LIFECYCLE_IA_DAYS and LIFECYCLE_ARCHIVE_DAYS need STORAGE_BACKEND=s3 or filesystem, not gcs"},
+		},
+		{
+			name:    "mtls without tls",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "TLS_CLIENT_CA_FILE": "ca.pem"},
+			wantErr: []string{"TLS_CLIENT_CA_FILE needs TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS"},
+		},
+		{
+			name:    "kms keys",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "STORAGE_BACKEND": "gcs", "SSE_KMS_TENANT_KEYS": "acme=alias/acme,globex"},
+			wantErr: []string{"need STORAGE_BACKEND=s3 or minio, not gcs", `SSE_KMS_TENANT_KEYS entry "globex" is not tenant=key`, "SSE_KMS_TENANT_KEYS needs MULTI_TENANT"},
+		},
+		{
+			name:    "filesystem without root",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "MISSION_TABLE": "m", "STORAGE_BACKEND": "filesystem"},
+			wantErr: []string{"STORAGE_ROOT is required"},
+		},
+		{
+			name:    "unknown backends",
+			env:     map[string]string{"SAT_IMAGES_BUCKET": "b", "STORAGE_BACKEND": "ftp", "METADATA_BACKEND": "mongo"},
+			wantErr: []string{`STORAGE_BACKEND "ftp"`, `METADATA_BACKEND "mongo"`},
+		},
+		{
+			name: "sqlite needs no mission table",
+			env:  map[string]string{"SAT_IMAGES_BUCKET": "b", "METADATA_BACKEND": "sqlite", "DATABASE_URL": "x.db"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := loadConfig("", false)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if cfg.Port != 8080 || !slices.Equal(cfg.CORS.Origins, []string{defaultCORSOrigin}) {
+					t.Errorf("defaults not applied: port %d, origins %v", cfg.Port, cfg.CORS.Origins)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("invalid configuration accepted")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	clearConfigEnv(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+SAT_IMAGES_BUCKET: from-file
+MISSION_TABLE: missions
+PORT: 9090
+DERIVED_CACHE_TTL: 1h
+MEMORY_CACHE_MB: 0
+S3_ACCELERATE_BUCKETS: images, archive
+CORS_ORIGINS:
+  - https://a.example.com
+  - http://localhost:3000
+`), 0o644)
+	t.Setenv("SAT_IMAGES_BUCKET", "from-env")
+
+	cfg, err := loadConfig(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ImagesBucket != "from-env" {
+		t.Errorf("ImagesBucket = %q, want the environment to win", cfg.ImagesBucket)
+	}
+	if cfg.MissionTable != "missions" || cfg.Port != 9090 {
+		t.Errorf("file settings not applied: %+v", cfg)
+	}
+	if !slices.Equal(cfg.CORS.Origins, []string{"https://a.example.com", "http://localhost:3000"}) {
+		t.Errorf("CORS.Origins = %v", cfg.CORS.Origins)
+	}
+	if cfg.DerivedCacheTTL != time.Hour || cfg.MemoryCacheBytes != 0 ||
+		!slices.Equal(cfg.S3AccelerateBuckets, []string{"images", "archive"}) {
+		t.Errorf("component settings not applied: %+v", cfg)
+	}
+
+	os.WriteFile(path, []byte("sat_images_bucket: x\nOVERLAY:\n  TEXT: y\n"), 0o644)
+	if _, err := loadConfig(path, false); err == nil || !strings.Contains(err.Error(), "sat_images_bucket") ||
+		!strings.Contains(err.Error(), "nested maps") {
+		t.Errorf("bad file error = %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	contactSheetPadding     = 8
+	contactSheetCaptionH    = 30
+	contactSheetMaxImages   = 400
+	contactSheetFetchLimit  = 8
+	contactSheetDefaultSize = 128
+)
+
+var (
+	contactSheetBackground  = color.RGBA{0x1e, 0x1e, 0x1e, 0xff}
+	contactSheetPlaceholder = color.RGBA{0x40, 0x40, 0x40, 0xff}
+	contactSheetCaption     = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+)
+
+type contactSheetCell struct {
+	id       string
+	thumb    image.Image
+	captured time.Time
+}
+
+// getContactSheet composites the mission's thumbnails into one grid image,
+// captioned with each frame's capture time, for quick triage of a pass.
+func (api *API) getContactSheet(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	size := contactSheetDefaultSize
+	if sizeStr := c.Query("size"); sizeStr != "" {
+		parsed, err := strconv.Atoi(sizeStr)
+		if err != nil || !thumbnailSizes[parsed] {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'size' parameter. Must be one of 64, 128, 256, 512.")
+			return
+		}
+		size = parsed
+	}
+
+	format := outputFormats[0]
+	if formatStr := c.Query("format"); formatStr != "" {
+		f, ok := lookupFormat(formatStr)
+		if !ok {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'format' parameter. Must be one of jpeg, png, webp, avif, tiff.")
+			return
+		}
+		format = f
+	}
+
+	ctx := c.Request.Context()
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+	if len(mission.ImageIDs) == 0 {
+		respondError(c, http.StatusNotFound, CodeMissionEmpty, "mission has no images")
+		return
+	}
+
+	imageIDs := mission.ImageIDs
+	if len(imageIDs) > contactSheetMaxImages {
+		imageIDs = imageIDs[:contactSheetMaxImages]
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(imageIDs)))))
+	if colsStr := c.Query("cols"); colsStr != "" {
+		parsed, err := strconv.Atoi(colsStr)
+		if err != nil || parsed <= 0 {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'cols' parameter. Must be a positive integer.")
+			return
+		}
+		cols = min(parsed, len(imageIDs))
+	}
+
+	cells := api.loadContactSheetCells(ctx, bucketName, imageIDs, size)
+	var sheet image.Image = renderContactSheet(cells, cols, size)
+	if api.Overlay != nil {
+		sheet = drawOverlay(sheet, api.Overlay)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Encode(&buf, sheet, EncodeOptions{Quality: defaultQuality}); err != nil {
+		slog.ErrorContext(ctx, "failed to encode contact sheet", "mission", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, format.ContentType, buf.Bytes())
+}
+
+// loadContactSheetCells fetches thumbnails and capture times concurrently.
+// Images that fail to load keep a nil thumbnail and render as placeholders.
+func (api *API) loadContactSheetCells(ctx context.Context, bucketName string, ids []string, size int) []contactSheetCell {
+	cells := make([]contactSheetCell, len(ids))
+	var g errgroup.Group
+	g.SetLimit(contactSheetFetchLimit)
+
+	for i, imageID := range ids {
+		cells[i].id = imageID
+		g.Go(func() error {
+			data, err := api.thumbnailBytes(ctx, bucketName, imageID, size)
+			if err != nil {
+				slog.ErrorContext(ctx, "contact sheet thumbnail failed", "id", imageID, "err", err)
+				return nil
+			}
+			thumb, err := imaging.Decode(bytes.NewReader(data))
+			if err != nil {
+				slog.ErrorContext(ctx, "contact sheet thumbnail decode failed", "id", imageID, "err", err)
+				return nil
+			}
+			cells[i].thumb = thumb
+			if t, err := api.captureTime(ctx, bucketName, imageID); err == nil {
+				cells[i].captured = t
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	return cells
+}
+
+func renderContactSheet(cells []contactSheetCell, cols, size int) *image.RGBA {
+	rows := (len(cells) + cols - 1) / cols
+	cellW := size + contactSheetPadding
+	cellH := size + contactSheetCaptionH + contactSheetPadding
+
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*cellW+contactSheetPadding, rows*cellH+contactSheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(contactSheetBackground), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{Dst: sheet, Src: image.NewUniform(contactSheetCaption), Face: basicfont.Face7x13}
+	maxChars := size / basicfont.Face7x13.Advance
+
+	for i, cell := range cells {
+		x := contactSheetPadding + (i%cols)*cellW
+		y := contactSheetPadding + (i/cols)*cellH
+		slot := image.Rect(x, y, x+size, y+size)
+
+		if cell.thumb == nil {
+			draw.Draw(sheet, slot, image.NewUniform(contactSheetPlaceholder), image.Point{}, draw.Src)
+		} else {
+			// Centre the thumbnail in its square slot.
+			b := cell.thumb.Bounds()
+			offset := image.Pt(x+(size-b.Dx())/2, y+(size-b.Dy())/2)
+			draw.Draw(sheet, b.Sub(b.Min).Add(offset), cell.thumb, b.Min, draw.Src)
+		}
+
+		caption := "unavailable"
+		if !cell.captured.IsZero() {
+			caption = cell.captured.UTC().Format("2006-01-02 15:04:05Z")
+		}
+		drawCaption(drawer, truncate(caption, maxChars), x, y+size+13)
+		drawCaption(drawer, truncate(cell.id, maxChars), x, y+size+26)
+	}
+
+	return sheet
+}
+
+func drawCaption(d *font.Drawer, text string, x, baseline int) {
+	d.Dot = fixed.P(x, baseline)
+	d.DrawString(text)
+}
+
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestRenderContactSheet(t *testing.T) {
+	const size = 64
+	// A wide thumbnail is centred vertically in its square slot.
+	thumb := image.NewRGBA(image.Rect(0, 0, size, 32))
+	for i := range thumb.Pix {
+		thumb.Pix[i] = 0xff
+	}
+	cells := []contactSheetCell{
+		{id: "a", thumb: thumb, captured: time.Date(2026, 10, 15, 4, 5, 6, 0, time.UTC)},
+		{id: "b"},
+		{id: "c", thumb: thumb},
+		{id: "d", thumb: thumb},
+		{id: "e", thumb: thumb},
+	}
+	sheet := renderContactSheet(cells, 3, size)
+	cellW, cellH := size+contactSheetPadding, size+contactSheetCaptionH+contactSheetPadding
+	if b := sheet.Bounds(); b.Dx() != 3*cellW+contactSheetPadding || b.Dy() != 2*cellH+contactSheetPadding {
+		t.Fatalf("sheet is %dx%d", b.Dx(), b.Dy())
+	}
+
+	at := func(cell, x, y int) color.RGBA {
+		return sheet.RGBAAt(contactSheetPadding+cell%3*cellW+x, contactSheetPadding+cell/3*cellH+y)
+	}
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	for _, tt := range []struct {
+		name       string
+		cell, x, y int
+		want       color.RGBA
+	}{
+		{"thumbnail", 0, size / 2, size / 2, white},
+		{"above the thumbnail", 0, size / 2, 4, contactSheetBackground},
+		{"placeholder", 1, size / 2, 4, contactSheetPlaceholder},
+		{"second row", 4, size / 2, size / 2, white},
+		{"empty slot", 5, size / 2, size / 2, contactSheetBackground},
+	} {
+		if got := at(tt.cell, tt.x, tt.y); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abcdef", 3, "abc"},
+		{"abc", 3, "abc"},
+		{"ab", 3, "ab"},
+		{"abc", 0, ""},
+		{"abc", -1, ""},
+	} {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
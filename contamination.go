@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// The classifier looks at the frame fitted within
+	// contaminationSampleSide pixels, contaminationCell pixels a side at a
+	// time.
+	contaminationSampleSide = 256
+	contaminationCell       = 8
+	// A cell darker on average than skyLevel is space beyond the limb.
+	skyLevel = 0.08
+	// A cell is cloud when it is at least cloudLevel bright, grey to
+	// within cloudChroma, and smoother than cloudTexture: the land and sea
+	// under it show colour and detail that cloud tops do not.
+	cloudLevel   = 0.55
+	cloudChroma  = 0.1
+	cloudTexture = 0.06
+	// earthPointing is the pointing_target of missions that image the
+	// Earth itself.
+	earthPointing = "earth"
+)
+
+// Contamination is how much of an earth-background frame is hidden by
+// cloud or is space past the limb, stored as the contamination attribute
+// of its ImageRecord.
+type Contamination struct {
+	// CloudPct and LimbPct are the percentages of the frame that are cloud
+	// and that are space beyond the Earth's limb.
+	CloudPct float64 `dynamodbav:"cloud_pct" json:"cloud_pct"`
+	LimbPct  float64 `dynamodbav:"limb_pct" json:"limb_pct"`
+	Computed int64   `dynamodbav:"computed" json:"computed"`
+}
+
+// classifyContamination sorts each cell of the frame into space, cloud or
+// the clear Earth by its mean brightness, mean colour and texture.
+func classifyContamination(src image.Image) Contamination {
+	img := imaging.Fit(src, contaminationSampleSide, contaminationSampleSide, imaging.Box)
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	cw, ch := min(contaminationCell, w), min(contaminationCell, h)
+	c := Contamination{Computed: time.Now().Unix()}
+	if cw == 0 || ch == 0 {
+		return c
+	}
+
+	var cells, sky, cloud int
+	for y0 := 0; y0+ch <= h; y0 += ch {
+		for x0 := 0; x0+cw <= w; x0 += cw {
+			var sum, sumSq, chroma float64
+			for y := y0; y < y0+ch; y++ {
+				row := img.Pix[y*img.Stride:]
+				for x := x0; x < x0+cw; x++ {
+					r, g, b := float64(row[4*x]), float64(row[4*x+1]), float64(row[4*x+2])
+					l := (0.299*r + 0.587*g + 0.114*b) / 255
+					sum += l
+					sumSq += l * l
+					chroma += (max(r, g, b) - min(r, g, b)) / 255
+				}
+			}
+			n := float64(cw * ch)
+			mean := sum / n
+			texture := math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+			cells++
+			switch {
+			case mean < skyLevel:
+				sky++
+			case mean >= cloudLevel && chroma/n < cloudChroma && texture < cloudTexture:
+				cloud++
+			}
+		}
+	}
+	pct := func(k int) float64 { return math.Round(1000*float64(k)/float64(cells)) / 10 }
+	c.CloudPct, c.LimbPct = pct(cloud), pct(sky)
+	return c
+}
+
+// earthBackground reports whether the line of sight from observer past
+// target meets the Earth, so the Earth fills the frame behind the target.
+func earthBackground(observer, target [3]float64) bool {
+	u := unit(sub(target, observer))
+	// The point of the ray beyond the target nearest the Earth's center.
+	s := -dot(target, u)
+	if s <= 0 {
+		return false
+	}
+	return norm([3]float64{target[0] + s*u[0], target[1] + s*u[1], target[2] + s*u[2]}) < earthRadiusKm
+}
+
+// hasEarthBackground reports whether an image of the mission is taken
+// against the Earth: its geometry puts the Earth behind the target, or the
+// mission points at the Earth itself.
+func (api *API) hasEarthBackground(ctx context.Context, missionID string, g *ObservationGeometry) bool {
+	if g != nil && g.EarthBackground {
+		return true
+	}
+	mission, err := api.loadMission(ctx, missionID)
+	return err == nil && strings.EqualFold(mission.PointingTarget, earthPointing)
+}
+
+// annotateContamination stores the cloud and limb contamination of an
+// earth-background image just ingested. A failure is logged and does not
+// fail the ingest.
+func (api *API) annotateContamination(ctx context.Context, id string) {
+	img, release, err := api.loadSourceImage(ctx, api.Config.ImagesBucket, id)
+	if err != nil {
+		slog.WarnContext(ctx, "no contamination score for ingested image", "id", id, "err", err)
+		return
+	}
+	c := classifyContamination(img)
+	release()
+	if err := api.Images.SetImageAttribute(ctx, id, "contamination", c); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to store contamination", "id", id, "err", err)
+	}
+}
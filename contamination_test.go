@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClassifyContamination(t *testing.T) {
+	// A band paints columns [x0, x1) of a 512×512 frame.
+	type band struct {
+		x0, x1 int
+		paint  func(x, y int) color.RGBA
+	}
+	space := func(x, y int) color.RGBA { return color.RGBA{4, 4, 8, 255} }
+	cloud := func(x, y int) color.RGBA { return color.RGBA{230, 232, 235, 255} }
+	// Ocean and land: coloured, and textured at the cell scale.
+	ground := func(x, y int) color.RGBA {
+		v := uint8(40 + 60*((x/3+y/3)%2))
+		return color.RGBA{v / 2, v, uint8(120), 255}
+	}
+	// Bright desert or snow shows detail, so it is not cloud.
+	snow := func(x, y int) color.RGBA {
+		v := uint8(160 + 90*((x/2+y/2)%2))
+		return color.RGBA{v, v, v, 255}
+	}
+	tests := []struct {
+		name        string
+		bands       []band
+		cloud, limb float64
+	}{
+		{"clear", []band{{0, 512, ground}}, 0, 0},
+		{"overcast", []band{{0, 512, cloud}}, 100, 0},
+		{"limb", []band{{0, 128, space}, {128, 512, ground}}, 0, 25},
+		{"cloud over the limb", []band{{0, 128, space}, {128, 256, cloud}, {256, 512, ground}}, 25, 25},
+		{"snow", []band{{0, 512, snow}}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+			for _, b := range tt.bands {
+				for y := range 512 {
+					for x := b.x0; x < b.x1; x++ {
+						img.SetRGBA(x, y, b.paint(x, y))
+					}
+				}
+			}
+			got := classifyContamination(img)
+			if got.CloudPct != tt.cloud || got.LimbPct != tt.limb || got.Computed == 0 {
+				t.Errorf("got %+v, want cloud %v limb %v", got, tt.cloud, tt.limb)
+			}
+		})
+	}
+	if got := classifyContamination(image.NewGray(image.Rect(0, 0, 4, 3))); got.LimbPct != 100 {
+		t.Errorf("tiny frame: %+v", got)
+	}
+}
+
+func TestEarthBackground(t *testing.T) {
+	tests := []struct {
+		name             string
+		observer, target [3]float64
+		want             bool
+	}{
+		{"looking down", [3]float64{7000, 0, 0}, [3]float64{6900, 10, 0}, true},
+		{"looking up", [3]float64{6900, 0, 0}, [3]float64{7000, 10, 0}, false},
+		{"along the orbit", [3]float64{7000, 0, 0}, [3]float64{7000, 50, 0}, false},
+		{"past the limb", [3]float64{7000, 0, 0}, [3]float64{6990, 3000, 0}, false},
+	}
+	for _, tt := range tests {
+		if got := earthBackground(tt.observer, tt.target); got != tt.want {
+			t.Errorf("%s: got %v", tt.name, got)
+		}
+	}
+}
+
+func TestMissionImagesMaxCloud(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	meta := testSQLStore(t)
+	if err := putMission(ctx, meta, &Mission{ID: "m1", ImageIDs: []string{"clear", "cloudy", "space"}}); err != nil {
+		t.Fatal(err)
+	}
+	meta.SetImageAttribute(ctx, "clear", "contamination", Contamination{CloudPct: 5, LimbPct: 30})
+	meta.SetImageAttribute(ctx, "cloudy", "contamination", Contamination{CloudPct: 60})
+	api := &API{MissionDB: meta, Images: meta}
+	router := gin.New()
+	router.GET("/mission/:id/images", api.getMissionImages)
+
+	tests := []struct {
+		query string
+		code  int
+		want  int
+	}{
+		{"", http.StatusOK, 3},
+		{"?maxCloud=20", http.StatusOK, 1},
+		{"?maxCloud=60", http.StatusOK, 2},
+		{"?maxCloud=101", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mission/m1/images"+tt.query, nil))
+		var resp MissionImagesResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != tt.code || len(resp.Images) != tt.want {
+			t.Errorf("%q: %d with %d images, want %d with %d", tt.query, w.Code, len(resp.Images), tt.code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// contentSumMetadata marks an original stored by content: an empty pointer
+// object whose bytes are in the blob of this SHA-256.
+const contentSumMetadata = "content-sha256"
+
+// blobKey is the bucket key of the blob holding the bytes with checksum sum,
+// under the tenant prefix of key, an original pointing at it.
+func blobKey(key, sum string) string {
+	if tenant, _, ok := splitObjectKey(key); ok {
+		return tenantObjectRoot + tenant + "/blobs/" + sum
+	}
+	return "blobs/" + sum
+}
+
+// isOriginalKey says whether the bucket key is an image's original or a kept
+// version of it, of any tenant.
+func isOriginalKey(key string) bool {
+	_, rest, _ := splitObjectKey(key)
+	_, ok := imageIDFromKey(rest)
+	return ok || strings.HasPrefix(rest, "versions/")
+}
+
+// ContentRefs counts the originals pointing at each blob, so a blob is
+// deleted with the last of them. When CONTENT_REFS_TABLE is set the
+// references are saved to DynamoDB, keyed by the blob's bucket key with the
+// originals' keys in the string set refs, and shared by every instance.
+// Without it they live only in this process, and a blob that was stored
+// before this process first referenced it is never deleted.
+type ContentRefs struct {
+	mu     sync.Mutex
+	refs   map[string]map[string]bool
+	pinned map[string]bool
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newContentRefs(db *dynamodb.Client, table string) *ContentRefs {
+	r := &ContentRefs{refs: make(map[string]map[string]bool), pinned: make(map[string]bool), table: table}
+	if r.table != "" {
+		r.db = db
+	}
+	return r
+}
+
+// Add records that the original key points at blob. existed says the blob
+// was already stored, by originals this process may not know of.
+func (r *ContentRefs) Add(ctx context.Context, blob, key string, existed bool) error {
+	if r.db != nil {
+		_, err := r.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(r.table),
+			Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: blob}},
+			UpdateExpression:          aws.String("ADD refs :k"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":k": &types.AttributeValueMemberSS{Value: []string{key}}},
+		})
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.refs[blob] == nil {
+		r.refs[blob] = make(map[string]bool)
+		if existed {
+			r.pinned[blob] = true
+		}
+	}
+	r.refs[blob][key] = true
+	return nil
+}
+
+// Remove drops the reference of the original key to blob, and reports
+// whether it was the last one, after which the blob may be deleted.
+func (r *ContentRefs) Remove(ctx context.Context, blob, key string) (last bool, err error) {
+	if r.db != nil {
+		id := map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: blob}}
+		out, err := r.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(r.table),
+			Key:                       id,
+			UpdateExpression:          aws.String("DELETE refs :k"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":k": &types.AttributeValueMemberSS{Value: []string{key}}},
+			ReturnValues:              types.ReturnValueAllNew,
+		})
+		if err != nil {
+			return false, err
+		}
+		if _, ok := out.Attributes["refs"]; ok {
+			return false, nil
+		}
+		// An original stored meanwhile adds itself back, and keeps the
+		// blob.
+		_, err = r.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName:           aws.String(r.table),
+			Key:                 id,
+			ConditionExpression: aws.String("attribute_not_exists(refs)"),
+		})
+		var failed *types.ConditionalCheckFailedException
+		if errors.As(err, &failed) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	refs, ok := r.refs[blob]
+	if !ok || !refs[key] {
+		return false, nil
+	}
+	delete(refs, key)
+	if len(refs) > 0 {
+		return false, nil
+	}
+	delete(r.refs, blob)
+	last = !r.pinned[blob]
+	delete(r.pinned, blob)
+	return last, nil
+}
+
+// contentStore keeps originals stored by content as empty pointer objects,
+// and reads, heads and restores the blob a pointer names in its place, so
+// the rest of the server sees originals where they were uploaded. Deleting
+// a pointer deletes its blob once nothing else points at it. Keys are bucket
+// keys, tenant prefix and all.
+type contentStore struct {
+	ObjectStore
+	refs *ContentRefs
+}
+
+// addressContent wraps store to read originals stored by content.
+func addressContent(store ObjectStore, refs *ContentRefs) *contentStore {
+	return &contentStore{store, refs}
+}
+
+// pointer returns the checksum of the blob the original at key points at,
+// or "" when it is an object of its own, and its own head.
+func (s *contentStore) pointer(ctx context.Context, bucket, key *string) (string, *s3.HeadObjectOutput, error) {
+	head, err := s.ObjectStore.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		return "", nil, err
+	}
+	return head.Metadata[contentSumMetadata], head, nil
+}
+
+// refusedByPointer says whether a read may have failed for reaching a
+// pointer rather than its blob: no range of an empty object exists, and
+// its ETag is not the blob's.
+func refusedByPointer(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ErrorCode()
+	return code == "InvalidRange" || code == "PreconditionFailed"
+}
+
+func (s *contentStore) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if !isOriginalKey(aws.ToString(in.Key)) {
+		return s.ObjectStore.GetObject(ctx, in, optFns...)
+	}
+	out, err := s.ObjectStore.GetObject(ctx, in, optFns...)
+	var sum string
+	var metadata map[string]string
+	var contentType, cacheControl *string
+	switch {
+	case err == nil:
+		if sum = out.Metadata[contentSumMetadata]; sum == "" {
+			return out, nil
+		}
+		out.Body.Close()
+		metadata, contentType, cacheControl = out.Metadata, out.ContentType, out.CacheControl
+	case refusedByPointer(err):
+		var head *s3.HeadObjectOutput
+		if sum, head, _ = s.pointer(ctx, in.Bucket, in.Key); sum == "" {
+			return nil, err
+		}
+		metadata, contentType, cacheControl = head.Metadata, head.ContentType, head.CacheControl
+	default:
+		return nil, err
+	}
+	blob := *in
+	blob.Key = aws.String(blobKey(aws.ToString(in.Key), sum))
+	out, err = s.ObjectStore.GetObject(ctx, &blob, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.Metadata, out.ContentType, out.CacheControl = metadata, contentType, cacheControl
+	return out, nil
+}
+
+func (s *contentStore) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if !isOriginalKey(aws.ToString(in.Key)) {
+		return s.ObjectStore.HeadObject(ctx, in, optFns...)
+	}
+	head, err := s.ObjectStore.HeadObject(ctx, in, optFns...)
+	var sum string
+	switch {
+	case err == nil:
+		if sum = head.Metadata[contentSumMetadata]; sum == "" {
+			return head, nil
+		}
+	case refusedByPointer(err):
+		if sum, head, _ = s.pointer(ctx, in.Bucket, in.Key); sum == "" {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	blob := *in
+	blob.Key = aws.String(blobKey(aws.ToString(in.Key), sum))
+	out, err := s.ObjectStore.HeadObject(ctx, &blob, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.Metadata, out.ContentType, out.CacheControl = head.Metadata, head.ContentType, head.CacheControl
+	return out, nil
+}
+
+// RestoreObject restores the blob of an original stored by content, which
+// is what the lifecycle sweep archives.
+func (s *contentStore) RestoreObject(ctx context.Context, in *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error) {
+	if isOriginalKey(aws.ToString(in.Key)) {
+		if sum, _, _ := s.pointer(ctx, in.Bucket, in.Key); sum != "" {
+			blob := *in
+			blob.Key = aws.String(blobKey(aws.ToString(in.Key), sum))
+			in = &blob
+		}
+	}
+	return s.ObjectStore.RestoreObject(ctx, in, optFns...)
+}
+
+// DeleteObjects deletes pointers like any other object, then releases their
+// blobs.
+func (s *contentStore) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	blobs := map[string]string{}
+	if in.Delete != nil {
+		for _, obj := range in.Delete.Objects {
+			key := aws.ToString(obj.Key)
+			if !isOriginalKey(key) {
+				continue
+			}
+			if sum, _, _ := s.pointer(ctx, in.Bucket, obj.Key); sum != "" {
+				blobs[key] = blobKey(key, sum)
+			}
+		}
+	}
+	out, err := s.ObjectStore.DeleteObjects(ctx, in, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	// A quiet delete lists only the keys that failed.
+	for _, failed := range out.Errors {
+		delete(blobs, aws.ToString(failed.Key))
+	}
+	for key, blob := range blobs {
+		if err := s.release(ctx, aws.ToString(in.Bucket), blob, key); err != nil {
+			slog.ErrorContext(ctx, "failed to release blob", "blob", blob, "key", key, "err", err)
+		}
+	}
+	return out, nil
+}
+
+// store moves the original at key into the blob of its checksum sum,
+// copying it there unless the blob is already stored, and leaves a pointer
+// with the original's content type and metadata in its place. An original
+// that is already a pointer, such as a copy of one, is only counted as
+// referencing its blob.
+func (s *contentStore) store(ctx context.Context, bucketName, key, sum string) error {
+	own, head, err := s.pointer(ctx, aws.String(bucketName), aws.String(key))
+	if err != nil {
+		return err
+	}
+	if own != "" {
+		return s.refs.Add(ctx, blobKey(key, own), key, true)
+	}
+	blob := blobKey(key, sum)
+	_, err = s.ObjectStore.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(blob)})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	existed := err == nil
+	// The reference comes first, so a concurrent release of the blob
+	// sees it.
+	if err := s.refs.Add(ctx, blob, key, existed); err != nil {
+		return fmt.Errorf("reference %s: %w", blob, err)
+	}
+	if !existed {
+		_, err := s.ObjectStore.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(bucketName),
+			Key:        aws.String(blob),
+			CopySource: aws.String(bucketName + "/" + key),
+		})
+		if err != nil {
+			return fmt.Errorf("copy %s to %s: %w", key, blob, err)
+		}
+	}
+	metadata := maps.Clone(head.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[contentSumMetadata] = sum
+	_, err = s.ObjectStore.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(bucketName),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(nil),
+		ContentType:  head.ContentType,
+		CacheControl: head.CacheControl,
+		Metadata:     metadata,
+	})
+	return err
+}
+
+// release drops the reference of the original key to blob, deleting the
+// blob if it was the last.
+func (s *contentStore) release(ctx context.Context, bucketName, blob, key string) error {
+	last, err := s.refs.Remove(ctx, blob, key)
+	if err != nil || !last {
+		return err
+	}
+	_, err = s.ObjectStore.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucketName),
+		Delete: &s3types.Delete{Objects: []s3types.ObjectIdentifier{{Key: aws.String(blob)}}, Quiet: aws.Bool(true)},
+	})
+	if err == nil {
+		slog.InfoContext(ctx, "deleted unreferenced blob", "blob", blob)
+	}
+	return err
+}
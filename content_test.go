@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestContentAddressedStorage(t *testing.T) {
+	ctx := context.Background()
+	raw, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := seedImage(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := addressContent(raw, newContentRefs(nil, ""))
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        content,
+		Content:   content,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+	ingest := func(id string, data []byte) *IngestRecord {
+		t.Helper()
+		_, err := raw.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(data), ContentType: aws.String("image/jpeg")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ingestImage(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		record, err := meta.ImageRecord(ctx, id)
+		if err != nil || record == nil || record.Ingest == nil {
+			t.Fatalf("%s: record %+v, %v", id, record, err)
+		}
+		return record.Ingest
+	}
+	size := func(key string) int64 {
+		t.Helper()
+		head, err := raw.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(key)})
+		if isNotFound(err) {
+			return -1
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return aws.ToInt64(head.ContentLength)
+	}
+
+	for _, id := range []string{"cal-01", "cal-02"} {
+		if rec := ingest(id, frame); !rec.ContentAddressed || rec.Status != IngestIngested {
+			t.Fatalf("%s: ingest %+v", id, rec)
+		}
+		if n := size(imageKey(id)); n != 0 {
+			t.Errorf("%s: pointer of %d bytes", id, n)
+		}
+	}
+	blob := blobKey(imageKey("cal-01"), contentSHA256(frame))
+	if n := size(blob); n != int64(len(frame)) {
+		t.Fatalf("blob of %d bytes, want %d", n, len(frame))
+	}
+	// The pointer it wrote is not ingested again.
+	if err := api.ingestImage(ctx, "cal-02"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := content.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey("cal-02"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(out.Body)
+	out.Body.Close()
+	if !bytes.Equal(got, frame) || aws.ToString(out.ContentType) != "image/jpeg" || out.Metadata[contentSumMetadata] == "" {
+		t.Errorf("read %d bytes of %s", len(got), aws.ToString(out.ContentType))
+	}
+	out, err = content.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey("cal-02")), Range: aws.String("bytes=0-7")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = io.ReadAll(out.Body)
+	out.Body.Close()
+	if !bytes.Equal(got, frame[:8]) {
+		t.Errorf("range read %x, want %x", got, frame[:8])
+	}
+	head, err := content.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey("cal-01"))})
+	if err != nil || aws.ToInt64(head.ContentLength) != int64(len(frame)) {
+		t.Errorf("head: %v, %v", head, err)
+	}
+
+	// Replacing cal-01 leaves the blob to cal-02, and deleting that
+	// deletes the blob.
+	if rec := ingest("cal-01", other); !rec.ContentAddressed {
+		t.Fatalf("reupload: ingest %+v", rec)
+	}
+	if size(blob) < 0 || size(blobKey(imageKey("cal-01"), contentSHA256(other))) != int64(len(other)) {
+		t.Fatal("blobs missing after reupload")
+	}
+	if err := deleteKeys(ctx, content, "sat", []string{imageKey("cal-02")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := size(blob); n != -1 {
+		t.Errorf("unreferenced blob of %d bytes kept", n)
+	}
+}
+
+func TestContentRefsPinned(t *testing.T) {
+	ctx := context.Background()
+	refs := newContentRefs(nil, "")
+	if err := refs.Add(ctx, "blobs/a", "images/x.jpg", true); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := refs.Remove(ctx, "blobs/a", "images/x.jpg"); err != nil || last {
+		t.Errorf("a blob stored before it was counted was released: %v, %v", last, err)
+	}
+	if last, err := refs.Remove(ctx, "blobs/b", "images/y.jpg"); err != nil || last {
+		t.Errorf("an unknown reference was released: %v, %v", last, err)
+	}
+}
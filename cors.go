@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// CORSConfig is the cross-origin policy. Origins are exact
+// (https://mission.example.com), "*" for any, or hold one "*" wildcard
+// (https://*.example.com, http://localhost:*).
+type CORSConfig struct {
+	Origins       []string
+	Methods       []string
+	Headers       []string
+	ExposeHeaders []string
+	Credentials   bool
+	MaxAge        time.Duration
+}
+
+// corsPresets are the starting points CORS_PRESET selects; the other CORS_
+// variables override single fields of them.
+var corsPresets = map[string]CORSConfig{
+	"": {
+		Origins:       []string{defaultCORSOrigin},
+		Methods:       []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		Headers:       []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		ExposeHeaders: []string{"Content-Length", "Content-Range", "Accept-Ranges", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Deprecation", "Sunset", "Link"},
+		Credentials:   true,
+	},
+	// dev admits a frontend served from any local port.
+	"dev": {
+		Origins:       []string{"http://localhost:*", "http://127.0.0.1:*"},
+		Methods:       []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		Headers:       []string{"Origin", "Content-Type", "Accept", "Authorization", "If-None-Match", "If-Modified-Since", "Range"},
+		ExposeHeaders: []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Deprecation", "Sunset", "Link"},
+		Credentials:   true,
+		MaxAge:        10 * time.Minute,
+	},
+}
+
+// splitList parses a comma-separated setting, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadCORSConfig reads CORS_PRESET, CORS_ORIGINS, CORS_METHODS,
+// CORS_HEADERS, CORS_EXPOSE_HEADERS, CORS_ALLOW_CREDENTIALS and CORS_MAX_AGE.
+func loadCORSConfig() (CORSConfig, []error) {
+	preset := strings.ToLower(os.Getenv("CORS_PRESET"))
+	c, ok := corsPresets[preset]
+	if !ok {
+		return c, []error{fmt.Errorf("CORS_PRESET %q is not dev or unset", preset)}
+	}
+	var errs []error
+	lists := []struct {
+		name string
+		dst  *[]string
+	}{
+		{"CORS_ORIGINS", &c.Origins},
+		{"CORS_METHODS", &c.Methods},
+		{"CORS_HEADERS", &c.Headers},
+		{"CORS_EXPOSE_HEADERS", &c.ExposeHeaders},
+	}
+	for _, l := range lists {
+		if v, ok := os.LookupEnv(l.name); ok {
+			*l.dst = splitList(v)
+		}
+	}
+	for i, m := range c.Methods {
+		c.Methods[i] = strings.ToUpper(m)
+	}
+
+	anyOrigin := false
+	for _, o := range c.Origins {
+		anyOrigin = anyOrigin || o == "*"
+	}
+	if v, ok := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CORS_ALLOW_CREDENTIALS %q is not true or false", v))
+		}
+		c.Credentials = b
+		if b && anyOrigin {
+			errs = append(errs, errors.New("CORS_ALLOW_CREDENTIALS=true cannot be combined with the * origin; list the origins instead"))
+		}
+	} else if anyOrigin {
+		// Browsers refuse credentials on a response allowing any origin.
+		c.Credentials = false
+	}
+	if v := os.Getenv("CORS_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("CORS_MAX_AGE %q is not a duration", v))
+		}
+		c.MaxAge = d
+	}
+	return c, append(errs, c.validate()...)
+}
+
+func (c CORSConfig) validate() []error {
+	var errs []error
+	if len(c.Origins) == 0 {
+		errs = append(errs, errors.New("CORS_ORIGINS is set but lists no origins"))
+	}
+	for _, o := range c.Origins {
+		if o == "*" {
+			continue
+		}
+		if err := checkOrigin(o); err != nil {
+			errs = append(errs, fmt.Errorf("CORS origin %q %v", o, err))
+		}
+	}
+	if len(c.Methods) == 0 {
+		errs = append(errs, errors.New("CORS_METHODS is set but lists no methods"))
+	}
+	return errs
+}
+
+// checkOrigin accepts scheme://host[:port], where one "*" may stand for a
+// part of the host or for the port.
+func checkOrigin(o string) error {
+	if strings.Count(o, "*") > 1 {
+		return errors.New("may hold only one *")
+	}
+	scheme, rest, ok := strings.Cut(o, "://")
+	if !ok || (scheme != "http" && scheme != "https") {
+		return errors.New("must start with http:// or https://")
+	}
+	// Stand a valid port or host label in for the wildcard so url.Parse
+	// checks the rest.
+	rest = strings.ReplaceAll(strings.Replace(rest, ":*", ":1", 1), "*", "wildcard")
+	u, err := url.Parse(scheme + "://" + rest)
+	if err != nil || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return errors.New("must be scheme://host[:port] with no path")
+	}
+	return nil
+}
+
+// middleware applies the policy. A "*" origin allows every origin.
+func (c CORSConfig) middleware() gin.HandlerFunc {
+	cfg := cors.Config{
+		AllowOrigins:     c.Origins,
+		AllowMethods:     c.Methods,
+		AllowHeaders:     c.Headers,
+		ExposeHeaders:    c.ExposeHeaders,
+		AllowCredentials: c.Credentials,
+		MaxAge:           c.MaxAge,
+		AllowWildcard:    true,
+	}
+	for _, o := range c.Origins {
+		if o == "*" {
+			cfg.AllowOrigins, cfg.AllowAllOrigins = nil, true
+			break
+		}
+	}
+	return cors.New(cfg)
+}
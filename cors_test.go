@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadCORSConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		origins     []string
+		credentials bool
+		maxAge      time.Duration
+		wantErr     []string
+	}{
+		{
+			name:        "default",
+			origins:     []string{defaultCORSOrigin},
+			credentials: true,
+		},
+		{
+			name:        "dev preset",
+			env:         map[string]string{"CORS_PRESET": "dev"},
+			origins:     []string{"http://localhost:*", "http://127.0.0.1:*"},
+			credentials: true,
+			maxAge:      10 * time.Minute,
+		},
+		{
+			name:        "override of a preset field",
+			env:         map[string]string{"CORS_PRESET": "dev", "CORS_ORIGINS": "https://*.example.com", "CORS_MAX_AGE": "1h"},
+			origins:     []string{"https://*.example.com"},
+			credentials: true,
+			maxAge:      time.Hour,
+		},
+		{
+			name:    "any origin drops credentials",
+			env:     map[string]string{"CORS_ORIGINS": "*"},
+			origins: []string{"*"},
+		},
+		{
+			name:    "any origin with credentials",
+			env:     map[string]string{"CORS_ORIGINS": "*", "CORS_ALLOW_CREDENTIALS": "true"},
+			wantErr: []string{"cannot be combined"},
+		},
+		{
+			name:    "bad origins",
+			env:     map[string]string{"CORS_ORIGINS": "https://*.*.example.com, example.com, https://x.example.com/app"},
+			wantErr: []string{"only one *", `"example.com"`, `"https://x.example.com/app"`},
+		},
+		{
+			name:    "bad values",
+			env:     map[string]string{"CORS_PRESET": "open"},
+			wantErr: []string{`CORS_PRESET "open"`},
+		},
+		{
+			name:    "bad credentials and max age",
+			env:     map[string]string{"CORS_ALLOW_CREDENTIALS": "sometimes", "CORS_MAX_AGE": "-1m", "CORS_METHODS": ""},
+			wantErr: []string{"CORS_ALLOW_CREDENTIALS", "CORS_MAX_AGE", "lists no methods"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, errs := loadCORSConfig()
+			if len(tt.wantErr) > 0 {
+				msg := ""
+				for _, err := range errs {
+					msg += err.Error() + "\n"
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(msg, want) {
+						t.Errorf("errors %q do not mention %s", msg, want)
+					}
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			if !slices.Equal(c.Origins, tt.origins) || c.Credentials != tt.credentials || c.MaxAge != tt.maxAge {
+				t.Errorf("got %+v", c)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		cors   CORSConfig
+		origin string
+		allow  string
+	}{
+		{"exact match", corsPresets[""], defaultCORSOrigin, defaultCORSOrigin},
+		{"exact mismatch", corsPresets[""], "https://evil.example.com", ""},
+		{"port wildcard", corsPresets["dev"], "http://localhost:5173", "http://localhost:5173"},
+		{"port wildcard other host", corsPresets["dev"], "http://example.com:5173", ""},
+		{"subdomain wildcard", CORSConfig{Origins: []string{"https://*.example.com"}, Methods: []string{"GET"}},
+			"https://a.example.com", "https://a.example.com"},
+		{"any origin", CORSConfig{Origins: []string{"*"}, Methods: []string{"GET"}}, "https://anything.test", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(tt.cors.middleware())
+			router.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+
+			req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			req.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
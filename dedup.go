@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// INGEST_DUPLICATES says what ingest does with an upload that copies an
+// image already stored.
+const (
+	// duplicatesKeep ingests it as usual, noting the image it copies.
+	duplicatesKeep = "keep"
+	// duplicatesLink deletes it and links the image it copies to the
+	// mission the upload's ID names instead.
+	duplicatesLink = "link"
+	// duplicatesReject deletes it and records it as rejected.
+	duplicatesReject = "reject"
+)
+
+// defaultDuplicateDistance is how many of the 64 perceptual hash bits an
+// upload may differ in from a stored image and still be a near duplicate:
+// enough for recompression or resampling, not for another frame.
+const defaultDuplicateDistance = 2
+
+const (
+	MatchExact = "exact"
+	MatchNear  = "near"
+)
+
+// DuplicateMatch is the stored image an upload copies.
+type DuplicateMatch struct {
+	ImageID string `dynamodbav:"image_id" json:"image_id"`
+	// Match is "exact" for the same bytes and "near" for the same picture,
+	// Distance bits of its perceptual hash apart.
+	Match    string `dynamodbav:"match" json:"match"`
+	Distance int    `dynamodbav:"distance" json:"distance"`
+}
+
+// errDuplicateImage stops the generation of a near duplicate's derivatives.
+var errDuplicateImage = errors.New("duplicate image")
+
+// exactDuplicate returns the image of ctx's tenant, other than id, uploaded
+// with checksum sum.
+func (api *API) exactDuplicate(ctx context.Context, id, sum string) *DuplicateMatch {
+	other, ok := api.Hashes.Exact(tenantRecordID(ctx, ""), id, sum)
+	if !ok {
+		return nil
+	}
+	return &DuplicateMatch{ImageID: other, Match: MatchExact}
+}
+
+// nearDuplicate returns the image of ctx's tenant, other than id, whose
+// perceptual hash is nearest hash within INGEST_DUPLICATE_DISTANCE bits.
+func (api *API) nearDuplicate(ctx context.Context, id string, hash uint64) *DuplicateMatch {
+	matches := api.Hashes.Near(tenantRecordID(ctx, ""), id, hash, api.Config.DuplicateDistance, 1)
+	if len(matches) == 0 {
+		return nil
+	}
+	return &DuplicateMatch{ImageID: matches[0].id, Match: MatchNear, Distance: matches[0].distance}
+}
+
+// handleDuplicate deletes the upload id, which copies rec.Duplicate, and
+// links or rejects it as INGEST_DUPLICATES says.
+func (api *API) handleDuplicate(ctx context.Context, id string, rec IngestRecord) error {
+	dup := rec.Duplicate
+	bucketName := api.Config.ImagesBucket
+	_, err := api.S3.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucketName),
+		Delete: &s3types.Delete{Objects: []s3types.ObjectIdentifier{{Key: aws.String(imageKey(id))}}, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("delete duplicate upload: %w", err)
+	}
+	if api.Config.Duplicates == duplicatesReject {
+		return api.rejectImage(ctx, id, rec, fmt.Sprintf("%s duplicate of %s", dup.Match, dup.ImageID))
+	}
+	if rec.MissionID, err = api.linkImage(ctx, id, dup.ImageID); err != nil {
+		return err
+	}
+	rec.Status = IngestDuplicate
+	slog.InfoContext(ctx, "linked duplicate upload", "id", id, "duplicate_of", dup.ImageID, "match", dup.Match, "mission", rec.MissionID)
+	api.recordIngest(ctx, id, rec)
+	ingestedImages.WithLabelValues(IngestDuplicate).Inc()
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/disintegration/imaging"
+)
+
+func TestIngestDuplicates(t *testing.T) {
+	encode := func(side, quality int) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, spacecraft(side, 0), imaging.JPEG, imaging.JPEGQuality(quality)); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	original := encode(512, 90)
+	var other bytes.Buffer
+	if err := imaging.Encode(&other, spacecraft(512, 2), imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+	uploads := []struct {
+		id   string
+		data []byte
+	}{
+		{"m2-00", original},
+		{"m2-01", encode(400, 60)},
+		{"m2-02", other.Bytes()},
+	}
+
+	tests := []struct {
+		mode    string
+		status  [3]string
+		match   [3]string
+		mission []string
+	}{
+		{duplicatesKeep, [3]string{IngestIngested, IngestIngested, IngestIngested}, [3]string{MatchExact, MatchNear, ""}, []string{"m2-00", "m2-01", "m2-02"}},
+		{duplicatesLink, [3]string{IngestDuplicate, IngestDuplicate, IngestIngested}, [3]string{MatchExact, MatchNear, ""}, []string{"m1-00", "m2-02"}},
+		{duplicatesReject, [3]string{IngestRejected, IngestRejected, IngestIngested}, [3]string{MatchExact, MatchNear, ""}, []string{"m2-02"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := newFSStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := testSQLStore(t)
+			for _, id := range []string{"m1", "m2"} {
+				if err := putMission(ctx, meta, &Mission{ID: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			api := &API{
+				Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: tt.mode, DuplicateDistance: defaultDuplicateDistance},
+				MissionDB: meta,
+				Images:    meta,
+				S3:        store,
+				Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+				Events:    newEventBus(),
+				Hashes:    newImageHashIndex(nil, ""),
+			}
+			ingest := func(id string, data []byte) *IngestRecord {
+				t.Helper()
+				_, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(data)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := api.ingestImage(ctx, id); err != nil {
+					t.Fatal(err)
+				}
+				record, err := meta.ImageRecord(ctx, id)
+				if err != nil || record == nil || record.Ingest == nil {
+					t.Fatalf("ImageRecord = %+v, %v", record, err)
+				}
+				return record.Ingest
+			}
+
+			if rec := ingest("m1-00", original); rec.Status != IngestIngested || rec.Duplicate != nil || len(rec.SHA256) != 64 {
+				t.Fatalf("original: %+v", rec)
+			}
+			for i, u := range uploads {
+				rec := ingest(u.id, u.data)
+				var match string
+				if rec.Duplicate != nil {
+					match = rec.Duplicate.Match
+					if rec.Duplicate.ImageID != "m1-00" || rec.Duplicate.Distance > defaultDuplicateDistance {
+						t.Errorf("%s: duplicate of %+v", u.id, rec.Duplicate)
+					}
+				}
+				if rec.Status != tt.status[i] || match != tt.match[i] {
+					t.Errorf("%s: %+v, want %s %q", u.id, rec, tt.status[i], tt.match[i])
+				}
+				_, err := store.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(u.id))})
+				if (err == nil) != (rec.Status == IngestIngested) {
+					t.Errorf("%s: upload kept: %v", u.id, err)
+				}
+			}
+			m, err := meta.Mission(ctx, "m2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(m.ImageIDs, tt.mission) {
+				t.Errorf("mission images %v, want %v", m.ImageIDs, tt.mission)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tileSize            = 256
+	pyramidQuality      = 90
+	derivativeQueueSize = 64
+
+	defaultDerivativeWorkers = 2
+)
+
+// PyramidManifest describes the zoom levels stored for an image. Level
+// MaxZoom is the original; every level below it halves the previous one
+// until the whole image fits in a single tile at level 0.
+type PyramidManifest struct {
+	ID       string `json:"id"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	TileSize int    `json:"tile_size"`
+	MaxZoom  int    `json:"max_zoom"`
+	Created  int64  `json:"created"`
+	// Overlay is the variant of the overlay stamped on every tile, empty
+	// when they are unmarked.
+	Overlay string `json:"overlay,omitempty"`
+}
+
+func pyramidLevelKey(id string, z int) string {
+	return fmt.Sprintf("pyramid/%s/%d.jpg", id, z)
+}
+
+func pyramidManifestKey(id string) string {
+	return fmt.Sprintf("pyramid/%s/manifest.json", id)
+}
+
+// maxZoom is the number of halvings needed for the larger side to fit a tile.
+func maxZoom(width, height int) int {
+	longest := max(width, height)
+	if longest <= tileSize {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(float64(longest) / tileSize)))
+}
+
+// LevelSize returns the pixel dimensions of zoom level z.
+func (m PyramidManifest) LevelSize(z int) (int, int) {
+	div := 1 << (m.MaxZoom - z)
+	return (m.Width + div - 1) / div, (m.Height + div - 1) / div
+}
+
+// DerivativeWorker pre-generates thumbnails, pyramid levels and tiles off the request
+// path. Work arrives from POST /images/:id/derivatives or, when
+// DERIVATIVES_QUEUE_URL is set, from S3 event notifications delivered via SQS,
+// whose images are ingested in full by ingestImage.
+type DerivativeWorker struct {
+	api      *API
+	sqs      *sqs.Client
+	queueURL string
+	workers  int
+	jobs     chan derivativeTask
+}
+
+// derivativeTask is an image to generate derivatives for, and the tenant it
+// belongs to.
+type derivativeTask struct {
+	tenant, id string
+}
+
+func newDerivativeWorker(api *API, sqsClient *sqs.Client, queueURL string, workers int) *DerivativeWorker {
+	return &DerivativeWorker{
+		api:      api,
+		sqs:      sqsClient,
+		queueURL: queueURL,
+		workers:  workers,
+		jobs:     make(chan derivativeTask, derivativeQueueSize),
+	}
+}
+
+// Start launches the worker goroutines and, if configured, the SQS poller.
+func (w *DerivativeWorker) Start(ctx context.Context) {
+	ctx = waitForCapacity(ctx)
+	for i := 0; i < w.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-w.jobs:
+					if err := w.api.generateDerivatives(withTenant(ctx, t.tenant), t.id, nil); err != nil {
+						slog.ErrorContext(ctx, "derivative generation failed", "id", t.id, "tenant", t.tenant, "err", err)
+					}
+				}
+			}
+		}()
+	}
+
+	if w.queueURL != "" && w.sqs != nil {
+		go w.pollQueue(ctx)
+	}
+}
+
+// Enqueue schedules id of ctx's tenant for generation. It reports false when
+// the queue is full.
+func (w *DerivativeWorker) Enqueue(ctx context.Context, id string) bool {
+	select {
+	case w.jobs <- derivativeTask{tenant: tenantFrom(ctx), id: id}:
+		return true
+	default:
+		return false
+	}
+}
+
+type s3EventNotification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// pollQueue long-polls SQS for ObjectCreated events. Messages are deleted only
+// once every image they reference has been processed, so failures are retried
+// after the visibility timeout. Messages that can never succeed are logged and
+// deleted rather than redelivered forever.
+func (w *DerivativeWorker) pollQueue(ctx context.Context) {
+	for ctx.Err() == nil {
+		out, err := w.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(w.queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.ErrorContext(ctx, "sqs ReceiveMessage failed", "queue", w.queueURL, "err", err)
+				time.Sleep(5 * time.Second)
+			}
+			continue
+		}
+
+		for _, msg := range out.Messages {
+			if err := w.handleMessage(ctx, aws.ToString(msg.Body)); err != nil {
+				if !isPermanent(err) {
+					slog.ErrorContext(ctx, "failed to handle derivatives message", "id", aws.ToString(msg.MessageId), "err", err)
+					continue
+				}
+				slog.WarnContext(ctx, "dropping derivatives message", "id", aws.ToString(msg.MessageId), "err", err)
+			}
+			_, err := w.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(w.queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil {
+				slog.ErrorContext(ctx, "sqs DeleteMessage failed", "id", aws.ToString(msg.MessageId), "err", err)
+			}
+		}
+	}
+}
+
+func (w *DerivativeWorker) handleMessage(ctx context.Context, body string) error {
+	var event s3EventNotification
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return permanent(fmt.Errorf("decode s3 event: %w", err))
+	}
+
+	for _, record := range event.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return permanent(fmt.Errorf("decode key %q: %w", record.S3.Object.Key, err))
+		}
+		// With MULTI_TENANT uploads land under their tenant's prefix.
+		ctx := ctx
+		if tenant, rest, ok := splitObjectKey(key); ok && w.api.Config.MultiTenant {
+			ctx, key = withTenant(ctx, tenant), rest
+		}
+		id, ok := imageIDFromKey(key)
+		if !ok {
+			continue
+		}
+		if err := w.api.ingestUpload(ctx, id, key); err != nil {
+			// One deleted image must not hold back the rest of the
+			// message.
+			if isPermanent(err) {
+				slog.WarnContext(ctx, "skipping ingest", "id", id, "err", err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// imageIDFromKey is the inverse of imageKey, and of sourceKey for every
+// source extension.
+func imageIDFromKey(key string) (string, bool) {
+	id, _, ok := sourceIDFromKey(key)
+	return id, ok
+}
+
+// generateDerivatives decodes the original once, scores its quality, and
+// writes every thumbnail size, pyramid level and tile for it. Smaller levels
+// are resampled from the level above rather than from the original to keep
+// the work proportional. TIFFs too large to decode in full are read a region
+// at a time instead, by generateRegionDerivatives. A non-nil accept is given
+// the perceptual hash of the decoded original before anything is stored, and
+// an error from it stops the generation; TIFFs read by region are not
+// checked.
+func (api *API) generateDerivatives(ctx context.Context, id string, accept func(phash uint64) error) error {
+	bucketName := api.Config.ImagesBucket
+	key := imageKey(id)
+
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+		Range:  firstPart(),
+	}, s3Accelerate(bucketName)...)
+	if err != nil {
+		return err
+	}
+	body := bufio.NewReaderSize(out.Body, cogHeaderBytes)
+	if head, _ := body.Peek(cogHeaderBytes); isTIFFHeader(head) && objectSize(out) >= cogMinBytes {
+		src, err := openCOG(&s3RangeReader{ctx: ctx, client: api.S3, bucket: bucketName, key: key, head: head}, objectSize(out))
+		if err == nil && checkPixels(int64(src.levels[0].width)*int64(src.levels[0].height)) != nil {
+			out.Body.Close()
+			return api.generateRegionDerivatives(ctx, bucketName, id, src, head)
+		}
+	}
+	buf, err := api.readObject(ctx, bucketName, key, out, body)
+	out.Body.Close()
+	if err != nil {
+		return fmt.Errorf("read %s: %w", key, err)
+	}
+	defer putBuffer(buf)
+	data := buf.Bytes()
+	pixels, err := sourcePixels(data)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", key, err)
+	}
+	release, err := api.Limiter.Acquire(ctx, pixels*bytesPerDecodedPixel)
+	if err != nil {
+		return err
+	}
+	defer release()
+	src, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return permanent(fmt.Errorf("decode %s: %w", key, err))
+	}
+
+	hash := perceptualHash(src)
+	if accept != nil {
+		if err := accept(hash); err != nil {
+			return err
+		}
+	}
+	api.recordTags(ctx, id, src, hash, data)
+
+	manifest := api.newPyramidManifest(id, src.Bounds().Dx(), src.Bounds().Dy())
+	if err := api.putTiles(ctx, bucketName, id, manifest.MaxZoom, src, image.Point{}); err != nil {
+		return err
+	}
+	return api.finishPyramid(ctx, bucketName, manifest, manifest.MaxZoom, src)
+}
+
+// generateRegionDerivatives builds the pyramid of a TIFF over the decode
+// limit from blocks of its full-resolution level. Each block is cut into
+// tiles for the top k levels, and its smallest version is pasted into level
+// MaxZoom-k, the first that fits in memory, from which the rest of the
+// pyramid is resampled as usual. Levels above it are stored only as tiles.
+func (api *API) generateRegionDerivatives(ctx context.Context, bucketName, id string, src *cogSource, head []byte) error {
+	full := src.levels[0]
+	manifest := api.newPyramidManifest(id, full.width, full.height)
+	limit := imagePixelLimit()
+
+	k := 1
+	for k < manifest.MaxZoom {
+		w, h := manifest.LevelSize(manifest.MaxZoom - k)
+		if int64(w)*int64(h) <= limit {
+			break
+		}
+		k++
+	}
+	block := tileSize << k
+	if err := checkPixels(full.coveredPixels(image.Rect(0, 0, block, block))); err != nil {
+		return err
+	}
+
+	rw, rh := manifest.LevelSize(manifest.MaxZoom - k)
+	reduced := image.NewNRGBA(image.Rect(0, 0, rw, rh))
+	bounds := image.Rect(0, 0, full.width, full.height)
+	for by := 0; by < full.height; by += block {
+		for bx := 0; bx < full.width; bx += block {
+			r := image.Rect(bx, by, bx+block, by+block).Intersect(bounds)
+			if err := api.putBlockTiles(ctx, bucketName, src, manifest, k, r, reduced); err != nil {
+				return fmt.Errorf("block %v of %s: %w", r.Min, id, err)
+			}
+		}
+	}
+
+	api.recordTags(ctx, id, reduced, perceptualHash(reduced), head)
+
+	z := manifest.MaxZoom - k
+	if err := api.putTiles(ctx, bucketName, id, z, reduced, image.Point{}); err != nil {
+		return err
+	}
+	return api.finishPyramid(ctx, bucketName, manifest, z, reduced)
+}
+
+// putBlockTiles reads region r of the full-resolution level, stores its tiles
+// for levels MaxZoom down to MaxZoom-k+1, and draws it at level MaxZoom-k into
+// reduced. r starts on a multiple of the block size, so at every level its
+// tiles line up with the level's tile grid.
+func (api *API) putBlockTiles(ctx context.Context, bucketName string, src *cogSource, manifest PyramidManifest, k int, r image.Rectangle, reduced *image.NRGBA) error {
+	release, err := api.Limiter.Acquire(ctx, src.levels[0].coveredPixels(r)*bytesPerDecodedPixel)
+	if err != nil {
+		return err
+	}
+	defer release()
+	img, err := src.readRegion(ctx, 0, r)
+	if err != nil {
+		return err
+	}
+
+	for j := 0; j <= k; j++ {
+		z := manifest.MaxZoom - j
+		lw, lh := manifest.LevelSize(z)
+		x0, y0 := r.Min.X>>j, r.Min.Y>>j
+		w, h := min((r.Dx()+1<<j-1)>>j, lw-x0), min((r.Dy()+1<<j-1)>>j, lh-y0)
+
+		level := img
+		if j > 0 {
+			level = imaging.Resize(img, w, h, imaging.Lanczos)
+		}
+		if j == k {
+			draw.Draw(reduced, image.Rect(x0, y0, x0+w, y0+h), level, level.Bounds().Min, draw.Src)
+			break
+		}
+		if err := api.putTiles(ctx, bucketName, manifest.ID, z, level, image.Pt(x0/tileSize, y0/tileSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (api *API) newPyramidManifest(id string, width, height int) PyramidManifest {
+	return PyramidManifest{
+		ID:       id,
+		Width:    width,
+		Height:   height,
+		TileSize: tileSize,
+		MaxZoom:  maxZoom(width, height),
+		Created:  time.Now().Unix(),
+		Overlay:  api.Overlay.variant(),
+	}
+}
+
+// recordTags scores src, stores its perceptual hash and the tags read from
+// data. They are advisory; a failure here should not hold up the derivatives viewers are
+// waiting on.
+func (api *API) recordTags(ctx context.Context, id string, src image.Image, hash uint64, data []byte) {
+	if err := api.recordQuality(ctx, id, src); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to record quality", "id", id, "err", err)
+	}
+	if err := api.recordEXIF(ctx, id, data); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to record exif", "id", id, "err", err)
+	}
+	if err := api.recordHash(ctx, id, hash); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to record perceptual hash", "id", id, "err", err)
+	}
+	if err := api.recordFITS(ctx, id, data); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to record fits header", "id", id, "err", err)
+	}
+}
+
+// finishPyramid resamples the levels below z from level, whose tiles are
+// already stored, then writes the thumbnails and, last, the manifest.
+func (api *API) finishPyramid(ctx context.Context, bucketName string, manifest PyramidManifest, z int, level image.Image) error {
+	id := manifest.ID
+	thumbSource := level
+	for z--; z >= 0; z-- {
+		w, h := manifest.LevelSize(z)
+		level = imaging.Resize(level, w, h, imaging.Lanczos)
+		if _, err := api.putJPEG(ctx, bucketName, pyramidLevelKey(id, z), level, pyramidQuality); err != nil {
+			return err
+		}
+		if err := api.putTiles(ctx, bucketName, id, z, level, image.Point{}); err != nil {
+			return err
+		}
+		if max(w, h) >= 512 {
+			thumbSource = level
+		}
+	}
+
+	for size := range thumbnailSizes {
+		var thumb image.Image = imaging.Fit(thumbSource, size, size, imaging.Lanczos)
+		if api.Overlay != nil {
+			thumb = drawOverlay(thumb, api.Overlay)
+		}
+		if _, err := api.putJPEG(ctx, bucketName, thumbnailKey(id, size, api.Overlay), thumb, thumbnailQuality); err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(pyramidManifestKey(id)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("put manifest: %w", err)
+	}
+
+	slog.InfoContext(ctx, "generated derivatives", "id", id, "levels", manifest.MaxZoom)
+	return nil
+}
+
+// putJPEG encodes img and stores it at key. The encoded bytes are returned
+// even when only the S3 write failed.
+func (api *API) putJPEG(ctx context.Context, bucketName, key string, img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(quality)); err != nil {
+		return nil, fmt.Errorf("encode %s: %w", key, err)
+	}
+	_, err := api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(bucketName),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(buf.Bytes()),
+		ContentType:  aws.String("image/jpeg"),
+		CacheControl: aws.String(thumbnailCacheControl),
+	})
+	if err != nil {
+		return buf.Bytes(), fmt.Errorf("put %s: %w", key, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (api *API) postDerivatives(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	key := imageKey(id)
+	_, err := api.S3.HeadObject(c.Request.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "s3 HeadObject failed", "key", key, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to look up image")
+		return
+	}
+
+	if !api.Derivatives.Enqueue(c.Request.Context(), id) {
+		respondError(c, http.StatusServiceUnavailable, CodeQueueFull, "derivative queue is full")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": "queued"})
+}
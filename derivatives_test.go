@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestMaxZoom(t *testing.T) {
+	tests := []struct {
+		width, height, want int
+	}{
+		{1, 1, 0},
+		{256, 100, 0},
+		{257, 100, 1},
+		{100, 512, 1},
+		{1024, 1024, 2},
+		{1025, 10, 3},
+	}
+	for _, tt := range tests {
+		if got := maxZoom(tt.width, tt.height); got != tt.want {
+			t.Errorf("maxZoom(%d, %d) = %d, want %d", tt.width, tt.height, got, tt.want)
+		}
+	}
+
+	m := PyramidManifest{Width: 1000, Height: 301, MaxZoom: 2}
+	for z, want := range [][2]int{{250, 76}, {500, 151}, {1000, 301}} {
+		if w, h := m.LevelSize(z); w != want[0] || h != want[1] {
+			t.Errorf("LevelSize(%d) = %dx%d, want %dx%d", z, w, h, want[0], want[1])
+		}
+	}
+}
+
+func TestGenerateDerivatives(t *testing.T) {
+	ctx := context.Background()
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, store, imageKey("a"), frame)
+	meta := testSQLStore(t)
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store, MissionDB: meta, Images: meta, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	if err := api.generateDerivatives(ctx, "a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := store.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(pyramidManifestKey("a"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest PyramidManifest
+	err = json.NewDecoder(out.Body).Decode(&manifest)
+	out.Body.Close()
+	if err != nil || manifest.Width != seedImageSize || manifest.Height != seedImageSize || manifest.MaxZoom != 2 || manifest.TileSize != tileSize {
+		t.Fatalf("manifest %+v: %v", manifest, err)
+	}
+
+	// The 1024px original is 4×4 tiles, halved twice down to one.
+	keys := []string{pyramidLevelKey("a", 0), pyramidLevelKey("a", 1), tileKey("a", nil, 0, 0, 0), tileKey("a", nil, 1, 1, 1), tileKey("a", nil, 2, 3, 3)}
+	for size := range thumbnailSizes {
+		keys = append(keys, thumbnailKey("a", size, nil))
+	}
+	for _, key := range keys {
+		out, err := store.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key)})
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+			continue
+		}
+		io.Copy(io.Discard, out.Body)
+		out.Body.Close()
+	}
+	for _, key := range []string{pyramidLevelKey("a", 2), tileKey("a", nil, 2, 4, 0), tileKey("a", nil, 1, 2, 0)} {
+		if _, err := store.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key)}); !isNotFound(err) {
+			t.Errorf("%s stored: %v", key, err)
+		}
+	}
+
+	if err := api.generateDerivatives(ctx, "gone", nil); !isPermanent(err) {
+		t.Errorf("missing original: %v", err)
+	}
+}
+
+func TestImageIDFromKey(t *testing.T) {
+	for key, want := range map[string]string{
+		"images/a.jpg":     "a",
+		"images/m31.fits":  "m31",
+		"images/a/b.jpg":   "",
+		"thumbnails/a.jpg": "",
+		"images/.jpg":      "",
+	} {
+		id, ok := imageIDFromKey(key)
+		if id != want || ok != (want != "") {
+			t.Errorf("imageIDFromKey(%q) = %q, %v", key, id, ok)
+		}
+	}
+}
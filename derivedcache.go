@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// derivedCacheVersion is part of every cache key. Bump it when a change
+	// to processing would alter the output for the same parameters.
+	derivedCacheVersion = 1
+
+	defaultDerivedTTL    = 7 * 24 * time.Hour
+	derivedSweepInterval = time.Hour
+	// A hit older than this rewrites the object's timestamp, so the sweep
+	// sees it as recently used. Capped at half the TTL.
+	derivedTouchAfter = 24 * time.Hour
+	derivedPutTimeout = time.Minute
+)
+
+// DerivedCache keeps processed /image/:id output in S3 under
+// derived/<id>/<hash>.<ext>, within the tenant's prefix under MULTI_TENANT,
+// so repeating a request is a single S3 read instead of a decode and
+// encode. The hash covers the source object's ETag, so replacing an image
+// orphans its old entries rather than serving them. A sweep deletes
+// entries unused for DERIVED_CACHE_TTL (default 168h) and, once
+// DERIVED_CACHE_MAX_MB is exceeded by all tenants together, the least
+// recently used ones.
+type DerivedCache struct {
+	s3       ObjectStore
+	bucket   string
+	ttl      time.Duration
+	maxBytes int64
+}
+
+// newDerivedCache returns a cache keeping entries unused for ttl in bucket,
+// up to maxBytes or unbounded when it is 0. It returns nil, disabling the
+// cache, when ttl is 0.
+func newDerivedCache(client ObjectStore, bucket string, ttl time.Duration, maxBytes int64) *DerivedCache {
+	if ttl == 0 {
+		return nil
+	}
+	return &DerivedCache{s3: client, bucket: bucket, ttl: ttl, maxBytes: maxBytes}
+}
+
+func derivedPrefix(id string) string {
+	return fmt.Sprintf("derived/%s/", id)
+}
+
+// processedHash identifies the output of rendering the source version
+// sourceETag with o.
+func processedHash(sourceETag string, o ProcessOptions) string {
+	sum := sha256.Sum256([]byte(sourceETag + "\n" + o.fingerprint()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// derivedKey names the cache entry for id rendered with o from the source
+// version sourceETag.
+func derivedKey(id, sourceETag string, o ProcessOptions) string {
+	return derivedPrefix(id) + processedHash(sourceETag, o) + "." + o.Format.Extension
+}
+
+// processedETag is the ETag of processed output. It is stable across
+// instances and restarts, and changes with the source or any parameter.
+func processedETag(sourceETag string, o ProcessOptions) string {
+	return `"` + processedHash(sourceETag, o) + `"`
+}
+
+// fingerprint renders every option that affects the output in a fixed order,
+// so equal requests produce equal strings however their parameters were
+// spelled.
+func (o ProcessOptions) fingerprint() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "v%d w=%d h=%d contrast=%g", derivedCacheVersion, o.Width, o.Height, o.Contrast)
+	if o.Crop != nil {
+		fmt.Fprintf(&b, " crop=%d,%d,%d,%d", o.Crop.Min.X, o.Crop.Min.Y, o.Crop.Dx(), o.Crop.Dy())
+	}
+	if o.Scale != nil {
+		fmt.Fprintf(&b, " scale=%s:%g,%g", o.Scale.Mode, o.Scale.Lo, o.Scale.Hi)
+	}
+	if o.Stars != nil {
+		fmt.Fprintf(&b, " stars=%d:%g", o.Stars.Cell, o.Stars.Sigma)
+	}
+	if o.Format != nil {
+		fmt.Fprintf(&b, " format=%s", o.Format.Name)
+	}
+	fmt.Fprintf(&b, " quality=%d lossless=%t compression=%s strip=%t", o.Encode.Quality, o.Encode.Lossless, o.Encode.Compression, o.StripMetadata)
+	if o.Annotate {
+		b.WriteString(" annotations=")
+		for _, a := range o.Annotations {
+			fmt.Fprintf(&b, "[%s %d %d %d %d %d %q %s %d]", a.Type, a.X, a.Y, a.Width, a.Height, a.Radius, a.Label, a.Color, a.StrokeWidth)
+		}
+	}
+	if o.DrawObjects {
+		b.WriteString(" objects=")
+		for _, d := range o.Objects {
+			fmt.Fprintf(&b, "[%q %g %g %g %g %g]", d.Label, d.Confidence, d.Box[0], d.Box[1], d.Box[2], d.Box[3])
+		}
+	}
+	if o.Overlay != nil {
+		fmt.Fprintf(&b, " overlay=%q %s %g %v %v", o.Overlay.Text, o.Overlay.Position, o.Overlay.Opacity, o.Overlay.Color, o.Overlay.Background)
+	}
+	return b.String()
+}
+
+// Get reads the cached entry for key. Read errors other than a miss are
+// logged and treated as one, so the caller renders instead.
+func (d *DerivedCache) Get(ctx context.Context, bucketName, key string) (cachedObject, bool) {
+	out, err := d.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if !isNotFound(err) {
+			slog.ErrorContext(ctx, "derived cache read failed", "key", key, "err", err)
+		}
+		recordCacheLookup("derived", false)
+		return cachedObject{}, false
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		slog.ErrorContext(ctx, "derived cache read failed", "key", key, "err", err)
+		recordCacheLookup("derived", false)
+		return cachedObject{}, false
+	}
+	recordCacheLookup("derived", true)
+	if out.LastModified != nil && time.Since(*out.LastModified) > min(derivedTouchAfter, d.ttl/2) {
+		go d.touch(ctx, bucketName, key, aws.ToString(out.ContentType))
+	}
+	return cachedObject{Data: data, ContentType: aws.ToString(out.ContentType), CacheControl: "private, max-age=3600"}, true
+}
+
+// Put stores a rendered response in the background; a failed write only
+// costs a future re-render. The write outlives ctx but keeps its tenant.
+func (d *DerivedCache) Put(ctx context.Context, bucketName, key, contentType string, data []byte) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), derivedPutTimeout)
+		defer cancel()
+		_, err := d.s3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String(contentType),
+		})
+		if err != nil {
+			slog.Error("derived cache write failed", "key", key, "err", err)
+		}
+	}()
+}
+
+// touch copies an entry onto itself, which resets its LastModified. S3 keeps
+// no access times, so this is what lets the sweep evict by last use.
+func (d *DerivedCache) touch(ctx context.Context, bucketName, key, contentType string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), derivedPutTimeout)
+	defer cancel()
+	_, err := d.s3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(bucketName),
+		Key:               aws.String(key),
+		CopySource:        aws.String(bucketName + "/" + key),
+		ContentType:       aws.String(contentType),
+		Metadata:          map[string]string{"touched": strconv.FormatInt(time.Now().Unix(), 10)},
+		MetadataDirective: s3types.MetadataDirectiveReplace,
+	})
+	if err != nil {
+		slog.Error("derived cache touch failed", "key", key, "err", err)
+	}
+}
+
+// Start runs the sweep every hour until ctx is done.
+func (d *DerivedCache) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(derivedSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.sweep(ctx, d.bucket); err != nil {
+					slog.ErrorContext(ctx, "derived cache sweep failed", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// sweep deletes entries older than the TTL, then the oldest remaining ones
+// until the cache fits in maxBytes.
+func (d *DerivedCache) sweep(ctx context.Context, bucketName string) error {
+	var keep []s3types.Object
+	var expired []string
+	var total int64
+	cutoff := time.Now().Add(-d.ttl)
+
+	// Each tenant's entries are under its own derived/, found by walking
+	// all of the tenants' objects.
+	for _, prefix := range []string{"derived/", tenantObjectRoot} {
+		pages := s3.NewListObjectsV2Paginator(d.s3, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucketName),
+			Prefix: aws.String(prefix),
+		})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("list derived: %w", err)
+			}
+			for _, obj := range page.Contents {
+				if _, rest, ok := splitObjectKey(aws.ToString(obj.Key)); prefix == tenantObjectRoot && (!ok || !strings.HasPrefix(rest, "derived/")) {
+					continue
+				}
+				if obj.LastModified != nil && obj.LastModified.Before(cutoff) {
+					expired = append(expired, aws.ToString(obj.Key))
+					continue
+				}
+				keep = append(keep, obj)
+				total += aws.ToInt64(obj.Size)
+			}
+		}
+	}
+
+	if d.maxBytes > 0 && total > d.maxBytes {
+		sort.Slice(keep, func(i, j int) bool {
+			return aws.ToTime(keep[i].LastModified).Before(aws.ToTime(keep[j].LastModified))
+		})
+		for _, obj := range keep {
+			if total <= d.maxBytes {
+				break
+			}
+			expired = append(expired, aws.ToString(obj.Key))
+			total -= aws.ToInt64(obj.Size)
+		}
+	}
+
+	if len(expired) > 0 {
+		slog.InfoContext(ctx, "derived cache sweep deleting entries", "count", len(expired))
+	}
+	return d.delete(ctx, bucketName, expired)
+}
+
+// Purge deletes every entry for id and returns how many there were.
+func (d *DerivedCache) Purge(ctx context.Context, bucketName, id string) (int, error) {
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(d.s3, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(derivedPrefix(id)),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("list derived: %w", err)
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	return len(keys), d.delete(ctx, bucketName, keys)
+}
+
+// delete removes cache entries.
+func (d *DerivedCache) delete(ctx context.Context, bucketName string, keys []string) error {
+	if err := deleteKeys(ctx, d.s3, bucketName, keys); err != nil {
+		return fmt.Errorf("delete derived: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestProcessedHash(t *testing.T) {
+	png, _ := lookupFormat("png")
+	crop := image.Rect(0, 0, 10, 10)
+	o := ProcessOptions{Width: 64, Format: png, Crop: &crop}
+	same := ProcessOptions{Width: 64, Format: png, Crop: &image.Rectangle{Max: image.Pt(10, 10)}}
+	if processedHash(`"v1"`, o) != processedHash(`"v1"`, same) {
+		t.Error("equal options hash differently")
+	}
+	for name, h := range map[string]string{
+		"source": processedHash(`"v2"`, o),
+		"width":  processedHash(`"v1"`, ProcessOptions{Width: 65, Format: png, Crop: &crop}),
+		"crop":   processedHash(`"v1"`, ProcessOptions{Width: 64, Format: png}),
+	} {
+		if h == processedHash(`"v1"`, o) {
+			t.Errorf("changing the %s keeps the hash", name)
+		}
+	}
+
+	key := derivedKey("a", `"v1"`, o)
+	if !strings.HasPrefix(key, "derived/a/") || !strings.HasSuffix(key, ".png") || len(key) != len("derived/a/")+32+len(".png") {
+		t.Errorf("derivedKey = %s", key)
+	}
+	if etag := processedETag(`"v1"`, o); etag != `"`+processedHash(`"v1"`, o)+`"` {
+		t.Errorf("processedETag = %s", etag)
+	}
+}
+
+func TestNewDerivedCache(t *testing.T) {
+	if d := newDerivedCache(nil, "sat", 0, 2<<20); d != nil {
+		t.Errorf("TTL 0 made cache %+v", d)
+	}
+	if d := newDerivedCache(nil, "sat", time.Hour, 2<<20); d == nil || d.bucket != "sat" || d.ttl != time.Hour || d.maxBytes != 2<<20 {
+		t.Errorf("newDerivedCache = %+v", d)
+	}
+}
+
+func TestDerivedCacheGet(t *testing.T) {
+	ctx := context.Background()
+	store := testFSStore(t)
+	d := &DerivedCache{s3: store, ttl: time.Hour}
+	if _, ok := d.Get(ctx, "sat", "derived/a/x.jpg"); ok {
+		t.Error("hit on an empty cache")
+	}
+	putAged(t, store, "derived/a/x.jpg", time.Now())
+	obj, ok := d.Get(ctx, "sat", "derived/a/x.jpg")
+	if !ok || obj.ContentType != "image/jpeg" || len(obj.Data) == 0 {
+		t.Errorf("Get = %+v, %t", obj, ok)
+	}
+}
+
+func TestDerivedCacheSweep(t *testing.T) {
+	ctx := context.Background()
+	store := testFSStore(t)
+	now := time.Now()
+	for key, age := range map[string]time.Duration{
+		"derived/a/expired.jpg":              3 * time.Hour,
+		"tenants/acme/derived/a/expired.jpg": 3 * time.Hour,
+		"derived/a/oldest.jpg":               90 * time.Minute,
+		"tenants/acme/derived/b/older.jpg":   time.Hour,
+		"derived/b/newest.jpg":               time.Minute,
+		"images/a":                           3 * time.Hour,
+		"tenants/acme/images/a":              3 * time.Hour,
+	} {
+		putAged(t, store, key, now.Add(-age))
+	}
+	head, err := store.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String("images/a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Room for two entries: the expired ones go, then the least recently
+	// used of the three left.
+	d := &DerivedCache{s3: store, ttl: 2 * time.Hour, maxBytes: 2 * aws.ToInt64(head.ContentLength)}
+	if err := d.sweep(ctx, "sat"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{
+		"derived/a/expired.jpg":              false,
+		"tenants/acme/derived/a/expired.jpg": false,
+		"derived/a/oldest.jpg":               false,
+		"tenants/acme/derived/b/older.jpg":   true,
+		"derived/b/newest.jpg":               true,
+		"images/a":                           true,
+		"tenants/acme/images/a":              true,
+	} {
+		_, err := store.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(key)})
+		if got := err == nil; got != want {
+			t.Errorf("%s kept = %t, want %t (%v)", key, got, want, err)
+		}
+	}
+
+	if n, err := d.Purge(ctx, "sat", "b"); err != nil || n != 1 {
+		t.Errorf("Purge = %d, %v", n, err)
+	}
+}
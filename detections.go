@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxDetections = 500
+	// tdmTime is the CCSDS ASCII time code used for TDM epochs.
+	tdmTime = "2006-01-02T15:04:05.000"
+)
+
+// Detection sources.
+const (
+	DetectionPhotometry = "photometry"
+	DetectionAnalyst    = "analyst"
+)
+
+// Detection is one measured direction to the target, stored in the
+// detections attribute of an ImageRecord. RADeg and DecDeg are in the
+// frame of the plate solution, ICRF.
+type Detection struct {
+	Time   time.Time `dynamodbav:"time" json:"time"`
+	RADeg  float64   `dynamodbav:"ra_deg" json:"ra_deg"`
+	DecDeg float64   `dynamodbav:"dec_deg" json:"dec_deg"`
+	// X and Y are the full-resolution pixel the direction was measured at,
+	// when it came from the image.
+	X      *float64 `dynamodbav:"x,omitempty" json:"x,omitempty"`
+	Y      *float64 `dynamodbav:"y,omitempty" json:"y,omitempty"`
+	SNR    float64  `dynamodbav:"snr,omitempty" json:"snr,omitempty"`
+	Source string   `dynamodbav:"source" json:"source"`
+}
+
+type detectionSet struct {
+	ID         string      `json:"id"`
+	Detections []Detection `json:"detections"`
+}
+
+// MissionDetection is a detection with the image it was measured in.
+type MissionDetection struct {
+	ImageID string `json:"image_id"`
+	Detection
+}
+
+// MissionDetectionsResponse is the JSON body of GET
+// /mission/:id/detections.
+type MissionDetectionsResponse struct {
+	MissionID  string             `json:"mission_id"`
+	Observer   string             `json:"observer,omitempty"`
+	Target     string             `json:"target,omitempty"`
+	Detections []MissionDetection `json:"detections"`
+}
+
+// skyDetection measures the direction of pixel (x, y) through the solution.
+func skyDetection(w WCS, x, y float64, t time.Time, source string) Detection {
+	ra, dec := w.Sky(x, y)
+	return Detection{
+		Time:   t.UTC(),
+		RADeg:  math.Round(ra*1e7) / 1e7,
+		DecDeg: math.Round(dec*1e7) / 1e7,
+		X:      &x,
+		Y:      &y,
+		Source: source,
+	}
+}
+
+// detectFromPhotometry replaces the image's photometry detection with one
+// measured through w at the centroid of its photometry, keeping those an
+// analyst stored. An image without photometry is left alone.
+func (api *API) detectFromPhotometry(ctx context.Context, id string, w WCS) error {
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil || record == nil || record.Photometry == nil || record.Photometry.CaptureTime == 0 {
+		return err
+	}
+	p := record.Photometry
+	d := skyDetection(w, p.X, p.Y, time.Unix(p.CaptureTime, 0), DetectionPhotometry)
+	d.SNR = math.Round(p.SNR*100) / 100
+	detections := slices.DeleteFunc(record.Detections, func(d Detection) bool { return d.Source == DetectionPhotometry })
+	return api.Images.SetImageAttribute(ctx, id, "detections", append(detections, d))
+}
+
+// getImageDetections lists the detections stored for an image.
+func (api *API) getImageDetections(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load image record")
+		return
+	}
+	set := detectionSet{ID: id, Detections: []Detection{}}
+	if record != nil && record.Detections != nil {
+		set.Detections = record.Detections
+	}
+	c.IndentedJSON(http.StatusOK, set)
+}
+
+// detectionInput is a detection as PUT: a pixel, measured through the
+// image's plate solution, or a direction.
+type detectionInput struct {
+	Time   *time.Time `json:"time"`
+	X      *float64   `json:"x"`
+	Y      *float64   `json:"y"`
+	RADeg  *float64   `json:"ra_deg"`
+	DecDeg *float64   `json:"dec_deg"`
+	SNR    float64    `json:"snr"`
+}
+
+func (d detectionInput) validate() error {
+	finite := func(v *float64) bool { return v != nil && !math.IsNaN(*v) && !math.IsInf(*v, 0) }
+	switch {
+	case d.X != nil || d.Y != nil:
+		if d.RADeg != nil || d.DecDeg != nil {
+			return errors.New("give either x and y or ra_deg and dec_deg")
+		}
+		if !finite(d.X) || !finite(d.Y) || *d.X < 0 || *d.Y < 0 {
+			return errors.New("x and y must both be pixel coordinates of at least 0")
+		}
+	case d.RADeg != nil || d.DecDeg != nil:
+		if !finite(d.RADeg) || !finite(d.DecDeg) || *d.RADeg < 0 || *d.RADeg >= 360 || *d.DecDeg < -90 || *d.DecDeg > 90 {
+			return errors.New("ra_deg must be from 0 up to 360 and dec_deg from -90 to 90")
+		}
+	default:
+		return errors.New("give x and y or ra_deg and dec_deg")
+	}
+	if d.SNR < 0 || math.IsNaN(d.SNR) {
+		return errors.New("snr must not be negative")
+	}
+	return nil
+}
+
+// putImageDetections replaces the detections an analyst stored for an
+// image, keeping the photometry one. Pixels are measured through the
+// image's plate solution, and a detection without a time is at the capture
+// time.
+func (api *API) putImageDetections(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	var req struct {
+		Detections []detectionInput `json:"detections"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"detections\": [...]}.")
+		return
+	}
+	if len(req.Detections) > maxDetections {
+		respondError(c, http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("At most %d detections may be stored per image.", maxDetections))
+		return
+	}
+	for i, d := range req.Detections {
+		if err := d.validate(); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("detection %d: %v", i, err))
+			return
+		}
+	}
+
+	captured, err := api.captureTime(ctx, api.Config.ImagesBucket, id)
+	if err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		slog.ErrorContext(ctx, "s3 HeadObject failed", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
+		return
+	}
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load image record")
+		return
+	}
+	if record == nil {
+		record = &ImageRecord{ID: id}
+	}
+
+	detections := slices.DeleteFunc(slices.Clone(record.Detections), func(d Detection) bool { return d.Source != DetectionPhotometry })
+	for i, in := range req.Detections {
+		t := captured
+		if in.Time != nil {
+			t = *in.Time
+		}
+		if t.IsZero() {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("detection %d: the image has no capture time, so give its time", i))
+			return
+		}
+		var d Detection
+		if in.X != nil {
+			if record.WCS == nil {
+				respondError(c, http.StatusUnprocessableEntity, CodeNotPlateSolved, "The image has no plate solution. Solve it with POST /image/:id/platesolve, or give ra_deg and dec_deg.")
+				return
+			}
+			d = skyDetection(*record.WCS, *in.X, *in.Y, t, DetectionAnalyst)
+		} else {
+			d = Detection{Time: t.UTC(), RADeg: *in.RADeg, DecDeg: *in.DecDeg, Source: DetectionAnalyst}
+		}
+		d.SNR = in.SNR
+		detections = append(detections, d)
+	}
+	if detections == nil {
+		detections = []Detection{}
+	}
+	if err := api.Images.SetImageAttribute(ctx, id, "detections", detections); err != nil {
+		slog.ErrorContext(ctx, "failed to store detections", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to store detections")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, detectionSet{ID: id, Detections: detections})
+}
+
+// getMissionDetections collects the detections of the mission's images in
+// time order, as JSON or, with ?format=tdm, as a CCSDS tracking data
+// message for orbit determination.
+func (api *API) getMissionDetections(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	format := cmp.Or(c.Query("format"), "json")
+	if format != "json" && format != "tdm" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'format' parameter. Must be json or tdm.")
+		return
+	}
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+	records, err := api.Images.ImageRecords(ctx, mission.ImageIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load image records", "mission", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+		return
+	}
+
+	resp := MissionDetectionsResponse{MissionID: id, Observer: mission.ObserverSatelliteID, Target: mission.TargetSatelliteID, Detections: []MissionDetection{}}
+	for _, imageID := range mission.ImageIDs {
+		if r, ok := records[imageID]; ok {
+			for _, d := range r.Detections {
+				resp.Detections = append(resp.Detections, MissionDetection{ImageID: imageID, Detection: d})
+			}
+		}
+	}
+	slices.SortStableFunc(resp.Detections, func(a, b MissionDetection) int { return a.Time.Compare(b.Time) })
+
+	if format == "tdm" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".tdm"))
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", api.detectionsTDM(resp, time.Now()))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// detectionsTDM writes the detections as a CCSDS 503.0-B-2 TDM in KVN: one
+// segment of the target's RA/Dec as seen by the observer, the second
+// participant, which receives its light.
+func (api *API) detectionsTDM(resp MissionDetectionsResponse, created time.Time) []byte {
+	participant := func(id string) string {
+		if s, err := api.Satellites.Get(id); err == nil && s.NoradID != 0 {
+			return strconv.Itoa(s.NoradID)
+		}
+		return cmp.Or(id, "UNKNOWN")
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "CCSDS_TDM_VERS = 2.0\nCREATION_DATE = %s\nORIGINATOR = %s\n\n", created.UTC().Format(tdmTime), eventSource)
+	b.WriteString("META_START\n")
+	fmt.Fprintf(&b, "COMMENT Mission %s: plate-solved directions from the observer to the target\n", resp.MissionID)
+	b.WriteString("TIME_SYSTEM = UTC\n")
+	fmt.Fprintf(&b, "PARTICIPANT_1 = %s\nPARTICIPANT_2 = %s\n", participant(resp.Target), participant(resp.Observer))
+	b.WriteString("MODE = SEQUENTIAL\nPATH = 1,2\nANGLE_TYPE = RADEC\nREFERENCE_FRAME = ICRF\n")
+	if n := len(resp.Detections); n > 0 {
+		fmt.Fprintf(&b, "START_TIME = %s\nSTOP_TIME = %s\n", resp.Detections[0].Time.Format(tdmTime), resp.Detections[n-1].Time.Format(tdmTime))
+	}
+	b.WriteString("META_STOP\n\nDATA_START\n")
+	for _, d := range resp.Detections {
+		at := d.Time.UTC().Format(tdmTime)
+		fmt.Fprintf(&b, "ANGLE_1 = %s %.7f\nANGLE_2 = %s %.7f\n", at, d.RADeg, at, d.DecDeg)
+	}
+	b.WriteString("DATA_STOP\n")
+	return b.Bytes()
+}
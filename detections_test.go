@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDetections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	captured := time.Date(2026, 10, 1, 3, 4, 5, 0, time.UTC)
+	for i, id := range []string{"f1", "f2", "f3"} {
+		_, err := store.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: strings.NewReader("jpeg"),
+			Metadata: map[string]string{"capture-time": strconv.FormatInt(captured.Unix()+int64(60*i), 10)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := putMission(ctx, meta, &Mission{ID: "m1", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", ImageIDs: []string{"f2", "f1", "f3"}}); err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		Config:     &Config{ImagesBucket: "sat"},
+		MissionDB:  meta,
+		Images:     meta,
+		S3:         store,
+		Satellites: newSatelliteStore(nil, ""),
+	}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive})
+
+	// Plate solving f1 and f2 measures the photometry centroid's direction.
+	w := WCS{CRPix: [2]float64{512.5, 512.5}, CRVal: [2]float64{83.82, -5.39}, CD: rolledCD(4, 0, false), Width: 1024, Height: 1024}
+	for i, id := range []string{"f1", "f2"} {
+		meta.SetImageAttribute(ctx, id, "photometry", Photometry{X: 611.5, Y: 511.5, SNR: 42.123, CaptureTime: captured.Unix() + int64(60*i)})
+		meta.SetImageAttribute(ctx, id, "wcs", w)
+		if err := api.detectFromPhotometry(ctx, id, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record, _ := meta.ImageRecord(ctx, "f1")
+	if len(record.Detections) != 1 {
+		t.Fatalf("f1 detections %+v", record.Detections)
+	}
+	// 100 pixels right of centre is 400 arcsec west at Dec -5.39.
+	d := record.Detections[0]
+	if wantRA := 83.82 - 400.0/3600/math.Cos(-5.39*math.Pi/180); math.Abs(d.RADeg-wantRA) > 1e-4 || math.Abs(d.DecDeg+5.39) > 1e-4 || !d.Time.Equal(captured) || d.Source != DetectionPhotometry || d.SNR != 42.12 {
+		t.Errorf("photometry detection %+v", d)
+	}
+
+	router := gin.New()
+	router.GET("/image/:id/detections", api.getImageDetections)
+	router.PUT("/image/:id/detections", api.putImageDetections)
+	router.GET("/mission/:id/detections", api.getMissionDetections)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	tests := []struct {
+		method, path, body string
+		want               int
+		code               ErrorCode
+	}{
+		{http.MethodPut, "/image/f1/detections", `{"detections": [{"x": 1}]}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/image/f1/detections", `{"detections": [{"x": 1, "y": 2, "ra_deg": 3}]}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/image/f1/detections", `{"detections": [{"ra_deg": 360, "dec_deg": 0}]}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/image/f1/detections", `{"detections": [{}]}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/image/gone/detections", `{"detections": []}`, http.StatusNotFound, CodeImageNotFound},
+		{http.MethodPut, "/image/f3/detections", `{"detections": [{"x": 1, "y": 2}]}`, http.StatusUnprocessableEntity, CodeNotPlateSolved},
+		{http.MethodPut, "/image/f2/detections", `{"detections": [{"x": 511.5, "y": 511.5, "snr": 9}, {"ra_deg": 84, "dec_deg": -5, "time": "2026-10-01T03:05:05.5Z"}]}`, http.StatusOK, ""},
+		{http.MethodPut, "/image/f3/detections", `{"detections": [{"ra_deg": 85, "dec_deg": -5}]}`, http.StatusOK, ""},
+		{http.MethodGet, "/mission/none/detections", "", http.StatusNotFound, CodeMissionNotFound},
+		{http.MethodGet, "/mission/m1/detections?format=xml", "", http.StatusBadRequest, CodeInvalidParameter},
+	}
+	for _, tt := range tests {
+		w := do(tt.method, tt.path, tt.body)
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s %s: %d %s, want %d %s", tt.method, tt.path, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+
+	var set detectionSet
+	json.Unmarshal(do(http.MethodGet, "/image/f2/detections", "").Body.Bytes(), &set)
+	if len(set.Detections) != 3 || set.Detections[0].Source != DetectionPhotometry || set.Detections[1].RADeg != 83.82 || set.Detections[1].SNR != 9 {
+		t.Errorf("f2 detections %+v", set.Detections)
+	}
+	if w := do(http.MethodGet, "/image/unsolved/detections", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"detections": []`) {
+		t.Errorf("no detections: %d %s", w.Code, w.Body)
+	}
+
+	var resp MissionDetectionsResponse
+	json.Unmarshal(do(http.MethodGet, "/mission/m1/detections", "").Body.Bytes(), &resp)
+	if len(resp.Detections) != 5 {
+		t.Fatalf("mission detections %+v", resp)
+	}
+	for i, d := range resp.Detections[1:] {
+		if d.Time.Before(resp.Detections[i].Time) {
+			t.Errorf("detection %d at %s is before %s", i+1, d.Time, resp.Detections[i].Time)
+		}
+	}
+	if resp.Detections[0].ImageID != "f1" || resp.Detections[4].ImageID != "f3" {
+		t.Errorf("mission detections %+v", resp.Detections)
+	}
+
+	tdm := do(http.MethodGet, "/mission/m1/detections?format=tdm", "").Body.String()
+	for _, want := range []string{"CCSDS_TDM_VERS = 2.0\n", "PARTICIPANT_1 = 25544\n", "PARTICIPANT_2 = chaser\n", "PATH = 1,2\n", "ANGLE_TYPE = RADEC\n", "REFERENCE_FRAME = ICRF\n", "START_TIME = 2026-10-01T03:04:05.000\n", "ANGLE_2 = 2026-10-01T03:05:05.500 -5.0000000\n"} {
+		if !strings.Contains(tdm, want) {
+			t.Errorf("TDM lacks %q:\n%s", want, tdm)
+		}
+	}
+	if n := strings.Count(tdm, "ANGLE_1 = "); n != 5 {
+		t.Errorf("TDM has %d ANGLE_1 lines", n)
+	}
+	if !strings.HasSuffix(tdm, "DATA_STOP\n") {
+		t.Errorf("TDM ends %q", tdm[len(tdm)-20:])
+	}
+}
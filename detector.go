@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sagemakerruntime"
+	"github.com/disintegration/imaging"
+)
+
+// Detector backends.
+const (
+	DetectorSageMaker = "sagemaker"
+	DetectorONNX      = "onnx"
+)
+
+const (
+	defaultDetectorCommand       = "onnx-detect"
+	defaultDetectorMinConfidence = 0.25
+	// Images are sent to the model fitted within detectorMaxSide pixels,
+	// as JPEG, which keeps them under SageMaker's 6 MB payload limit.
+	detectorMaxSide = 2048
+	detectorQuality = 90
+	detectorTimeout = time.Minute
+	// At most maxObjectDetections objects, the most confident, are kept.
+	maxObjectDetections = 500
+	// The overlay draws boxes in defaultObjectColor, labelled with the
+	// class and confidence.
+	defaultObjectColor = "#00ffff"
+)
+
+// DetectorConfig is the model that finds objects in ingested images.
+type DetectorConfig struct {
+	// Backend is sagemaker or onnx; empty detects nothing.
+	Backend string
+	// Endpoint is the SageMaker endpoint invoked.
+	Endpoint string
+	// Model is the ONNX model file, which Command runs.
+	Model, Command string
+	// MinConfidence is the least confidence of an object kept.
+	MinConfidence float64
+}
+
+// loadDetectorConfig reads DETECTOR, DETECTOR_ENDPOINT, DETECTOR_MODEL,
+// DETECTOR_COMMAND and DETECTOR_MIN_CONFIDENCE.
+func loadDetectorConfig() (DetectorConfig, []error) {
+	c := DetectorConfig{
+		Backend:       strings.ToLower(os.Getenv("DETECTOR")),
+		Endpoint:      os.Getenv("DETECTOR_ENDPOINT"),
+		Model:         os.Getenv("DETECTOR_MODEL"),
+		Command:       cmp.Or(os.Getenv("DETECTOR_COMMAND"), defaultDetectorCommand),
+		MinConfidence: defaultDetectorMinConfidence,
+	}
+	var errs []error
+	if v := os.Getenv("DETECTOR_MIN_CONFIDENCE"); v != "" {
+		conf, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(conf >= 0 && conf <= 1) {
+			errs = append(errs, fmt.Errorf("DETECTOR_MIN_CONFIDENCE %q is not a confidence from 0 to 1", v))
+		}
+		c.MinConfidence = conf
+	}
+	switch c.Backend {
+	case "":
+	case DetectorSageMaker:
+		if c.Endpoint == "" {
+			errs = append(errs, errors.New("DETECTOR=sagemaker needs DETECTOR_ENDPOINT"))
+		}
+	case DetectorONNX:
+		if c.Model == "" {
+			errs = append(errs, errors.New("DETECTOR=onnx needs DETECTOR_MODEL"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("DETECTOR %q is not sagemaker or onnx", c.Backend))
+	}
+	return c, errs
+}
+
+// ObjectDetection is an object a model found in an image.
+type ObjectDetection struct {
+	Label      string  `dynamodbav:"label" json:"label"`
+	Confidence float64 `dynamodbav:"confidence" json:"confidence"`
+	// Box is the object's bounds, x0, y0, x1, y1, in full-resolution
+	// source pixels.
+	Box [4]float64 `dynamodbav:"box" json:"box"`
+	// Polygon outlines the object, in the same pixels, from a
+	// segmentation model.
+	Polygon [][2]float64 `dynamodbav:"polygon,omitempty" json:"polygon,omitempty"`
+}
+
+// ObjectDetections are the objects found in an image, stored as the
+// objects attribute of its ImageRecord.
+type ObjectDetections struct {
+	// Model is the SageMaker endpoint or ONNX model file that found them.
+	Model    string            `dynamodbav:"model" json:"model"`
+	Detected int64             `dynamodbav:"detected" json:"detected"`
+	Objects  []ObjectDetection `dynamodbav:"objects" json:"objects"`
+}
+
+// objectDetector runs a detection or segmentation model.
+type objectDetector interface {
+	// Detect returns the objects in a JPEG, in its pixels, as the model
+	// answered. Its answer is read by parseObjectDetections.
+	Detect(ctx context.Context, jpeg []byte) ([]byte, error)
+	// Model names the model.
+	Model() string
+}
+
+func newObjectDetector(c DetectorConfig) objectDetector {
+	switch c.Backend {
+	case DetectorSageMaker:
+		cfg, err := config.LoadDefaultConfig(context.TODO(), withAWSMetrics())
+		if err != nil {
+			fatal("unable to load SDK config", "err", err)
+		}
+		return &sageMakerDetector{client: sagemakerruntime.NewFromConfig(cfg), endpoint: c.Endpoint}
+	case DetectorONNX:
+		if _, err := exec.LookPath(c.Command); err != nil {
+			slog.Error("DETECTOR_COMMAND not found, object detection will fail", "command", c.Command, "err", err)
+		}
+		return &onnxDetector{command: c.Command, model: c.Model}
+	}
+	return nil
+}
+
+type sageMakerInvoker interface {
+	InvokeEndpoint(ctx context.Context, in *sagemakerruntime.InvokeEndpointInput, optFns ...func(*sagemakerruntime.Options)) (*sagemakerruntime.InvokeEndpointOutput, error)
+}
+
+// sageMakerDetector invokes a SageMaker real-time endpoint.
+type sageMakerDetector struct {
+	client   sageMakerInvoker
+	endpoint string
+}
+
+func (d *sageMakerDetector) Detect(ctx context.Context, jpeg []byte) ([]byte, error) {
+	out, err := d.client.InvokeEndpoint(ctx, &sagemakerruntime.InvokeEndpointInput{
+		EndpointName: aws.String(d.endpoint),
+		ContentType:  aws.String("image/jpeg"),
+		Accept:       aws.String("application/json"),
+		Body:         jpeg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (d *sageMakerDetector) Model() string { return d.endpoint }
+
+// onnxDetector runs an ONNX model locally through a command, such as a
+// small ONNX Runtime script, given the model file as its argument. It
+// reads the JPEG on stdin and writes the objects to stdout.
+type onnxDetector struct {
+	command, model string
+}
+
+func (d *onnxDetector) Detect(ctx context.Context, jpeg []byte) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, d.command, d.model)
+	cmd.Stdin = bytes.NewReader(jpeg)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		return nil, fmt.Errorf("%s: %w: %.200s", d.command, err, msg)
+	}
+	return out, nil
+}
+
+func (d *onnxDetector) Model() string { return d.model }
+
+// parseObjectDetections reads a model's answer for a width×height image:
+// either {"detections": [{"label", "confidence" or "score", "box": [x0, y0,
+// x1, y1], "polygon"}]} in pixels, or the {"prediction": [[class, score,
+// x0, y0, x1, y1]]} of SageMaker's built-in object detection, in fractions
+// of the image.
+func parseObjectDetections(body []byte, width, height int) ([]ObjectDetection, error) {
+	var answer struct {
+		Detections []struct {
+			Label      string       `json:"label"`
+			Confidence *float64     `json:"confidence"`
+			Score      *float64     `json:"score"`
+			Box        []float64    `json:"box"`
+			Polygon    [][2]float64 `json:"polygon"`
+		} `json:"detections"`
+		Prediction [][]float64 `json:"prediction"`
+	}
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return nil, fmt.Errorf("decode detections: %w", err)
+	}
+	objects := []ObjectDetection{}
+	for i, d := range answer.Detections {
+		conf := d.Confidence
+		if conf == nil {
+			conf = d.Score
+		}
+		if len(d.Box) != 4 || conf == nil {
+			return nil, fmt.Errorf("detection %d needs a box of 4 numbers and a confidence", i)
+		}
+		objects = append(objects, ObjectDetection{Label: d.Label, Confidence: *conf, Box: [4]float64(d.Box), Polygon: d.Polygon})
+	}
+	w, h := float64(width), float64(height)
+	for i, p := range answer.Prediction {
+		if len(p) != 6 {
+			return nil, fmt.Errorf("prediction %d is not [class, score, x0, y0, x1, y1]", i)
+		}
+		objects = append(objects, ObjectDetection{
+			Label:      strconv.FormatFloat(p[0], 'f', -1, 64),
+			Confidence: p[1],
+			Box:        [4]float64{p[2] * w, p[3] * h, p[4] * w, p[5] * h},
+		})
+	}
+	return objects, nil
+}
+
+// detectObjects runs api.Detector on the image and returns the objects at
+// least DETECTOR_MIN_CONFIDENCE confident, most confident first.
+func (api *API) detectObjects(ctx context.Context, id string) (*ObjectDetections, error) {
+	img, release, err := api.loadSourceImage(ctx, api.Config.ImagesBucket, id)
+	if err != nil {
+		return nil, err
+	}
+	src := img.Bounds()
+	sent := imaging.Fit(img, detectorMaxSide, detectorMaxSide, imaging.Lanczos)
+	release()
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, sent, imaging.JPEG, imaging.JPEGQuality(detectorQuality)); err != nil {
+		return nil, fmt.Errorf("encode %s: %w", id, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, detectorTimeout)
+	defer cancel()
+	start := time.Now()
+	body, err := api.Detector.Detect(ctx, buf.Bytes())
+	detectorDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	objects, err := parseObjectDetections(body, sent.Bounds().Dx(), sent.Bounds().Dy())
+	if err != nil {
+		return nil, err
+	}
+
+	sx := float64(src.Dx()) / float64(sent.Bounds().Dx())
+	sy := float64(src.Dy()) / float64(sent.Bounds().Dy())
+	round := func(v float64) float64 { return math.Round(v*10) / 10 }
+	kept := []ObjectDetection{}
+	for _, o := range objects {
+		if o.Confidence < api.Config.Detector.MinConfidence {
+			continue
+		}
+		o.Box = [4]float64{round(o.Box[0] * sx), round(o.Box[1] * sy), round(o.Box[2] * sx), round(o.Box[3] * sy)}
+		for i, p := range o.Polygon {
+			o.Polygon[i] = [2]float64{round(p[0] * sx), round(p[1] * sy)}
+		}
+		kept = append(kept, o)
+	}
+	slices.SortStableFunc(kept, func(a, b ObjectDetection) int { return cmp.Compare(b.Confidence, a.Confidence) })
+	return &ObjectDetections{Model: api.Detector.Model(), Detected: time.Now().Unix(), Objects: kept[:min(len(kept), maxObjectDetections)]}, nil
+}
+
+// annotateObjects stores the objects found in an image just ingested. A
+// failure is logged and does not fail the ingest.
+func (api *API) annotateObjects(ctx context.Context, id string) {
+	found, err := api.detectObjects(ctx, id)
+	if err != nil {
+		objectDetections.WithLabelValues("failed").Inc()
+		slog.WarnContext(ctx, "object detection failed for ingested image", "id", id, "err", err)
+		return
+	}
+	objectDetections.WithLabelValues("detected").Inc()
+	if err := api.Images.SetImageAttribute(ctx, id, "objects", found); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to store object detections", "id", id, "err", err)
+	}
+}
+
+// loadObjectOverlay reads the objects opts draws, when it draws them.
+func (api *API) loadObjectOverlay(ctx context.Context, id string, opts *ProcessOptions) error {
+	if !opts.DrawObjects {
+		return nil
+	}
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		return err
+	}
+	if record != nil && record.Objects != nil {
+		opts.Objects = record.Objects.Objects
+	}
+	return nil
+}
+
+// objectAnnotations are the boxes the overlay draws for objects.
+func objectAnnotations(objects []ObjectDetection) []Annotation {
+	var boxes []Annotation
+	for _, o := range objects {
+		r := image.Rect(int(math.Round(o.Box[0])), int(math.Round(o.Box[1])), int(math.Round(o.Box[2])), int(math.Round(o.Box[3]))).Canon()
+		if r.Empty() {
+			continue
+		}
+		label := fmt.Sprintf("%s %.0f%%", o.Label, o.Confidence*100)
+		boxes = append(boxes, Annotation{Type: "box", X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Label: strings.TrimSpace(label), Color: defaultObjectColor})
+	}
+	return boxes
+}
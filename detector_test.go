@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sagemakerruntime"
+)
+
+func TestParseObjectDetections(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []ObjectDetection
+		wantErr bool
+	}{
+		{
+			name: "pixels",
+			body: `{"detections": [{"label": "solar_panel", "confidence": 0.9, "box": [10, 20, 30, 40]}, {"label": "antenna", "score": 0.4, "box": [1, 2, 3, 4], "polygon": [[1, 2], [3, 4], [1, 4]]}]}`,
+			want: []ObjectDetection{
+				{Label: "solar_panel", Confidence: 0.9, Box: [4]float64{10, 20, 30, 40}},
+				{Label: "antenna", Confidence: 0.4, Box: [4]float64{1, 2, 3, 4}, Polygon: [][2]float64{{1, 2}, {3, 4}, {1, 4}}},
+			},
+		},
+		{
+			name: "sagemaker fractions",
+			body: `{"prediction": [[3, 0.8, 0.1, 0.25, 0.5, 0.75]]}`,
+			want: []ObjectDetection{{Label: "3", Confidence: 0.8, Box: [4]float64{20, 25, 100, 75}}},
+		},
+		{name: "none", body: `{"detections": []}`, want: []ObjectDetection{}},
+		{name: "short box", body: `{"detections": [{"label": "x", "confidence": 1, "box": [1, 2]}]}`, wantErr: true},
+		{name: "no confidence", body: `{"detections": [{"label": "x", "box": [1, 2, 3, 4]}]}`, wantErr: true},
+		{name: "short prediction", body: `{"prediction": [[1, 0.5]]}`, wantErr: true},
+		{name: "not json", body: `boxes`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseObjectDetections([]byte(tt.body), 200, 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeInvoker struct {
+	body []byte
+	err  error
+	in   *sagemakerruntime.InvokeEndpointInput
+}
+
+func (f *fakeInvoker) InvokeEndpoint(_ context.Context, in *sagemakerruntime.InvokeEndpointInput, _ ...func(*sagemakerruntime.Options)) (*sagemakerruntime.InvokeEndpointOutput, error) {
+	f.in = in
+	return &sagemakerruntime.InvokeEndpointOutput{Body: f.body}, f.err
+}
+
+func TestAnnotateObjects(t *testing.T) {
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"f1", "f2"} {
+		if _, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(frame)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta := testSQLStore(t)
+	invoker := &fakeInvoker{body: []byte(`{"prediction": [[0, 0.3, 0.5, 0.5, 0.75, 0.75], [1, 0.9, 0, 0, 0.25, 0.5], [2, 0.1, 0, 0, 1, 1]]}`)}
+	api := &API{
+		Config:   &Config{ImagesBucket: "sat", Detector: DetectorConfig{MinConfidence: 0.25}},
+		Images:   meta,
+		S3:       store,
+		Limiter:  newProcessLimiter(0, defaultQueueTimeout),
+		Detector: &sageMakerDetector{client: invoker, endpoint: "spacecraft-parts"},
+	}
+
+	api.annotateObjects(ctx, "f1")
+	if aws.ToString(invoker.in.EndpointName) != "spacecraft-parts" || aws.ToString(invoker.in.ContentType) != "image/jpeg" || len(invoker.in.Body) == 0 {
+		t.Errorf("invoked with %+v", invoker.in)
+	}
+	record, err := meta.ImageRecord(ctx, "f1")
+	if err != nil || record == nil || record.Objects == nil {
+		t.Fatalf("record %+v, %v", record, err)
+	}
+	// Least confident dropped, most confident first, in source pixels.
+	want := []ObjectDetection{
+		{Label: "1", Confidence: 0.9, Box: [4]float64{0, 0, 256, 512}},
+		{Label: "0", Confidence: 0.3, Box: [4]float64{512, 512, 768, 768}},
+	}
+	if got := record.Objects; got.Model != "spacecraft-parts" || got.Detected == 0 || !reflect.DeepEqual(got.Objects, want) {
+		t.Errorf("objects %+v", got)
+	}
+
+	invoker.err = errors.New("endpoint down")
+	api.annotateObjects(ctx, "f2")
+	if record, _ := meta.ImageRecord(ctx, "f2"); record != nil && record.Objects != nil {
+		t.Errorf("stored objects from a failed detection: %+v", record.Objects)
+	}
+
+	opts := ProcessOptions{DrawObjects: true}
+	if err := api.loadObjectOverlay(ctx, "f1", &opts); err != nil || len(opts.Objects) != 2 {
+		t.Fatalf("overlay objects %+v, %v", opts.Objects, err)
+	}
+	boxes := objectAnnotations(opts.Objects)
+	if len(boxes) != 2 || boxes[0] != (Annotation{Type: "box", Width: 256, Height: 512, Label: "1 90%", Color: defaultObjectColor}) {
+		t.Errorf("boxes %+v", boxes)
+	}
+	if opts.fingerprint() == (ProcessOptions{DrawObjects: true}).fingerprint() {
+		t.Error("fingerprint ignores the objects drawn")
+	}
+}
+
+func TestONNXDetector(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "detect.sh")
+	os.WriteFile(script, []byte("wc -c >&2\necho '{\"detections\": []}'\n"), 0o644)
+	d := &onnxDetector{command: "sh", model: script}
+	out, err := d.Detect(context.Background(), []byte("jpeg"))
+	if err != nil || string(out) != "{\"detections\": []}\n" {
+		t.Errorf("Detect = %q, %v", out, err)
+	}
+
+	os.WriteFile(script, []byte("echo 'no such model' >&2\nexit 3\n"), 0o644)
+	if _, err := d.Detect(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "no such model") {
+		t.Errorf("failing runner: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var startTime = time.Now()
+
+// Diagnostics is a point-in-time view of the process for GET
+// /admin/diagnostics, aimed at chasing memory growth in the image path.
+type Diagnostics struct {
+	UptimeSeconds int64                 `json:"uptime_seconds"`
+	Goroutines    int                   `json:"goroutines"`
+	GOMAXPROCS    int                   `json:"gomaxprocs"`
+	Memory        MemoryDiagnostics     `json:"memory"`
+	Processing    ProcessingDiagnostics `json:"processing"`
+	Caches        map[string]CacheStats `json:"caches"`
+	Queues        map[string]QueueStats `json:"queues"`
+}
+
+// MemoryDiagnostics is the Go heap as runtime.MemStats reports it, and the
+// soft memory limit when one is set.
+type MemoryDiagnostics struct {
+	HeapAllocBytes uint64     `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64     `json:"heap_inuse_bytes"`
+	HeapSysBytes   uint64     `json:"heap_sys_bytes"`
+	HeapObjects    uint64     `json:"heap_objects"`
+	SysBytes       uint64     `json:"sys_bytes"`
+	NextGCBytes    uint64     `json:"next_gc_bytes"`
+	NumGC          uint32     `json:"num_gc"`
+	LastGC         *time.Time `json:"last_gc,omitempty"`
+	PauseTotalMS   float64    `json:"gc_pause_total_ms"`
+	LimitBytes     int64      `json:"memory_limit_bytes,omitempty"`
+}
+
+// ProcessingDiagnostics is the decode memory budget and how much is held.
+type ProcessingDiagnostics struct {
+	BudgetBytes int64 `json:"budget_bytes"`
+	InUseBytes  int64 `json:"in_use_bytes"`
+}
+
+// CacheStats describes one in-process cache. Redis-backed mission caching
+// reports only its backend.
+type CacheStats struct {
+	Backend  string `json:"backend"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+}
+
+// QueueStats is a work queue's backlog against its buffer size.
+type QueueStats struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+}
+
+func (api *API) diagnostics() Diagnostics {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	d := Diagnostics{
+		UptimeSeconds: int64(time.Since(startTime).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		Memory: MemoryDiagnostics{
+			HeapAllocBytes: ms.HeapAlloc,
+			HeapInuseBytes: ms.HeapInuse,
+			HeapSysBytes:   ms.HeapSys,
+			HeapObjects:    ms.HeapObjects,
+			SysBytes:       ms.Sys,
+			NextGCBytes:    ms.NextGC,
+			NumGC:          ms.NumGC,
+			PauseTotalMS:   float64(ms.PauseTotalNs) / 1e6,
+		},
+		Caches: map[string]CacheStats{},
+		Queues: map[string]QueueStats{},
+	}
+	if ms.LastGC > 0 {
+		last := time.Unix(0, int64(ms.LastGC))
+		d.Memory.LastGC = &last
+	}
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		d.Memory.LimitBytes = limit
+	}
+	if l := api.Limiter; l != nil {
+		d.Processing = ProcessingDiagnostics{BudgetBytes: l.capacity, InUseBytes: l.inUse.Load()}
+	}
+
+	if m := api.Memory; m != nil {
+		m.mu.Lock()
+		d.Caches["memory"] = CacheStats{Backend: "memory", Entries: len(m.items), Bytes: m.size, MaxBytes: m.maxBytes}
+		m.mu.Unlock()
+	}
+	if mc := api.Missions; mc != nil {
+		switch kv := mc.cache.(type) {
+		case *memoryKV:
+			kv.mu.Lock()
+			d.Caches["mission"] = CacheStats{Backend: "memory", Entries: len(kv.items)}
+			kv.mu.Unlock()
+		case *redisCache:
+			d.Caches["mission"] = CacheStats{Backend: "redis"}
+		}
+	}
+
+	if j := api.Jobs; j != nil {
+		d.Queues["jobs"] = QueueStats{Depth: len(j.queue), Capacity: cap(j.queue)}
+	}
+	if b := api.Batch; b != nil {
+		d.Queues["batch"] = QueueStats{Depth: len(b.tasks), Capacity: cap(b.tasks)}
+	}
+	if w := api.Derivatives; w != nil {
+		d.Queues["derivatives"] = QueueStats{Depth: len(w.jobs), Capacity: cap(w.jobs)}
+	}
+	return d
+}
+
+func (api *API) getDiagnostics(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, api.diagnostics())
+}
+
+// publishDiagnostics adds the snapshot to /admin/debug/vars beside expvar's
+// own memstats and cmdline.
+func (api *API) publishDiagnostics() {
+	expvar.Publish("diagnostics", expvar.Func(func() any { return api.diagnostics() }))
+}
+
+// pprofHandler serves net/http/pprof under /admin/debug/pprof/. The pprof
+// handlers find the profile name under /debug/pprof/, so the /admin prefix
+// is stripped first.
+func pprofHandler() gin.HandlerFunc {
+	index := http.StripPrefix("/admin", http.HandlerFunc(pprof.Index))
+	return func(c *gin.Context) {
+		switch strings.TrimPrefix(c.Param("name"), "/") {
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			index.ServeHTTP(c.Writer, c.Request)
+		}
+	}
+}
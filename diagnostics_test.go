@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminDiagnostics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{Limiter: newProcessLimiter(0, defaultQueueTimeout), Memory: newMemoryCache(1<<20, defaultMemoryCacheTTL), Jobs: newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers)}
+	api.Memory.Add("k", cachedObject{Data: []byte("abc")})
+	release, err := api.Limiter.Acquire(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	router := gin.New()
+	admin := router.Group("/admin", requireScope(&Config{AdminToken: "secret"}, nil, ScopeAdmin, false))
+	admin.GET("/diagnostics", api.getDiagnostics)
+	admin.Any("/debug/pprof/*name", pprofHandler())
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/admin/diagnostics", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("diagnostics without a token = %d, want 401", w.Code)
+	}
+	w := get("/admin/diagnostics", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("diagnostics = %d", w.Code)
+	}
+	var d Diagnostics
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Goroutines == 0 || d.Memory.HeapAllocBytes == 0 || d.Processing.InUseBytes == 0 ||
+		d.Caches["memory"].Entries != 1 || d.Caches["memory"].Bytes == 0 || d.Queues["jobs"].Capacity != jobQueueSize {
+		t.Errorf("diagnostics = %+v", d)
+	}
+
+	if w := get("/admin/debug/pprof/", "secret"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("pprof index = %d", w.Code)
+	}
+	if w := get("/admin/debug/pprof/heap", "secret"); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("heap profile = %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if w := get("/admin/debug/pprof/cmdline", "secret"); w.Code != http.StatusOK {
+		t.Errorf("cmdline = %d", w.Code)
+	}
+}
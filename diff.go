@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// diffMaxSide caps the working resolution for alignment and metrics.
+	diffMaxSide = 2048
+	// diffCoarseSide and diffCoarseShift bound the brute-force translation
+	// search, which runs on a small copy before refining at full size.
+	diffCoarseSide  = 256
+	diffCoarseShift = 16
+	diffRefineShift = 2
+	diffAlignBlur   = 1.5
+	ssimWindow      = 8
+)
+
+// grayPlane is a luminance image in [0,255] used for alignment and metrics.
+type grayPlane struct {
+	w, h int
+	pix  []float64
+}
+
+func toGrayPlane(img image.Image) grayPlane {
+	nrgba := imaging.Clone(img)
+	b := nrgba.Bounds()
+	p := grayPlane{w: b.Dx(), h: b.Dy(), pix: make([]float64, b.Dx()*b.Dy())}
+	for i := range p.pix {
+		o := i * 4
+		p.pix[i] = 0.299*float64(nrgba.Pix[o]) + 0.587*float64(nrgba.Pix[o+1]) + 0.114*float64(nrgba.Pix[o+2])
+	}
+	return p
+}
+
+func (p grayPlane) at(x, y int) float64 { return p.pix[y*p.w+x] }
+
+// overlap returns the rectangle of a that has a counterpart in b when b is
+// translated by (dx, dy).
+func overlap(a, b grayPlane, dx, dy int) image.Rectangle {
+	return image.Rect(0, 0, a.w, a.h).Intersect(image.Rect(dx, dy, b.w+dx, b.h+dy))
+}
+
+// shiftScore rates a candidate shift by the zero-mean normalized
+// cross-correlation of the overlap, negated so that lower is better. Unlike
+// a plain difference it is insensitive to exposure changes between frames.
+func shiftScore(a, b grayPlane, dx, dy int) float64 {
+	r := overlap(a, b, dx, dy)
+	n := float64(r.Dx() * r.Dy())
+	if n < float64(a.w*a.h)/4 {
+		return math.Inf(1)
+	}
+
+	var sa, sb float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sa += a.at(x, y)
+			sb += b.at(x-dx, y-dy)
+		}
+	}
+	ma, mb := sa/n, sb/n
+
+	var cov, va, vb float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			da, db := a.at(x, y)-ma, b.at(x-dx, y-dy)-mb
+			cov += da * db
+			va += da * da
+			vb += db * db
+		}
+	}
+	if va == 0 || vb == 0 {
+		return 0
+	}
+	return -cov / math.Sqrt(va*vb)
+}
+
+func bestShift(a, b grayPlane, cx, cy, radius int) (int, int) {
+	bestX, bestY, bestScore := cx, cy, math.Inf(1)
+	for dy := cy - radius; dy <= cy+radius; dy++ {
+		for dx := cx - radius; dx <= cx+radius; dx++ {
+			if s := shiftScore(a, b, dx, dy); s < bestScore {
+				bestX, bestY, bestScore = dx, dy, s
+			}
+		}
+	}
+	return bestX, bestY
+}
+
+// alignTranslation estimates the (dx, dy) that best registers b onto a with a
+// coarse-to-fine search over integer translations.
+func alignTranslation(a, b image.Image) (int, int) {
+	coarseA := toGrayPlane(imaging.Fit(a, diffCoarseSide, diffCoarseSide, imaging.Box))
+	coarseB := toGrayPlane(imaging.Resize(b, coarseA.w, coarseA.h, imaging.Box))
+	cx, cy := bestShift(coarseA, coarseB, 0, 0, diffCoarseShift)
+
+	// A light blur keeps sensor noise from deciding the last pixel or two.
+	fullA := toGrayPlane(imaging.Blur(a, diffAlignBlur))
+	fullB := toGrayPlane(imaging.Blur(b, diffAlignBlur))
+	scale := float64(fullA.w) / float64(coarseA.w)
+	return bestShift(fullA, fullB, int(math.Round(float64(cx)*scale)), int(math.Round(float64(cy)*scale)), diffRefineShift)
+}
+
+// DiffMetrics summarises how two aligned images differ.
+type DiffMetrics struct {
+	A       string  `json:"a"`
+	B       string  `json:"b"`
+	OffsetX int     `json:"offset_x"`
+	OffsetY int     `json:"offset_y"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	RMSE    float64 `json:"rmse"`
+	SSIM    float64 `json:"ssim"`
+}
+
+// compareImages computes RMSE and mean SSIM over the overlap of a and the
+// shifted b, and returns the overlap with its per-pixel absolute difference.
+func compareImages(a, b grayPlane, dx, dy int) (float64, float64, image.Rectangle, []float64) {
+	r := overlap(a, b, dx, dy)
+	diff := make([]float64, r.Dx()*r.Dy())
+
+	var sq float64
+	i := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			d := a.at(x, y) - b.at(x-dx, y-dy)
+			sq += d * d
+			diff[i] = math.Abs(d)
+			i++
+		}
+	}
+	rmse := math.Sqrt(sq / float64(len(diff)))
+
+	// Mean SSIM over non-overlapping windows, with the usual constants for
+	// an 8-bit dynamic range.
+	const c1, c2 = (0.01 * 255) * (0.01 * 255), (0.03 * 255) * (0.03 * 255)
+	var ssimSum float64
+	var windows int
+	for wy := r.Min.Y; wy+ssimWindow <= r.Max.Y; wy += ssimWindow {
+		for wx := r.Min.X; wx+ssimWindow <= r.Max.X; wx += ssimWindow {
+			var sa, sb, saa, sbb, sab float64
+			for y := wy; y < wy+ssimWindow; y++ {
+				for x := wx; x < wx+ssimWindow; x++ {
+					va, vb := a.at(x, y), b.at(x-dx, y-dy)
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			n := float64(ssimWindow * ssimWindow)
+			ma, mb := sa/n, sb/n
+			va, vb := saa/n-ma*ma, sbb/n-mb*mb
+			cov := sab/n - ma*mb
+			ssimSum += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	ssim := 1.0
+	if windows > 0 {
+		ssim = ssimSum / float64(windows)
+	}
+
+	return rmse, ssim, r, diff
+}
+
+// renderDiffMap draws the difference with a black-red-yellow-white ramp,
+// stretched to the largest difference so faint changes stay visible.
+func renderDiffMap(r image.Rectangle, diff []float64) *image.NRGBA {
+	peak := 1.0
+	for _, d := range diff {
+		peak = max(peak, d)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for i, d := range diff {
+		t := d / peak
+		img.Pix[i*4+0] = uint8(255 * math.Min(1, 3*t))
+		img.Pix[i*4+1] = uint8(255 * math.Max(0, math.Min(1, 3*t-1)))
+		img.Pix[i*4+2] = uint8(255 * math.Max(0, math.Min(1, 3*t-2)))
+		img.Pix[i*4+3] = 0xff
+	}
+	return img
+}
+
+// getImageDiff aligns image b onto image a, returning a difference map with
+// the metrics in X-Diff-* headers, or just the metrics with ?output=json.
+func (api *API) getImageDiff(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	idA, idB := c.Query("a"), c.Query("b")
+	if idA == "" || idB == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Both 'a' and 'b' image IDs are required.")
+		return
+	}
+
+	output := c.DefaultQuery("output", "image")
+	if output != "image" && output != "json" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'output' parameter. Must be image or json.")
+		return
+	}
+
+	format := negotiateFormat(c.GetHeader("Accept"))
+	if formatStr := c.Query("format"); formatStr != "" {
+		f, ok := lookupFormat(formatStr)
+		if !ok {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'format' parameter. Must be one of jpeg, png, webp, avif, tiff.")
+			return
+		}
+		format = f
+	}
+	gated := ProcessOptions{Format: format, Negotiated: c.Query("format") == ""}
+	if err := api.gateFormat(c.Request.Context(), &gated, c.GetHeader("Accept")); err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	format = gated.Format
+
+	// Each source is brought down to the working size as soon as it is
+	// decoded, so that neither holds its share of the limiter while waiting
+	// on the other.
+	var imgA, imgB image.Image
+	load := func(ctx context.Context, id string) (image.Image, error) {
+		img, release, err := api.loadSourceImage(ctx, bucketName, id)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return imaging.Fit(img, diffMaxSide, diffMaxSide, imaging.Lanczos), nil
+	}
+	g, ctx := errgroup.WithContext(c.Request.Context())
+	g.Go(func() (err error) {
+		imgA, err = load(ctx, idA)
+		return err
+	})
+	g.Go(func() (err error) {
+		imgB, err = load(ctx, idB)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		if errors.Is(err, errOverloaded) {
+			respondOverloaded(c)
+			return
+		}
+		if errors.Is(err, errImageTooLarge) {
+			respondError(c, http.StatusUnprocessableEntity, CodeImageTooLarge, imageTooLargeMessage())
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load images for diff", "a", idA, "b", idB, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+		return
+	}
+
+	// Work at a's resolution (capped), with b resampled to match.
+	if imgB.Bounds().Size() != imgA.Bounds().Size() {
+		imgB = imaging.Resize(imgB, imgA.Bounds().Dx(), imgA.Bounds().Dy(), imaging.Lanczos)
+	}
+
+	dx, dy := alignTranslation(imgA, imgB)
+	rmse, ssim, region, diff := compareImages(toGrayPlane(imgA), toGrayPlane(imgB), dx, dy)
+
+	metrics := DiffMetrics{
+		A:       idA,
+		B:       idB,
+		OffsetX: dx,
+		OffsetY: dy,
+		Width:   region.Dx(),
+		Height:  region.Dy(),
+		RMSE:    math.Round(rmse*1000) / 1000,
+		SSIM:    math.Round(ssim*10000) / 10000,
+	}
+
+	if output == "json" {
+		c.IndentedJSON(http.StatusOK, metrics)
+		return
+	}
+
+	var diffMap image.Image = renderDiffMap(region, diff)
+	if api.Overlay != nil {
+		diffMap = drawOverlay(diffMap, api.Overlay)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Encode(&buf, diffMap, EncodeOptions{Quality: defaultQuality}); err != nil {
+		slog.ErrorContext(ctx, "failed to encode diff map", "a", idA, "b", idB, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+		return
+	}
+
+	c.Header("X-Diff-RMSE", strconv.FormatFloat(metrics.RMSE, 'f', -1, 64))
+	c.Header("X-Diff-SSIM", strconv.FormatFloat(metrics.SSIM, 'f', -1, 64))
+	c.Header("X-Diff-Offset", strconv.Itoa(dx)+","+strconv.Itoa(dy))
+	c.Header("Access-Control-Expose-Headers", "X-Diff-RMSE, X-Diff-SSIM, X-Diff-Offset")
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, format.ContentType, buf.Bytes())
+}
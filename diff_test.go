@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// testPlane is a w×h plane with each pixel set by value.
+func testPlane(w, h int, value func(x, y int) float64) grayPlane {
+	p := grayPlane{w: w, h: h, pix: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p.pix[y*w+x] = value(x, y)
+		}
+	}
+	return p
+}
+
+func TestOverlap(t *testing.T) {
+	a, b := grayPlane{w: 100, h: 50}, grayPlane{w: 80, h: 60}
+	for _, tt := range []struct {
+		dx, dy int
+		want   image.Rectangle
+	}{
+		{0, 0, image.Rect(0, 0, 80, 50)},
+		{10, 5, image.Rect(10, 5, 90, 50)},
+		{-10, -5, image.Rect(0, 0, 70, 50)},
+		{100, 0, image.Rectangle{}},
+	} {
+		if got := overlap(a, b, tt.dx, tt.dy); got != tt.want {
+			t.Errorf("overlap(%d, %d) = %v, want %v", tt.dx, tt.dy, got, tt.want)
+		}
+	}
+}
+
+func TestCompareImages(t *testing.T) {
+	texture := func(x, y int) float64 { return float64((x*37 + y*91 + x*y) % 200) }
+	a := testPlane(64, 64, texture)
+
+	rmse, ssim, r, diff := compareImages(a, a, 0, 0)
+	if rmse != 0 || math.Abs(ssim-1) > 1e-9 || r != image.Rect(0, 0, 64, 64) || len(diff) != 64*64 {
+		t.Errorf("identical: rmse %g, ssim %g over %v", rmse, ssim, r)
+	}
+
+	// An offset in brightness is all RMSE sees, and barely lowers SSIM.
+	brighter := testPlane(64, 64, func(x, y int) float64 { return texture(x, y) + 10 })
+	rmse, ssim, _, _ = compareImages(a, brighter, 0, 0)
+	if math.Abs(rmse-10) > 1e-9 || ssim < 0.95 || ssim >= 1 {
+		t.Errorf("brighter: rmse %g, ssim %g", rmse, ssim)
+	}
+
+	// Unrelated structure scores far lower.
+	other := testPlane(64, 64, func(x, y int) float64 { return texture(y, 63-x) })
+	if _, low, _, _ := compareImages(a, other, 0, 0); low > 0.5 {
+		t.Errorf("unrelated ssim %g", low)
+	}
+
+	// b shifted by (3, 2) matches a over the overlap.
+	shifted := testPlane(64, 64, func(x, y int) float64 { return texture(x+3, y+2) })
+	rmse, ssim, r, _ = compareImages(a, shifted, 3, 2)
+	if rmse != 0 || math.Abs(ssim-1) > 1e-9 || r != image.Rect(3, 2, 64, 64) {
+		t.Errorf("shifted: rmse %g, ssim %g over %v", rmse, ssim, r)
+	}
+}
+
+func TestAlignTranslation(t *testing.T) {
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := imaging.Decode(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b(x, y) is a(x+5, y-3), so b moved by (5, -3) lines up with a.
+	a := imaging.Crop(src, image.Rect(20, 20, 532, 532))
+	b := imaging.Crop(src, image.Rect(25, 17, 537, 529))
+	if dx, dy := alignTranslation(a, b); dx != 5 || dy != -3 {
+		t.Errorf("alignTranslation = %d, %d, want 5, -3", dx, dy)
+	}
+}
+
+func TestRenderDiffMap(t *testing.T) {
+	img := renderDiffMap(image.Rect(0, 0, 3, 1), []float64{0, 50, 150})
+	for x, want := range [][4]uint8{{0, 0, 0, 255}, {255, 0, 0, 255}, {255, 255, 255, 255}} {
+		if got := img.Pix[x*4 : x*4+4]; [4]uint8(got) != want {
+			t.Errorf("pixel %d = %v, want %v", x, got, want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	downlinkRefresh       = 5 * time.Minute
+	defaultDownlinkGrace  = 6 * time.Hour
+	downlinkCheckInterval = 15 * time.Minute
+	// downlinkLookback is how long after its collection window a mission
+	// can still be overdue, so that old missions recorded before downlinks
+	// were tracked do not all alert.
+	downlinkLookback      = 7 * 24 * time.Hour
+	maxMissionDownlinks   = 1000
+	maxGroundStationChars = 100
+)
+
+// downlinkExemptStatuses are the mission statuses, compared without regard
+// to case, of missions not expected to collect and so never overdue.
+var downlinkExemptStatuses = []string{"proposed", "cancelled", "canceled"}
+
+// Downlink records imagery for a mission reaching the ground during a pass
+// over a ground station.
+type Downlink struct {
+	ID string `dynamodbav:"id" json:"id"`
+	// MissionID is the mission's ID as MISSION_TABLE keys it, and so
+	// carries the tenant with MULTI_TENANT.
+	MissionID     string `dynamodbav:"mission_id" json:"mission_id"`
+	GroundStation string `dynamodbav:"ground_station" json:"ground_station"`
+	// PassStart and PassEnd are the pass's unix times.
+	PassStart int64   `dynamodbav:"pass_start" json:"pass_start"`
+	PassEnd   int64   `dynamodbav:"pass_end" json:"pass_end"`
+	VolumeMB  float64 `dynamodbav:"volume_mb" json:"volume_mb"`
+	// ImageIDs are the mission's images the pass brought down, if known.
+	ImageIDs []string `dynamodbav:"image_ids,omitempty" json:"image_ids,omitempty"`
+	Recorded int64    `dynamodbav:"recorded" json:"recorded"`
+}
+
+// DownlinkStore holds the downlinks recorded. When DOWNLINKS_TABLE is set
+// they are saved to DynamoDB and shared by every instance; without it they
+// live only in this process.
+type DownlinkStore struct {
+	mu        sync.RWMutex
+	downlinks map[string]*Downlink
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newDownlinkStore(db *dynamodb.Client, table string) *DownlinkStore {
+	s := &DownlinkStore{downlinks: make(map[string]*Downlink), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the downlinks and rereads them until ctx is done.
+func (s *DownlinkStore) Start(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load downlinks", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(downlinkRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload downlinks", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Add records d under a new ID.
+func (s *DownlinkStore) Add(ctx context.Context, d Downlink) (Downlink, error) {
+	if len(s.ForMission(d.MissionID)) >= maxMissionDownlinks {
+		return Downlink{}, newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("At most %d downlinks are kept per mission.", maxMissionDownlinks))
+	}
+	d.ID = newJobID()
+	d.Recorded = time.Now().Unix()
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(d)
+		if err != nil {
+			return Downlink{}, fmt.Errorf("marshal downlink: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return Downlink{}, err
+		}
+	}
+	stored := d
+	s.mu.Lock()
+	s.downlinks[d.ID] = &stored
+	s.mu.Unlock()
+	return d, nil
+}
+
+// ForMission returns the downlinks of the mission keyed missionID, in pass
+// order.
+func (s *DownlinkStore) ForMission(missionID string) []Downlink {
+	s.mu.RLock()
+	list := []Downlink{}
+	for _, d := range s.downlinks {
+		if d.MissionID == missionID {
+			list = append(list, *d)
+		}
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(list, func(a, b Downlink) int {
+		return cmp.Or(cmp.Compare(a.PassStart, b.PassStart), cmp.Compare(a.ID, b.ID))
+	})
+	return list
+}
+
+// refresh replaces the downlinks with DOWNLINKS_TABLE's.
+func (s *DownlinkStore) refresh(ctx context.Context) error {
+	downlinks := make(map[string]*Downlink)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []Downlink
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for i := range items {
+			downlinks[items[i].ID] = &items[i]
+		}
+	}
+	s.mu.Lock()
+	s.downlinks = downlinks
+	s.mu.Unlock()
+	return nil
+}
+
+// downlinkDue is when m's imagery is overdue without a downlink, or zero
+// when it never is: it has no collection window, or a status that does not
+// collect.
+func (api *API) downlinkDue(m *Mission) int64 {
+	exempt := slices.ContainsFunc(downlinkExemptStatuses, func(s string) bool { return strings.EqualFold(m.Status, s) })
+	if exempt || m.CollectionWindowEnd <= 0 {
+		return 0
+	}
+	return m.CollectionWindowEnd + int64(api.DownlinkGrace.Seconds())
+}
+
+// OverdueDownlink is a mission past its collection window and the grace
+// after it with nothing downlinked.
+type OverdueDownlink struct {
+	MissionID           string `json:"mission_id"`
+	Name                string `json:"name"`
+	Status              string `json:"status"`
+	ObserverSatelliteID string `json:"observer_satellite_id"`
+	CollectionWindowEnd int64  `json:"collection_window_end"`
+	DueAt               int64  `json:"due_at"`
+	// recordID is the mission's MISSION_TABLE key.
+	recordID string
+	mission  Mission
+}
+
+// overdueDownlinks reads every one of ctx's tenant's missions and returns
+// those overdue at now, within downlinkLookback of their due time, in due
+// order.
+func (api *API) overdueDownlinks(ctx context.Context, now time.Time) ([]OverdueDownlink, error) {
+	overdue := []OverdueDownlink{}
+	token := ""
+	for {
+		missions, next, err := api.MissionDB.Missions(ctx, streamIndexPage, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range missions {
+			due := api.downlinkDue(&m)
+			if due == 0 || due > now.Unix() || due < now.Add(-downlinkLookback).Unix() {
+				continue
+			}
+			recordID := tenantRecordID(ctx, m.ID)
+			if len(api.Downlinks.ForMission(recordID)) > 0 {
+				continue
+			}
+			overdue = append(overdue, OverdueDownlink{
+				MissionID:           m.ID,
+				Name:                m.Name,
+				Status:              m.Status,
+				ObserverSatelliteID: m.ObserverSatelliteID,
+				CollectionWindowEnd: m.CollectionWindowEnd,
+				DueAt:               due,
+				recordID:            recordID,
+				mission:             m,
+			})
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	slices.SortStableFunc(overdue, func(a, b OverdueDownlink) int { return cmp.Compare(a.DueAt, b.DueAt) })
+	return overdue, nil
+}
+
+// downlinkWatch publishes a mission.downlink_overdue event, once per
+// process, for each mission that becomes overdue.
+type downlinkWatch struct {
+	api     *API
+	alerted map[string]bool
+}
+
+func newDownlinkWatch(api *API) *downlinkWatch {
+	return &downlinkWatch{api: api, alerted: map[string]bool{}}
+}
+
+// Start checks every downlinkCheckInterval until ctx is done.
+func (w *downlinkWatch) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(downlinkCheckInterval)
+		defer ticker.Stop()
+		for {
+			if err := w.check(ctx, time.Now()); err != nil {
+				slog.ErrorContext(ctx, "failed to check for overdue downlinks", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// check alerts on the missions newly overdue at now. Missions no longer
+// overdue are forgotten, so they alert again if they become overdue again.
+func (w *downlinkWatch) check(ctx context.Context, now time.Time) error {
+	overdue, err := w.api.overdueDownlinks(ctx, now)
+	if err != nil {
+		return err
+	}
+	downlinksOverdue.Set(float64(len(overdue)))
+	alerted := make(map[string]bool, len(overdue))
+	for _, o := range overdue {
+		alerted[o.recordID] = true
+		if w.alerted[o.recordID] {
+			continue
+		}
+		id, tenant := o.recordID, ""
+		if t, rest, ok := splitRecordID(id); ok && w.api.Config.MultiTenant {
+			id, tenant = rest, t
+		}
+		mission := o.mission.withID(id)
+		slog.WarnContext(ctx, "mission downlink overdue", "id", id, "tenant", tenant, "due", o.DueAt)
+		w.api.Events.Publish(Event{Type: EventMissionDownlinkOverdue, MissionID: id, SourceID: "downlink-overdue/" + o.recordID, Mission: mission, Tenant: tenant})
+	}
+	w.alerted = alerted
+	return nil
+}
+
+type downlinkRequest struct {
+	GroundStation string   `json:"ground_station"`
+	PassStart     int64    `json:"pass_start"`
+	PassEnd       int64    `json:"pass_end"`
+	VolumeMB      float64  `json:"volume_mb"`
+	ImageIDs      []string `json:"image_ids"`
+}
+
+func (req downlinkRequest) validate(m *Mission) error {
+	invalid := func(msg string) error { return newProblem(http.StatusBadRequest, CodeInvalidBody, msg) }
+	station := strings.TrimSpace(req.GroundStation)
+	switch {
+	case station == "" || utf8.RuneCountInString(station) > maxGroundStationChars:
+		return invalid(fmt.Sprintf("'ground_station' must be 1 to %d characters.", maxGroundStationChars))
+	case req.PassStart <= 0 || req.PassEnd < req.PassStart:
+		return invalid("'pass_start' and 'pass_end' must be unix times, with pass_end not before pass_start.")
+	case !(req.VolumeMB >= 0) || req.VolumeMB > 1e12:
+		return invalid("'volume_mb' must not be negative.")
+	}
+	for _, id := range req.ImageIDs {
+		if !slices.Contains(m.ImageIDs, id) {
+			return invalid(fmt.Sprintf("Image %q is not one of the mission's images.", id))
+		}
+	}
+	return nil
+}
+
+// MissionDownlinksResponse is the body of GET /mission/:id/downlinks.
+type MissionDownlinksResponse struct {
+	MissionID string     `json:"mission_id"`
+	Downlinks []Downlink `json:"downlinks"`
+	VolumeMB  float64    `json:"volume_mb"`
+	// DueAt is when the mission is overdue without a downlink, and Overdue
+	// whether it is.
+	DueAt   int64 `json:"due_at,omitempty"`
+	Overdue bool  `json:"overdue"`
+}
+
+// OverdueDownlinksResponse is the body of GET /downlinks/overdue.
+type OverdueDownlinksResponse struct {
+	Missions []OverdueDownlink `json:"missions"`
+}
+
+// loadDownlinkMission answers for a mission that cannot be loaded, and
+// returns nil then.
+func (api *API) loadDownlinkMission(c *gin.Context) *Mission {
+	ctx := c.Request.Context()
+	mission, err := api.loadMission(ctx, c.Param("id"))
+	if errors.Is(err, errMissionNotFound) {
+		respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+		return nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load mission", "id", c.Param("id"), "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return nil
+	}
+	return mission
+}
+
+// postMissionDownlink records a downlink of the mission's imagery.
+func (api *API) postMissionDownlink(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req downlinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"ground_station\": ..., \"pass_start\": ..., \"pass_end\": ..., \"volume_mb\": ...}.")
+		return
+	}
+	mission := api.loadDownlinkMission(c)
+	if mission == nil {
+		return
+	}
+	if err := req.validate(mission); err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	d, err := api.Downlinks.Add(ctx, Downlink{
+		MissionID:     tenantRecordID(ctx, c.Param("id")),
+		GroundStation: strings.TrimSpace(req.GroundStation),
+		PassStart:     req.PassStart,
+		PassEnd:       req.PassEnd,
+		VolumeMB:      req.VolumeMB,
+		ImageIDs:      slices.Compact(slices.Sorted(slices.Values(req.ImageIDs))),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to store downlink", "mission", c.Param("id"), "err", err)
+		respondProblem(c, problemFor(err))
+		return
+	}
+	downlinksRecorded.Inc()
+	slog.InfoContext(ctx, "recorded downlink", "mission", c.Param("id"), "ground_station", d.GroundStation, "volume_mb", d.VolumeMB)
+	d.MissionID = c.Param("id")
+	c.JSON(http.StatusCreated, d)
+}
+
+func (api *API) getMissionDownlinks(c *gin.Context) {
+	ctx := c.Request.Context()
+	mission := api.loadDownlinkMission(c)
+	if mission == nil {
+		return
+	}
+	out := MissionDownlinksResponse{MissionID: c.Param("id"), Downlinks: api.Downlinks.ForMission(tenantRecordID(ctx, c.Param("id")))}
+	for i := range out.Downlinks {
+		out.Downlinks[i].MissionID = out.MissionID
+		out.VolumeMB += out.Downlinks[i].VolumeMB
+	}
+	out.DueAt = api.downlinkDue(mission)
+	out.Overdue = out.DueAt != 0 && len(out.Downlinks) == 0 && time.Now().Unix() > out.DueAt
+	c.IndentedJSON(http.StatusOK, out)
+}
+
+func (api *API) getOverdueDownlinks(c *gin.Context) {
+	ctx := c.Request.Context()
+	overdue, err := api.overdueDownlinks(ctx, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list missions", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve missions")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, OverdueDownlinksResponse{Missions: overdue})
+}
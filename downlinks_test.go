@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDownlinks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+	ended := now.Add(-8 * time.Hour).Unix()
+	missions := missionMap{
+		"m1":       {ID: "m1", Status: "completed", ObserverSatelliteID: "chaser", CollectionWindowStart: ended - 600, CollectionWindowEnd: ended, ImageIDs: []string{"f1", "f2"}},
+		"m2":       {ID: "m2", Status: "active", ObserverSatelliteID: "chaser", CollectionWindowStart: ended - 600, CollectionWindowEnd: ended},
+		"upcoming": {ID: "upcoming", Status: "planned", CollectionWindowStart: now.Unix(), CollectionWindowEnd: now.Add(time.Hour).Unix()},
+		"proposed": {ID: "proposed", Status: "Proposed", CollectionWindowStart: ended - 600, CollectionWindowEnd: ended},
+		"old":      {ID: "old", Status: "completed", CollectionWindowStart: 1000, CollectionWindowEnd: 2000},
+	}
+	api := &API{MissionDB: missions, Downlinks: newDownlinkStore(nil, ""), DownlinkGrace: 6 * time.Hour, Events: newEventBus(), Config: &Config{}}
+	router := gin.New()
+	router.GET("/mission/:id/downlinks", api.getMissionDownlinks)
+	router.POST("/mission/:id/downlinks", api.postMissionDownlink)
+	router.GET("/downlinks/overdue", api.getOverdueDownlinks)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	var overdue OverdueDownlinksResponse
+	json.Unmarshal(do(http.MethodGet, "/downlinks/overdue", "").Body.Bytes(), &overdue)
+	if len(overdue.Missions) != 2 || overdue.Missions[0].MissionID != "m1" || overdue.Missions[1].MissionID != "m2" || overdue.Missions[0].DueAt != ended+6*3600 {
+		t.Errorf("overdue %+v", overdue.Missions)
+	}
+
+	// The watch alerts once for each mission that falls overdue.
+	events, cancel := api.Events.Subscribe(10, nil)
+	defer cancel()
+	watch := newDownlinkWatch(api)
+	for range 2 {
+		if err := watch.check(context.Background(), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"m1", "m2"} {
+		select {
+		case e := <-events:
+			if e.Type != EventMissionDownlinkOverdue || e.MissionID != want || e.Mission == nil || e.SourceID != "downlink-overdue/"+want {
+				t.Errorf("event %+v, want %s overdue", e, want)
+			}
+		default:
+			t.Fatalf("no overdue event for %s", want)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("alerted again: %+v", e)
+	default:
+	}
+
+	pass := `"pass_start": 1700000000, "pass_end": 1700000600`
+	tests := []struct {
+		method, path, body string
+		want               int
+		code               ErrorCode
+	}{
+		{http.MethodGet, "/mission/none/downlinks", "", http.StatusNotFound, CodeMissionNotFound},
+		{http.MethodPost, "/mission/none/downlinks", `{"ground_station": "Svalbard", ` + pass + `}`, http.StatusNotFound, CodeMissionNotFound},
+		{http.MethodPost, "/mission/m1/downlinks", `{"ground_station": " ", ` + pass + `}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/mission/m1/downlinks", `{"ground_station": "Svalbard", "pass_start": 1700000600, "pass_end": 1700000000}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/mission/m1/downlinks", `{"ground_station": "Svalbard", "volume_mb": -1, ` + pass + `}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/mission/m1/downlinks", `{"ground_station": "Svalbard", "image_ids": ["f9"], ` + pass + `}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/mission/m1/downlinks", `{"ground_station": "Svalbard", "volume_mb": 512.5, "image_ids": ["f2", "f1", "f2"], ` + pass + `}`, http.StatusCreated, ""},
+		{http.MethodPost, "/mission/m1/downlinks", `{"ground_station": "McMurdo", "volume_mb": 100, "pass_start": 1699990000, "pass_end": 1699990300}`, http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		w := do(tt.method, tt.path, tt.body)
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s %s %s: %d %s, want %d %s", tt.method, tt.path, tt.body, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+
+	var resp MissionDownlinksResponse
+	json.Unmarshal(do(http.MethodGet, "/mission/m1/downlinks", "").Body.Bytes(), &resp)
+	if len(resp.Downlinks) != 2 || resp.Downlinks[0].GroundStation != "McMurdo" || resp.VolumeMB != 612.5 || resp.Overdue || resp.DueAt != ended+6*3600 {
+		t.Errorf("m1 downlinks %+v", resp)
+	}
+	if d := resp.Downlinks[1]; d.MissionID != "m1" || d.ID == "" || d.Recorded == 0 || strings.Join(d.ImageIDs, ",") != "f1,f2" {
+		t.Errorf("downlink %+v", d)
+	}
+	resp = MissionDownlinksResponse{}
+	json.Unmarshal(do(http.MethodGet, "/mission/m2/downlinks", "").Body.Bytes(), &resp)
+	if len(resp.Downlinks) != 0 || !resp.Overdue {
+		t.Errorf("m2 downlinks %+v", resp)
+	}
+
+	// m1 is no longer overdue, and m2 stays alerted.
+	if err := watch.check(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if len(watch.alerted) != 1 || !watch.alerted["m2"] {
+		t.Errorf("alerted %v", watch.alerted)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("alerted again: %+v", e)
+	default:
+	}
+}
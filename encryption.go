@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/gin-gonic/gin"
+)
+
+// The headers a client sends its SSE-C key in, named as S3 names them.
+const (
+	sseCustomerAlgorithmHeader = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeyHeader       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Header    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+	sseCustomerAlgorithm       = "AES256"
+)
+
+// EncryptionConfig is how objects the server writes are encrypted at rest.
+type EncryptionConfig struct {
+	// KMSKeyID is the KMS key objects are written with, as an ID, ARN or
+	// alias; empty leaves them to the bucket's default encryption.
+	KMSKeyID string
+	// TenantKMSKeys are the keys of tenants that have their own, used for
+	// their objects in place of KMSKeyID.
+	TenantKMSKeys map[string]string
+}
+
+func (c EncryptionConfig) enabled() bool {
+	return c.KMSKeyID != "" || len(c.TenantKMSKeys) > 0
+}
+
+// loadEncryptionConfig reads SSE_KMS_KEY_ID and SSE_KMS_TENANT_KEYS, a
+// comma-separated list of tenant=key pairs.
+func loadEncryptionConfig() (EncryptionConfig, []error) {
+	c := EncryptionConfig{KMSKeyID: os.Getenv("SSE_KMS_KEY_ID"), TenantKMSKeys: map[string]string{}}
+	var errs []error
+	for _, item := range splitList(os.Getenv("SSE_KMS_TENANT_KEYS")) {
+		tenant, key, ok := strings.Cut(item, "=")
+		tenant, key = strings.TrimSpace(tenant), strings.TrimSpace(key)
+		if !ok || key == "" || !tenantName.MatchString(tenant) {
+			errs = append(errs, fmt.Errorf("SSE_KMS_TENANT_KEYS entry %q is not tenant=key", item))
+			continue
+		}
+		c.TenantKMSKeys[tenant] = key
+	}
+	return c, errs
+}
+
+// sseCustomerKey is an SSE-C key sent with a request, which S3 needs to read
+// objects encrypted with it.
+type sseCustomerKey struct {
+	Key, KeyMD5 string
+}
+
+type sseCustomerKeyContextKey struct{}
+
+func customerKeyFrom(ctx context.Context) *sseCustomerKey {
+	k, _ := ctx.Value(sseCustomerKeyContextKey{}).(*sseCustomerKey)
+	return k
+}
+
+// parseCustomerKey reads the SSE-C headers of a request, returning nil when
+// it has none. The key is the base64 of 32 bytes; the MD5, when sent, must
+// be that of the key.
+func parseCustomerKey(h http.Header) (*sseCustomerKey, error) {
+	algorithm, key, sum := h.Get(sseCustomerAlgorithmHeader), h.Get(sseCustomerKeyHeader), h.Get(sseCustomerKeyMD5Header)
+	if algorithm == "" && key == "" && sum == "" {
+		return nil, nil
+	}
+	if algorithm != sseCustomerAlgorithm {
+		return nil, fmt.Errorf("%s must be %s", sseCustomerAlgorithmHeader, sseCustomerAlgorithm)
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("%s must be the base64 of a 256-bit key", sseCustomerKeyHeader)
+	}
+	digest := md5.Sum(raw)
+	want := base64.StdEncoding.EncodeToString(digest[:])
+	if sum != "" && sum != want {
+		return nil, fmt.Errorf("%s does not match the key", sseCustomerKeyMD5Header)
+	}
+	return &sseCustomerKey{Key: key, KeyMD5: want}, nil
+}
+
+// acceptCustomerKey passes a request's SSE-C key on to the object reads it
+// makes. Nothing read with one is cached, since the caches would serve it
+// to clients without the key.
+func acceptCustomerKey(c *gin.Context) {
+	key, err := parseCustomerKey(c.Request.Header)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	if key != nil {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), sseCustomerKeyContextKey{}, key))
+	}
+	c.Next()
+}
+
+// errCustomerKey is returned by reads that S3 refused for a missing or
+// wrong SSE-C key.
+var errCustomerKey = errors.New("the object needs its SSE-C key")
+
+// respondCustomerKey answers a read refused for errCustomerKey. sent is
+// whether the request carried a key.
+func respondCustomerKey(c *gin.Context, sent bool) {
+	detail := "The image is encrypted with a customer key. Send it in " + sseCustomerKeyHeader + "."
+	if sent {
+		detail = "The customer key sent does not decrypt the image."
+	}
+	respondError(c, http.StatusForbidden, CodeEncryptionKey, detail)
+}
+
+// encryptingStore asks for SSE-KMS on every object written, with the key of
+// the tenant whose prefix the object is under, and sends the request's
+// SSE-C key, if any, with every read.
+type encryptingStore struct {
+	ObjectStore
+	cfg EncryptionConfig
+}
+
+// encryptObjects wraps store to encrypt what it writes when cfg has a key,
+// and to pass SSE-C keys on to S3 whether or not it has.
+func encryptObjects(cfg EncryptionConfig, store ObjectStore) ObjectStore {
+	return encryptingStore{store, cfg}
+}
+
+// kmsKey is the key for the bucket key key.
+func (s encryptingStore) kmsKey(key string) string {
+	if tenant, _, ok := splitObjectKey(key); ok {
+		if k, ok := s.cfg.TenantKMSKeys[tenant]; ok {
+			return k
+		}
+	}
+	return s.cfg.KMSKeyID
+}
+
+func (s encryptingStore) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if k := customerKeyFrom(ctx); k != nil {
+		scoped := *in
+		scoped.SSECustomerAlgorithm, scoped.SSECustomerKey, scoped.SSECustomerKeyMD5 = aws.String(sseCustomerAlgorithm), aws.String(k.Key), aws.String(k.KeyMD5)
+		in = &scoped
+	}
+	out, err := s.ObjectStore.GetObject(ctx, in, optFns...)
+	return out, customerKeyError(ctx, err)
+}
+
+func (s encryptingStore) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if k := customerKeyFrom(ctx); k != nil {
+		scoped := *in
+		scoped.SSECustomerAlgorithm, scoped.SSECustomerKey, scoped.SSECustomerKeyMD5 = aws.String(sseCustomerAlgorithm), aws.String(k.Key), aws.String(k.KeyMD5)
+		in = &scoped
+	}
+	out, err := s.ObjectStore.HeadObject(ctx, in, optFns...)
+	return out, customerKeyError(ctx, err)
+}
+
+func (s encryptingStore) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if key := s.kmsKey(aws.ToString(in.Key)); key != "" && in.ServerSideEncryption == "" && in.SSECustomerKey == nil {
+		scoped := *in
+		scoped.ServerSideEncryption, scoped.SSEKMSKeyId = s3types.ServerSideEncryptionAwsKms, aws.String(key)
+		in = &scoped
+	}
+	return s.ObjectStore.PutObject(ctx, in, optFns...)
+}
+
+func (s encryptingStore) CopyObject(ctx context.Context, in *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	scoped := *in
+	if key := s.kmsKey(aws.ToString(in.Key)); key != "" && in.ServerSideEncryption == "" && in.SSECustomerKey == nil {
+		scoped.ServerSideEncryption, scoped.SSEKMSKeyId = s3types.ServerSideEncryptionAwsKms, aws.String(key)
+	}
+	if k := customerKeyFrom(ctx); k != nil {
+		scoped.CopySourceSSECustomerAlgorithm, scoped.CopySourceSSECustomerKey, scoped.CopySourceSSECustomerKeyMD5 = aws.String(sseCustomerAlgorithm), aws.String(k.Key), aws.String(k.KeyMD5)
+	}
+	out, err := s.ObjectStore.CopyObject(ctx, &scoped, optFns...)
+	return out, customerKeyError(ctx, err)
+}
+
+// customerKeyError marks S3's refusals to read an SSE-C object as
+// errCustomerKey: InvalidRequest, or BadRequest to a HEAD, for a missing key
+// or one sent for an object without, and AccessDenied for the wrong key.
+func customerKeyError(ctx context.Context, err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "InvalidRequest", "BadRequest":
+	case "AccessDenied", "Forbidden":
+		if customerKeyFrom(ctx) == nil {
+			return err
+		}
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", errCustomerKey, err)
+}
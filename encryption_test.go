@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestParseCustomerKey(t *testing.T) {
+	raw := strings.Repeat("k", 32)
+	key := base64.StdEncoding.EncodeToString([]byte(raw))
+	sum := md5.Sum([]byte(raw))
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	tests := []struct {
+		name      string
+		algorithm string
+		key       string
+		md5       string
+		wantErr   string
+	}{
+		{"none", "", "", "", ""},
+		{"key", "AES256", key, "", ""},
+		{"key and digest", "AES256", key, digest, ""},
+		{"no algorithm", "", key, "", "must be AES256"},
+		{"short key", "AES256", base64.StdEncoding.EncodeToString([]byte("short")), "", "256-bit key"},
+		{"not base64", "AES256", "not base64!", "", "256-bit key"},
+		{"wrong digest", "AES256", key, base64.StdEncoding.EncodeToString(make([]byte, 16)), "does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for name, v := range map[string]string{sseCustomerAlgorithmHeader: tt.algorithm, sseCustomerKeyHeader: tt.key, sseCustomerKeyMD5Header: tt.md5} {
+				if v != "" {
+					h.Set(name, v)
+				}
+			}
+			got, err := parseCustomerKey(h)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.key == "" && got != nil || tt.key != "" && (got == nil || got.Key != key || got.KeyMD5 != digest) {
+				t.Errorf("key = %+v", got)
+			}
+		})
+	}
+}
+
+// recordingStore keeps the last inputs it was given, and answers reads with
+// err.
+type recordingStore struct {
+	ObjectStore
+	put *s3.PutObjectInput
+	get *s3.GetObjectInput
+	err error
+}
+
+func (s *recordingStore) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	s.put = in
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (s *recordingStore) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	s.get = in
+	return &s3.GetObjectOutput{}, s.err
+}
+
+func TestEncryptingStore(t *testing.T) {
+	inner := &recordingStore{}
+	store := encryptObjects(EncryptionConfig{KMSKeyID: "alias/sat", TenantKMSKeys: map[string]string{"acme": "alias/acme"}}, inner)
+	ctx := context.Background()
+
+	for key, want := range map[string]string{"img1": "alias/sat", "tenants/acme/img1": "alias/acme", "tenants/globex/img1": "alias/sat"} {
+		store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("b"), Key: aws.String(key)})
+		if inner.put.ServerSideEncryption != s3types.ServerSideEncryptionAwsKms || aws.ToString(inner.put.SSEKMSKeyId) != want {
+			t.Errorf("%s: written with %s %s, want %s", key, inner.put.ServerSideEncryption, aws.ToString(inner.put.SSEKMSKeyId), want)
+		}
+	}
+	if encryptObjects(EncryptionConfig{}, inner).PutObject(ctx, &s3.PutObjectInput{Key: aws.String("img1")}); inner.put.ServerSideEncryption != "" {
+		t.Errorf("written with %s without a key", inner.put.ServerSideEncryption)
+	}
+
+	store.GetObject(ctx, &s3.GetObjectInput{Key: aws.String("img1")})
+	if inner.get.SSECustomerKey != nil {
+		t.Error("read with a customer key the request did not send")
+	}
+	keyed := context.WithValue(ctx, sseCustomerKeyContextKey{}, &sseCustomerKey{Key: "a2V5", KeyMD5: "bWQ1"})
+	store.GetObject(keyed, &s3.GetObjectInput{Key: aws.String("img1")})
+	if aws.ToString(inner.get.SSECustomerAlgorithm) != "AES256" || aws.ToString(inner.get.SSECustomerKey) != "a2V5" || aws.ToString(inner.get.SSECustomerKeyMD5) != "bWQ1" {
+		t.Errorf("read with %+v", inner.get)
+	}
+
+	tests := []struct {
+		code string
+		ctx  context.Context
+		want bool
+	}{
+		{"InvalidRequest", ctx, true},
+		{"AccessDenied", keyed, true},
+		{"AccessDenied", ctx, false},
+		{"NoSuchKey", keyed, false},
+	}
+	for _, tt := range tests {
+		inner.err = &smithy.GenericAPIError{Code: tt.code}
+		if _, err := store.GetObject(tt.ctx, &s3.GetObjectInput{Key: aws.String("img1")}); errors.Is(err, errCustomerKey) != tt.want {
+			t.Errorf("%s: err = %v", tt.code, err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultEphemerisSpan = 90 * time.Minute
+	defaultEphemerisStep = time.Minute
+	// ephemerisMaxSamples caps the samples one request propagates.
+	ephemerisMaxSamples = 10000
+)
+
+// EphemerisSample is the satellite's state at Time, in the TEME frame SGP4
+// propagates in and in the Earth-fixed frame.
+type EphemerisSample struct {
+	Time time.Time   `json:"time"`
+	ECI  StateVector `json:"eci"`
+	ECEF StateVector `json:"ecef"`
+}
+
+// EphemerisResponse is the body of GET /satellite/:id/ephemeris.
+type EphemerisResponse struct {
+	Satellite string `json:"satellite"`
+	// TLE is the element set propagated.
+	TLE     TLE               `json:"tle"`
+	Samples []EphemerisSample `json:"samples"`
+}
+
+// orbit is the SGP4 model of the satellite's element set nearest at, or of
+// its catalog TLE when none has been recorded.
+func (api *API) orbit(ctx context.Context, id string, at time.Time) (*sgp4, TLE, error) {
+	sat, err := api.Satellites.Get(id)
+	if err != nil {
+		return nil, TLE{}, newProblem(http.StatusNotFound, CodeSatelliteNotFound, "satellite not found")
+	}
+	var tle TLE
+	rec, err := api.TLEs.Closest(ctx, id, at)
+	switch {
+	case err == nil:
+		tle = rec.TLE
+	case !errors.Is(err, errTLENotFound):
+		slog.ErrorContext(ctx, "failed to read TLE history", "satellite", id, "err", err)
+		return nil, TLE{}, err
+	case sat.TLE != nil:
+		tle = *sat.TLE
+	default:
+		return nil, TLE{}, newProblem(http.StatusNotFound, CodeTLENotFound, "no TLE recorded for the satellite")
+	}
+	p, err := newSGP4(tle)
+	if err != nil {
+		return nil, TLE{}, newProblem(http.StatusUnprocessableEntity, CodePropagationFailed, err.Error())
+	}
+	return p, tle, nil
+}
+
+// parseTimeWindow reads ?start=&end=, unix times in seconds or RFC 3339
+// times, and ?step=, a duration such as 30s or a number of seconds. start
+// defaults to now, end to defaultSpan after it and step to defaultStep.
+func parseTimeWindow(c *gin.Context, defaultSpan, defaultStep time.Duration) (start, end time.Time, step time.Duration, err error) {
+	start = time.Now().UTC().Truncate(time.Second)
+	if v := c.Query("start"); v != "" {
+		if start, err = parseAuditTime(v); err != nil {
+			return start, end, 0, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'start' parameter. Must be a unix time or an RFC 3339 time.")
+		}
+	}
+	end = start.Add(defaultSpan)
+	if v := c.Query("end"); v != "" {
+		if end, err = parseAuditTime(v); err != nil || end.Before(start) {
+			return start, end, 0, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'end' parameter. Must be a unix time or an RFC 3339 time no earlier than start.")
+		}
+	}
+	if step, err = parseStep(c, defaultStep); err != nil {
+		return start, end, 0, err
+	}
+	if err := checkSamples(start, end, step); err != nil {
+		return start, end, 0, err
+	}
+	return start.UTC(), end.UTC(), step, nil
+}
+
+// parseStep reads ?step=, a duration such as 30s or a number of seconds.
+func parseStep(c *gin.Context, defaultStep time.Duration) (time.Duration, error) {
+	v := c.Query("step")
+	if v == "" {
+		return defaultStep, nil
+	}
+	step, err := time.ParseDuration(v)
+	if n, nerr := strconv.Atoi(v); nerr == nil {
+		step, err = time.Duration(n)*time.Second, nil
+	}
+	if err != nil || step < time.Second {
+		return 0, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'step' parameter. Must be a duration of at least 1s.")
+	}
+	return step, nil
+}
+
+// checkSamples refuses windows of more than ephemerisMaxSamples samples.
+func checkSamples(start, end time.Time, step time.Duration) error {
+	if n := end.Sub(start)/step + 1; n > ephemerisMaxSamples {
+		return newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("start to end at this step is %d samples; at most %d are allowed", n, ephemerisMaxSamples)).
+			with("parameter", "step").with("limit", ephemerisMaxSamples)
+	}
+	return nil
+}
+
+// getEphemeris propagates the satellite's element set nearest the middle of
+// ?start= to ?end= with SGP4, sampling every ?step=. ?format=csv returns CSV.
+func (api *API) getEphemeris(c *gin.Context) {
+	id := c.Param("id")
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'format' parameter. Must be json or csv.")
+		return
+	}
+	start, end, step, err := parseTimeWindow(c, defaultEphemerisSpan, defaultEphemerisStep)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	p, tle, err := api.orbit(c.Request.Context(), id, start.Add(end.Sub(start)/2))
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+
+	resp := EphemerisResponse{Satellite: id, TLE: tle, Samples: []EphemerisSample{}}
+	for t := start; !t.After(end); t = t.Add(step) {
+		eci, err := p.At(t)
+		if err != nil {
+			respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, fmt.Sprintf("at %s: %v", t.Format(time.RFC3339), err))
+			return
+		}
+		resp.Samples = append(resp.Samples, EphemerisSample{Time: t, ECI: eci, ECEF: temeToECEF(eci, t)})
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	if format == "csv" {
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", ephemerisCSV(resp.Samples))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// ephemerisCSV writes one row per sample, positions in km and velocities in
+// km/s.
+func ephemerisCSV(samples []EphemerisSample) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{"time"}
+	for _, frame := range []string{"eci", "ecef"} {
+		for _, axis := range []string{"x", "y", "z", "vx", "vy", "vz"} {
+			header = append(header, frame+"_"+axis)
+		}
+	}
+	w.Write(header)
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	for _, s := range samples {
+		row := []string{s.Time.Format(time.RFC3339Nano)}
+		for _, sv := range []StateVector{s.ECI, s.ECEF} {
+			for _, v := range sv.Position {
+				row = append(row, f(v))
+			}
+			for _, v := range sv.Velocity {
+				row = append(row, f(v))
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes()
+}
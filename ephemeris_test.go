@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEphemeris(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	api := &API{Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, "")}
+	vanguard, _, _ := parseTLE(vanguardLine1, vanguardLine2)
+	api.Satellites.Put(ctx, Satellite{ID: "vanguard", NoradID: 5, Name: "Vanguard 1", Status: SatelliteActive, TLE: &vanguard})
+	api.Satellites.Put(ctx, Satellite{ID: "bare", NoradID: 6, Name: "No TLE", Status: SatelliteUnknown})
+	router := gin.New()
+	router.GET("/satellite/:id/ephemeris", api.getEphemeris)
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	tests := []struct {
+		path string
+		want int
+		code ErrorCode
+	}{
+		{"/satellite/sputnik/ephemeris", http.StatusNotFound, CodeSatelliteNotFound},
+		{"/satellite/bare/ephemeris", http.StatusNotFound, CodeTLENotFound},
+		{"/satellite/vanguard/ephemeris?start=tomorrow", http.StatusBadRequest, CodeInvalidParameter},
+		{"/satellite/vanguard/ephemeris?start=1000&end=999", http.StatusBadRequest, CodeInvalidParameter},
+		{"/satellite/vanguard/ephemeris?step=0", http.StatusBadRequest, CodeInvalidParameter},
+		{"/satellite/vanguard/ephemeris?step=500ms", http.StatusBadRequest, CodeInvalidParameter},
+		{"/satellite/vanguard/ephemeris?end=2100-01-01T00:00:00Z&step=1s", http.StatusBadRequest, CodeLimitExceeded},
+		{"/satellite/vanguard/ephemeris?format=xml", http.StatusBadRequest, CodeInvalidParameter},
+	}
+	for _, tt := range tests {
+		w := do(tt.path)
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s: %d %s, want %d %s", tt.path, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+
+	// The element set's epoch is 2000-06-27T18:50:19.733568Z.
+	w := do("/satellite/vanguard/ephemeris?start=2000-06-27T18:50:19.733568Z&end=962153420&step=6h")
+	var resp EphemerisResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if len(resp.Samples) != 2 || resp.TLE.Line1 != vanguardLine1 {
+		t.Fatalf("got %+v", resp)
+	}
+	// The second sample is 360 minutes after the epoch.
+	if got := resp.Samples[1].ECI.Position[0]; got < -7154.04 || got > -7154.02 {
+		t.Errorf("x at 360 min = %v", got)
+	}
+
+	w = do("/satellite/vanguard/ephemeris?start=962131819&end=962135419&step=600&format=csv")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 8 || len(rows[0]) != 13 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("CSV: %v %v", rows, err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	EventMissionCreated = "mission.created"
+	EventMissionUpdated = "mission.updated"
+	EventMissionDeleted = "mission.deleted"
+	// EventMissionCompleted follows the mission.updated of a change that
+	// set a mission's status to a completed one.
+	EventMissionCompleted = "mission.completed"
+	EventImageIngested    = "image.ingested"
+	// EventMissionDownlinkOverdue is published when a mission passes its
+	// collection window and DOWNLINK_GRACE with nothing downlinked.
+	EventMissionDownlinkOverdue = "mission.downlink_overdue"
+)
+
+// eventTypes are the known event types, for validating filters.
+var eventTypes = []string{EventMissionCreated, EventMissionUpdated, EventMissionDeleted, EventMissionCompleted, EventImageIngested, EventMissionDownlinkOverdue}
+
+// missionCompleteStatuses are the mission statuses, compared without regard
+// to case, that count as completed.
+var missionCompleteStatuses = []string{"complete", "completed"}
+
+// eventHistory is how many recent events are kept for clients resuming a
+// stream.
+const eventHistory = 1000
+
+// Event is a change pushed to subscribers such as /ws. IDs increase by one
+// per event, starting from the process's start time in microseconds, so they
+// also increase across restarts.
+type Event struct {
+	ID        uint64 `json:"id"`
+	Type      string `json:"type"`
+	Time      int64  `json:"time"`
+	MissionID string `json:"mission_id,omitempty"`
+	ImageID   string `json:"image_id,omitempty"`
+	// SourceID identifies the change that caused the event, where the
+	// source has one, such as a DynamoDB stream record's event ID. Unlike
+	// ID it is the same on every instance that publishes the change.
+	SourceID string `json:"source_id,omitempty"`
+	// Mission is the mission after the change; it is absent on deletion.
+	Mission *Mission `json:"mission,omitempty"`
+	// Tenant is the tenant the mission or image belongs to with
+	// MULTI_TENANT. Its clients are sent only its events.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// EventBus fans events out to in-process subscribers. Publish never blocks:
+// a subscriber whose buffer is full is dropped, its channel closed, so one
+// stalled client cannot hold up the rest. It is safe to use nil, when
+// publishing does nothing.
+type EventBus struct {
+	mu      sync.Mutex
+	seq     uint64
+	subs    map[*eventSubscription]struct{}
+	history []Event
+}
+
+type eventSubscription struct {
+	ch     chan Event
+	filter func(Event) bool
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{seq: uint64(time.Now().UnixMicro()), subs: map[*eventSubscription]struct{}{}}
+}
+
+// Publish stamps e with the next ID and the current time and delivers it.
+func (b *EventBus) Publish(e Event) Event {
+	if b == nil {
+		return e
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e.ID = b.seq
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	if len(b.history) == eventHistory {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:eventHistory-1]
+	}
+	b.history = append(b.history, e)
+	for s := range b.subs {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+	return e
+}
+
+// Subscribe returns a channel of the events filter accepts, or of all events
+// when filter is nil, buffering up to buffer of them. The channel is closed
+// if the subscriber falls behind or calls cancel. A nil bus never delivers.
+func (b *EventBus) Subscribe(buffer int, filter func(Event) bool) (<-chan Event, func()) {
+	if b == nil {
+		return nil, func() {}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribe(buffer, filter)
+}
+
+// Resume subscribes as Subscribe does, and also returns the retained events
+// after lastID that filter accepts. complete is false when some events after
+// lastID are no longer retained, or lastID is from another process's
+// sequence, so the backlog has a gap the caller must make up some other way.
+func (b *EventBus) Resume(lastID uint64, buffer int, filter func(Event) bool) (backlog []Event, events <-chan Event, cancel func(), complete bool) {
+	if b == nil {
+		return nil, nil, func() {}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	oldest := b.seq + 1
+	if len(b.history) > 0 {
+		oldest = b.history[0].ID
+	}
+	complete = lastID+1 >= oldest && lastID <= b.seq
+	for _, e := range b.history {
+		if e.ID > lastID && (filter == nil || filter(e)) {
+			backlog = append(backlog, e)
+		}
+	}
+	events, cancel = b.subscribe(buffer, filter)
+	return backlog, events, cancel, complete
+}
+
+func (b *EventBus) subscribe(buffer int, filter func(Event) bool) (<-chan Event, func()) {
+	s := &eventSubscription{ch: make(chan Event, buffer), filter: filter}
+	b.subs[s] = struct{}{}
+	return s.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[s]; ok {
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// consumeEvents passes each event published on bus to handle, in order,
+// until ctx is done. If handle falls behind and the bus drops it, it
+// resubscribes and catches up from the bus's history; name identifies the
+// consumer in the warning logged when that history no longer reaches back.
+func consumeEvents(ctx context.Context, bus *EventBus, buffer int, name string, handle func(Event)) {
+	events, cancel := bus.Subscribe(buffer, nil)
+	defer func() { cancel() }()
+	var last uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				var backlog []Event
+				var complete bool
+				backlog, events, cancel, complete = bus.Resume(last, buffer, nil)
+				if !complete {
+					slog.WarnContext(ctx, name+" fell behind, events were skipped", "after", last)
+				}
+				for _, e := range backlog {
+					handle(e)
+					last = e.ID
+				}
+				continue
+			}
+			handle(e)
+			last = e.ID
+		}
+	}
+}
+
+// isMissionEvent reports whether e is about a mission itself.
+func isMissionEvent(e Event) bool {
+	switch e.Type {
+	case EventMissionCreated, EventMissionUpdated, EventMissionDeleted, EventMissionCompleted:
+		return true
+	}
+	return false
+}
+
+func missionComplete(m *Mission) bool {
+	return m != nil && slices.ContainsFunc(missionCompleteStatuses, func(s string) bool {
+		return strings.EqualFold(m.Status, s)
+	})
+}
+
+// publishMissionChange reads a mission a writer reported as changed and
+// publishes it as updated, or as deleted when it is gone. A writer's report
+// does not say whether the mission is new, so creation is not told apart.
+// before is the mission as last cached, if it was.
+func (api *API) publishMissionChange(ctx context.Context, id string, before *Mission) {
+	if api.Events == nil {
+		return
+	}
+	mission, err := api.loadMission(ctx, id)
+	switch {
+	case errors.Is(err, errMissionNotFound):
+		api.publishMission(ctx, EventMissionDeleted, id, "", before, nil)
+	case err != nil:
+		slog.ErrorContext(ctx, "failed to load changed mission", "id", id, "err", err)
+	default:
+		api.publishMission(ctx, EventMissionUpdated, id, "", before, mission)
+	}
+}
+
+// publishMission publishes an event of eventType for ctx's tenant's mission
+// id, which is after once changed and nil once deleted. A completed mission
+// that before, if known, did not show as completed is also published as
+// completed.
+func (api *API) publishMission(ctx context.Context, eventType, id, source string, before, after *Mission) {
+	tenant := tenantFrom(ctx)
+	api.Events.Publish(Event{Type: eventType, MissionID: id, SourceID: source, Mission: after, Tenant: tenant})
+	if missionComplete(after) && !missionComplete(before) {
+		api.Events.Publish(Event{Type: EventMissionCompleted, MissionID: id, SourceID: source, Mission: after, Tenant: tenant})
+	}
+}
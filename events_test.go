@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus()
+	all, cancelAll := bus.Subscribe(4, nil)
+	defer cancelAll()
+	missions, cancelMissions := bus.Subscribe(1, isMissionEvent)
+	defer cancelMissions()
+
+	bus.Publish(Event{Type: "other"})
+	bus.Publish(Event{Type: EventMissionUpdated, MissionID: "m1"})
+	first := <-all
+	if first.ID == 0 || first.Type != "other" || first.Time == 0 {
+		t.Errorf("first event = %+v", first)
+	}
+	if e := <-all; e.ID != first.ID+1 {
+		t.Errorf("second event = %+v after %d", e, first.ID)
+	}
+	if e := <-missions; e.MissionID != "m1" {
+		t.Errorf("filtered event = %+v", e)
+	}
+
+	// A subscriber with a full buffer is dropped rather than waited for.
+	bus.Publish(Event{Type: EventMissionDeleted})
+	bus.Publish(Event{Type: EventMissionDeleted})
+	<-missions
+	if _, ok := <-missions; ok {
+		t.Error("slow subscriber still open")
+	}
+	cancelMissions()
+
+	// All four events are retained, so resuming after one has no gap; an id
+	// from before them or beyond them has.
+	backlog, _, cancel, complete := bus.Resume(first.ID+1, 1, isMissionEvent)
+	cancel()
+	if len(backlog) != 2 || backlog[0].ID != first.ID+2 || !complete {
+		t.Errorf("Resume after the second = %+v, complete %v", backlog, complete)
+	}
+	for _, last := range []uint64{0, first.ID + 10} {
+		if _, _, cancel, complete := bus.Resume(last, 1, nil); complete {
+			t.Errorf("Resume(%d) reported no gap", last)
+		} else {
+			cancel()
+		}
+	}
+
+	var nilBus *EventBus
+	nilBus.Publish(Event{})
+	if ch, cancel := nilBus.Subscribe(1, nil); ch != nil {
+		t.Error("nil bus delivered")
+	} else {
+		cancel()
+	}
+}
+
+// missionMap is a MissionStore of fixed missions.
+type missionMap map[string]*Mission
+
+func (m missionMap) Mission(_ context.Context, id string) (*Mission, error) {
+	if mission, ok := m[id]; ok {
+		return mission, nil
+	}
+	return nil, errMissionNotFound
+}
+
+// Missions returns every mission in one page.
+func (m missionMap) Missions(context.Context, int32, string) ([]Mission, string, error) {
+	var missions []Mission
+	for _, id := range slices.Sorted(maps.Keys(m)) {
+		missions = append(missions, *m[id])
+	}
+	return missions, "", nil
+}
+
+func (m missionMap) LinkImage(_ context.Context, missionID, imageID string) (bool, error) {
+	mission, ok := m[missionID]
+	if !ok {
+		return false, errMissionNotFound
+	}
+	if slices.Contains(mission.ImageIDs, imageID) {
+		return false, nil
+	}
+	mission.ImageIDs = append(mission.ImageIDs, imageID)
+	return true, nil
+}
+
+func (m missionMap) UnlinkImage(_ context.Context, missionID, imageID string) (bool, error) {
+	mission, ok := m[missionID]
+	if !ok {
+		return false, errMissionNotFound
+	}
+	i := slices.Index(mission.ImageIDs, imageID)
+	if i < 0 {
+		return false, nil
+	}
+	mission.ImageIDs = slices.Delete(mission.ImageIDs, i, i+1)
+	return true, nil
+}
+
+func (m missionMap) SetGeometry(_ context.Context, id string, g MissionGeometry) error {
+	mission, ok := m[id]
+	if !ok {
+		return errMissionNotFound
+	}
+	mission.TCA, mission.MinRangeKM, mission.RelativeVelocityKMS = g.TCA, g.MinRangeKM, g.RelativeVelocityKMS
+	if g.Feasibility != nil {
+		mission.Feasibility = g.Feasibility
+	}
+	mission.GeometryUpdatedAt = time.Now().Unix()
+	mission.UpdatedAt = mission.GeometryUpdatedAt
+	return nil
+}
+
+func (m missionMap) SetSchedule(_ context.Context, id string, s *ScheduleAssignment) error {
+	mission, ok := m[id]
+	if !ok {
+		return errMissionNotFound
+	}
+	mission.Schedule = s
+	mission.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func (m missionMap) SetStatus(_ context.Context, id, status string, allowed func(string) bool) error {
+	mission, ok := m[id]
+	if !ok {
+		return errMissionNotFound
+	}
+	if !allowed(mission.Status) {
+		return errMissionStatus
+	}
+	mission.Status = status
+	mission.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func TestPublishMissionChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		before *Mission
+		want   []string
+	}{
+		{"updated", "In Progress", nil, []string{EventMissionUpdated}},
+		{"completed, unknown before", "Complete", nil, []string{EventMissionUpdated, EventMissionCompleted}},
+		{"completed now", "completed", &Mission{Status: "In Progress"}, []string{EventMissionUpdated, EventMissionCompleted}},
+		{"already completed", "complete", &Mission{Status: "COMPLETE"}, []string{EventMissionUpdated}},
+		{"deleted", "", nil, []string{EventMissionDeleted}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missions := missionMap{}
+			if tt.status != "" {
+				missions["m1"] = &Mission{ID: "m1", Status: tt.status}
+			}
+			api := &API{MissionDB: missions, Events: newEventBus()}
+			events, cancel := api.Events.Subscribe(4, nil)
+			defer cancel()
+			api.publishMissionChange(context.Background(), "m1", tt.before)
+			var got []string
+			for len(events) > 0 {
+				e := <-events
+				if e.MissionID != "m1" {
+					t.Errorf("event %+v is not about m1", e)
+				}
+				got = append(got, e.Type)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("published %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
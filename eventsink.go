@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+const (
+	// eventSource is the EventBridge source of the events published.
+	eventSource     = "sat-image-server"
+	eventSinkBuffer = 256
+	// An event EventBridge accepted the call for but failed on its own is
+	// sent again up to eventSinkAttempts times in all, waiting
+	// eventSinkRetry, doubling, between. Failed calls are retried by the
+	// SDK.
+	eventSinkAttempts = 3
+	eventSinkRetry    = time.Second
+)
+
+// snsPublisher is the part of the SNS client EventSink uses.
+type snsPublisher interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// eventBridgePublisher is the part of the EventBridge client EventSink uses.
+type eventBridgePublisher interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// EventSink republishes the events on the bus to an SNS topic or an
+// EventBridge bus, so other systems can react to them without polling.
+type EventSink struct {
+	arn    string
+	target string
+	fifo   bool
+	topics snsPublisher
+	buses  eventBridgePublisher
+	retry  time.Duration
+}
+
+func newSNSSink(client snsPublisher, topicARN string) *EventSink {
+	return &EventSink{arn: topicARN, target: "sns", fifo: strings.HasSuffix(topicARN, ".fifo"), topics: client, retry: eventSinkRetry}
+}
+
+func newEventBridgeSink(client eventBridgePublisher, busARN string) *EventSink {
+	return &EventSink{arn: busARN, target: "eventbridge", buses: client, retry: eventSinkRetry}
+}
+
+// eventsTarget returns which service an EVENTS_ARN names, sns or
+// eventbridge.
+func eventsTarget(s string) (string, error) {
+	a, err := arn.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("EVENTS_ARN %q is not an ARN", s)
+	}
+	switch {
+	case a.Service == "sns" && a.Resource != "":
+		return "sns", nil
+	case a.Service == "events" && strings.HasPrefix(a.Resource, "event-bus/"):
+		return "eventbridge", nil
+	}
+	return "", fmt.Errorf("EVENTS_ARN %q is not an SNS topic or EventBridge bus", s)
+}
+
+// Start publishes the events on bus, in order, until ctx is done.
+func (s *EventSink) Start(ctx context.Context, bus *EventBus) {
+	go consumeEvents(ctx, bus, eventSinkBuffer, "event publisher", func(e Event) { s.publish(ctx, e) })
+}
+
+func (s *EventSink) publish(ctx context.Context, e Event) {
+	body, err := json.Marshal(e)
+	if err == nil {
+		if s.topics != nil {
+			err = s.publishSNS(ctx, e, body)
+		} else {
+			err = s.publishEventBridge(ctx, e, body)
+		}
+	}
+	result := "published"
+	if err != nil {
+		result = "failed"
+		slog.ErrorContext(ctx, "failed to publish event", "id", e.ID, "type", e.Type, "target", s.arn, "err", err)
+	}
+	eventsPublished.WithLabelValues(s.target, result).Inc()
+}
+
+// publishSNS sends the event as the message, with its type and subject as
+// attributes for subscription filter policies. On a FIFO topic the events of
+// one mission or image are kept in order, and an event every instance
+// publishes for the same change is delivered once.
+func (s *EventSink) publishSNS(ctx context.Context, e Event, body []byte) error {
+	attrs := map[string]snstypes.MessageAttributeValue{
+		"event_type": {DataType: aws.String("String"), StringValue: aws.String(e.Type)},
+	}
+	if e.MissionID != "" {
+		attrs["mission_id"] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(e.MissionID)}
+	}
+	if e.ImageID != "" {
+		attrs["image_id"] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(e.ImageID)}
+	}
+	in := &sns.PublishInput{
+		TopicArn:          aws.String(s.arn),
+		Message:           aws.String(string(body)),
+		MessageAttributes: attrs,
+	}
+	if s.fifo {
+		group := e.MissionID
+		if group == "" {
+			group = e.ImageID
+		}
+		if group == "" {
+			group = e.Type
+		}
+		dedup := strconv.FormatUint(e.ID, 10)
+		if e.SourceID != "" {
+			dedup = e.Type + "-" + e.SourceID
+		}
+		in.MessageGroupId = aws.String(group)
+		in.MessageDeduplicationId = aws.String(dedup)
+	}
+	_, err := s.topics.Publish(ctx, in)
+	return err
+}
+
+// publishEventBridge sends the event as the detail of an event whose detail
+// type is the event type.
+func (s *EventSink) publishEventBridge(ctx context.Context, e Event, body []byte) error {
+	in := &eventbridge.PutEventsInput{Entries: []ebtypes.PutEventsRequestEntry{{
+		EventBusName: aws.String(s.arn),
+		Source:       aws.String(eventSource),
+		DetailType:   aws.String(e.Type),
+		Detail:       aws.String(string(body)),
+		Time:         aws.Time(time.Unix(e.Time, 0)),
+	}}}
+	wait := s.retry
+	for attempt := 1; ; attempt++ {
+		out, err := s.buses.PutEvents(ctx, in)
+		if err != nil {
+			return err
+		}
+		if out.FailedEntryCount == 0 {
+			return nil
+		}
+		err = errors.New("event rejected")
+		if len(out.Entries) > 0 && out.Entries[0].ErrorCode != nil {
+			err = fmt.Errorf("event rejected: %s: %s", aws.ToString(out.Entries[0].ErrorCode), aws.ToString(out.Entries[0].ErrorMessage))
+		}
+		if attempt == eventSinkAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
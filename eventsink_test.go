@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+type fakeTopic struct{ published []*sns.PublishInput }
+
+func (f *fakeTopic) Publish(ctx context.Context, in *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.published = append(f.published, in)
+	return &sns.PublishOutput{}, nil
+}
+
+// fakeBus rejects the first rejections events it is sent.
+type fakeBus struct {
+	rejections int
+	puts       []*eventbridge.PutEventsInput
+}
+
+func (f *fakeBus) PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.puts = append(f.puts, in)
+	if len(f.puts) <= f.rejections {
+		return &eventbridge.PutEventsOutput{FailedEntryCount: 1, Entries: []ebtypes.PutEventsResultEntry{{
+			ErrorCode: aws.String("ThrottlingException"), ErrorMessage: aws.String("Rate exceeded"),
+		}}}, nil
+	}
+	return &eventbridge.PutEventsOutput{Entries: []ebtypes.PutEventsResultEntry{{EventId: aws.String("e1")}}}, nil
+}
+
+func TestEventsTarget(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{"arn:aws:sns:us-east-1:123456789012:mission-events", "sns"},
+		{"arn:aws:sns:us-east-1:123456789012:mission-events.fifo", "sns"},
+		{"arn:aws:events:us-east-1:123456789012:event-bus/ground-segment", "eventbridge"},
+		{"arn:aws:events:us-east-1:123456789012:rule/ground-segment", ""},
+		{"arn:aws:sqs:us-east-1:123456789012:mission-events", ""},
+		{"mission-events", ""},
+	}
+	for _, tt := range tests {
+		got, err := eventsTarget(tt.arn)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("eventsTarget(%q) = %q, %v, want %q", tt.arn, got, err, tt.want)
+		}
+	}
+}
+
+func TestEventSinkSNS(t *testing.T) {
+	ctx := context.Background()
+	mission := Event{ID: 7, Type: EventMissionCompleted, Time: 1791936000, MissionID: "m1", SourceID: "abc123", Mission: &Mission{ID: "m1", Status: "complete"}}
+	image := Event{ID: 8, Type: EventImageIngested, Time: 1791936000, ImageID: "i1"}
+
+	topic := &fakeTopic{}
+	sink := newSNSSink(topic, "arn:aws:sns:us-east-1:123456789012:mission-events")
+	sink.publish(ctx, mission)
+	in := topic.published[0]
+	var got Event
+	if err := json.Unmarshal([]byte(aws.ToString(in.Message)), &got); err != nil || got.ID != mission.ID || got.Mission == nil {
+		t.Errorf("message = %s, err %v", aws.ToString(in.Message), err)
+	}
+	if aws.ToString(in.MessageAttributes["event_type"].StringValue) != EventMissionCompleted ||
+		aws.ToString(in.MessageAttributes["mission_id"].StringValue) != "m1" {
+		t.Errorf("attributes = %+v", in.MessageAttributes)
+	}
+	if _, ok := in.MessageAttributes["image_id"]; ok || in.MessageGroupId != nil || in.MessageDeduplicationId != nil {
+		t.Errorf("standard topic message = %+v", in)
+	}
+
+	topic = &fakeTopic{}
+	sink = newSNSSink(topic, "arn:aws:sns:us-east-1:123456789012:mission-events.fifo")
+	sink.publish(ctx, mission)
+	sink.publish(ctx, image)
+	for i, want := range [][2]string{{"m1", "mission.completed-abc123"}, {"i1", "8"}} {
+		in := topic.published[i]
+		if aws.ToString(in.MessageGroupId) != want[0] || aws.ToString(in.MessageDeduplicationId) != want[1] {
+			t.Errorf("message %d: group %q, deduplication %q, want %q", i, aws.ToString(in.MessageGroupId), aws.ToString(in.MessageDeduplicationId), want)
+		}
+	}
+}
+
+func TestEventSinkEventBridge(t *testing.T) {
+	ctx := context.Background()
+	e := Event{ID: 7, Type: EventMissionUpdated, Time: 1791936000, MissionID: "m1"}
+	busARN := "arn:aws:events:us-east-1:123456789012:event-bus/ground-segment"
+	tests := []struct {
+		name       string
+		rejections int
+		puts       int
+	}{
+		{"accepted", 0, 1},
+		{"retried", 1, 2},
+		{"rejected", eventSinkAttempts, eventSinkAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := &fakeBus{rejections: tt.rejections}
+			sink := newEventBridgeSink(bus, busARN)
+			sink.retry = time.Millisecond
+			sink.publish(ctx, e)
+			if len(bus.puts) != tt.puts {
+				t.Fatalf("PutEvents called %d times, want %d", len(bus.puts), tt.puts)
+			}
+			entry := bus.puts[0].Entries[0]
+			if aws.ToString(entry.EventBusName) != busARN || aws.ToString(entry.Source) != eventSource ||
+				aws.ToString(entry.DetailType) != EventMissionUpdated || !entry.Time.Equal(time.Unix(e.Time, 0)) {
+				t.Errorf("entry = %+v", entry)
+			}
+			var detail Event
+			if err := json.Unmarshal([]byte(aws.ToString(entry.Detail)), &detail); err != nil || detail.MissionID != "m1" {
+				t.Errorf("detail = %s, err %v", aws.ToString(entry.Detail), err)
+			}
+		})
+	}
+}
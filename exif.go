@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Pointer tags from IFD0 to the Exif and GPS sub-IFDs.
+const (
+	tagExifIFD = 34665
+	tagGPSIFD  = 34853
+)
+
+// maxEXIFValueLen truncates long ASCII values such as descriptions.
+const maxEXIFValueLen = 1024
+
+var errNoEXIF = errors.New("no EXIF block")
+
+// exifIFD0Tags are the descriptive IFD0 tags reported by the metadata
+// endpoint. Structural tags (strip layout, compression and the like) and the
+// GeoTIFF tags, which are reported separately, are left out.
+var exifIFD0Tags = map[uint16]string{
+	269:   "DocumentName",
+	270:   "ImageDescription",
+	271:   "Make",
+	272:   "Model",
+	274:   "Orientation",
+	282:   "XResolution",
+	283:   "YResolution",
+	285:   "PageName",
+	296:   "ResolutionUnit",
+	305:   "Software",
+	306:   "DateTime",
+	315:   "Artist",
+	316:   "HostComputer",
+	33432: "Copyright",
+}
+
+var exifSubIFDTags = map[uint16]string{
+	33434: "ExposureTime",
+	33437: "FNumber",
+	34850: "ExposureProgram",
+	34855: "ISOSpeedRatings",
+	36864: "ExifVersion",
+	36867: "DateTimeOriginal",
+	36868: "DateTimeDigitized",
+	36880: "OffsetTime",
+	36881: "OffsetTimeOriginal",
+	37377: "ShutterSpeedValue",
+	37378: "ApertureValue",
+	37380: "ExposureBiasValue",
+	37383: "MeteringMode",
+	37385: "Flash",
+	37386: "FocalLength",
+	37520: "SubSecTime",
+	37521: "SubSecTimeOriginal",
+	40961: "ColorSpace",
+	40962: "PixelXDimension",
+	40963: "PixelYDimension",
+	41486: "FocalPlaneXResolution",
+	41487: "FocalPlaneYResolution",
+	41488: "FocalPlaneResolutionUnit",
+	42016: "ImageUniqueID",
+	42033: "BodySerialNumber",
+	42035: "LensMake",
+	42036: "LensModel",
+	42037: "LensSerialNumber",
+}
+
+var exifGPSTags = map[uint16]string{
+	0:  "GPSVersionID",
+	1:  "GPSLatitudeRef",
+	2:  "GPSLatitude",
+	3:  "GPSLongitudeRef",
+	4:  "GPSLongitude",
+	5:  "GPSAltitudeRef",
+	6:  "GPSAltitude",
+	7:  "GPSTimeStamp",
+	29: "GPSDateStamp",
+}
+
+// parseEXIF returns the descriptive EXIF/TIFF tags embedded in a JPEG, PNG,
+// WebP or TIFF file, keyed by tag name. GPS latitude and longitude are
+// converted to signed decimal degrees.
+func parseEXIF(data []byte) (map[string]string, error) {
+	block, err := exifBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(block)
+	order, off, err := readTIFFHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, _, err := readIFD(r, order, off)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	collectEXIF(tags, order, ifd0, exifIFD0Tags)
+	for _, e := range ifd0 {
+		var names map[uint16]string
+		switch e.tag {
+		case tagExifIFD:
+			names = exifSubIFDTags
+		case tagGPSIFD:
+			names = exifGPSTags
+		default:
+			continue
+		}
+		ptr := entryUints(order, e)
+		if len(ptr) != 1 {
+			continue
+		}
+		sub, _, err := readIFD(r, order, int64(ptr[0]))
+		if err != nil {
+			return tags, err
+		}
+		collectEXIF(tags, order, sub, names)
+		if e.tag == tagGPSIFD {
+			resolveGPS(tags, order, sub)
+		}
+	}
+	return tags, nil
+}
+
+// exifBlock locates the TIFF-structured EXIF data inside a container. TIFF
+// files are their own EXIF block.
+func exifBlock(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return data, nil
+
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		var block []byte
+		_, err := walkJPEGSegments(data, func(marker byte, segment []byte) bool {
+			if marker == 0xe1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+				block = segment[10:]
+				return false
+			}
+			return true
+		})
+		if block != nil {
+			return block, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	case bytes.HasPrefix(data, pngSignature):
+		for i := len(pngSignature); i+12 <= len(data); {
+			n := int(binary.BigEndian.Uint32(data[i:]))
+			if i+12+n > len(data) {
+				break
+			}
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : i+8+n], nil
+			}
+			i += 12 + n
+		}
+
+	case isWebP(data):
+		for i := 12; i+8 <= len(data); {
+			n := int(binary.LittleEndian.Uint32(data[i+4:]))
+			if i+8+n > len(data) {
+				break
+			}
+			if string(data[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(data[i+8:i+8+n], []byte("Exif\x00\x00")), nil
+			}
+			i += 8 + n + n%2
+		}
+	}
+	return nil, errNoEXIF
+}
+
+func collectEXIF(tags map[string]string, order binary.ByteOrder, entries []tiffEntry, names map[uint16]string) {
+	for _, e := range entries {
+		name, ok := names[e.tag]
+		if !ok {
+			continue
+		}
+		if v := formatEXIFValue(order, e); v != "" {
+			tags[name] = v
+		}
+	}
+}
+
+// formatEXIFValue renders an entry as text. Multi-valued entries are joined
+// with spaces; opaque binary values are dropped.
+func formatEXIFValue(order binary.ByteOrder, e tiffEntry) string {
+	if e.datatype == tiffASCII || e.datatype == 7 {
+		s := strings.TrimRight(string(e.value), "\x00 ")
+		for _, r := range s {
+			if r < 0x20 || r > 0x7e {
+				return ""
+			}
+		}
+		if len(s) > maxEXIFValueLen {
+			s = s[:maxEXIFValueLen]
+		}
+		return s
+	}
+
+	size := tiffTypeSize(e.datatype)
+	if size == 0 {
+		return ""
+	}
+	var parts []string
+	for i := 0; i+size <= len(e.value); i += size {
+		v := e.value[i : i+size]
+		switch e.datatype {
+		case 1:
+			parts = append(parts, strconv.Itoa(int(v[0])))
+		case tiffShort:
+			parts = append(parts, strconv.Itoa(int(order.Uint16(v))))
+		case tiffLong:
+			parts = append(parts, strconv.FormatUint(uint64(order.Uint32(v)), 10))
+		case 8:
+			parts = append(parts, strconv.Itoa(int(int16(order.Uint16(v)))))
+		case 9:
+			parts = append(parts, strconv.Itoa(int(int32(order.Uint32(v)))))
+		case 5, 10:
+			num, den := float64(order.Uint32(v)), float64(order.Uint32(v[4:]))
+			if e.datatype == 10 {
+				num, den = float64(int32(order.Uint32(v))), float64(int32(order.Uint32(v[4:])))
+			}
+			if den == 0 {
+				return ""
+			}
+			parts = append(parts, strconv.FormatFloat(num/den, 'g', 8, 64))
+		case 11:
+			parts = append(parts, strconv.FormatFloat(float64(math.Float32frombits(order.Uint32(v))), 'g', 8, 32))
+		case tiffDouble:
+			parts = append(parts, strconv.FormatFloat(math.Float64frombits(order.Uint64(v)), 'g', 10, 64))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// resolveGPS replaces the degrees/minutes/seconds GPS position with signed
+// decimal degrees.
+func resolveGPS(tags map[string]string, order binary.ByteOrder, entries []tiffEntry) {
+	dms := make(map[uint16][]float64)
+	for _, e := range entries {
+		if (e.tag == 2 || e.tag == 4) && e.datatype == 5 && len(e.value) == 24 {
+			var v []float64
+			for i := 0; i < 24; i += 8 {
+				den := float64(order.Uint32(e.value[i+4:]))
+				if den == 0 {
+					break
+				}
+				v = append(v, float64(order.Uint32(e.value[i:]))/den)
+			}
+			if len(v) == 3 {
+				dms[e.tag] = v
+			}
+		}
+	}
+	for tag, ref := range map[uint16]string{2: "GPSLatitudeRef", 4: "GPSLongitudeRef"} {
+		v, ok := dms[tag]
+		if !ok {
+			continue
+		}
+		deg := v[0] + v[1]/60 + v[2]/3600
+		if r := tags[ref]; r == "S" || r == "W" {
+			deg = -deg
+		}
+		tags[exifGPSTags[tag]] = strconv.FormatFloat(math.Round(deg*1e7)/1e7, 'f', -1, 64)
+	}
+}
+
+// recordEXIF stores the file's embedded tags on the image record. Files
+// without an EXIF block are left alone.
+func (api *API) recordEXIF(ctx context.Context, id string, data []byte) error {
+	tags, err := parseEXIF(data)
+	if errors.Is(err, errNoEXIF) || (err == nil && len(tags) == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return api.Images.SetImageAttribute(ctx, id, "exif", tags)
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// walkJPEGSegments calls fn with each marker segment before the start of
+// scan, including its marker and length bytes, stopping early if fn returns
+// false. It returns the offset of the start-of-scan marker.
+func walkJPEGSegments(data []byte, fn func(marker byte, segment []byte) bool) (int, error) {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 0, errors.New("jpeg: malformed marker")
+		}
+		marker := data[i+1]
+		n := 2
+		switch {
+		case marker == 0xff:
+			i++
+			continue
+		case marker == 0xda:
+			return i, nil
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+		default:
+			n += int(binary.BigEndian.Uint16(data[i+2:]))
+			if n < 4 || i+n > len(data) {
+				return 0, errors.New("jpeg: segment truncated")
+			}
+		}
+		if !fn(marker, data[i:i+n]) {
+			return i, nil
+		}
+		i += n
+	}
+	return 0, errors.New("jpeg: no start of scan")
+}
+
+// stripMetadata removes embedded tags from a JPEG, PNG or WebP without
+// re-encoding the pixels. JPEG keeps only its JFIF header, ICC profile and
+// Adobe colour-transform segments; PNG drops text, time and eXIf chunks;
+// WebP drops its EXIF and XMP chunks. It returns ok=false for containers it
+// cannot edit in place (TIFF, AVIF) or cannot parse.
+func stripMetadata(data []byte) (out []byte, ok bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNG(data)
+	case isWebP(data):
+		return stripWebP(data)
+	}
+	return nil, false
+}
+
+// stripDownload strips data in place where the container allows it. For other
+// containers it returns a nil slice and the format to re-encode the image in
+// instead; our encoders write no descriptive tags.
+func stripDownload(data []byte) ([]byte, *OutputFormat, error) {
+	if stripped, ok := stripMetadata(data); ok {
+		return stripped, nil, nil
+	}
+	_, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	format, ok := lookupFormat(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("no encoder for %s", name)
+	}
+	return nil, format, nil
+}
+
+func stripJPEG(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	sos, err := walkJPEGSegments(data, func(marker byte, segment []byte) bool {
+		if keepJPEGSegment(marker, segment[min(4, len(segment)):]) {
+			out = append(out, segment...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false
+	}
+	return append(out, data[sos:]...), true
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xfe:
+		return false
+	case marker == 0xe0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case marker == 0xe2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xee:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xe1 && marker <= 0xef:
+		return false
+	}
+	return true
+}
+
+func stripPNG(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if end > len(data) {
+			return nil, false
+		}
+		switch string(data[i+4 : i+8]) {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, true
+}
+
+func stripWebP(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, false
+		}
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + n + n%2
+		if end > len(data) {
+			return nil, false
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if n > 0 {
+				// Clear the EXIF and XMP presence flags.
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, true
+}
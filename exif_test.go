@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	v := append([]byte(s), 0)
+	return tiffEntry{tag: tag, datatype: tiffASCII, count: uint32(len(v)), value: v}
+}
+
+func rationalEntry(order binary.ByteOrder, tag uint16, pairs ...uint32) tiffEntry {
+	e := tiffEntry{tag: tag, datatype: 5, count: uint32(len(pairs) / 2), value: make([]byte, 4*len(pairs))}
+	for i, v := range pairs {
+		order.PutUint32(e.value[i*4:], v)
+	}
+	return e
+}
+
+func shortEntry(order binary.ByteOrder, tag uint16, v uint16) tiffEntry {
+	e := tiffEntry{tag: tag, datatype: tiffShort, count: 1, value: make([]byte, 2)}
+	order.PutUint16(e.value, v)
+	return e
+}
+
+// testEXIFBlock builds a TIFF-structured EXIF block with an Exif and a GPS
+// sub-IFD, placed ahead of IFD0 so IFD0 can point back at them.
+func testEXIFBlock(order binary.ByteOrder) []byte {
+	var out bytes.Buffer
+	out.Write(make([]byte, 8))
+	exifIFD := appendIFD(&out, order, []tiffEntry{
+		rationalEntry(order, 33434, 1, 250), // ExposureTime
+		asciiEntry(36867, "2024:05:01 10:20:30"),
+	})
+	gpsIFD := appendIFD(&out, order, []tiffEntry{
+		asciiEntry(1, "S"),
+		rationalEntry(order, 2, 12, 1, 30, 1, 0, 1),
+		asciiEntry(3, "W"),
+		rationalEntry(order, 4, 45, 1, 15, 1, 36, 1),
+	})
+	ifd0 := appendIFD(&out, order, []tiffEntry{
+		asciiEntry(271, "Acme Orbital"),
+		asciiEntry(272, "Eye-1"),
+		shortEntry(order, 274, 1),
+		longEntry(order, tagImageWidth, 640), // structural, not reported
+		longEntry(order, tagExifIFD, uint32(exifIFD)),
+		longEntry(order, tagGPSIFD, uint32(gpsIFD)),
+	})
+	data := out.Bytes()
+	if order == binary.LittleEndian {
+		copy(data, "II*\x00")
+	} else {
+		copy(data, "MM\x00*")
+	}
+	order.PutUint32(data[4:], uint32(ifd0))
+	return data
+}
+
+func jpegWithAPP1(payload []byte) []byte {
+	data := []byte{0xff, 0xd8, 0xff, 0xe0, 0, 16}
+	data = append(data, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"...)
+	if payload != nil {
+		data = append(data, 0xff, 0xe1)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(payload)+2))
+		data = append(data, payload...)
+	}
+	return append(data, 0xff, 0xda, 0, 2, 0xff, 0xd9)
+}
+
+func pngWithChunk(typ string, payload []byte) []byte {
+	data := append([]byte(nil), pngSignature...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+	chunk := append([]byte(typ), payload...)
+	data = append(data, chunk...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(chunk))
+}
+
+func webpWithChunk(fourcc string, payload []byte) []byte {
+	body := []byte("WEBP")
+	body = append(body, fourcc...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(payload)))
+	body = append(body, payload...)
+	if len(payload)%2 == 1 {
+		body = append(body, 0)
+	}
+	data := []byte("RIFF")
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(body)))
+	return append(data, body...)
+}
+
+func TestParseEXIF(t *testing.T) {
+	le := testEXIFBlock(binary.LittleEndian)
+	want := map[string]string{
+		"Make":             "Acme Orbital",
+		"Model":            "Eye-1",
+		"Orientation":      "1",
+		"ExposureTime":     "0.004",
+		"DateTimeOriginal": "2024:05:01 10:20:30",
+		"GPSLatitudeRef":   "S",
+		"GPSLatitude":      "-12.5",
+		"GPSLongitudeRef":  "W",
+		"GPSLongitude":     "-45.26",
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"tiff little-endian", le},
+		{"tiff big-endian", testEXIFBlock(binary.BigEndian)},
+		{"jpeg", jpegWithAPP1(append([]byte("Exif\x00\x00"), le...))},
+		{"png", pngWithChunk("eXIf", le)},
+		{"webp", webpWithChunk("EXIF", append([]byte("Exif\x00\x00"), le...))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEXIF(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range want {
+				if got[k] != v {
+					t.Errorf("%s = %q, want %q", k, got[k], v)
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("got %d tags %v, want %d", len(got), got, len(want))
+			}
+		})
+	}
+}
+
+func TestParseEXIFMissing(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"jpeg without APP1": jpegWithAPP1(nil),
+		"png without eXIf":  pngWithChunk("IDAT", []byte{1, 2, 3}),
+		"unknown container": []byte("GIF89a"),
+	} {
+		if _, err := parseEXIF(data); !errors.Is(err, errNoEXIF) {
+			t.Errorf("%s: error = %v, want errNoEXIF", name, err)
+		}
+	}
+}
+
+func TestParseEXIFCorrupt(t *testing.T) {
+	block := testEXIFBlock(binary.LittleEndian)
+	// Point IFD0 past the end of the block.
+	binary.LittleEndian.PutUint32(block[4:], uint32(len(block)+100))
+	if _, err := parseEXIF(block); err == nil {
+		t.Error("IFD offset past the end parsed")
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	data := jpegWithAPP1(append([]byte("Exif\x00\x00"), testEXIFBlock(binary.LittleEndian)...))
+	stripped, ok := stripMetadata(data)
+	if !ok {
+		t.Fatal("strip failed")
+	}
+	if _, err := parseEXIF(stripped); !errors.Is(err, errNoEXIF) {
+		t.Errorf("EXIF survived stripping: %v", err)
+	}
+	if !bytes.Contains(stripped, []byte("JFIF\x00")) {
+		t.Error("JFIF header was dropped")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Feasibility scores whether the observer's sensor can make a mission's
+// collection at TCA.
+type Feasibility struct {
+	// Score runs from 0, unusable, to 1, the target lit face-on from
+	// nearby. It is the product of a range factor, falling linearly to 0 at
+	// the maximum range, and a lighting factor, the lit fraction of the
+	// target's disc seen at PhaseAngleDeg, or 0 in Earth's shadow.
+	Score float64 `dynamodbav:"score" json:"score"`
+	// Feasible is false when any constraint fails; Issues says which.
+	Feasible bool     `dynamodbav:"feasible" json:"feasible"`
+	Issues   []string `dynamodbav:"issues,omitempty" json:"issues,omitempty"`
+	// PhaseAngleDeg is the Sun-target-observer angle: 0 with the Sun
+	// behind the observer.
+	PhaseAngleDeg    float64 `dynamodbav:"phase_angle_deg" json:"phase_angle_deg"`
+	TargetSunlit     bool    `dynamodbav:"target_sunlit" json:"target_sunlit"`
+	ObserverEclipsed bool    `dynamodbav:"observer_eclipsed" json:"observer_eclipsed"`
+}
+
+// assessFeasibility scores the collection at t against k. When k does not
+// require a sunlit target, lighting does not count toward the score.
+func assessFeasibility(pair orbitPair, k AccessConstraints, t time.Time) (Feasibility, error) {
+	o, err := pair.observer.At(t)
+	if err != nil {
+		return Feasibility{}, fmt.Errorf("observer at %s: %w", t.Format(time.RFC3339), err)
+	}
+	tg, err := pair.target.At(t)
+	if err != nil {
+		return Feasibility{}, fmt.Errorf("target at %s: %w", t.Format(time.RFC3339), err)
+	}
+	observer, target, sun := o.Position, tg.Position, sunPosition(t)
+	phase := angleDeg(sub(sun, target), sub(observer, target))
+	f := Feasibility{
+		PhaseAngleDeg:    math.Round(phase*100) / 100,
+		TargetSunlit:     sunlit(target, sun),
+		ObserverEclipsed: !sunlit(observer, sun),
+	}
+
+	rng := norm(sub(target, observer))
+	if rng > k.MaxRangeKM {
+		f.Issues = append(f.Issues, fmt.Sprintf("the range at TCA is %.1f km, beyond %g km", rng, k.MaxRangeKM))
+	}
+	if occulted(observer, target) {
+		f.Issues = append(f.Issues, "the Earth blocks the line of sight at TCA")
+	}
+	if angle := sunAngle(observer, target, sun); !f.ObserverEclipsed && angle < k.MinSunAngleDeg {
+		f.Issues = append(f.Issues, fmt.Sprintf("the target is %.1f° from the Sun as seen from the observer, under %g°", angle, k.MinSunAngleDeg))
+	}
+	if k.TargetSunlit && !f.TargetSunlit {
+		f.Issues = append(f.Issues, "the target is in Earth's shadow at TCA")
+	}
+	if k.ObserverEclipsed && !f.ObserverEclipsed {
+		f.Issues = append(f.Issues, "the observer is sunlit at TCA")
+	}
+	f.Feasible = len(f.Issues) == 0
+
+	score := math.Max(0, 1-rng/k.MaxRangeKM)
+	if k.TargetSunlit {
+		lit := (1 + math.Cos(phase*math.Pi/180)) / 2
+		if !f.TargetSunlit {
+			lit = 0
+		}
+		score *= lit
+	}
+	f.Score = math.Round(score*1000) / 1000
+	return f, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssessFeasibility(t *testing.T) {
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	target, _ := newSGP4(iss)
+	observer, _ := newSGP4(shiftedISS(t))
+	pair := orbitPair{target: target, observer: observer}
+	k := AccessConstraints{MaxRangeKM: 300, MinSunAngleDeg: 30, TargetSunlit: true}
+
+	// The closest approach of the first access window.
+	tca := time.Unix(1221916200, 0).UTC()
+	f, err := assessFeasibility(pair, k, tca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Feasible || len(f.Issues) != 0 || !f.TargetSunlit || f.Score <= 0 || f.Score > 1 {
+		t.Errorf("at TCA %+v", f)
+	}
+
+	// Out of range scores 0.
+	near := k
+	near.MaxRangeKM = 50
+	f, _ = assessFeasibility(pair, near, tca)
+	if f.Feasible || f.Score != 0 || len(f.Issues) != 1 || !strings.Contains(f.Issues[0], "beyond 50 km") {
+		t.Errorf("out of range %+v", f)
+	}
+
+	// In Earth's shadow the target cannot be seen, unless a lit target is
+	// not required.
+	dark := iss.Epoch
+	for ; dark.Before(iss.Epoch.Add(2 * time.Hour)); dark = dark.Add(time.Minute) {
+		s, _ := target.At(dark)
+		if !sunlit(s.Position, sunPosition(dark)) {
+			break
+		}
+	}
+	f, _ = assessFeasibility(pair, k, dark)
+	if f.Feasible || f.TargetSunlit || f.Score != 0 || !strings.Contains(strings.Join(f.Issues, ";"), "shadow") {
+		t.Errorf("in shadow %+v", f)
+	}
+	unlit := k
+	unlit.TargetSunlit = false
+	if f, _ = assessFeasibility(pair, unlit, dark); f.Score == 0 || strings.Contains(strings.Join(f.Issues, ";"), "shadow") {
+		t.Errorf("in shadow without target_sunlit %+v", f)
+	}
+}
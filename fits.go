@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// FITS files are 2880-byte blocks. The header is a block or more of 80-byte
+// keyword cards, ending with END, and the data array follows in the next
+// block.
+const (
+	fitsBlock       = 2880
+	fitsCard        = 80
+	fitsMagic       = "SIMPLE  ="
+	fitsContentType = "application/fits"
+	// fitsMaxHeader bounds the header read, in blocks.
+	fitsMaxHeader = 64
+	// fitsScaleSamples is how many samples percentile stretches are taken
+	// from, evenly spread over the data.
+	fitsScaleSamples = 1 << 20
+	// fitsChunk is the size the data is read in, a multiple of every
+	// sample size, so that a truncated file costs only what it holds.
+	fitsChunk = 1 << 20
+)
+
+// defaultFITSScale stretches FITS data to a displayable range when
+// FITS_SCALE is unset: a faint sky and a few saturated stars would
+// otherwise leave a min-max stretch almost black.
+var defaultFITSScale = RadiometricScale{Mode: "percentile", Lo: 0.5, Hi: 99.5}
+
+// fitsScale is the stretch FITS data is decoded with. FITS_SCALE takes the
+// values of the scale parameter, with fixed bounds in the data's physical
+// units; configureDecoders sets it at startup.
+var fitsScale = defaultFITSScale
+
+var errNoFITS = errors.New("not a FITS file")
+
+func init() {
+	image.RegisterFormat("fits", fitsMagic, decodeFITS, decodeFITSConfig)
+}
+
+func isFITS(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(fitsMagic))
+}
+
+// fitsHeader is the primary header of a FITS file.
+type fitsHeader struct {
+	// Cards are the keywords and their values, with strings unquoted.
+	// COMMENT, HISTORY and blank cards are left out.
+	Cards  map[string]string
+	BitPix int
+	// Axes are NAXIS1 (the width), NAXIS2 (the height) and any more.
+	Axes     []int
+	BZero    float64
+	BScale   float64
+	Blank    int64
+	HasBlank bool
+}
+
+// readFITSHeader reads the primary header from r, leaving r at the data.
+func readFITSHeader(r io.Reader) (*fitsHeader, error) {
+	h := &fitsHeader{Cards: map[string]string{}, BScale: 1}
+	block := make([]byte, fitsBlock)
+	for n := 0; ; n++ {
+		if n == fitsMaxHeader {
+			return nil, fmt.Errorf("FITS header longer than %d blocks", fitsMaxHeader)
+		}
+		if _, err := io.ReadFull(r, block); err != nil {
+			if n == 0 {
+				return nil, errNoFITS
+			}
+			return nil, fmt.Errorf("read FITS header: %w", err)
+		}
+		if n == 0 && !isFITS(block) {
+			return nil, errNoFITS
+		}
+		for i := 0; i < fitsBlock; i += fitsCard {
+			keyword, value, ok := parseFITSCard(block[i : i+fitsCard])
+			if keyword == "END" {
+				return h, h.parse()
+			}
+			if ok {
+				h.Cards[keyword] = value
+			}
+		}
+	}
+}
+
+// parseFITSCard returns a card's keyword and, for a keyword with a value,
+// the value without its comment.
+func parseFITSCard(card []byte) (keyword, value string, ok bool) {
+	keyword = strings.TrimSpace(string(card[:8]))
+	if keyword == "" || keyword == "COMMENT" || keyword == "HISTORY" || string(card[8:10]) != "= " {
+		return keyword, "", false
+	}
+	v := strings.TrimSpace(string(card[10:]))
+	if strings.HasPrefix(v, "'") {
+		// Quotes within a string are doubled; trailing spaces are not
+		// significant.
+		var s strings.Builder
+		for i := 1; i < len(v); i++ {
+			if v[i] == '\'' {
+				if i+1 < len(v) && v[i+1] == '\'' {
+					s.WriteByte('\'')
+					i++
+					continue
+				}
+				break
+			}
+			s.WriteByte(v[i])
+		}
+		return keyword, strings.TrimRight(s.String(), " "), true
+	}
+	if i := strings.IndexByte(v, '/'); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return keyword, v, true
+}
+
+// parse reads the structural keywords from the cards.
+func (h *fitsHeader) parse() error {
+	if h.Cards["SIMPLE"] != "T" {
+		return errors.New("FITS file does not conform to the standard")
+	}
+	var err error
+	if h.BitPix, err = strconv.Atoi(h.Cards["BITPIX"]); err != nil || !slices.Contains([]int{8, 16, 32, 64, -32, -64}, h.BitPix) {
+		return fmt.Errorf("unsupported FITS BITPIX %q", h.Cards["BITPIX"])
+	}
+	naxis, err := strconv.Atoi(h.Cards["NAXIS"])
+	if err != nil || naxis < 2 || naxis > 999 {
+		return fmt.Errorf("FITS data with NAXIS %q is not an image", h.Cards["NAXIS"])
+	}
+	for i := 1; i <= naxis; i++ {
+		n, err := strconv.Atoi(h.Cards[fmt.Sprintf("NAXIS%d", i)])
+		if err != nil || n < 1 || n > math.MaxInt32 {
+			return fmt.Errorf("invalid FITS NAXIS%d %q", i, h.Cards[fmt.Sprintf("NAXIS%d", i)])
+		}
+		h.Axes = append(h.Axes, n)
+	}
+	if v, ok := h.Cards["BZERO"]; ok {
+		if h.BZero, err = parseFITSFloat(v); err != nil {
+			return fmt.Errorf("invalid FITS BZERO %q", v)
+		}
+	}
+	if v, ok := h.Cards["BSCALE"]; ok {
+		if h.BScale, err = parseFITSFloat(v); err != nil {
+			return fmt.Errorf("invalid FITS BSCALE %q", v)
+		}
+	}
+	if v, ok := h.Cards["BLANK"]; ok && h.BitPix > 0 {
+		if h.Blank, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid FITS BLANK %q", v)
+		}
+		h.HasBlank = true
+	}
+	return nil
+}
+
+// parseFITSFloat parses a FITS real, whose exponent may be written with D.
+func parseFITSFloat(v string) (float64, error) {
+	return strconv.ParseFloat(strings.NewReplacer("D", "E", "d", "e").Replace(v), 64)
+}
+
+// planes is how many planes of the data are decoded: three for a colour
+// image, with NAXIS3 = 3, and otherwise only the first.
+func (h *fitsHeader) planes() int {
+	if len(h.Axes) >= 3 && h.Axes[2] == 3 {
+		return 3
+	}
+	return 1
+}
+
+// dataBytes is the size of the planes decoded, without any further planes
+// of the data.
+func (h *fitsHeader) dataBytes() int64 {
+	return int64(h.Axes[0]) * int64(h.Axes[1]) * int64(h.planes()) * int64(max(h.BitPix, -h.BitPix)/8)
+}
+
+func (h *fitsHeader) config() image.Config {
+	cfg := image.Config{ColorModel: color.Gray16Model, Width: h.Axes[0], Height: h.Axes[1]}
+	if h.planes() == 3 {
+		cfg.ColorModel = color.NRGBA64Model
+	}
+	return cfg
+}
+
+// captureTime is DATE-OBS, when the observation started, in UTC.
+func (h *fitsHeader) captureTime() (time.Time, bool) {
+	v := h.Cards["DATE-OBS"]
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func decodeFITSConfig(r io.Reader) (image.Config, error) {
+	h, err := readFITSHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return h.config(), nil
+}
+
+// decodeFITS decodes the primary data array of a FITS file, stretched to
+// 16 bits by FITS_SCALE. FITS stores the bottom row first, so the rows are
+// flipped to put north-up images the right way up.
+func decodeFITS(r io.Reader) (image.Image, error) {
+	h, err := readFITSHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	w, ht, planes := h.Axes[0], h.Axes[1], h.planes()
+	if err := checkPixels(int64(w) * int64(ht)); err != nil {
+		return nil, err
+	}
+	// The raw samples are held alongside the decoded image, so they are
+	// bounded too: by what the largest image allowed takes to decode.
+	if raw, limit := h.dataBytes(), imagePixelLimit()*bytesPerDecodedPixel; raw > limit {
+		return nil, fmt.Errorf("%w: %d bytes of FITS data, limit %d", errImageTooLarge, raw, limit)
+	}
+	data, err := readFITSData(r, h.dataBytes())
+	if err != nil {
+		return nil, err
+	}
+	size := max(h.BitPix, -h.BitPix) / 8
+	sample := func(i int) (float64, bool) {
+		at := i * size
+		b := data[at/fitsChunk][at%fitsChunk:]
+		var raw int64
+		switch h.BitPix {
+		case 8:
+			raw = int64(b[0])
+		case 16:
+			raw = int64(int16(binary.BigEndian.Uint16(b)))
+		case 32:
+			raw = int64(int32(binary.BigEndian.Uint32(b)))
+		case 64:
+			raw = int64(binary.BigEndian.Uint64(b))
+		case -32:
+			v := float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+			return h.BZero + h.BScale*v, !math.IsNaN(v) && !math.IsInf(v, 0)
+		default:
+			v := math.Float64frombits(binary.BigEndian.Uint64(b))
+			return h.BZero + h.BScale*v, !math.IsNaN(v) && !math.IsInf(v, 0)
+		}
+		if h.HasBlank && raw == h.Blank {
+			return 0, false
+		}
+		return h.BZero + h.BScale*float64(raw), true
+	}
+	n := w * ht * planes
+	lo, hi := fitsBounds(n, sample, fitsScale)
+	level := func(i int) uint16 {
+		v, ok := sample(i)
+		if !ok || v <= lo {
+			return 0
+		}
+		if v >= hi {
+			return math.MaxUint16
+		}
+		return uint16((v - lo) / (hi - lo) * math.MaxUint16)
+	}
+
+	rect := image.Rect(0, 0, w, ht)
+	if planes == 1 {
+		dst := image.NewGray16(rect)
+		parallelRows(ht, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				row := (ht - 1 - y) * w
+				for x := 0; x < w; x++ {
+					binary.BigEndian.PutUint16(dst.Pix[y*dst.Stride+2*x:], level(row+x))
+				}
+			}
+		})
+		return dst, nil
+	}
+	dst := image.NewNRGBA64(rect)
+	plane := w * ht
+	parallelRows(ht, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := (ht - 1 - y) * w
+			for x := 0; x < w; x++ {
+				p := dst.Pix[y*dst.Stride+8*x:]
+				for c := 0; c < 3; c++ {
+					binary.BigEndian.PutUint16(p[2*c:], level(c*plane+row+x))
+				}
+				binary.BigEndian.PutUint16(p[6:], math.MaxUint16)
+			}
+		}
+	})
+	return dst, nil
+}
+
+// readFITSData reads n bytes of data in fitsChunk pieces, allocating them
+// only as the reader delivers, so a header claiming more data than the file
+// holds fails before it is all allocated.
+func readFITSData(r io.Reader, n int64) ([][]byte, error) {
+	chunks := make([][]byte, 0, (n+fitsChunk-1)/fitsChunk)
+	for n > 0 {
+		chunk := make([]byte, min(n, fitsChunk))
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, fmt.Errorf("read FITS data: %w", err)
+		}
+		chunks = append(chunks, chunk)
+		n -= int64(len(chunk))
+	}
+	return chunks, nil
+}
+
+// fitsDataBytes is the size of the data decodeFITS reads from a FITS file,
+// or 0 for another format.
+func fitsDataBytes(data []byte) int64 {
+	h, err := readFITSHeader(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	return h.dataBytes()
+}
+
+// fitsBounds resolves the physical values stretched to black and white
+// across n samples.
+func fitsBounds(n int, sample func(int) (float64, bool), scale RadiometricScale) (float64, float64) {
+	var lo, hi float64
+	switch scale.Mode {
+	case "fixed":
+		lo, hi = scale.Lo, scale.Hi
+	case "percentile":
+		step := max(1, n/fitsScaleSamples)
+		values := make([]float64, 0, n/step+1)
+		for i := 0; i < n; i += step {
+			if v, ok := sample(i); ok {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return 0, 1
+		}
+		slices.Sort(values)
+		at := func(p float64) float64 { return values[int(p/100*float64(len(values)-1))] }
+		lo, hi = at(scale.Lo), at(scale.Hi)
+	default:
+		lo, hi = math.Inf(1), math.Inf(-1)
+		for i := 0; i < n; i++ {
+			if v, ok := sample(i); ok {
+				lo, hi = min(lo, v), max(hi, v)
+			}
+		}
+		if lo > hi {
+			return 0, 1
+		}
+	}
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+// fitsTags returns the header cards of a FITS file for its metadata, or nil
+// for another format.
+func fitsTags(data []byte) map[string]string {
+	if !isFITS(data) {
+		return nil
+	}
+	h, err := readFITSHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return h.Cards
+}
+
+// recordFITS stores the header cards of a FITS original in its image
+// record.
+func (api *API) recordFITS(ctx context.Context, id string, data []byte) error {
+	tags := fitsTags(data)
+	if tags == nil {
+		return nil
+	}
+	return api.Images.SetImageAttribute(ctx, id, "fits", tags)
+}
+
+// fitsCaptureTime is the DATE-OBS of a FITS file.
+func fitsCaptureTime(data []byte) (time.Time, bool) {
+	if !isFITS(data) {
+		return time.Time{}, false
+	}
+	h, err := readFITSHeader(bytes.NewReader(data))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return h.captureTime()
+}
+
+// downloadFITS serves GET /image/:id?format=fits: a FITS original as it was
+// uploaded, for tools that need its full dynamic range and header.
+func (api *API) downloadFITS(c *gin.Context, id string) {
+	ctx := c.Request.Context()
+	for name := range c.Request.URL.Query() {
+		if name != "format" && name != "exp" && name != "sig" && name != "tenant" {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "format=fits downloads the original FITS file and cannot be combined with "+name)
+			return
+		}
+	}
+	if c.GetHeader("Range") != "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "format=fits does not serve byte ranges")
+		return
+	}
+	bucketName := api.Config.ImagesBucket
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(imageKey(id)),
+	}, s3Accelerate(bucketName)...)
+	switch {
+	case errors.Is(err, errCustomerKey):
+		respondCustomerKey(c, customerKeyFrom(ctx) != nil)
+		return
+	case isColdObject(err):
+		api.respondCold(c, id)
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "s3 GetObject failed", "key", imageKey(id), "err", err)
+		respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+		return
+	}
+	defer out.Body.Close()
+
+	body := bufio.NewReader(out.Body)
+	if head, _ := body.Peek(len(fitsMagic)); !isFITS(head) {
+		respondError(c, http.StatusUnprocessableEntity, CodeImageUnsupported, "the image is not a FITS file")
+		return
+	}
+	c.Header("Content-Type", fitsContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".fits"))
+	if out.ContentLength != nil {
+		c.Header("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		slog.ErrorContext(ctx, "streaming failed", "key", imageKey(id), "err", err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// testFITS writes a w×h FITS file of 16-bit samples, stored bottom row
+// first, with the extra header cards given.
+func testFITS(w, h int, sample func(x, row int) int16, cards ...string) []byte {
+	var buf bytes.Buffer
+	card := func(s string) { fmt.Fprintf(&buf, "%-80s", s) }
+	card("SIMPLE  =                    T / conforms to FITS")
+	card("BITPIX  =                   16")
+	card("NAXIS   =                    2")
+	card(fmt.Sprintf("NAXIS1  = %20d", w))
+	card(fmt.Sprintf("NAXIS2  = %20d", h))
+	for _, c := range cards {
+		card(c)
+	}
+	card("END")
+	for buf.Len()%fitsBlock != 0 {
+		buf.WriteByte(' ')
+	}
+	for row := 0; row < h; row++ {
+		for x := 0; x < w; x++ {
+			binary.Write(&buf, binary.BigEndian, sample(x, row))
+		}
+	}
+	for buf.Len()%fitsBlock != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func TestReadFITSHeader(t *testing.T) {
+	data := testFITS(4, 2, func(x, row int) int16 { return 0 },
+		"OBJECT  = 'M 31''s core'       / target",
+		"BZERO   =              3.2768D4",
+		"DATE-OBS= '2026-10-15T04:05:06.5'",
+		"COMMENT   not a value",
+	)
+	h, err := readFITSHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Cards["OBJECT"] != "M 31's core" || h.BZero != 32768 || h.BScale != 1 || h.BitPix != 16 || len(h.Axes) != 2 || h.Axes[0] != 4 || h.Axes[1] != 2 {
+		t.Errorf("header %+v", h)
+	}
+	if _, ok := h.Cards["COMMENT"]; ok {
+		t.Error("COMMENT kept as a card")
+	}
+	if at, ok := h.captureTime(); !ok || !at.Equal(time.Date(2026, 10, 15, 4, 5, 6, 5e8, time.UTC)) {
+		t.Errorf("capture time %v %v", at, ok)
+	}
+	if _, err := readFITSHeader(bytes.NewReader([]byte("\xff\xd8\xff"))); err != errNoFITS {
+		t.Errorf("JPEG: %v", err)
+	}
+}
+
+func TestDecodeFITS(t *testing.T) {
+	defer func(scale RadiometricScale) { fitsScale = scale }(fitsScale)
+	fitsScale = RadiometricScale{Mode: "minmax"}
+	// The bottom row is stored first, and is all 100; the top row is 0 to
+	// 300.
+	data := testFITS(4, 2, func(x, row int) int16 {
+		if row == 0 {
+			return 100
+		}
+		return int16(x * 100)
+	}, "BZERO   = 1000")
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "fits" || cfg.Width != 4 || cfg.Height != 2 {
+		t.Fatalf("config %+v %s %v", cfg, format, err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, ok := img.(*image.Gray16)
+	if !ok {
+		t.Fatalf("decoded %T", img)
+	}
+	for x, want := range []uint16{0, 21845, 43690, 65535} {
+		if got := gray.Gray16At(x, 0).Y; got != want {
+			t.Errorf("top row %d = %d, want %d", x, got, want)
+		}
+	}
+	if got := gray.Gray16At(0, 1).Y; got != 21845 {
+		t.Errorf("bottom row = %d, want 21845", got)
+	}
+}
+
+func TestDecodeFITSBounds(t *testing.T) {
+	defer func(limit int64) { maxImagePixels = limit }(maxImagePixels)
+	// header is a FITS header of three planes of float64 samples, with no
+	// data after it.
+	header := func(w, h int) []byte {
+		var buf bytes.Buffer
+		for _, c := range []string{
+			"SIMPLE  =                    T",
+			"BITPIX  =                  -64",
+			"NAXIS   =                    3",
+			fmt.Sprintf("NAXIS1  = %20d", w),
+			fmt.Sprintf("NAXIS2  = %20d", h),
+			"NAXIS3  =                    3",
+			"END",
+		} {
+			fmt.Fprintf(&buf, "%-80s", c)
+		}
+		for buf.Len()%fitsBlock != 0 {
+			buf.WriteByte(' ')
+		}
+		return buf.Bytes()
+	}
+
+	// 900 pixels are allowed, but not the 21600 bytes they are stored in.
+	maxImagePixels = 1000
+	if _, _, err := image.Decode(bytes.NewReader(header(30, 30))); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("30×30×3 of float64: %v", err)
+	}
+
+	// A header claiming 1.5GB over an empty body fails having allocated
+	// only the first chunk.
+	maxImagePixels = defaultMaxImagePixels
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := image.Decode(bytes.NewReader(header(8000, 8000)))
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("truncated: %v", err)
+	}
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 64<<20 {
+		t.Errorf("truncated file allocated %d bytes", grown)
+	}
+
+	// The raw samples are charged to the limiter on top of the pixels.
+	n, err := sourcePixels(testFITS(64, 64, func(x, row int) int16 { return 0 }))
+	if want := int64(64*64 + 64*64*2/bytesPerDecodedPixel); err != nil || n != want {
+		t.Errorf("sourcePixels = %d %v, want %d", n, err, want)
+	}
+}
+
+func TestFITSIngestAndDownload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	raw, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	fits := testFITS(64, 64, func(x, row int) int16 { return int16(x * row) }, "OBJECT  = 'M 31'", "DATE-OBS= '2026-10-15'")
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := routeObjects(raw, meta, "sat", LayoutConfig{})
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        layout,
+		Layout:    layout,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+	for key, data := range map[string][]byte{sourceKey("m31", ".fits"): fits, imageKey("m42"): frame} {
+		if _, err := raw.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(key), Body: bytes.NewReader(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := api.ingestUpload(ctx, "m31", sourceKey("m31", ".fits")); err != nil {
+		t.Fatal(err)
+	}
+	record, err := meta.ImageRecord(ctx, "m31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil || record.Ingest == nil || record.Ingest.Status != IngestIngested || record.Ingest.Format != "fits" || record.FITS["OBJECT"] != "M 31" {
+		t.Fatalf("record %+v", record)
+	}
+
+	router := gin.New()
+	router.GET("/image/:id", api.getSatImageByID)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := get("/image/m31?format=fits"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), fits) || w.Header().Get("Content-Type") != fitsContentType {
+		t.Errorf("FITS download: %d, %d bytes of %s", w.Code, w.Body.Len(), w.Header().Get("Content-Type"))
+	}
+	if w := get("/image/m31?format=png&width=32"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("rendered FITS: %d %s", w.Code, w.Body)
+	}
+	for path, want := range map[string]int{
+		"/image/m31?format=fits&width=32": http.StatusBadRequest,
+		"/image/m42?format=fits":          http.StatusUnprocessableEntity,
+	} {
+		if w := get(path); w.Code != want {
+			t.Errorf("GET %s: %d, want %d", path, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+// The features that can be switched off, for rolling them out gradually.
+const (
+	FlagAVIF  = "avif"
+	FlagTiles = "tiles"
+)
+
+var featureFlags = []string{FlagAVIF, FlagTiles}
+
+// With FEATURE_FLAGS_TABLE set, the flags are reread every flagRefresh, so a change
+// made through another instance applies within it.
+const flagRefresh = 30 * time.Second
+
+var errFlagNotFound = errors.New("feature flag not found")
+
+// FeatureFlag turns a feature on or off, for every tenant but those it
+// names, which get the setting given for them.
+type FeatureFlag struct {
+	Name    string          `dynamodbav:"id" json:"name"`
+	Enabled bool            `dynamodbav:"enabled" json:"enabled"`
+	Tenants map[string]bool `dynamodbav:"tenants,omitempty" json:"tenants,omitempty"`
+	Updated int64           `dynamodbav:"updated,omitempty" json:"updated,omitempty"`
+}
+
+// enabled reports whether the flag is on for tenant, "" without
+// MULTI_TENANT.
+func (f FeatureFlag) enabled(tenant string) bool {
+	if on, ok := f.Tenants[tenant]; ok && tenant != "" {
+		return on
+	}
+	return f.Enabled
+}
+
+// loadFeatureFlags reads FEATURE_FLAGS, a comma-separated list of
+// flag=on|off and flag:tenant=on|off pairs, into the flags' defaults.
+// Flags it does not name are on.
+func loadFeatureFlags() (map[string]FeatureFlag, []error) {
+	flags := map[string]FeatureFlag{}
+	for _, name := range featureFlags {
+		flags[name] = FeatureFlag{Name: name, Enabled: true}
+	}
+	var errs []error
+	for _, item := range splitList(os.Getenv("FEATURE_FLAGS")) {
+		key, value, _ := strings.Cut(item, "=")
+		name, tenant, scoped := strings.Cut(strings.TrimSpace(key), ":")
+		var on bool
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "on", "true":
+			on = true
+		case "off", "false":
+		default:
+			errs = append(errs, fmt.Errorf("FEATURE_FLAGS entry %q is not flag=on or flag:tenant=on, or off", item))
+			continue
+		}
+		f, ok := flags[name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("FEATURE_FLAGS entry %q: unknown flag %q, not one of %s", item, name, strings.Join(featureFlags, ", ")))
+		case scoped && !tenantName.MatchString(tenant):
+			errs = append(errs, fmt.Errorf("FEATURE_FLAGS entry %q: %q is not a tenant name", item, tenant))
+		case scoped:
+			f.Tenants = maps.Clone(f.Tenants)
+			if f.Tenants == nil {
+				f.Tenants = map[string]bool{}
+			}
+			f.Tenants[tenant] = on
+		default:
+			f.Enabled = on
+		}
+		flags[name] = f
+	}
+	return flags, errs
+}
+
+// FlagStore holds the feature flags: the FEATURE_FLAGS defaults, and the
+// settings made through /admin/maintenance/flags that replace them. When
+// FEATURE_FLAGS_TABLE is set those are saved to DynamoDB and shared by every
+// instance; without it they live only in this process. A nil *FlagStore
+// has every feature on.
+type FlagStore struct {
+	defaults map[string]FeatureFlag
+
+	mu    sync.RWMutex
+	flags map[string]FeatureFlag
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newFlagStore(db *dynamodb.Client, table string, defaults map[string]FeatureFlag) *FlagStore {
+	s := &FlagStore{defaults: defaults, flags: map[string]FeatureFlag{}, table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the flags and rereads them until ctx is done.
+func (s *FlagStore) Start(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load feature flags", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(flagRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload feature flags", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Enabled reports whether the feature name is on for ctx's tenant.
+func (s *FlagStore) Enabled(ctx context.Context, name string) bool {
+	if s == nil {
+		return true
+	}
+	f, err := s.Get(name)
+	return err != nil || f.enabled(tenantFrom(ctx))
+}
+
+// Get returns the flag in effect for name.
+func (s *FlagStore) Get(name string) (FeatureFlag, error) {
+	s.mu.RLock()
+	f, ok := s.flags[name]
+	s.mu.RUnlock()
+	if ok {
+		return f, nil
+	}
+	if f, ok := s.defaults[name]; ok {
+		return f, nil
+	}
+	return FeatureFlag{}, errFlagNotFound
+}
+
+// List returns every flag in effect.
+func (s *FlagStore) List() []FeatureFlag {
+	list := make([]FeatureFlag, 0, len(featureFlags))
+	for _, name := range featureFlags {
+		if f, err := s.Get(name); err == nil {
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
+// Put replaces the setting for f.Name.
+func (s *FlagStore) Put(ctx context.Context, f FeatureFlag) (FeatureFlag, error) {
+	f.Updated = time.Now().Unix()
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(f)
+		if err != nil {
+			return FeatureFlag{}, fmt.Errorf("marshal feature flag: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return FeatureFlag{}, err
+		}
+	}
+	s.mu.Lock()
+	s.flags[f.Name] = f
+	s.mu.Unlock()
+	return f, nil
+}
+
+// Delete drops the setting for name, which reverts to its default.
+func (s *FlagStore) Delete(ctx context.Context, name string) error {
+	if s.db != nil {
+		_, err := s.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: name},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	delete(s.flags, name)
+	s.mu.Unlock()
+	return nil
+}
+
+// refresh replaces the settings with those in FEATURE_FLAGS_TABLE. Items for flags
+// this build does not know are ignored.
+func (s *FlagStore) refresh(ctx context.Context) error {
+	flags := map[string]FeatureFlag{}
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []FeatureFlag
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for _, f := range items {
+			if slices.Contains(featureFlags, f.Name) {
+				flags[f.Name] = f
+			}
+		}
+	}
+	s.mu.Lock()
+	s.flags = flags
+	s.mu.Unlock()
+	return nil
+}
+
+// requireFlag answers 501 FEATURE_UNAVAILABLE to tenants without the
+// feature name.
+func (api *API) requireFlag(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !api.Flags.Enabled(c.Request.Context(), name) {
+			respondError(c, http.StatusNotImplemented, CodeFeatureUnavailable, fmt.Sprintf("the %s feature is not enabled", name))
+			return
+		}
+		c.Next()
+	}
+}
+
+// gateFormat keeps opts to the output formats enabled for ctx's tenant:
+// AVIF, while FlagAVIF is off, is refused when asked for by name and
+// passed over when negotiated from accept.
+func (api *API) gateFormat(ctx context.Context, opts *ProcessOptions, accept string) error {
+	if opts.Format == nil || opts.Format.Name != FlagAVIF || api.Flags.Enabled(ctx, FlagAVIF) {
+		return nil
+	}
+	if opts.Negotiated {
+		opts.Format = negotiateFormat(accept, FlagAVIF)
+		return nil
+	}
+	return newProblem(http.StatusNotImplemented, CodeFeatureUnavailable, "avif output is not enabled")
+}
+
+// FlagListResponse is the body of GET /admin/maintenance/flags.
+type FlagListResponse struct {
+	Flags []FeatureFlag `json:"flags"`
+}
+
+// flagRequest is the body of PUT /admin/maintenance/flags/:name.
+type flagRequest struct {
+	Enabled *bool           `json:"enabled"`
+	Tenants map[string]bool `json:"tenants"`
+}
+
+func (api *API) getFlags(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, FlagListResponse{Flags: api.Flags.List()})
+}
+
+// putFlag replaces a flag's setting, in place of its FEATURE_FLAGS default.
+func (api *API) putFlag(c *gin.Context) {
+	name := c.Param("name")
+	if _, err := api.Flags.Get(name); err != nil {
+		respondError(c, http.StatusNotFound, CodeFlagNotFound, fmt.Sprintf("unknown feature flag %q, not one of %s", name, strings.Join(featureFlags, ", ")))
+		return
+	}
+	var req flagRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, `Invalid request body. Expected {"enabled": true|false, "tenants": {"<tenant>": true|false}}.`)
+		return
+	}
+	for tenant := range req.Tenants {
+		if !tenantName.MatchString(tenant) {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("%q is not a tenant name", tenant))
+			return
+		}
+	}
+	f, err := api.Flags.Put(c.Request.Context(), FeatureFlag{Name: name, Enabled: *req.Enabled, Tenants: req.Tenants})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to save feature flag", "name", name, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save the feature flag")
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "set feature flag", "name", name, "enabled", f.Enabled, "tenants", f.Tenants)
+	c.IndentedJSON(http.StatusOK, f)
+}
+
+// deleteFlag reverts a flag to its FEATURE_FLAGS default.
+func (api *API) deleteFlag(c *gin.Context) {
+	name := c.Param("name")
+	if _, err := api.Flags.Get(name); err != nil {
+		respondError(c, http.StatusNotFound, CodeFlagNotFound, fmt.Sprintf("unknown feature flag %q", name))
+		return
+	}
+	if err := api.Flags.Delete(c.Request.Context(), name); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to delete feature flag", "name", name, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete the feature flag")
+		return
+	}
+	f, _ := api.Flags.Get(name)
+	c.IndentedJSON(http.StatusOK, f)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFlagStoreEnabled(t *testing.T) {
+	t.Setenv("FEATURE_FLAGS", "avif=off, avif:acme=on, tiles:globex=off")
+	defaults, errs := loadFeatureFlags()
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	s := newFlagStore(nil, "", defaults)
+	ctx := context.Background()
+
+	tests := []struct {
+		flag, tenant string
+		want         bool
+	}{
+		{FlagAVIF, "", false},
+		{FlagAVIF, "acme", true},
+		{FlagAVIF, "globex", false},
+		{FlagTiles, "", true},
+		{FlagTiles, "globex", false},
+		{"unknown", "", true},
+	}
+	for _, tt := range tests {
+		if got := s.Enabled(withTenant(ctx, tt.tenant), tt.flag); got != tt.want {
+			t.Errorf("Enabled(%s, %q) = %v, want %v", tt.flag, tt.tenant, got, tt.want)
+		}
+	}
+
+	if _, err := s.Put(ctx, FeatureFlag{Name: FlagAVIF, Enabled: true, Tenants: map[string]bool{"globex": false}}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Enabled(ctx, FlagAVIF) || s.Enabled(withTenant(ctx, "globex"), FlagAVIF) || !s.Enabled(withTenant(ctx, "acme"), FlagAVIF) {
+		t.Error("the setting put did not replace the default")
+	}
+	if err := s.Delete(ctx, FlagAVIF); err != nil {
+		t.Fatal(err)
+	}
+	if s.Enabled(ctx, FlagAVIF) {
+		t.Error("avif still on after its setting was deleted")
+	}
+	if !(*FlagStore)(nil).Enabled(ctx, FlagAVIF) {
+		t.Error("a nil store turned avif off")
+	}
+}
+
+func TestGateFormat(t *testing.T) {
+	avif, _ := lookupFormat("avif")
+	api := &API{Flags: newFlagStore(nil, "", map[string]FeatureFlag{FlagAVIF: {Name: FlagAVIF}})}
+	ctx := context.Background()
+
+	opts := ProcessOptions{Format: avif, Negotiated: true}
+	if err := api.gateFormat(ctx, &opts, "image/avif,image/webp;q=0.9"); err != nil || opts.Format.Name != "webp" {
+		t.Errorf("negotiated: %v, %v", opts.Format.Name, err)
+	}
+	opts = ProcessOptions{Format: avif}
+	if err := api.gateFormat(ctx, &opts, ""); problemFor(err).Code != CodeFeatureUnavailable {
+		t.Errorf("explicit: %v", err)
+	}
+	opts = ProcessOptions{Format: avif}
+	if err := (&API{}).gateFormat(ctx, &opts, ""); err != nil || opts.Format != avif {
+		t.Errorf("without flags: %v, %v", opts.Format.Name, err)
+	}
+}
+
+func TestFlagRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{Config: &Config{}, Flags: newFlagStore(nil, "", map[string]FeatureFlag{
+		FlagAVIF:  {Name: FlagAVIF, Enabled: true},
+		FlagTiles: {Name: FlagTiles, Enabled: true},
+	})}
+	router := gin.New()
+	g := router.Group("/admin/maintenance")
+	g.GET("/flags", api.getFlags)
+	g.PUT("/flags/:name", api.putFlag)
+	g.DELETE("/flags/:name", api.deleteFlag)
+	router.GET("/tiles", api.requireFlag(FlagTiles), func(c *gin.Context) { c.Status(http.StatusOK) })
+	do := func(method, path, body string) (int, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var got map[string]any
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return w.Code, got
+	}
+
+	tests := []struct {
+		method, path, body string
+		want               int
+		code               ErrorCode
+	}{
+		{http.MethodPut, "/admin/maintenance/flags/webp", `{"enabled": false}`, http.StatusNotFound, CodeFlagNotFound},
+		{http.MethodPut, "/admin/maintenance/flags/tiles", `{"tenants": {"acme": true}}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/admin/maintenance/flags/tiles", `{"enabled": true, "tenants": {"Not A Tenant": true}}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodDelete, "/admin/maintenance/flags/webp", "", http.StatusNotFound, CodeFlagNotFound},
+		{http.MethodPut, "/admin/maintenance/flags/tiles", `{"enabled": false}`, http.StatusOK, ""},
+		{http.MethodGet, "/tiles", "", http.StatusNotImplemented, CodeFeatureUnavailable},
+		{http.MethodDelete, "/admin/maintenance/flags/tiles", "", http.StatusOK, ""},
+		{http.MethodGet, "/tiles", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		code, body := do(tt.method, tt.path, tt.body)
+		if code != tt.want || tt.code != "" && body["code"] != string(tt.code) {
+			t.Errorf("%s %s %s: %d %v, want %d %s", tt.method, tt.path, tt.body, code, body, tt.want, tt.code)
+		}
+	}
+
+	_, body := do(http.MethodGet, "/admin/maintenance/flags", "")
+	flags, _ := body["flags"].([]any)
+	if len(flags) != 2 {
+		t.Errorf("GET flags: %v", body)
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Footprints are indexed by the geohash cells of footprintPrecision
+	// characters, about 1250 by 625 km, that their bounding boxes touch.
+	footprintPrecision = 2
+	// maxFootprintCells bounds the cells one footprint is indexed under;
+	// larger ones are not indexed. maxSearchCells bounds those one search
+	// reads.
+	maxFootprintCells = 64
+	maxSearchCells    = 256
+	// footprintCellIndex is the IMAGE_FOOTPRINTS_TABLE index partitioned by
+	// cell.
+	footprintCellIndex = "cell-id"
+	// GET /images/search answers with up to ?limit= images.
+	defaultImageSearchLimit = 100
+	maxImageSearchLimit     = 1000
+	// utmScale is the scale factor on the central meridian of every UTM
+	// zone.
+	utmScale = 0.9996
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Footprint is the area of the ground an image covers, stored as the
+// footprint attribute of its ImageRecord.
+type Footprint struct {
+	// BBox is west, south, east, north in degrees. West is greater than
+	// east for a footprint across the antimeridian.
+	BBox [4]float64 `dynamodbav:"bbox" json:"bbox"`
+	// Polygon is the outline as a closed ring of [longitude, latitude]
+	// positions; a single position for a point.
+	Polygon [][2]float64 `dynamodbav:"polygon" json:"polygon"`
+	// Source is where it was read from: geotiff or exif.
+	Source string `dynamodbav:"source" json:"source"`
+	CRS    string `dynamodbav:"crs,omitempty" json:"crs,omitempty"`
+}
+
+// geotiffFootprint is the outline of the image's corners, for GeoTIFFs in
+// geographic coordinates, UTM or Web Mercator. Other projections return nil.
+func geotiffFootprint(g *GeoInfo) *Footprint {
+	toLonLat := projectionInverse(g.CRS)
+	if toLonLat == nil || g.Width == 0 || g.Height == 0 {
+		return nil
+	}
+	c := g.Corners
+	ring := [][2]float64{c.UpperLeft, c.UpperRight, c.LowerRight, c.LowerLeft, c.UpperLeft}
+	for i, p := range ring {
+		lon, lat := toLonLat(p[0], p[1])
+		if math.IsNaN(lon) || math.IsNaN(lat) || lat < -90 || lat > 90 {
+			return nil
+		}
+		ring[i] = [2]float64{roundDeg(wrapLon(lon)), roundDeg(lat)}
+	}
+	return &Footprint{BBox: ringBBox(ring), Polygon: ring, Source: "geotiff", CRS: g.CRS}
+}
+
+// exifFootprint is the point of the file's GPS tags.
+func exifFootprint(tags map[string]string) *Footprint {
+	lat, errLat := strconv.ParseFloat(tags["GPSLatitude"], 64)
+	lon, errLon := strconv.ParseFloat(tags["GPSLongitude"], 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil
+	}
+	return &Footprint{BBox: [4]float64{lon, lat, lon, lat}, Polygon: [][2]float64{{lon, lat}}, Source: "exif"}
+}
+
+func roundDeg(v float64) float64 { return math.Round(v*1e7) / 1e7 }
+
+func wrapLon(lon float64) float64 {
+	return math.Mod(math.Mod(lon+180, 360)+360, 360) - 180
+}
+
+// ringBBox bounds ring, crossing the antimeridian when that is the smaller
+// box.
+func ringBBox(ring [][2]float64) [4]float64 {
+	w, s, e, n := 180.0, 90.0, -180.0, -90.0
+	// The same longitudes taken from 0 to 360, for boxes across ±180.
+	w360, e360 := 360.0, 0.0
+	for _, p := range ring {
+		w, e = min(w, p[0]), max(e, p[0])
+		s, n = min(s, p[1]), max(n, p[1])
+		l := math.Mod(p[0]+360, 360)
+		w360, e360 = min(w360, l), max(e360, l)
+	}
+	if e360-w360 < e-w {
+		w, e = wrapLon(w360), wrapLon(e360)
+	}
+	return [4]float64{w, s, e, n}
+}
+
+// projectionInverse converts coordinates of the EPSG crs to longitude and
+// latitude, or is nil for a CRS it does not know.
+func projectionInverse(crs string) func(x, y float64) (float64, float64) {
+	code, ok := strings.CutPrefix(crs, "EPSG:")
+	n, err := strconv.Atoi(code)
+	if !ok || err != nil {
+		return nil
+	}
+	switch {
+	case n == 4326 || n == 4269 || n == 4258:
+		return func(x, y float64) (float64, float64) { return x, y }
+	case n == 3857:
+		r := wgs84A * 1000
+		return func(x, y float64) (float64, float64) {
+			return x / r * 180 / math.Pi, (2*math.Atan(math.Exp(y/r)) - math.Pi/2) * 180 / math.Pi
+		}
+	case n > 32600 && n <= 32660, n > 32700 && n <= 32760:
+		zone, south := n%100, n > 32700
+		return func(x, y float64) (float64, float64) { return utmInverse(zone, south, x, y) }
+	}
+	return nil
+}
+
+// utmInverse converts WGS-84 UTM coordinates in metres to longitude and
+// latitude in degrees, by the series of Snyder's Map Projections, §8.
+func utmInverse(zone int, south bool, x, y float64) (lon, lat float64) {
+	a := wgs84A * 1000
+	e2 := wgs84E2
+	ep2 := e2 / (1 - e2)
+	x -= 500000
+	if south {
+		y -= 10000000
+	}
+	mu := y / utmScale / (a * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	phi := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sin, cos := math.Sincos(phi)
+	tan := sin / cos
+	n := a / math.Sqrt(1-e2*sin*sin)
+	t := tan * tan
+	c := ep2 * cos * cos
+	r := a * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+	d := x / (n * utmScale)
+
+	lat = phi - n*tan/r*(d*d/2-
+		(5+3*t+10*c-4*c*c-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t+298*c+45*t*t-252*ep2-3*c*c)*math.Pow(d, 6)/720)
+	lon = (d - (1+2*t+c)*math.Pow(d, 3)/6 +
+		(5-2*c+28*t-3*c*c+8*ep2+24*t*t)*math.Pow(d, 5)/120) / cos
+	lon0 := float64(zone-1)*6 - 180 + 3
+	return lon0 + lon*180/math.Pi, lat * 180 / math.Pi
+}
+
+// geohash encodes the cell of precision characters that holds the point.
+func geohash(lon, lat float64, precision int) string {
+	lonRange, latRange := [2]float64{-180, 180}, [2]float64{-90, 90}
+	out := make([]byte, precision)
+	bit := 0
+	for i := range out {
+		var ch byte
+		for range 5 {
+			r, v := &lonRange, lon
+			if bit%2 == 1 {
+				r, v = &latRange, lat
+			}
+			mid := (r[0] + r[1]) / 2
+			ch <<= 1
+			if v >= mid {
+				ch |= 1
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			bit++
+		}
+		out[i] = geohashAlphabet[ch]
+	}
+	return string(out)
+}
+
+// lonIntervals splits a box's longitudes in two where it crosses the
+// antimeridian.
+func lonIntervals(b [4]float64) [][2]float64 {
+	if b[0] > b[2] {
+		return [][2]float64{{b[0], 180}, {-180, b[2]}}
+	}
+	return [][2]float64{{b[0], b[2]}}
+}
+
+// bboxIntersects reports whether two west, south, east, north boxes share
+// a point.
+func bboxIntersects(a, b [4]float64) bool {
+	if a[1] > b[3] || b[1] > a[3] {
+		return false
+	}
+	for _, x := range lonIntervals(a) {
+		for _, y := range lonIntervals(b) {
+			if x[0] <= y[1] && y[0] <= x[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coverCells lists the geohash cells of footprintPrecision that the box
+// touches. It reports false, with no cells, when there are more than limit.
+func coverCells(b [4]float64, limit int) ([]string, bool) {
+	lonBits, latBits := (5*footprintPrecision+1)/2, 5*footprintPrecision/2
+	cw, ch := 360/float64(int(1)<<lonBits), 180/float64(int(1)<<latBits)
+	index := func(v, lo, size float64, count int) int {
+		return min(int((v-lo)/size), count-1)
+	}
+	rows := [2]int{index(b[1], -90, ch, 1<<latBits), index(b[3], -90, ch, 1<<latBits)}
+	var cols [][2]int
+	count := 0
+	for _, l := range lonIntervals(b) {
+		c := [2]int{index(l[0], -180, cw, 1<<lonBits), index(l[1], -180, cw, 1<<lonBits)}
+		cols = append(cols, c)
+		count += (c[1] - c[0] + 1) * (rows[1] - rows[0] + 1)
+	}
+	if count > limit {
+		return nil, false
+	}
+	cells := make([]string, 0, count)
+	for _, c := range cols {
+		for i := c[0]; i <= c[1]; i++ {
+			for j := rows[0]; j <= rows[1]; j++ {
+				cells = append(cells, geohash(-180+(float64(i)+0.5)*cw, -90+(float64(j)+0.5)*ch, footprintPrecision))
+			}
+		}
+	}
+	return cells, true
+}
+
+// footprintCell is an image's footprint under one of the cells it touches,
+// an item of IMAGE_FOOTPRINTS_TABLE.
+type footprintCell struct {
+	// ID is the image's key and the cell, joined by '#'. The image's key is
+	// its IMAGE_TABLE key, and so carries the tenant with MULTI_TENANT.
+	ID          string     `dynamodbav:"id"`
+	Cell        string     `dynamodbav:"cell"`
+	Image       string     `dynamodbav:"image"`
+	BBox        [4]float64 `dynamodbav:"bbox"`
+	CaptureTime int64      `dynamodbav:"capture_time,omitempty"`
+}
+
+// FootprintIndex finds the images whose footprints meet a box. When
+// IMAGE_FOOTPRINTS_TABLE is set the footprints are saved to it and each
+// search queries the cells it covers; without it they live only in this
+// process. A nil index holds nothing.
+type FootprintIndex struct {
+	mu    sync.RWMutex
+	cells map[string]map[string]footprintCell
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newFootprintIndex(db *dynamodb.Client, table string) *FootprintIndex {
+	s := &FootprintIndex{cells: make(map[string]map[string]footprintCell), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Put indexes the footprint of the image keyed key, replacing previous, the
+// footprint it was indexed with before, if any. It reports false for a
+// footprint too large to index.
+func (s *FootprintIndex) Put(ctx context.Context, key string, fp, previous *Footprint, captured int64) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	cells, ok := coverCells(fp.BBox, maxFootprintCells)
+	if !ok {
+		return false, nil
+	}
+	var stale []string
+	if previous != nil {
+		old, _ := coverCells(previous.BBox, maxFootprintCells)
+		for _, c := range old {
+			if !slices.Contains(cells, c) {
+				stale = append(stale, c)
+			}
+		}
+	}
+	if s.db != nil {
+		for _, cell := range cells {
+			item, err := attributevalue.MarshalMap(footprintCell{ID: key + "#" + cell, Cell: cell, Image: key, BBox: fp.BBox, CaptureTime: captured})
+			if err != nil {
+				return false, fmt.Errorf("marshal footprint: %w", err)
+			}
+			if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+				return false, err
+			}
+		}
+		for _, cell := range stale {
+			_, err := s.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName: aws.String(s.table),
+				Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key + "#" + cell}},
+			})
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cell := range stale {
+		delete(s.cells[cell], key)
+	}
+	for _, cell := range cells {
+		if s.cells[cell] == nil {
+			s.cells[cell] = make(map[string]footprintCell)
+		}
+		s.cells[cell][key] = footprintCell{Cell: cell, Image: key, BBox: fp.BBox, CaptureTime: captured}
+	}
+	return true, nil
+}
+
+// footprintMatch is an image found by Search, its key without the prefix.
+type footprintMatch struct {
+	id          string
+	bbox        [4]float64
+	captureTime int64
+}
+
+// Search returns the images keyed with prefix whose footprints meet bbox
+// and, when start or end is not zero, that were captured between them, in
+// capture order. It reports false, with no images, when bbox is too large
+// to search.
+func (s *FootprintIndex) Search(ctx context.Context, prefix string, bbox [4]float64, start, end int64) ([]footprintMatch, bool, error) {
+	cells, ok := coverCells(bbox, maxSearchCells)
+	if s == nil || !ok {
+		return nil, ok, nil
+	}
+	seen := map[string]bool{}
+	var matches []footprintMatch
+	add := func(fc footprintCell) {
+		id, ok := strings.CutPrefix(fc.Image, prefix)
+		switch {
+		case !ok || seen[id] || (prefix == "" && strings.Contains(id, "/")):
+			return
+		case !bboxIntersects(bbox, fc.BBox):
+			return
+		case (start != 0 || end != 0) && fc.CaptureTime == 0:
+			return
+		case start != 0 && fc.CaptureTime < start, end != 0 && fc.CaptureTime > end:
+			return
+		}
+		seen[id] = true
+		matches = append(matches, footprintMatch{id, fc.BBox, fc.CaptureTime})
+	}
+	for _, cell := range cells {
+		if s.db == nil {
+			s.mu.RLock()
+			for _, fc := range s.cells[cell] {
+				add(fc)
+			}
+			s.mu.RUnlock()
+			continue
+		}
+		input := &dynamodb.QueryInput{
+			TableName:                 aws.String(s.table),
+			IndexName:                 aws.String(footprintCellIndex),
+			KeyConditionExpression:    aws.String("cell = :cell AND begins_with(id, :prefix)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":cell": &types.AttributeValueMemberS{Value: cell}, ":prefix": &types.AttributeValueMemberS{Value: prefix}},
+		}
+		if prefix == "" {
+			input.KeyConditionExpression = aws.String("cell = :cell")
+			delete(input.ExpressionAttributeValues, ":prefix")
+		}
+		paginator := dynamodb.NewQueryPaginator(s.db, input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, true, err
+			}
+			var items []footprintCell
+			if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+				return nil, true, err
+			}
+			for _, fc := range items {
+				add(fc)
+			}
+		}
+	}
+	slices.SortFunc(matches, func(a, b footprintMatch) int {
+		return cmp.Or(cmp.Compare(a.captureTime, b.captureTime), cmp.Compare(a.id, b.id))
+	})
+	return matches, true, nil
+}
+
+// annotateFootprint stores and indexes the footprint of an image just
+// ingested, read from the GeoTIFF tags in head or else the GPS position of
+// the EXIF tags recorded from the whole file. Images with neither are left
+// alone, and a failure is logged and does not fail the ingest.
+func (api *API) annotateFootprint(ctx context.Context, id string, head []byte, captured time.Time) {
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		return
+	}
+	var fp, previous *Footprint
+	if geo, err := parseGeoTIFF(head); err == nil && geo != nil {
+		fp = geotiffFootprint(geo)
+	}
+	if record != nil {
+		if fp == nil {
+			fp = exifFootprint(record.EXIF)
+		}
+		previous = record.Footprint
+	}
+	if fp == nil {
+		return
+	}
+	if err := api.Images.SetImageAttribute(ctx, id, "footprint", fp); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to store footprint", "id", id, "err", err)
+		return
+	}
+	var at int64
+	if !captured.IsZero() {
+		at = captured.Unix()
+	}
+	indexed, err := api.Footprints.Put(ctx, tenantRecordID(ctx, id), fp, previous, at)
+	switch {
+	case err != nil:
+		slog.ErrorContext(ctx, "failed to index footprint", "id", id, "err", err)
+	case !indexed:
+		slog.WarnContext(ctx, "footprint too large to index", "id", id, "bbox", fp.BBox)
+	}
+}
+
+// ImageSearchResult is an image whose footprint meets the box searched.
+type ImageSearchResult struct {
+	ImageID     string     `json:"image_id"`
+	MissionID   string     `json:"mission_id,omitempty"`
+	CaptureTime int64      `json:"capture_time,omitempty"`
+	Footprint   *Footprint `json:"footprint,omitempty"`
+}
+
+// ImageSearchResponse is the body of GET /images/search.
+type ImageSearchResponse struct {
+	BBox   [4]float64          `json:"bbox"`
+	Images []ImageSearchResult `json:"images"`
+	// Truncated is set when more images matched than were returned.
+	Truncated bool `json:"truncated"`
+}
+
+// parseBBox reads west,south,east,north in degrees.
+func parseBBox(v string) ([4]float64, bool) {
+	var b [4]float64
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return b, false
+	}
+	for i, p := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return b, false
+		}
+		b[i] = n
+	}
+	return b, b[0] >= -180 && b[0] <= 180 && b[2] >= -180 && b[2] <= 180 && b[1] >= -90 && b[3] <= 90 && b[1] <= b[3]
+}
+
+// getImageSearch lists the images whose footprints meet ?bbox=, captured
+// between ?start= and ?end= when they are given.
+func (api *API) getImageSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	bbox, ok := parseBBox(c.Query("bbox"))
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'bbox' parameter. Must be west,south,east,north in degrees.")
+		return
+	}
+	var start, end int64
+	if v := c.Query("start"); v != "" {
+		t, err := parseAuditTime(v)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'start' parameter. Must be a unix time or an RFC 3339 time.")
+			return
+		}
+		start = t.Unix()
+	}
+	if v := c.Query("end"); v != "" {
+		t, err := parseAuditTime(v)
+		if err != nil || t.Unix() < start {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'end' parameter. Must be a unix time or an RFC 3339 time no earlier than start.")
+			return
+		}
+		end = t.Unix()
+	}
+	limit := defaultImageSearchLimit
+	if s := c.Query("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxImageSearchLimit {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'limit' parameter. Must be an integer between 1 and %d.", maxImageSearchLimit))
+			return
+		}
+		limit = v
+	}
+
+	matches, ok, err := api.Footprints.Search(ctx, tenantRecordID(ctx, ""), bbox, start, end)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to search footprints", "bbox", bbox, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to search footprints")
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'bbox' parameter. It spans more than %d geohash cells of %d characters; search a smaller box.", maxSearchCells, footprintPrecision))
+		return
+	}
+	resp := ImageSearchResponse{BBox: bbox, Images: []ImageSearchResult{}, Truncated: len(matches) > limit}
+	matches = matches[:min(len(matches), limit)]
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.id
+	}
+	records, err := api.Images.ImageRecords(ctx, ids)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image records", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+		return
+	}
+	for _, m := range matches {
+		r := records[m.id]
+		result := ImageSearchResult{ImageID: m.id, MissionID: imageMission(r), CaptureTime: m.captureTime}
+		if r != nil {
+			result.Footprint = r.Footprint
+		}
+		resp.Images = append(resp.Images, result)
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
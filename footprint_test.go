@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGeohash(t *testing.T) {
+	tests := []struct {
+		lon, lat  float64
+		precision int
+		want      string
+	}{
+		{-5.6, 42.6, 5, "ezs42"},
+		{10.40744, 57.64911, 11, "u4pruydqqvj"},
+		{-180, -90, 2, "00"},
+		{180, 90, 2, "zz"},
+	}
+	for _, tt := range tests {
+		if got := geohash(tt.lon, tt.lat, tt.precision); got != tt.want {
+			t.Errorf("geohash(%v, %v) = %s, want %s", tt.lon, tt.lat, got, tt.want)
+		}
+	}
+}
+
+func TestUTMInverse(t *testing.T) {
+	tests := []struct {
+		zone     int
+		south    bool
+		x, y     float64
+		lon, lat float64
+	}{
+		{33, false, 500000, 4000000, 15, 36.1447181},
+		{33, true, 500000, 6000000, 15, -36.1447181},
+		{31, false, 448251.8, 5411932.7, 2.2945, 48.8582},
+	}
+	for _, tt := range tests {
+		lon, lat := utmInverse(tt.zone, tt.south, tt.x, tt.y)
+		if math.Abs(lon-tt.lon) > 1e-4 || math.Abs(lat-tt.lat) > 1e-4 {
+			t.Errorf("zone %d (%v, %v) = %v, %v, want %v, %v", tt.zone, tt.x, tt.y, lon, lat, tt.lon, tt.lat)
+		}
+	}
+}
+
+func TestBBoxIntersects(t *testing.T) {
+	tests := []struct {
+		a, b [4]float64
+		want bool
+	}{
+		{[4]float64{0, 0, 10, 10}, [4]float64{5, 5, 15, 15}, true},
+		{[4]float64{0, 0, 10, 10}, [4]float64{10, 10, 20, 20}, true},
+		{[4]float64{0, 0, 10, 10}, [4]float64{11, 0, 20, 10}, false},
+		{[4]float64{0, 0, 10, 10}, [4]float64{0, 11, 10, 20}, false},
+		{[4]float64{170, -10, -170, 10}, [4]float64{179, 0, 179, 0}, true},
+		{[4]float64{170, -10, -170, 10}, [4]float64{-175, 0, -172, 5}, true},
+		{[4]float64{170, -10, -170, 10}, [4]float64{0, 0, 10, 10}, false},
+		{[4]float64{175, 0, -175, 5}, [4]float64{170, -10, -170, 10}, true},
+	}
+	for _, tt := range tests {
+		if got := bboxIntersects(tt.a, tt.b); got != tt.want {
+			t.Errorf("bboxIntersects(%v, %v) = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestCoverCells(t *testing.T) {
+	cells, ok := coverCells([4]float64{-5.6, 42.6, -5.6, 42.6}, 1)
+	if !ok || !slices.Equal(cells, []string{"ez"}) {
+		t.Errorf("point: %v %v", cells, ok)
+	}
+	// Cells are 11.25° by 5.625°; a box across the antimeridian takes one
+	// column on each side.
+	cells, ok = coverCells([4]float64{179, 1, -179, 2}, 4)
+	if !ok || len(cells) != 2 || cells[0] != geohash(179, 1, 2) || cells[1] != geohash(-179, 1, 2) {
+		t.Errorf("antimeridian: %v %v", cells, ok)
+	}
+	if _, ok := coverCells([4]float64{-180, -90, 180, 90}, maxSearchCells); ok {
+		t.Error("the whole world was covered")
+	}
+}
+
+func TestRingBBox(t *testing.T) {
+	got := ringBBox([][2]float64{{179, 1}, {-179, 1}, {-179, -1}, {179, -1}, {179, 1}})
+	if got != [4]float64{179, -1, -179, 1} {
+		t.Errorf("antimeridian ring: %v", got)
+	}
+	got = ringBBox([][2]float64{{10, 1}, {12, 1}, {12, -1}, {10, -1}, {10, 1}})
+	if got != [4]float64{10, -1, 12, 1} {
+		t.Errorf("ring: %v", got)
+	}
+}
+
+func TestImageSearch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	meta := testSQLStore(t)
+	if err := putMission(ctx, meta, &Mission{ID: "m1", ImageIDs: []string{"m1-00", "m1-01", "m1-02"}}); err != nil {
+		t.Fatal(err)
+	}
+	api := &API{Images: meta, MissionDB: meta, Footprints: newFootprintIndex(nil, "")}
+	for _, id := range []string{"m1-00", "m1-01", "m1-02"} {
+		meta.SetImageAttribute(ctx, id, "ingest", IngestRecord{Status: IngestIngested, MissionID: "m1"})
+	}
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+
+	// The GeoTIFF is 1 km by 500 m in UTM zone 33N, on the central meridian
+	// at about 36.14°N.
+	api.annotateFootprint(ctx, "m1-00", testGeoTIFF(t, testGeoInfo()), day)
+	meta.SetImageAttribute(ctx, "m1-01", "exif", map[string]string{"GPSLatitude": "-5", "GPSLongitude": "179.5"})
+	api.annotateFootprint(ctx, "m1-01", nil, day.Add(time.Hour))
+	api.annotateFootprint(ctx, "m1-02", testGeoTIFF(t, nil), day)
+
+	r, err := meta.ImageRecord(ctx, "m1-00")
+	if err != nil || r == nil || r.Footprint == nil {
+		t.Fatalf("m1-00 record %+v, %v", r, err)
+	}
+	if fp := r.Footprint; fp.Source != "geotiff" || len(fp.Polygon) != 5 || math.Abs(fp.BBox[0]-15) > 1e-4 || math.Abs(fp.BBox[3]-36.1447) > 1e-3 || fp.BBox[1] > fp.BBox[3] {
+		t.Errorf("m1-00 footprint %+v", fp)
+	}
+	if r, _ := meta.ImageRecord(ctx, "m1-02"); r != nil && r.Footprint != nil {
+		t.Errorf("m1-02 has a footprint %+v", r.Footprint)
+	}
+
+	router := gin.New()
+	router.GET("/images/search", api.getImageSearch)
+	search := func(query string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/search?"+query, nil))
+		var resp ImageSearchResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var ids []string
+		for _, img := range resp.Images {
+			ids = append(ids, img.ImageID)
+			if img.MissionID != "m1" || img.Footprint == nil {
+				t.Errorf("%s: %+v", query, img)
+			}
+		}
+		return w.Code, strings.Join(ids, ",")
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"bbox=14,36,16,37", "m1-00"},
+		{"bbox=15.2,36,16,37", ""},
+		{"bbox=170,-10,-170,10", "m1-01"},
+		{"bbox=10,-10,180,40&limit=5", "m1-00,m1-01"},
+		{"bbox=10,-10,180,40&start=2026-10-14T00:30:00Z", "m1-01"},
+		{"bbox=10,-10,180,40&end=1791936000", "m1-00"},
+	}
+	for _, tt := range tests {
+		if code, ids := search(tt.query); code != http.StatusOK || ids != tt.want {
+			t.Errorf("%s: %d %s, want %s", tt.query, code, ids, tt.want)
+		}
+	}
+	for _, q := range []string{"", "bbox=1,2,3", "bbox=0,50,10,40", "bbox=-180,-90,180,90", "bbox=0,0,1,1&start=soon", "bbox=0,0,1,1&start=100&end=50", "bbox=0,0,1,1&limit=0"} {
+		if code, _ := search(q); code != http.StatusBadRequest {
+			t.Errorf("%q: %d", q, code)
+		}
+	}
+
+	// Moving a footprint drops it from the cells it left.
+	meta.SetImageAttribute(ctx, "m1-01", "exif", map[string]string{"GPSLatitude": "40", "GPSLongitude": "-100"})
+	api.annotateFootprint(ctx, "m1-01", nil, day.Add(time.Hour))
+	if _, ids := search("bbox=170,-10,-170,10"); ids != "" {
+		t.Errorf("moved image still found: %s", ids)
+	}
+	if _, ids := search("bbox=-101,39,-99,41"); ids != "m1-01" {
+		t.Errorf("moved image found at %s", ids)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/tiff"
+)
+
+// OutputFormat describes an encoder the processing path can write to.
+type OutputFormat struct {
+	Name        string
+	ContentType string
+	Extension   string
+	encode      func(w io.Writer, img image.Image, opts EncodeOptions) error
+}
+
+// EncodeOptions carries the per-format encoder settings requested by the client.
+type EncodeOptions struct {
+	Quality     int
+	Lossless    bool
+	Compression string
+}
+
+const defaultQuality = 95
+
+// outputFormats is ordered by server preference, which breaks ties during
+// Accept-header negotiation.
+var outputFormats = []*OutputFormat{
+	{Name: "jpeg", ContentType: "image/jpeg", Extension: "jpg", encode: encodeJPEG},
+	{Name: "webp", ContentType: "image/webp", Extension: "webp", encode: encodeWebP},
+	{Name: "avif", ContentType: "image/avif", Extension: "avif", encode: encodeAVIF},
+	{Name: "png", ContentType: "image/png", Extension: "png", encode: encodePNG},
+	{Name: "tiff", ContentType: "image/tiff", Extension: "tif", encode: encodeTIFF},
+}
+
+var formatAliases = map[string]string{
+	"jpg": "jpeg",
+	"tif": "tiff",
+}
+
+func lookupFormat(name string) (*OutputFormat, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := formatAliases[name]; ok {
+		name = alias
+	}
+	for _, f := range outputFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// negotiateFormat picks the best output format for an Accept header. JPEG is
+// returned when the header is empty or nothing we can encode is acceptable.
+// Formats named in exclude are never picked.
+func negotiateFormat(accept string, exclude ...string) *OutputFormat {
+	if strings.TrimSpace(accept) == "" {
+		return outputFormats[0]
+	}
+
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(fields[0])), "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+
+	// More specific ranges take precedence over wildcards, per RFC 9110.
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return specificity(ranges[i].typ, ranges[i].subtype) > specificity(ranges[j].typ, ranges[j].subtype)
+	})
+
+	// At equal q, a format the client named explicitly beats one that only
+	// matched a wildcard, so "image/webp,image/*" yields WebP.
+	var best *OutputFormat
+	bestQ, bestSpec := 0.0, -1
+	for _, f := range outputFormats {
+		if slices.Contains(exclude, f.Name) {
+			continue
+		}
+		typ, subtype, _ := strings.Cut(f.ContentType, "/")
+		for _, r := range ranges {
+			if (r.typ == typ || r.typ == "*") && (r.subtype == subtype || r.subtype == "*") {
+				spec := specificity(r.typ, r.subtype)
+				if r.q > 0 && (r.q > bestQ || r.q == bestQ && spec > bestSpec) {
+					best, bestQ, bestSpec = f, r.q, spec
+				}
+				break
+			}
+		}
+	}
+	if best == nil {
+		return outputFormats[0]
+	}
+	return best
+}
+
+func specificity(typ, subtype string) int {
+	switch {
+	case typ == "*":
+		return 0
+	case subtype == "*":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// parseEncodeOptions reads quality, lossless and compression query values.
+func parseEncodeOptions(qualityStr, losslessStr, compression string) (EncodeOptions, error) {
+	opts := EncodeOptions{Quality: defaultQuality, Compression: strings.ToLower(compression)}
+
+	if qualityStr != "" {
+		q, err := strconv.Atoi(qualityStr)
+		if err != nil || q < 1 || q > 100 {
+			return opts, errors.New("Invalid 'quality' parameter. Must be an integer between 1 and 100.")
+		}
+		opts.Quality = q
+	}
+
+	if losslessStr != "" {
+		lossless, err := strconv.ParseBool(losslessStr)
+		if err != nil {
+			return opts, errors.New("Invalid 'lossless' parameter. Must be true or false.")
+		}
+		opts.Lossless = lossless
+	}
+
+	switch opts.Compression {
+	case "", "default", "none", "speed", "best":
+	default:
+		return opts, errors.New("Invalid 'compression' parameter. Must be one of default, none, speed, best.")
+	}
+
+	return opts, nil
+}
+
+func encodeJPEG(w io.Writer, img image.Image, opts EncodeOptions) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+}
+
+func encodePNG(w io.Writer, img image.Image, opts EncodeOptions) error {
+	enc := png.Encoder{CompressionLevel: png.DefaultCompression}
+	switch opts.Compression {
+	case "none":
+		enc.CompressionLevel = png.NoCompression
+	case "speed":
+		enc.CompressionLevel = png.BestSpeed
+	case "best":
+		enc.CompressionLevel = png.BestCompression
+	}
+	return enc.Encode(w, img)
+}
+
+func encodeWebP(w io.Writer, img image.Image, opts EncodeOptions) error {
+	return webp.Encode(w, img, webp.Options{Quality: opts.Quality, Lossless: opts.Lossless, Method: 4})
+}
+
+func encodeAVIF(w io.Writer, img image.Image, opts EncodeOptions) error {
+	return avif.Encode(w, img, avif.Options{
+		Quality:      opts.Quality,
+		QualityAlpha: opts.Quality,
+		Speed:        8,
+		Lossless:     opts.Lossless,
+	})
+}
+
+func encodeTIFF(w io.Writer, img image.Image, opts EncodeOptions) error {
+	compression := tiff.Deflate
+	if opts.Compression == "none" {
+		compression = tiff.Uncompressed
+	}
+	return tiff.Encode(w, img, &tiff.Options{Compression: compression})
+}
+
+func (f *OutputFormat) Encode(w io.Writer, img image.Image, opts EncodeOptions) error {
+	return f.encode(w, img, opts)
+}
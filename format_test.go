@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept, want string
+	}{
+		{"", "jpeg"},
+		{"   ", "jpeg"},
+		{"*/*", "jpeg"},
+		{"image/*", "jpeg"},
+		{"image/webp", "webp"},
+		{"image/webp,image/*", "webp"},
+		{"image/avif,image/webp,*/*;q=0.8", "webp"},
+		{"image/avif,*/*;q=0.8", "avif"},
+		{"image/webp;q=0.5,image/avif;q=0.9", "avif"},
+		{"image/webp;q=0.9,image/avif;q=0.9", "webp"},
+		{"IMAGE/PNG", "png"},
+		{"image/tiff, text/html", "tiff"},
+		{"image/webp;q=0,image/*;q=0.5", "jpeg"},
+		{"image/jpeg;q=0,image/png;q=0.1", "png"},
+		{"text/html,application/json", "jpeg"},
+		{"image/gif", "jpeg"},
+		{"garbage", "jpeg"},
+		{"image/webp;q=bogus", "webp"},
+	}
+	for _, tt := range tests {
+		if got := negotiateFormat(tt.accept).Name; got != tt.want {
+			t.Errorf("negotiateFormat(%q) = %s, want %s", tt.accept, got, tt.want)
+		}
+	}
+}
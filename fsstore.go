@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// fsMetaDir holds a JSON sidecar per object with the headers S3 would
+	// have stored alongside it. Objects are never stored under it.
+	fsMetaDir = ".meta"
+	// fsTempPrefix marks files still being written, which listings skip.
+	fsTempPrefix = ".tmp-"
+	fsMaxKeys    = 1000
+)
+
+// fsStore is an ObjectStore keeping bucket/key at <root>/<bucket>/<key>. It
+// serves the byte ranges, ETags and If-Match checks the handlers rely on, so
+// the server behaves as it does against S3, but it is meant for development
+// and single-instance installs rather than heavy concurrent writes. ETags
+// derive from the file's size and modification time, which keeps them in step
+// with the data without hashing it.
+type fsStore struct {
+	root string
+}
+
+// fsMeta is the sidecar of one object.
+type fsMeta struct {
+	ContentType  string            `json:"content_type,omitempty"`
+	CacheControl string            `json:"cache_control,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	// StorageClass is recorded but changes nothing about how the file is
+	// kept. An archived object cannot be read until RestoreObject marks it
+	// restored until Restored, which it does at once.
+	StorageClass string `json:"storage_class,omitempty"`
+	Restored     int64  `json:"restored,omitempty"`
+}
+
+// cold reports whether the object is archived and not restored.
+func (m fsMeta) cold(now time.Time) bool {
+	return isArchiveClass(s3types.StorageClass(m.StorageClass)) && m.Restored <= now.Unix()
+}
+
+// restore is the Restore header S3 sends for an object restored until
+// m.Restored.
+func (m fsMeta) restore() *string {
+	if m.Restored == 0 {
+		return nil
+	}
+	return aws.String(fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, time.Unix(m.Restored, 0).UTC().Format(http.TimeFormat)))
+}
+
+// fsStorageClass is what S3 stores for the class asked for, which is
+// STANDARD when none is.
+func fsStorageClass(class s3types.StorageClass) string {
+	if class == s3types.StorageClassStandard {
+		return ""
+	}
+	return string(class)
+}
+
+func newFSStore(root string) (*fsStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &fsStore{root: root}, nil
+}
+
+// paths returns the object and sidecar files for bucket/key, refusing names
+// that would escape the root.
+func (f *fsStore) paths(bucket, key *string) (data, meta string, err error) {
+	b, k := aws.ToString(bucket), aws.ToString(key)
+	if err := checkBucket(b); err != nil {
+		return "", "", err
+	}
+	if k == "" || strings.HasSuffix(k, "/") || !filepath.IsLocal(filepath.FromSlash(k)) ||
+		strings.HasPrefix(path.Base(k), fsTempPrefix) {
+		return "", "", fmt.Errorf("invalid key %q", k)
+	}
+	rel := filepath.Join(b, filepath.FromSlash(k))
+	return filepath.Join(f.root, rel), filepath.Join(f.root, fsMetaDir, rel+".json"), nil
+}
+
+func checkBucket(b string) error {
+	if b == "" || b == fsMetaDir || strings.ContainsAny(b, `/\`) || !filepath.IsLocal(b) {
+		return fmt.Errorf("invalid bucket %q", b)
+	}
+	return nil
+}
+
+func fsETag(fi fs.FileInfo) *string {
+	return aws.String(fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+}
+
+func (f *fsStore) readMeta(file string) fsMeta {
+	var m fsMeta
+	if data, err := os.ReadFile(file); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+func fsOptional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+func fsError(code, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
+
+// parseByteRange reads a "bytes=first-last", "bytes=first-" or "bytes=-suffix"
+// header against an object of size bytes.
+func parseByteRange(v string, size int64) (first, last int64, err error) {
+	spec, ok := strings.CutPrefix(v, "bytes=")
+	from, to, dash := strings.Cut(spec, "-")
+	if !ok || !dash {
+		return 0, 0, fsError("InvalidArgument", "unsupported range "+v)
+	}
+	if from == "" {
+		// The last suffix bytes, or all of a shorter object.
+		var suffix int64
+		if _, err := fmt.Sscan(to, &suffix); err != nil || suffix < 0 {
+			return 0, 0, fsError("InvalidArgument", "unsupported range "+v)
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, fsError("InvalidRange", "the requested range is not satisfiable")
+		}
+		return max(size-suffix, 0), size - 1, nil
+	}
+	if _, err := fmt.Sscan(from, &first); err != nil {
+		return 0, 0, fsError("InvalidArgument", "unsupported range "+v)
+	}
+	last = size - 1
+	if to != "" {
+		if _, err := fmt.Sscan(to, &last); err != nil || last < first {
+			return 0, 0, fsError("InvalidArgument", "unsupported range "+v)
+		}
+	}
+	if first >= size {
+		return 0, 0, fsError("InvalidRange", "the requested range is not satisfiable")
+	}
+	return first, min(last, size-1), nil
+}
+
+// fsBody is a ranged view of an open file that closes it.
+type fsBody struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (b fsBody) Close() error { return b.f.Close() }
+
+func (f *fsStore) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	dataPath, metaPath, err := f.paths(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	m := f.readMeta(metaPath)
+	if m.cold(time.Now()) {
+		return nil, fsError("InvalidObjectState", "the operation is not valid for the object's storage class")
+	}
+	file, err := os.Open(dataPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &s3types.NoSuchKey{Message: aws.String("no such key " + aws.ToString(in.Key))}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil || fi.IsDir() {
+		file.Close()
+		return nil, &s3types.NoSuchKey{Message: aws.String("no such key " + aws.ToString(in.Key))}
+	}
+	etag := fsETag(fi)
+	if in.IfMatch != nil && aws.ToString(in.IfMatch) != *etag {
+		file.Close()
+		return nil, fsError("PreconditionFailed", "at least one of the preconditions did not hold")
+	}
+
+	size := fi.Size()
+	first, last := int64(0), size-1
+	var contentRange *string
+	if in.Range != nil && size > 0 {
+		if first, last, err = parseByteRange(aws.ToString(in.Range), size); err != nil {
+			file.Close()
+			return nil, err
+		}
+		contentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+	}
+	return &s3.GetObjectOutput{
+		Body:          fsBody{io.NewSectionReader(file, first, last-first+1), file},
+		ContentLength: aws.Int64(last - first + 1),
+		ContentRange:  contentRange,
+		ContentType:   fsOptional(m.ContentType),
+		CacheControl:  fsOptional(m.CacheControl),
+		ETag:          etag,
+		LastModified:  aws.Time(fi.ModTime()),
+		Metadata:      m.Metadata,
+	}, nil
+}
+
+func (f *fsStore) HeadObject(_ context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	dataPath, metaPath, err := f.paths(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dataPath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && fi.IsDir()) {
+		return nil, &s3types.NotFound{Message: aws.String("not found")}
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := f.readMeta(metaPath)
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(fi.Size()),
+		ContentType:   fsOptional(m.ContentType),
+		CacheControl:  fsOptional(m.CacheControl),
+		ETag:          fsETag(fi),
+		LastModified:  aws.Time(fi.ModTime()),
+		Metadata:      m.Metadata,
+		StorageClass:  s3types.StorageClass(m.StorageClass),
+		Restore:       m.restore(),
+	}, nil
+}
+
+// RestoreObject marks an archived object readable for in's days.
+func (f *fsStore) RestoreObject(_ context.Context, in *s3.RestoreObjectInput, _ ...func(*s3.Options)) (*s3.RestoreObjectOutput, error) {
+	dataPath, metaPath, err := f.paths(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dataPath); errors.Is(err, fs.ErrNotExist) {
+		return nil, &s3types.NoSuchKey{Message: aws.String("no such key " + aws.ToString(in.Key))}
+	}
+	m := f.readMeta(metaPath)
+	if !isArchiveClass(s3types.StorageClass(m.StorageClass)) {
+		return nil, fsError("InvalidObjectState", "restore is not allowed for the object's storage class")
+	}
+	days := 1
+	if in.RestoreRequest != nil && in.RestoreRequest.Days != nil {
+		days = int(*in.RestoreRequest.Days)
+	}
+	m.Restored = time.Now().AddDate(0, 0, days).Unix()
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(metaPath, bytes.NewReader(meta)); err != nil {
+		return nil, err
+	}
+	return &s3.RestoreObjectOutput{}, nil
+}
+
+// HeadBucket reports whether the bucket's directory exists.
+func (f *fsStore) HeadBucket(_ context.Context, in *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	b := aws.ToString(in.Bucket)
+	if err := checkBucket(b); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filepath.Join(f.root, b))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !fi.IsDir()) {
+		return nil, &s3types.NotFound{Message: aws.String("bucket not found")}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+// write stores body and its sidecar, each through a rename so readers see
+// either the old object or the new one.
+func (f *fsStore) write(dataPath, metaPath string, body io.Reader, m fsMeta) (fs.FileInfo, error) {
+	if err := writeFileAtomic(dataPath, body); err != nil {
+		return nil, err
+	}
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(metaPath, bytes.NewReader(meta)); err != nil {
+		return nil, err
+	}
+	return os.Stat(dataPath)
+}
+
+func writeFileAtomic(name string, r io.Reader) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, fsTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		_, err = io.Copy(tmp, r)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// lowerKeys returns user metadata with lowercase names, as S3 returns it.
+func lowerKeys(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
+
+func (f *fsStore) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	dataPath, metaPath, err := f.paths(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.write(dataPath, metaPath, in.Body, fsMeta{
+		ContentType:  aws.ToString(in.ContentType),
+		CacheControl: aws.ToString(in.CacheControl),
+		Metadata:     lowerKeys(in.Metadata),
+		StorageClass: fsStorageClass(in.StorageClass),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectOutput{ETag: fsETag(fi)}, nil
+}
+
+// CopyObject copies within the store. CopySource is "bucket/key", optionally
+// URL-escaped; a REPLACE directive takes the headers from the request rather
+// than the source, which is how an object is copied onto itself to touch it.
+// As in S3, the copy has the storage class asked for, not the source's.
+func (f *fsStore) CopyObject(ctx context.Context, in *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	src := strings.TrimPrefix(aws.ToString(in.CopySource), "/")
+	srcBucket, srcKey, ok := strings.Cut(src, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid copy source %q", aws.ToString(in.CopySource))
+	}
+	if k, err := url.PathUnescape(srcKey); err == nil {
+		srcKey = k
+	}
+	dataPath, metaPath, err := f.paths(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	srcOut, err := f.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(srcBucket), Key: aws.String(srcKey)})
+	if err != nil {
+		return nil, err
+	}
+	defer srcOut.Body.Close()
+
+	m := fsMeta{
+		ContentType:  aws.ToString(srcOut.ContentType),
+		CacheControl: aws.ToString(srcOut.CacheControl),
+		Metadata:     srcOut.Metadata,
+	}
+	if in.MetadataDirective == s3types.MetadataDirectiveReplace {
+		m = fsMeta{
+			ContentType:  aws.ToString(in.ContentType),
+			CacheControl: aws.ToString(in.CacheControl),
+			Metadata:     lowerKeys(in.Metadata),
+		}
+	}
+	m.StorageClass = fsStorageClass(in.StorageClass)
+	fi, err := f.write(dataPath, metaPath, srcOut.Body, m)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3types.CopyObjectResult{
+		ETag:         fsETag(fi),
+		LastModified: aws.Time(fi.ModTime()),
+	}}, nil
+}
+
+func (f *fsStore) DeleteObjects(_ context.Context, in *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	out := &s3.DeleteObjectsOutput{}
+	if in.Delete == nil {
+		return out, nil
+	}
+	for _, obj := range in.Delete.Objects {
+		dataPath, metaPath, err := f.paths(in.Bucket, obj.Key)
+		if err == nil {
+			if err = os.Remove(dataPath); errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+			os.Remove(metaPath)
+		}
+		if err != nil {
+			out.Errors = append(out.Errors, s3types.Error{Key: obj.Key, Message: aws.String(err.Error())})
+			continue
+		}
+		if !aws.ToBool(in.Delete.Quiet) {
+			out.Deleted = append(out.Deleted, s3types.DeletedObject{Key: obj.Key})
+		}
+	}
+	return out, nil
+}
+
+// ListObjectsV2 lists keys in byte order. The continuation token is the last
+// key returned, so each page walks the prefix again; that is fine at the sizes
+// a local store holds.
+func (f *fsStore) ListObjectsV2(_ context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	bucket := aws.ToString(in.Bucket)
+	if err := checkBucket(bucket); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(f.root, bucket)
+	prefix := aws.ToString(in.Prefix)
+	after := max(aws.ToString(in.StartAfter), aws.ToString(in.ContinuationToken))
+	limit := int(aws.ToInt32(in.MaxKeys))
+	if limit <= 0 || limit > fsMaxKeys {
+		limit = fsMaxKeys
+	}
+
+	// Walk only the deepest directory the prefix names.
+	start := base
+	if dir := path.Dir(prefix); strings.Contains(prefix, "/") && filepath.IsLocal(filepath.FromSlash(dir)) {
+		start = filepath.Join(base, filepath.FromSlash(dir))
+	}
+	var objects []s3types.Object
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), fsTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		class := s3types.ObjectStorageClassStandard
+		if _, metaPath, err := f.paths(in.Bucket, &key); err == nil {
+			if c := f.readMeta(metaPath).StorageClass; c != "" {
+				class = s3types.ObjectStorageClass(c)
+			}
+		}
+		objects = append(objects, s3types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(fi.Size()),
+			ETag:         fsETag(fi),
+			LastModified: aws.Time(fi.ModTime()),
+			StorageClass: class,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return *objects[i].Key < *objects[j].Key })
+
+	out := &s3.ListObjectsV2Output{Name: in.Bucket, Prefix: in.Prefix, IsTruncated: aws.Bool(false)}
+	if len(objects) > limit {
+		objects = objects[:limit]
+		out.IsTruncated = aws.Bool(true)
+		out.NextContinuationToken = objects[limit-1].Key
+	}
+	out.Contents = objects
+	out.KeyCount = aws.Int32(int32(len(objects)))
+	return out, nil
+}
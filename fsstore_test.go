@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gin-gonic/gin"
+)
+
+func testFSStore(t *testing.T) *fsStore {
+	t.Helper()
+	f, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func putTestObject(t *testing.T, f *fsStore, key string, data []byte) *s3.PutObjectOutput {
+	t.Helper()
+	out, err := f.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:       aws.String("bucket"),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(data),
+		ContentType:  aws.String("image/tiff"),
+		CacheControl: aws.String("max-age=60"),
+		Metadata:     map[string]string{"Source": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestFSStoreGetObject(t *testing.T) {
+	f := testFSStore(t)
+	ctx := context.Background()
+	data := testObject(1000)
+	put := putTestObject(t, f, "images/x.tif", data)
+
+	tests := []struct {
+		name      string
+		rng       string
+		ifMatch   *string
+		want      []byte
+		wantRange string
+		wantErr   bool
+	}{
+		{name: "whole", want: data},
+		{name: "closed range", rng: "bytes=10-19", want: data[10:20], wantRange: "bytes 10-19/1000"},
+		{name: "open range", rng: "bytes=990-", want: data[990:], wantRange: "bytes 990-999/1000"},
+		{name: "range past end", rng: "bytes=900-4999", want: data[900:], wantRange: "bytes 900-999/1000"},
+		{name: "unsatisfiable", rng: "bytes=1000-1100", wantErr: true},
+		{name: "suffix range", rng: "bytes=-10", want: data[990:], wantRange: "bytes 990-999/1000"},
+		{name: "suffix past start", rng: "bytes=-5000", want: data, wantRange: "bytes 0-999/1000"},
+		{name: "empty suffix", rng: "bytes=-0", wantErr: true},
+		{name: "malformed range", rng: "bytes=x-", wantErr: true},
+		{name: "matching etag", ifMatch: put.ETag, want: data},
+		{name: "stale etag", ifMatch: aws.String(`"old"`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("images/x.tif"), IfMatch: tt.ifMatch}
+			if tt.rng != "" {
+				in.Range = aws.String(tt.rng)
+			}
+			out, err := f.GetObject(ctx, in)
+			if tt.wantErr {
+				if err == nil {
+					out.Body.Close()
+					t.Fatal("GetObject succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Body.Close()
+			got, err := io.ReadAll(out.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) || aws.ToInt64(out.ContentLength) != int64(len(tt.want)) {
+				t.Errorf("read %d bytes (ContentLength %d), want %d", len(got), aws.ToInt64(out.ContentLength), len(tt.want))
+			}
+			if aws.ToString(out.ContentRange) != tt.wantRange {
+				t.Errorf("ContentRange = %q, want %q", aws.ToString(out.ContentRange), tt.wantRange)
+			}
+			if aws.ToString(out.ETag) != aws.ToString(put.ETag) || aws.ToString(out.ContentType) != "image/tiff" ||
+				aws.ToString(out.CacheControl) != "max-age=60" || out.Metadata["source"] != "test" {
+				t.Errorf("headers not kept: %q %q %q %v", aws.ToString(out.ETag), aws.ToString(out.ContentType),
+					aws.ToString(out.CacheControl), out.Metadata)
+			}
+		})
+	}
+}
+
+func TestGetImageRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f := testFSStore(t)
+	data := testObject(1000)
+	putTestObject(t, f, imageKey("x"), data)
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: f, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	router := gin.New()
+	router.GET("/image/:id", api.getSatImageByID)
+
+	tests := []struct {
+		path, rng string
+		want      int
+	}{
+		{"/image/x", "bytes=-10", http.StatusPartialContent},
+		{"/image/x", "bytes=1000-", http.StatusRequestedRangeNotSatisfiable},
+		{"/image/x", "bytes=x-", http.StatusRequestedRangeNotSatisfiable},
+		{"/image/gone", "bytes=-10", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("Range", tt.rng)
+		router.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("GET %s with %s: %d, want %d", tt.path, tt.rng, w.Code, tt.want)
+		}
+		if w.Code == http.StatusPartialContent && !bytes.Equal(w.Body.Bytes(), data[990:]) {
+			t.Errorf("GET %s with %s: %d bytes", tt.path, tt.rng, w.Body.Len())
+		}
+	}
+}
+
+func TestFSStoreMissing(t *testing.T) {
+	f := testFSStore(t)
+	ctx := context.Background()
+	_, err := f.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("images/none.tif")})
+	if !isNotFound(err) {
+		t.Errorf("GetObject of a missing key: %v", err)
+	}
+	_, err = f.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("images/none.tif")})
+	if !isNotFound(err) {
+		t.Errorf("HeadObject of a missing key: %v", err)
+	}
+	for _, key := range []string{"../escape", "images/../../escape", "/abs", "images/", ""} {
+		if _, err := f.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key)}); err == nil || isNotFound(err) {
+			t.Errorf("key %q was not refused: %v", key, err)
+		}
+	}
+	if _, err := f.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(".."), Key: aws.String("x")}); err == nil {
+		t.Error("bucket .. was not refused")
+	}
+}
+
+func TestFSStoreCopyReplace(t *testing.T) {
+	f := testFSStore(t)
+	ctx := context.Background()
+	putTestObject(t, f, "derived/a.jpg", []byte("jpeg"))
+
+	_, err := f.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String("bucket"),
+		Key:               aws.String("derived/a.jpg"),
+		CopySource:        aws.String("bucket/derived/a.jpg"),
+		ContentType:       aws.String("image/jpeg"),
+		Metadata:          map[string]string{"touched": "1"},
+		MetadataDirective: s3types.MetadataDirectiveReplace,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := f.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("derived/a.jpg")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToInt64(out.ContentLength) != 4 || aws.ToString(out.ContentType) != "image/jpeg" ||
+		out.CacheControl != nil || out.Metadata["touched"] != "1" || out.Metadata["source"] != "" {
+		t.Errorf("copy onto itself left %d bytes, %q, %v, %v", aws.ToInt64(out.ContentLength),
+			aws.ToString(out.ContentType), out.CacheControl, out.Metadata)
+	}
+}
+
+func TestFSStoreListAndDelete(t *testing.T) {
+	f := testFSStore(t)
+	ctx := context.Background()
+	var want []string
+	for i := range 7 {
+		key := fmt.Sprintf("derived/img%d/%02d.jpg", i%3, i)
+		putTestObject(t, f, key, []byte{byte(i)})
+		want = append(want, key)
+	}
+	putTestObject(t, f, "images/other.tif", []byte("x"))
+	slices.Sort(want)
+
+	var got []string
+	pages := s3.NewListObjectsV2Paginator(f, &s3.ListObjectsV2Input{
+		Bucket:  aws.String("bucket"),
+		Prefix:  aws.String("derived/"),
+		MaxKeys: aws.Int32(3),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Contents) > 3 {
+			t.Errorf("page of %d objects, want at most 3", len(page.Contents))
+		}
+		for _, obj := range page.Contents {
+			got = append(got, aws.ToString(obj.Key))
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+
+	_, err := f.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String("bucket"),
+		Delete: &s3types.Delete{Objects: []s3types.ObjectIdentifier{
+			{Key: aws.String(want[0])}, {Key: aws.String("derived/never-existed.jpg")},
+		}, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := f.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("bucket"), Prefix: aws.String("derived/img0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 2 || aws.ToString(list.Contents[0].Key) == want[0] {
+		t.Errorf("after delete listed %d objects under derived/img0", len(list.Contents))
+	}
+}
+
+func TestFSStoreReadObject(t *testing.T) {
+	defer func(size int64) { downloadPartSize = size }(downloadPartSize)
+	downloadPartSize = 1 << 20
+	f := testFSStore(t)
+	ctx := context.Background()
+	want := testObject(3<<20 + 17)
+	putTestObject(t, f, "images/big.tif", want)
+
+	out, err := f.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("images/big.tif"),
+		Range:  firstPart(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Body.Close()
+	buf, err := (&API{S3: f}).readObject(ctx, "bucket", "images/big.tif", out, out.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer putBuffer(buf)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Error("ranged read through the filesystem store differs from the source")
+	}
+}
+
+func TestFSStoreHeadBucket(t *testing.T) {
+	f := testFSStore(t)
+	ctx := context.Background()
+	if _, err := f.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("bucket")}); !isNotFound(err) {
+		t.Errorf("HeadBucket before any write error = %v, want NotFound", err)
+	}
+	putTestObject(t, f, "a.tif", []byte("x"))
+	if _, err := f.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("bucket")}); err != nil {
+		t.Errorf("HeadBucket = %v", err)
+	}
+	if _, err := f.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("../x")}); err == nil || isNotFound(err) {
+		t.Errorf("HeadBucket(../x) error = %v, want invalid bucket", err)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// The encounter is found by sampling the range every geometrySearchStep
+	// and refining around the closest sample to geometryTolerance.
+	geometrySearchStep = 30 * time.Second
+	geometryTolerance  = time.Millisecond
+	// Without a collection window or ?start=&end=, the search covers
+	// geometryDefaultSpan either side of the stored TCA.
+	geometryDefaultSpan = 6 * time.Hour
+	geometryMaxWindow   = 7 * 24 * time.Hour
+)
+
+// MissionGeometry is the closest approach of a mission's target to its
+// observer.
+type MissionGeometry struct {
+	// TCA is the unix time of closest approach.
+	TCA                 int64   `json:"tca"`
+	MinRangeKM          float64 `json:"min_range_km"`
+	RelativeVelocityKMS float64 `json:"relative_velocity_kms"`
+	// Feasibility is left nil by closestApproach.
+	Feasibility *Feasibility `json:"feasibility,omitempty"`
+}
+
+// closestApproach finds when target comes nearest observer between start
+// and end.
+func closestApproach(target, observer *sgp4, start, end time.Time) (MissionGeometry, error) {
+	var err error
+	rangeAt := func(t time.Time) float64 {
+		a, aerr := target.At(t)
+		b, berr := observer.At(t)
+		if aerr != nil || berr != nil {
+			err = errors.Join(aerr, berr)
+			return math.Inf(1)
+		}
+		return distance(a.Position, b.Position)
+	}
+
+	best, bestRange := start, math.Inf(1)
+	for t := start; !t.After(end); t = t.Add(geometrySearchStep) {
+		if r := rangeAt(t); r < bestRange {
+			best, bestRange = t, r
+		}
+		if err != nil {
+			return MissionGeometry{}, fmt.Errorf("at %s: %w", t.Format(time.RFC3339), err)
+		}
+	}
+
+	// Golden-section search of the steps either side of the closest sample.
+	lo, hi := best.Add(-geometrySearchStep), best.Add(geometrySearchStep)
+	if lo.Before(start) {
+		lo = start
+	}
+	if hi.After(end) {
+		hi = end
+	}
+	const ratio = 0.6180339887498949
+	for hi.Sub(lo) > geometryTolerance {
+		span := float64(hi.Sub(lo))
+		a := lo.Add(time.Duration(span * (1 - ratio)))
+		b := lo.Add(time.Duration(span * ratio))
+		if rangeAt(a) < rangeAt(b) {
+			hi = b
+		} else {
+			lo = a
+		}
+	}
+	tca := lo.Add(hi.Sub(lo) / 2).Round(time.Second)
+	a, aerr := target.At(tca)
+	b, berr := observer.At(tca)
+	if err := errors.Join(err, aerr, berr); err != nil {
+		return MissionGeometry{}, fmt.Errorf("at %s: %w", tca.Format(time.RFC3339), err)
+	}
+	return MissionGeometry{
+		TCA:                 tca.Unix(),
+		MinRangeKM:          math.Round(distance(a.Position, b.Position)*1000) / 1000,
+		RelativeVelocityKMS: math.Round(distance(a.Velocity, b.Velocity)*1e6) / 1e6,
+	}, nil
+}
+
+func distance(a, b [3]float64) float64 {
+	return math.Sqrt((a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2]))
+}
+
+// orbitPair models a target and an observer.
+type orbitPair struct {
+	target, observer       *sgp4
+	targetTLE, observerTLE TLE
+}
+
+// orbits models the target and observer from their element sets nearest at.
+// Problems name the satellite they are about.
+func (api *API) orbits(ctx context.Context, targetID, observerID string, at time.Time) (orbitPair, error) {
+	var pair orbitPair
+	for _, sat := range []struct {
+		role, id string
+		p        **sgp4
+		tle      *TLE
+	}{
+		{"target", targetID, &pair.target, &pair.targetTLE},
+		{"observer", observerID, &pair.observer, &pair.observerTLE},
+	} {
+		p, tle, err := api.orbit(ctx, sat.id, at)
+		if err != nil {
+			var problem *Problem
+			if errors.As(err, &problem) {
+				problem.Detail = fmt.Sprintf("%s %s: %s", sat.role, sat.id, problem.Detail)
+			}
+			return orbitPair{}, err
+		}
+		*sat.p, *sat.tle = p, tle
+	}
+	return pair, nil
+}
+
+// RecomputeGeometryResponse is the body of POST
+// /missions/:id/recompute-geometry.
+type RecomputeGeometryResponse struct {
+	Mission *Mission `json:"mission"`
+	// TargetTLE and ObserverTLE are the element sets propagated.
+	TargetTLE   TLE `json:"target_tle"`
+	ObserverTLE TLE `json:"observer_tle"`
+	// Coverage is what the observer's sensor sees of the target at TCA,
+	// when it has one registered.
+	Coverage *SensorCoverage `json:"coverage,omitempty"`
+}
+
+// postRecomputeGeometry propagates the mission's target and observer from
+// their element sets nearest the search window and stores the TCA, minimum
+// range and relative velocity found, with the collection's feasibility at
+// TCA under the access window constraints. The window is ?start= to ?end=,
+// or the collection window, or geometryDefaultSpan either side of the stored
+// TCA. ?reject_infeasible=true refuses to store an infeasible collection.
+func (api *API) postRecomputeGeometry(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	k, err := parseAccessConstraints(c)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	reject, err := strconv.ParseBool(c.DefaultQuery("reject_infeasible", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'reject_infeasible' parameter. Must be true or false.")
+		return
+	}
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+
+	var start, end time.Time
+	switch {
+	case c.Query("start") != "" || c.Query("end") != "":
+		var serr, eerr error
+		start, serr = parseAuditTime(c.Query("start"))
+		end, eerr = parseAuditTime(c.Query("end"))
+		if serr != nil || eerr != nil || !end.After(start) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'start'/'end' parameters. Both must be unix times or RFC 3339 times, with end after start.")
+			return
+		}
+	case mission.CollectionWindowEnd > mission.CollectionWindowStart && mission.CollectionWindowStart > 0:
+		start, end = time.Unix(mission.CollectionWindowStart, 0), time.Unix(mission.CollectionWindowEnd, 0)
+	case mission.TCA != 0:
+		tca := time.Unix(mission.TCA, 0)
+		start, end = tca.Add(-geometryDefaultSpan), tca.Add(geometryDefaultSpan)
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "The mission has no collection window or TCA to search around. Give 'start' and 'end'.")
+		return
+	}
+	if end.Sub(start) > geometryMaxWindow {
+		respondProblem(c, newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("the search window may be at most %d seconds", int(geometryMaxWindow.Seconds()))).
+			with("parameter", "end").with("limit", int(geometryMaxWindow.Seconds())))
+		return
+	}
+
+	if mission.TargetSatelliteID == "" || mission.ObserverSatelliteID == "" {
+		respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, "the mission needs a target and an observer satellite")
+		return
+	}
+	pair, err := api.orbits(ctx, mission.TargetSatelliteID, mission.ObserverSatelliteID, start.Add(end.Sub(start)/2))
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+
+	g, err := closestApproach(pair.target, pair.observer, start.UTC(), end.UTC())
+	if err != nil {
+		respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, err.Error())
+		return
+	}
+	feasibility, err := assessFeasibility(pair, k, time.Unix(g.TCA, 0).UTC())
+	if err != nil {
+		respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, err.Error())
+		return
+	}
+	if !feasibility.Feasible {
+		if reject {
+			respondProblem(c, newProblem(http.StatusUnprocessableEntity, CodeCollectionInfeasible, strings.Join(feasibility.Issues, "; ")).
+				with("feasibility", feasibility))
+			return
+		}
+		slog.WarnContext(ctx, "mission collection is infeasible", "id", id, "tca", g.TCA, "issues", feasibility.Issues)
+	}
+	g.Feasibility = &feasibility
+	if err := api.MissionDB.SetGeometry(ctx, id, g); err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to store mission geometry", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to update mission")
+		return
+	}
+	api.missionChanged(ctx, id)
+	slog.InfoContext(ctx, "recomputed mission geometry", "id", id, "tca", g.TCA, "min_range_km", g.MinRangeKM, "feasibility", feasibility.Score)
+
+	out := RecomputeGeometryResponse{TargetTLE: pair.targetTLE, ObserverTLE: pair.observerTLE}
+	out.Coverage = api.sensorCoverage(mission.ObserverSatelliteID, mission.TargetSatelliteID, g.MinRangeKM)
+	if out.Mission, err = api.loadMission(ctx, id); err != nil {
+		slog.ErrorContext(ctx, "failed to reload mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, out)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shiftedISS is the ISS element set with its node a degree further east, so
+// the two orbits cross.
+func shiftedISS(t *testing.T) TLE {
+	t.Helper()
+	line2 := issLine2[:17] + "248.4627" + issLine2[25:]
+	line2 = line2[:tleLineLen-1] + string(tleChecksum(line2))
+	tle, _, err := parseTLE(issLine1, line2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tle
+}
+
+func TestClosestApproach(t *testing.T) {
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	target, _ := newSGP4(iss)
+	observer, err := newSGP4(shiftedISS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := iss.Epoch.Truncate(time.Second)
+	end := start.Add(2 * time.Hour)
+	g, err := closestApproach(target, observer, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every second of the window is no nearer than what was found.
+	best, bestRange := start, math.Inf(1)
+	for at := start; !at.After(end); at = at.Add(time.Second) {
+		a, _ := target.At(at)
+		b, _ := observer.At(at)
+		if r := distance(a.Position, b.Position); r < bestRange {
+			best, bestRange = at, r
+		}
+	}
+	if d := time.Unix(g.TCA, 0).Sub(best); d < -time.Second || d > time.Second || g.MinRangeKM > bestRange+0.01 {
+		t.Errorf("closest approach %+v, want near %v at %.3f km", g, best, bestRange)
+	}
+	if g.MinRangeKM <= 0 || g.MinRangeKM > 200 || g.RelativeVelocityKMS <= 0 {
+		t.Errorf("implausible geometry %+v", g)
+	}
+}
+
+func TestRecomputeGeometry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	shifted := shiftedISS(t)
+	epoch := iss.Epoch.Unix()
+	missions := missionMap{
+		"m1": {ID: "m1", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", CollectionWindowStart: epoch + 1800, CollectionWindowEnd: epoch + 3600, TCA: 1},
+		"m2": {ID: "m2", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser"},
+		"m3": {ID: "m3", TargetSatelliteID: "nope", ObserverSatelliteID: "chaser", TCA: epoch},
+	}
+	api := &API{MissionDB: missions, Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, "")}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive, TLE: &iss})
+	api.Satellites.Put(ctx, Satellite{ID: "chaser", NoradID: 25544, Name: "Chaser", Status: SatelliteActive, TLE: &shifted})
+	router := gin.New()
+	router.POST("/missions/:id/recompute-geometry", api.postRecomputeGeometry)
+	do := func(path string) (*httptest.ResponseRecorder, map[string]any) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	tests := []struct {
+		path   string
+		want   int
+		code   ErrorCode
+		detail string
+	}{
+		{"/missions/none/recompute-geometry", http.StatusNotFound, CodeMissionNotFound, ""},
+		{"/missions/m2/recompute-geometry", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"/missions/m2/recompute-geometry?start=100", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"/missions/m2/recompute-geometry?start=0&end=1000000", http.StatusBadRequest, CodeLimitExceeded, ""},
+		{"/missions/m3/recompute-geometry", http.StatusNotFound, CodeSatelliteNotFound, "target nope: satellite not found"},
+		{"/missions/m1/recompute-geometry?reject_infeasible=maybe", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"/missions/m1/recompute-geometry?min_sun_angle=-1", http.StatusBadRequest, CodeInvalidParameter, ""},
+		{"/missions/m1/recompute-geometry?reject_infeasible=true&max_range_km=1", http.StatusUnprocessableEntity, CodeCollectionInfeasible, ""},
+	}
+	for _, tt := range tests {
+		w, body := do(tt.path)
+		if w.Code != tt.want || body["code"] != string(tt.code) || tt.detail != "" && body["detail"] != tt.detail {
+			t.Errorf("%s: %d %v, want %d %s", tt.path, w.Code, body, tt.want, tt.code)
+		}
+	}
+	if m := missions["m1"]; m.TCA != 1 || m.Feasibility != nil {
+		t.Errorf("a rejected collection was stored: %+v", m)
+	}
+
+	w, body := do("/missions/m1/recompute-geometry")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	m := missions["m1"]
+	if m.TCA < epoch || m.TCA > epoch+7200 || m.MinRangeKM > 200 || m.RelativeVelocityKMS == 0 || m.GeometryUpdatedAt == 0 {
+		t.Errorf("stored geometry %+v", m)
+	}
+	if m.Feasibility == nil || !m.Feasibility.Feasible || m.Feasibility.Score <= 0 {
+		t.Errorf("stored feasibility %+v", m.Feasibility)
+	}
+	mission, _ := body["mission"].(map[string]any)
+	feasibility, _ := mission["feasibility"].(map[string]any)
+	if mission["tca"] != float64(m.TCA) || feasibility["score"] != m.Feasibility.Score || body["observer_tle"].(map[string]any)["line2"] != shifted.Line2 {
+		t.Errorf("response %v", body)
+	}
+
+	// An infeasible collection is stored with its issues unless rejected.
+	if w, _ := do("/missions/m1/recompute-geometry?max_range_km=1"); w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if f := missions["m1"].Feasibility; f == nil || f.Feasible || len(f.Issues) == 0 {
+		t.Errorf("stored feasibility %+v", f)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+)
+
+// TIFF tag numbers used for georeferencing.
+const (
+	tagImageWidth          = 256
+	tagImageLength         = 257
+	tagModelPixelScale     = 33550
+	tagModelTiepoint       = 33922
+	tagModelTransformation = 34264
+	tagGeoKeyDirectory     = 34735
+	tagGeoDoubleParams     = 34736
+	tagGeoAsciiParams      = 34737
+)
+
+// GeoKey IDs that identify the coordinate reference system.
+const (
+	geoKeyGeographicType = 2048
+	geoKeyProjectedType  = 3072
+)
+
+const (
+	tiffASCII  = 2
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffDouble = 12
+)
+
+// maxTIFFValueBytes bounds a single tag's value, so a corrupt count cannot
+// force a huge allocation.
+const maxTIFFValueBytes = 64 << 20
+
+var errNotTIFF = errors.New("not a TIFF file")
+
+// GeoInfo is the georeferencing carried by a GeoTIFF.
+type GeoInfo struct {
+	CRS string `json:"crs,omitempty"`
+	// GeoTransform follows the GDAL convention: origin X, pixel width, row
+	// rotation, origin Y, column rotation, pixel height.
+	GeoTransform [6]float64        `json:"geotransform"`
+	Corners      GeoCorners        `json:"corners"`
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	tags         map[uint16]geoTag `json:"-"`
+}
+
+// GeoCorners are the model-space coordinates of the image corners and centre.
+type GeoCorners struct {
+	UpperLeft  [2]float64 `json:"upper_left"`
+	UpperRight [2]float64 `json:"upper_right"`
+	LowerLeft  [2]float64 `json:"lower_left"`
+	LowerRight [2]float64 `json:"lower_right"`
+	Center     [2]float64 `json:"center"`
+}
+
+type geoTag struct {
+	datatype uint16
+	shorts   []uint16
+	doubles  []float64
+	ascii    string
+}
+
+type tiffEntry struct {
+	tag, datatype uint16
+	count         uint32
+	value         []byte
+}
+
+// readTIFFEntries parses the first IFD of a classic (non-Big) TIFF.
+func readTIFFEntries(data []byte) (binary.ByteOrder, []tiffEntry, error) {
+	r := bytes.NewReader(data)
+	order, off, err := readTIFFHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, _, err := readIFD(r, order, off)
+	return order, entries, err
+}
+
+func readTIFFHeader(r io.ReaderAt) (binary.ByteOrder, int64, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, 0, errNotTIFF
+	}
+	var order binary.ByteOrder
+	switch string(header[0:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errNotTIFF
+	}
+	return order, int64(order.Uint32(header[4:8])), nil
+}
+
+// readIFD parses the IFD at off and returns its entries and the offset of the
+// next IFD, which is zero for the last one.
+func readIFD(r io.ReaderAt, order binary.ByteOrder, off int64) ([]tiffEntry, int64, error) {
+	var countBuf [2]byte
+	if _, err := r.ReadAt(countBuf[:], off); err != nil {
+		return nil, 0, errors.New("tiff: IFD offset out of range")
+	}
+	n := int(order.Uint16(countBuf[:]))
+
+	raw := make([]byte, n*12+4)
+	if _, err := r.ReadAt(raw, off+2); err != nil {
+		return nil, 0, errors.New("tiff: IFD truncated")
+	}
+
+	entries := make([]tiffEntry, 0, n)
+	for i := 0; i < n; i++ {
+		field := raw[i*12 : i*12+12]
+		e := tiffEntry{
+			tag:      order.Uint16(field[0:2]),
+			datatype: order.Uint16(field[2:4]),
+			count:    order.Uint32(field[4:8]),
+		}
+		size := int64(e.count) * int64(tiffTypeSize(e.datatype))
+		if size > maxTIFFValueBytes {
+			return nil, 0, fmt.Errorf("tiff: tag %d value too large", e.tag)
+		}
+		if size <= 4 {
+			e.value = field[8 : 8+size]
+		} else {
+			e.value = make([]byte, size)
+			if _, err := r.ReadAt(e.value, int64(order.Uint32(field[8:12]))); err != nil {
+				return nil, 0, fmt.Errorf("tiff: tag %d value out of range", e.tag)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, int64(order.Uint32(raw[n*12:])), nil
+}
+
+// entryUints decodes a SHORT or LONG entry.
+func entryUints(order binary.ByteOrder, e tiffEntry) []uint64 {
+	var out []uint64
+	switch e.datatype {
+	case tiffShort:
+		for i := 0; i+2 <= len(e.value); i += 2 {
+			out = append(out, uint64(order.Uint16(e.value[i:])))
+		}
+	case tiffLong:
+		for i := 0; i+4 <= len(e.value); i += 4 {
+			out = append(out, uint64(order.Uint32(e.value[i:])))
+		}
+	}
+	return out
+}
+
+func longEntry(order binary.ByteOrder, tag uint16, values ...uint32) tiffEntry {
+	e := tiffEntry{tag: tag, datatype: tiffLong, count: uint32(len(values)), value: make([]byte, 4*len(values))}
+	for i, v := range values {
+		order.PutUint32(e.value[i*4:], v)
+	}
+	return e
+}
+
+func tiffTypeSize(datatype uint16) int {
+	switch datatype {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 0
+}
+
+func decodeTag(order binary.ByteOrder, e tiffEntry) geoTag {
+	t := geoTag{datatype: e.datatype}
+	switch e.datatype {
+	case tiffShort:
+		for i := 0; i+2 <= len(e.value); i += 2 {
+			t.shorts = append(t.shorts, order.Uint16(e.value[i:]))
+		}
+	case tiffDouble:
+		for i := 0; i+8 <= len(e.value); i += 8 {
+			t.doubles = append(t.doubles, math.Float64frombits(order.Uint64(e.value[i:])))
+		}
+	case tiffASCII:
+		t.ascii = string(e.value)
+	}
+	return t
+}
+
+// parseGeoTIFF extracts georeferencing from a TIFF. It returns nil without an
+// error for TIFFs that carry no geo tags.
+func parseGeoTIFF(data []byte) (*GeoInfo, error) {
+	order, entries, err := readTIFFEntries(data)
+	if err != nil {
+		return nil, err
+	}
+	return geoFromEntries(order, entries), nil
+}
+
+func geoFromEntries(order binary.ByteOrder, entries []tiffEntry) *GeoInfo {
+	geo := &GeoInfo{tags: map[uint16]geoTag{}}
+	for _, e := range entries {
+		switch e.tag {
+		case tagImageWidth, tagImageLength:
+			var v int
+			if vals := entryUints(order, e); len(vals) > 0 {
+				v = int(vals[0])
+			}
+			if e.tag == tagImageWidth {
+				geo.Width = v
+			} else {
+				geo.Height = v
+			}
+		case tagModelPixelScale, tagModelTiepoint, tagModelTransformation,
+			tagGeoKeyDirectory, tagGeoDoubleParams, tagGeoAsciiParams:
+			geo.tags[e.tag] = decodeTag(order, e)
+		}
+	}
+
+	_, hasTiepoint := geo.tags[tagModelTiepoint]
+	_, hasTransform := geo.tags[tagModelTransformation]
+	if !hasTiepoint && !hasTransform {
+		return nil
+	}
+
+	geo.resolve()
+	return geo
+}
+
+// resolve derives the CRS, geotransform and corners from the raw tags.
+func (g *GeoInfo) resolve() {
+	if m := g.tags[tagModelTransformation].doubles; len(m) >= 16 {
+		g.GeoTransform = [6]float64{m[3], m[0], m[1], m[7], m[4], m[5]}
+	} else if tp := g.tags[tagModelTiepoint].doubles; len(tp) >= 6 {
+		scale := g.tags[tagModelPixelScale].doubles
+		sx, sy := 1.0, 1.0
+		if len(scale) >= 2 {
+			sx, sy = scale[0], scale[1]
+		}
+		g.GeoTransform = [6]float64{tp[3] - tp[0]*sx, sx, 0, tp[4] + tp[1]*sy, 0, -sy}
+	}
+
+	g.CRS = ""
+	if keys := g.tags[tagGeoKeyDirectory].shorts; len(keys) >= 4 {
+		for i := 4; i+3 < len(keys); i += 4 {
+			id, location, value := keys[i], keys[i+1], keys[i+3]
+			if location != 0 {
+				continue
+			}
+			switch id {
+			case geoKeyProjectedType:
+				g.CRS = fmt.Sprintf("EPSG:%d", value)
+			case geoKeyGeographicType:
+				if g.CRS == "" {
+					g.CRS = fmt.Sprintf("EPSG:%d", value)
+				}
+			}
+		}
+	}
+
+	w, h := float64(g.Width), float64(g.Height)
+	g.Corners = GeoCorners{
+		UpperLeft:  g.pixelToModel(0, 0),
+		UpperRight: g.pixelToModel(w, 0),
+		LowerLeft:  g.pixelToModel(0, h),
+		LowerRight: g.pixelToModel(w, h),
+		Center:     g.pixelToModel(w/2, h/2),
+	}
+}
+
+func (g *GeoInfo) pixelToModel(px, py float64) [2]float64 {
+	t := g.GeoTransform
+	return [2]float64{t[0] + px*t[1] + py*t[2], t[3] + px*t[4] + py*t[5]}
+}
+
+// resized returns a copy of g describing the same footprint at a new size.
+func (g *GeoInfo) resized(width, height int) *GeoInfo {
+	if width == g.Width && height == g.Height || width == 0 || height == 0 {
+		return g
+	}
+	sx := float64(g.Width) / float64(width)
+	sy := float64(g.Height) / float64(height)
+
+	out := &GeoInfo{Width: width, Height: height, tags: make(map[uint16]geoTag, len(g.tags))}
+	for tag, t := range g.tags {
+		out.tags[tag] = t
+	}
+
+	if t, ok := out.tags[tagModelPixelScale]; ok && len(t.doubles) >= 2 {
+		d := append([]float64(nil), t.doubles...)
+		d[0] *= sx
+		d[1] *= sy
+		out.tags[tagModelPixelScale] = geoTag{datatype: tiffDouble, doubles: d}
+	}
+	if t, ok := out.tags[tagModelTiepoint]; ok && len(t.doubles) >= 6 {
+		d := append([]float64(nil), t.doubles...)
+		for i := 0; i+5 < len(d); i += 6 {
+			d[i] /= sx
+			d[i+1] /= sy
+		}
+		out.tags[tagModelTiepoint] = geoTag{datatype: tiffDouble, doubles: d}
+	}
+	if t, ok := out.tags[tagModelTransformation]; ok && len(t.doubles) >= 16 {
+		d := append([]float64(nil), t.doubles...)
+		d[0], d[4] = d[0]*sx, d[4]*sx
+		d[1], d[5] = d[1]*sy, d[5]*sy
+		out.tags[tagModelTransformation] = geoTag{datatype: tiffDouble, doubles: d}
+	}
+
+	out.resolve()
+	return out
+}
+
+// cropped returns a copy of g describing the sub-rectangle r of the image.
+func (g *GeoInfo) cropped(r image.Rectangle) *GeoInfo {
+	if r == image.Rect(0, 0, g.Width, g.Height) {
+		return g
+	}
+	x0, y0 := float64(r.Min.X), float64(r.Min.Y)
+
+	out := &GeoInfo{Width: r.Dx(), Height: r.Dy(), tags: make(map[uint16]geoTag, len(g.tags))}
+	for tag, t := range g.tags {
+		out.tags[tag] = t
+	}
+
+	if t, ok := out.tags[tagModelTiepoint]; ok && len(t.doubles) >= 6 {
+		d := append([]float64(nil), t.doubles...)
+		for i := 0; i+5 < len(d); i += 6 {
+			d[i] -= x0
+			d[i+1] -= y0
+		}
+		out.tags[tagModelTiepoint] = geoTag{datatype: tiffDouble, doubles: d}
+	}
+	if t, ok := out.tags[tagModelTransformation]; ok && len(t.doubles) >= 16 {
+		d := append([]float64(nil), t.doubles...)
+		d[3] += x0*d[0] + y0*d[1]
+		d[7] += x0*d[4] + y0*d[5]
+		out.tags[tagModelTransformation] = geoTag{datatype: tiffDouble, doubles: d}
+	}
+
+	out.resolve()
+	return out
+}
+
+// writeGeoTags copies a TIFF produced by our encoder to w, adding g's geo tags
+// to its IFD. The original IFD is left in place and the header is repointed
+// at a rewritten one appended to the end of the file.
+func writeGeoTags(w io.Writer, encoded []byte, g *GeoInfo) error {
+	order, entries, err := readTIFFEntries(encoded)
+	if err != nil {
+		return err
+	}
+
+	byTag := make(map[uint16]tiffEntry, len(entries)+len(g.tags))
+	for _, e := range entries {
+		byTag[e.tag] = e
+	}
+	for tag, t := range g.tags {
+		e := tiffEntry{tag: tag, datatype: t.datatype}
+		var buf bytes.Buffer
+		switch t.datatype {
+		case tiffShort:
+			for _, v := range t.shorts {
+				binary.Write(&buf, order, v)
+			}
+			e.count = uint32(len(t.shorts))
+		case tiffDouble:
+			for _, v := range t.doubles {
+				binary.Write(&buf, order, v)
+			}
+			e.count = uint32(len(t.doubles))
+		case tiffASCII:
+			buf.WriteString(t.ascii)
+			e.count = uint32(len(t.ascii))
+		default:
+			continue
+		}
+		e.value = buf.Bytes()
+		byTag[tag] = e
+	}
+
+	entries = entries[:0]
+	for _, e := range byTag {
+		entries = append(entries, e)
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(encoded)+1024))
+	out.Write(encoded)
+	ifdOffset := appendIFD(out, order, entries)
+
+	result := out.Bytes()
+	order.PutUint32(result[4:8], uint32(ifdOffset))
+
+	_, err = w.Write(result)
+	return err
+}
+
+// appendIFD writes entries as an IFD, followed by any values too large to
+// inline, at the end of out and returns the IFD's offset.
+func appendIFD(out *bytes.Buffer, order binary.ByteOrder, entries []tiffEntry) int {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	if out.Len()%2 == 1 {
+		out.WriteByte(0)
+	}
+
+	ifdOffset := out.Len()
+	dataOffset := ifdOffset + 2 + 12*len(entries) + 4
+	var values bytes.Buffer
+
+	binary.Write(out, order, uint16(len(entries)))
+	for _, e := range entries {
+		var raw [12]byte
+		order.PutUint16(raw[0:2], e.tag)
+		order.PutUint16(raw[2:4], e.datatype)
+		order.PutUint32(raw[4:8], e.count)
+		if len(e.value) <= 4 {
+			copy(raw[8:], e.value)
+		} else {
+			order.PutUint32(raw[8:12], uint32(dataOffset+values.Len()))
+			values.Write(e.value)
+			if values.Len()%2 == 1 {
+				values.WriteByte(0)
+			}
+		}
+		out.Write(raw[:])
+	}
+	binary.Write(out, order, uint32(0))
+	out.Write(values.Bytes())
+
+	return ifdOffset
+}
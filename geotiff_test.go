@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"math"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// testGeoInfo is a 100x50 image in UTM zone 33N with 10 m pixels.
+func testGeoInfo() *GeoInfo {
+	g := &GeoInfo{Width: 100, Height: 50, tags: map[uint16]geoTag{
+		tagModelPixelScale: {datatype: tiffDouble, doubles: []float64{10, 10, 0}},
+		tagModelTiepoint:   {datatype: tiffDouble, doubles: []float64{0, 0, 0, 500000, 4000000, 0}},
+		tagGeoKeyDirectory: {datatype: tiffShort, shorts: []uint16{1, 1, 0, 1, geoKeyProjectedType, 0, 1, 32633}},
+	}}
+	g.resolve()
+	return g
+}
+
+func testGeoTIFF(t *testing.T, g *GeoInfo) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := tiff.Encode(&encoded, image.NewGray(image.Rect(0, 0, 100, 50)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if g == nil {
+		return encoded.Bytes()
+	}
+	var out bytes.Buffer
+	if err := writeGeoTags(&out, encoded.Bytes(), g); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func closeTo(a, b [2]float64) bool {
+	return math.Abs(a[0]-b[0]) < 1e-6 && math.Abs(a[1]-b[1]) < 1e-6
+}
+
+func TestParseGeoTIFF(t *testing.T) {
+	data := testGeoTIFF(t, testGeoInfo())
+	geo, err := parseGeoTIFF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geo == nil {
+		t.Fatal("no georeferencing found")
+	}
+	if geo.CRS != "EPSG:32633" {
+		t.Errorf("CRS = %q", geo.CRS)
+	}
+	if want := [6]float64{500000, 10, 0, 4000000, 0, -10}; geo.GeoTransform != want {
+		t.Errorf("GeoTransform = %v, want %v", geo.GeoTransform, want)
+	}
+	if geo.Width != 100 || geo.Height != 50 {
+		t.Errorf("size = %dx%d", geo.Width, geo.Height)
+	}
+	if want := [2]float64{501000, 3999500}; !closeTo(geo.Corners.LowerRight, want) {
+		t.Errorf("LowerRight = %v, want %v", geo.Corners.LowerRight, want)
+	}
+
+	// The encoded image must still decode after the IFD was rewritten.
+	if _, err := tiff.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("rewritten TIFF does not decode: %v", err)
+	}
+}
+
+func TestParseGeoTIFFWithoutGeo(t *testing.T) {
+	geo, err := parseGeoTIFF(testGeoTIFF(t, nil))
+	if err != nil || geo != nil {
+		t.Errorf("plain TIFF = %+v, %v; want nil, nil", geo, err)
+	}
+	if _, err := parseGeoTIFF([]byte("\xff\xd8\xff\xe0 not a tiff")); !errors.Is(err, errNotTIFF) {
+		t.Errorf("JPEG error = %v, want errNotTIFF", err)
+	}
+	if _, err := parseGeoTIFF([]byte("II*\x00\xff\xff\x00\x00")); err == nil {
+		t.Error("IFD offset past the end parsed")
+	}
+}
+
+func TestGeoInfoCropped(t *testing.T) {
+	g := testGeoInfo().cropped(image.Rect(10, 20, 60, 50))
+	if g.Width != 50 || g.Height != 30 {
+		t.Errorf("size = %dx%d, want 50x30", g.Width, g.Height)
+	}
+	if want := [2]float64{500100, 3999800}; !closeTo(g.Corners.UpperLeft, want) {
+		t.Errorf("UpperLeft = %v, want %v", g.Corners.UpperLeft, want)
+	}
+	if want := [2]float64{500600, 3999500}; !closeTo(g.Corners.LowerRight, want) {
+		t.Errorf("LowerRight = %v, want %v", g.Corners.LowerRight, want)
+	}
+}
+
+func TestGeoInfoResized(t *testing.T) {
+	orig := testGeoInfo()
+	g := orig.resized(50, 25)
+	if g.GeoTransform[1] != 20 || g.GeoTransform[5] != -20 {
+		t.Errorf("pixel size = %v x %v, want 20 x -20", g.GeoTransform[1], g.GeoTransform[5])
+	}
+	if g.Corners != orig.Corners {
+		t.Errorf("footprint moved: %+v, want %+v", g.Corners, orig.Corners)
+	}
+	if orig.GeoTransform[1] != 10 {
+		t.Error("resized modified the original")
+	}
+}
@@ -0,0 +1,696 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// graphqlSchema exposes missions with their images nested, so a client can
+// fetch a mission, its frames and their metadata in one request. Lists are
+// paginated with first and after, as on GET /missions; endCursor is the
+// after of the next page.
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+"Unix seconds, or a byte count, beyond the 32 bits of Int."
+scalar Int64
+
+type Query {
+	"Missions in table order. status, collectionType and satellite (either the target or the observer) filter them."
+	missions(first: Int = 10, after: String, status: String, collectionType: String, satellite: String): MissionConnection!
+	"A mission by ID, or null if there is none."
+	mission(id: ID!): Mission
+	"An image's metadata, read from its file header, or null if there is no such image."
+	image(id: ID!): ImageMetadata
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+
+type MissionConnection {
+	nodes: [Mission!]!
+	pageInfo: PageInfo!
+}
+
+type Mission {
+	id: ID!
+	name: String!
+	status: String!
+	priority: Int!
+	targetSatelliteId: String!
+	observerSatelliteId: String!
+	tca: Int64!
+	minRangeKm: Float!
+	relativeVelocityKms: Float!
+	collectionWindowStart: Int64!
+	collectionWindowEnd: Int64!
+	collectionType: String!
+	pointingTarget: String!
+	imageIds: [ID!]!
+	updatedAt: Int64
+	geometryUpdatedAt: Int64
+	feasibility: Feasibility
+	schedule: Schedule
+	"The mission's images with their ingest-time records. minQuality keeps only frames scoring at least that value."
+	images(first: Int = 100, after: String, minQuality: Float): ImageConnection!
+}
+
+type ImageConnection {
+	nodes: [Image!]!
+	pageInfo: PageInfo!
+}
+
+type Image {
+	id: ID!
+	quality: QualityMetrics
+	photometry: Photometry
+	geometry: ObservationGeometry
+	wcs: WCS
+	exif: [Tag!]!
+	updated: Int64
+	"The file's own metadata. Each one costs a read of the image header, so ask for it only when needed."
+	metadata: ImageMetadata
+}
+
+type Tag {
+	name: String!
+	value: String!
+}
+
+type Feasibility {
+	score: Float!
+	feasible: Boolean!
+	issues: [String!]!
+	phaseAngleDeg: Float!
+	targetSunlit: Boolean!
+	observerEclipsed: Boolean!
+}
+
+type Schedule {
+	start: Int64!
+	end: Int64!
+	rank: Int!
+	frames: Int!
+	dataMb: Float!
+	slewDeg: Float!
+	scheduledAt: Int64!
+}
+
+type QualityMetrics {
+	score: Float!
+	blurVariance: Float!
+	snrDb: Float!
+	saturatedPct: Float!
+	computed: Int64!
+}
+
+type Photometry {
+	x: Float!
+	y: Float!
+	fwhm: Float!
+	flux: Float!
+	snr: Float!
+	peak: Float!
+	background: Float!
+	aperture: Float!
+	saturated: Boolean!
+	captureTime: Int64!
+	measured: Int64!
+}
+
+type ImageMetadata {
+	id: ID!
+	key: String!
+	contentType: String
+	contentLength: Int64!
+	etag: String
+	lastModified: Int64
+	captureTime: Int64
+	format: String
+	width: Int!
+	height: Int!
+	geo: GeoInfo
+	exif: [Tag!]!
+	quality: QualityMetrics
+	photometry: Photometry
+	geometry: ObservationGeometry
+	wcs: WCS
+}
+
+"How the mission's observer saw its target at capture. sunVector is in the sensor frame, with +Z on the target."
+type ObservationGeometry {
+	missionId: ID!
+	captureTime: Int64!
+	rangeKm: Float!
+	rangeRateKms: Float!
+	phaseAngleDeg: Float!
+	sunVector: [Float!]!
+	targetRaDeg: Float!
+	targetDecDeg: Float!
+	targetSunlit: Boolean!
+	observerEclipsed: Boolean!
+	computed: Int64!
+}
+
+"The image's plate solution. crpix is 1-based and cd is CD1_1, CD1_2, CD2_1, CD2_2 in degrees per pixel."
+type WCS {
+	projection: String!
+	crpix: [Float!]!
+	crval: [Float!]!
+	cd: [Float!]!
+	width: Int!
+	height: Int!
+	raDeg: Float!
+	decDeg: Float!
+	rollDeg: Float!
+	plateScaleArcsec: Float!
+	mirrored: Boolean!
+	solver: String!
+	solved: Int64!
+}
+
+type GeoInfo {
+	crs: String
+	"The GDAL geotransform, six values."
+	geotransform: [Float!]!
+	upperLeft: [Float!]!
+	upperRight: [Float!]!
+	lowerLeft: [Float!]!
+	lowerRight: [Float!]!
+	center: [Float!]!
+	width: Int!
+	height: Int!
+}
+`
+
+const (
+	// graphqlMaxPage bounds first on every list, as count is bounded on
+	// GET /missions.
+	graphqlMaxPage = 100
+	// graphqlMaxScan bounds the missions read to fill one filtered page. A
+	// page may come back short, with a cursor to continue from.
+	graphqlMaxScan  = 1000
+	graphqlMaxDepth = 8
+)
+
+// graphqlHandler serves POST /graphql with a JSON {query, operationName,
+// variables} body, and GET /graphql with the same as query parameters.
+func (api *API) graphqlHandler() gin.HandlerFunc {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{api: api},
+		graphql.MaxDepth(graphqlMaxDepth),
+		graphql.MaxParallelism(8),
+	)
+	return func(c *gin.Context) {
+		var req struct {
+			Query         string         `json:"query"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+		}
+		if c.Request.Method == http.MethodGet {
+			req.Query = c.Query("query")
+			req.OperationName = c.Query("operationName")
+			if v := c.Query("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'variables' parameter. Must be a JSON object.")
+					return
+				}
+			}
+		} else if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"query\": \"...\"}.")
+			return
+		}
+		if req.Query == "" {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing query")
+			return
+		}
+		c.JSON(http.StatusOK, schema.Exec(c.Request.Context(), req.Query, req.OperationName, req.Variables))
+	}
+}
+
+// graphqlError is a service-layer problem as a GraphQL error, its code in
+// the error's extensions.
+type graphqlError struct{ *Problem }
+
+func (e graphqlError) Extensions() map[string]any {
+	return map[string]any{"code": e.Code, "status": e.Status}
+}
+
+func toGraphQLError(err error) error {
+	return graphqlError{problemFor(err)}
+}
+
+// int64Scalar is the Int64 scalar.
+type int64Scalar int64
+
+func (int64Scalar) ImplementsGraphQLType(name string) bool { return name == "Int64" }
+
+func (n *int64Scalar) UnmarshalGraphQL(input any) error {
+	switch v := input.(type) {
+	case int32:
+		*n = int64Scalar(v)
+	case int64:
+		*n = int64Scalar(v)
+	case float64:
+		*n = int64Scalar(v)
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*n = int64Scalar(i)
+	default:
+		return fmt.Errorf("wrong type for Int64: %T", input)
+	}
+	return nil
+}
+
+// optionalInt64 is nil for 0, the value of an unset omitempty field.
+func optionalInt64(v int64) *int64Scalar {
+	if v == 0 {
+		return nil
+	}
+	n := int64Scalar(v)
+	return &n
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func pageSize(first int32) (int32, error) {
+	if first <= 0 {
+		return 0, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'first' argument. Must be a positive integer.")
+	}
+	return min(first, graphqlMaxPage), nil
+}
+
+type graphqlResolver struct {
+	api *API
+}
+
+type pageInfo struct {
+	next *string
+}
+
+func (p pageInfo) HasNextPage() bool  { return p.next != nil }
+func (p pageInfo) EndCursor() *string { return p.next }
+
+type missionConnection struct {
+	nodes []*missionResolver
+	info  pageInfo
+}
+
+func (c *missionConnection) Nodes() []*missionResolver { return c.nodes }
+func (c *missionConnection) PageInfo() pageInfo        { return c.info }
+
+func (r *graphqlResolver) Missions(ctx context.Context, args struct {
+	First          int32
+	After          *string
+	Status         *string
+	CollectionType *string
+	Satellite      *string
+}) (*missionConnection, error) {
+	limit, err := pageSize(args.First)
+	if err != nil {
+		return nil, toGraphQLError(err)
+	}
+	matches := func(m *Mission) bool {
+		return (args.Status == nil || m.Status == *args.Status) &&
+			(args.CollectionType == nil || m.CollectionType == *args.CollectionType) &&
+			(args.Satellite == nil || m.TargetSatelliteID == *args.Satellite || m.ObserverSatelliteID == *args.Satellite)
+	}
+
+	// Pages are read no larger than what is still needed, so every mission
+	// read is either returned or filtered out, and the store's token is a
+	// cursor that skips nothing.
+	conn := &missionConnection{nodes: []*missionResolver{}}
+	var token string
+	if args.After != nil {
+		token = *args.After
+	}
+	for scanned := 0; ; {
+		page, err := r.api.listMissions(ctx, limit-int32(len(conn.nodes)), token)
+		if err != nil {
+			return nil, toGraphQLError(err)
+		}
+		for i := range page.Missions {
+			if m := &page.Missions[i]; matches(m) {
+				conn.nodes = append(conn.nodes, &missionResolver{api: r.api, m: m})
+			}
+		}
+		scanned += len(page.Missions)
+		conn.info.next = page.NextToken
+		if page.NextToken == nil || int32(len(conn.nodes)) == limit || scanned >= graphqlMaxScan {
+			return conn, nil
+		}
+		token = *page.NextToken
+	}
+}
+
+func (r *graphqlResolver) Mission(ctx context.Context, args struct{ ID graphql.ID }) (*missionResolver, error) {
+	m, err := r.api.loadMission(ctx, string(args.ID))
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			return nil, nil
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", args.ID, "err", err)
+		return nil, toGraphQLError(newProblem(http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission"))
+	}
+	return &missionResolver{api: r.api, m: m}, nil
+}
+
+func (r *graphqlResolver) Image(ctx context.Context, args struct{ ID graphql.ID }) (*metadataResolver, error) {
+	return imageMetadataResolver(ctx, r.api, string(args.ID))
+}
+
+// imageMetadataResolver returns nil for an image that does not exist, as
+// GraphQL does for a missing object, and an error for anything else.
+func imageMetadataResolver(ctx context.Context, api *API, id string) (*metadataResolver, error) {
+	meta, err := api.imageMetadata(ctx, id)
+	if err != nil {
+		if problemFor(err).Code == CodeImageNotFound {
+			return nil, nil
+		}
+		return nil, toGraphQLError(err)
+	}
+	return &metadataResolver{meta}, nil
+}
+
+type missionResolver struct {
+	api *API
+	m   *Mission
+
+	// The mission's image records are loaded once, however many images
+	// fields the query selects.
+	once    sync.Once
+	records map[string]*ImageRecord
+	err     error
+}
+
+func (r *missionResolver) ID() graphql.ID              { return graphql.ID(r.m.ID) }
+func (r *missionResolver) Name() string                { return r.m.Name }
+func (r *missionResolver) Status() string              { return r.m.Status }
+func (r *missionResolver) Priority() int32             { return int32(r.m.Priority) }
+func (r *missionResolver) TargetSatelliteID() string   { return r.m.TargetSatelliteID }
+func (r *missionResolver) ObserverSatelliteID() string { return r.m.ObserverSatelliteID }
+func (r *missionResolver) TCA() int64Scalar            { return int64Scalar(r.m.TCA) }
+func (r *missionResolver) MinRangeKM() float64         { return r.m.MinRangeKM }
+func (r *missionResolver) RelativeVelocityKMS() float64 {
+	return r.m.RelativeVelocityKMS
+}
+func (r *missionResolver) CollectionWindowStart() int64Scalar {
+	return int64Scalar(r.m.CollectionWindowStart)
+}
+func (r *missionResolver) CollectionWindowEnd() int64Scalar {
+	return int64Scalar(r.m.CollectionWindowEnd)
+}
+func (r *missionResolver) CollectionType() string  { return r.m.CollectionType }
+func (r *missionResolver) PointingTarget() string  { return r.m.PointingTarget }
+func (r *missionResolver) UpdatedAt() *int64Scalar { return optionalInt64(r.m.UpdatedAt) }
+func (r *missionResolver) GeometryUpdatedAt() *int64Scalar {
+	return optionalInt64(r.m.GeometryUpdatedAt)
+}
+func (r *missionResolver) Feasibility() *feasibilityResolver {
+	if r.m.Feasibility == nil {
+		return nil
+	}
+	return &feasibilityResolver{r.m.Feasibility}
+}
+func (r *missionResolver) Schedule() *scheduleResolver {
+	if r.m.Schedule == nil {
+		return nil
+	}
+	return &scheduleResolver{r.m.Schedule}
+}
+
+func (r *missionResolver) ImageIDs() []graphql.ID {
+	ids := make([]graphql.ID, len(r.m.ImageIDs))
+	for i, id := range r.m.ImageIDs {
+		ids[i] = graphql.ID(id)
+	}
+	return ids
+}
+
+type imageConnection struct {
+	nodes []*imageResolver
+	info  pageInfo
+}
+
+func (c *imageConnection) Nodes() []*imageResolver { return c.nodes }
+func (c *imageConnection) PageInfo() pageInfo      { return c.info }
+
+// Images pages through the mission's image IDs; the cursor is the ID of the
+// last image on the page.
+func (r *missionResolver) Images(ctx context.Context, args struct {
+	First      int32
+	After      *string
+	MinQuality *float64
+}) (*imageConnection, error) {
+	limit, err := pageSize(args.First)
+	if err != nil {
+		return nil, toGraphQLError(err)
+	}
+	if q := args.MinQuality; q != nil && (*q < 0 || *q > 100) {
+		return nil, toGraphQLError(newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'minQuality' argument. Must be a number between 0 and 100."))
+	}
+	ids := r.m.ImageIDs
+	if args.After != nil {
+		i := slices.Index(ids, *args.After)
+		if i < 0 {
+			return nil, toGraphQLError(newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'after' cursor"))
+		}
+		ids = ids[i+1:]
+	}
+
+	r.once.Do(func() {
+		r.records, r.err = r.api.Images.ImageRecords(ctx, r.m.ImageIDs)
+		if r.err != nil {
+			slog.ErrorContext(ctx, "failed to load image records", "mission", r.m.ID, "err", r.err)
+		}
+	})
+	if r.err != nil {
+		return nil, toGraphQLError(newProblem(http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata"))
+	}
+
+	conn := &imageConnection{nodes: []*imageResolver{}}
+	for i, id := range ids {
+		if int32(len(conn.nodes)) == limit {
+			last := ids[i-1]
+			conn.info.next = &last
+			break
+		}
+		record := ImageRecord{ID: id}
+		if rec, ok := r.records[id]; ok {
+			record = *rec
+		}
+		if args.MinQuality != nil && (record.Quality == nil || record.Quality.Score < *args.MinQuality) {
+			continue
+		}
+		conn.nodes = append(conn.nodes, &imageResolver{api: r.api, r: record})
+	}
+	return conn, nil
+}
+
+type imageResolver struct {
+	api *API
+	r   ImageRecord
+}
+
+func (r *imageResolver) ID() graphql.ID            { return graphql.ID(r.r.ID) }
+func (r *imageResolver) Quality() *qualityResolver { return newQualityResolver(r.r.Quality) }
+func (r *imageResolver) Photometry() *photometryResolver {
+	return newPhotometryResolver(r.r.Photometry)
+}
+func (r *imageResolver) Geometry() *observationResolver {
+	return newObservationResolver(r.r.Geometry)
+}
+func (r *imageResolver) WCS() *wcsResolver     { return newWCSResolver(r.r.WCS) }
+func (r *imageResolver) EXIF() []tagResolver   { return newTags(r.r.EXIF) }
+func (r *imageResolver) Updated() *int64Scalar { return optionalInt64(r.r.Updated) }
+
+func (r *imageResolver) Metadata(ctx context.Context) (*metadataResolver, error) {
+	return imageMetadataResolver(ctx, r.api, r.r.ID)
+}
+
+type tagResolver struct {
+	name, value string
+}
+
+func (t tagResolver) Name() string  { return t.name }
+func (t tagResolver) Value() string { return t.value }
+
+// newTags lists EXIF tags in name order, since GraphQL has no map type.
+func newTags(exif map[string]string) []tagResolver {
+	tags := make([]tagResolver, 0, len(exif))
+	for _, name := range slices.Sorted(maps.Keys(exif)) {
+		tags = append(tags, tagResolver{name, exif[name]})
+	}
+	return tags
+}
+
+type feasibilityResolver struct{ f *Feasibility }
+
+func (r *feasibilityResolver) Score() float64         { return r.f.Score }
+func (r *feasibilityResolver) Feasible() bool         { return r.f.Feasible }
+func (r *feasibilityResolver) PhaseAngleDeg() float64 { return r.f.PhaseAngleDeg }
+func (r *feasibilityResolver) TargetSunlit() bool     { return r.f.TargetSunlit }
+func (r *feasibilityResolver) ObserverEclipsed() bool { return r.f.ObserverEclipsed }
+func (r *feasibilityResolver) Issues() []string {
+	if r.f.Issues == nil {
+		return []string{}
+	}
+	return r.f.Issues
+}
+
+type scheduleResolver struct{ s *ScheduleAssignment }
+
+func (r *scheduleResolver) Start() int64Scalar       { return int64Scalar(r.s.Start) }
+func (r *scheduleResolver) End() int64Scalar         { return int64Scalar(r.s.End) }
+func (r *scheduleResolver) Rank() int32              { return int32(r.s.Rank) }
+func (r *scheduleResolver) Frames() int32            { return int32(r.s.Frames) }
+func (r *scheduleResolver) DataMB() float64          { return r.s.DataMB }
+func (r *scheduleResolver) SlewDeg() float64         { return r.s.SlewDeg }
+func (r *scheduleResolver) ScheduledAt() int64Scalar { return int64Scalar(r.s.ScheduledAt) }
+
+type qualityResolver struct{ q *QualityMetrics }
+
+func newQualityResolver(q *QualityMetrics) *qualityResolver {
+	if q == nil {
+		return nil
+	}
+	return &qualityResolver{q}
+}
+
+func (r *qualityResolver) Score() float64        { return r.q.Score }
+func (r *qualityResolver) BlurVariance() float64 { return r.q.BlurVariance }
+func (r *qualityResolver) SNRDb() float64        { return r.q.SNR }
+func (r *qualityResolver) SaturatedPct() float64 { return r.q.SaturatedPct }
+func (r *qualityResolver) Computed() int64Scalar { return int64Scalar(r.q.Computed) }
+
+type photometryResolver struct{ p *Photometry }
+
+func newPhotometryResolver(p *Photometry) *photometryResolver {
+	if p == nil {
+		return nil
+	}
+	return &photometryResolver{p}
+}
+
+func (r *photometryResolver) X() float64               { return r.p.X }
+func (r *photometryResolver) Y() float64               { return r.p.Y }
+func (r *photometryResolver) FWHM() float64            { return r.p.FWHM }
+func (r *photometryResolver) Flux() float64            { return r.p.Flux }
+func (r *photometryResolver) SNR() float64             { return r.p.SNR }
+func (r *photometryResolver) Peak() float64            { return r.p.Peak }
+func (r *photometryResolver) Background() float64      { return r.p.Background }
+func (r *photometryResolver) Aperture() float64        { return r.p.Aperture }
+func (r *photometryResolver) Saturated() bool          { return r.p.Saturated }
+func (r *photometryResolver) CaptureTime() int64Scalar { return int64Scalar(r.p.CaptureTime) }
+func (r *photometryResolver) Measured() int64Scalar    { return int64Scalar(r.p.Measured) }
+
+type observationResolver struct{ g *ObservationGeometry }
+
+func newObservationResolver(g *ObservationGeometry) *observationResolver {
+	if g == nil {
+		return nil
+	}
+	return &observationResolver{g}
+}
+
+func (r *observationResolver) MissionID() graphql.ID    { return graphql.ID(r.g.MissionID) }
+func (r *observationResolver) CaptureTime() int64Scalar { return int64Scalar(r.g.CaptureTime) }
+func (r *observationResolver) RangeKm() float64         { return r.g.RangeKM }
+func (r *observationResolver) RangeRateKms() float64    { return r.g.RangeRateKMS }
+func (r *observationResolver) PhaseAngleDeg() float64   { return r.g.PhaseAngleDeg }
+func (r *observationResolver) SunVector() []float64     { return r.g.SunVector[:] }
+func (r *observationResolver) TargetRaDeg() float64     { return r.g.TargetRADeg }
+func (r *observationResolver) TargetDecDeg() float64    { return r.g.TargetDecDeg }
+func (r *observationResolver) TargetSunlit() bool       { return r.g.TargetSunlit }
+func (r *observationResolver) ObserverEclipsed() bool   { return r.g.ObserverEclipsed }
+func (r *observationResolver) Computed() int64Scalar    { return int64Scalar(r.g.Computed) }
+
+type wcsResolver struct{ w *WCS }
+
+func newWCSResolver(w *WCS) *wcsResolver {
+	if w == nil {
+		return nil
+	}
+	return &wcsResolver{w}
+}
+
+func (r *wcsResolver) Projection() string { return r.w.Projection }
+func (r *wcsResolver) Crpix() []float64   { return r.w.CRPix[:] }
+func (r *wcsResolver) Crval() []float64   { return r.w.CRVal[:] }
+func (r *wcsResolver) Cd() []float64 {
+	return []float64{r.w.CD[0][0], r.w.CD[0][1], r.w.CD[1][0], r.w.CD[1][1]}
+}
+func (r *wcsResolver) Width() int32              { return int32(r.w.Width) }
+func (r *wcsResolver) Height() int32             { return int32(r.w.Height) }
+func (r *wcsResolver) RaDeg() float64            { return r.w.RADeg }
+func (r *wcsResolver) DecDeg() float64           { return r.w.DecDeg }
+func (r *wcsResolver) RollDeg() float64          { return r.w.RollDeg }
+func (r *wcsResolver) PlateScaleArcsec() float64 { return r.w.PlateScale }
+func (r *wcsResolver) Mirrored() bool            { return r.w.Mirrored }
+func (r *wcsResolver) Solver() string            { return r.w.Solver }
+func (r *wcsResolver) Solved() int64Scalar       { return int64Scalar(r.w.Solved) }
+
+type metadataResolver struct{ m *ImageMetadata }
+
+func (r *metadataResolver) ID() graphql.ID             { return graphql.ID(r.m.ID) }
+func (r *metadataResolver) Key() string                { return r.m.Key }
+func (r *metadataResolver) ContentType() *string       { return optionalString(r.m.ContentType) }
+func (r *metadataResolver) ContentLength() int64Scalar { return int64Scalar(r.m.ContentLength) }
+func (r *metadataResolver) ETag() *string              { return optionalString(r.m.ETag) }
+func (r *metadataResolver) LastModified() *int64Scalar { return optionalInt64(r.m.LastModified) }
+func (r *metadataResolver) CaptureTime() *int64Scalar  { return optionalInt64(r.m.CaptureTime) }
+func (r *metadataResolver) Format() *string            { return optionalString(r.m.Format) }
+func (r *metadataResolver) Width() int32               { return int32(r.m.Width) }
+func (r *metadataResolver) Height() int32              { return int32(r.m.Height) }
+func (r *metadataResolver) EXIF() []tagResolver        { return newTags(r.m.EXIF) }
+func (r *metadataResolver) Quality() *qualityResolver  { return newQualityResolver(r.m.Quality) }
+func (r *metadataResolver) Photometry() *photometryResolver {
+	return newPhotometryResolver(r.m.Photometry)
+}
+
+func (r *metadataResolver) Geometry() *observationResolver {
+	return newObservationResolver(r.m.Geometry)
+}
+
+func (r *metadataResolver) WCS() *wcsResolver { return newWCSResolver(r.m.WCS) }
+
+func (r *metadataResolver) Geo() *geoResolver {
+	if r.m.Geo == nil {
+		return nil
+	}
+	return &geoResolver{r.m.Geo}
+}
+
+type geoResolver struct{ g *GeoInfo }
+
+func (r *geoResolver) CRS() *string            { return optionalString(r.g.CRS) }
+func (r *geoResolver) Geotransform() []float64 { return r.g.GeoTransform[:] }
+func (r *geoResolver) UpperLeft() []float64    { return r.g.Corners.UpperLeft[:] }
+func (r *geoResolver) UpperRight() []float64   { return r.g.Corners.UpperRight[:] }
+func (r *geoResolver) LowerLeft() []float64    { return r.g.Corners.LowerLeft[:] }
+func (r *geoResolver) LowerRight() []float64   { return r.g.Corners.LowerRight[:] }
+func (r *geoResolver) Center() []float64       { return r.g.Corners.Center[:] }
+func (r *geoResolver) Width() int32            { return int32(r.g.Width) }
+func (r *geoResolver) Height() int32           { return int32(r.g.Height) }
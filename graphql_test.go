@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type graphqlResult struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+func testGraphQL(t *testing.T) (*API, func(query string, vars map[string]any) graphqlResult) {
+	t.Helper()
+	api := testGRPCAPI(t)
+	db := api.MissionDB.(*sqlStore)
+	for i, m := range []Mission{
+		{ID: "m1", Status: "complete", TargetSatelliteID: "sat-a", ImageIDs: []string{"a", "b", "c"}},
+		{ID: "m2", Status: "planned", TargetSatelliteID: "sat-b"},
+		{ID: "m3", Status: "complete", ObserverSatelliteID: "sat-a", TCA: 1 << 40},
+	} {
+		m.Name = fmt.Sprint("mission ", i)
+		data, _ := json.Marshal(m)
+		if _, err := db.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", m.ID, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, score := range map[string]float64{"a": 90, "b": 30} {
+		if err := db.SetImageAttribute(context.Background(), id, "quality", QualityMetrics{Score: score}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/graphql", api.graphqlHandler())
+	return api, func(query string, vars map[string]any) graphqlResult {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"query": query, "variables": vars})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var res graphqlResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+}
+
+func TestGraphQLMissions(t *testing.T) {
+	_, query := testGraphQL(t)
+
+	res := query(`query($sat: String) {
+		missions(first: 1, status: "complete", satellite: $sat) {
+			nodes { id tca images(minQuality: 50) { nodes { id quality { score } } } }
+			pageInfo { hasNextPage endCursor }
+		}
+	}`, map[string]any{"sat": "sat-a"})
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	var page struct {
+		Missions struct {
+			Nodes []struct {
+				ID     string
+				TCA    int64
+				Images struct {
+					Nodes []struct {
+						ID      string
+						Quality struct{ Score float64 }
+					}
+				}
+			}
+			PageInfo struct {
+				HasNextPage bool
+				EndCursor   string
+			}
+		}
+	}
+	json.Unmarshal(res.Data, &page)
+	if n := page.Missions.Nodes; len(n) != 1 || n[0].ID != "m1" || len(n[0].Images.Nodes) != 1 || n[0].Images.Nodes[0].Quality.Score != 90 {
+		t.Errorf("first page = %s", res.Data)
+	}
+	if !page.Missions.PageInfo.HasNextPage {
+		t.Fatalf("no next page: %s", res.Data)
+	}
+
+	// m2 does not match and is skipped; m3 matches as the observer.
+	res = query(`query($after: String) {
+		missions(first: 5, after: $after, status: "complete", satellite: "sat-a") { nodes { id tca } pageInfo { hasNextPage } }
+	}`, map[string]any{"after": page.Missions.PageInfo.EndCursor})
+	json.Unmarshal(res.Data, &page)
+	if n := page.Missions.Nodes; len(n) != 1 || n[0].ID != "m3" || n[0].TCA != 1<<40 || page.Missions.PageInfo.HasNextPage {
+		t.Errorf("second page = %s %v", res.Data, res.Errors)
+	}
+
+	res = query(`{ missions(first: 0) { nodes { id } } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != string(CodeInvalidParameter) {
+		t.Errorf("first: 0 errors = %+v", res.Errors)
+	}
+}
+
+func TestGraphQLMission(t *testing.T) {
+	_, query := testGraphQL(t)
+
+	res := query(`{
+		mission(id: "m1") { name images(first: 2) { nodes { id } pageInfo { hasNextPage endCursor } } }
+		missing: mission(id: "none") { name }
+	}`, nil)
+	want := `{"mission":{"name":"mission 0","images":{"nodes":[{"id":"a"},{"id":"b"}],"pageInfo":{"hasNextPage":true,"endCursor":"b"}}},"missing":null}`
+	if len(res.Errors) > 0 || string(res.Data) != want {
+		t.Errorf("data = %s, errors %v", res.Data, res.Errors)
+	}
+
+	res = query(`{ mission(id: "m1") { images(after: "b") { nodes { id } pageInfo { hasNextPage } } } }`, nil)
+	if want := `{"mission":{"images":{"nodes":[{"id":"c"}],"pageInfo":{"hasNextPage":false}}}}`; string(res.Data) != want {
+		t.Errorf("after b = %s", res.Data)
+	}
+
+	res = query(`{ image(id: "none") { width } }`, nil)
+	if len(res.Errors) > 0 || string(res.Data) != `{"image":null}` {
+		t.Errorf("missing image = %s %v", res.Data, res.Errors)
+	}
+}
+
+func TestGraphQLRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	h := (&API{Config: &Config{}}).graphqlHandler()
+	router.GET("/graphql", h)
+	router.POST("/graphql", h)
+
+	for _, tc := range []struct {
+		name, method, target, body string
+		want                       int
+	}{
+		{"introspection over GET", http.MethodGet, "/graphql?query=" + url.QueryEscape("{ __schema { queryType { name } } }"), "", http.StatusOK},
+		{"bad variables", http.MethodGet, "/graphql?query=%7B__typename%7D&variables=nope", "", http.StatusBadRequest},
+		{"no query", http.MethodPost, "/graphql", `{}`, http.StatusBadRequest},
+		{"bad body", http.MethodPost, "/graphql", `query`, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body)))
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WGS-84, which map coordinates are given in.
+const (
+	wgs84A  = 6378.137
+	wgs84F  = 1 / 298.257223563
+	wgs84E2 = wgs84F * (2 - wgs84F)
+	// earthMeanRadiusKm is used for footprints, where the ellipsoid's
+	// flattening does not matter.
+	earthMeanRadiusKm = 6371.0088
+)
+
+// GroundPosition is the point on the ground below a satellite.
+type GroundPosition struct {
+	Time  time.Time `json:"time"`
+	Lat   float64   `json:"lat"`
+	Lon   float64   `json:"lon"`
+	AltKM float64   `json:"alt_km"`
+	// FootprintKM is the ground distance from the point to the satellite's
+	// horizon.
+	FootprintKM float64 `json:"footprint_km"`
+}
+
+// geodetic converts an Earth-fixed position in km to WGS-84 latitude and
+// longitude in degrees and height in km.
+func geodetic(r [3]float64) (lat, lon, alt float64) {
+	p := math.Hypot(r[0], r[1])
+	lon = math.Atan2(r[1], r[0])
+	phi := math.Atan2(r[2], p*(1-wgs84E2))
+	for range 5 {
+		sin := math.Sin(phi)
+		n := wgs84A / math.Sqrt(1-wgs84E2*sin*sin)
+		phi = math.Atan2(r[2]+n*wgs84E2*sin, p)
+	}
+	sin, cos := math.Sincos(phi)
+	alt = p*cos + r[2]*sin - wgs84A*math.Sqrt(1-wgs84E2*sin*sin)
+	return phi * 180 / math.Pi, lon * 180 / math.Pi, alt
+}
+
+// groundPosition is the point below the satellite p models at t.
+func groundPosition(p *sgp4, t time.Time) (GroundPosition, error) {
+	eci, err := p.At(t)
+	if err != nil {
+		return GroundPosition{}, err
+	}
+	lat, lon, alt := geodetic(temeToECEF(eci, t).Position)
+	footprint := earthMeanRadiusKm * math.Acos(earthMeanRadiusKm/(earthMeanRadiusKm+max(alt, 0)))
+	round := func(v float64, places float64) float64 {
+		scale := math.Pow(10, places)
+		return math.Round(v*scale) / scale
+	}
+	return GroundPosition{Time: t, Lat: round(lat, 6), Lon: round(lon, 6), AltKM: round(alt, 3), FootprintKM: round(footprint, 1)}, nil
+}
+
+// positionAt is the ground position of the satellite at the unix time at,
+// or nil when it has no element set or cannot be propagated there.
+func (api *API) positionAt(ctx context.Context, id string, at int64) *GroundPosition {
+	if id == "" || at == 0 {
+		return nil
+	}
+	t := time.Unix(at, 0).UTC()
+	p, _, err := api.orbit(ctx, id, t)
+	if err != nil {
+		return nil
+	}
+	pos, err := groundPosition(p, t)
+	if err != nil {
+		return nil
+	}
+	return &pos
+}
+
+// GroundTrack is the body of GET /satellite/:id/groundtrack: a GeoJSON
+// Feature whose geometry is a LineString, or a MultiLineString when the
+// track crosses the antimeridian.
+type GroundTrack struct {
+	Type       string                `json:"type"`
+	Geometry   GeoJSONGeometry       `json:"geometry"`
+	Properties GroundTrackProperties `json:"properties"`
+}
+
+type GeoJSONGeometry struct {
+	Type string `json:"type"`
+	// Coordinates are [longitude, latitude] positions, in a list for a
+	// LineString and a list of lists for a MultiLineString.
+	Coordinates any `json:"coordinates"`
+}
+
+type GroundTrackProperties struct {
+	Satellite   string    `json:"satellite"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	StepSeconds float64   `json:"step_seconds"`
+	// TLE is the element set propagated.
+	TLE TLE `json:"tle"`
+}
+
+// splitAntimeridian splits a track into lines that do not cross longitude
+// ±180, ending each at the crossing and starting the next there, as RFC
+// 7946 asks.
+func splitAntimeridian(points [][2]float64) [][][2]float64 {
+	lines := [][][2]float64{}
+	var line [][2]float64
+	for i, pt := range points {
+		if i > 0 {
+			prev := points[i-1]
+			if d := pt[0] - prev[0]; math.Abs(d) > 180 {
+				// Going east over the antimeridian longitude drops by
+				// nearly 360, and going west it rises by as much.
+				edge := 180.0
+				if d > 0 {
+					edge = -180
+				}
+				unwrapped := pt[0] + 2*edge
+				f := (edge - prev[0]) / (unwrapped - prev[0])
+				lat := prev[1] + f*(pt[1]-prev[1])
+				line = append(line, [2]float64{edge, lat})
+				lines = append(lines, line)
+				line = [][2]float64{{-edge, lat}}
+			}
+		}
+		line = append(line, pt)
+	}
+	return append(lines, line)
+}
+
+// getGroundTrack answers with the satellite's ground track from ?start= to
+// ?end=, a point every ?step=, as GeoJSON.
+func (api *API) getGroundTrack(c *gin.Context) {
+	id := c.Param("id")
+	start, end, step, err := parseTimeWindow(c, defaultEphemerisSpan, defaultEphemerisStep)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	p, tle, err := api.orbit(c.Request.Context(), id, start.Add(end.Sub(start)/2))
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+
+	var points [][2]float64
+	for t := start; !t.After(end); t = t.Add(step) {
+		pos, err := groundPosition(p, t)
+		if err != nil {
+			respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, fmt.Sprintf("at %s: %v", t.Format(time.RFC3339), err))
+			return
+		}
+		points = append(points, [2]float64{pos.Lon, pos.Lat})
+	}
+	if len(points) < 2 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'end' parameter. A ground track needs at least two points, so end must be at least one step after start.")
+		return
+	}
+	track := GroundTrack{
+		Type: "Feature",
+		Properties: GroundTrackProperties{
+			Satellite: id, Start: start, End: end, StepSeconds: step.Seconds(), TLE: tle,
+		},
+	}
+	if lines := splitAntimeridian(points); len(lines) == 1 {
+		track.Geometry = GeoJSONGeometry{Type: "LineString", Coordinates: lines[0]}
+	} else {
+		track.Geometry = GeoJSONGeometry{Type: "MultiLineString", Coordinates: lines}
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("Content-Type", "application/geo+json")
+	c.IndentedJSON(http.StatusOK, track)
+}
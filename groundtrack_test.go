@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGeodetic(t *testing.T) {
+	polarRadius := wgs84A * (1 - wgs84F)
+	tests := []struct {
+		r             [3]float64
+		lat, lon, alt float64
+	}{
+		{[3]float64{wgs84A + 500, 0, 0}, 0, 0, 500},
+		{[3]float64{0, -(wgs84A + 35786)}, 0, -90, 35786},
+		{[3]float64{0, 0, polarRadius + 400}, 90, 0, 400},
+		// 45°N 45°E on the ellipsoid itself.
+		{[3]float64{3194.419145, 3194.419145, 4487.348409}, 45, 45, 0},
+	}
+	for _, tt := range tests {
+		lat, lon, alt := geodetic(tt.r)
+		if math.Abs(lat-tt.lat) > 1e-6 || math.Abs(lon-tt.lon) > 1e-6 || math.Abs(alt-tt.alt) > 1e-3 {
+			t.Errorf("geodetic(%v) = %v, %v, %v; want %v, %v, %v", tt.r, lat, lon, alt, tt.lat, tt.lon, tt.alt)
+		}
+	}
+}
+
+func TestSplitAntimeridian(t *testing.T) {
+	lines := splitAntimeridian([][2]float64{{170, 0}, {-170, 10}, {-160, 20}, {170, 30}})
+	want := [][][2]float64{
+		{{170, 0}, {180, 5}},
+		{{-180, 5}, {-170, 10}, {-160, 20}, {-180, 20 + 20.0/3}},
+		{{180, 20 + 20.0/3}, {170, 30}},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %v", lines)
+	}
+	for i := range want {
+		if len(lines[i]) != len(want[i]) {
+			t.Fatalf("line %d = %v, want %v", i, lines[i], want[i])
+		}
+		for j := range want[i] {
+			if math.Abs(lines[i][j][0]-want[i][j][0]) > 1e-9 || math.Abs(lines[i][j][1]-want[i][j][1]) > 1e-9 {
+				t.Errorf("line %d = %v, want %v", i, lines[i], want[i])
+			}
+		}
+	}
+}
+
+func TestGroundTrack(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, "")}
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	api.Satellites.Put(context.Background(), Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive, TLE: &iss})
+	router := gin.New()
+	router.GET("/satellite/:id/groundtrack", api.getGroundTrack)
+	do := func(path string) (*httptest.ResponseRecorder, GroundTrack) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var track GroundTrack
+		json.Unmarshal(w.Body.Bytes(), &track)
+		return w, track
+	}
+
+	if w, _ := do("/satellite/iss/groundtrack?start=1221913540&end=1221913540"); w.Code != http.StatusBadRequest {
+		t.Errorf("one point: %d %s", w.Code, w.Body)
+	}
+	if w, _ := do("/satellite/nope/groundtrack"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown satellite: %d", w.Code)
+	}
+
+	// At the epoch the ISS is over the Pacific, minutes short of the
+	// antimeridian; a whole orbit crosses it.
+	w, track := do("/satellite/iss/groundtrack?start=1221913540&end=1221913720&step=60")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/geo+json" {
+		t.Fatalf("%d %s %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	if track.Type != "Feature" || track.Geometry.Type != "LineString" || len(track.Geometry.Coordinates.([]any)) != 4 {
+		t.Errorf("short track = %+v", track)
+	}
+	_, track = do("/satellite/iss/groundtrack?start=1221913540&end=1221919540&step=60")
+	if track.Geometry.Type != "MultiLineString" || track.Properties.StepSeconds != 60 {
+		t.Errorf("orbit track = %+v", track.Geometry.Type)
+	}
+	for _, line := range track.Geometry.Coordinates.([]any) {
+		for _, pt := range line.([]any) {
+			lon, lat := pt.([]any)[0].(float64), pt.([]any)[1].(float64)
+			if math.Abs(lon) > 180 || math.Abs(lat) > 52 {
+				t.Errorf("point %v, %v off the ISS's track", lon, lat)
+			}
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=module=sat-thumbnail-server --go-grpc_out=. --go-grpc_opt=module=sat-thumbnail-server satpb/sat.proto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"sat-thumbnail-server/satpb"
+)
+
+// grpcChunkSize bounds the data in one ImageChunk, well under gRPC's 4 MB
+// default message limit.
+const grpcChunkSize = 64 << 10
+
+// grpcErrorDomain is the ErrorInfo domain of the problem codes attached to
+// gRPC errors.
+const grpcErrorDomain = "sat-image-server"
+
+// grpcServer implements satpb.SatImageService on the same service methods as
+// the HTTP handlers, so both transports give the same answers.
+type grpcServer struct {
+	satpb.UnimplementedSatImageServiceServer
+	api *API
+}
+
+// grpcScopes is the scope each method needs, as its REST counterpart does.
+var grpcScopes = map[string]string{
+	satpb.SatImageService_ListMissions_FullMethodName:      ScopeMissionsRead,
+	satpb.SatImageService_GetMission_FullMethodName:        ScopeMissionsRead,
+	satpb.SatImageService_ListMissionImages_FullMethodName: ScopeMissionsRead,
+	satpb.SatImageService_GetImageMetadata_FullMethodName:  ScopeImagesRead,
+	satpb.SatImageService_GetImage_FullMethodName:          ScopeImagesRead,
+	satpb.SatImageService_SubmitProcessJob_FullMethodName:  ScopeImagesRead,
+	satpb.SatImageService_GetJob_FullMethodName:            ScopeImagesRead,
+}
+
+// newGRPCServer returns a server for the service. Unary calls get the JSON
+// endpoints' RequestTimeout and streams the imagery ProcessingTimeout. Calls
+// are authorized by their authorization metadata, like REST requests by
+// their header.
+func newGRPCServer(api *API, opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+			err = grpcCall(ctx, info.FullMethod, api.Config.RequestTimeout, func(ctx context.Context) error {
+				ctx, err := api.grpcAuthorize(ctx, info.FullMethod)
+				if err != nil {
+					return err
+				}
+				resp, err = handler(ctx, req)
+				return err
+			})
+			return resp, err
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return grpcCall(ss.Context(), info.FullMethod, api.Config.ProcessingTimeout, func(ctx context.Context) error {
+				ctx, err := api.grpcAuthorize(ctx, info.FullMethod)
+				if err != nil {
+					return err
+				}
+				return handler(srv, contextStream{ss, ctx})
+			})
+		}),
+	)...)
+	satpb.RegisterSatImageServiceServer(srv, &grpcServer{api: api})
+	return srv
+}
+
+// grpcAuthorize refuses a call whose caller lacks the scope of method, and
+// otherwise returns ctx with the tenant the call acts for, which x-tenant
+// metadata may name like the X-Tenant header. Methods not in grpcScopes
+// need ScopeAdmin.
+func (api *API) grpcAuthorize(ctx context.Context, method string) (context.Context, error) {
+	ctx = grpcClientCert(ctx)
+	var auth, requested string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			auth = values[0]
+		}
+		if values := md.Get(tenantHeader); len(values) > 0 {
+			requested = values[0]
+		}
+	}
+	scope, ok := grpcScopes[method]
+	if !ok {
+		scope = ScopeAdmin
+	}
+	p, err := authorize(ctx, api.Config, api.credentials(), bearerToken(auth), scope)
+	if err != nil {
+		return ctx, grpcError(err)
+	}
+	tenant, err := resolveTenant(api.Config, p, requested)
+	if err != nil {
+		return ctx, grpcError(err)
+	}
+	return withTenant(ctx, tenant), nil
+}
+
+// serveGRPC listens on GRPC_PORT until the process exits, over TLS when s
+// is set.
+func serveGRPC(api *API, s *serverTLS) {
+	lis, err := net.Listen("tcp", ":"+strconv.Itoa(api.Config.GRPCPort))
+	if err != nil {
+		fatal("grpc listen failed", "err", err)
+	}
+	var opts []grpc.ServerOption
+	if s != nil {
+		opts = append(opts, grpc.Creds(s.grpcCredentials()))
+	}
+	slog.Info("serving grpc", "port", api.Config.GRPCPort, "tls", s != nil)
+	if err := newGRPCServer(api, opts...).Serve(lis); err != nil {
+		fatal("grpc server failed", "err", err)
+	}
+}
+
+// contextStream is a server stream whose context carries the request ID and
+// deadline grpcCall added.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context { return s.ctx }
+
+// grpcCall is the gRPC counterpart of the HTTP middleware: it assigns the
+// request ID, keeping a well-formed x-request-id from the caller, applies
+// the timeout, recovers panics, and records the call in the access log and
+// metrics.
+func grpcCall(ctx context.Context, method string, timeout time.Duration, call func(context.Context) error) (err error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !requestIDPattern.MatchString(id) {
+		id = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "handler panicked", "err", r, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "internal server error")
+		}
+		if ctx.Err() == context.DeadlineExceeded && status.Code(err) != codes.OK {
+			err = status.Error(codes.DeadlineExceeded, "Request timed out")
+		}
+		code := status.Code(err)
+		level := slog.LevelInfo
+		if code == codes.Internal || code == codes.Unknown {
+			level = slog.LevelError
+		}
+		grpcRequests.WithLabelValues(method, code.String()).Inc()
+		grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		slog.LogAttrs(ctx, level, "grpc request",
+			slog.String("method", method),
+			slog.String("code", code.String()),
+			slog.Duration("latency", time.Since(start)),
+		)
+	}()
+	return call(ctx)
+}
+
+// grpcCodes maps the HTTP status of a problem to the gRPC code meaning the
+// same; unlisted statuses become Internal.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusNotImplemented:        codes.Unimplemented,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// grpcError converts an error from the service methods into a gRPC status,
+// with the problem code attached as an ErrorInfo reason so callers can
+// branch on it as REST clients do.
+func grpcError(err error) error {
+	p := problemFor(err)
+	code, ok := grpcCodes[p.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	msg := p.Detail
+	if msg == "" {
+		msg = p.Title
+	}
+	st := status.New(code, msg)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: string(p.Code), Domain: grpcErrorDomain}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func missingID() error {
+	return grpcError(newProblem(http.StatusBadRequest, CodeInvalidParameter, "missing id"))
+}
+
+func (s *grpcServer) ListMissions(ctx context.Context, req *satpb.ListMissionsRequest) (*satpb.ListMissionsResponse, error) {
+	limit := req.GetPageSize()
+	switch {
+	case limit < 0:
+		return nil, grpcError(newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'page_size'. Must be a positive integer."))
+	case limit == 0:
+		limit = 10
+	case limit > 100:
+		limit = 100
+	}
+	page, err := s.api.listMissions(ctx, limit, req.GetPageToken())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &satpb.ListMissionsResponse{NextPageToken: aws.ToString(page.NextToken)}
+	for i := range page.Missions {
+		resp.Missions = append(resp.Missions, missionProto(&page.Missions[i]))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetMission(ctx context.Context, req *satpb.GetMissionRequest) (*satpb.Mission, error) {
+	if req.GetId() == "" {
+		return nil, missingID()
+	}
+	mission, err := s.api.loadMission(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			return nil, grpcError(newProblem(http.StatusNotFound, CodeMissionNotFound, "mission not found"))
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", req.GetId(), "err", err)
+		return nil, grpcError(newProblem(http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission"))
+	}
+	return missionProto(mission), nil
+}
+
+func (s *grpcServer) ListMissionImages(ctx context.Context, req *satpb.ListMissionImagesRequest) (*satpb.ListMissionImagesResponse, error) {
+	if req.GetMissionId() == "" {
+		return nil, missingID()
+	}
+	minQuality := -1.0
+	if req.MinQuality != nil {
+		minQuality = req.GetMinQuality()
+		if minQuality < 0 || minQuality > 100 {
+			return nil, grpcError(newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'min_quality'. Must be a number between 0 and 100."))
+		}
+	}
+	images, err := s.api.missionImages(ctx, req.GetMissionId(), minQuality, -1)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &satpb.ListMissionImagesResponse{MissionId: images.MissionID}
+	for _, r := range images.Images {
+		resp.Images = append(resp.Images, &satpb.ImageRecord{
+			Id:         r.ID,
+			Quality:    qualityProto(r.Quality),
+			Photometry: photometryProto(r.Photometry),
+			Exif:       r.EXIF,
+			Updated:    r.Updated,
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetImageMetadata(ctx context.Context, req *satpb.GetImageMetadataRequest) (*satpb.ImageMetadata, error) {
+	if req.GetId() == "" {
+		return nil, missingID()
+	}
+	meta, err := s.api.imageMetadata(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &satpb.ImageMetadata{
+		Id:            meta.ID,
+		Key:           meta.Key,
+		ContentType:   meta.ContentType,
+		ContentLength: meta.ContentLength,
+		Etag:          meta.ETag,
+		LastModified:  meta.LastModified,
+		CaptureTime:   meta.CaptureTime,
+		Format:        meta.Format,
+		Width:         int32(meta.Width),
+		Height:        int32(meta.Height),
+		Geo:           geoProto(meta.Geo),
+		Exif:          meta.EXIF,
+		Quality:       qualityProto(meta.Quality),
+		Photometry:    photometryProto(meta.Photometry),
+	}, nil
+}
+
+// GetImage is GET /image/:id streamed in chunks. Processed output is served
+// from the derived-image cache when present, and otherwise encoded straight
+// into the stream; the in-memory cache, conditional requests and byte ranges
+// are HTTP concerns and are not used.
+func (s *grpcServer) GetImage(req *satpb.GetImageRequest, stream grpc.ServerStreamingServer[satpb.ImageChunk]) error {
+	api := s.api
+	ctx := stream.Context()
+	bucketName := api.Config.ImagesBucket
+	id := req.GetId()
+	if id == "" {
+		return missingID()
+	}
+	key := imageKey(id)
+
+	q := processingValues(req.GetOptions())
+	if lerr := api.Config.Limits.checkQuery(q); lerr != nil {
+		return grpcError(lerr.problem())
+	}
+	opts, err := parseProcessValues(q, req.GetAccept(), api.Overlay)
+	if err != nil {
+		return grpcError(newProblem(http.StatusBadRequest, CodeInvalidParameter, err.Error()))
+	}
+	if err := api.gateFormat(ctx, &opts, req.GetAccept()); err != nil {
+		return grpcError(err)
+	}
+	needsProcessing := opts.NeedsProcessing()
+
+	if opts.Annotate {
+		opts.Annotations, err = api.loadAnnotations(ctx, bucketName, id)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to load annotations", "id", id, "err", err)
+			return grpcError(newProblem(http.StatusInternalServerError, CodeInternal, "failed to retrieve annotations"))
+		}
+	}
+
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}
+	if needsProcessing || opts.StripMetadata {
+		in.Range = firstPart()
+	}
+	out, err := api.S3.GetObject(ctx, in, s3Accelerate(bucketName)...)
+	if err != nil {
+		slog.ErrorContext(ctx, "s3 GetObject failed", "key", key, "err", err)
+		return grpcError(newProblem(http.StatusNotFound, CodeImageNotFound, "object not found"))
+	}
+	defer out.Body.Close()
+
+	w := &chunkWriter{stream: stream, first: &satpb.ImageChunk{}}
+	if !needsProcessing && opts.StripMetadata {
+		raw, err := api.readObject(ctx, bucketName, key, out, out.Body)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to read object", "key", key, "err", err)
+			return grpcError(newProblem(http.StatusInternalServerError, CodeInternal, "failed to read image"))
+		}
+		defer putBuffer(raw)
+		data := raw.Bytes()
+		stripped, format, err := stripDownload(data)
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot strip metadata", "key", key, "err", err)
+			return grpcError(newProblem(http.StatusUnprocessableEntity, CodeImageUnsupported, "metadata cannot be stripped from this image format"))
+		}
+		if format == nil {
+			w.first.ContentType = aws.ToString(out.ContentType)
+			w.first.Size = int64(len(stripped))
+			return w.send(stripped)
+		}
+		opts.Format = format
+		out.Body = memoryBody{bytes.NewReader(data)}
+		out.ContentLength = aws.Int64(int64(len(data)))
+		needsProcessing = true
+	}
+
+	if !needsProcessing {
+		w.first.ContentType = aws.ToString(out.ContentType)
+		w.first.Etag = aws.ToString(out.ETag)
+		w.first.Size = objectSize(out)
+		if _, err := io.Copy(w, out.Body); err != nil {
+			slog.ErrorContext(ctx, "failed to stream image", "key", key, "err", err)
+			return grpcError(err)
+		}
+		return w.Close()
+	}
+
+	w.first.ContentType = opts.Format.ContentType
+	if out.ETag != nil {
+		w.first.Etag = processedETag(aws.ToString(out.ETag), opts)
+		if api.Derived != nil {
+			if obj, ok := api.Derived.Get(ctx, bucketName, derivedKey(id, aws.ToString(out.ETag), opts)); ok {
+				w.first.Size = int64(len(obj.Data))
+				return w.send(obj.Data)
+			}
+		}
+	}
+
+	img, geo, release, err := api.renderProcessed(ctx, bucketName, key, out, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, errCropOutside):
+			return grpcError(newProblem(http.StatusBadRequest, CodeInvalidParameter, err.Error()))
+		case errors.Is(err, errOverloaded):
+			return grpcError(newProblem(http.StatusServiceUnavailable, CodeOverloaded, "image processing capacity exhausted, retry shortly"))
+		case errors.Is(err, errImageTooLarge):
+			return grpcError(newProblem(http.StatusUnprocessableEntity, CodeImageTooLarge, imageTooLargeMessage()))
+		}
+		slog.ErrorContext(ctx, "failed to process image", "key", key, "err", err)
+		return grpcError(newProblem(http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image"))
+	}
+	err = encodeProcessed(w, img, opts, geo)
+	release()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode image", "key", key, "err", err)
+		return grpcError(newProblem(http.StatusInternalServerError, CodeImageEncodeFailed, "failed to encode image"))
+	}
+	return w.Close()
+}
+
+func (s *grpcServer) SubmitProcessJob(ctx context.Context, req *satpb.SubmitProcessJobRequest) (*satpb.Job, error) {
+	spec := map[string]any{}
+	for k, v := range processingValues(req.GetOptions()) {
+		spec[k] = v[0]
+	}
+	job, err := s.api.submitProcessJob(ctx, batchRequest{IDs: req.GetIds(), Spec: spec})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return jobProto(job), nil
+}
+
+func (s *grpcServer) GetJob(ctx context.Context, req *satpb.GetJobRequest) (*satpb.Job, error) {
+	if req.GetId() == "" {
+		return nil, missingID()
+	}
+	job, err := s.api.Jobs.Get(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, errJobNotFound) {
+			return nil, grpcError(newProblem(http.StatusNotFound, CodeJobNotFound, "job not found"))
+		}
+		slog.ErrorContext(ctx, "failed to load job", "id", req.GetId(), "err", err)
+		return nil, grpcError(newProblem(http.StatusInternalServerError, CodeInternal, "failed to retrieve job"))
+	}
+	return jobProto(job), nil
+}
+
+// chunkWriter sends what is written to it as ImageChunks of grpcChunkSize,
+// the first of them carrying the fields of first.
+type chunkWriter struct {
+	stream grpc.ServerStreamingServer[satpb.ImageChunk]
+	first  *satpb.ImageChunk
+	buf    []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, grpcChunkSize)
+		}
+		k := min(len(p), grpcChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		if len(w.buf) == grpcChunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush() error {
+	chunk := &satpb.ImageChunk{Data: w.buf}
+	if w.first != nil {
+		w.first.Data = w.buf
+		chunk, w.first = w.first, nil
+	}
+	// The stream may still hold the message after Send returns, so the
+	// next chunk gets a fresh buffer.
+	w.buf = nil
+	return w.stream.Send(chunk)
+}
+
+// Close sends what is buffered. An empty image still gets its first chunk.
+func (w *chunkWriter) Close() error {
+	if len(w.buf) == 0 && w.first == nil {
+		return nil
+	}
+	return w.flush()
+}
+
+// send writes data and closes w.
+func (w *chunkWriter) send(data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// processingValues is o as the query parameters of GET /image/:id.
+func processingValues(o *satpb.ProcessingOptions) url.Values {
+	q := url.Values{}
+	setInt := func(name string, v int32) {
+		if v != 0 {
+			q.Set(name, strconv.Itoa(int(v)))
+		}
+	}
+	setFloat := func(name string, v float64) {
+		if v != 0 {
+			q.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	setString := func(name, v string) {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	setBool := func(name string, v bool) {
+		if v {
+			q.Set(name, "true")
+		}
+	}
+	setInt("width", o.GetWidth())
+	setInt("height", o.GetHeight())
+	if r := o.GetCrop(); r.GetWidth() != 0 || r.GetHeight() != 0 {
+		q.Set("crop", strconv.Itoa(int(r.GetX()))+","+strconv.Itoa(int(r.GetY()))+","+strconv.Itoa(int(r.GetWidth()))+","+strconv.Itoa(int(r.GetHeight())))
+	}
+	setFloat("contrast", o.GetContrast())
+	setString("format", o.GetFormat())
+	setInt("quality", o.GetQuality())
+	setBool("lossless", o.GetLossless())
+	setString("compression", o.GetCompression())
+	setString("scale", o.GetScale())
+	setBool("starsuppress", o.GetStarSuppress())
+	setInt("starsuppress_cell", o.GetStarSuppressCell())
+	setFloat("starsuppress_sigma", o.GetStarSuppressSigma())
+	setString("overlay", o.GetOverlay())
+	setString("overlay_position", o.GetOverlayPosition())
+	setFloat("overlay_opacity", o.GetOverlayOpacity())
+	setBool("annotations", o.GetAnnotations())
+	setBool("stripMetadata", o.GetStripMetadata())
+	return q
+}
+
+func missionProto(m *Mission) *satpb.Mission {
+	return &satpb.Mission{
+		Id:                    m.ID,
+		Name:                  m.Name,
+		Status:                m.Status,
+		Priority:              int32(m.Priority),
+		TargetSatelliteId:     m.TargetSatelliteID,
+		ObserverSatelliteId:   m.ObserverSatelliteID,
+		Tca:                   m.TCA,
+		MinRangeKm:            m.MinRangeKM,
+		CollectionWindowStart: m.CollectionWindowStart,
+		CollectionWindowEnd:   m.CollectionWindowEnd,
+		CollectionType:        m.CollectionType,
+		PointingTarget:        m.PointingTarget,
+		ImageIds:              m.ImageIDs,
+		UpdatedAt:             m.UpdatedAt,
+	}
+}
+
+func qualityProto(q *QualityMetrics) *satpb.QualityMetrics {
+	if q == nil {
+		return nil
+	}
+	return &satpb.QualityMetrics{
+		Score:        q.Score,
+		BlurVariance: q.BlurVariance,
+		SnrDb:        q.SNR,
+		SaturatedPct: q.SaturatedPct,
+		Computed:     q.Computed,
+	}
+}
+
+func photometryProto(p *Photometry) *satpb.Photometry {
+	if p == nil {
+		return nil
+	}
+	return &satpb.Photometry{
+		X:           p.X,
+		Y:           p.Y,
+		Fwhm:        p.FWHM,
+		Flux:        p.Flux,
+		Snr:         p.SNR,
+		Peak:        p.Peak,
+		Background:  p.Background,
+		Aperture:    p.Aperture,
+		Saturated:   p.Saturated,
+		CaptureTime: p.CaptureTime,
+		Measured:    p.Measured,
+	}
+}
+
+func geoProto(g *GeoInfo) *satpb.GeoInfo {
+	if g == nil {
+		return nil
+	}
+	point := func(p [2]float64) *satpb.Point { return &satpb.Point{X: p[0], Y: p[1]} }
+	return &satpb.GeoInfo{
+		Crs:          g.CRS,
+		Geotransform: g.GeoTransform[:],
+		UpperLeft:    point(g.Corners.UpperLeft),
+		UpperRight:   point(g.Corners.UpperRight),
+		LowerLeft:    point(g.Corners.LowerLeft),
+		LowerRight:   point(g.Corners.LowerRight),
+		Center:       point(g.Corners.Center),
+		Width:        int32(g.Width),
+		Height:       int32(g.Height),
+	}
+}
+
+func jobProto(j Job) *satpb.Job {
+	pb := &satpb.Job{
+		Id:          j.ID,
+		Type:        j.Type,
+		Status:      j.Status,
+		Progress:    j.Progress,
+		Error:       j.Error,
+		Output:      j.Output,
+		ContentType: j.ContentType,
+		Attempts:    int32(j.Attempts),
+		MaxAttempts: int32(j.MaxAttempts),
+		NextAttempt: j.NextAttempt,
+		Created:     j.Created,
+		Updated:     j.Updated,
+	}
+	for _, item := range j.Items {
+		pb.Items = append(pb.Items, &satpb.JobItem{Id: item.ID, Status: item.Status, Output: item.Output, Error: item.Error})
+	}
+	return pb
+}
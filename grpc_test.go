@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"sat-thumbnail-server/satpb"
+)
+
+func testGRPC(t *testing.T, api *API) satpb.SatImageServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(api)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return satpb.NewSatImageServiceClient(conn)
+}
+
+func testGRPCAPI(t *testing.T) *API {
+	t.Helper()
+	db := testSQLStore(t)
+	return &API{
+		Config: &Config{ImagesBucket: "bucket", Limits: RequestLimits{
+			MaxDimension: defaultMaxOutputDimension, MaxCropPixels: defaultMaxImagePixels,
+			MaxBodyBytes: defaultMaxBodyBytes, MaxOps: defaultMaxRequestOps,
+		}},
+		MissionDB: db,
+		Images:    db,
+		S3:        testFSStore(t),
+		Jobs:      newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers),
+	}
+}
+
+// errorReason is the problem code attached to a gRPC error.
+func errorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestGRPCMissions(t *testing.T) {
+	api := testGRPCAPI(t)
+	db := api.MissionDB.(*sqlStore)
+	for _, m := range []Mission{{ID: "m1", Name: "one", ImageIDs: []string{"a", "b"}}, {ID: "m2", Name: "two"}} {
+		data, _ := json.Marshal(m)
+		if _, err := db.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", m.ID, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetImageAttribute(context.Background(), "b", "quality", QualityMetrics{Score: 80}); err != nil {
+		t.Fatal(err)
+	}
+	c := testGRPC(t, api)
+	ctx := context.Background()
+
+	page, err := c.ListMissions(ctx, &satpb.ListMissionsRequest{PageSize: 1})
+	if err != nil || len(page.Missions) != 1 || page.NextPageToken == "" {
+		t.Fatalf("ListMissions = %v, %v", page, err)
+	}
+	next, err := c.ListMissions(ctx, &satpb.ListMissionsRequest{PageSize: 1, PageToken: page.NextPageToken})
+	if err != nil || len(next.Missions) != 1 || next.Missions[0].Id == page.Missions[0].Id {
+		t.Errorf("second page = %v, %v", next, err)
+	}
+
+	var header metadata.MD
+	m, err := c.GetMission(metadata.AppendToOutgoingContext(ctx, "x-request-id", "req-1"), &satpb.GetMissionRequest{Id: "m1"}, grpc.Header(&header))
+	if err != nil || m.Name != "one" || len(m.ImageIds) != 2 {
+		t.Errorf("GetMission = %v, %v", m, err)
+	}
+	if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "req-1" {
+		t.Errorf("x-request-id = %v", ids)
+	}
+
+	_, err = c.GetMission(ctx, &satpb.GetMissionRequest{Id: "none"})
+	if status.Code(err) != codes.NotFound || errorReason(err) != string(CodeMissionNotFound) {
+		t.Errorf("missing mission: %v", err)
+	}
+	_, err = c.GetMission(ctx, &satpb.GetMissionRequest{})
+	if status.Code(err) != codes.InvalidArgument || errorReason(err) != string(CodeInvalidParameter) {
+		t.Errorf("empty id: %v", err)
+	}
+
+	images, err := c.ListMissionImages(ctx, &satpb.ListMissionImagesRequest{MissionId: "m1", MinQuality: proto64(50)})
+	if err != nil || len(images.Images) != 1 || images.Images[0].Id != "b" || images.Images[0].Quality.GetScore() != 80 {
+		t.Errorf("ListMissionImages = %v, %v", images, err)
+	}
+}
+
+func proto64(v float64) *float64 { return &v }
+
+func TestGRPCGetImage(t *testing.T) {
+	api := testGRPCAPI(t)
+	// Noise does not compress, so the PNG spans several chunks.
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 150))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(rand.N(256))
+	}
+	src.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+	_, err := api.S3.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String("bucket"),
+		Key:         aws.String(imageKey("x")),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("image/png"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testGRPC(t, api)
+
+	download := func(req *satpb.GetImageRequest) (*satpb.ImageChunk, []byte, int, error) {
+		stream, err := c.GetImage(context.Background(), req)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		var first *satpb.ImageChunk
+		var data []byte
+		chunks := 0
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return first, data, chunks, nil
+			}
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			if first == nil {
+				first = chunk
+			}
+			if len(chunk.Data) > grpcChunkSize {
+				t.Errorf("chunk of %d bytes", len(chunk.Data))
+			}
+			data = append(data, chunk.Data...)
+			chunks++
+		}
+	}
+
+	first, data, chunks, err := download(&satpb.GetImageRequest{Id: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks < 2 || !bytes.Equal(data, buf.Bytes()) || first.ContentType != "image/png" || first.Size != int64(buf.Len()) {
+		t.Errorf("original: %d chunks, %d bytes, first %q size %d", chunks, len(data), first.ContentType, first.Size)
+	}
+
+	first, data, _, err = download(&satpb.GetImageRequest{Id: "x", Options: &satpb.ProcessingOptions{Width: 50, Format: "png"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil || img.Bounds().Dx() != 50 || first.ContentType != "image/png" {
+		t.Errorf("processed: %v, %v, %q", img.Bounds(), err, first.ContentType)
+	}
+
+	_, _, _, err = download(&satpb.GetImageRequest{Id: "x", Options: &satpb.ProcessingOptions{Width: 100000}})
+	if status.Code(err) != codes.InvalidArgument || errorReason(err) != string(CodeLimitExceeded) {
+		t.Errorf("oversized width: %v", err)
+	}
+	_, _, _, err = download(&satpb.GetImageRequest{Id: "missing"})
+	if status.Code(err) != codes.NotFound || errorReason(err) != string(CodeImageNotFound) {
+		t.Errorf("missing image: %v", err)
+	}
+}
+
+func TestGRPCJobs(t *testing.T) {
+	c := testGRPC(t, testGRPCAPI(t))
+	ctx := context.Background()
+
+	_, err := c.SubmitProcessJob(ctx, &satpb.SubmitProcessJobRequest{Ids: []string{"x"}})
+	if status.Code(err) != codes.InvalidArgument || errorReason(err) != string(CodeInvalidBody) {
+		t.Errorf("job without a spec: %v", err)
+	}
+	job, err := c.SubmitProcessJob(ctx, &satpb.SubmitProcessJobRequest{Ids: []string{"x", "y"}, Options: &satpb.ProcessingOptions{Format: "png"}})
+	if err != nil || job.Type != "process" || len(job.Items) != 2 {
+		t.Fatalf("SubmitProcessJob = %v, %v", job, err)
+	}
+	got, err := c.GetJob(ctx, &satpb.GetJobRequest{Id: job.Id})
+	if err != nil || got.Id != job.Id {
+		t.Errorf("GetJob = %v, %v", got, err)
+	}
+	_, err = c.GetJob(ctx, &satpb.GetJobRequest{Id: "none"})
+	if status.Code(err) != codes.NotFound || errorReason(err) != string(CodeJobNotFound) {
+		t.Errorf("missing job: %v", err)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	api := testGRPCAPI(t)
+	api.Config.AuthRequired = true
+	api.Keys = newAPIKeyStore(nil, "")
+	ctx := context.Background()
+	images, err := api.Keys.Create(ctx, APIKey{Scopes: []string{ScopeImagesRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missions, err := api.Keys.Create(ctx, APIKey{Scopes: []string{ScopeMissionsRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testGRPC(t, api)
+
+	tests := []struct {
+		name string
+		auth string
+		want codes.Code
+	}{
+		{"no credentials", "", codes.Unauthenticated},
+		{"invalid key", "Bearer " + apiKeyPrefix + "0123_abcd", codes.Unauthenticated},
+		{"key without scope", "Bearer " + images.Key, codes.PermissionDenied},
+		{"key with scope", "Bearer " + missions.Key, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ctx
+			if tt.auth != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.auth)
+			}
+			_, err := c.ListMissions(ctx, &satpb.ListMissionsRequest{})
+			if status.Code(err) != tt.want {
+				t.Errorf("ListMissions: %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// readinessTTL is how long a readiness result is reused, so frequent
+	// probes from several load balancers cost one round of checks.
+	readinessTTL   = 5 * time.Second
+	readinessCheck = 2 * time.Second
+)
+
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// CheckResult is one dependency's state in GET /readyz.
+type CheckResult struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// ReadinessResponse is the body of GET /readyz.
+type ReadinessResponse struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]CheckResult `json:"checks"`
+	CheckedAt time.Time              `json:"checked_at"`
+}
+
+// Readiness checks that the dependencies a request needs are reachable: the
+// images bucket and the metadata and jobs tables or database. The
+// configuration has already been validated by the time it runs, since the
+// server does not start otherwise.
+type Readiness struct {
+	checks []dependencyCheck
+
+	mu   sync.Mutex
+	last *ReadinessResponse
+}
+
+func newReadiness(cfg *Config, db *dynamodb.Client, store ObjectStore, missions MissionStore) *Readiness {
+	r := &Readiness{}
+	r.add("storage", func(ctx context.Context) error {
+		_, err := store.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(cfg.ImagesBucket)})
+		return err
+	})
+	seen := map[string]bool{cfg.ImagesBucket: true}
+	for _, route := range cfg.Layout.Routes {
+		if seen[route.Bucket] {
+			continue
+		}
+		seen[route.Bucket] = true
+		loc := &ObjectLocation{Bucket: route.Bucket, Region: route.Region}
+		r.add("storage:"+route.Bucket, func(ctx context.Context) error {
+			_, err := store.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(loc.Bucket)}, loc.options()...)
+			return err
+		})
+	}
+
+	describe := func(table string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			_, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+			return err
+		}
+	}
+	switch s := missions.(type) {
+	case *dynamoStore:
+		r.add("dynamodb:"+s.missionTable, describe(s.missionTable))
+		if s.imageTable != "" {
+			r.add("dynamodb:"+s.imageTable, describe(s.imageTable))
+		}
+	case *sqlStore:
+		r.add(s.dialect, s.db.PingContext)
+	}
+	if cfg.JobsTable != "" {
+		r.add("dynamodb:"+cfg.JobsTable, describe(cfg.JobsTable))
+	}
+	if cfg.WebhooksTable != "" {
+		r.add("dynamodb:"+cfg.WebhooksTable, describe(cfg.WebhooksTable))
+	}
+	if cfg.APIKeysTable != "" {
+		r.add("dynamodb:"+cfg.APIKeysTable, describe(cfg.APIKeysTable))
+	}
+	if cfg.RolesTable != "" {
+		r.add("dynamodb:"+cfg.RolesTable, describe(cfg.RolesTable))
+	}
+	if cfg.AuditTable != "" {
+		r.add("dynamodb:"+cfg.AuditTable, describe(cfg.AuditTable))
+	}
+	if cfg.UsageTable != "" {
+		r.add("dynamodb:"+cfg.UsageTable, describe(cfg.UsageTable))
+	}
+	if cfg.StorageTable != "" {
+		r.add("dynamodb:"+cfg.StorageTable, describe(cfg.StorageTable))
+	}
+	if cfg.FlagsTable != "" {
+		r.add("dynamodb:"+cfg.FlagsTable, describe(cfg.FlagsTable))
+	}
+	if cfg.SatellitesTable != "" {
+		r.add("dynamodb:"+cfg.SatellitesTable, describe(cfg.SatellitesTable))
+	}
+	if cfg.TLETable != "" {
+		r.add("dynamodb:"+cfg.TLETable, describe(cfg.TLETable))
+	}
+	if cfg.ConjunctionsTable != "" {
+		r.add("dynamodb:"+cfg.ConjunctionsTable, describe(cfg.ConjunctionsTable))
+	}
+	if cfg.SensorsTable != "" {
+		r.add("dynamodb:"+cfg.SensorsTable, describe(cfg.SensorsTable))
+	}
+	if cfg.DownlinksTable != "" {
+		r.add("dynamodb:"+cfg.DownlinksTable, describe(cfg.DownlinksTable))
+	}
+	if cfg.HistoryTable != "" {
+		r.add("dynamodb:"+cfg.HistoryTable, describe(cfg.HistoryTable))
+	}
+	if cfg.HashesTable != "" {
+		r.add("dynamodb:"+cfg.HashesTable, describe(cfg.HashesTable))
+	}
+	if cfg.FootprintsTable != "" {
+		r.add("dynamodb:"+cfg.FootprintsTable, describe(cfg.FootprintsTable))
+	}
+	if cfg.ContentAddressed && cfg.ContentRefsTable != "" {
+		r.add("dynamodb:"+cfg.ContentRefsTable, describe(cfg.ContentRefsTable))
+	}
+	return r
+}
+
+func (r *Readiness) add(name string, check func(ctx context.Context) error) {
+	r.checks = append(r.checks, dependencyCheck{name, check})
+}
+
+// Check runs every check in parallel, each bounded by readinessCheck, unless
+// a result younger than readinessTTL can be reused.
+func (r *Readiness) Check(ctx context.Context) *ReadinessResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last != nil && time.Since(r.last.CheckedAt) < readinessTTL {
+		return r.last
+	}
+
+	resp := &ReadinessResponse{Status: "ready", Checks: map[string]CheckResult{"config": {Status: "ok"}}, CheckedAt: time.Now()}
+	results := make([]CheckResult, len(r.checks))
+	var wg sync.WaitGroup
+	for i, dc := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The result is shared, so one caller hanging up must not
+			// fail it for the others.
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readinessCheck)
+			defer cancel()
+			start := time.Now()
+			err := dc.check(ctx)
+			results[i] = CheckResult{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				results[i].Status, results[i].Error = "error", err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	for i, dc := range r.checks {
+		resp.Checks[dc.name] = results[i]
+		if results[i].Status != "ok" {
+			resp.Status = "unavailable"
+			slog.WarnContext(ctx, "readiness check failed", "check", dc.name, "err", results[i].Error)
+		}
+	}
+	r.last = resp
+	return resp
+}
+
+// getReadyz answers 200 when every dependency is reachable and 503 with the
+// failing checks otherwise, for load balancer and Kubernetes readiness
+// probes.
+func (r *Readiness) getReadyz(c *gin.Context) {
+	resp := r.Check(c.Request.Context())
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, resp)
+}
+
+// getHealthz serves /healthz and /livez. It reports only that the process is
+// up and serving requests, checking no dependencies, so an outage of S3 or
+// DynamoDB does not get every instance restarted; /readyz takes instances
+// out of rotation instead.
+func getHealthz(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
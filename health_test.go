@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := testFSStore(t)
+	missions := testSQLStore(t)
+	cfg := &Config{ImagesBucket: "bucket"}
+
+	get := func(r *Readiness) (int, ReadinessResponse) {
+		router := gin.New()
+		router.GET("/readyz", r.getReadyz)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp ReadinessResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	// The bucket directory does not exist until something is written.
+	r := newReadiness(cfg, nil, store, missions)
+	code, resp := get(r)
+	if code != http.StatusServiceUnavailable || resp.Checks["storage"].Status != "error" || resp.Checks["sqlite"].Status != "ok" {
+		t.Errorf("readyz = %d %+v, want 503 with storage failing", code, resp)
+	}
+
+	putTestObject(t, store, "a.tif", []byte("x"))
+	if code, _ := get(r); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz = %d within the cache TTL, want the cached 503", code)
+	}
+	code, resp = get(newReadiness(cfg, nil, store, missions))
+	if code != http.StatusOK || resp.Status != "ready" || resp.Checks["config"].Status != "ok" {
+		t.Errorf("readyz = %d %+v, want 200", code, resp)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/healthz", getHealthz)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"status":"ok"}` {
+		t.Errorf("healthz = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageRecord is the per-image item in IMAGE_TABLE, holding whatever the
+// ingest steps have derived from the pixels. Each step owns one top-level
+// attribute and writes it with SetImageAttribute, so steps never overwrite
+// one another's results.
+type ImageRecord struct {
+	ID         string               `dynamodbav:"id" json:"id"`
+	Quality    *QualityMetrics      `dynamodbav:"quality,omitempty" json:"quality,omitempty"`
+	Photometry *Photometry          `dynamodbav:"photometry,omitempty" json:"photometry,omitempty"`
+	Geometry   *ObservationGeometry `dynamodbav:"geometry,omitempty" json:"geometry,omitempty"`
+	// Contamination is scored only for earth-background frames.
+	Contamination *Contamination    `dynamodbav:"contamination,omitempty" json:"contamination,omitempty"`
+	WCS           *WCS              `dynamodbav:"wcs,omitempty" json:"wcs,omitempty"`
+	Detections    []Detection       `dynamodbav:"detections,omitempty" json:"detections,omitempty"`
+	Objects       *ObjectDetections `dynamodbav:"objects,omitempty" json:"objects,omitempty"`
+	PHash         string            `dynamodbav:"phash,omitempty" json:"phash,omitempty"`
+	EXIF          map[string]string `dynamodbav:"exif,omitempty" json:"exif,omitempty"`
+	// FITS holds the header cards of a FITS original.
+	FITS      map[string]string `dynamodbav:"fits,omitempty" json:"fits,omitempty"`
+	Footprint *Footprint        `dynamodbav:"footprint,omitempty" json:"footprint,omitempty"`
+	Ingest    *IngestRecord     `dynamodbav:"ingest,omitempty" json:"ingest,omitempty"`
+	// Versions are the image's ingested uploads, oldest first.
+	Versions []ImageVersion `dynamodbav:"versions,omitempty" json:"versions,omitempty"`
+	// Location is where the original is when it was uploaded under another
+	// extension than .jpg, or placed by IMAGE_KEY_TEMPLATE and
+	// BUCKET_ROUTES; nil is images/<id>.jpg in the images bucket.
+	Location *ObjectLocation `dynamodbav:"location,omitempty" json:"location,omitempty"`
+	Updated  int64           `dynamodbav:"updated,omitempty" json:"updated,omitempty"`
+}
+
+type MissionImagesResponse struct {
+	MissionID string        `json:"mission_id"`
+	Images    []ImageRecord `json:"images"`
+}
+
+// getMissionImages lists the mission's images with their derived metadata.
+// ?minQuality= keeps only frames whose quality score is at least the given
+// value, and ?maxCloud= those at most that percent cloud; frames that have
+// not been scored yet are left out when filtering.
+func (api *API) getMissionImages(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	minQuality := -1.0
+	if s := c.Query("minQuality"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 || v > 100 {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'minQuality' parameter. Must be a number between 0 and 100.")
+			return
+		}
+		minQuality = v
+	}
+	maxCloud := -1.0
+	if s := c.Query("maxCloud"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 || v > 100 {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'maxCloud' parameter. Must be a number between 0 and 100.")
+			return
+		}
+		maxCloud = v
+	}
+
+	resp, err := api.missionImages(c.Request.Context(), id, minQuality, maxCloud)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// missionImages lists the mission's image records, keeping only those
+// scoring at least minQuality and at most maxCloud percent cloud when they
+// are not negative.
+func (api *API) missionImages(ctx context.Context, id string, minQuality, maxCloud float64) (*MissionImagesResponse, error) {
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			return nil, newProblem(http.StatusNotFound, CodeMissionNotFound, "mission not found")
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		return nil, newProblem(http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+	}
+
+	records, err := api.Images.ImageRecords(ctx, mission.ImageIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load image records", "mission", id, "err", err)
+		return nil, newProblem(http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+	}
+
+	images := make([]ImageRecord, 0, len(mission.ImageIDs))
+	for _, imageID := range mission.ImageIDs {
+		record := ImageRecord{ID: imageID}
+		if r, ok := records[imageID]; ok {
+			record = *r
+		}
+		if minQuality >= 0 && (record.Quality == nil || record.Quality.Score < minQuality) {
+			continue
+		}
+		if maxCloud >= 0 && (record.Contamination == nil || record.Contamination.CloudPct > maxCloud) {
+			continue
+		}
+		images = append(images, record)
+	}
+	return &MissionImagesResponse{MissionID: id, Images: images}, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	IngestIngested = "ingested"
+	IngestRejected = "rejected"
+	// IngestDuplicate is an upload deleted as a copy of a stored image,
+	// which was linked to its mission in its place.
+	IngestDuplicate = "duplicate"
+)
+
+// defaultIngestMissionPattern links an image named after its mission and
+// frame number, such as demo-leo-inspection-07, to that mission.
+const defaultIngestMissionPattern = `^(.+)-\d+$`
+
+// ingestHeaderBytes is how much of an upload is read to check its format and
+// dimensions before the derivatives are generated.
+const ingestHeaderBytes = 64 << 10
+
+// IngestRecord is the outcome of ingesting an uploaded image, stored as the
+// ingest attribute of its ImageRecord.
+type IngestRecord struct {
+	Status string `dynamodbav:"status" json:"status"`
+	// Error says why an image was rejected.
+	Error  string `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	Format string `dynamodbav:"format,omitempty" json:"format,omitempty"`
+	// Width and Height are absent when the header read did not reach
+	// them.
+	Width  int   `dynamodbav:"width,omitempty" json:"width,omitempty"`
+	Height int   `dynamodbav:"height,omitempty" json:"height,omitempty"`
+	Bytes  int64 `dynamodbav:"bytes" json:"bytes"`
+	// SHA256 is the checksum of the whole upload. Verified says it
+	// matched the checksum the uploader declared.
+	SHA256   string `dynamodbav:"sha256,omitempty" json:"sha256,omitempty"`
+	Verified bool   `dynamodbav:"sha256_verified,omitempty" json:"sha256_verified,omitempty"`
+	// Duplicate is the stored image the upload copies, if any.
+	Duplicate *DuplicateMatch `dynamodbav:"duplicate_of,omitempty" json:"duplicate_of,omitempty"`
+	// MissionID is the mission the image was linked to, if any.
+	MissionID string `dynamodbav:"mission_id,omitempty" json:"mission_id,omitempty"`
+	// ContentAddressed says the upload was moved into the blob of its
+	// checksum.
+	ContentAddressed bool  `dynamodbav:"content_addressed,omitempty" json:"content_addressed,omitempty"`
+	Ingested         int64 `dynamodbav:"ingested" json:"ingested"`
+}
+
+// sniffFormat names the image format data starts with, or returns "" for
+// one the server cannot read.
+func sniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return "jpeg"
+	case bytes.HasPrefix(data, pngSignature):
+		return "png"
+	case isTIFFHeader(data):
+		return "tiff"
+	case isWebP(data):
+		return "webp"
+	case isFITS(data):
+		return "fits"
+	}
+	return ""
+}
+
+// ingestImage processes an upload reported by an S3 event: it checks that
+// the object is an image and not a duplicate, generates its derivatives,
+// which also records its quality and EXIF tags, links it to the mission its
+// ID names, records its observation geometry for that mission and its
+// footprint and records the outcome. An image that can never be processed is recorded as rejected
+// rather than failing, so its message is not redelivered.
+func (api *API) ingestImage(ctx context.Context, id string) error {
+	return api.ingestUpload(ctx, id, "")
+}
+
+// ingestUpload ingests the upload of image id to key, one of its source
+// keys, or with an empty key the first found.
+func (api *API) ingestUpload(ctx context.Context, id, key string) error {
+	bucketName := api.Config.ImagesBucket
+	if err := api.reclaimUpload(ctx, id, key); err != nil {
+		return err
+	}
+	// What this instance holds in memory is of the image the upload
+	// replaced.
+	api.forgetImage(ctx, id)
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(imageKey(id)),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", ingestHeaderBytes-1)),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+		// No range of an empty object is satisfiable.
+		return api.rejectImage(ctx, id, IngestRecord{Ingested: time.Now().Unix()}, "the object is empty")
+	}
+	if err != nil {
+		return err
+	}
+	head, err := io.ReadAll(out.Body)
+	out.Body.Close()
+	if err != nil {
+		return err
+	}
+	if out.Metadata[contentSumMetadata] != "" {
+		// The server wrote this pointer after ingesting the upload.
+		return nil
+	}
+	rec := IngestRecord{Status: IngestIngested, Format: sniffFormat(head), Bytes: objectSize(out), Ingested: time.Now().Unix()}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+		rec.Width, rec.Height = cfg.Width, cfg.Height
+	}
+	if rec.Format == "" {
+		return api.rejectImage(ctx, id, rec, "not a JPEG, PNG, TIFF, WebP or FITS image")
+	}
+
+	var declared string
+	if rec.SHA256, declared, err = api.objectChecksum(ctx, bucketName, imageKey(id)); err != nil {
+		return err
+	}
+	if declared != "" {
+		if declared != rec.SHA256 {
+			return api.rejectImage(ctx, id, rec, fmt.Sprintf("the upload's SHA-256 %s is not the declared %s", rec.SHA256, declared))
+		}
+		rec.Verified = true
+	}
+	rec.Duplicate = api.exactDuplicate(ctx, id, rec.SHA256)
+	if rec.Duplicate != nil && api.Config.Duplicates != duplicatesKeep {
+		return api.handleDuplicate(ctx, id, rec)
+	}
+	accept := func(hash uint64) error {
+		if rec.Duplicate == nil {
+			rec.Duplicate = api.nearDuplicate(ctx, id, hash)
+		}
+		if rec.Duplicate != nil && api.Config.Duplicates != duplicatesKeep {
+			return errDuplicateImage
+		}
+		return nil
+	}
+
+	if err := api.generateDerivatives(ctx, id, accept); err != nil {
+		if errors.Is(err, errDuplicateImage) {
+			return api.handleDuplicate(ctx, id, rec)
+		}
+		if isPermanent(err) && !isNotFound(err) {
+			return api.rejectImage(ctx, id, rec, err.Error())
+		}
+		return err
+	}
+	if err := api.Hashes.PutChecksum(ctx, tenantRecordID(ctx, id), rec.SHA256); err != nil {
+		slog.ErrorContext(ctx, "failed to record checksum", "id", id, "err", err)
+	}
+	if rec.Duplicate != nil {
+		slog.InfoContext(ctx, "kept duplicate upload", "id", id, "duplicate_of", rec.Duplicate.ImageID, "match", rec.Duplicate.Match)
+	}
+	if rec.MissionID, err = api.linkImage(ctx, id, id); err != nil {
+		return err
+	}
+	var geometry *ObservationGeometry
+	captured := parseCaptureTime(out.Metadata, out.LastModified)
+	if t, ok := fitsCaptureTime(head); ok && out.Metadata["capture-time"] == "" {
+		captured = t
+	}
+	if rec.MissionID != "" && !captured.IsZero() {
+		geometry = api.annotateGeometry(ctx, id, rec.MissionID, captured)
+	}
+	api.annotateFootprint(ctx, id, head, captured)
+	if rec.MissionID != "" && api.hasEarthBackground(ctx, rec.MissionID, geometry) {
+		api.annotateContamination(ctx, id)
+	}
+	if api.Detector != nil {
+		api.annotateObjects(ctx, id)
+	}
+	api.storeByContent(ctx, id, &rec)
+	api.recordVersion(ctx, id, rec, out)
+	api.accountStorage(ctx, id, rec)
+	api.placeOriginal(ctx, id, rec.MissionID, out.Metadata, captured)
+	api.recordIngest(ctx, id, rec)
+	ingestedImages.WithLabelValues(IngestIngested).Inc()
+	api.Events.Publish(Event{Type: EventImageIngested, ImageID: id, MissionID: rec.MissionID, Tenant: tenantFrom(ctx)})
+	return nil
+}
+
+func (api *API) rejectImage(ctx context.Context, id string, rec IngestRecord, reason string) error {
+	rec.Status = IngestRejected
+	rec.Error = reason
+	slog.WarnContext(ctx, "rejected uploaded image", "id", id, "reason", reason)
+	api.recordIngest(ctx, id, rec)
+	ingestedImages.WithLabelValues(IngestRejected).Inc()
+	return nil
+}
+
+func (api *API) recordIngest(ctx context.Context, id string, rec IngestRecord) {
+	api.releaseContent(ctx, id, rec)
+	if err := api.Images.SetImageAttribute(ctx, id, "ingest", rec); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to record ingest", "id", id, "err", err)
+	}
+}
+
+// storeByContent moves an ingested upload into the blob of its checksum when
+// originals are stored by content. One that cannot be moved stays where it
+// was uploaded.
+func (api *API) storeByContent(ctx context.Context, id string, rec *IngestRecord) {
+	if api.Content == nil {
+		return
+	}
+	key := tenantObjectKey(ctx, api.originalKey(ctx, id))
+	if err := api.Content.store(ctx, api.Config.ImagesBucket, key, rec.SHA256); err != nil {
+		slog.ErrorContext(ctx, "failed to store image by content", "id", id, "err", err)
+		return
+	}
+	rec.ContentAddressed = true
+}
+
+// releaseContent drops the reference to its blob of the upload that rec's
+// replaced, unless rec's is stored in the same one.
+func (api *API) releaseContent(ctx context.Context, id string, rec IngestRecord) {
+	if api.Content == nil {
+		return
+	}
+	prev, err := api.Images.ImageRecord(ctx, id)
+	if err != nil || prev == nil || prev.Ingest == nil || !prev.Ingest.ContentAddressed {
+		return
+	}
+	if rec.ContentAddressed && rec.SHA256 == prev.Ingest.SHA256 {
+		return
+	}
+	key := tenantObjectKey(ctx, api.originalKey(ctx, id))
+	blob := blobKey(key, prev.Ingest.SHA256)
+	if err := api.Content.release(ctx, api.Config.ImagesBucket, blob, key); err != nil {
+		slog.ErrorContext(ctx, "failed to release blob", "id", id, "blob", blob, "err", err)
+	}
+}
+
+// linkImage adds image id to the mission INGEST_MISSION_PATTERN takes from
+// the upload's ID, name, and returns that mission's ID: they differ when the
+// upload duplicated id. It returns "" when the pattern does not match or
+// names no existing mission.
+func (api *API) linkImage(ctx context.Context, name, id string) (string, error) {
+	re := api.Config.IngestMissionPattern
+	if re == nil {
+		return "", nil
+	}
+	m := re.FindStringSubmatch(name)
+	if m == nil || m[1] == "" {
+		return "", nil
+	}
+	missionID := m[1]
+	linked, err := api.MissionDB.LinkImage(ctx, missionID, id)
+	switch {
+	case errors.Is(err, errMissionNotFound):
+		slog.InfoContext(ctx, "no mission for ingested image", "id", id, "mission", missionID)
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	if linked {
+		slog.InfoContext(ctx, "linked ingested image", "id", id, "mission", missionID)
+		api.missionChanged(ctx, missionID)
+	}
+	return missionID, nil
+}
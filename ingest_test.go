@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"\xff\xd8\xff\xe0\x00\x10JFIF", "jpeg"},
+		{"\x89PNG\r\n\x1a\n\x00", "png"},
+		{"II*\x00\x08\x00\x00\x00", "tiff"},
+		{"MM\x00*\x00\x00\x00\x08", "tiff"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"GIF89a", ""},
+		{"<html>", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sniffFormat([]byte(tt.data)); got != tt.want {
+			t.Errorf("sniffFormat(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestIngestImage(t *testing.T) {
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	if err := putMission(ctx, meta, &Mission{ID: "demo-leo-inspection", ImageIDs: []string{"demo-leo-inspection-00"}}); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(id string, data []byte) {
+		t.Helper()
+		_, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern)},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        store,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		data    []byte
+		status  string
+		mission string
+		events  []string
+	}{
+		{"linked", "demo-leo-inspection-01", frame, IngestIngested, "demo-leo-inspection", []string{EventMissionUpdated, EventImageIngested}},
+		{"already linked", "demo-leo-inspection-00", frame, IngestIngested, "demo-leo-inspection", []string{EventImageIngested}},
+		{"no such mission", "demo-geo-survey-01", frame, IngestIngested, "", []string{EventImageIngested}},
+		{"no mission in name", "frame", frame, IngestIngested, "", []string{EventImageIngested}},
+		{"not an image", "notes-01", []byte("collection notes"), IngestRejected, "", nil},
+		{"undecodable", "cut-01", frame[:200], IngestRejected, "", nil},
+		{"empty", "empty-01", nil, IngestRejected, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			put(tt.id, tt.data)
+			events, cancel := api.Events.Subscribe(8, nil)
+			defer cancel()
+			if err := api.ingestImage(ctx, tt.id); err != nil {
+				t.Fatal(err)
+			}
+
+			record, err := meta.ImageRecord(ctx, tt.id)
+			if err != nil || record == nil || record.Ingest == nil {
+				t.Fatalf("ImageRecord = %+v, %v", record, err)
+			}
+			got := record.Ingest
+			if got.Status != tt.status || got.MissionID != tt.mission || got.Bytes != int64(len(tt.data)) || (got.Status == IngestRejected) == (got.Error == "") {
+				t.Errorf("ingest = %+v", got)
+			}
+			if tt.status == IngestIngested && (got.Format != "jpeg" || got.Width != seedImageSize || got.Height != seedImageSize) {
+				t.Errorf("ingest = %+v", got)
+			}
+			_, err = store.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(pyramidManifestKey(tt.id))})
+			if (err == nil) != (tt.status == IngestIngested) {
+				t.Errorf("pyramid manifest: %v", err)
+			}
+
+			var types []string
+			for len(events) > 0 {
+				e := <-events
+				types = append(types, e.Type)
+				if e.Type == EventImageIngested && (e.ImageID != tt.id || e.MissionID != tt.mission) {
+					t.Errorf("event = %+v", e)
+				}
+			}
+			if !slices.Equal(types, tt.events) {
+				t.Errorf("events = %v, want %v", types, tt.events)
+			}
+		})
+	}
+
+	m, err := meta.Mission(ctx, "demo-leo-inspection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"demo-leo-inspection-00", "demo-leo-inspection-01"}; !slices.Equal(m.ImageIDs, want) || m.UpdatedAt == 0 {
+		t.Errorf("mission = %+v, want images %v", m, want)
+	}
+	if err := api.ingestImage(ctx, "deleted-01"); !isPermanent(err) {
+		t.Errorf("deleted image: err = %v", err)
+	}
+}
+
+func TestIngestReplacesMemoryCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reprocessed, err := seedImage(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        store,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+		Memory:    newMemoryCache(defaultMemoryCacheMB<<20, defaultMemoryCacheTTL),
+	}
+	router := gin.New()
+	router.GET("/image/:id", api.getSatImageByID)
+	get := func(path string) []byte {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+		return w.Body.Bytes()
+	}
+	ingest := func(data []byte) {
+		t.Helper()
+		_, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey("m31")), Body: bytes.NewReader(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ingestImage(ctx, "m31"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ingest(frame)
+	get("/image/m31")
+	small := get("/image/m31?width=32&format=png")
+	ingest(reprocessed)
+	if got := get("/image/m31"); !bytes.Equal(got, reprocessed) {
+		t.Error("the replaced original was served from memory")
+	}
+	if got := get("/image/m31?width=32&format=png"); bytes.Equal(got, small) {
+		t.Error("output of the replaced original was served from memory")
+	}
+}
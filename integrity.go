@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// integrityPage is how many missions and image records are read at a
+	// time.
+	integrityPage = 100
+	// maxIntegrityFindings is the most findings of each kind a report
+	// lists; its count covers them all.
+	maxIntegrityFindings = 1000
+	// integrityLatestKey holds the report of the last check to finish, in
+	// the tenant it covered.
+	integrityLatestKey = "integrity/latest.json"
+)
+
+const (
+	RepairUnlink = "unlink"
+	RepairIngest = "ingest"
+	RepairLink   = "link"
+)
+
+var errNoMissionForImage = errors.New("no mission matches the image ID")
+
+// integrityRepairs are the fixes a check may make: unlink drops the image
+// IDs missions list with no object, ingest ingests objects with no image
+// record, and link links recorded images to their mission.
+var integrityRepairs = []string{RepairUnlink, RepairIngest, RepairLink}
+
+// IntegrityFinding is one inconsistency a check found, and what it did
+// about it.
+type IntegrityFinding struct {
+	// Tenant is set when the check covered every tenant.
+	Tenant    string `json:"tenant,omitempty"`
+	ImageID   string `json:"image_id"`
+	MissionID string `json:"mission_id,omitempty"`
+	// Repaired is the repair made, and Error why it failed.
+	Repaired string `json:"repaired,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// IntegrityFindings lists up to maxIntegrityFindings of Count findings.
+type IntegrityFindings struct {
+	Count int                `json:"count"`
+	Items []IntegrityFinding `json:"items"`
+}
+
+func (f *IntegrityFindings) add(finding IntegrityFinding) *IntegrityFinding {
+	f.Count++
+	if len(f.Items) >= maxIntegrityFindings {
+		return nil
+	}
+	f.Items = append(f.Items, finding)
+	return &f.Items[len(f.Items)-1]
+}
+
+// IntegrityReport is the output of an integrity check.
+type IntegrityReport struct {
+	JobID    string   `json:"job_id"`
+	Started  int64    `json:"started"`
+	Finished int64    `json:"finished"`
+	Repair   []string `json:"repair,omitempty"`
+	// Objects, Records and Missions are how many of each were read.
+	Objects  int `json:"objects"`
+	Records  int `json:"records"`
+	Missions int `json:"missions"`
+	// MissingObjects are images missions list that have no object.
+	MissingObjects IntegrityFindings `json:"missing_objects"`
+	// UnrecordedObjects are objects under images/ with no image record.
+	UnrecordedObjects IntegrityFindings `json:"unrecorded_objects"`
+	// UnlinkedRecords are image records no mission lists, other than those
+	// of rejected and duplicate uploads.
+	UnlinkedRecords IntegrityFindings `json:"unlinked_records"`
+}
+
+// integritySpec is the input of an integrity job.
+type integritySpec struct {
+	Repair []string `json:"repair,omitempty"`
+}
+
+// parseRepairs reads a comma-separated list of integrityRepairs.
+func parseRepairs(v string) ([]string, error) {
+	repairs := splitList(v)
+	for _, r := range repairs {
+		if !slices.Contains(integrityRepairs, r) {
+			return nil, fmt.Errorf("%q is not unlink, ingest or link", r)
+		}
+	}
+	return repairs, nil
+}
+
+// integrityRef names an image across tenants, as tenantRecordID does.
+func integrityRef(tenant, id string) string {
+	if tenant == "" {
+		return id
+	}
+	return tenant + "/" + id
+}
+
+// splitIntegrityID returns the tenant and ID of a raw record ID, which
+// carries its tenant only when the check covers every tenant.
+func (api *API) splitIntegrityID(ctx context.Context, raw string) (tenant, id string) {
+	if api.Config.MultiTenant && tenantFrom(ctx) == "" {
+		if tenant, id, ok := splitRecordID(raw); ok {
+			return tenant, id
+		}
+	}
+	return "", raw
+}
+
+// imageObjects returns the images with an object under images/, by
+// integrityRef. Without a tenant in ctx, every tenant's are included.
+func (api *API) imageObjects(ctx context.Context) (map[string]bool, error) {
+	roots := []string{"images/"}
+	if api.Config.MultiTenant && tenantFrom(ctx) == "" {
+		roots = append(roots, tenantObjectRoot)
+	}
+	objects := map[string]bool{}
+	for _, root := range roots {
+		pages := s3.NewListObjectsV2Paginator(api.S3, &s3.ListObjectsV2Input{
+			Bucket: aws.String(api.Config.ImagesBucket),
+			Prefix: aws.String(root),
+		})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("list %s: %w", root, err)
+			}
+			for _, obj := range page.Contents {
+				tenant, rest := "", aws.ToString(obj.Key)
+				if root == tenantObjectRoot {
+					var ok bool
+					if tenant, rest, ok = splitObjectKey(rest); !ok {
+						continue
+					}
+				}
+				if id, ok := imageIDFromKey(rest); ok {
+					objects[integrityRef(tenant, id)] = true
+				}
+			}
+		}
+	}
+	return objects, nil
+}
+
+// checkIntegrity compares the bucket's images with the image records and
+// missions, making the repairs asked for, and reports what it found.
+// Without a tenant in ctx, it covers every tenant.
+func (api *API) checkIntegrity(ctx context.Context, jobID string, repair []string) (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		JobID:             jobID,
+		Started:           time.Now().Unix(),
+		Repair:            repair,
+		MissingObjects:    IntegrityFindings{Items: []IntegrityFinding{}},
+		UnrecordedObjects: IntegrityFindings{Items: []IntegrityFinding{}},
+		UnlinkedRecords:   IntegrityFindings{Items: []IntegrityFinding{}},
+	}
+	objects, err := api.imageObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	api.Jobs.SetProgress(jobID, 0.2)
+
+	records := map[string]*ImageRecord{}
+	for token := ""; ; {
+		page, next, err := api.Images.ListImageRecords(ctx, integrityPage, token)
+		if errors.Is(err, errImageTableUnset) {
+			return nil, permanent(errors.New("the integrity check needs IMAGE_TABLE"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list image records: %w", err)
+		}
+		for i := range page {
+			tenant, id := api.splitIntegrityID(ctx, page[i].ID)
+			ref := integrityRef(tenant, id)
+			records[ref] = &page[i]
+			if loc := page[i].Location; loc != nil && api.Layout != nil && api.Layout.placed(withTenant(ctx, tenant), loc) {
+				objects[ref] = true
+			}
+		}
+		if token = next; token == "" {
+			break
+		}
+	}
+	report.Records = len(records)
+	report.Objects = len(objects)
+	api.Jobs.SetProgress(jobID, 0.4)
+
+	linked := map[string]bool{}
+	for token := ""; ; {
+		page, next, err := api.MissionDB.Missions(ctx, integrityPage, token)
+		if err != nil {
+			return nil, fmt.Errorf("list missions: %w", err)
+		}
+		for _, m := range page {
+			report.Missions++
+			tenant, missionID := api.splitIntegrityID(ctx, m.ID)
+			for _, imageID := range m.ImageIDs {
+				ref := integrityRef(tenant, imageID)
+				linked[ref] = true
+				if objects[ref] {
+					continue
+				}
+				f := report.MissingObjects.add(IntegrityFinding{Tenant: tenant, ImageID: imageID, MissionID: missionID})
+				if slices.Contains(repair, RepairUnlink) {
+					api.repairFinding(ctx, f, RepairUnlink, api.unlinkMissing(withTenant(ctx, tenant), missionID, imageID))
+				}
+			}
+		}
+		if token = next; token == "" {
+			break
+		}
+	}
+	api.Jobs.SetProgress(jobID, 0.6)
+
+	for _, ref := range slices.Sorted(maps.Keys(objects)) {
+		if records[ref] != nil {
+			continue
+		}
+		tenant, id := api.splitIntegrityID(ctx, ref)
+		f := report.UnrecordedObjects.add(IntegrityFinding{Tenant: tenant, ImageID: id})
+		if slices.Contains(repair, RepairIngest) {
+			api.repairFinding(ctx, f, RepairIngest, api.ingestImage(withTenant(ctx, tenant), id))
+		}
+	}
+	api.Jobs.SetProgress(jobID, 0.8)
+
+	for _, ref := range slices.Sorted(maps.Keys(records)) {
+		rec := records[ref]
+		if linked[ref] || rec.Ingest != nil && (rec.Ingest.Status == IngestRejected || rec.Ingest.Status == IngestDuplicate) {
+			continue
+		}
+		tenant, id := api.splitIntegrityID(ctx, ref)
+		finding := IntegrityFinding{Tenant: tenant, ImageID: id}
+		if rec.Ingest != nil {
+			finding.MissionID = rec.Ingest.MissionID
+		}
+		f := report.UnlinkedRecords.add(finding)
+		if slices.Contains(repair, RepairLink) && objects[ref] {
+			missionID, err := api.relinkImage(withTenant(ctx, tenant), id, finding.MissionID)
+			if err == nil && missionID == "" {
+				err = errNoMissionForImage
+			}
+			if f != nil && missionID != "" {
+				f.MissionID = missionID
+			}
+			api.repairFinding(ctx, f, RepairLink, err)
+		}
+	}
+	report.Finished = time.Now().Unix()
+	for kind, findings := range map[string]IntegrityFindings{
+		"missing_object":    report.MissingObjects,
+		"unrecorded_object": report.UnrecordedObjects,
+		"unlinked_record":   report.UnlinkedRecords,
+	} {
+		integrityFindings.WithLabelValues(kind).Set(float64(findings.Count))
+	}
+	return report, nil
+}
+
+// repairFinding records the outcome of a repair on f, which is nil when
+// the report has no room left for it.
+func (api *API) repairFinding(ctx context.Context, f *IntegrityFinding, repair string, err error) {
+	if err != nil {
+		slog.ErrorContext(ctx, "integrity repair failed", "repair", repair, "err", err)
+	}
+	if f == nil {
+		return
+	}
+	if err != nil {
+		f.Error = err.Error()
+		return
+	}
+	f.Repaired = repair
+}
+
+// unlinkMissing removes imageID from the mission.
+func (api *API) unlinkMissing(ctx context.Context, missionID, imageID string) error {
+	removed, err := api.MissionDB.UnlinkImage(ctx, missionID, imageID)
+	if err != nil {
+		return err
+	}
+	if removed {
+		slog.InfoContext(ctx, "unlinked missing image", "id", imageID, "mission", missionID)
+		api.missionChanged(ctx, missionID)
+	}
+	return nil
+}
+
+// relinkImage links image id to missionID, the mission its ingest record
+// names, or to the one INGEST_MISSION_PATTERN takes from its ID when that is
+// empty, and returns the mission's ID, which is "" when there is none.
+func (api *API) relinkImage(ctx context.Context, id, missionID string) (string, error) {
+	if missionID == "" {
+		return api.linkImage(ctx, id, id)
+	}
+	linked, err := api.MissionDB.LinkImage(ctx, missionID, id)
+	if err != nil {
+		return "", err
+	}
+	if linked {
+		slog.InfoContext(ctx, "relinked image", "id", id, "mission", missionID)
+		api.missionChanged(ctx, missionID)
+	}
+	return missionID, nil
+}
+
+// runIntegrityJob runs a check and stores its report as the job's output and
+// as the latest report.
+func (api *API) runIntegrityJob(ctx context.Context, job Job) (string, string, error) {
+	var spec integritySpec
+	if err := json.Unmarshal(job.Params, &spec); err != nil {
+		return "", "", permanent(fmt.Errorf("decode params: %w", err))
+	}
+	report, err := api.checkIntegrity(ctx, job.ID, spec.Repair)
+	if err != nil {
+		return "", "", err
+	}
+	slog.InfoContext(ctx, "integrity check finished", "job", job.ID,
+		"missing_objects", report.MissingObjects.Count, "unrecorded_objects", report.UnrecordedObjects.Count, "unlinked_records", report.UnlinkedRecords.Count)
+
+	body, _ := json.MarshalIndent(report, "", "    ")
+	outKey := fmt.Sprintf("integrity/%s.json", job.ID)
+	for _, key := range []string{outKey, integrityLatestKey} {
+		if _, err := api.S3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(api.Config.ImagesBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		}); err != nil {
+			slog.ErrorContext(ctx, "s3 PutObject failed", "key", key, "err", err)
+			return "", "", fmt.Errorf("store report: %w", err)
+		}
+	}
+	return outKey, "application/json", nil
+}
+
+// integrityStatusURL is where the report of check id is served.
+func integrityStatusURL(id string) string {
+	return "/admin/maintenance/integrity/" + id
+}
+
+// postIntegrityCheck starts an integrity check of the request's tenant, or
+// of every tenant without one, making the repairs in ?repair=.
+func (api *API) postIntegrityCheck(c *gin.Context) {
+	repair, err := parseRepairs(c.Query("repair"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "repair "+err.Error())
+		return
+	}
+	job, err := api.Jobs.Submit(c.Request.Context(), "integrity", integritySpec{Repair: repair}, nil)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to submit integrity job", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to start job")
+		return
+	}
+	accepted := newJobAccepted(job)
+	accepted.StatusURL = integrityStatusURL(job.ID)
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// getIntegrityReport serves the report of the check :id, or of the last
+// check to finish without one.
+func (api *API) getIntegrityReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	key := integrityLatestKey
+	if id := c.Param("id"); id != "" {
+		job, err := api.Jobs.Get(ctx, id)
+		if errors.Is(err, errJobNotFound) || err == nil && job.Type != "integrity" {
+			respondError(c, http.StatusNotFound, CodeJobNotFound, "integrity check not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to load job", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve job")
+			return
+		}
+		if job.Status != JobSucceeded || job.Output == "" {
+			p := newProblem(http.StatusConflict, CodeJobNotFinished, "integrity check has no report yet").with("job_status", job.Status)
+			if job.Error != "" {
+				p = p.with("job_error", job.Error)
+			}
+			respondProblem(c, p)
+			return
+		}
+		key = job.Output
+	}
+
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(api.Config.ImagesBucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no integrity check has finished")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "s3 GetObject failed", "key", key, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read the integrity report")
+		return
+	}
+	defer out.Body.Close()
+	c.Header("Content-Type", "application/json")
+	if out.ContentLength != nil {
+		c.Header("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, out.Body); err != nil {
+		slog.ErrorContext(ctx, "streaming failed", "key", key, "err", err)
+	}
+}
+
+// integritySchedule starts an integrity check of every tenant each
+// interval, unless one is already queued or running.
+type integritySchedule struct {
+	api      *API
+	interval time.Duration
+	repair   []string
+}
+
+// Start submits checks until ctx is done, when interval is set. The first
+// is an interval after startup, so restarts do not each start one.
+func (s integritySchedule) Start(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.submit(ctx); err != nil {
+				slog.ErrorContext(ctx, "failed to schedule integrity check", "err", err)
+			}
+		}
+	}()
+}
+
+func (s integritySchedule) submit(ctx context.Context) error {
+	for _, status := range []string{JobQueued, JobRunning} {
+		active, _, err := s.api.Jobs.List(ctx, "integrity", status, 1, "")
+		if err != nil {
+			return err
+		}
+		if len(active) > 0 {
+			slog.InfoContext(ctx, "integrity check already running", "job", active[0].ID)
+			return nil
+		}
+	}
+	job, err := s.api.Jobs.Submit(ctx, "integrity", integritySpec{Repair: s.repair}, nil)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "scheduled integrity check", "job", job.ID, "repair", s.repair)
+	return nil
+}
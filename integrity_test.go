@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"m-01", "m-03", "m-04", "x-05"} {
+		_, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(frame)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// m-02 is listed with no object, m-03 has no record, m-04 is in no
+	// mission and x-05 was rejected.
+	if err := putMission(ctx, meta, &Mission{ID: "m", ImageIDs: []string{"m-01", "m-02"}}); err != nil {
+		t.Fatal(err)
+	}
+	for id, rec := range map[string]IngestRecord{
+		"m-01": {Status: IngestIngested, MissionID: "m"},
+		"m-04": {Status: IngestIngested},
+		"x-05": {Status: IngestRejected, Error: "not an image"},
+	} {
+		if err := meta.SetImageAttribute(ctx, id, "ingest", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern)},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        store,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+		Jobs:      newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers),
+	}
+
+	report, err := api.checkIntegrity(ctx, "j1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Objects != 4 || report.Records != 3 || report.Missions != 1 {
+		t.Errorf("read %d objects, %d records, %d missions", report.Objects, report.Records, report.Missions)
+	}
+	ids := func(f IntegrityFindings) []string {
+		var ids []string
+		for _, item := range f.Items {
+			ids = append(ids, item.ImageID+":"+item.Repaired)
+		}
+		return ids
+	}
+	for name, tt := range map[string]struct {
+		got  IntegrityFindings
+		want []string
+	}{
+		"missing objects":    {report.MissingObjects, []string{"m-02:"}},
+		"unrecorded objects": {report.UnrecordedObjects, []string{"m-03:"}},
+		"unlinked records":   {report.UnlinkedRecords, []string{"m-04:"}},
+	} {
+		if got := ids(tt.got); !slices.Equal(got, tt.want) || tt.got.Count != len(tt.want) {
+			t.Errorf("%s = %v (%d), want %v", name, got, tt.got.Count, tt.want)
+		}
+	}
+
+	report, err = api.checkIntegrity(ctx, "j2", integrityRepairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(report.MissingObjects); !slices.Equal(got, []string{"m-02:unlink"}) {
+		t.Errorf("missing objects = %v", got)
+	}
+	if got := ids(report.UnrecordedObjects); !slices.Equal(got, []string{"m-03:ingest"}) {
+		t.Errorf("unrecorded objects = %v", got)
+	}
+	if got := ids(report.UnlinkedRecords); !slices.Equal(got, []string{"m-04:link"}) || report.UnlinkedRecords.Items[0].MissionID != "m" {
+		t.Errorf("unlinked records = %v", report.UnlinkedRecords.Items)
+	}
+	m, err := meta.Mission(ctx, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(slices.Values(m.ImageIDs)); !slices.Equal(got, []string{"m-01", "m-03", "m-04"}) {
+		t.Errorf("mission images after repair = %v", m.ImageIDs)
+	}
+
+	report, err = api.checkIntegrity(ctx, "j3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.MissingObjects.Count + report.UnrecordedObjects.Count + report.UnlinkedRecords.Count; n != 0 {
+		t.Errorf("%d findings after repair: %+v", n, report)
+	}
+}
+
+func TestIntegrityRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", MaintenanceToken: "ops-secret"},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        store,
+		Jobs:      newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers),
+	}
+	router := gin.New()
+	api.routesMaintenance(router.Group("/admin/maintenance", api.require(ScopeMaintenance)), func(c *gin.Context) {}, func(c *gin.Context) {})
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer ops-secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodGet, "/admin/maintenance/integrity"); w.Code != http.StatusNotFound {
+		t.Errorf("latest report before any check: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/admin/maintenance/integrity?repair=delete"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown repair: %d", w.Code)
+	}
+	w := do(http.MethodPost, "/admin/maintenance/integrity?repair=unlink")
+	var accepted JobAccepted
+	if err := json.Unmarshal(w.Body.Bytes(), &accepted); w.Code != http.StatusAccepted || err != nil || !strings.HasPrefix(accepted.StatusURL, "/admin/maintenance/integrity/") {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, accepted.StatusURL); w.Code != http.StatusConflict {
+		t.Errorf("report of a queued check: %d", w.Code)
+	}
+	job, err := api.Jobs.Get(ctx, accepted.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, contentType, err := api.runIntegrityJob(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Jobs.Succeed(job.ID, output, contentType)
+
+	for _, path := range []string{accepted.StatusURL, "/admin/maintenance/integrity"} {
+		w := do(http.MethodGet, path)
+		var report IntegrityReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); w.Code != http.StatusOK || err != nil || report.JobID != accepted.JobID || !slices.Equal(report.Repair, []string{RepairUnlink}) {
+			t.Errorf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+	}
+	if w := do(http.MethodGet, "/admin/maintenance/integrity/nope"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown check: %d", w.Code)
+	}
+}
@@ -0,0 +1,841 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+const (
+	defaultJobWorkers  = 4
+	defaultJobAttempts = 3
+	jobQueueSize       = 256
+	// Retries wait jobRetryBase, doubling per attempt, up to jobRetryMax.
+	jobRetryBase = 5 * time.Second
+	jobRetryMax  = 5 * time.Minute
+	// Active jobs are re-saved every jobHeartbeat. A queued or running job
+	// not touched for jobStaleAfter is assumed orphaned by a dead instance
+	// and is taken over.
+	jobHeartbeat  = 15 * time.Second
+	jobStaleAfter = 2 * time.Minute
+	// jobRetention is how long finished jobs are kept in memory and, via
+	// the table's TTL attribute, in JOBS_TABLE.
+	jobRetention   = 7 * 24 * time.Hour
+	defaultJobList = 50
+	maxJobList     = 500
+	// Stale jobs are looked for a page of jobAdoptPage at a time.
+	jobAdoptPage          = 100
+	defaultJobStatusIndex = "status-created"
+)
+
+var errJobNotFound = errors.New("job not found")
+
+// Job tracks long-running work started by a request. Output, when set, is an
+// S3 key in the images bucket served by GET /jobs/:id/output.
+type Job struct {
+	ID   string `dynamodbav:"id" json:"id"`
+	Type string `dynamodbav:"type" json:"type"`
+	// Tenant is the tenant the submitting request acted for, which the job
+	// runs as and which alone may see it.
+	Tenant string `dynamodbav:"tenant,omitempty" json:"-"`
+	// Client is who submitted the job, which its processing is charged to.
+	Client      string  `dynamodbav:"client,omitempty" json:"-"`
+	Status      string  `dynamodbav:"status" json:"status"`
+	Progress    float64 `dynamodbav:"progress" json:"progress"`
+	Error       string  `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	Output      string  `dynamodbav:"output,omitempty" json:"output,omitempty"`
+	ContentType string  `dynamodbav:"content_type,omitempty" json:"content_type,omitempty"`
+	Attempts    int     `dynamodbav:"attempts" json:"attempts"`
+	MaxAttempts int     `dynamodbav:"max_attempts" json:"max_attempts"`
+	// NextAttempt is when a job waiting to be retried will run again.
+	NextAttempt int64 `dynamodbav:"next_attempt,omitempty" json:"next_attempt,omitempty"`
+	// Params is the job's input as submitted, which is all a retry or
+	// another instance needs to run it again.
+	Params  json.RawMessage `dynamodbav:"params,omitempty" json:"params,omitempty"`
+	Created int64           `dynamodbav:"created" json:"created"`
+	Updated int64           `dynamodbav:"updated" json:"updated"`
+	// Items reports per-image results for jobs that cover several images.
+	Items   []JobItem `dynamodbav:"items,omitempty" json:"items,omitempty"`
+	Expires int64     `dynamodbav:"expires,omitempty" json:"-"`
+	// Version orders writes to JOBS_TABLE so a stale snapshot never
+	// overwrites a newer one.
+	Version int64 `dynamodbav:"version" json:"-"`
+}
+
+type JobItem struct {
+	ID     string `dynamodbav:"id" json:"id"`
+	Status string `dynamodbav:"status" json:"status"`
+	Output string `dynamodbav:"output,omitempty" json:"output,omitempty"`
+	Error  string `dynamodbav:"error,omitempty" json:"error,omitempty"`
+}
+
+func (j *Job) active() bool {
+	return j.Status == JobQueued || j.Status == JobRunning
+}
+
+// JobFunc runs one attempt of a job and returns the S3 key and content type of
+// its output. It may report progress with SetProgress.
+type JobFunc func(ctx context.Context, job Job) (output, contentType string, err error)
+
+// permanentError marks a job failure that retrying cannot fix.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+func permanent(err error) error { return permanentError{err} }
+
+// waitError asks for a job to be run again after a delay without using up an
+// attempt, for work waiting on something outside the server.
+type waitError struct {
+	error
+	after time.Duration
+}
+
+func (e waitError) Unwrap() error { return e.error }
+
+func retryAfter(after time.Duration, err error) error { return waitError{err, after} }
+
+// isPermanent reports whether err is one that retrying cannot fix: a failure
+// marked permanent, a missing object, or a source over the decode limit.
+func isPermanent(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm) || isNotFound(err) || errors.Is(err, errImageTooLarge)
+}
+
+// JobStore queues jobs and runs them on a pool of JOB_WORKERS workers. When
+// JOBS_TABLE is set every job is also saved to DynamoDB, so status survives a
+// restart, any instance can answer for any job, and work orphaned by a dead
+// instance is picked up again. Without it jobs live only in this process.
+type JobStore struct {
+	mu       sync.RWMutex
+	jobs     map[string]*Job
+	handlers map[string]JobFunc
+	queue    chan string
+	attempts int
+	workers  int
+
+	db          *dynamodb.Client
+	table       string
+	statusIndex string
+}
+
+// newJobStore returns a store running each job up to attempts times on
+// workers workers. statusIndex names table's global secondary index on
+// status, sorted by created, so listing and adopting jobs never scans the
+// whole table.
+func newJobStore(db *dynamodb.Client, table, statusIndex string, attempts, workers int) *JobStore {
+	s := &JobStore{
+		jobs:        make(map[string]*Job),
+		handlers:    make(map[string]JobFunc),
+		queue:       make(chan string, jobQueueSize),
+		attempts:    attempts,
+		workers:     workers,
+		table:       table,
+		statusIndex: statusIndex,
+	}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Register sets the function that runs jobs of jobType. It must be called
+// before Start.
+func (s *JobStore) Register(jobType string, fn JobFunc) {
+	s.handlers[jobType] = fn
+}
+
+// Start launches the workers and the heartbeat that keeps active jobs fresh
+// and adopts stale ones.
+func (s *JobStore) Start(ctx context.Context) {
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-s.queue:
+					s.run(ctx, id)
+				}
+			}
+		}()
+	}
+	go s.maintain(ctx)
+}
+
+// Submit records a new job of jobType with params as its input and queues it.
+// init, if non-nil, can fill in fields such as Items before the job is saved.
+func (s *JobStore) Submit(ctx context.Context, jobType string, params any, init func(*Job)) (Job, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return Job{}, fmt.Errorf("encode params: %w", err)
+	}
+	now := time.Now()
+	job := &Job{
+		ID:          newJobID(),
+		Type:        jobType,
+		Tenant:      tenantFrom(ctx),
+		Status:      JobQueued,
+		MaxAttempts: s.attempts,
+		Params:      raw,
+		Created:     now.Unix(),
+		Updated:     now.Unix(),
+		Expires:     now.Add(jobRetention).Unix(),
+	}
+	if p := principalFrom(ctx); p != nil {
+		job.Client = p.ID
+	}
+	if init != nil {
+		init(job)
+	}
+
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+
+	if err := s.save(ctx, job.ID); err != nil {
+		s.mu.Lock()
+		delete(s.jobs, job.ID)
+		s.mu.Unlock()
+		return Job{}, err
+	}
+	s.enqueue(job.ID)
+	return s.snapshot(job.ID), nil
+}
+
+func (s *JobStore) enqueue(id string) {
+	select {
+	case s.queue <- id:
+	default:
+		// A full queue should delay the job, not its submitter.
+		go func() { s.queue <- id }()
+	}
+}
+
+// run executes one attempt of a job and either records the result or
+// schedules a retry with exponential backoff.
+func (s *JobStore) run(ctx context.Context, id string) {
+	var fn JobFunc
+	var job Job
+	s.Update(id, func(j *Job) {
+		fn = s.handlers[j.Type]
+		j.Status = JobRunning
+		j.Attempts++
+		j.NextAttempt = 0
+		job = *j
+	})
+	if job.ID == "" {
+		return
+	}
+	if fn == nil {
+		s.Fail(id, fmt.Errorf("no handler for job type %q", job.Type))
+		return
+	}
+	if err := s.save(ctx, id); err != nil {
+		slog.ErrorContext(ctx, "failed to save job", "id", id, "err", err)
+	}
+
+	output, contentType, err := fn(withTenant(waitForCapacity(ctx), job.Tenant), job)
+	if err == nil {
+		s.Succeed(id, output, contentType)
+		return
+	}
+
+	var wait waitError
+	if errors.As(err, &wait) {
+		slog.InfoContext(ctx, "job waiting", "type", job.Type, "job", id, "delay", wait.after, "reason", err)
+		s.Update(id, func(j *Job) {
+			j.Status = JobQueued
+			j.Attempts--
+			j.NextAttempt = time.Now().Add(wait.after).Unix()
+		})
+		if err := s.save(ctx, id); err != nil {
+			slog.ErrorContext(ctx, "failed to save job", "id", id, "err", err)
+		}
+		time.AfterFunc(wait.after, func() { s.enqueue(id) })
+		return
+	}
+	if isPermanent(err) || job.Attempts >= job.MaxAttempts {
+		slog.ErrorContext(ctx, "job failed", "type", job.Type, "job", id, "attempt", job.Attempts, "err", err)
+		s.Fail(id, err)
+		return
+	}
+
+	delay := min(jobRetryMax, jobRetryBase<<(job.Attempts-1))
+	slog.WarnContext(ctx, "job failed, retrying", "type", job.Type, "job", id, "attempt", job.Attempts, "delay", delay, "err", err)
+	s.Update(id, func(j *Job) {
+		j.Status = JobQueued
+		j.Error = err.Error()
+		j.NextAttempt = time.Now().Add(delay).Unix()
+	})
+	if err := s.save(ctx, id); err != nil {
+		slog.ErrorContext(ctx, "failed to save job", "id", id, "err", err)
+	}
+	time.AfterFunc(delay, func() { s.enqueue(id) })
+}
+
+func (s *JobStore) snapshot(id string) Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}
+	}
+	snap := *job
+	snap.Items = slices.Clone(job.Items)
+	return snap
+}
+
+// Get returns a snapshot of the job so callers never race with updates. Jobs
+// this instance does not hold are looked up in JOBS_TABLE. Another tenant's
+// job is not found.
+func (s *JobStore) Get(ctx context.Context, id string) (Job, error) {
+	job, err := s.get(ctx, id)
+	if err == nil && job.Tenant != tenantFrom(ctx) {
+		return Job{}, errJobNotFound
+	}
+	return job, err
+}
+
+func (s *JobStore) get(ctx context.Context, id string) (Job, error) {
+	if job := s.snapshot(id); job.ID != "" {
+		return job, nil
+	}
+	if s.db == nil {
+		return Job{}, errJobNotFound
+	}
+
+	out, err := s.db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	if out.Item == nil {
+		return Job{}, errJobNotFound
+	}
+	var job Job
+	if err := attributevalue.UnmarshalMap(out.Item, &job); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// jobCursor marks the last job of one status returned by List, which the
+// next page continues after.
+type jobCursor struct {
+	ID      string `json:"id"`
+	Created int64  `json:"created"`
+}
+
+var errInvalidJobToken = errors.New("invalid pagination token")
+
+// List returns up to limit of ctx's tenant's jobs newest first, optionally
+// filtered by type and status, and the token for the next page, which is empty after the last.
+// Each status is read in creation order on its own and the results merged,
+// so the token holds one cursor per status that has jobs left.
+func (s *JobStore) List(ctx context.Context, jobType, status string, limit int, token string) ([]Job, string, error) {
+	cursors := map[string]*jobCursor{}
+	if token != "" {
+		raw, err := base64.StdEncoding.DecodeString(token)
+		if err != nil || json.Unmarshal(raw, &cursors) != nil {
+			return nil, "", errInvalidJobToken
+		}
+	} else {
+		for _, st := range []string{JobQueued, JobRunning, JobSucceeded, JobFailed} {
+			cursors[st] = nil
+		}
+	}
+	if status != "" {
+		cursor, ok := cursors[status]
+		cursors = map[string]*jobCursor{}
+		if ok {
+			cursors[status] = cursor
+		}
+	}
+
+	var jobs []Job
+	read := map[string]int{}
+	more := map[string]bool{}
+	for st, after := range cursors {
+		var page []Job
+		var err error
+		if s.db == nil {
+			page, more[st] = s.listMemory(tenantFrom(ctx), jobType, st, after, limit)
+		} else if page, more[st], err = s.query(ctx, tenantFrom(ctx), jobType, st, after, limit); err != nil {
+			return nil, "", err
+		}
+		read[st] = len(page)
+		jobs = append(jobs, page...)
+	}
+
+	// A stable sort keeps each status in the order it was read, so the jobs
+	// taken from it are always a prefix of what was read.
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Created > jobs[j].Created })
+	jobs = jobs[:min(len(jobs), limit)]
+
+	taken := map[string]int{}
+	last := map[string]*jobCursor{}
+	for _, job := range jobs {
+		taken[job.Status]++
+		last[job.Status] = &jobCursor{ID: job.ID, Created: job.Created}
+	}
+	next := map[string]*jobCursor{}
+	for st, after := range cursors {
+		if taken[st] == read[st] && !more[st] {
+			continue
+		}
+		next[st] = after
+		if taken[st] > 0 {
+			next[st] = last[st]
+		}
+	}
+	if len(next) == 0 {
+		return jobs, "", nil
+	}
+	raw, err := json.Marshal(next)
+	if err != nil {
+		return nil, "", err
+	}
+	return jobs, base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// listMemory returns up to limit of this instance's jobs with status, newest
+// first, after the cursor, and whether there are more.
+func (s *JobStore) listMemory(tenant, jobType, status string, after *jobCursor, limit int) ([]Job, bool) {
+	var jobs []Job
+	s.mu.RLock()
+	for _, job := range s.jobs {
+		if job.Status == status && job.Tenant == tenant && (jobType == "" || job.Type == jobType) {
+			jobs = append(jobs, *job)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Created != jobs[j].Created {
+			return jobs[i].Created > jobs[j].Created
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	if after != nil {
+		jobs = jobs[sort.Search(len(jobs), func(i int) bool {
+			return jobs[i].Created < after.Created || (jobs[i].Created == after.Created && jobs[i].ID > after.ID)
+		}):]
+	}
+	if len(jobs) > limit {
+		return jobs[:limit], true
+	}
+	return jobs, false
+}
+
+// query reads up to limit jobs with status from the JOBS_STATUS_INDEX
+// index, newest first, after the cursor, and reports whether there are more.
+func (s *JobStore) query(ctx context.Context, tenant, jobType, status string, after *jobCursor, limit int) ([]Job, bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		IndexName:                 aws.String(s.statusIndex),
+		KeyConditionExpression:    aws.String("#status = :status"),
+		ExpressionAttributeNames:  map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":status": &types.AttributeValueMemberS{Value: status}},
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(int32(limit)),
+	}
+	var filters []string
+	if jobType != "" {
+		filters = append(filters, "#type = :type")
+		input.ExpressionAttributeNames["#type"] = "type"
+		input.ExpressionAttributeValues[":type"] = &types.AttributeValueMemberS{Value: jobType}
+	}
+	if tenant != "" {
+		filters = append(filters, "tenant = :tenant")
+		input.ExpressionAttributeValues[":tenant"] = &types.AttributeValueMemberS{Value: tenant}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+	if after != nil {
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"id":      &types.AttributeValueMemberS{Value: after.ID},
+			"status":  &types.AttributeValueMemberS{Value: status},
+			"created": &types.AttributeValueMemberN{Value: strconv.FormatInt(after.Created, 10)},
+		}
+	}
+
+	var jobs []Job
+	for {
+		out, err := s.db.Query(ctx, input)
+		if err != nil {
+			return nil, false, err
+		}
+		var page []Job
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, false, err
+		}
+		for i, job := range page {
+			jobs = append(jobs, job)
+			if len(jobs) == limit {
+				return jobs, i < len(page)-1 || out.LastEvaluatedKey != nil, nil
+			}
+		}
+		if out.LastEvaluatedKey == nil {
+			return jobs, false, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// Update changes the in-memory job. Progress is saved by the heartbeat;
+// state changes are saved by the methods that make them.
+func (s *JobStore) Update(id string, fn func(*Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.Updated = time.Now().Unix()
+	}
+}
+
+func (s *JobStore) SetProgress(id string, progress float64) {
+	s.Update(id, func(j *Job) {
+		j.Status = JobRunning
+		j.Progress = progress
+	})
+}
+
+func (s *JobStore) Fail(id string, err error) {
+	s.Update(id, func(j *Job) {
+		j.Status = JobFailed
+		j.Error = err.Error()
+		j.NextAttempt = 0
+	})
+	if err := s.save(context.Background(), id); err != nil {
+		slog.Error("failed to save job", "id", id, "err", err)
+	}
+}
+
+func (s *JobStore) Succeed(id, output, contentType string) {
+	s.Update(id, func(j *Job) {
+		j.Status = JobSucceeded
+		j.Progress = 1
+		j.Error = ""
+		j.Output = output
+		j.ContentType = contentType
+	})
+	if err := s.save(context.Background(), id); err != nil {
+		slog.Error("failed to save job", "id", id, "err", err)
+	}
+}
+
+// save writes the job to JOBS_TABLE. The conditional write drops the
+// snapshot if a newer version is already stored.
+func (s *JobStore) save(ctx context.Context, id string) error {
+	if s.db == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	job.Version++
+	snap := *job
+	snap.Items = slices.Clone(job.Items)
+	s.mu.Unlock()
+
+	item, err := attributevalue.MarshalMap(snap)
+	if err != nil {
+		return fmt.Errorf("marshal job: %w", err)
+	}
+	_, err = s.db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.table),
+		Item:                      item,
+		ConditionExpression:       aws.String("attribute_not_exists(id) OR version < :version"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":version": item["version"]},
+	})
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return nil
+	}
+	return err
+}
+
+// maintain saves active jobs every heartbeat so other instances can see they
+// are alive, adopts stale jobs from JOBS_TABLE, and forgets old finished jobs.
+func (s *JobStore) maintain(ctx context.Context) {
+	ticker := time.NewTicker(jobHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var active []string
+		cutoff := time.Now().Add(-jobRetention).Unix()
+		s.mu.Lock()
+		for id, job := range s.jobs {
+			switch {
+			case job.active():
+				job.Updated = time.Now().Unix()
+				active = append(active, id)
+			case job.Updated < cutoff:
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, id := range active {
+			if err := s.save(ctx, id); err != nil {
+				slog.ErrorContext(ctx, "failed to save job", "id", id, "err", err)
+			}
+		}
+		if s.db != nil {
+			s.adoptStale(ctx)
+		}
+	}
+}
+
+// adoptStale claims queued or running jobs that no instance has touched for
+// jobStaleAfter and queues them here. The claim is a conditional write on the
+// version read, so only one instance wins each job. Jobs are read from the
+// status index a page at a time, filtered to the stale ones.
+func (s *JobStore) adoptStale(ctx context.Context) {
+	now := time.Now()
+	cutoff := strconv.FormatInt(now.Add(-jobStaleAfter).Unix(), 10)
+	for _, status := range []string{JobQueued, JobRunning} {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(s.table),
+			IndexName:              aws.String(s.statusIndex),
+			KeyConditionExpression: aws.String("#status = :status"),
+			FilterExpression:       aws.String("#updated < :cutoff AND (attribute_not_exists(next_attempt) OR next_attempt < :cutoff)"),
+			ExpressionAttributeNames: map[string]string{
+				"#status":  "status",
+				"#updated": "updated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":status": &types.AttributeValueMemberS{Value: status},
+				":cutoff": &types.AttributeValueMemberN{Value: cutoff},
+			},
+			Limit: aws.Int32(jobAdoptPage),
+		}
+		for {
+			out, err := s.db.Query(ctx, input)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to query jobs", "status", status, "err", err)
+				break
+			}
+			var page []Job
+			if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+				slog.ErrorContext(ctx, "failed to decode jobs", "status", status, "err", err)
+				break
+			}
+			for _, job := range page {
+				s.adopt(ctx, job, now)
+			}
+			if out.LastEvaluatedKey == nil {
+				break
+			}
+			input.ExclusiveStartKey = out.LastEvaluatedKey
+		}
+	}
+}
+
+// adopt claims one stale job read from JOBS_TABLE.
+func (s *JobStore) adopt(ctx context.Context, job Job, now time.Time) {
+	if _, ok := s.handlers[job.Type]; !ok {
+		return
+	}
+	s.mu.RLock()
+	_, held := s.jobs[job.ID]
+	s.mu.RUnlock()
+	if held {
+		return
+	}
+
+	seen := job.Version
+	job.Version++
+	job.Status = JobQueued
+	job.Updated = now.Unix()
+	item, err := attributevalue.MarshalMap(job)
+	if err != nil {
+		return
+	}
+	_, err = s.db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.table),
+		Item:                item,
+		ConditionExpression: aws.String("version = :seen"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":seen": &types.AttributeValueMemberN{Value: strconv.FormatInt(seen, 10)},
+		},
+	})
+	if err != nil {
+		var conflict *types.ConditionalCheckFailedException
+		if !errors.As(err, &conflict) {
+			slog.ErrorContext(ctx, "failed to claim job", "id", job.ID, "err", err)
+		}
+		return
+	}
+
+	slog.InfoContext(ctx, "adopted stale job", "type", job.Type, "id", job.ID)
+	s.mu.Lock()
+	s.jobs[job.ID] = &job
+	s.mu.Unlock()
+	s.enqueue(job.ID)
+}
+
+// JobAccepted is the 202 body of a request that started a job.
+type JobAccepted struct {
+	JobID     string `json:"job_id"`
+	Status    string `json:"status"`
+	StatusURL string `json:"status_url"`
+}
+
+func newJobAccepted(job Job) JobAccepted {
+	return JobAccepted{JobID: job.ID, Status: job.Status, StatusURL: jobStatusURL(job.ID)}
+}
+
+type JobListResponse struct {
+	Jobs      []Job   `json:"jobs"`
+	NextToken *string `json:"nextToken,omitempty"`
+}
+
+// getJobs lists jobs, newest first. ?type= and ?status= filter the list and
+// ?limit= sets the page size (default 50, at most 500); ?nextToken= fetches
+// the page after one that returned it. Params and per-image items are left
+// out; fetch a single job for those.
+func (api *API) getJobs(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", JobQueued, JobRunning, JobSucceeded, JobFailed:
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'status' parameter. Must be queued, running, succeeded, or failed.")
+		return
+	}
+
+	limit := defaultJobList
+	if s := c.Query("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v <= 0 || v > maxJobList {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'limit' parameter. Must be an integer between 1 and 500.")
+			return
+		}
+		limit = v
+	}
+
+	jobs, token, err := api.Jobs.List(c.Request.Context(), c.Query("type"), status, limit, c.Query("nextToken"))
+	if err != nil {
+		if errors.Is(err, errInvalidJobToken) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid pagination token")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to list jobs", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve jobs")
+		return
+	}
+	for i := range jobs {
+		jobs[i].Params = nil
+		jobs[i].Items = nil
+	}
+	if jobs == nil {
+		jobs = []Job{}
+	}
+	response := JobListResponse{Jobs: jobs}
+	if token != "" {
+		response.NextToken = aws.String(token)
+	}
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+func (api *API) getJob(c *gin.Context) {
+	job, err := api.Jobs.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, errJobNotFound) {
+			respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to load job", "id", c.Param("id"), "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve job")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, job)
+}
+
+func (api *API) getJobOutput(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	job, err := api.Jobs.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, errJobNotFound) {
+			respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to load job", "id", c.Param("id"), "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve job")
+		return
+	}
+	if job.Status != JobSucceeded || job.Output == "" {
+		respondProblem(c, newProblem(http.StatusConflict, CodeJobNotFinished, "job has no output yet").with("job_status", job.Status))
+		return
+	}
+
+	out, err := api.S3.GetObject(c.Request.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(job.Output),
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "s3 GetObject failed", "key", job.Output, "err", err)
+		respondError(c, http.StatusNotFound, CodeNotFound, "object not found")
+		return
+	}
+	defer out.Body.Close()
+
+	if out.ContentType != nil {
+		c.Header("Content-Type", aws.ToString(out.ContentType))
+	}
+	if out.ContentLength != nil {
+		c.Header("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, out.Body); err != nil {
+		slog.ErrorContext(c.Request.Context(), "streaming failed", "key", job.Output, "err", err)
+	}
+}
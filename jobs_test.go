@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestJobStoreListPages(t *testing.T) {
+	s := newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers)
+	statuses := []string{JobQueued, JobRunning, JobSucceeded, JobFailed, JobSucceeded}
+	var want []string
+	for i := range 23 {
+		// Pairs of jobs share a creation time, so ties must page cleanly.
+		job := &Job{ID: fmt.Sprintf("job%02d", i), Type: "stack", Status: statuses[i%len(statuses)], Created: int64(100 - i/2)}
+		if i%4 == 3 {
+			job.Type = "timelapse"
+		}
+		s.jobs[job.ID] = job
+	}
+	for _, job := range s.jobs {
+		want = append(want, job.ID)
+	}
+
+	tests := []struct {
+		name, jobType, status string
+		keep                  func(*Job) bool
+	}{
+		{name: "all", keep: func(*Job) bool { return true }},
+		{name: "by status", status: JobSucceeded, keep: func(j *Job) bool { return j.Status == JobSucceeded }},
+		{name: "by type", jobType: "timelapse", keep: func(j *Job) bool { return j.Type == "timelapse" }},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 3, 50} {
+			t.Run(fmt.Sprintf("%s/limit %d", tt.name, limit), func(t *testing.T) {
+				var got []string
+				var last int64 = 1 << 62
+				token := ""
+				for pages := 0; ; pages++ {
+					if pages > len(s.jobs) {
+						t.Fatal("paging did not end")
+					}
+					jobs, next, err := s.List(context.Background(), tt.jobType, tt.status, limit, token)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(jobs) > limit {
+						t.Fatalf("page of %d jobs, limit %d", len(jobs), limit)
+					}
+					for _, job := range jobs {
+						if job.Created > last {
+							t.Errorf("%s created %d after a job created %d", job.ID, job.Created, last)
+						}
+						last = job.Created
+						got = append(got, job.ID)
+					}
+					if next == "" {
+						break
+					}
+					token = next
+				}
+
+				var expect []string
+				for _, id := range want {
+					if tt.keep(s.jobs[id]) {
+						expect = append(expect, id)
+					}
+				}
+				slices.Sort(got)
+				slices.Sort(expect)
+				if !slices.Equal(got, expect) {
+					t.Errorf("listed %v, want %v", got, expect)
+				}
+			})
+		}
+	}
+}
+
+func TestJobStoreListBadToken(t *testing.T) {
+	s := newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers)
+	for _, token := range []string{"not base64!", "bm90IGpzb24="} {
+		if _, _, err := s.List(context.Background(), "", "", 10, token); !errors.Is(err, errInvalidJobToken) {
+			t.Errorf("List(token %q) error = %v, want errInvalidJobToken", token, err)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// layoutCacheTTL is how long an instance trusts where it last saw an
+	// original. A read that finds nothing there looks again.
+	layoutCacheTTL = time.Minute
+	// layoutUnknown stands in for a key template value an image lacks.
+	layoutUnknown = "none"
+)
+
+var (
+	layoutPlaceholder = regexp.MustCompile(`\{([a-z]*)\}`)
+	// layoutPlaceholders are the values IMAGE_KEY_TEMPLATE may use.
+	layoutPlaceholders = []string{"id", "ext", "mission", "sensor", "date", "year", "month", "day"}
+	// layoutReserved are the prefixes the server keeps other objects
+	// under, where no original may be placed.
+	layoutReserved = append([]string{"images/", "tenants/", "blobs/", "versions/", "derived/", "annotations/"}, derivedPrefixes...)
+)
+
+// LayoutConfig places ingested originals somewhere other than
+// images/<id>.jpg in the images bucket.
+type LayoutConfig struct {
+	// KeyTemplate is the key of an original, such as
+	// originals/{mission}/{date}/{id}.jpg; empty keeps images/<id>.jpg.
+	KeyTemplate string
+	// Routes send the originals of some missions or tenants to other
+	// buckets. The first that matches wins.
+	Routes []BucketRoute
+}
+
+// BucketRoute sends the originals of the missions matching Mission, a
+// path.Match pattern, or of Tenant, to Bucket in Region. An empty Region is
+// the server's own.
+type BucketRoute struct {
+	Mission, Tenant string
+	Bucket, Region  string
+}
+
+func (c LayoutConfig) enabled() bool { return c.KeyTemplate != "" || len(c.Routes) > 0 }
+
+// route returns the route for an original of mission and tenant, or nil
+// for the images bucket.
+func (c LayoutConfig) route(tenant, missionID string) *BucketRoute {
+	for i, r := range c.Routes {
+		if r.Tenant != "" && r.Tenant == tenant {
+			return &c.Routes[i]
+		}
+		if ok, _ := path.Match(r.Mission, missionID); r.Mission != "" && missionID != "" && ok {
+			return &c.Routes[i]
+		}
+	}
+	return nil
+}
+
+// loadLayoutConfig reads IMAGE_KEY_TEMPLATE and BUCKET_ROUTES, a list of
+// mission:<pattern>=<bucket>[@<region>] and tenant:<name>=<bucket>[@<region>].
+func loadLayoutConfig() (LayoutConfig, []error) {
+	c := LayoutConfig{KeyTemplate: strings.TrimSpace(os.Getenv("IMAGE_KEY_TEMPLATE"))}
+	var errs []error
+	if c.KeyTemplate != "" {
+		if !strings.Contains(c.KeyTemplate, "{id}") {
+			errs = append(errs, fmt.Errorf("IMAGE_KEY_TEMPLATE %q does not contain {id}", c.KeyTemplate))
+		}
+		for _, m := range layoutPlaceholder.FindAllStringSubmatch(c.KeyTemplate, -1) {
+			if !slices.Contains(layoutPlaceholders, m[1]) {
+				errs = append(errs, fmt.Errorf("IMAGE_KEY_TEMPLATE placeholder %s is not one of {%s}", m[0], strings.Join(layoutPlaceholders, "}, {")))
+			}
+		}
+		if strings.HasPrefix(c.KeyTemplate, "/") || hasAnyPrefix(c.KeyTemplate, layoutReserved) {
+			errs = append(errs, fmt.Errorf("IMAGE_KEY_TEMPLATE %q may not start with / or any of %s", c.KeyTemplate, strings.Join(layoutReserved, ", ")))
+		}
+	}
+	for _, item := range splitList(os.Getenv("BUCKET_ROUTES")) {
+		match, target, _ := strings.Cut(item, "=")
+		kind, name, _ := strings.Cut(strings.TrimSpace(match), ":")
+		bucket, region, _ := strings.Cut(strings.TrimSpace(target), "@")
+		r := BucketRoute{Bucket: bucket, Region: region}
+		switch kind {
+		case "mission":
+			r.Mission = name
+			if _, err := path.Match(name, ""); err != nil {
+				errs = append(errs, fmt.Errorf("BUCKET_ROUTES entry %q: %q is not a pattern", item, name))
+				continue
+			}
+		case "tenant":
+			r.Tenant = name
+			if !tenantName.MatchString(name) {
+				errs = append(errs, fmt.Errorf("BUCKET_ROUTES entry %q: %q is not a tenant name", item, name))
+				continue
+			}
+		default:
+			errs = append(errs, fmt.Errorf("BUCKET_ROUTES entry %q is not mission:<pattern>=<bucket>[@<region>] or tenant:<name>=<bucket>[@<region>]", item))
+			continue
+		}
+		if name == "" || bucket == "" {
+			errs = append(errs, fmt.Errorf("BUCKET_ROUTES entry %q names no %s or bucket", item, kind))
+			continue
+		}
+		c.Routes = append(c.Routes, r)
+	}
+	return c, errs
+}
+
+// ObjectLocation is where an original is when it is not images/<id>.jpg
+// in the images bucket, recorded as the location attribute of its image
+// record. Key is within its tenant, as every key
+// handlers use.
+type ObjectLocation struct {
+	Bucket string `dynamodbav:"bucket" json:"bucket"`
+	Region string `dynamodbav:"region,omitempty" json:"region,omitempty"`
+	Key    string `dynamodbav:"key" json:"key"`
+	// ContentType is the original's, by the extension it was uploaded
+	// with, for uploads stored without one.
+	ContentType string `dynamodbav:"content_type,omitempty" json:"content_type,omitempty"`
+}
+
+// isUpload reports whether l is an upload that stayed where it was
+// uploaded, under another extension than .jpg.
+func (l *ObjectLocation) isUpload(bucket string) bool {
+	_, ok := imageIDFromKey(l.Key)
+	return l.Bucket == bucket && ok
+}
+
+// options are the per-request options for the location: S3 Transfer
+// Acceleration if its bucket uses it, and its region.
+func (l *ObjectLocation) options() []func(*s3.Options) {
+	optFns := s3Accelerate(l.Bucket)
+	if l.Region != "" {
+		region := l.Region
+		optFns = append(optFns, func(o *s3.Options) { o.Region = region })
+	}
+	return optFns
+}
+
+// layoutStore reads and deletes each original where it is, resolved from
+// the image record, while handlers keep naming it images/<id>.jpg in the
+// images bucket: where it was placed, or where it was uploaded under
+// another extension. Without a record, the other extensions are probed.
+// Everything else, uploads included, goes to the store it wraps.
+type layoutStore struct {
+	ObjectStore
+	images ImageStore
+	bucket string
+	cfg    LayoutConfig
+	// locations caches each original's location by tenantRecordID, "null"
+	// for one in the images bucket.
+	locations *memoryKV
+}
+
+func routeObjects(store ObjectStore, images ImageStore, bucket string, cfg LayoutConfig) *layoutStore {
+	return &layoutStore{ObjectStore: store, images: images, bucket: bucket, cfg: cfg, locations: newMemoryKV()}
+}
+
+// location returns where the original named by bucket and key is, or nil
+// for where it is named, and whether the answer came from the cache.
+// fresh skips the cache.
+func (s *layoutStore) location(ctx context.Context, bucket, key string, fresh bool) (*ObjectLocation, bool) {
+	if bucket != s.bucket {
+		return nil, false
+	}
+	id, ok := imageIDFromKey(key)
+	if !ok {
+		return nil, false
+	}
+	var loc *ObjectLocation
+	if data, ok := s.locations.Get(ctx, tenantRecordID(ctx, id)); ok && !fresh && json.Unmarshal(data, &loc) == nil {
+		return loc, true
+	}
+	record, err := s.images.ImageRecord(ctx, id)
+	if err != nil {
+		return nil, false
+	}
+	if record != nil {
+		loc = record.Location
+	}
+	s.remember(ctx, id, loc)
+	return loc, false
+}
+
+func (s *layoutStore) remember(ctx context.Context, id string, loc *ObjectLocation) {
+	data, _ := json.Marshal(loc)
+	s.locations.Set(ctx, tenantRecordID(ctx, id), data, layoutCacheTTL)
+}
+
+// route is where a request for bucket and key goes: loc, or where it was
+// named. The caller's options are for the images bucket, so loc's replace
+// them.
+func (s *layoutStore) route(loc *ObjectLocation, bucket, key *string, optFns []func(*s3.Options)) (*string, *string, []func(*s3.Options)) {
+	if loc == nil {
+		return bucket, key, optFns
+	}
+	return aws.String(loc.Bucket), aws.String(loc.Key), loc.options()
+}
+
+// routed runs call against where the original named by bucket and key is.
+// One not found where the cache put it is looked for again past the cache,
+// since another instance may have moved it, and one not found where it is
+// named is probed for under the other extensions.
+func routed[T any](ctx context.Context, s *layoutStore, bucket, key *string, call func(loc *ObjectLocation) (T, error)) (T, error) {
+	loc, cached := s.location(ctx, aws.ToString(bucket), aws.ToString(key), false)
+	out, err := call(loc)
+	if cached && isNotFound(err) {
+		if fresh, _ := s.location(ctx, aws.ToString(bucket), aws.ToString(key), true); fresh != nil && (loc == nil || *fresh != *loc) {
+			loc = fresh
+			out, err = call(loc)
+		}
+	}
+	if loc == nil && isNotFound(err) {
+		if found := s.probe(ctx, aws.ToString(bucket), aws.ToString(key)); found != nil {
+			return call(found)
+		}
+	}
+	return out, err
+}
+
+func (s *layoutStore) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return routed(ctx, s, in.Bucket, in.Key, func(loc *ObjectLocation) (*s3.GetObjectOutput, error) {
+		routed := *in
+		var opts []func(*s3.Options)
+		routed.Bucket, routed.Key, opts = s.route(loc, in.Bucket, in.Key, optFns)
+		out, err := s.ObjectStore.GetObject(ctx, &routed, opts...)
+		if err == nil && loc != nil && loc.ContentType != "" {
+			out.ContentType = aws.String(loc.ContentType)
+		}
+		return out, err
+	})
+}
+
+func (s *layoutStore) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return routed(ctx, s, in.Bucket, in.Key, func(loc *ObjectLocation) (*s3.HeadObjectOutput, error) {
+		routed := *in
+		var opts []func(*s3.Options)
+		routed.Bucket, routed.Key, opts = s.route(loc, in.Bucket, in.Key, optFns)
+		out, err := s.ObjectStore.HeadObject(ctx, &routed, opts...)
+		if err == nil && loc != nil && loc.ContentType != "" {
+			out.ContentType = aws.String(loc.ContentType)
+		}
+		return out, err
+	})
+}
+
+func (s *layoutStore) RestoreObject(ctx context.Context, in *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error) {
+	return routed(ctx, s, in.Bucket, in.Key, func(loc *ObjectLocation) (*s3.RestoreObjectOutput, error) {
+		routed := *in
+		var opts []func(*s3.Options)
+		routed.Bucket, routed.Key, opts = s.route(loc, in.Bucket, in.Key, optFns)
+		return s.ObjectStore.RestoreObject(ctx, &routed, opts...)
+	})
+}
+
+// CopyObject copies an original from where it was placed. The copy is
+// made where it is named.
+func (s *layoutStore) CopyObject(ctx context.Context, in *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(aws.ToString(in.CopySource), "/"), "/")
+	if loc, _ := s.location(ctx, bucket, key, false); loc != nil {
+		routed := *in
+		routed.CopySource = aws.String(loc.Bucket + "/" + loc.Key)
+		in = &routed
+	}
+	return s.ObjectStore.CopyObject(ctx, in, optFns...)
+}
+
+// DeleteObjects deletes the originals among the keys where they were
+// placed too, reporting a failure there under the key as named.
+func (s *layoutStore) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	out, err := s.ObjectStore.DeleteObjects(ctx, in, optFns...)
+	if err != nil || in.Delete == nil {
+		return out, err
+	}
+	for _, obj := range in.Delete.Objects {
+		loc, _ := s.location(ctx, aws.ToString(in.Bucket), aws.ToString(obj.Key), true)
+		if loc == nil {
+			continue
+		}
+		if err := s.deletePlaced(ctx, loc); err != nil {
+			out.Errors = append(out.Errors, s3types.Error{Key: obj.Key, Code: aws.String("PlacedObject"), Message: aws.String(err.Error())})
+		}
+	}
+	return out, nil
+}
+
+// placed reports whether there is an original at loc.
+func (s *layoutStore) placed(ctx context.Context, loc *ObjectLocation) bool {
+	_, err := s.ObjectStore.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(loc.Bucket), Key: aws.String(loc.Key)}, loc.options()...)
+	return err == nil
+}
+
+// deletePlaced deletes the original at loc.
+func (s *layoutStore) deletePlaced(ctx context.Context, loc *ObjectLocation) error {
+	out, err := s.ObjectStore.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(loc.Bucket),
+		Delete: &s3types.Delete{Objects: []s3types.ObjectIdentifier{{Key: aws.String(loc.Key)}}, Quiet: aws.Bool(true)},
+	}, loc.options()...)
+	if err == nil && len(out.Errors) > 0 {
+		err = fmt.Errorf("%s: %s", aws.ToString(out.Errors[0].Code), aws.ToString(out.Errors[0].Message))
+	}
+	return err
+}
+
+// placement is where the key template and routes put the original of
+// image id uploaded to source, or nil for where it was uploaded.
+func (s *layoutStore) placement(ctx context.Context, id, source, missionID, sensor string, captured time.Time) *ObjectLocation {
+	loc := &ObjectLocation{Bucket: s.bucket, Key: source}
+	if r := s.cfg.route(tenantFrom(ctx), missionID); r != nil {
+		loc.Bucket, loc.Region = r.Bucket, r.Region
+	}
+	if s.cfg.KeyTemplate != "" {
+		values := map[string]string{
+			"id":      id,
+			"ext":     strings.TrimPrefix(path.Ext(source), "."),
+			"mission": strings.ReplaceAll(missionID, "/", "-"),
+			"sensor":  strings.ReplaceAll(sensor, "/", "-"),
+			"date":    captured.UTC().Format("2006/01/02"),
+			"year":    captured.UTC().Format("2006"),
+			"month":   captured.UTC().Format("01"),
+			"day":     captured.UTC().Format("02"),
+		}
+		loc.Key = layoutPlaceholder.ReplaceAllStringFunc(s.cfg.KeyTemplate, func(m string) string {
+			if v := values[m[1:len(m)-1]]; v != "" {
+				return v
+			}
+			return layoutUnknown
+		})
+	}
+	if loc.Bucket == s.bucket && loc.Key == source {
+		return nil
+	}
+	return loc
+}
+
+// reclaimUpload readies the upload of image id to key to be ingested, or
+// with an empty key the first found, when the record says the original is
+// somewhere else: where an earlier upload was placed, or under another
+// extension. The record is pointed at the upload, so reads see it, and the
+// earlier original is deleted, as an upload under the same key overwrites
+// it. A redelivered upload that was already placed is left where it is.
+func (api *API) reclaimUpload(ctx context.Context, id, key string) error {
+	if api.Layout == nil {
+		return nil
+	}
+	bucketName := api.Config.ImagesBucket
+	raw := api.Layout.ObjectStore
+	if key == "" {
+		if key = api.Layout.findUpload(ctx, id); key == "" {
+			return nil
+		}
+	} else {
+		_, err := raw.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	loc, _ := api.Layout.location(ctx, bucketName, imageKey(id), true)
+	upload := api.Layout.uploadLocation(id, key)
+	if loc == nil && upload == nil || loc != nil && upload != nil && *loc == *upload {
+		return nil
+	}
+	if err := api.Images.SetImageAttribute(ctx, id, "location", upload); err != nil && !errors.Is(err, errImageTableUnset) {
+		return err
+	}
+	api.Layout.remember(ctx, id, upload)
+	replaced := loc
+	if replaced == nil {
+		replaced = &ObjectLocation{Bucket: bucketName, Key: imageKey(id)}
+	}
+	if err := api.Layout.deletePlaced(ctx, replaced); err != nil {
+		slog.ErrorContext(ctx, "failed to delete replaced original", "id", id, "bucket", replaced.Bucket, "key", replaced.Key, "err", err)
+	}
+	return nil
+}
+
+// placeOriginal moves an ingested original to where the key template and
+// routes put it, and records the location. One that cannot be moved stays
+// where it was uploaded, and one already placed where it is.
+func (api *API) placeOriginal(ctx context.Context, id, missionID string, metadata map[string]string, captured time.Time) {
+	if api.Layout == nil || !api.Config.Layout.enabled() {
+		return
+	}
+	bucketName := api.Config.ImagesBucket
+	source := &ObjectLocation{Bucket: bucketName, Key: imageKey(id)}
+	if loc, _ := api.Layout.location(ctx, bucketName, imageKey(id), true); loc != nil {
+		if !loc.isUpload(bucketName) {
+			return
+		}
+		source = loc
+	}
+	if captured.IsZero() {
+		captured = time.Now()
+	}
+	var sensor string
+	if strings.Contains(api.Config.Layout.KeyTemplate, "{sensor}") {
+		sensor = api.imageSensor(ctx, missionID, metadata)
+	}
+	loc := api.Layout.placement(ctx, id, source.Key, missionID, sensor, captured)
+	if loc == nil {
+		return
+	}
+	loc.ContentType = source.ContentType
+	raw := api.Layout.ObjectStore
+	_, err := raw.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(loc.Bucket),
+		Key:        aws.String(loc.Key),
+		CopySource: aws.String(bucketName + "/" + source.Key),
+	}, loc.options()...)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to place original", "id", id, "bucket", loc.Bucket, "key", loc.Key, "err", err)
+		return
+	}
+	if err := api.Images.SetImageAttribute(ctx, id, "location", loc); err != nil {
+		slog.ErrorContext(ctx, "failed to record original location", "id", id, "err", err)
+		return
+	}
+	api.Layout.remember(ctx, id, loc)
+	if err := deleteKeys(ctx, raw, bucketName, []string{source.Key}); err != nil {
+		slog.ErrorContext(ctx, "failed to delete placed upload", "id", id, "err", err)
+	}
+}
+
+// imageSensor names the sensor that took an image: the upload's
+// x-amz-meta-sensor, else the name of the mission observer's sensor, else
+// the observer's ID.
+func (api *API) imageSensor(ctx context.Context, missionID string, metadata map[string]string) string {
+	if v := metadata["sensor"]; v != "" {
+		return v
+	}
+	if missionID == "" {
+		return ""
+	}
+	mission, err := api.loadMission(ctx, missionID)
+	if err != nil || mission.ObserverSatelliteID == "" {
+		return ""
+	}
+	if api.Sensors != nil {
+		if sensor, err := api.Sensors.Get(mission.ObserverSatelliteID); err == nil && sensor.Name != "" {
+			return sensor.Name
+		}
+	}
+	return mission.ObserverSatelliteID
+}
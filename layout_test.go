@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadLayoutConfig(t *testing.T) {
+	t.Setenv("IMAGE_KEY_TEMPLATE", "originals/{mission}/{date}/{id}.jpg")
+	t.Setenv("BUCKET_ROUTES", "mission:leo-*=sat-leo@us-west-2, tenant:acme=acme-images")
+	cfg, errs := loadLayoutConfig()
+	want := []BucketRoute{{Mission: "leo-*", Bucket: "sat-leo", Region: "us-west-2"}, {Tenant: "acme", Bucket: "acme-images"}}
+	if len(errs) > 0 || cfg.KeyTemplate != "originals/{mission}/{date}/{id}.jpg" || !reflect.DeepEqual(cfg.Routes, want) {
+		t.Errorf("%+v %v", cfg, errs)
+	}
+	if r := cfg.route("acme", "geo-1"); r == nil || r.Bucket != "acme-images" {
+		t.Errorf("route for acme = %+v", r)
+	}
+	if r := cfg.route("", "geo-1"); r != nil {
+		t.Errorf("route for geo-1 = %+v", r)
+	}
+
+	t.Setenv("IMAGE_KEY_TEMPLATE", "images/{orbit}.jpg")
+	t.Setenv("BUCKET_ROUTES", "satellite:a=b,mission:=b,tenant:Acme=b")
+	if _, errs := loadLayoutConfig(); len(errs) != 6 {
+		t.Errorf("errors %v", errs)
+	}
+}
+
+func TestLayoutIngest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	raw, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	if err := putMission(ctx, meta, &Mission{ID: "leo"}); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reprocessed, err := seedImage(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := LayoutConfig{
+		KeyTemplate: "originals/{mission}/{date}/{sensor}/{id}.jpg",
+		Routes:      []BucketRoute{{Mission: "le?", Bucket: "sat-leo"}},
+	}
+	layout := routeObjects(raw, meta, "sat", cfg)
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep, Layout: cfg},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        layout,
+		Layout:    layout,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+	ingest := func(data []byte) {
+		t.Helper()
+		_, err := raw.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:   aws.String("sat"),
+			Key:      aws.String(imageKey("leo-01")),
+			Body:     bytes.NewReader(data),
+			Metadata: map[string]string{"capture-time": "2026-10-15T04:05:06Z", "sensor": "nav/cam"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ingestImage(ctx, "leo-01"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := gin.New()
+	router.GET("/image/:id", api.getSatImageByID)
+	serves := func(want []byte) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/image/leo-01", nil))
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) {
+			t.Fatalf("GET /image/leo-01: %d, %d bytes", w.Code, w.Body.Len())
+		}
+	}
+
+	ingest(frame)
+	record, err := meta.ImageRecord(ctx, "leo-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ObjectLocation{Bucket: "sat-leo", Key: "originals/leo/2026/10/15/nav-cam/leo-01.jpg"}
+	if record == nil || record.Location == nil || *record.Location != want {
+		t.Fatalf("location %+v, want %+v", record.Location, want)
+	}
+	if _, err := raw.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey("leo-01"))}); !isNotFound(err) {
+		t.Errorf("the upload was not moved: %v", err)
+	}
+	serves(frame)
+
+	// Uploading again replaces the moved original.
+	ingest(reprocessed)
+	serves(reprocessed)
+	out, err := raw.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("sat-leo"), Key: aws.String(want.Key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Body.Close()
+	var placed bytes.Buffer
+	if _, err := placed.ReadFrom(out.Body); err != nil || !bytes.Equal(placed.Bytes(), reprocessed) {
+		t.Errorf("placed original is not the new upload: %v", err)
+	}
+}
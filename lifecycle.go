@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	lifecycleInterval         = 6 * time.Hour
+	defaultLifecycleRestore   = 7
+	defaultLifecycleArchive   = s3types.StorageClassGlacier
+	defaultLifecycleRestoreBy = s3types.TierStandard
+	// A restore job checks on its object every restorePoll, and a request
+	// for a cold image is told to come back after restoreRetryAfter.
+	restorePoll       = time.Minute
+	restoreRetryAfter = 60
+	// uploadedMetadata is the unix time an original was uploaded, kept in
+	// its metadata when it is copied to another storage class, which
+	// resets LastModified.
+	uploadedMetadata = "uploaded"
+)
+
+// derivedPrefixes are where pre-generated derivatives live, which
+// LIFECYCLE_DERIVED_DAYS expires. The processed-image cache under derived/
+// has DERIVED_CACHE_TTL instead.
+var derivedPrefixes = []string{"thumbnails/", "pyramid/", "tiles/"}
+
+// LifecycleConfig moves originals to cheaper storage classes as they age
+// and deletes old derivatives. Ages are in days since upload; 0 turns a
+// rule off.
+type LifecycleConfig struct {
+	// IADays moves originals to STANDARD_IA, and ArchiveDays to
+	// ArchiveClass: GLACIER, DEEP_ARCHIVE or GLACIER_IR.
+	IADays, ArchiveDays int
+	ArchiveClass        s3types.StorageClass
+	// DerivedDays deletes thumbnails, pyramids and tiles, which are
+	// generated again when next requested.
+	DerivedDays int
+	// An archived original requested is restored with RestoreTier for
+	// RestoreDays.
+	RestoreDays int
+	RestoreTier s3types.Tier
+}
+
+func (c LifecycleConfig) transitions() bool { return c.IADays > 0 || c.ArchiveDays > 0 }
+
+// loadLifecycleConfig reads LIFECYCLE_IA_DAYS, LIFECYCLE_ARCHIVE_DAYS,
+// LIFECYCLE_ARCHIVE_CLASS, LIFECYCLE_DERIVED_DAYS, LIFECYCLE_RESTORE_DAYS and
+// LIFECYCLE_RESTORE_TIER.
+func loadLifecycleConfig() (LifecycleConfig, []error) {
+	c := LifecycleConfig{ArchiveClass: defaultLifecycleArchive, RestoreDays: defaultLifecycleRestore, RestoreTier: defaultLifecycleRestoreBy}
+	var errs []error
+	for _, d := range []struct {
+		name string
+		dst  *int
+		min  int
+	}{
+		{"LIFECYCLE_IA_DAYS", &c.IADays, 0},
+		{"LIFECYCLE_ARCHIVE_DAYS", &c.ArchiveDays, 0},
+		{"LIFECYCLE_DERIVED_DAYS", &c.DerivedDays, 0},
+		{"LIFECYCLE_RESTORE_DAYS", &c.RestoreDays, 1},
+	} {
+		if v := os.Getenv(d.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < d.min {
+				errs = append(errs, fmt.Errorf("%s %q is not a number of days from %d", d.name, v, d.min))
+			}
+			*d.dst = n
+		}
+	}
+	if c.IADays > 0 && c.ArchiveDays > 0 && c.ArchiveDays <= c.IADays {
+		errs = append(errs, fmt.Errorf("LIFECYCLE_ARCHIVE_DAYS %d is not after LIFECYCLE_IA_DAYS %d", c.ArchiveDays, c.IADays))
+	}
+	if v := os.Getenv("LIFECYCLE_ARCHIVE_CLASS"); v != "" {
+		c.ArchiveClass = s3types.StorageClass(strings.ToUpper(v))
+		switch c.ArchiveClass {
+		case s3types.StorageClassGlacier, s3types.StorageClassDeepArchive, s3types.StorageClassGlacierIr:
+		default:
+			errs = append(errs, fmt.Errorf("LIFECYCLE_ARCHIVE_CLASS %q is not GLACIER, DEEP_ARCHIVE or GLACIER_IR", v))
+		}
+	}
+	if v := os.Getenv("LIFECYCLE_RESTORE_TIER"); v != "" {
+		c.RestoreTier = s3types.Tier(strings.ToUpper(v[:1]) + strings.ToLower(v[1:]))
+		switch c.RestoreTier {
+		case s3types.TierStandard, s3types.TierBulk, s3types.TierExpedited:
+		default:
+			errs = append(errs, fmt.Errorf("LIFECYCLE_RESTORE_TIER %q is not Standard, Bulk or Expedited", v))
+		}
+	}
+	return c, errs
+}
+
+// isArchiveClass reports whether objects of class must be restored before
+// they can be read.
+func isArchiveClass(class s3types.StorageClass) bool {
+	return class == s3types.StorageClassGlacier || class == s3types.StorageClassDeepArchive
+}
+
+// isColdObject reports whether err is S3 refusing to read an archived
+// object that has not been restored.
+func isColdObject(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState"
+}
+
+// lifecycleWorker applies LifecycleConfig to the images bucket and restores
+// archived originals when they are requested.
+type lifecycleWorker struct {
+	api *API
+	cfg LifecycleConfig
+
+	mu sync.Mutex
+	// restores are the restore jobs started here, by bucket key.
+	restores map[string]string
+}
+
+func newLifecycleWorker(api *API, cfg LifecycleConfig) *lifecycleWorker {
+	return &lifecycleWorker{api: api, cfg: cfg, restores: map[string]string{}}
+}
+
+// Start sweeps the bucket every lifecycleInterval until ctx is done, when
+// any rule is on.
+func (w *lifecycleWorker) Start(ctx context.Context) {
+	if !w.cfg.transitions() && w.cfg.DerivedDays == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(lifecycleInterval)
+		defer ticker.Stop()
+		for {
+			if err := w.sweep(ctx, time.Now()); err != nil {
+				slog.ErrorContext(ctx, "lifecycle sweep failed", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// walk calls fn with every object of every tenant whose key within its
+// tenant starts with one of prefixes.
+func (w *lifecycleWorker) walk(ctx context.Context, bucketName string, prefixes []string, fn func(obj s3types.Object, rest string) error) error {
+	for _, root := range append(prefixes, tenantObjectRoot) {
+		pages := s3.NewListObjectsV2Paginator(w.api.S3, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucketName),
+			Prefix: aws.String(root),
+		})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("list %s: %w", root, err)
+			}
+			for _, obj := range page.Contents {
+				rest := aws.ToString(obj.Key)
+				if root == tenantObjectRoot {
+					var ok bool
+					if _, rest, ok = splitObjectKey(rest); !ok || !hasAnyPrefix(rest, prefixes) {
+						continue
+					}
+				}
+				if err := fn(obj, rest); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep moves the originals due for another storage class and deletes the
+// derivatives due to expire at now. A failure on one object is logged and
+// the sweep goes on.
+func (w *lifecycleWorker) sweep(ctx context.Context, now time.Time) error {
+	bucketName := w.api.Config.ImagesBucket
+	if w.cfg.transitions() {
+		// Originals stored by content are tiered as blobs, by the age of
+		// their first upload.
+		prefixes := []string{"images/"}
+		if w.api.Content != nil {
+			prefixes = append(prefixes, "blobs/")
+		}
+		err := w.walk(ctx, bucketName, prefixes, func(obj s3types.Object, _ string) error {
+			if err := w.transition(ctx, bucketName, obj, now); err != nil {
+				slog.ErrorContext(ctx, "failed to change storage class", "key", aws.ToString(obj.Key), "err", err)
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if w.cfg.DerivedDays > 0 {
+		return w.expireDerived(ctx, bucketName, now)
+	}
+	return nil
+}
+
+// transition copies an original onto itself in the storage class its age
+// calls for, if that is colder than its own.
+func (w *lifecycleWorker) transition(ctx context.Context, bucketName string, obj s3types.Object, now time.Time) error {
+	class := s3types.StorageClass(obj.StorageClass)
+	if class == "" {
+		class = s3types.StorageClassStandard
+	}
+	// Empty objects, such as the pointers to blobs, are not worth moving.
+	if (class != s3types.StorageClassStandard && class != s3types.StorageClassStandardIa) || aws.ToInt64(obj.Size) == 0 {
+		return nil
+	}
+	key := aws.ToString(obj.Key)
+	uploaded := aws.ToTime(obj.LastModified)
+	var head *s3.HeadObjectOutput
+	if class == s3types.StorageClassStandardIa {
+		if w.cfg.ArchiveDays == 0 {
+			return nil
+		}
+		// Moving it to IA reset LastModified; the upload time went into
+		// its metadata.
+		var err error
+		if head, err = w.api.S3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)}); err != nil {
+			return err
+		}
+		if t, err := strconv.ParseInt(head.Metadata[uploadedMetadata], 10, 64); err == nil {
+			uploaded = time.Unix(t, 0)
+		}
+	}
+	age := now.Sub(uploaded)
+	var target s3types.StorageClass
+	switch {
+	case w.cfg.ArchiveDays > 0 && age >= time.Duration(w.cfg.ArchiveDays)*24*time.Hour:
+		target = w.cfg.ArchiveClass
+	case w.cfg.IADays > 0 && age >= time.Duration(w.cfg.IADays)*24*time.Hour && class == s3types.StorageClassStandard:
+		target = s3types.StorageClassStandardIa
+	default:
+		return nil
+	}
+
+	if head == nil {
+		var err error
+		if head, err = w.api.S3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)}); err != nil {
+			return err
+		}
+	}
+	metadata := maps.Clone(head.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	if _, ok := metadata[uploadedMetadata]; !ok {
+		metadata[uploadedMetadata] = strconv.FormatInt(uploaded.Unix(), 10)
+	}
+	// The capture time falls back to LastModified, which the copy resets.
+	if _, ok := metadata["capture-time"]; !ok {
+		metadata["capture-time"] = uploaded.UTC().Format(time.RFC3339)
+	}
+	_, err := w.api.S3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(bucketName),
+		Key:               aws.String(key),
+		CopySource:        aws.String(bucketName + "/" + key),
+		StorageClass:      target,
+		ContentType:       head.ContentType,
+		CacheControl:      head.CacheControl,
+		Metadata:          metadata,
+		MetadataDirective: s3types.MetadataDirectiveReplace,
+	})
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "changed storage class", "key", key, "from", class, "to", target, "age_days", int(age.Hours()/24))
+	lifecycleObjects.WithLabelValues(strings.ToLower(string(target))).Inc()
+	return nil
+}
+
+// expireDerived deletes thumbnails older than LIFECYCLE_DERIVED_DAYS, and
+// each image's pyramid and tiles together once its manifest is, so a
+// manifest never outlives its tiles. Tiles and pyramid levels with no
+// manifest go by their own age.
+func (w *lifecycleWorker) expireDerived(ctx context.Context, bucketName string, now time.Time) error {
+	cutoff := now.Add(-time.Duration(w.cfg.DerivedDays) * 24 * time.Hour)
+	type pyramid struct {
+		keys     []string
+		old      []string
+		manifest *time.Time
+	}
+	pyramids := map[string]*pyramid{}
+	var expired []string
+	err := w.walk(ctx, bucketName, derivedPrefixes, func(obj s3types.Object, rest string) error {
+		key, modified := aws.ToString(obj.Key), aws.ToTime(obj.LastModified)
+		dir, path, _ := strings.Cut(rest, "/")
+		if dir == "thumbnails" {
+			if modified.Before(cutoff) {
+				expired = append(expired, key)
+			}
+			return nil
+		}
+		id, file, _ := strings.Cut(path, "/")
+		group := strings.TrimSuffix(key, rest) + id
+		p := pyramids[group]
+		if p == nil {
+			p = &pyramid{}
+			pyramids[group] = p
+		}
+		p.keys = append(p.keys, key)
+		if dir == "pyramid" && file == "manifest.json" {
+			p.manifest = &modified
+		}
+		if modified.Before(cutoff) {
+			p.old = append(p.old, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range pyramids {
+		switch {
+		case p.manifest == nil:
+			expired = append(expired, p.old...)
+		case p.manifest.Before(cutoff):
+			expired = append(expired, p.keys...)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	slog.InfoContext(ctx, "lifecycle deleting derivatives", "count", len(expired))
+	if err := deleteKeys(ctx, w.api.S3, bucketName, expired); err != nil {
+		return fmt.Errorf("delete derivatives: %w", err)
+	}
+	lifecycleObjects.WithLabelValues("expired").Add(float64(len(expired)))
+	gone := make(map[string]bool, len(expired))
+	for _, key := range expired {
+		gone[key] = true
+	}
+	w.api.Memory.RemoveFunc(func(key string) bool { return gone[key] })
+	return nil
+}
+
+// restoreSpec is the input of a restore job.
+type restoreSpec struct {
+	ImageID string `json:"image_id"`
+}
+
+// restore returns the job restoring the original of id, starting one unless
+// this instance already has one running.
+func (w *lifecycleWorker) restore(ctx context.Context, id string) (Job, error) {
+	key := tenantObjectKey(ctx, imageKey(id))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if jobID, ok := w.restores[key]; ok {
+		if job, err := w.api.Jobs.Get(ctx, jobID); err == nil && job.active() {
+			return job, nil
+		}
+	}
+	job, err := w.api.Jobs.Submit(ctx, "restore", restoreSpec{ImageID: id}, func(j *Job) {
+		j.Items = []JobItem{{ID: id, Status: JobQueued}}
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	w.restores[key] = job.ID
+	return job, nil
+}
+
+// runRestoreJob asks S3 to restore an archived original and waits, without
+// holding a worker, until the restored copy can be read.
+func (api *API) runRestoreJob(ctx context.Context, job Job) (string, string, error) {
+	bucketName := api.Config.ImagesBucket
+	var spec restoreSpec
+	if err := json.Unmarshal(job.Params, &spec); err != nil {
+		return "", "", permanent(fmt.Errorf("decode params: %w", err))
+	}
+	key := imageKey(spec.ImageID)
+	head, err := api.S3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+	if err != nil {
+		return "", "", fmt.Errorf("head %s: %w", spec.ImageID, err)
+	}
+	restore := aws.ToString(head.Restore)
+	switch {
+	case !isArchiveClass(head.StorageClass) || strings.Contains(restore, `ongoing-request="false"`):
+		api.Jobs.Update(job.ID, func(j *Job) { j.Items = []JobItem{{ID: spec.ImageID, Status: JobSucceeded}} })
+		lifecycleObjects.WithLabelValues("restored").Inc()
+		slog.InfoContext(ctx, "restored archived image", "id", spec.ImageID, "restore", restore)
+		return "", "", nil
+	case !strings.Contains(restore, `ongoing-request="true"`):
+		cfg := api.Lifecycle.cfg
+		_, err := api.S3.RestoreObject(ctx, &s3.RestoreObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			RestoreRequest: &s3types.RestoreRequest{
+				Days:                 aws.Int32(int32(cfg.RestoreDays)),
+				GlacierJobParameters: &s3types.GlacierJobParameters{Tier: cfg.RestoreTier},
+			},
+		})
+		var apiErr smithy.APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress") {
+			return "", "", fmt.Errorf("restore %s: %w", spec.ImageID, err)
+		}
+		slog.InfoContext(ctx, "restoring archived image", "id", spec.ImageID, "class", head.StorageClass, "tier", cfg.RestoreTier, "days", cfg.RestoreDays)
+		// A restore of any tier takes minutes at least; an emulated one
+		// may be done already.
+		if head, err := api.S3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)}); err == nil && strings.Contains(aws.ToString(head.Restore), `ongoing-request="false"`) {
+			return api.runRestoreJob(ctx, job)
+		}
+	}
+	api.Jobs.Update(job.ID, func(j *Job) { j.Items = []JobItem{{ID: spec.ImageID, Status: JobRunning}} })
+	return "", "", retryAfter(restorePoll, errors.New("restore in progress"))
+}
+
+// respondCold answers a request for an archived original with the job
+// restoring it.
+func (api *API) respondCold(c *gin.Context, id string) {
+	ctx := c.Request.Context()
+	job, err := api.Lifecycle.restore(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to submit restore job", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to start restoring the archived image")
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(restoreRetryAfter))
+	c.JSON(http.StatusAccepted, newJobAccepted(job))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gin-gonic/gin"
+)
+
+// putAged writes key to the "sat" bucket of store as last modified at.
+func putAged(t *testing.T, store *fsStore, key string, at time.Time) {
+	t.Helper()
+	var frame bytes.Buffer
+	if err := jpeg.Encode(&frame, image.NewGray(image.Rect(0, 0, 64, 64)), nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String("sat"),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(frame.Bytes()),
+		ContentType: aws.String("image/jpeg"),
+		Metadata:    map[string]string{"mission": "m1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := store.paths(aws.String("sat"), aws.String(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(data, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycleTransitions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store := testFSStore(t)
+	now := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	putAged(t, store, imageKey("old"), now.Add(-40*day))
+	putAged(t, store, imageKey("new"), now.Add(-5*day))
+	putAged(t, store, "tenants/acme/"+imageKey("ancient"), now.Add(-200*day))
+
+	api := &API{
+		Config:  &Config{ImagesBucket: "sat"},
+		S3:      store,
+		Limiter: newProcessLimiter(0, defaultQueueTimeout),
+		Jobs:    newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers),
+	}
+	api.Lifecycle = newLifecycleWorker(api, LifecycleConfig{IADays: 30, ArchiveDays: 90, ArchiveClass: s3types.StorageClassGlacier, RestoreDays: 7, RestoreTier: s3types.TierBulk})
+	head := func(key string) *s3.HeadObjectOutput {
+		t.Helper()
+		out, err := store.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if err := api.Lifecycle.sweep(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]s3types.StorageClass{
+		imageKey("old"):                       s3types.StorageClassStandardIa,
+		imageKey("new"):                       "",
+		"tenants/acme/" + imageKey("ancient"): s3types.StorageClassGlacier,
+	} {
+		if got := head(key).StorageClass; got != want {
+			t.Errorf("%s in %q, want %q", key, got, want)
+		}
+	}
+	old := head(imageKey("old"))
+	if old.Metadata["mission"] != "m1" || old.Metadata[uploadedMetadata] == "" || aws.ToString(old.ContentType) != "image/jpeg" {
+		t.Errorf("old metadata %v %q", old.Metadata, aws.ToString(old.ContentType))
+	}
+	if captured := parseCaptureTime(old.Metadata, old.LastModified); !captured.Equal(now.Add(-40 * day)) {
+		t.Errorf("old captured %v", captured)
+	}
+
+	// Moving to IA reset LastModified, but the age is still from upload.
+	if err := api.Lifecycle.sweep(ctx, now.Add(60*day)); err != nil {
+		t.Fatal(err)
+	}
+	if got := head(imageKey("old")).StorageClass; got != s3types.StorageClassGlacier {
+		t.Errorf("old in %q after 100 days", got)
+	}
+
+	router := gin.New()
+	router.GET("/image/:id/thumbnail", api.getThumbnail)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/image/old/thumbnail", nil))
+		return w
+	}
+	w := get()
+	var accepted JobAccepted
+	if err := json.Unmarshal(w.Body.Bytes(), &accepted); err != nil || w.Code != http.StatusAccepted || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("cold thumbnail: %d %s", w.Code, w.Body)
+	}
+	var again JobAccepted
+	if w := get(); json.Unmarshal(w.Body.Bytes(), &again) != nil || again.JobID != accepted.JobID {
+		t.Errorf("second request started job %s, not %s", again.JobID, accepted.JobID)
+	}
+	job, err := api.Jobs.Get(ctx, accepted.JobID)
+	if err != nil || job.Type != "restore" {
+		t.Fatalf("job %+v, %v", job, err)
+	}
+	if _, _, err := api.runRestoreJob(ctx, job); err != nil {
+		t.Fatalf("runRestoreJob: %v", err)
+	}
+	if w := get(); w.Code != http.StatusOK {
+		t.Errorf("restored thumbnail: %d %s", w.Code, w.Body)
+	}
+	if !isColdObject(func() error {
+		_, err := store.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("sat"), Key: aws.String("tenants/acme/" + imageKey("ancient"))})
+		return err
+	}()) {
+		t.Error("ancient can be read without a restore")
+	}
+}
+
+func TestLifecycleExpireDerived(t *testing.T) {
+	ctx := context.Background()
+	store := testFSStore(t)
+	now := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	old, recent := now.Add(-40*24*time.Hour), now.Add(-time.Hour)
+	objects := []struct {
+		key  string
+		at   time.Time
+		kept bool
+	}{
+		{"thumbnails/256/a.jpg", old, false},
+		{"thumbnails/256/b.jpg", recent, true},
+		{"tenants/acme/thumbnails/64/a.jpg", old, false},
+		// A pyramid goes with its manifest, whatever its levels' ages.
+		{"pyramid/a/manifest.json", old, false},
+		{"pyramid/a/0.jpg", recent, false},
+		{"tiles/a/0/0/0.jpg", recent, false},
+		{"pyramid/b/manifest.json", recent, true},
+		{"tiles/b/0/0/0.jpg", old, true},
+		{"tiles/c/0/0/0.jpg", old, false},
+		{"tiles/c/1/0/0.jpg", recent, true},
+		{imageKey("a"), old, true},
+		{"derived/a/x.png", old, true},
+	}
+	for _, o := range objects {
+		putAged(t, store, o.key, o.at)
+	}
+	api := &API{Config: &Config{ImagesBucket: "sat"}, S3: store, Memory: newMemoryCache(defaultMemoryCacheMB<<20, defaultMemoryCacheTTL)}
+	api.Memory.Add("thumbnails/256/a.jpg", cachedObject{Data: []byte("x")})
+	w := newLifecycleWorker(api, LifecycleConfig{DerivedDays: 30})
+	if err := w.sweep(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	pages := s3.NewListObjectsV2Paginator(store, &s3.ListObjectsV2Input{Bucket: aws.String("sat")})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range page.Contents {
+			kept = append(kept, aws.ToString(obj.Key))
+		}
+	}
+	for _, o := range objects {
+		if slices.Contains(kept, o.key) != o.kept {
+			t.Errorf("%s kept = %v", o.key, !o.kept)
+		}
+	}
+	if _, ok := api.Memory.Get("thumbnails/256/a.jpg"); ok {
+		t.Error("expired thumbnail still in memory")
+	}
+}
+
+func TestLoadLifecycleConfig(t *testing.T) {
+	t.Setenv("LIFECYCLE_ARCHIVE_CLASS", "deep_archive")
+	t.Setenv("LIFECYCLE_RESTORE_TIER", "bulk")
+	t.Setenv("LIFECYCLE_IA_DAYS", "30")
+	cfg, errs := loadLifecycleConfig()
+	if len(errs) > 0 || cfg.ArchiveClass != s3types.StorageClassDeepArchive || cfg.RestoreTier != s3types.TierBulk || cfg.IADays != 30 || cfg.RestoreDays != defaultLifecycleRestore {
+		t.Errorf("%+v %v", cfg, errs)
+	}
+	t.Setenv("LIFECYCLE_RESTORE_TIER", "instant")
+	t.Setenv("LIFECYCLE_DERIVED_DAYS", "-1")
+	if _, errs := loadLifecycleConfig(); len(errs) != 2 {
+		t.Errorf("errors %v", errs)
+	}
+}
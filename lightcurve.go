@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// lightCurveMaxMeasure caps how many frames one request will measure on
+	// the fly; larger missions should persist photometry per image first.
+	lightCurveMaxMeasure = 100
+	// Measuring decodes the full-resolution source, so fewer run at once
+	// than thumbnail fetches.
+	lightCurveMeasureLimit = 4
+)
+
+// LightCurveSample is one frame's brightness. Mag is the instrumental
+// magnitude, -2.5·log10(flux), and is omitted when the flux is not positive.
+type LightCurveSample struct {
+	ImageID     string   `json:"image_id"`
+	CaptureTime int64    `json:"capture_time"`
+	Flux        float64  `json:"flux"`
+	Mag         *float64 `json:"mag,omitempty"`
+	SNR         float64  `json:"snr"`
+	FWHM        float64  `json:"fwhm"`
+	X           float64  `json:"x"`
+	Y           float64  `json:"y"`
+	Saturated   bool     `json:"saturated"`
+	// Source is "stored" for photometry read from IMAGE_TABLE and
+	// "measured" for photometry computed by this request.
+	Source string `json:"source"`
+}
+
+type LightCurveSkip struct {
+	ImageID string `json:"image_id"`
+	Reason  string `json:"reason"`
+}
+
+type LightCurveResponse struct {
+	MissionID string             `json:"mission_id"`
+	Samples   []LightCurveSample `json:"samples"`
+	Skipped   []LightCurveSkip   `json:"skipped,omitempty"`
+}
+
+// getLightCurve returns flux against capture time for the mission's images.
+// Frames with photometry stored on their image record use it; the rest are
+// measured on the fly around the ?x=&y= hint within ?search= pixels, as
+// GET /image/:id/photometry does. ?recompute=true measures every frame,
+// ?persist=true stores new measurements, and ?format=csv returns CSV.
+func (api *API) getLightCurve(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'format' parameter. Must be json or csv.")
+		return
+	}
+	hint, search, err := parsePhotometryParams(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	recompute, _ := strconv.ParseBool(c.Query("recompute"))
+	persist, _ := strconv.ParseBool(c.Query("persist"))
+
+	ctx := c.Request.Context()
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+
+	var records map[string]*ImageRecord
+	if !recompute {
+		records, err = api.Images.ImageRecords(ctx, mission.ImageIDs)
+		if err != nil && !errors.Is(err, errImageTableUnset) {
+			slog.ErrorContext(ctx, "failed to load image records", "mission", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+			return
+		}
+	}
+
+	resp := LightCurveResponse{MissionID: id, Samples: []LightCurveSample{}}
+	var pending []string
+	for _, imageID := range mission.ImageIDs {
+		if r, ok := records[imageID]; ok && r.Photometry != nil {
+			resp.Samples = append(resp.Samples, newLightCurveSample(imageID, r.Photometry, "stored"))
+			continue
+		}
+		pending = append(pending, imageID)
+	}
+	if len(pending) > lightCurveMaxMeasure {
+		respondError(c, http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("%d images have no stored photometry; at most %d can be measured per request. Persist photometry with GET /image/:id/photometry?persist=true first.", len(pending), lightCurveMaxMeasure))
+		return
+	}
+
+	var mu sync.Mutex
+	var overloaded bool
+	var g errgroup.Group
+	g.SetLimit(lightCurveMeasureLimit)
+	for _, imageID := range pending {
+		g.Go(func() error {
+			m, err := api.measureImage(ctx, bucketName, imageID, hint, search)
+			if err == nil && persist {
+				if perr := api.Images.SetImageAttribute(ctx, imageID, "photometry", m); perr != nil {
+					slog.ErrorContext(ctx, "failed to store photometry", "id", imageID, "err", perr)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				resp.Samples = append(resp.Samples, newLightCurveSample(imageID, m, "measured"))
+			case errors.Is(err, errOverloaded):
+				overloaded = true
+			case isNotFound(err):
+				resp.Skipped = append(resp.Skipped, LightCurveSkip{ImageID: imageID, Reason: "object not found"})
+			case errors.Is(err, errNoSource), errors.Is(err, errHintOutside), errors.Is(err, errImageTooLarge):
+				resp.Skipped = append(resp.Skipped, LightCurveSkip{ImageID: imageID, Reason: err.Error()})
+			default:
+				slog.ErrorContext(ctx, "light curve measurement failed", "id", imageID, "err", err)
+				resp.Skipped = append(resp.Skipped, LightCurveSkip{ImageID: imageID, Reason: "failed to process image"})
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if overloaded {
+		respondOverloaded(c)
+		return
+	}
+
+	sort.SliceStable(resp.Samples, func(i, j int) bool { return resp.Samples[i].CaptureTime < resp.Samples[j].CaptureTime })
+	sort.Slice(resp.Skipped, func(i, j int) bool { return resp.Skipped[i].ImageID < resp.Skipped[j].ImageID })
+
+	c.Header("Cache-Control", "private, max-age=300")
+	if format == "csv" {
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", lightCurveCSV(resp.Samples))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func newLightCurveSample(imageID string, p *Photometry, source string) LightCurveSample {
+	s := LightCurveSample{
+		ImageID:     imageID,
+		CaptureTime: p.CaptureTime,
+		Flux:        p.Flux,
+		SNR:         p.SNR,
+		FWHM:        p.FWHM,
+		X:           p.X,
+		Y:           p.Y,
+		Saturated:   p.Saturated,
+		Source:      source,
+	}
+	if p.Flux > 0 {
+		mag := math.Round(-2.5*math.Log10(p.Flux)*1000) / 1000
+		s.Mag = &mag
+	}
+	return s
+}
+
+// lightCurveCSV writes one row per sample. Capture times are RFC 3339 so the
+// file loads directly into a spreadsheet or pandas.
+func lightCurveCSV(samples []LightCurveSample) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"image_id", "capture_time", "flux", "mag", "snr", "fwhm", "x", "y", "saturated", "source"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, s := range samples {
+		captured, mag := "", ""
+		if s.CaptureTime != 0 {
+			captured = time.Unix(s.CaptureTime, 0).UTC().Format(time.RFC3339)
+		}
+		if s.Mag != nil {
+			mag = f(*s.Mag)
+		}
+		w.Write([]string{
+			s.ImageID,
+			captured,
+			f(s.Flux), mag, f(s.SNR), f(s.FWHM), f(s.X), f(s.Y),
+			strconv.FormatBool(s.Saturated),
+			s.Source,
+		})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewLightCurveSample(t *testing.T) {
+	s := newLightCurveSample("a", &Photometry{Flux: 100, CaptureTime: 5, X: 1, Y: 2}, "stored")
+	if s.Mag == nil || *s.Mag != -5 || s.CaptureTime != 5 || s.X != 1 || s.Source != "stored" {
+		t.Errorf("sample %+v", s)
+	}
+	if s := newLightCurveSample("a", &Photometry{Flux: -3}, "measured"); s.Mag != nil {
+		t.Errorf("negative flux has magnitude %g", *s.Mag)
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(lightCurveCSV([]LightCurveSample{s, newLightCurveSample("b", &Photometry{}, "measured")}))).ReadAll()
+	if err != nil || len(rows) != 3 || rows[0][0] != "image_id" || rows[1][1] != "1970-01-01T00:00:05Z" || rows[1][3] != "-5" || rows[2][1] != "" || rows[2][3] != "" {
+		t.Errorf("csv %q, %v", rows, err)
+	}
+}
+
+func TestGetLightCurve(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store := testFSStore(t)
+	for id, img := range map[string]image.Image{
+		"measured": testStarField(120, 100, 2, 20000, [2]float64{50, 60}),
+		"sky":      testStarField(120, 100, 2, 0),
+	} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		putTestObject(t, store, imageKey(id), buf.Bytes())
+	}
+	db := testSQLStore(t)
+	data, _ := json.Marshal(Mission{ID: "m1", ImageIDs: []string{"sky", "measured", "stored", "gone"}})
+	if _, err := db.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", "m1", string(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetImageAttribute(ctx, "stored", "photometry", Photometry{Flux: 100, CaptureTime: 200}); err != nil {
+		t.Fatal(err)
+	}
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store, MissionDB: db, Images: db, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	router := gin.New()
+	router.GET("/mission/:id/lightcurve", api.getLightCurve)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/mission/m1/lightcurve?x=50&y=60&search=10&persist=true")
+	var resp LightCurveResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("light curve: %d %s", w.Code, w.Body)
+	}
+	// The stored sample was captured first; the measured one at upload.
+	if len(resp.Samples) != 2 || resp.Samples[0].ImageID != "stored" || resp.Samples[0].Source != "stored" ||
+		resp.Samples[1].ImageID != "measured" || resp.Samples[1].Source != "measured" || resp.Samples[1].Flux <= 0 {
+		t.Errorf("samples %+v", resp.Samples)
+	}
+	if len(resp.Skipped) != 2 || resp.Skipped[0].ImageID != "gone" || resp.Skipped[1].ImageID != "sky" {
+		t.Errorf("skipped %+v", resp.Skipped)
+	}
+	record, err := db.ImageRecord(ctx, "measured")
+	if err != nil || record == nil || record.Photometry == nil {
+		t.Errorf("measurement not persisted: %+v, %v", record, err)
+	}
+
+	// Persisted, the measurement is read back rather than made again.
+	if err := json.Unmarshal(get("/mission/m1/lightcurve").Body.Bytes(), &resp); err != nil || len(resp.Samples) != 2 || resp.Samples[1].Source != "stored" {
+		t.Errorf("second light curve %+v, %v", resp, err)
+	}
+	if w := get("/mission/m1/lightcurve?recompute=true&x=50&y=60&search=10"); !bytes.Contains(w.Body.Bytes(), []byte(`"source": "measured"`)) || bytes.Contains(w.Body.Bytes(), []byte(`"source": "stored"`)) {
+		t.Errorf("recomputed light curve %s", w.Body)
+	}
+	if w := get("/mission/m1/lightcurve?format=csv"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("csv: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	for path, want := range map[string]int{
+		"/mission/m1/lightcurve?format=xml": http.StatusBadRequest,
+		"/mission/m1/lightcurve?search=-1":  http.StatusBadRequest,
+		"/mission/none/lightcurve":          http.StatusNotFound,
+	} {
+		if w := get(path); w.Code != want {
+			t.Errorf("GET %s: %d, want %d", path, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	defaultProcessMemoryMB = 1024
+	defaultQueueTimeout    = 5 * time.Second
+	// Decoded pixels are held as up to 8 bytes each (16-bit RGBA), and the
+	// source and one working copy are alive at the same time.
+	bytesPerDecodedPixel = 16
+	// Retry-After sent with 503s when the server is saturated.
+	overloadRetryAfter    = "5"
+	defaultMaxImagePixels = 100_000_000
+)
+
+var (
+	errOverloaded    = errors.New("image processing capacity exhausted")
+	errImageTooLarge = errors.New("image too large to decode")
+)
+
+type waitKey struct{}
+
+// waitForCapacity marks ctx as belonging to background work, which queues for
+// processing capacity for as long as it takes instead of giving up after
+// PROCESS_QUEUE_TIMEOUT.
+func waitForCapacity(ctx context.Context) context.Context {
+	return context.WithValue(ctx, waitKey{}, true)
+}
+
+// ProcessLimiter bounds the memory held by concurrent decode, resize and
+// encode work with a semaphore weighted in bytes. PROCESS_MEMORY_MB sets the
+// budget, by default half the container's memory limit. Every render also
+// takes at least 1/(2·GOMAXPROCS) of the budget, which caps concurrency at
+// twice the CPU count however small the images are.
+type ProcessLimiter struct {
+	sem      *semaphore.Weighted
+	capacity int64
+	minCost  int64
+	timeout  time.Duration
+	inUse    atomic.Int64
+}
+
+// newProcessLimiter returns a limiter with a budget of capacity bytes, or
+// half the container's memory limit when capacity is 0, that requests wait
+// up to timeout for.
+func newProcessLimiter(capacity int64, timeout time.Duration) *ProcessLimiter {
+	if capacity == 0 {
+		capacity = int64(defaultProcessMemoryMB) << 20
+		if limit := memoryLimit(); limit > 0 {
+			capacity = limit / 2
+		}
+	}
+
+	slog.Info("image processing memory budget", "mb", capacity>>20)
+	return &ProcessLimiter{
+		sem:      semaphore.NewWeighted(capacity),
+		capacity: capacity,
+		minCost:  max(capacity/int64(2*runtime.GOMAXPROCS(0)), 1),
+		timeout:  timeout,
+	}
+}
+
+// Acquire reserves cost bytes of the budget and returns the function that
+// gives them back. Requests wait up to PROCESS_QUEUE_TIMEOUT and then fail
+// with errOverloaded; background work waits until ctx is done. A cost above
+// the whole budget is clamped, so an oversized image runs alone rather than
+// never. A nil limiter admits everything.
+func (l *ProcessLimiter) Acquire(ctx context.Context, cost int64) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	cost = min(max(cost, l.minCost), l.capacity)
+
+	waitCtx := ctx
+	if ctx.Value(waitKey{}) == nil {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+	if err := l.sem.Acquire(waitCtx, cost); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		processRejected.Inc()
+		return nil, errOverloaded
+	}
+	processInFlight.Inc()
+	l.inUse.Add(cost)
+	start := time.Now()
+	return func() {
+		l.inUse.Add(-cost)
+		l.sem.Release(cost)
+		processInFlight.Dec()
+		processDuration.Observe(time.Since(start).Seconds())
+		chargeProcessing(ctx, time.Since(start))
+	}, nil
+}
+
+// maxImagePixels is the largest source, in pixels, that may be decoded in
+// full. Decoders run outside the handlers, so configureDecoders sets it from
+// MAX_IMAGE_PIXELS at startup.
+var maxImagePixels int64 = defaultMaxImagePixels
+
+// configureDecoders applies cfg to the decoding done wherever an image is
+// read, such as by the formats registered with the image package.
+func configureDecoders(cfg *Config) {
+	maxImagePixels = cfg.MaxImagePixels
+	fitsScale = cfg.FITSScale
+}
+
+// imagePixelLimit is the largest source, in pixels, that may be decoded in
+// full.
+func imagePixelLimit() int64 {
+	return maxImagePixels
+}
+
+// checkPixels returns errImageTooLarge if decoding n pixels would exceed
+// imagePixelLimit.
+func checkPixels(n int64) error {
+	if limit := imagePixelLimit(); n > limit {
+		return fmt.Errorf("%w: %d pixels, limit %d", errImageTooLarge, n, limit)
+	}
+	return nil
+}
+
+// sourcePixels reads the dimensions from an encoded image's header, without
+// decoding it, and checks them against imagePixelLimit. Headers that cannot
+// be read report 0 and no error; the decode that follows will fail anyway.
+// A FITS file's raw samples, held while it is decoded, are counted on top
+// as the pixels they would take decoded.
+func sourcePixels(data []byte) (int64, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, nil
+	}
+	n := int64(cfg.Width) * int64(cfg.Height)
+	if err := checkPixels(n); err != nil {
+		return n, err
+	}
+	if format == "fits" {
+		n += (fitsDataBytes(data) + bytesPerDecodedPixel - 1) / bytesPerDecodedPixel
+	}
+	return n, nil
+}
+
+// imageTooLargeMessage is the client-facing error for errImageTooLarge.
+func imageTooLargeMessage() string {
+	return fmt.Sprintf("Image exceeds the %d megapixel decode limit. Large sources can be cropped or resized only when stored as TIFF of at least 8 MB.", imagePixelLimit()/1_000_000)
+}
+
+// respondOverloaded sends the 503 for errOverloaded.
+func respondOverloaded(c *gin.Context) {
+	c.Header("Retry-After", overloadRetryAfter)
+	respondError(c, http.StatusServiceUnavailable, CodeOverloaded, "image processing capacity exhausted, retry shortly")
+}
+
+// memoryLimit returns the cgroup memory limit, or the machine's total memory
+// outside a container, in bytes. It returns 0 if neither can be read.
+func memoryLimit() int64 {
+	if data, err := os.ReadFile("/sys/fs/cgroup/memory.max"); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			return n
+		}
+	}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kb << 10
+		}
+	}
+	return 0
+}
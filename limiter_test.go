@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestProcessLimiter(t *testing.T) {
+	var none *ProcessLimiter
+	if release, err := none.Acquire(context.Background(), 1<<40); err != nil {
+		t.Errorf("nil limiter: %v", err)
+	} else {
+		release()
+	}
+
+	l := newProcessLimiter(1<<20, 20*time.Millisecond)
+	if l.capacity != 1<<20 || l.timeout != 20*time.Millisecond || l.minCost < 1 || l.minCost > l.capacity {
+		t.Fatalf("limiter %+v", l)
+	}
+	ctx := context.Background()
+
+	// Small work is charged the minimum, and a cost over the budget is
+	// clamped so it can still run alone.
+	release, err := l.Acquire(ctx, 1)
+	if err != nil || l.inUse.Load() != l.minCost {
+		t.Fatalf("small acquire: %d in use, %v", l.inUse.Load(), err)
+	}
+	release()
+	release, err = l.Acquire(ctx, 10*l.capacity)
+	if err != nil || l.inUse.Load() != l.capacity {
+		t.Fatalf("oversized acquire: %d in use, %v", l.inUse.Load(), err)
+	}
+
+	// With the budget taken a request gives up after the queue timeout, or
+	// as soon as its own context is done.
+	if _, err := l.Acquire(ctx, 1); !errors.Is(err, errOverloaded) {
+		t.Errorf("saturated: %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.Acquire(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: %v", err)
+	}
+	// Background work waits past the timeout for capacity to come back.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}()
+	waited, err := l.Acquire(waitForCapacity(ctx), 1)
+	if err != nil {
+		t.Fatalf("background acquire: %v", err)
+	}
+	waited()
+	if l.inUse.Load() != 0 {
+		t.Errorf("%d in use after release", l.inUse.Load())
+	}
+}
+
+func TestSourcePixels(t *testing.T) {
+	defer func(limit int64) { maxImagePixels = limit }(maxImagePixels)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 40, 25))); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sourcePixels(buf.Bytes()); n != 1000 || err != nil {
+		t.Errorf("sourcePixels = %d, %v", n, err)
+	}
+	if n, err := sourcePixels([]byte("not an image")); n != 0 || err != nil {
+		t.Errorf("unreadable header: %d, %v", n, err)
+	}
+
+	maxImagePixels = 999
+	if n, err := sourcePixels(buf.Bytes()); n != 1000 || !errors.Is(err, errImageTooLarge) {
+		t.Errorf("over the limit: %d, %v", n, err)
+	}
+	if err := checkPixels(999); err != nil {
+		t.Errorf("checkPixels at the limit: %v", err)
+	}
+}
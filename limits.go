@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultMaxOutputDimension = 8192
+	defaultMaxBodyBytes       = 1 << 20
+	defaultMaxRequestOps      = 32
+)
+
+// RequestLimits caps what one request may ask of the server before any
+// handler runs, so that ?width=100000 is refused instead of allocated.
+type RequestLimits struct {
+	// MaxDimension bounds ?width= and ?height=.
+	MaxDimension int64
+	// MaxCropPixels bounds the area of ?crop=.
+	MaxCropPixels int64
+	// MaxBodyBytes bounds request bodies such as job and annotation JSON.
+	MaxBodyBytes int64
+	// MaxOps bounds the number of query parameter values, each of which
+	// asks for one operation, or the keys of a batch job's spec.
+	MaxOps int64
+}
+
+// limitError is a request refused for exceeding one of the limits. It is
+// sent as a LIMIT_EXCEEDED or PAYLOAD_TOO_LARGE problem with "parameter" and
+// "limit" members.
+type limitError struct {
+	status  int
+	param   string
+	limit   int64
+	message string
+}
+
+func (e *limitError) Error() string { return e.message }
+
+func (e *limitError) problem() *Problem {
+	code := CodeLimitExceeded
+	if e.status == http.StatusRequestEntityTooLarge {
+		code = CodePayloadTooLarge
+	}
+	return newProblem(e.status, code, e.message).with("parameter", e.param).with("limit", e.limit)
+}
+
+func respondLimit(c *gin.Context, e *limitError) {
+	respondProblem(c, e.problem())
+}
+
+// checkQuery checks processing parameters, whether from a query string or a
+// batch job's spec. Values that do not parse are left for the handler to
+// reject with its own message.
+func (l RequestLimits) checkQuery(q url.Values) *limitError {
+	ops := 0
+	for _, vs := range q {
+		ops += len(vs)
+	}
+	if int64(ops) > l.MaxOps {
+		return &limitError{http.StatusBadRequest, "query", l.MaxOps,
+			fmt.Sprintf("Too many parameters. At most %d are allowed per request.", l.MaxOps)}
+	}
+	for _, name := range []string{"width", "height"} {
+		for _, v := range q[name] {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > l.MaxDimension {
+				return &limitError{http.StatusBadRequest, name, l.MaxDimension,
+					fmt.Sprintf("Invalid '%s' parameter. Must be at most %d.", name, l.MaxDimension)}
+			}
+		}
+	}
+	for _, v := range q["crop"] {
+		r, err := parseCrop(v)
+		if err != nil {
+			continue
+		}
+		w, h := int64(r.Dx()), int64(r.Dy())
+		if w > l.MaxCropPixels || h > l.MaxCropPixels || w*h > l.MaxCropPixels {
+			return &limitError{http.StatusBadRequest, "crop", l.MaxCropPixels,
+				fmt.Sprintf("Invalid 'crop' parameter. The region may cover at most %d pixels.", l.MaxCropPixels)}
+		}
+	}
+	return nil
+}
+
+// limitRequests enforces the limits on every route. Bodies are small JSON
+// documents, so they are read here in full, which lets an oversized one be
+// answered with 413 whether or not it declared its length.
+func limitRequests(l RequestLimits) gin.HandlerFunc {
+	tooLarge := &limitError{http.StatusRequestEntityTooLarge, "body", l.MaxBodyBytes,
+		fmt.Sprintf("Request body too large. At most %d bytes are allowed.", l.MaxBodyBytes)}
+	return func(c *gin.Context) {
+		if err := l.checkQuery(c.Request.URL.Query()); err != nil {
+			respondLimit(c, err)
+			return
+		}
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > l.MaxBodyBytes {
+			respondLimit(c, tooLarge)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, l.MaxBodyBytes+1))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, "failed to read request body")
+			return
+		}
+		if int64(len(body)) > l.MaxBodyBytes {
+			respondLimit(c, tooLarge)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
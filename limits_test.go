@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLimitRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limits := RequestLimits{MaxDimension: 4096, MaxCropPixels: 1_000_000, MaxBodyBytes: 64, MaxOps: 4}
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		body      string
+		chunked   bool
+		wantCode  int
+		wantParam string
+	}{
+		{name: "within limits", method: "GET", target: "/x?width=4096&crop=0,0,1000,1000", wantCode: http.StatusOK},
+		{name: "unparsable left to the handler", method: "GET", target: "/x?width=big&crop=a", wantCode: http.StatusOK},
+		{name: "width", method: "GET", target: "/x?width=100000", wantCode: http.StatusBadRequest, wantParam: "width"},
+		{name: "repeated height", method: "GET", target: "/x?height=10&height=5000", wantCode: http.StatusBadRequest, wantParam: "height"},
+		{name: "crop area", method: "GET", target: "/x?crop=0,0,1001,1000", wantCode: http.StatusBadRequest, wantParam: "crop"},
+		{name: "crop overflow", method: "GET", target: "/x?crop=0,0,4294967296,4294967296", wantCode: http.StatusBadRequest, wantParam: "crop"},
+		{name: "too many ops", method: "GET", target: "/x?a=1&b=2&c=3&c=4&d=5", wantCode: http.StatusBadRequest, wantParam: "query"},
+		{name: "small body", method: "POST", target: "/x", body: `{"ids":["a"]}`, wantCode: http.StatusOK},
+		{name: "declared large body", method: "POST", target: "/x", body: strings.Repeat("x", 65), wantCode: http.StatusRequestEntityTooLarge, wantParam: "body"},
+		{name: "chunked large body", method: "POST", target: "/x", body: strings.Repeat("x", 65), chunked: true, wantCode: http.StatusRequestEntityTooLarge, wantParam: "body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(limitRequests(limits))
+			echo := func(c *gin.Context) {
+				body, _ := io.ReadAll(c.Request.Body)
+				c.String(http.StatusOK, string(body))
+			}
+			router.GET("/x", echo)
+			router.POST("/x", echo)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantParam == "" {
+				if w.Body.String() != tt.body {
+					t.Errorf("handler saw body %q, want %q", w.Body, tt.body)
+				}
+				return
+			}
+			var resp struct {
+				Code      ErrorCode `json:"code"`
+				Detail    string    `json:"detail"`
+				Parameter string    `json:"parameter"`
+				Limit     int64     `json:"limit"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Parameter != tt.wantParam || resp.Limit == 0 || resp.Code == "" || resp.Detail == "" {
+				t.Errorf("response = %+v", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	localEndpoint   = "http://localhost:4566"
+	seedImageSize   = 1024
+	seedFrames      = 6
+	seedStars       = 400
+	seedTableWait   = 2 * time.Minute
+	seedJPEGQuality = 90
+)
+
+// localDefaults are the settings -local fills in where the environment has
+// none: LocalStack's edge port and dummy credentials, and resource names
+// matching what seed creates, with CORS open to local frontends.
+var localDefaults = [][2]string{
+	{"AWS_ENDPOINT_URL", localEndpoint},
+	{"AWS_REGION", "us-east-1"},
+	{"AWS_ACCESS_KEY_ID", "test"},
+	{"AWS_SECRET_ACCESS_KEY", "test"},
+	{"MISSION_TABLE", "missions"},
+	{"IMAGE_TABLE", "images"},
+	{"JOBS_TABLE", "jobs"},
+	{"WEBHOOKS_TABLE", "webhooks"},
+	{"API_KEYS_TABLE", "api_keys"},
+	{"ROLES_TABLE", "roles"},
+	{"AUDIT_TABLE", "audit"},
+	{"USAGE_TABLE", "usage"},
+	{"STORAGE_TABLE", "storage"},
+	{"FEATURE_FLAGS_TABLE", "feature_flags"},
+	{"SATELLITES_TABLE", "satellites"},
+	{"TLE_TABLE", "tles"},
+	{"CONJUNCTIONS_TABLE", "conjunctions"},
+	{"SENSORS_TABLE", "sensors"},
+	{"DOWNLINKS_TABLE", "downlinks"},
+	{"MISSION_HISTORY_TABLE", "mission_history"},
+	{"IMAGE_HASHES_TABLE", "image_hashes"},
+	{"IMAGE_FOOTPRINTS_TABLE", "image_footprints"},
+	{"CONTENT_REFS_TABLE", "content_refs"},
+	{"SAT_IMAGES_BUCKET", "sat-images"},
+	{"CORS_PRESET", "dev"},
+}
+
+// applyLocalDefaults points every AWS client at a local emulator. Variables
+// that are already set win, so AWS_ENDPOINT_URL_DYNAMODB=http://localhost:8000
+// sends DynamoDB to DynamoDB Local while S3 goes to LocalStack or MinIO.
+func applyLocalDefaults() {
+	for _, kv := range localDefaults {
+		if _, ok := os.LookupEnv(kv[0]); !ok {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	slog.Info("local mode", "endpoint", os.Getenv("AWS_ENDPOINT_URL"))
+}
+
+// seed creates the bucket and tables the server reads, where the backends
+// allow creating them, and loads sample missions and imagery. It is safe to
+// run again: existing resources are kept and the samples overwritten.
+func seed(ctx context.Context, cfg *Config, db *dynamodb.Client, store ObjectStore, missions MissionStore) error {
+	bucket := cfg.ImagesBucket
+	if client, ok := store.(*s3.Client); ok {
+		if err := ensureBucket(ctx, client, bucket); err != nil {
+			return err
+		}
+	}
+	if _, ok := missions.(*dynamoStore); ok {
+		if err := ensureTables(ctx, db, cfg); err != nil {
+			return err
+		}
+	}
+
+	if cfg.MultiTenant {
+		// The samples belong to DEFAULT_TENANT.
+		ctx = withTenant(ctx, cfg.DefaultTenant)
+		store = tenantStore{store}
+	}
+
+	samples := seedMissions(time.Now())
+	for i, m := range samples {
+		for frame, id := range m.ImageIDs {
+			data, err := seedImage(uint64(i), frame)
+			if err != nil {
+				return err
+			}
+			_, err = store.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String(bucket),
+				Key:         aws.String(imageKey(id)),
+				Body:        bytes.NewReader(data),
+				ContentType: aws.String("image/jpeg"),
+			})
+			if err != nil {
+				return fmt.Errorf("put image %s: %w", id, err)
+			}
+		}
+		rec := samples[i]
+		rec.ID = tenantRecordID(ctx, rec.ID)
+		if err := putMission(ctx, missions, &rec); err != nil {
+			return fmt.Errorf("put mission %s: %w", m.ID, err)
+		}
+		slog.InfoContext(ctx, "seeded mission", "id", m.ID, "images", len(m.ImageIDs))
+	}
+	return nil
+}
+
+func ensureBucket(ctx context.Context, client *s3.Client, bucket string) error {
+	in := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	if region := client.Options().Region; region != "" && region != "us-east-1" {
+		in.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraint(region),
+		}
+	}
+	_, err := client.CreateBucket(ctx, in)
+	var owned *s3types.BucketAlreadyOwnedByYou
+	if err != nil && !errors.As(err, &owned) {
+		return fmt.Errorf("create bucket %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// ensureTables creates MISSION_TABLE, IMAGE_TABLE, JOBS_TABLE,
+// WEBHOOKS_TABLE, API_KEYS_TABLE, ROLES_TABLE, AUDIT_TABLE, USAGE_TABLE,
+// STORAGE_TABLE, FEATURE_FLAGS_TABLE, SATELLITES_TABLE, TLE_TABLE,
+// CONJUNCTIONS_TABLE, SENSORS_TABLE, DOWNLINKS_TABLE, MISSION_HISTORY_TABLE,
+// IMAGE_HASHES_TABLE, IMAGE_FOOTPRINTS_TABLE and CONTENT_REFS_TABLE with the
+// keys and indexes the server expects, skipping unset names and tables that
+// exist, and waits for them to become active.
+func ensureTables(ctx context.Context, db *dynamodb.Client, cfg *Config) error {
+	idKey := []types.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: types.KeyTypeHash}}
+	idAttr := types.AttributeDefinition{AttributeName: aws.String("id"), AttributeType: types.ScalarAttributeTypeS}
+	tables := []*dynamodb.CreateTableInput{
+		{TableName: aws.String(cfg.MissionTable)},
+		{TableName: aws.String(cfg.ImageTable)},
+		{TableName: aws.String(cfg.WebhooksTable)},
+		{TableName: aws.String(cfg.APIKeysTable)},
+		{TableName: aws.String(cfg.RolesTable)},
+		{TableName: aws.String(cfg.FlagsTable)},
+		{TableName: aws.String(cfg.SatellitesTable)},
+		{TableName: aws.String(cfg.ConjunctionsTable)},
+		{TableName: aws.String(cfg.SensorsTable)},
+		{TableName: aws.String(cfg.DownlinksTable)},
+		{TableName: aws.String(cfg.HistoryTable)},
+		{TableName: aws.String(cfg.HashesTable)},
+		{TableName: aws.String(cfg.ContentRefsTable)},
+		{TableName: aws.String(cfg.StorageTable)},
+		{
+			TableName: aws.String(cfg.JobsTable),
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: aws.String("status"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: aws.String("created"), AttributeType: types.ScalarAttributeTypeN},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+				IndexName: aws.String(cfg.JobsStatusIndex),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("status"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("created"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			}},
+		},
+		{
+			TableName: aws.String(cfg.AuditTable),
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: aws.String("day"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: aws.String("time"), AttributeType: types.ScalarAttributeTypeN},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+				IndexName: aws.String(auditDayIndex),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("day"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("time"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			}},
+		},
+		{
+			TableName: aws.String(cfg.TLETable),
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: aws.String("satellite"), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: aws.String("epoch_ms"), AttributeType: types.ScalarAttributeTypeN},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+				IndexName: aws.String(tleSatelliteIndex),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("satellite"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("epoch_ms"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			}},
+		},
+		{
+			TableName: aws.String(cfg.UsageTable),
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: aws.String("day"), AttributeType: types.ScalarAttributeTypeS},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+				IndexName: aws.String(usageDayIndex),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("day"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("id"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			}},
+		},
+		{
+			TableName: aws.String(cfg.FootprintsTable),
+			AttributeDefinitions: []types.AttributeDefinition{
+				{AttributeName: aws.String("cell"), AttributeType: types.ScalarAttributeTypeS},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+				IndexName: aws.String(footprintCellIndex),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("cell"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("id"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			}},
+		},
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(db)
+	for _, in := range tables {
+		if aws.ToString(in.TableName) == "" {
+			continue
+		}
+		in.KeySchema = idKey
+		in.AttributeDefinitions = append(in.AttributeDefinitions, idAttr)
+		in.BillingMode = types.BillingModePayPerRequest
+		_, err := db.CreateTable(ctx, in)
+		var inUse *types.ResourceInUseException
+		if err != nil && !errors.As(err, &inUse) {
+			return fmt.Errorf("create table %s: %w", aws.ToString(in.TableName), err)
+		}
+		if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: in.TableName}, seedTableWait); err != nil {
+			return fmt.Errorf("wait for table %s: %w", aws.ToString(in.TableName), err)
+		}
+	}
+	return nil
+}
+
+// putMission writes a mission through whichever metadata backend is in use.
+// The server only reads missions, so this exists for seed alone.
+func putMission(ctx context.Context, missions MissionStore, m *Mission) error {
+	switch s := missions.(type) {
+	case *dynamoStore:
+		item, err := attributevalue.MarshalMap(m)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.missionTable), Item: item})
+		return err
+	case *sqlStore:
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.ExecContext(ctx, "INSERT INTO "+s.missions+" (id, data) VALUES ($1, $2) "+
+			"ON CONFLICT (id) DO UPDATE SET data = excluded.data", m.ID, string(data))
+		return err
+	}
+	return fmt.Errorf("cannot write missions to %T", missions)
+}
+
+// seedMissions are the sample missions, each with seedFrames images, timed
+// around now so that collection windows look current.
+func seedMissions(now time.Time) []Mission {
+	base := now.Truncate(time.Hour).Unix()
+	specs := []struct {
+		id, name, status, collection, target string
+		priority                             int
+		rangeKM                              float64
+	}{
+		{"demo-leo-inspection", "LEO Inspection Demo", "completed", "inspection", "SAT-41001", 1, 12.5},
+		{"demo-geo-survey", "GEO Belt Survey Demo", "active", "survey", "SAT-28884", 2, 310},
+		{"demo-debris-track", "Debris Tracking Demo", "planned", "tracking", "DEB-90210", 3, 48.2},
+	}
+	missions := make([]Mission, len(specs))
+	for i, sp := range specs {
+		tca := base + int64(i-1)*86400
+		ids := make([]string, seedFrames)
+		for f := range ids {
+			ids[f] = fmt.Sprintf("%s-%02d", sp.id, f)
+		}
+		missions[i] = Mission{
+			ID:                    sp.id,
+			Name:                  sp.name,
+			Status:                sp.status,
+			Priority:              sp.priority,
+			TargetSatelliteID:     sp.target,
+			ObserverSatelliteID:   "OBS-1",
+			TCA:                   tca,
+			MinRangeKM:            sp.rangeKM,
+			CollectionWindowStart: tca - 600,
+			CollectionWindowEnd:   tca + 600,
+			CollectionType:        sp.collection,
+			PointingTarget:        sp.target,
+			ImageIDs:              ids,
+			UpdatedAt:             now.Unix(),
+		}
+	}
+	return missions
+}
+
+// seedImage renders frame of a mission as a JPEG star field, the same stars
+// in every frame, with a brighter target drifting across it and a little
+// sensor noise, so time-lapses, stacks and light curves have something to
+// show.
+func seedImage(mission uint64, frame int) ([]byte, error) {
+	stars := rand.New(rand.NewPCG(mission, 1))
+	noise := rand.New(rand.NewPCG(mission, uint64(frame)+2))
+	img := image.NewGray(image.Rect(0, 0, seedImageSize, seedImageSize))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(8 + noise.IntN(10))
+	}
+	for range seedStars {
+		x, y := stars.Float64()*seedImageSize, stars.Float64()*seedImageSize
+		drawStar(img, x, y, 1+stars.Float64()*1.5, 80+stars.Float64()*175)
+	}
+	t := float64(frame) / float64(seedFrames-1)
+	x := seedImageSize * (0.2 + 0.6*t)
+	y := seedImageSize * (0.3 + 0.4*t + 0.05*math.Sin(float64(mission)+t*math.Pi))
+	drawStar(img, x, y, 3, 255)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: seedJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawStar adds a Gaussian spot of the given radius and peak brightness.
+func drawStar(img *image.Gray, cx, cy, radius, peak float64) {
+	r := int(math.Ceil(radius * 3))
+	for y := int(cy) - r; y <= int(cy)+r; y++ {
+		for x := int(cx) - r; x <= int(cx)+r; x++ {
+			if !(image.Point{x, y}).In(img.Rect) {
+				continue
+			}
+			dx, dy := float64(x)-cx, float64(y)-cy
+			v := float64(img.GrayAt(x, y).Y) + peak*math.Exp(-(dx*dx+dy*dy)/(2*radius*radius))
+			img.SetGray(x, y, color.Gray{Y: uint8(min(v, 255))})
+		}
+	}
+}
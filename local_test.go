@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestSeed(t *testing.T) {
+	cfg := &Config{ImagesBucket: "sat-images"}
+	store := testFSStore(t)
+	missions := testSQLStore(t)
+	ctx := context.Background()
+
+	// A second run overwrites the samples rather than failing.
+	for range 2 {
+		if err := seed(ctx, cfg, nil, store, missions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, next, err := missions.Missions(ctx, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := seedMissions(time.Now())
+	if len(page) != len(want) || next != "" {
+		t.Fatalf("seeded %d missions, want %d", len(page), len(want))
+	}
+	for _, m := range page {
+		if len(m.ImageIDs) != seedFrames {
+			t.Errorf("mission %s has %d images", m.ID, len(m.ImageIDs))
+		}
+		out, err := store.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String("sat-images"),
+			Key:    aws.String(imageKey(m.ImageIDs[0])),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := jpeg.DecodeConfig(out.Body)
+		out.Body.Close()
+		if err != nil || cfg.Width != seedImageSize {
+			t.Errorf("image %s: %v, %dpx wide", m.ImageIDs[0], err, cfg.Width)
+		}
+	}
+}
+
+func TestSeedImageMoves(t *testing.T) {
+	first, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := seedImage(0, seedFrames-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := seedImage(0, 0)
+	if bytes.Equal(first, last) || !bytes.Equal(first, again) {
+		t.Error("frames are not deterministic and distinct")
+	}
+}
+
+func TestApplyLocalDefaults(t *testing.T) {
+	for _, kv := range localDefaults {
+		// Setenv restores the variable afterwards; unsetting it leaves it
+		// for applyLocalDefaults to fill in.
+		t.Setenv(kv[0], "")
+		os.Unsetenv(kv[0])
+	}
+	t.Setenv("SAT_IMAGES_BUCKET", "mine")
+	applyLocalDefaults()
+	if got := os.Getenv("AWS_ENDPOINT_URL"); got != localEndpoint {
+		t.Errorf("AWS_ENDPOINT_URL = %q, want %q", got, localEndpoint)
+	}
+	if got := os.Getenv("SAT_IMAGES_BUCKET"); got != "mine" {
+		t.Errorf("SAT_IMAGES_BUCKET = %q, want the value already set", got)
+	}
+}
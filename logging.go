@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern is what an X-Request-ID sent by a client or proxy must
+// look like to be kept; anything else is replaced by a fresh ID.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// requestIDFrom returns the ID of the request ctx belongs to, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID carried by a record's context, so every
+// slog.*Context call made while serving a request can be correlated.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// initLogging installs the default logger, writing JSON lines to stdout.
+// LOG_FORMAT=text switches to key=value lines and LOG_LEVEL sets the minimum
+// level (debug, info, warn or error; default info). The standard log
+// package, used by some dependencies, writes through the same handler.
+func initLogging() {
+	var level slog.Level
+	levelErr := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler = slog.NewJSONHandler(os.Stdout, opts)
+	format := strings.ToLower(os.Getenv("LOG_FORMAT"))
+	if format == "text" {
+		h = slog.NewTextHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+
+	if v := os.Getenv("LOG_LEVEL"); v != "" && levelErr != nil {
+		slog.Warn("invalid LOG_LEVEL, using info", "value", v)
+	}
+	if format != "" && format != "json" && format != "text" {
+		slog.Warn("invalid LOG_FORMAT, using json", "value", format)
+	}
+}
+
+// fatal logs at error level and exits, for failures during startup.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// assignRequestID gives each request an ID, keeping a well-formed
+// X-Request-ID from upstream. The ID is echoed in the response header, put in
+// the request's context for logging, and added as "request_id" to JSON error
+// bodies.
+func assignRequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, id: id}
+		c.Next()
+	}
+}
+
+// requestIDWriter adds the request ID to a JSON or problem object sent with
+// an error status. gin renders a JSON body in a single write, so only the first write
+// is inspected.
+type requestIDWriter struct {
+	gin.ResponseWriter
+	id      string
+	checked bool
+}
+
+func (w *requestIDWriter) Write(p []byte) (int, error) {
+	if w.checked {
+		return w.ResponseWriter.Write(p)
+	}
+	w.checked = true
+	body := bytes.TrimLeft(p, " \t\r\n")
+	if w.Status() < http.StatusBadRequest || len(body) < 2 || body[0] != '{' ||
+		!isJSONType(w.Header().Get("Content-Type")) {
+		return w.ResponseWriter.Write(p)
+	}
+	id, _ := json.Marshal(w.id)
+	tagged := append([]byte(`{"request_id":`), id...)
+	if rest := bytes.TrimLeft(body[1:], " \t\r\n"); len(rest) > 0 && rest[0] != '}' {
+		tagged = append(tagged, ',')
+	}
+	tagged = append(tagged, body[1:]...)
+	if _, err := w.ResponseWriter.Write(tagged); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func isJSONType(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, problemContentType)
+}
+
+func (w *requestIDWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// logRequests writes one access log line per request, at error level for
+// 5xx responses.
+func logRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", c.Writer.Status()),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+		)
+	}
+}
+
+// recoverPanics turns a panicking handler into a 500, logging the panic and
+// its stack with the request ID.
+func recoverPanics() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		slog.ErrorContext(c.Request.Context(), "handler panicked", "err", err, "stack", string(debug.Stack()))
+		respondError(c, http.StatusInternalServerError, CodeInternal, "internal server error")
+	})
+}
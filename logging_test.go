@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAssignRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(&logs, nil)}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	router := gin.New()
+	router.Use(compressResponses(), assignRequestID())
+	router.GET("/ok", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	router.GET("/fail", func(c *gin.Context) {
+		slog.ErrorContext(c.Request.Context(), "s3 GetObject failed", "key", "images/x.jpg")
+		c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+	})
+	router.GET("/empty", func(c *gin.Context) { c.IndentedJSON(http.StatusBadRequest, gin.H{}) })
+	router.GET("/text", func(c *gin.Context) { c.String(http.StatusBadRequest, "{not json") })
+
+	tests := []struct {
+		name     string
+		path     string
+		incoming string
+		keep     bool
+		wantBody string
+	}{
+		{name: "success untouched", path: "/ok", wantBody: `{"ok":true}`},
+		{name: "error tagged", path: "/fail", incoming: "abc-123", keep: true,
+			wantBody: `{"request_id":"abc-123","error":"object not found"}`},
+		{name: "empty object", path: "/empty", incoming: "abc-123", keep: true, wantBody: `{"request_id":"abc-123"}`},
+		{name: "text untouched", path: "/text", wantBody: "{not json"},
+		{name: "malformed incoming ID replaced", path: "/ok", incoming: "bad id\n", wantBody: `{"ok":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			id := w.Header().Get(requestIDHeader)
+			if tt.keep && id != tt.incoming {
+				t.Errorf("%s = %q, want %q", requestIDHeader, id, tt.incoming)
+			}
+			if !tt.keep && (!requestIDPattern.MatchString(id) || id == tt.incoming) {
+				t.Errorf("%s = %q, want a fresh ID", requestIDHeader, id)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+
+	var line struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Key       string `json:"key"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("log output %q: %v", logs.String(), err)
+	}
+	if line.RequestID != "abc-123" || line.Key != "images/x.jpg" {
+		t.Errorf("log line = %+v", line)
+	}
+}
@@ -1,31 +1,89 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
-	"image"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/disintegration/imaging"
-	"github.com/gin-contrib/cors"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/gin-gonic/gin"
 )
 
 type API struct {
-	DB *dynamodb.Client
-	S3 *s3.Client
+	Config      *Config
+	MissionDB   MissionStore
+	Images      ImageStore
+	S3          ObjectStore
+	Derivatives *DerivativeWorker
+	Jobs        *JobStore
+	Batch       *BatchProcessor
+	Derived     *DerivedCache
+	Memory      *MemoryCache
+	Limiter     *ProcessLimiter
+	Overlay     *OverlaySpec
+	Missions    *MissionCache
+	Events      *EventBus
+	Webhooks    *WebhookStore
+	Stream      *MissionStream
+	Sink        *EventSink
+	Keys        *APIKeyStore
+	OIDC        *OIDCVerifier
+	Roles       *RoleStore
+	RateLimiter *RateLimiter
+	Audit       *AuditLog
+	Usage       *UsageMeter
+	// Storage accounts stored and served bytes per mission.
+	Storage    *StorageMeter
+	Flags      *FlagStore
+	Satellites *SatelliteStore
+	TLEs       *TLEStore
+	Sensors    *SensorStore
+	// Downlinks are recorded per mission. A mission DownlinkGrace past its
+	// collection window without one is overdue.
+	Downlinks     *DownlinkStore
+	DownlinkGrace time.Duration
+	// History records mission creation and status changes for
+	// GET /mission/:id/timeline.
+	History *MissionHistory
+	// Hashes are the images' perceptual hashes, for GET
+	// /images/similar/:id.
+	Hashes *ImageHashIndex
+	// Footprints index the ground the images cover, for GET /images/search.
+	Footprints *FootprintIndex
+	// Content stores ingested originals by checksum; nil keeps them where
+	// they were uploaded.
+	Content *contentStore
+	// Layout reads originals where they are: under the extension they were
+	// uploaded with, or where IMAGE_KEY_TEMPLATE and BUCKET_ROUTES placed
+	// them.
+	Layout *layoutStore
+	// Lifecycle tiers and expires objects and restores archived originals.
+	Lifecycle *lifecycleWorker
+	// Conjunctions are read from CDMs. One at least as probable as
+	// CDMProposalPc can come with a proposed mission.
+	Conjunctions  *ConjunctionStore
+	CDMProposalPc float64
+	// PlateSolver is nil when PLATESOLVE_URL is unset.
+	PlateSolver plateSolver
+	// Detector is nil when DETECTOR is unset.
+	Detector objectDetector
 }
 
 type Mission struct {
@@ -37,55 +95,270 @@ type Mission struct {
 	ObserverSatelliteID   string   `dynamodbav:"observer_satellite_id" json:"observer_satellite_id"`
 	TCA                   int64    `dynamodbav:"tca" json:"tca"`
 	MinRangeKM            float64  `dynamodbav:"min_range_km" json:"min_range_km"`
+	RelativeVelocityKMS   float64  `dynamodbav:"relative_velocity_kms,omitempty" json:"relative_velocity_kms,omitempty"`
 	CollectionWindowStart int64    `dynamodbav:"collection_window_start" json:"collection_window_start"`
 	CollectionWindowEnd   int64    `dynamodbav:"collection_window_end" json:"collection_window_end"`
 	CollectionType        string   `dynamodbav:"collection_type" json:"collection_type"`
 	PointingTarget        string   `dynamodbav:"pointing_target" json:"pointing_target"`
 	ImageIDs              []string `dynamodbav:"image_ids" json:"image_ids"`
+	// UpdatedAt is the Unix time of the item's last change, if the writer
+	// records one. It becomes the Last-Modified header.
+	UpdatedAt int64 `dynamodbav:"updated_at" json:"updated_at,omitempty"`
+	// GeometryUpdatedAt is the Unix time TCA, MinRangeKM and
+	// RelativeVelocityKMS were last recomputed from the satellites' TLEs.
+	GeometryUpdatedAt int64 `dynamodbav:"geometry_updated_at,omitempty" json:"geometry_updated_at,omitempty"`
+	// Feasibility is scored at TCA along with the geometry.
+	Feasibility *Feasibility `dynamodbav:"feasibility,omitempty" json:"feasibility,omitempty"`
+	// Schedule is where POST /schedule/optimize last placed the collection.
+	Schedule *ScheduleAssignment `dynamodbav:"schedule,omitempty" json:"schedule,omitempty"`
+}
+
+// lastModified is the latest UpdatedAt among missions. It is zero if any of
+// them lacks one, since that item could have changed at any time.
+func lastModified(missions ...Mission) time.Time {
+	var latest int64
+	for _, m := range missions {
+		if m.UpdatedAt == 0 {
+			return time.Time{}
+		}
+		latest = max(latest, m.UpdatedAt)
+	}
+	if latest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(latest, 0)
 }
 
 func initDB() *dynamodb.Client {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	cfg, err := config.LoadDefaultConfig(context.TODO(), withAWSMetrics())
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		fatal("unable to load SDK config", "err", err)
 	}
 
 	dbClient := dynamodb.NewFromConfig(cfg)
 	return dbClient
 }
 
-func initS3() *s3.Client {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+func initSQS() *sqs.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), withAWSMetrics())
 	if err != nil {
-		log.Fatalf("unable to load SDK config: %v", err)
+		fatal("unable to load SDK config", "err", err)
 	}
-	s3Clent := s3.NewFromConfig(cfg)
-	return s3Clent
+	return sqs.NewFromConfig(cfg)
+}
+
+func initStreams() *dynamodbstreams.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), withAWSMetrics())
+	if err != nil {
+		fatal("unable to load SDK config", "err", err)
+	}
+	return dynamodbstreams.NewFromConfig(cfg)
+}
+
+// initEventSink returns a publisher to the SNS topic or EventBridge bus
+// target, which the configuration has already checked is one.
+func initEventSink(target string) *EventSink {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), withAWSMetrics())
+	if err != nil {
+		fatal("unable to load SDK config", "err", err)
+	}
+	if service, _ := eventsTarget(target); service == "sns" {
+		return newSNSSink(sns.NewFromConfig(cfg), target)
+	}
+	return newEventBridgeSink(eventbridge.NewFromConfig(cfg), target)
 }
 
 func main() {
+	initLogging()
+	local := flag.Bool("local", false, "use LocalStack, DynamoDB Local or MinIO on localhost")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML file of settings the environment leaves unset")
+	corsPreset := flag.String("cors-preset", "", "CORS policy to start from: dev, or the production default when empty")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-local] [-config file] [-cors-preset dev] [seed]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *corsPreset != "" {
+		os.Setenv("CORS_PRESET", *corsPreset)
+	}
+	cfg, err := loadConfig(*configFile, *local)
+	if err != nil {
+		fatal("invalid configuration", "err", err)
+	}
+	configureDecoders(cfg)
+	configureDownloads(cfg)
+	cache, err := newCache(cfg.RedisURL)
+	if err != nil {
+		fatal("invalid REDIS_URL", "err", err)
+	}
+
+	db := initDB()
+	store := encryptObjects(cfg.Encryption, initStorage(cfg))
+	var content *contentStore
+	if cfg.ContentAddressed {
+		content = addressContent(store, newContentRefs(db, cfg.ContentRefsTable))
+		store = content
+	}
+	missions, images := initMetadataStore(cfg, db)
+	switch flag.Arg(0) {
+	case "":
+	case "seed":
+		if err := seed(context.Background(), cfg, db, store, missions); err != nil {
+			fatal("seed failed", "err", err)
+		}
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	// Seeding and the readiness check see the stores unscoped.
+	scopedStore, scopedMissions, scopedImages := scopeTenants(cfg, store, missions, images)
 	api := &API{
-		DB: initDB(),
-		S3: initS3(),
+		Config:      cfg,
+		MissionDB:   scopedMissions,
+		Images:      scopedImages,
+		S3:          scopedStore,
+		Jobs:        newJobStore(db, cfg.JobsTable, cfg.JobsStatusIndex, cfg.JobMaxAttempts, cfg.JobWorkers),
+		Derived:     newDerivedCache(scopedStore, cfg.ImagesBucket, cfg.DerivedCacheTTL, cfg.DerivedCacheMaxBytes),
+		Memory:      newMemoryCache(cfg.MemoryCacheBytes, cfg.MemoryCacheTTL),
+		Limiter:     newProcessLimiter(cfg.ProcessMemory, cfg.ProcessQueueTimeout),
+		Overlay:     cfg.Overlay,
+		Missions:    newMissionCache(cache, cfg.MissionCacheTTL),
+		Events:      newEventBus(),
+		Webhooks:    newWebhookStore(db, cfg.WebhooksTable, cfg.WebhookMaxAttempts),
+		Keys:        newAPIKeyStore(db, cfg.APIKeysTable),
+		Roles:       newRoleStore(db, cfg.RolesTable),
+		RateLimiter: newRateLimiter(cfg.RateLimits),
+		Audit:       newAuditLog(db, cfg.AuditTable),
+		Usage:       newUsageMeter(db, cfg.UsageTable),
+		Storage:     newStorageMeter(db, cfg.StorageTable),
+		Flags:       newFlagStore(db, cfg.FlagsTable, cfg.FeatureFlags),
+		Satellites:  newSatelliteStore(db, cfg.SatellitesTable),
+		TLEs:        newTLEStore(db, cfg.TLETable),
+		Sensors:     newSensorStore(db, cfg.SensorsTable),
+
+		Downlinks:     newDownlinkStore(db, cfg.DownlinksTable),
+		DownlinkGrace: cfg.DownlinkGrace,
+		History:       newMissionHistory(db, cfg.HistoryTable),
+		Hashes:        newImageHashIndex(db, cfg.HashesTable),
+		Footprints:    newFootprintIndex(db, cfg.FootprintsTable),
+		Content:       content,
+
+		Conjunctions:  newConjunctionStore(db, cfg.ConjunctionsTable),
+		CDMProposalPc: cfg.CDMProposalPc,
+	}
+	api.Layout = routeObjects(scopedStore, scopedImages, cfg.ImagesBucket, cfg.Layout)
+	api.S3 = api.Layout
+	if api.Derived != nil {
+		api.Derived.Start(context.Background())
+	}
+	api.Derivatives = newDerivativeWorker(api, initSQS(), cfg.DerivativesQueueURL, cfg.DerivativeWorkers)
+	api.Derivatives.Start(context.Background())
+	api.Batch = newBatchProcessor(api, cfg.ProcessWorkers)
+	api.Batch.Start(context.Background())
+	api.Jobs.Register("timelapse", api.meteredJob(api.runTimelapseJob))
+	api.Jobs.Register("stack", api.meteredJob(api.runStackJob))
+	api.Jobs.Register("process", api.meteredJob(api.runProcessJob))
+	if cfg.PlateSolveURL != "" {
+		api.PlateSolver = newAstrometryNet(cfg.PlateSolveURL, cfg.PlateSolveAPIKey)
+	}
+	api.Jobs.Register("platesolve", api.meteredJob(api.runPlatesolveJob))
+	api.Lifecycle = newLifecycleWorker(api, cfg.Lifecycle)
+	api.Jobs.Register("restore", api.runRestoreJob)
+	api.Jobs.Register("integrity", api.runIntegrityJob)
+	api.Detector = newObjectDetector(cfg.Detector)
+	api.Jobs.Start(context.Background())
+	api.Webhooks.Start(context.Background(), api.Events)
+	api.Keys.Start(context.Background())
+	api.Roles.Start(context.Background())
+	api.RateLimiter.Start(context.Background())
+	api.Usage.Start(context.Background())
+	api.Storage.Start(context.Background())
+	api.Flags.Start(context.Background())
+	api.Satellites.Start(context.Background())
+	api.Sensors.Start(context.Background())
+	api.Conjunctions.Start(context.Background())
+	api.Downlinks.Start(context.Background())
+	newDownlinkWatch(api).Start(context.Background())
+	api.Lifecycle.Start(context.Background())
+	integritySchedule{api: api, interval: cfg.IntegrityInterval, repair: cfg.IntegrityRepair}.Start(context.Background())
+	api.History.Start(context.Background(), api.Events)
+	api.Hashes.Start(context.Background())
+	newTLEFetcher(cfg.TLE, api.Satellites, api.TLEs).Start(context.Background())
+	if cfg.OIDC.Issuer != "" {
+		api.OIDC = newOIDCVerifier(cfg.OIDC)
 	}
+	if cfg.MissionStreamARN != "" {
+		api.Stream = newMissionStream(api, initStreams(), cfg.MissionStreamARN)
+		api.Stream.Start(context.Background())
+	}
+	if cfg.EventsARN != "" {
+		api.Sink = initEventSink(cfg.EventsARN)
+		api.Sink.Start(context.Background(), api.Events)
+	}
+
+	router := gin.New()
 
-	router := gin.Default()
+	router.Use(recordRequests())
+	router.Use(compressResponses())
+	router.Use(assignRequestID())
+	router.Use(logRequests())
+	router.Use(recoverPanics())
 
-	router.Use(cors.New(cors.Config{
-		// AllowOrigins:     []string{"*"}, // For Development
-		AllowOrigins:     []string{"https://mission.austinlopez.work"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
+	router.Use(cfg.CORS.middleware())
+	router.Use(limitRequests(cfg.Limits))
+	router.Use(api.auditRequests())
+	router.Use(api.meterUsage())
+	router.NoRoute(routeNotFound)
+
+	// JSON endpoints answer from metadata and should be quick; the rest read
+	// and render imagery.
+	short := routeTimeout(cfg.RequestTimeout)
+	long := routeTimeout(cfg.ProcessingTimeout)
 
 	router.GET("/ping", ping)
-	router.GET("/missions", api.getMissions)
-	router.GET("/mission/:id", api.getMissionById)
-	router.GET("/image/:id", api.getSatImageByID)
+	router.GET("/metrics", metricsHandler())
+	router.GET("/healthz", getHealthz)
+	router.GET("/livez", getHealthz)
+	router.GET("/readyz", newReadiness(cfg, db, store, missions).getReadyz)
+	router.GET("/openapi.json", openAPIHandler())
+	graphqlHandler := api.graphqlHandler()
+	router.GET("/graphql", short, api.require(ScopeMissionsRead), api.rateLimit(rateClassJSON), graphqlHandler)
+	router.POST("/graphql", short, api.require(ScopeMissionsRead), api.rateLimit(rateClassJSON), graphqlHandler)
+	// A WebSocket stays open for as long as the client watches, so it has
+	// no route timeout.
+	router.GET("/ws", api.requireStream(ScopeMissionsRead), api.getWebSocket)
+	if cfg.SwaggerUI {
+		router.GET("/docs", getSwaggerUI)
+	}
 
-	router.Run(":8080")
+	// Profiling and diagnostics bypass the route timeouts, since a CPU
+	// profile or trace runs for as long as ?seconds= asks.
+	api.publishDiagnostics()
+	admin := router.Group("/admin", api.require(ScopeAdmin))
+	admin.GET("/diagnostics", api.getDiagnostics)
+	admin.GET("/audit", api.getAudit)
+	admin.GET("/storage", short, api.getStorageReport)
+	admin.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	admin.Any("/debug/pprof/*name", pprofHandler())
+	api.routesMaintenance(router.Group("/admin/maintenance", api.require(ScopeMaintenance)), short, long)
+
+	v1 := router.Group(apiV1Prefix)
+	api.routesV1(v1, short, long)
+	// The routes predate versioning, so the dashboard and other existing
+	// clients keep working against the root until the sunset.
+	if cfg.LegacyRoutes {
+		api.routesV1(router.Group("", deprecatedRoute(cfg.LegacySunset)), short, long)
+	}
+
+	tlsServer, err := newServerTLS(cfg.TLS)
+	if err != nil {
+		fatal("invalid TLS configuration", "err", err)
+	}
+	if cfg.GRPCPort != 0 {
+		go serveGRPC(api, tlsServer)
+	}
+	serveHTTP(cfg, tlsServer, router)
 }
 
 func ping(c *gin.Context) {
@@ -100,8 +373,6 @@ type PaginatedMissionsResponse struct {
 }
 
 func (api *API) getMissions(c *gin.Context) {
-	tableName := os.Getenv("MISSION_TABLE")
-
 	limit := int32(10)
 	const maxLimit = 100
 
@@ -109,7 +380,7 @@ func (api *API) getMissions(c *gin.Context) {
 	if countStr != "" {
 		parsedCount, err := strconv.ParseInt(countStr, 10, 32)
 		if err != nil || parsedCount <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'count' parameter. Must be a positive integer."})
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'count' parameter. Must be a positive integer.")
 			return
 		}
 
@@ -120,185 +391,349 @@ func (api *API) getMissions(c *gin.Context) {
 		}
 	}
 
-	scanInput := &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-		Limit:     aws.Int32(limit),
-	}
-
-	token := c.Query("nextToken")
-
-	if token != "" {
-		decodedToken, err := base64.StdEncoding.DecodeString(token)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pagination token"})
-			return
-		}
-
-		var tempKey map[string]map[string]string
-		if err := json.Unmarshal(decodedToken, &tempKey); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pagination token format"})
-			return
-		}
-
-		exclusiveStartKey := make(map[string]types.AttributeValue)
-		for key, valMap := range tempKey {
-			for typeIdentifier, value := range valMap {
-				switch typeIdentifier {
-				case "S":
-					exclusiveStartKey[key] = &types.AttributeValueMemberS{Value: value}
-				case "N":
-					exclusiveStartKey[key] = &types.AttributeValueMemberN{Value: value}
-				}
-			}
-		}
-		scanInput.ExclusiveStartKey = exclusiveStartKey
-
-	}
-
-	output, err := api.DB.Scan(c.Request.Context(), scanInput)
+	expand, err := parseExpand(c.Query("expand"))
 	if err != nil {
-		log.Printf("DynamoDB scan failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve missions"})
+		respondProblem(c, problemFor(err))
 		return
 	}
 
-	var missions []Mission
-	err = attributevalue.UnmarshalListOfMaps(output.Items, &missions)
+	page, err := api.listMissions(c.Request.Context(), limit, c.Query("nextToken"))
 	if err != nil {
-		log.Printf("Failed to unmarshal missions: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process mission data"})
+		respondProblem(c, problemFor(err))
+		return
+	}
+	if expand.any() {
+		missions, modified := api.expandMissions(c.Request.Context(), page.Missions, lastModified(page.Missions...), expand)
+		conditionalJSON(c, ExpandedMissionsResponse{Missions: missions, NextToken: page.NextToken}, modified)
 		return
 	}
+	conditionalJSON(c, page, lastModified(page.Missions...))
+}
 
-	var nextToken *string
-	if len(output.LastEvaluatedKey) > 0 {
-		serializableKey := make(map[string]interface{})
-		for key, val := range output.LastEvaluatedKey {
-			switch v := val.(type) {
-			case *types.AttributeValueMemberS:
-				serializableKey[key] = map[string]string{"S": v.Value}
-			case *types.AttributeValueMemberN:
-				serializableKey[key] = map[string]string{"N": v.Value}
-			}
-		}
+// listMissions returns one page of missions, through the mission cache.
+func (api *API) listMissions(ctx context.Context, limit int32, token string) (*PaginatedMissionsResponse, error) {
+	if page, ok := api.Missions.Page(ctx, limit, token); ok {
+		return page, nil
+	}
 
-		jsonKey, err := json.Marshal(serializableKey)
-		if err != nil {
-			log.Printf("Failed to marshal LastEvaluatedKey: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare pagination token"})
-			return
+	missions, next, err := api.MissionDB.Missions(ctx, limit, token)
+	if err != nil {
+		if errors.Is(err, errInvalidMissionToken) {
+			return nil, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid pagination token")
 		}
-
-		encodedToken := base64.StdEncoding.EncodeToString(jsonKey)
-		nextToken = aws.String(encodedToken)
+		slog.ErrorContext(ctx, "failed to list missions", "err", err)
+		return nil, newProblem(http.StatusInternalServerError, CodeInternal, "Failed to retrieve missions")
+	}
+	var nextToken *string
+	if next != "" {
+		nextToken = aws.String(next)
 	}
 
-	response := PaginatedMissionsResponse{
+	response := &PaginatedMissionsResponse{
 		Missions:  missions,
 		NextToken: nextToken,
 	}
+	api.Missions.StorePage(ctx, limit, token, response)
+	return response, nil
+}
 
-	c.IndentedJSON(http.StatusOK, response)
+// loadMission fetches a single mission, through the mission cache, returning
+// errMissionNotFound when the table has no item for id.
+func (api *API) loadMission(ctx context.Context, id string) (*Mission, error) {
+	if mission, ok := api.Missions.Mission(ctx, id); ok {
+		return mission, nil
+	}
+	mission, err := api.MissionDB.Mission(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	api.Missions.StoreMission(ctx, mission)
+	return mission, nil
 }
 
 func (api *API) getMissionById(c *gin.Context) {
-	tableName := os.Getenv("MISSION_TABLE")
-
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing id"})
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
 		return
 	}
 
-	out, err := api.DB.GetItem(c.Request.Context(), &dynamodb.GetItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: id},
-		},
-	})
+	expand, err := parseExpand(c.Query("expand"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve mission"})
+		respondProblem(c, problemFor(err))
 		return
 	}
-	if out.Item == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "mission not found"})
+
+	mission, err := api.loadMission(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
 		return
 	}
-	var mission Mission
-	err = attributevalue.UnmarshalMap(out.Item, &mission)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve mission"})
+	if expand.any() {
+		expanded, modified := api.expandMissions(c.Request.Context(), []Mission{*mission}, lastModified(*mission), expand)
+		conditionalJSON(c, expanded[0], modified)
 		return
 	}
-	c.IndentedJSON(http.StatusOK, mission)
+	conditionalJSON(c, mission, lastModified(*mission))
 }
 
 func (api *API) getSatImageByID(c *gin.Context) {
-	bucketName := os.Getenv("SAT_IMAGES_BUCKET")
+	bucketName := api.Config.ImagesBucket
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing id"})
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
 		return
 	}
 
-	key := fmt.Sprintf("images/%s.jpg", id)
-
-	widthStr := c.Query("width")
-	heightStr := c.Query("height")
-	contrastStr := c.Query("contrast")
+	key := imageKey(id)
 
-	width, _ := strconv.Atoi(widthStr)
-	height, _ := strconv.Atoi(heightStr)
-	contrast, _ := strconv.ParseFloat(contrastStr, 64)
+	if strings.EqualFold(c.Query("format"), "fits") {
+		api.downloadFITS(c, id)
+		return
+	}
+	opts, err := parseProcessOptions(c, api.Overlay)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	if err := api.gateFormat(c.Request.Context(), &opts, c.GetHeader("Accept")); err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	needsProcessing := opts.NeedsProcessing()
+	verify, _ := strconv.ParseBool(c.Query("verify"))
+	if verify && (needsProcessing || opts.StripMetadata || c.GetHeader("Range") != "") {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "verify applies only to whole, unprocessed downloads")
+		return
+	}
 
-	needsProcessing := width > 0 || height > 0 || contrast != 0
+	if opts.Annotate {
+		opts.Annotations, err = api.loadAnnotations(c.Request.Context(), bucketName, id)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to load annotations", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to retrieve annotations")
+			return
+		}
+	}
+	if err := api.loadObjectOverlay(c.Request.Context(), id, &opts); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to load detections", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to retrieve detections")
+		return
+	}
 
 	in := &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(key),
 	}
 
-	if !needsProcessing {
-		if rng := c.GetHeader("Range"); rng != "" {
-			in.Range = aws.String(rng)
-		}
+	// Only whole responses are kept in memory: processed output under its
+	// parameters, and originals without a Range or stripping.
+	var memKey string
+	switch {
+	case needsProcessing:
+		memKey = "processed/" + id + "\n" + opts.fingerprint()
+		in.Range = firstPart()
+	case opts.StripMetadata:
+		in.Range = firstPart()
+	case c.GetHeader("Range") != "":
+		// Stripping rewrites the whole file, so byte ranges of the
+		// original cannot be served.
+		in.Range = aws.String(c.GetHeader("Range"))
+	case verify:
+		// A verified download is checked against what S3 holds now.
+	default:
+		memKey = key
+	}
+	// The memory cache is shared by every tenant, so its keys are the
+	// tenant's bucket keys. What is read with an SSE-C key is not cached.
+	customerKey := customerKeyFrom(c.Request.Context()) != nil
+	if memKey != "" {
+		memKey = tenantObjectKey(c.Request.Context(), memKey)
+	}
+	if customerKey {
+		memKey = ""
+	}
+	if obj, ok := api.Memory.Get(memKey); ok {
+		serveCachedObject(c, obj, "memory")
+		return
 	}
 
-	out, err := api.S3.GetObject(c.Request.Context(), in)
+	out, err := api.S3.GetObject(c.Request.Context(), in, s3Accelerate(bucketName)...)
+	if errors.Is(err, errCustomerKey) {
+		respondCustomerKey(c, customerKey)
+		return
+	}
+	if isColdObject(err) {
+		api.respondCold(c, id)
+		return
+	}
+	if isInvalidRange(err) && c.GetHeader("Range") != "" {
+		respondError(c, http.StatusRequestedRangeNotSatisfiable, CodeRangeNotSatisfiable, "the requested range is not satisfiable")
+		return
+	}
+	if isNotFound(err) {
+		respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+		return
+	}
 	if err != nil {
-		log.Printf("s3 GetObject error key=%s: %v", key, err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+		slog.ErrorContext(c.Request.Context(), "s3 GetObject failed", "key", key, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
 		return
 	}
 	defer out.Body.Close()
 
-	if needsProcessing {
-		srcImage, err := imaging.Decode(out.Body)
+	if !needsProcessing && opts.StripMetadata {
+		raw, err := api.readObject(c.Request.Context(), bucketName, key, out, out.Body)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to read object", "key", key, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
+			return
+		}
+		defer putBuffer(raw)
+		data := raw.Bytes()
+		stripped, format, err := stripDownload(data)
 		if err != nil {
-			log.Printf("failed to decode image key=%s: %v", key, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process image"})
+			slog.ErrorContext(c.Request.Context(), "cannot strip metadata", "key", key, "err", err)
+			respondError(c, http.StatusUnprocessableEntity, CodeImageUnsupported, "metadata cannot be stripped from this image format")
 			return
 		}
+		if format == nil {
+			c.Header("Cache-Control", "private, max-age=3600")
+			c.Header(checksumHeader, contentSHA256(stripped))
+			c.Data(http.StatusOK, aws.ToString(out.ContentType), stripped)
+			return
+		}
+		opts.Format = format
+		out.Body = memoryBody{bytes.NewReader(data)}
+		out.ContentLength = aws.Int64(int64(len(data)))
+		needsProcessing = true
+	}
 
-		var processedImage image.Image = srcImage
+	if needsProcessing {
+		var cacheKey, etag, vary string
+		if opts.Negotiated {
+			vary = "Accept"
+		}
+		if out.ETag != nil {
+			etag = processedETag(aws.ToString(out.ETag), opts)
+		}
+		setHeaders := func() {
+			c.Header("Cache-Control", "private, max-age=3600")
+			if etag != "" {
+				c.Header("ETag", etag)
+			}
+			if vary != "" {
+				c.Writer.Header().Add("Vary", vary)
+			}
+		}
+		// The output is fully determined by the source version and the
+		// parameters, so a client already holding it needs no rendering.
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			setHeaders()
+			c.Status(http.StatusNotModified)
+			return
+		}
 
-		if width > 0 || height > 0 {
-			processedImage = imaging.Resize(processedImage, width, height, imaging.Lanczos)
+		if api.Derived != nil && out.ETag != nil && !customerKey {
+			cacheKey = derivedKey(id, aws.ToString(out.ETag), opts)
+			if obj, ok := api.Derived.Get(c.Request.Context(), bucketName, cacheKey); ok {
+				obj.ETag, obj.Vary, obj.SHA256 = etag, vary, contentSHA256(obj.Data)
+				api.Memory.Add(memKey, obj)
+				serveCachedObject(c, obj, "hit")
+				return
+			}
 		}
 
-		if contrast != 0 {
-			processedImage = imaging.AdjustContrast(processedImage, contrast)
+		processedImage, geo, release, err := api.renderProcessed(c.Request.Context(), bucketName, key, out, opts)
+		if err != nil {
+			if errors.Is(err, errCropOutside) {
+				respondError(c, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+				return
+			}
+			if errors.Is(err, errOverloaded) {
+				respondOverloaded(c)
+				return
+			}
+			if errors.Is(err, errImageTooLarge) {
+				respondError(c, http.StatusUnprocessableEntity, CodeImageTooLarge, imageTooLargeMessage())
+				return
+			}
+			if errors.Is(err, errCustomerKey) {
+				respondCustomerKey(c, customerKey)
+				return
+			}
+			slog.ErrorContext(c.Request.Context(), "failed to process image", "key", key, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+			return
 		}
 
-		c.Header("Content-Type", "image/jpeg")
-		c.Header("Cache-Control", "private, max-age=3600")
+		// Without a cache the output is streamed as it is encoded. Range and
+		// HEAD requests need the whole body first.
+		partial := c.GetHeader("Range") != "" || c.Request.Method == http.MethodHead
+		if cacheKey == "" && api.Memory == nil && !partial {
+			setHeaders()
+			c.Header("Content-Type", opts.Format.ContentType)
+			err = encodeProcessed(c.Writer, processedImage, opts, geo)
+			release()
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "failed to encode and write image", "key", key, "err", err)
+			}
+			return
+		}
 
-		err = imaging.Encode(c.Writer, processedImage, imaging.JPEG, imaging.JPEGQuality(95))
+		buf := getBuffer()
+		err = encodeProcessed(buf, processedImage, opts, geo)
+		release()
 		if err != nil {
-			log.Printf("failed to encode and write image key=%s: %v", key, err)
+			putBuffer(buf)
+			slog.ErrorContext(c.Request.Context(), "failed to encode image", "key", key, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeImageEncodeFailed, "failed to encode image")
+			return
+		}
+		// The caches keep the bytes, so they get an exact-size copy and the
+		// growable buffer goes back to the pool.
+		data := bytes.Clone(buf.Bytes())
+		putBuffer(buf)
+		obj := cachedObject{Data: data, ContentType: opts.Format.ContentType, ETag: etag, CacheControl: "private, max-age=3600", Vary: vary, SHA256: contentSHA256(data)}
+		serveCachedObject(c, obj, "miss")
+		api.Memory.Add(memKey, obj)
+		if cacheKey != "" {
+			api.Derived.Put(c.Request.Context(), bucketName, cacheKey, opts.Format.ContentType, data)
 		}
 
 	} else {
+		// Only whole originals carry their checksum, which is recorded at
+		// ingest.
+		var checksum string
+		if in.Range == nil {
+			checksum = api.imageChecksum(c.Request.Context(), id, out.Metadata)
+		}
+		if verify {
+			if checksum == "" {
+				respondError(c, http.StatusConflict, CodeChecksumUnavailable, "the image has no recorded checksum to verify against")
+				return
+			}
+			raw, err := api.readObject(c.Request.Context(), bucketName, key, out, out.Body)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "failed to read object", "key", key, "err", err)
+				respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
+				return
+			}
+			defer putBuffer(raw)
+			if sum := contentSHA256(raw.Bytes()); sum != checksum {
+				slog.ErrorContext(c.Request.Context(), "image checksum mismatch", "key", key, "recorded", checksum, "read", sum)
+				respondProblem(c, newProblem(http.StatusInternalServerError, CodeChecksumMismatch, "the stored image does not match its recorded checksum").with("recorded", checksum).with("read", sum))
+				return
+			}
+			out.Body = memoryBody{bytes.NewReader(raw.Bytes())}
+		}
+		if checksum != "" {
+			c.Header(checksumHeader, checksum)
+		}
 		if out.ContentType != nil {
 			c.Header("Content-Type", aws.ToString(out.ContentType))
 		}
@@ -311,11 +746,11 @@ func (api *API) getSatImageByID(c *gin.Context) {
 		if out.LastModified != nil {
 			c.Header("Last-Modified", out.LastModified.UTC().Format(http.TimeFormat))
 		}
+		cacheControl := "private, max-age=60"
 		if out.CacheControl != nil {
-			c.Header("Cache-Control", aws.ToString(out.CacheControl))
-		} else {
-			c.Header("Cache-Control", "private, max-age=60")
+			cacheControl = aws.ToString(out.CacheControl)
 		}
+		c.Header("Cache-Control", cacheControl)
 		c.Header("Accept-Ranges", "bytes")
 
 		status := http.StatusOK
@@ -324,9 +759,32 @@ func (api *API) getSatImageByID(c *gin.Context) {
 			status = http.StatusPartialContent
 		}
 
+		// Copy the body aside while streaming it if it is small enough to
+		// keep in memory.
+		var body io.Reader = out.Body
+		var buf *bytes.Buffer
+		if memKey != "" && api.Memory.Fits(aws.ToInt64(out.ContentLength)) {
+			buf = bytes.NewBuffer(make([]byte, 0, aws.ToInt64(out.ContentLength)))
+			body = io.TeeReader(out.Body, buf)
+		}
+
 		c.Status(status)
-		if _, err := io.Copy(c.Writer, out.Body); err != nil {
-			log.Printf("error streaming key=%s: %v", key, err)
+		if c.Request.Method == http.MethodHead {
+			return
+		}
+		if _, err := io.Copy(c.Writer, body); err != nil {
+			slog.ErrorContext(c.Request.Context(), "streaming failed", "key", key, "err", err)
+			return
+		}
+		if buf != nil {
+			api.Memory.Add(memKey, cachedObject{
+				Data:         buf.Bytes(),
+				ContentType:  aws.ToString(out.ContentType),
+				ETag:         aws.ToString(out.ETag),
+				LastModified: aws.ToTime(out.LastModified),
+				CacheControl: cacheControl,
+				SHA256:       checksum,
+			})
 		}
 	}
 }
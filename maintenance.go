@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenancePrincipal is the holder of MAINTENANCE_TOKEN, who may run the
+// /admin/maintenance operations and nothing else.
+var maintenancePrincipal = &Principal{ID: "maintenance", Scopes: []string{ScopeMaintenance}}
+
+// flushableCaches are the caches POST /admin/maintenance/cache/flush can
+// empty.
+var flushableCaches = []string{"memory", "missions"}
+
+// CacheFlushResponse is the body of POST /admin/maintenance/cache/flush: the
+// entries dropped from each cache named.
+type CacheFlushResponse struct {
+	Flushed map[string]int `json:"flushed"`
+}
+
+// routesMaintenance registers the operations that fix stale state without
+// shell access to an instance.
+func (api *API) routesMaintenance(g *gin.RouterGroup, short, long gin.HandlerFunc) {
+	g.POST("/cache/flush", short, api.postCacheFlush)
+	g.DELETE("/derived/:id", short, api.deleteDerived)
+	g.POST("/reindex", short, api.postReindex)
+	g.POST("/ingest/:id", long, api.postIngest)
+	g.POST("/integrity", short, api.postIntegrityCheck)
+	g.GET("/integrity", short, api.getIntegrityReport)
+	g.GET("/integrity/:id", short, api.getIntegrityReport)
+	g.GET("/flags", short, api.getFlags)
+	g.PUT("/flags/:name", short, api.putFlag)
+	g.DELETE("/flags/:name", short, api.deleteFlag)
+}
+
+// postCacheFlush empties the caches in ?cache=, a comma-separated list of
+// memory and missions, or both without it. The memory cache is this
+// instance's own; the mission cache is every instance's when it is in
+// Redis.
+func (api *API) postCacheFlush(c *gin.Context) {
+	names := flushableCaches
+	if v := c.Query("cache"); v != "" {
+		names = splitList(v)
+		for _, name := range names {
+			if !slices.Contains(flushableCaches, name) {
+				respondError(c, http.StatusBadRequest, CodeInvalidParameter, "cache must be memory or missions, not "+name)
+				return
+			}
+		}
+	}
+	resp := CacheFlushResponse{Flushed: map[string]int{}}
+	for _, name := range names {
+		switch name {
+		case "memory":
+			resp.Flushed[name] = api.Memory.RemoveFunc(func(string) bool { return true })
+		case "missions":
+			n, err := api.Missions.Flush(c.Request.Context())
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "failed to flush mission cache", "err", err)
+				respondError(c, http.StatusInternalServerError, CodeInternal, "failed to flush the mission cache")
+				return
+			}
+			resp.Flushed[name] = n
+		}
+	}
+	slog.InfoContext(c.Request.Context(), "flushed caches", "flushed", resp.Flushed)
+	c.JSON(http.StatusOK, resp)
+}
+
+// deleteDerived purges an image's processed-image cache entries, and this
+// instance's in-memory copies of it, so the next requests render it again.
+func (api *API) deleteDerived(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if id == "" || strings.Contains(id, "/") {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing or invalid id")
+		return
+	}
+	deleted := 0
+	if api.Derived != nil {
+		n, err := api.Derived.Purge(ctx, api.Config.ImagesBucket, id)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to purge derived images", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to purge the processed-image cache")
+			return
+		}
+		deleted = n
+	}
+	memory := api.forgetImage(ctx, id)
+	slog.InfoContext(ctx, "purged derived images", "id", id, "deleted", deleted, "memory", memory)
+	c.JSON(http.StatusOK, gin.H{"id": id, "deleted": deleted, "memory": memory})
+}
+
+// postReindex reloads the mission index from MISSION_TABLE in the
+// background, for when it has drifted from the table. Aggregates answer
+// 503 until it is loaded.
+func (api *API) postReindex(c *gin.Context) {
+	if api.Stream == nil {
+		respondError(c, http.StatusNotImplemented, CodeFeatureUnavailable, "the mission index needs MISSION_STREAM_ARN")
+		return
+	}
+	// The index holds every tenant's missions, so it is loaded unscoped.
+	go api.Stream.reindex(context.Background())
+	c.JSON(http.StatusAccepted, gin.H{"status": "reindexing"})
+}
+
+// postIngest ingests an uploaded image again, as its S3 notification would,
+// and answers with the outcome.
+func (api *API) postIngest(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+	if err := api.ingestImage(ctx, id); err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to ingest image", "id", id, "err", err)
+		respondProblem(c, problemFor(err))
+		return
+	}
+	resp := gin.H{"id": id}
+	if rec, err := api.Images.ImageRecord(ctx, id); err == nil && rec != nil && rec.Ingest != nil {
+		resp["ingest"] = rec.Ingest
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fs, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"images/img1.jpg", "derived/img1/a.png", "derived/img1/b.webp", "derived/img10/a.png"} {
+		if _, err := fs.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("b"), Key: aws.String(key), Body: strings.NewReader("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{
+		Config:   &Config{ImagesBucket: "b", AdminToken: "admin-secret", MaintenanceToken: "ops-secret"},
+		S3:       fs,
+		Memory:   newMemoryCache(defaultMemoryCacheMB<<20, defaultMemoryCacheTTL),
+		Missions: &MissionCache{cache: newMemoryKV(), ttl: time.Minute},
+		Derived:  &DerivedCache{s3: fs, ttl: time.Hour},
+	}
+	for _, key := range []string{"images/img1.jpg", "processed/img1\nw=64", "images/img10.jpg"} {
+		api.Memory.Add(key, cachedObject{Data: []byte("x")})
+	}
+	api.Missions.StoreMission(ctx, &Mission{ID: "m1"})
+
+	router := gin.New()
+	router.GET("/admin/audit", api.require(ScopeAdmin), func(c *gin.Context) { c.Status(http.StatusOK) })
+	api.routesMaintenance(router.Group("/admin/maintenance", api.require(ScopeMaintenance)), func(c *gin.Context) {}, func(c *gin.Context) {})
+	do := func(method, path, token string) (int, map[string]any) {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	auth := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodPost, "/admin/maintenance/reindex", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/audit", "ops-secret", http.StatusForbidden},
+		{http.MethodPost, "/admin/maintenance/reindex", "ops-secret", http.StatusNotImplemented},
+		{http.MethodPost, "/admin/maintenance/reindex", "admin-secret", http.StatusNotImplemented},
+		{http.MethodPost, "/admin/maintenance/cache/flush?cache=redis", "ops-secret", http.StatusBadRequest},
+		{http.MethodPost, "/admin/maintenance/ingest/missing", "ops-secret", http.StatusNotFound},
+	}
+	for _, tt := range auth {
+		if code, _ := do(tt.method, tt.path, tt.token); code != tt.want {
+			t.Errorf("%s %s with %q: status = %d, want %d", tt.method, tt.path, tt.token, code, tt.want)
+		}
+	}
+
+	code, body := do(http.MethodDelete, "/admin/maintenance/derived/img1", "ops-secret")
+	if code != http.StatusOK || body["deleted"] != 2.0 || body["memory"] != 2.0 {
+		t.Errorf("purge: %d %v", code, body)
+	}
+	list, err := fs.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("b"), Prefix: aws.String("derived/")})
+	if err != nil || len(list.Contents) != 1 || aws.ToString(list.Contents[0].Key) != "derived/img10/a.png" {
+		t.Errorf("derived/ holds %v, %v", list.Contents, err)
+	}
+
+	code, body = do(http.MethodPost, "/admin/maintenance/cache/flush", "ops-secret")
+	flushed, _ := body["flushed"].(map[string]any)
+	if code != http.StatusOK || flushed["memory"] != 1.0 || flushed["missions"] != 1.0 {
+		t.Errorf("flush: %d %v", code, body)
+	}
+	if _, ok := api.Missions.Mission(ctx, "m1"); ok {
+		t.Error("mission still cached after the flush")
+	}
+}
+
+func TestPostIngest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, store, imageKey("frame"), frame)
+	// A pointer the server wrote for a duplicate upload is skipped without
+	// a record of its own.
+	if _, err := store.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("bucket"), Key: aws.String(imageKey("pointer")), Body: bytes.NewReader(frame),
+		Metadata: map[string]string{contentSumMetadata: "abc"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db := testSQLStore(t)
+	api := &API{
+		Config:    &Config{ImagesBucket: "bucket", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern)},
+		S3:        store,
+		MissionDB: db,
+		Images:    db,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+	router := gin.New()
+	api.routesMaintenance(router.Group("/admin/maintenance"), func(c *gin.Context) {}, func(c *gin.Context) {})
+
+	for id, wantIngest := range map[string]bool{"frame": true, "pointer": false} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/maintenance/ingest/"+id, nil))
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if _, ok := body["ingest"]; w.Code != http.StatusOK || ok != wantIngest {
+			t.Errorf("ingest %s: %d %s", id, w.Code, w.Body)
+		}
+	}
+}
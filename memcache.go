@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultMemoryCacheMB  = 128
+	defaultMemoryCacheTTL = 5 * time.Minute
+	// No single object may take more than this share of the cache, so one
+	// full-resolution original cannot flush every hot thumbnail.
+	memoryCacheMaxShare = 8
+)
+
+// cachedObject is a complete response body with the headers needed to
+// replay it.
+type cachedObject struct {
+	Data         []byte
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	CacheControl string
+	Vary         string
+	// SHA256 is the hex checksum of Data, when known.
+	SHA256 string
+}
+
+type memoryEntry struct {
+	key    string
+	obj    cachedObject
+	stored time.Time
+}
+
+// MemoryCache is an in-process LRU of image bytes in front of S3, for
+// originals, thumbnails and processed output. MEMORY_CACHE_MB caps its size
+// (default 128, 0 disables it) and MEMORY_CACHE_TTL how long an entry is
+// trusted without going back to S3 (default 5m). A nil *MemoryCache is a
+// disabled cache.
+type MemoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ttl      time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+// newMemoryCache returns a cache of up to maxBytes whose entries are
+// trusted for ttl, or nil when maxBytes is 0.
+func newMemoryCache(maxBytes int64, ttl time.Duration) *MemoryCache {
+	if maxBytes == 0 {
+		return nil
+	}
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Fits reports whether an object of n bytes would be cached.
+func (m *MemoryCache) Fits(n int64) bool {
+	return m != nil && n > 0 && n <= m.maxBytes/memoryCacheMaxShare
+}
+
+// Get returns the entry for key if it is present and within the TTL. An
+// empty key always misses.
+func (m *MemoryCache) Get(key string) (cachedObject, bool) {
+	if m == nil || key == "" {
+		return cachedObject{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		recordCacheLookup("memory", false)
+		return cachedObject{}, false
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Since(e.stored) > m.ttl {
+		m.remove(el)
+		recordCacheLookup("memory", false)
+		return cachedObject{}, false
+	}
+	m.ll.MoveToFront(el)
+	recordCacheLookup("memory", true)
+	return e.obj, true
+}
+
+// Add stores obj under key, evicting the least recently used entries to make
+// room. Objects that do not fit, and empty keys, are ignored.
+func (m *MemoryCache) Add(key string, obj cachedObject) {
+	n := int64(len(obj.Data))
+	if key == "" || !m.Fits(n) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+	m.items[key] = m.ll.PushFront(&memoryEntry{key: key, obj: obj, stored: time.Now()})
+	m.size += n
+	for m.size > m.maxBytes {
+		m.remove(m.ll.Back())
+	}
+}
+
+// RemoveFunc drops the entries whose keys match and returns how many there
+// were.
+func (m *MemoryCache) RemoveFunc(match func(key string) bool) int {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for key, el := range m.items {
+		if match(key) {
+			m.remove(el)
+			n++
+		}
+	}
+	return n
+}
+
+// forgetImage drops the original, processed output, thumbnails and tiles of
+// image id that this instance holds in memory, for when they are replaced
+// or purged, and returns how many entries there were.
+func (api *API) forgetImage(ctx context.Context, id string) int {
+	original := tenantObjectKey(ctx, imageKey(id))
+	processed, tiles := tenantObjectKey(ctx, "processed/"+id+"\n"), tenantObjectKey(ctx, "tiles/"+id+"/")
+	thumbnails := make(map[string]bool, len(thumbnailSizes))
+	for size := range thumbnailSizes {
+		thumbnails[tenantObjectKey(ctx, thumbnailKey(id, size, api.Overlay))] = true
+	}
+	return api.Memory.RemoveFunc(func(key string) bool {
+		return key == original || thumbnails[key] || strings.HasPrefix(key, processed) || strings.HasPrefix(key, tiles)
+	})
+}
+
+func (m *MemoryCache) remove(el *list.Element) {
+	e := m.ll.Remove(el).(*memoryEntry)
+	delete(m.items, e.key)
+	m.size -= int64(len(e.obj.Data))
+}
+
+// serveCachedObject replays obj. Because the whole body is in hand it
+// answers HEAD, Range, If-Range and conditional requests like a static file.
+// source goes in X-Cache.
+func serveCachedObject(c *gin.Context, obj cachedObject, source string) {
+	if obj.ETag != "" {
+		c.Header("ETag", obj.ETag)
+	}
+	c.Header("Content-Type", obj.ContentType)
+	if obj.CacheControl != "" {
+		c.Header("Cache-Control", obj.CacheControl)
+	}
+	if obj.Vary != "" {
+		c.Writer.Header().Add("Vary", obj.Vary)
+	}
+	if obj.SHA256 != "" && c.GetHeader("Range") == "" {
+		c.Header(checksumHeader, obj.SHA256)
+	}
+	c.Header("X-Cache", source)
+	http.ServeContent(c.Writer, c.Request, "", obj.LastModified, bytes.NewReader(obj.Data))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProcessedRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, store, imageKey("a"), frame)
+	db := testSQLStore(t)
+	// Without a cache the render would otherwise be streamed, so ranges
+	// take the buffered path as well.
+	for _, memory := range []*MemoryCache{nil, newMemoryCache(defaultMemoryCacheMB<<20, defaultMemoryCacheTTL)} {
+		api := &API{
+			Config: &Config{ImagesBucket: "bucket", Limits: RequestLimits{
+				MaxDimension: defaultMaxOutputDimension, MaxCropPixels: defaultMaxImagePixels,
+				MaxBodyBytes: defaultMaxBodyBytes, MaxOps: defaultMaxRequestOps,
+			}},
+			S3:        store,
+			MissionDB: db,
+			Images:    db,
+			Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+			Memory:    memory,
+		}
+		router := gin.New()
+		router.GET("/image/:id", api.getSatImageByID)
+		router.HEAD("/image/:id", api.getSatImageByID)
+		do := func(method string, headers ...string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, "/image/a?width=64&format=png", nil)
+			for i := 0; i+1 < len(headers); i += 2 {
+				r.Header.Set(headers[i], headers[i+1])
+			}
+			router.ServeHTTP(w, r)
+			return w
+		}
+
+		full := do(http.MethodGet)
+		if full.Code != http.StatusOK || full.Body.Len() < 100 {
+			t.Fatalf("processed: %d, %d bytes", full.Code, full.Body.Len())
+		}
+		size, etag := full.Body.Len(), full.Header().Get("ETag")
+
+		w := do(http.MethodGet, "Range", "bytes=10-19")
+		if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), full.Body.Bytes()[10:20]) ||
+			w.Header().Get("Content-Range") != "bytes 10-19/"+strconv.Itoa(size) {
+			t.Errorf("range: %d %q, %d bytes", w.Code, w.Header().Get("Content-Range"), w.Body.Len())
+		}
+		if w := do(http.MethodGet, "Range", "bytes=-5"); w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), full.Body.Bytes()[size-5:]) {
+			t.Errorf("suffix range: %d, %d bytes", w.Code, w.Body.Len())
+		}
+		if w := do(http.MethodGet, "Range", "bytes="+strconv.Itoa(size)+"-"); w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("range past the end: %d", w.Code)
+		}
+		// A stale If-Range gets the whole new render.
+		if w := do(http.MethodGet, "Range", "bytes=10-19", "If-Range", etag); w.Code != http.StatusPartialContent {
+			t.Errorf("current If-Range: %d", w.Code)
+		}
+		if w := do(http.MethodGet, "Range", "bytes=10-19", "If-Range", `"old"`); w.Code != http.StatusOK || w.Body.Len() != size {
+			t.Errorf("stale If-Range: %d, %d bytes", w.Code, w.Body.Len())
+		}
+
+		w = do(http.MethodHead)
+		if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("ETag") != etag ||
+			w.Header().Get("Content-Length") != strconv.Itoa(size) || w.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("HEAD: %d, %d bytes, headers %v", w.Code, w.Body.Len(), w.Header())
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/image/a", nil))
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("HEAD original: %d, %d bytes", w.Code, w.Body.Len())
+		}
+	}
+}
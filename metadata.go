@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+type ImageMetadata struct {
+	ID            string   `json:"id"`
+	Key           string   `json:"key"`
+	ContentType   string   `json:"content_type,omitempty"`
+	ContentLength int64    `json:"content_length"`
+	ETag          string   `json:"etag,omitempty"`
+	LastModified  int64    `json:"last_modified,omitempty"`
+	CaptureTime   int64    `json:"capture_time,omitempty"`
+	Format        string   `json:"format,omitempty"`
+	Width         int      `json:"width"`
+	Height        int      `json:"height"`
+	Geo           *GeoInfo `json:"geo,omitempty"`
+	// EXIF holds the descriptive EXIF/TIFF tags embedded in the file.
+	EXIF map[string]string `json:"exif,omitempty"`
+	// FITS holds the header cards of a FITS file.
+	FITS map[string]string `json:"fits,omitempty"`
+	// Quality, Photometry, Geometry, Contamination, WCS, Objects, PHash,
+	// Footprint and Ingest come from the image record.
+	Quality       *QualityMetrics      `json:"quality,omitempty"`
+	Photometry    *Photometry          `json:"photometry,omitempty"`
+	Geometry      *ObservationGeometry `json:"geometry,omitempty"`
+	Contamination *Contamination       `json:"contamination,omitempty"`
+	WCS           *WCS                 `json:"wcs,omitempty"`
+	Objects       *ObjectDetections    `json:"objects,omitempty"`
+	PHash         string               `json:"phash,omitempty"`
+	Footprint     *Footprint           `json:"footprint,omitempty"`
+	Ingest        *IngestRecord        `json:"ingest,omitempty"`
+}
+
+func (api *API) getImageMetadata(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	meta, err := api.imageMetadata(c.Request.Context(), id)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, meta)
+}
+
+// imageMetadata describes an image from its file header and image record.
+func (api *API) imageMetadata(ctx context.Context, id string) (*ImageMetadata, error) {
+	key := imageKey(id)
+
+	// Dimensions, georeferencing and EXIF all sit in the file's header, so
+	// only its start is fetched, however large the image.
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(api.Config.ImagesBucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", cogHeaderBytes-1)),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "s3 GetObject failed", "key", key, "err", err)
+		return nil, newProblem(http.StatusNotFound, CodeImageNotFound, "object not found")
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(out.Body, cogHeaderBytes))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read object", "key", key, "err", err)
+		return nil, newProblem(http.StatusInternalServerError, CodeInternal, "failed to read image")
+	}
+
+	meta := &ImageMetadata{
+		ID:            id,
+		Key:           key,
+		ContentType:   aws.ToString(out.ContentType),
+		ContentLength: objectSize(out),
+		ETag:          aws.ToString(out.ETag),
+	}
+	if out.LastModified != nil {
+		meta.LastModified = out.LastModified.Unix()
+		meta.CaptureTime = parseCaptureTime(out.Metadata, out.LastModified).Unix()
+	}
+	if t, ok := fitsCaptureTime(data); ok && out.Metadata["capture-time"] == "" {
+		meta.CaptureTime = t.Unix()
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to decode image config", "key", key, "err", err)
+	} else {
+		meta.Format = format
+		meta.Width = cfg.Width
+		meta.Height = cfg.Height
+	}
+
+	if format == "tiff" {
+		geo, err := parseGeoTIFF(data)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to parse geotiff tags", "key", key, "err", err)
+		}
+		meta.Geo = geo
+	}
+
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+	}
+	if record != nil {
+		meta.Quality = record.Quality
+		meta.Photometry = record.Photometry
+		meta.Geometry = record.Geometry
+		meta.Contamination = record.Contamination
+		meta.WCS = record.WCS
+		meta.Objects = record.Objects
+		meta.PHash = record.PHash
+		meta.Footprint = record.Footprint
+		meta.EXIF = record.EXIF
+		meta.FITS = record.FITS
+		meta.Ingest = record.Ingest
+	}
+
+	// The tags recorded at ingest were read from the whole file; parse the
+	// header only for images that have not been through it yet.
+	if meta.FITS == nil {
+		meta.FITS = fitsTags(data)
+	}
+	if meta.EXIF == nil {
+		if tags, err := parseEXIF(data); err == nil {
+			meta.EXIF = tags
+		} else if !errors.Is(err, errNoEXIF) {
+			slog.ErrorContext(ctx, "failed to parse exif", "key", key, "err", err)
+		}
+	}
+
+	return meta, nil
+}
+
+// captureTime reports when an image was taken. Uploaders record it in the
+// capture-time object metadata as RFC 3339 or Unix seconds; objects without
+// it fall back to their S3 LastModified time.
+func (api *API) captureTime(ctx context.Context, bucketName, id string) (time.Time, error) {
+	out, err := api.S3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(imageKey(id)),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseCaptureTime(out.Metadata, out.LastModified), nil
+}
+
+func parseCaptureTime(metadata map[string]string, lastModified *time.Time) time.Time {
+	if v, ok := metadata["capture-time"]; ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t
+		}
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(secs, 0)
+		}
+	}
+	return aws.ToTime(lastModified)
+}
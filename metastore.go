@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// batchGetLimit is the most keys DynamoDB accepts in one BatchGetItem call.
+const batchGetLimit = 100
+
+var (
+	errMissionNotFound     = errors.New("mission not found")
+	errMissionStatus       = errors.New("mission status does not allow the change")
+	errInvalidMissionToken = errors.New("invalid mission pagination token")
+	errImageTableUnset     = errors.New("IMAGE_TABLE is not configured")
+	errInvalidImageToken   = errors.New("invalid image record pagination token")
+)
+
+// MissionStore reads missions. They are written by another system; the
+// server only adds the images it ingests to them and moves them through
+// approval and cancellation.
+type MissionStore interface {
+	// Mission returns errMissionNotFound when there is no mission id.
+	Mission(ctx context.Context, id string) (*Mission, error)
+	// Missions returns up to limit missions after the opaque token, and the
+	// token for the next page, which is empty after the last one. A token
+	// this store did not issue yields errInvalidMissionToken.
+	Missions(ctx context.Context, limit int32, token string) ([]Mission, string, error)
+	// LinkImage appends imageID to the mission's ImageIDs and sets its
+	// UpdatedAt, leaving the rest of the item alone. It reports false if
+	// the image was already listed, and returns errMissionNotFound when
+	// there is no mission missionID.
+	LinkImage(ctx context.Context, missionID, imageID string) (bool, error)
+	// UnlinkImage removes imageID from the mission's ImageIDs and sets its
+	// UpdatedAt, leaving the rest of the item alone. It reports false if
+	// the image was not listed, and returns errMissionNotFound when there
+	// is no mission missionID.
+	UnlinkImage(ctx context.Context, missionID, imageID string) (bool, error)
+	// SetGeometry stores g on the mission, with its Feasibility when set,
+	// and sets its UpdatedAt and GeometryUpdatedAt, leaving the rest of the
+	// item alone. It returns
+	// errMissionNotFound when there is no mission id.
+	SetGeometry(ctx context.Context, id string, g MissionGeometry) error
+	// SetSchedule stores s as the mission's Schedule, or removes it when s
+	// is nil, and sets its UpdatedAt, leaving the rest of the item alone. It
+	// returns errMissionNotFound when there is no mission id.
+	SetSchedule(ctx context.Context, id string, s *ScheduleAssignment) error
+	// SetStatus sets the mission's Status to status and its UpdatedAt,
+	// leaving the rest of the item alone, if allowed accepts the current
+	// status. It returns errMissionNotFound when there is no mission id and
+	// an error wrapping errMissionStatus when allowed refuses, or the status
+	// changed while it was being checked.
+	SetStatus(ctx context.Context, id, status string, allowed func(current string) bool) error
+}
+
+// ImageStore holds the ImageRecords that ingest steps derive.
+type ImageStore interface {
+	// ImageRecord returns nil if no record has been written for id.
+	ImageRecord(ctx context.Context, id string) (*ImageRecord, error)
+	// ImageRecords leaves images without a record out of the result.
+	ImageRecords(ctx context.Context, ids []string) (map[string]*ImageRecord, error)
+	// ListImageRecords returns up to limit records after the opaque token,
+	// and the token for the next page, which is empty after the last one.
+	// A token this store did not issue yields errInvalidImageToken.
+	ListImageRecords(ctx context.Context, limit int32, token string) ([]ImageRecord, string, error)
+	// SetImageAttribute stores value under name on the image's record,
+	// creating the record if needed and leaving its other attributes alone.
+	SetImageAttribute(ctx context.Context, id, name string, value any) error
+}
+
+// initMetadataStore returns the mission and image stores named by
+// METADATA_BACKEND: dynamodb (the default), postgres, or sqlite for local
+// development. Jobs stay in DynamoDB or in memory whichever is chosen.
+func initMetadataStore(cfg *Config, db *dynamodb.Client) (MissionStore, ImageStore) {
+	if cfg.MetadataBackend == "dynamodb" {
+		store := &dynamoStore{db: db, missionTable: cfg.MissionTable, imageTable: cfg.ImageTable}
+		return store, store
+	}
+	store, err := openSQLStore(context.Background(), cfg.MetadataBackend, cfg.DatabaseURL, cfg.MissionTable, cfg.ImageTable)
+	if err != nil {
+		fatal("unable to open metadata store", "backend", cfg.MetadataBackend, "err", err)
+	}
+	slog.Info("reading missions and images", "backend", cfg.MetadataBackend)
+	return store, store
+}
+
+// dynamoStore keeps missions in MISSION_TABLE and image records in
+// IMAGE_TABLE, each keyed by the string attribute id.
+type dynamoStore struct {
+	db           *dynamodb.Client
+	missionTable string
+	imageTable   string
+}
+
+func (s *dynamoStore) Mission(ctx context.Context, id string) (*Mission, error) {
+	out, err := s.db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.missionTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, errMissionNotFound
+	}
+	var mission Mission
+	if err := attributevalue.UnmarshalMap(out.Item, &mission); err != nil {
+		return nil, err
+	}
+	return &mission, nil
+}
+
+// Missions pages through a Scan. The token is the LastEvaluatedKey as JSON
+// of {"name": {"S" or "N": value}}, base64 encoded.
+func (s *dynamoStore) Missions(ctx context.Context, limit int32, token string) ([]Mission, string, error) {
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(s.missionTable),
+		Limit:     aws.Int32(limit),
+	}
+	if token != "" {
+		startKey, err := decodeScanToken(token)
+		if err != nil {
+			return nil, "", errInvalidMissionToken
+		}
+		scanInput.ExclusiveStartKey = startKey
+	}
+
+	output, err := s.db.Scan(ctx, scanInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("scan: %w", err)
+	}
+
+	var missions []Mission
+	if err := attributevalue.UnmarshalListOfMaps(output.Items, &missions); err != nil {
+		return nil, "", fmt.Errorf("unmarshal missions: %w", err)
+	}
+	next, err := encodeScanToken(output.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return missions, next, nil
+}
+
+// decodeScanToken is the inverse of encodeScanToken.
+func decodeScanToken(token string) (map[string]types.AttributeValue, error) {
+	decodedToken, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var tempKey map[string]map[string]string
+	if err := json.Unmarshal(decodedToken, &tempKey); err != nil {
+		return nil, err
+	}
+
+	exclusiveStartKey := make(map[string]types.AttributeValue)
+	for key, valMap := range tempKey {
+		for typeIdentifier, value := range valMap {
+			switch typeIdentifier {
+			case "S":
+				exclusiveStartKey[key] = &types.AttributeValueMemberS{Value: value}
+			case "N":
+				exclusiveStartKey[key] = &types.AttributeValueMemberN{Value: value}
+			}
+		}
+	}
+	return exclusiveStartKey, nil
+}
+
+// encodeScanToken is a Scan's LastEvaluatedKey as a page token, or "" after
+// the last page.
+func encodeScanToken(lastKey map[string]types.AttributeValue) (string, error) {
+	if len(lastKey) == 0 {
+		return "", nil
+	}
+	serializableKey := make(map[string]interface{})
+	for key, val := range lastKey {
+		switch v := val.(type) {
+		case *types.AttributeValueMemberS:
+			serializableKey[key] = map[string]string{"S": v.Value}
+		case *types.AttributeValueMemberN:
+			serializableKey[key] = map[string]string{"N": v.Value}
+		}
+	}
+	jsonKey, err := json.Marshal(serializableKey)
+	if err != nil {
+		return "", fmt.Errorf("marshal LastEvaluatedKey: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(jsonKey), nil
+}
+
+// LinkImage appends in one conditional update, so it never adds the image
+// twice or creates a mission.
+func (s *dynamoStore) LinkImage(ctx context.Context, missionID, imageID string) (bool, error) {
+	_, err := s.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.missionTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: missionID},
+		},
+		UpdateExpression:    aws.String("SET image_ids = list_append(if_not_exists(image_ids, :empty), :ids), updated_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(id) AND NOT contains(image_ids, :id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberL{},
+			":ids":   &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: imageID}}},
+			":id":    &types.AttributeValueMemberS{Value: imageID},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		// Either the mission is missing or it already lists the image.
+		if _, err := s.Mission(ctx, missionID); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UnlinkImage removes the image by its index in one update, conditional on
+// the index still holding it, and reads the mission again if another write
+// moved it.
+func (s *dynamoStore) UnlinkImage(ctx context.Context, missionID, imageID string) (bool, error) {
+	for range 3 {
+		mission, err := s.Mission(ctx, missionID)
+		if err != nil {
+			return false, err
+		}
+		i := slices.Index(mission.ImageIDs, imageID)
+		if i < 0 {
+			return false, nil
+		}
+		_, err = s.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(s.missionTable),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: missionID},
+			},
+			UpdateExpression:    aws.String(fmt.Sprintf("REMOVE image_ids[%d] SET updated_at = :now", i)),
+			ConditionExpression: aws.String(fmt.Sprintf("image_ids[%d] = :id", i)),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id":  &types.AttributeValueMemberS{Value: imageID},
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+			},
+		})
+		var failed *types.ConditionalCheckFailedException
+		if !errors.As(err, &failed) {
+			return err == nil, err
+		}
+	}
+	return false, fmt.Errorf("unlink %s from mission %s: the mission kept changing", imageID, missionID)
+}
+
+// SetGeometry updates the mission in one conditional update, so it never
+// creates one.
+func (s *dynamoStore) SetGeometry(ctx context.Context, id string, g MissionGeometry) error {
+	num := func(v string) types.AttributeValue { return &types.AttributeValueMemberN{Value: v} }
+	update := "SET tca = :tca, min_range_km = :range, relative_velocity_kms = :velocity, geometry_updated_at = :now, updated_at = :now"
+	values := map[string]types.AttributeValue{
+		":tca":      num(strconv.FormatInt(g.TCA, 10)),
+		":range":    num(strconv.FormatFloat(g.MinRangeKM, 'f', -1, 64)),
+		":velocity": num(strconv.FormatFloat(g.RelativeVelocityKMS, 'f', -1, 64)),
+		":now":      num(strconv.FormatInt(time.Now().Unix(), 10)),
+	}
+	if g.Feasibility != nil {
+		f, err := attributevalue.Marshal(g.Feasibility)
+		if err != nil {
+			return err
+		}
+		update += ", feasibility = :feasibility"
+		values[":feasibility"] = f
+	}
+	_, err := s.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.missionTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: values,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return errMissionNotFound
+	}
+	return err
+}
+
+// SetSchedule updates the mission in one conditional update, so it never
+// creates one.
+func (s *dynamoStore) SetSchedule(ctx context.Context, id string, schedule *ScheduleAssignment) error {
+	update := "SET updated_at = :now REMOVE schedule"
+	values := map[string]types.AttributeValue{
+		":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	if schedule != nil {
+		v, err := attributevalue.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+		update = "SET updated_at = :now, schedule = :schedule"
+		values[":schedule"] = v
+	}
+	_, err := s.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.missionTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: values,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return errMissionNotFound
+	}
+	return err
+}
+
+// SetStatus checks the status it read and updates the mission on condition
+// that it still holds it, so two changes racing cannot both apply.
+func (s *dynamoStore) SetStatus(ctx context.Context, id, status string, allowed func(current string) bool) error {
+	mission, err := s.Mission(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !allowed(mission.Status) {
+		return fmt.Errorf("%w: the mission is %q", errMissionStatus, mission.Status)
+	}
+	condition := "#status = :current"
+	if mission.Status == "" {
+		// A mission written without a status has no attribute to compare.
+		condition = "attribute_exists(id) AND (attribute_not_exists(#status) OR #status = :current)"
+	}
+	_, err = s.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.missionTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:         aws.String("SET #status = :status, updated_at = :now"),
+		ConditionExpression:      aws.String(condition),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":  &types.AttributeValueMemberS{Value: status},
+			":current": &types.AttributeValueMemberS{Value: mission.Status},
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return fmt.Errorf("%w: the mission changed while it was being updated", errMissionStatus)
+	}
+	return err
+}
+
+func (s *dynamoStore) ImageRecord(ctx context.Context, id string) (*ImageRecord, error) {
+	tableName := s.imageTable
+	if tableName == "" {
+		return nil, errImageTableUnset
+	}
+
+	out, err := s.db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var record ImageRecord
+	if err := attributevalue.UnmarshalMap(out.Item, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ImageRecords fetches the records in BatchGetItem batches.
+func (s *dynamoStore) ImageRecords(ctx context.Context, ids []string) (map[string]*ImageRecord, error) {
+	tableName := s.imageTable
+	if tableName == "" {
+		return nil, errImageTableUnset
+	}
+
+	records := make(map[string]*ImageRecord, len(ids))
+	for start := 0; start < len(ids); start += batchGetLimit {
+		chunk := ids[start:min(start+batchGetLimit, len(ids))]
+		keys := make([]map[string]types.AttributeValue, 0, len(chunk))
+		seen := make(map[string]bool, len(chunk))
+		for _, id := range chunk {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			keys = append(keys, map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			})
+		}
+
+		request := map[string]types.KeysAndAttributes{tableName: {Keys: keys}}
+		for len(request) > 0 {
+			out, err := s.db.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, err
+			}
+			var batch []ImageRecord
+			if err := attributevalue.UnmarshalListOfMaps(out.Responses[tableName], &batch); err != nil {
+				return nil, err
+			}
+			for i := range batch {
+				records[batch[i].ID] = &batch[i]
+			}
+			request = out.UnprocessedKeys
+		}
+	}
+	return records, nil
+}
+
+// ListImageRecords pages through a Scan, with tokens as Missions has.
+func (s *dynamoStore) ListImageRecords(ctx context.Context, limit int32, token string) ([]ImageRecord, string, error) {
+	tableName := s.imageTable
+	if tableName == "" {
+		return nil, "", errImageTableUnset
+	}
+
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+		Limit:     aws.Int32(limit),
+	}
+	if token != "" {
+		startKey, err := decodeScanToken(token)
+		if err != nil {
+			return nil, "", errInvalidImageToken
+		}
+		scanInput.ExclusiveStartKey = startKey
+	}
+	out, err := s.db.Scan(ctx, scanInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("scan: %w", err)
+	}
+	var records []ImageRecord
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &records); err != nil {
+		return nil, "", fmt.Errorf("unmarshal image records: %w", err)
+	}
+	next, err := encodeScanToken(out.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return records, next, nil
+}
+
+func (s *dynamoStore) SetImageAttribute(ctx context.Context, id, name string, value any) error {
+	tableName := s.imageTable
+	if tableName == "" {
+		return errImageTableUnset
+	}
+
+	av, err := attributevalue.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", name, err)
+	}
+	_, err = s.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:         aws.String("SET #attr = :value, #updated = :updated"),
+		ExpressionAttributeNames: map[string]string{"#attr": name, "#updated": "updated"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":value":   av,
+			":updated": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+	return err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are registered with the default Prometheus registry, which also
+// carries the Go runtime and process collectors, and served on /metrics.
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sat_http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"route", "method"})
+	httpBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_http_response_bytes_total",
+		Help: "Response body bytes sent by route, after compression.",
+	}, []string{"route"})
+
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_grpc_requests_total",
+		Help: "gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sat_grpc_request_duration_seconds",
+		Help:    "gRPC call latency by method, streaming included.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"method"})
+
+	eventSubscribers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sat_event_subscribers",
+		Help: "Clients currently connected to an event stream, by transport.",
+	}, []string{"transport"})
+	missionStreamRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_mission_stream_records_total",
+		Help: "Mission table stream records applied, by event name (INSERT, MODIFY, REMOVE).",
+	}, []string{"event"})
+	missionStreamAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sat_mission_stream_lag_seconds",
+		Help: "Age of the last mission table stream record applied when it was read.",
+	})
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by result (succeeded, failed, retried).",
+	}, []string{"result"})
+	ingestedImages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_ingested_images_total",
+		Help: "Uploaded images ingested from S3 events, by result (ingested, rejected, duplicate).",
+	}, []string{"result"})
+	lifecycleObjects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_lifecycle_objects_total",
+		Help: "Objects acted on by lifecycle rules, by action (standard_ia, glacier, deep_archive, glacier_ir, expired, restored).",
+	}, []string{"action"})
+	objectDetections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_object_detections_total",
+		Help: "Object detection runs on ingested images, by result (detected, failed).",
+	}, []string{"result"})
+	detectorDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sat_detector_duration_seconds",
+		Help:    "Object detection model latency, from request to answer.",
+		Buckets: prometheus.DefBuckets,
+	})
+	eventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_events_published_total",
+		Help: "Events published to EVENTS_ARN by target (sns, eventbridge) and result (published, failed).",
+	}, []string{"target", "result"})
+
+	awsDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sat_aws_call_duration_seconds",
+		Help:    "AWS SDK call latency, retries included, by service and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+	awsErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_aws_call_errors_total",
+		Help: "Failed AWS SDK calls by service, operation and error code.",
+	}, []string{"service", "operation", "code"})
+
+	processDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sat_image_processing_seconds",
+		Help:    "Time image work held processing capacity: decode, transform and encode.",
+		Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	})
+	processInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sat_image_processing_in_flight",
+		Help: "Image operations currently holding processing capacity.",
+	})
+	processRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sat_image_processing_rejected_total",
+		Help: "Image operations refused with 503 because capacity stayed exhausted.",
+	})
+	auditFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sat_audit_write_failures_total",
+		Help: "Audit entries that could not be written to AUDIT_TABLE.",
+	})
+	usageFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sat_usage_write_failures_total",
+		Help: "Usage rollups that could not be added to USAGE_TABLE, and were kept for the next try.",
+	})
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_rate_limited_requests_total",
+		Help: "Requests refused with 429 by route class (json, image).",
+	}, []string{"class"})
+
+	tleFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_tle_fetched_total",
+		Help: "Satellites looked up at TLE_SOURCE by result (updated, unchanged, missing, failed).",
+	}, []string{"result"})
+
+	cdmReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_cdm_received_total",
+		Help: "Conjunction Data Messages stored, by whether either object is in the satellite catalog (true, false).",
+	}, []string{"linked"})
+
+	downlinksRecorded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sat_downlinks_recorded_total",
+		Help: "Downlinks recorded with POST /mission/:id/downlinks.",
+	})
+	downlinksOverdue = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sat_downlinks_overdue",
+		Help: "Missions past their collection window and DOWNLINK_GRACE with nothing downlinked, at the last check.",
+	})
+	integrityFindings = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sat_integrity_findings",
+		Help: "Inconsistencies found by the last integrity check, by kind (missing_object, unrecorded_object, unlinked_record).",
+	}, []string{"kind"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sat_cache_lookups_total",
+		Help: "Cache lookups by cache (memory, derived, mission) and result (hit, miss).",
+	}, []string{"cache", "result"})
+)
+
+// recordCacheLookup counts one lookup in the named cache.
+func recordCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// recordRequests counts every request under its route pattern, such as
+// /image/:id, so ids do not become label values. Unrouted requests are
+// counted under "unmatched".
+func recordRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+		if n := c.Writer.Size(); n > 0 {
+			httpBytes.WithLabelValues(route).Add(float64(n))
+		}
+	}
+}
+
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// withAWSMetrics times every call made by clients built from the config. It
+// sits after the step that records the service and operation names, and
+// before retries, so a call's duration includes its retries.
+func withAWSMetrics() func(*config.LoadOptions) error {
+	return config.WithAPIOptions([]func(*middleware.Stack) error{
+		func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SatMetrics",
+				func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					start := time.Now()
+					out, md, err := next.HandleInitialize(ctx, in)
+					service, op := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+					awsDuration.WithLabelValues(service, op).Observe(time.Since(start).Seconds())
+					if err != nil {
+						code := "unknown"
+						var apiErr smithy.APIError
+						if errors.As(err, &apiErr) {
+							code = apiErr.ErrorCode()
+						} else if ctx.Err() != nil {
+							code = "canceled"
+						}
+						awsErrors.WithLabelValues(service, op, code).Inc()
+					}
+					return out, md, err
+				}), middleware.After)
+		},
+	})
+}
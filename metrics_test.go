@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(recordRequests())
+	router.GET("/image/:id", func(c *gin.Context) { c.String(http.StatusOK, "12345") })
+	router.GET("/metrics", metricsHandler())
+
+	ok := httpRequests.WithLabelValues("/image/:id", "GET", "200")
+	unmatched := httpRequests.WithLabelValues("unmatched", "GET", "404")
+	bytes := httpBytes.WithLabelValues("/image/:id")
+	before, beforeUnmatched, beforeBytes := testutil.ToFloat64(ok), testutil.ToFloat64(unmatched), testutil.ToFloat64(bytes)
+
+	for _, path := range []string{"/image/a", "/image/b", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := testutil.ToFloat64(ok) - before; got != 2 {
+		t.Errorf("counted %v requests to /image/:id, want 2", got)
+	}
+	if got := testutil.ToFloat64(unmatched) - beforeUnmatched; got != 1 {
+		t.Errorf("counted %v unmatched requests, want 1", got)
+	}
+	if got := testutil.ToFloat64(bytes) - beforeBytes; got != 10 {
+		t.Errorf("counted %v bytes, want 10", got)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `sat_http_requests_total{code="200",method="GET",route="/image/:id"}`) {
+		t.Errorf("/metrics does not list the request counter:\n%s", w.Body)
+	}
+}
+
+func TestRecordCacheLookup(t *testing.T) {
+	m := newMemoryCache(1<<20, defaultMemoryCacheTTL)
+	hits, misses := cacheLookups.WithLabelValues("memory", "hit"), cacheLookups.WithLabelValues("memory", "miss")
+	beforeHits, beforeMisses := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+
+	m.Add("present", cachedObject{Data: []byte("x")})
+	m.Get("present")
+	m.Get("absent")
+	m.Get("")
+	if got := testutil.ToFloat64(misses) - beforeMisses; got != 1 {
+		t.Errorf("counted %v misses, want 1; an empty key is not a lookup", got)
+	}
+	if got := testutil.ToFloat64(hits) - beforeHits; got != 1 {
+		t.Errorf("counted %v hits, want 1", got)
+	}
+}
+
+func TestAWSMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchBucket</Code><Message>none</Message></Error>`))
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), withAWSMetrics(),
+		config.WithRegion("us-east-1"), config.WithCredentialsProvider(aws.AnonymousCredentials{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(srv.URL)
+		o.UsePathStyle = true
+	})
+	errs := awsErrors.WithLabelValues("S3", "ListObjectsV2", "NoSuchBucket")
+	before := testutil.ToFloat64(errs)
+	if _, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("b")}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := testutil.ToFloat64(errs) - before; got != 1 {
+		t.Errorf("counted %v errors, want 1", got)
+	}
+	if n := testutil.CollectAndCount(awsDuration, "sat_aws_call_duration_seconds"); n == 0 {
+		t.Error("no call durations recorded")
+	}
+}
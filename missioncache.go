@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultMissionCacheTTL = 30 * time.Second
+	missionKeyPrefix       = "sat:mission:"
+	// Scan pages are keyed under a generation that every invalidation
+	// replaces, which drops all cached pages at once.
+	missionListGenKey = "sat:missions:gen"
+)
+
+// MissionCache holds mission GetItem results and Scan pages for
+// MISSION_CACHE_TTL (default 30s, 0 disables it). Writers to MISSION_TABLE
+// call POST /mission/:id/invalidate, or delete the keys themselves when they
+// share the Redis, so that changes show up before the TTL runs out. A nil
+// *MissionCache caches nothing.
+type MissionCache struct {
+	cache Cache
+	ttl   time.Duration
+}
+
+// newMissionCache returns a cache holding missions in cache for ttl, or nil
+// when ttl is 0.
+func newMissionCache(cache Cache, ttl time.Duration) *MissionCache {
+	if ttl == 0 {
+		return nil
+	}
+	return &MissionCache{cache: cache, ttl: ttl}
+}
+
+func (m *MissionCache) load(ctx context.Context, key string, v any) bool {
+	if m == nil {
+		return false
+	}
+	data, ok := m.cache.Get(ctx, key)
+	hit := ok && json.Unmarshal(data, v) == nil
+	recordCacheLookup("mission", hit)
+	return hit
+}
+
+func (m *MissionCache) store(ctx context.Context, key string, v any) {
+	if m == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	m.cache.Set(ctx, key, data, m.ttl)
+}
+
+// missionKey names the cached mission id of ctx's tenant. Its table key is
+// used, so the mission stream, which sees only table keys, drops the same
+// entry.
+func missionKey(ctx context.Context, id string) string {
+	return missionKeyPrefix + tenantRecordID(ctx, id)
+}
+
+// Mission returns the cached mission for id.
+func (m *MissionCache) Mission(ctx context.Context, id string) (*Mission, bool) {
+	var mission Mission
+	if !m.load(ctx, missionKey(ctx, id), &mission) {
+		return nil, false
+	}
+	return &mission, true
+}
+
+func (m *MissionCache) StoreMission(ctx context.Context, mission *Mission) {
+	m.store(ctx, missionKey(ctx, mission.ID), mission)
+}
+
+// listKey names the Scan page of limit items of ctx's tenant starting at
+// token, under the current generation.
+func (m *MissionCache) listKey(ctx context.Context, limit int32, token string) string {
+	gen := "0"
+	if data, ok := m.cache.Get(ctx, missionListGenKey); ok {
+		gen = string(data)
+	}
+	key := "sat:missions:" + gen + ":"
+	if tenant := tenantFrom(ctx); tenant != "" {
+		key += tenant + ":"
+	}
+	return key + strconv.Itoa(int(limit)) + ":" + token
+}
+
+// Page returns the cached response for a GET /missions page.
+func (m *MissionCache) Page(ctx context.Context, limit int32, token string) (*PaginatedMissionsResponse, bool) {
+	if m == nil {
+		return nil, false
+	}
+	var page PaginatedMissionsResponse
+	if !m.load(ctx, m.listKey(ctx, limit, token), &page) {
+		return nil, false
+	}
+	return &page, true
+}
+
+func (m *MissionCache) StorePage(ctx context.Context, limit int32, token string, page *PaginatedMissionsResponse) {
+	if m == nil {
+		return
+	}
+	m.store(ctx, m.listKey(ctx, limit, token), page)
+}
+
+// Invalidate drops the cached missions for ids and every cached list page.
+func (m *MissionCache) Invalidate(ctx context.Context, ids ...string) {
+	if m == nil {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = missionKey(ctx, id)
+	}
+	m.cache.Delete(ctx, keys...)
+	m.cache.Set(ctx, missionListGenKey, []byte(strconv.FormatInt(time.Now().UnixNano(), 36)), 0)
+}
+
+// Flush drops every cached mission and list page of every tenant, and
+// returns how many entries there were.
+func (m *MissionCache) Flush(ctx context.Context) (int, error) {
+	if m == nil {
+		return 0, nil
+	}
+	// The prefix covers missionKeyPrefix, the pages under sat:missions:
+	// and their generation.
+	return m.cache.DeletePrefix(ctx, "sat:mission")
+}
+
+// postMissionInvalidate is called by whatever writes MISSION_TABLE after it
+// changes a mission. The change is also published to event subscribers,
+// unless the table's stream is read, which publishes it instead.
+func (api *API) postMissionInvalidate(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+	api.missionChanged(c.Request.Context(), id)
+	c.Status(http.StatusNoContent)
+}
+
+// missionChanged drops the mission id from the cache and, unless the mission
+// stream publishes changes, publishes this one.
+func (api *API) missionChanged(ctx context.Context, id string) {
+	before, _ := api.Missions.Mission(ctx, id)
+	api.Missions.Invalidate(ctx, id)
+	if api.Stream == nil {
+		api.publishMissionChange(ctx, id, before)
+	}
+}
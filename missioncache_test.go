@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMissionCache(t *testing.T) {
+	ctx := context.Background()
+	m := &MissionCache{cache: newMemoryKV(), ttl: time.Minute}
+
+	if _, ok := m.Mission(ctx, "m1"); ok {
+		t.Fatal("hit on an empty cache")
+	}
+	m.StoreMission(ctx, &Mission{ID: "m1"})
+	m.StoreMission(ctx, &Mission{ID: "m2"})
+	if got, ok := m.Mission(ctx, "m1"); !ok || got.ID != "m1" {
+		t.Fatalf("Mission(m1) = %+v, %v", got, ok)
+	}
+
+	token := "abc"
+	m.StorePage(ctx, 10, token, &PaginatedMissionsResponse{Missions: []Mission{{ID: "m1"}}, NextToken: &token})
+	if page, ok := m.Page(ctx, 10, token); !ok || len(page.Missions) != 1 || *page.NextToken != token {
+		t.Fatalf("Page = %+v, %v", page, ok)
+	}
+	if _, ok := m.Page(ctx, 20, token); ok {
+		t.Error("page cached under one limit served for another")
+	}
+
+	m.Invalidate(ctx, "m1")
+	if _, ok := m.Mission(ctx, "m1"); ok {
+		t.Error("invalidated mission still cached")
+	}
+	if _, ok := m.Mission(ctx, "m2"); !ok {
+		t.Error("invalidating m1 dropped m2")
+	}
+	if _, ok := m.Page(ctx, 10, token); ok {
+		t.Error("list page survived an invalidation")
+	}
+}
+
+func TestMissionCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	m := &MissionCache{cache: newMemoryKV(), ttl: time.Millisecond}
+	m.StoreMission(ctx, &Mission{ID: "m1"})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := m.Mission(ctx, "m1"); ok {
+		t.Error("mission served after its TTL")
+	}
+}
+
+func TestNilMissionCache(t *testing.T) {
+	m := newMissionCache(newMemoryKV(), 0)
+	if m != nil {
+		t.Fatalf("TTL 0 gave %+v, want nil", m)
+	}
+	ctx := context.Background()
+	m.StoreMission(ctx, &Mission{ID: "m1"})
+	m.StorePage(ctx, 10, "", &PaginatedMissionsResponse{})
+	m.Invalidate(ctx, "m1")
+	if _, ok := m.Mission(ctx, "m1"); ok {
+		t.Error("nil cache reported a hit")
+	}
+	if _, ok := m.Page(ctx, 10, ""); ok {
+		t.Error("nil cache reported a page")
+	}
+}
+
+func TestPostMissionInvalidate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name, token, auth string
+		want              int
+	}{
+		{name: "no token configured", auth: "Bearer secret", want: http.StatusForbidden},
+		{name: "missing header", token: "secret", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", auth: "Bearer guess", want: http.StatusUnauthorized},
+		{name: "wrong scheme", token: "secret", auth: "Basic secret", want: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", auth: "Bearer secret", want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			api := &API{Missions: &MissionCache{cache: newMemoryKV(), ttl: time.Minute}}
+			api.Missions.StoreMission(ctx, &Mission{ID: "m1"})
+
+			router := gin.New()
+			router.POST("/mission/:id/invalidate", requireScope(&Config{AdminToken: tt.token}, nil, ScopeMissionsWrite, false), api.postMissionInvalidate)
+			req := httptest.NewRequest(http.MethodPost, "/mission/m1/invalidate", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			_, cached := api.Missions.Mission(ctx, "m1")
+			if cached != (tt.want != http.StatusNoContent) {
+				t.Errorf("mission cached = %v after status %d", cached, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Statuses the server moves missions to. The system that writes missions
+// sets the others, in whatever case it likes, so they are compared
+// case-insensitively.
+const (
+	MissionApproved  = "approved"
+	MissionCancelled = "cancelled"
+)
+
+var (
+	// approvableStatuses are those a mission may be approved from.
+	approvableStatuses = []string{"proposed", "pending"}
+	// finalStatuses are those a mission may not be cancelled from.
+	finalStatuses = []string{"completed", "complete", "failed", "cancelled", "canceled"}
+)
+
+func hasStatus(statuses []string, status string) bool {
+	return slices.ContainsFunc(statuses, func(s string) bool { return strings.EqualFold(s, status) })
+}
+
+// postMissionApprove approves a proposed or pending mission. It needs
+// missions:approve, which operators do not hold.
+func (api *API) postMissionApprove(c *gin.Context) {
+	api.setMissionStatus(c, MissionApproved, func(current string) bool {
+		return hasStatus(approvableStatuses, current)
+	})
+}
+
+// postMissionCancel cancels a mission that has not finished.
+func (api *API) postMissionCancel(c *gin.Context) {
+	api.setMissionStatus(c, MissionCancelled, func(current string) bool {
+		return !hasStatus(finalStatuses, current)
+	})
+}
+
+// setMissionStatus moves the mission to status if allowed accepts its
+// current one, and answers with the updated mission.
+func (api *API) setMissionStatus(c *gin.Context, status string, allowed func(current string) bool) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if err := api.MissionDB.SetStatus(ctx, id, status, allowed); err != nil {
+		switch {
+		case errors.Is(err, errMissionNotFound):
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+		case errors.Is(err, errMissionStatus):
+			respondError(c, http.StatusConflict, CodeMissionStatusConflict, err.Error())
+		default:
+			slog.ErrorContext(ctx, "failed to set mission status", "id", id, "status", status, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to update mission")
+		}
+		return
+	}
+	api.missionChanged(ctx, id)
+	slog.InfoContext(ctx, "mission status changed", "id", id, "status", status)
+
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to reload mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, mission)
+}
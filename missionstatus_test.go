@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMissionStatusRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	db := testSQLStore(t)
+	for _, m := range []Mission{
+		{ID: "proposed", Status: "Proposed"},
+		{ID: "pending", Status: "pending"},
+		{ID: "active", Status: "active"},
+		{ID: "done", Status: "completed"},
+	} {
+		if err := putMission(ctx, db, &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{
+		Config:    &Config{AdminToken: "secret"},
+		MissionDB: db,
+		Keys:      newAPIKeyStore(nil, ""),
+		Roles:     newRoleStore(nil, ""),
+		Events:    newEventBus(),
+	}
+	tokens := map[string]string{"": ""}
+	for _, role := range []string{RoleViewer, RoleOperator, RoleApprover} {
+		key, err := api.Keys.Create(ctx, APIKey{Scopes: []string{ScopeMissionsRead}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.Roles.Put(ctx, RoleAssignment{Subject: key.ID, Roles: []string{role}}); err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = key.Key
+	}
+	router := gin.New()
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	do := func(path, role string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if tokens[role] != "" {
+			req.Header.Set("Authorization", "Bearer "+tokens[role])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		path, role string
+		want       int
+		status     string
+	}{
+		{"/mission/proposed/approve", "", http.StatusUnauthorized, ""},
+		{"/mission/proposed/approve", RoleViewer, http.StatusForbidden, ""},
+		{"/mission/proposed/approve", RoleOperator, http.StatusForbidden, ""},
+		{"/mission/proposed/cancel", RoleViewer, http.StatusForbidden, ""},
+		{"/mission/proposed/cancel", RoleApprover, http.StatusForbidden, ""},
+		{"/mission/proposed/approve", RoleApprover, http.StatusOK, MissionApproved},
+		{"/mission/proposed/approve", RoleApprover, http.StatusConflict, ""},
+		{"/mission/active/approve", RoleApprover, http.StatusConflict, ""},
+		{"/mission/none/approve", RoleApprover, http.StatusNotFound, ""},
+		{"/mission/pending/cancel", RoleOperator, http.StatusOK, MissionCancelled},
+		{"/mission/active/cancel", RoleOperator, http.StatusOK, MissionCancelled},
+		{"/mission/done/cancel", RoleOperator, http.StatusConflict, ""},
+		{"/mission/pending/approve", RoleApprover, http.StatusConflict, ""},
+	}
+	for _, tt := range tests {
+		w := do(tt.path, tt.role)
+		if w.Code != tt.want {
+			t.Errorf("POST %s as %q: status = %d, want %d: %s", tt.path, tt.role, w.Code, tt.want, w.Body)
+			continue
+		}
+		var m Mission
+		if tt.status != "" && (json.Unmarshal(w.Body.Bytes(), &m) != nil || m.Status != tt.status || m.UpdatedAt == 0) {
+			t.Errorf("POST %s: mission %s", tt.path, w.Body)
+		}
+	}
+	if m, err := db.Mission(ctx, "done"); err != nil || m.Status != "completed" {
+		t.Errorf("refused cancel changed the mission: %+v, %v", m, err)
+	}
+}
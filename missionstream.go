@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// An empty shard is polled every streamPoll, within the stream's limit
+	// of five reads per second per shard across every reader.
+	streamPoll = time.Second
+	// The stream is described every streamShardRefresh to find the shards
+	// that replace closed ones.
+	streamShardRefresh = 30 * time.Second
+	streamRetry        = 5 * time.Second
+	streamIndexPage    = 100
+)
+
+// streamsAPI is the part of the DynamoDB Streams client MissionStream uses.
+type streamsAPI interface {
+	DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// MissionStream reads the DynamoDB stream of MISSION_TABLE, named by
+// MISSION_STREAM_ARN. Every change to a mission drops it from the mission
+// cache, is published on the event bus, and is applied to the mission index,
+// so events no longer depend on writers calling POST /mission/:id/invalidate.
+// Each instance reads the whole stream, starting from its tip when it
+// starts. A nil *MissionStream reads nothing and has no index.
+type MissionStream struct {
+	api    *API
+	client streamsAPI
+	arn    string
+	poll   time.Duration
+	index  *MissionIndex
+
+	mu sync.Mutex
+	// shards holds the shards being or done being read, true once done.
+	shards map[string]bool
+}
+
+func newMissionStream(api *API, client streamsAPI, arn string) *MissionStream {
+	return &MissionStream{
+		api:    api,
+		client: client,
+		arn:    arn,
+		poll:   streamPoll,
+		index:  newMissionIndex(),
+		shards: map[string]bool{},
+	}
+}
+
+// Start reads the stream until ctx is done. Once the open shards are being
+// read, the index is loaded from the table, so no change made in between is
+// missed.
+func (s *MissionStream) Start(ctx context.Context) {
+	go func() {
+		first := true
+		for {
+			if err := s.startShards(ctx, first); err != nil {
+				slog.ErrorContext(ctx, "failed to describe mission stream", "arn", s.arn, "err", err)
+			} else if first {
+				first = false
+				go s.loadIndex(ctx)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(streamShardRefresh):
+			}
+		}
+	}()
+}
+
+func (s *MissionStream) loadIndex(ctx context.Context) {
+	for {
+		err := s.index.load(ctx, s.api.MissionDB)
+		if err == nil {
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission index", "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(streamRetry):
+		}
+	}
+}
+
+// reindex empties the index and loads it again from the table, keeping the
+// changes the stream applies meanwhile.
+func (s *MissionStream) reindex(ctx context.Context) {
+	s.index.mu.Lock()
+	s.index.missions = map[string]Mission{}
+	s.index.ready = false
+	s.index.mu.Unlock()
+	slog.InfoContext(ctx, "reloading mission index")
+	s.loadIndex(ctx)
+}
+
+// startShards starts a reader for every shard whose parent has been read.
+// On the first call the shards are read from their tip and closed ones are
+// skipped; shards found later are new, and are read from their start.
+func (s *MissionStream) startShards(ctx context.Context, first bool) error {
+	var shards []streamtypes.Shard
+	in := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(s.arn)}
+	for {
+		out, err := s.client.DescribeStream(ctx, in)
+		if err != nil {
+			return err
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if out.StreamDescription.LastEvaluatedShardId == nil {
+			break
+		}
+		in.ExclusiveStartShardId = out.StreamDescription.LastEvaluatedShardId
+	}
+
+	listed := map[string]bool{}
+	for _, sh := range shards {
+		listed[aws.ToString(sh.ShardId)] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sh := range shards {
+		id := aws.ToString(sh.ShardId)
+		if _, seen := s.shards[id]; seen {
+			continue
+		}
+		position := streamtypes.ShardIteratorTypeTrimHorizon
+		if first {
+			if sh.SequenceNumberRange != nil && sh.SequenceNumberRange.EndingSequenceNumber != nil {
+				s.shards[id] = true
+				continue
+			}
+			position = streamtypes.ShardIteratorTypeLatest
+		} else if parent := aws.ToString(sh.ParentShardId); parent != "" && listed[parent] && !s.shards[parent] {
+			// Records for a key must be read in order, so a child waits
+			// for its parent, unless the parent has been trimmed.
+			continue
+		}
+		iterator, err := s.iterator(ctx, id, position, "")
+		if err != nil {
+			return err
+		}
+		s.shards[id] = false
+		go s.readShard(ctx, id, iterator)
+	}
+	return nil
+}
+
+// iterator starts reading shard at position, or after the sequence number
+// after when it is set.
+func (s *MissionStream) iterator(ctx context.Context, shard string, position streamtypes.ShardIteratorType, after string) (*string, error) {
+	in := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(s.arn),
+		ShardId:           aws.String(shard),
+		ShardIteratorType: position,
+	}
+	if after != "" {
+		in.ShardIteratorType = streamtypes.ShardIteratorTypeAfterSequenceNumber
+		in.SequenceNumber = aws.String(after)
+	}
+	out, err := s.client.GetShardIterator(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out.ShardIterator, nil
+}
+
+// readShard applies the shard's records until it is closed and read to the
+// end. An iterator that expires is renewed after the last record read; one
+// pointing at trimmed records moves to the oldest record left.
+func (s *MissionStream) readShard(ctx context.Context, shard string, iterator *string) {
+	var last string
+	for iterator != nil {
+		out, err := s.client.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var expired *streamtypes.ExpiredIteratorException
+			var trimmed *streamtypes.TrimmedDataAccessException
+			var next *string
+			switch {
+			case errors.As(err, &expired):
+				next, err = s.iterator(ctx, shard, streamtypes.ShardIteratorTypeLatest, last)
+			case errors.As(err, &trimmed):
+				last = ""
+				next, err = s.iterator(ctx, shard, streamtypes.ShardIteratorTypeTrimHorizon, "")
+			}
+			if next != nil {
+				iterator = next
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to read mission stream", "shard", shard, "err", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(streamRetry):
+				}
+			}
+			continue
+		}
+		for _, rec := range out.Records {
+			s.apply(ctx, rec)
+			if rec.Dynamodb != nil {
+				last = aws.ToString(rec.Dynamodb.SequenceNumber)
+			}
+		}
+		iterator = out.NextShardIterator
+		if len(out.Records) == 0 && iterator != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.poll):
+			}
+		}
+	}
+	s.mu.Lock()
+	s.shards[shard] = true
+	s.mu.Unlock()
+}
+
+// apply handles one change to a mission. Streams that carry only keys, or
+// only old images, have the new image read from the table.
+func (s *MissionStream) apply(ctx context.Context, rec streamtypes.Record) {
+	if rec.Dynamodb == nil {
+		return
+	}
+	missionStreamRecords.WithLabelValues(string(rec.EventName)).Inc()
+	if t := rec.Dynamodb.ApproximateCreationDateTime; t != nil {
+		missionStreamAge.Set(time.Since(*t).Seconds())
+	}
+	var key struct {
+		ID string `dynamodbav:"id"`
+	}
+	if err := unmarshalStreamImage(rec.Dynamodb.Keys, &key); err != nil || key.ID == "" {
+		slog.WarnContext(ctx, "skipping mission stream record without an id", "event", rec.EventName, "err", err)
+		return
+	}
+	id := key.ID
+	before := streamMission(rec.Dynamodb.OldImage)
+	after := streamMission(rec.Dynamodb.NewImage)
+	s.api.Missions.Invalidate(ctx, id)
+
+	// The stream and the index see table keys; events name the mission as
+	// its tenant knows it.
+	publish := func(eventType string, before, after *Mission) {
+		ctx, id := ctx, id
+		if tenant, rest, ok := splitRecordID(id); ok && s.api.Config.MultiTenant {
+			ctx, id = withTenant(ctx, tenant), rest
+		}
+		s.api.publishMission(ctx, eventType, id, aws.ToString(rec.EventID), before.withID(id), after.withID(id))
+	}
+	eventType := EventMissionUpdated
+	switch rec.EventName {
+	case streamtypes.OperationTypeRemove:
+		s.index.remove(id)
+		publish(EventMissionDeleted, before, nil)
+		return
+	case streamtypes.OperationTypeInsert:
+		eventType = EventMissionCreated
+	}
+	if after == nil {
+		mission, err := s.api.MissionDB.Mission(ctx, id)
+		if errors.Is(err, errMissionNotFound) {
+			// Deleted again since; its REMOVE record follows.
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to load changed mission", "id", id, "err", err)
+			return
+		}
+		after = mission
+	}
+	s.index.put(after)
+	publish(eventType, before, after)
+}
+
+func unmarshalStreamImage(image map[string]streamtypes.AttributeValue, v any) error {
+	item, err := attributevalue.FromDynamoDBStreamsMap(image)
+	if err != nil {
+		return err
+	}
+	return attributevalue.UnmarshalMap(item, v)
+}
+
+// streamMission is the mission in a record's image, or nil when the stream
+// does not carry that image.
+func streamMission(image map[string]streamtypes.AttributeValue) *Mission {
+	if len(image) == 0 {
+		return nil
+	}
+	var m Mission
+	if err := unmarshalStreamImage(image, &m); err != nil || m.ID == "" {
+		return nil
+	}
+	return &m
+}
+
+// withID returns a copy of m with the ID id, or nil for a nil m.
+func (m *Mission) withID(id string) *Mission {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.ID = id
+	return &c
+}
+
+// Index is the stream's mission index, or nil without a stream.
+func (s *MissionStream) Index() *MissionIndex {
+	if s == nil {
+		return nil
+	}
+	return s.index
+}
+
+// MissionIndex is every mission, kept current by the stream, from which
+// aggregates are served without scanning MISSION_TABLE.
+type MissionIndex struct {
+	mu       sync.RWMutex
+	missions map[string]Mission
+	ready    bool
+	updated  int64
+}
+
+func newMissionIndex() *MissionIndex {
+	return &MissionIndex{missions: map[string]Mission{}}
+}
+
+// load adds every mission in store. Changes already applied from the stream
+// are newer than what a page may hold, so they are kept.
+func (x *MissionIndex) load(ctx context.Context, store MissionStore) error {
+	loaded := map[string]Mission{}
+	token := ""
+	for {
+		missions, next, err := store.Missions(ctx, streamIndexPage, token)
+		if err != nil {
+			return err
+		}
+		for _, m := range missions {
+			loaded[m.ID] = m
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for id, m := range loaded {
+		if _, ok := x.missions[id]; !ok {
+			x.missions[id] = m
+		}
+	}
+	maps.DeleteFunc(x.missions, func(_ string, m Mission) bool { return m.ID == "" })
+	x.ready = true
+	x.updated = time.Now().Unix()
+	return nil
+}
+
+func (x *MissionIndex) put(m *Mission) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.missions[m.ID] = *m
+	x.updated = time.Now().Unix()
+}
+
+// remove drops a mission. A removal applied before the index finished
+// loading is not undone by the load, since the mission is marked with an
+// empty entry until then.
+func (x *MissionIndex) remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.ready {
+		delete(x.missions, id)
+	} else {
+		x.missions[id] = Mission{}
+	}
+	x.updated = time.Now().Unix()
+}
+
+// each calls fn for every mission of tenant, under the read lock, with
+// the ID the tenant knows it by. An empty tenant is every mission.
+func (x *MissionIndex) each(tenant string, fn func(m *Mission)) {
+	for _, m := range x.missions {
+		if m.ID == "" {
+			continue
+		}
+		if tenant != "" {
+			t, id, ok := splitRecordID(m.ID)
+			if !ok || t != tenant {
+				continue
+			}
+			m.ID = id
+		}
+		fn(&m)
+	}
+}
+
+// MissionStats are the aggregates served by GET /missions/stats.
+type MissionStats struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+	// BySatellite counts the missions each satellite is the target or
+	// observer of.
+	BySatellite map[string]int `json:"by_satellite"`
+	// Updated is the Unix time of the last change applied.
+	Updated int64 `json:"updated"`
+}
+
+// Stats returns the aggregates of tenant's missions, or false while the
+// index is loading.
+func (x *MissionIndex) Stats(tenant string) (MissionStats, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	stats := MissionStats{ByStatus: map[string]int{}, BySatellite: map[string]int{}, Updated: x.updated}
+	if !x.ready {
+		return stats, false
+	}
+	x.each(tenant, func(m *Mission) {
+		stats.Total++
+		stats.ByStatus[m.Status]++
+		for _, sat := range missionSatellites(m) {
+			stats.BySatellite[sat]++
+		}
+	})
+	return stats, true
+}
+
+// Satellite returns tenant's missions that sat is the target or observer
+// of, by ID, or false while the index is loading.
+func (x *MissionIndex) Satellite(tenant, sat string) ([]Mission, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if !x.ready {
+		return nil, false
+	}
+	missions := []Mission{}
+	x.each(tenant, func(m *Mission) {
+		if slices.Contains(missionSatellites(m), sat) {
+			missions = append(missions, *m)
+		}
+	})
+	slices.SortFunc(missions, func(a, b Mission) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return missions, true
+}
+
+// satelliteImages returns the image IDs of tenant's missions of each
+// satellite in sats. A nil index has none.
+func (x *MissionIndex) satelliteImages(tenant string, sats map[string]bool) []string {
+	if x == nil || len(sats) == 0 {
+		return nil
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	images := map[string]bool{}
+	x.each(tenant, func(m *Mission) {
+		if sats[m.TargetSatelliteID] || sats[m.ObserverSatelliteID] {
+			for _, id := range m.ImageIDs {
+				images[id] = true
+			}
+		}
+	})
+	return slices.Collect(maps.Keys(images))
+}
+
+// missionSatellites are the distinct, non-empty satellite IDs of m.
+func missionSatellites(m *Mission) []string {
+	var sats []string
+	for _, sat := range []string{m.TargetSatelliteID, m.ObserverSatelliteID} {
+		if sat != "" && !slices.Contains(sats, sat) {
+			sats = append(sats, sat)
+		}
+	}
+	return sats
+}
+
+// SatelliteMissionsResponse is the body of GET /satellite/:id/missions.
+type SatelliteMissionsResponse struct {
+	SatelliteID string    `json:"satellite_id"`
+	Missions    []Mission `json:"missions"`
+}
+
+// missionIndex is the mission index, or responds with why there is none.
+func (api *API) missionIndex(c *gin.Context) *MissionIndex {
+	index := api.Stream.Index()
+	if index == nil {
+		respondError(c, http.StatusNotImplemented, CodeFeatureUnavailable, "the mission index needs MISSION_STREAM_ARN")
+	}
+	return index
+}
+
+// indexLoading answers a request made before the index has loaded.
+func indexLoading(c *gin.Context) {
+	c.Header("Retry-After", "5")
+	respondError(c, http.StatusServiceUnavailable, CodeFeatureUnavailable, "the mission index is still loading")
+}
+
+func (api *API) getMissionStats(c *gin.Context) {
+	index := api.missionIndex(c)
+	if index == nil {
+		return
+	}
+	stats, ok := index.Stats(tenantFrom(c.Request.Context()))
+	if !ok {
+		indexLoading(c)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, stats)
+}
+
+// getSatelliteMissions lists the missions that a satellite is the target or
+// observer of, in ID order.
+func (api *API) getSatelliteMissions(c *gin.Context) {
+	index := api.missionIndex(c)
+	if index == nil {
+		return
+	}
+	missions, ok := index.Satellite(tenantFrom(c.Request.Context()), c.Param("id"))
+	if !ok {
+		indexLoading(c)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, SatelliteMissionsResponse{SatelliteID: c.Param("id"), Missions: missions})
+}
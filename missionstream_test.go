@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeStream serves shards whose iterators are "<shard>/<batch>". A shard
+// left open returns empty batches once its records run out.
+type fakeStream struct {
+	mu        sync.Mutex
+	shards    []streamtypes.Shard
+	batches   map[string][][]streamtypes.Record
+	open      map[string]bool
+	positions map[string]streamtypes.ShardIteratorType
+}
+
+func (f *fakeStream) DescribeStream(context.Context, *dynamodbstreams.DescribeStreamInput, ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &streamtypes.StreamDescription{Shards: slices.Clone(f.shards)}}, nil
+}
+
+func (f *fakeStream) GetShardIterator(_ context.Context, in *dynamodbstreams.GetShardIteratorInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.positions[aws.ToString(in.ShardId)] = in.ShardIteratorType
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(aws.ToString(in.ShardId) + "/0")}, nil
+}
+
+func (f *fakeStream) GetRecords(_ context.Context, in *dynamodbstreams.GetRecordsInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shard, n, _ := strings.Cut(aws.ToString(in.ShardIterator), "/")
+	i, _ := strconv.Atoi(n)
+	out := &dynamodbstreams.GetRecordsOutput{}
+	if i < len(f.batches[shard]) {
+		out.Records = f.batches[shard][i]
+	}
+	if i < len(f.batches[shard]) || f.open[shard] {
+		out.NextShardIterator = aws.String(fmt.Sprintf("%s/%d", shard, i+1))
+	}
+	return out, nil
+}
+
+func streamString(s string) streamtypes.AttributeValue {
+	return &streamtypes.AttributeValueMemberS{Value: s}
+}
+
+// streamImage is m as a stream record image.
+func streamImage(m Mission) map[string]streamtypes.AttributeValue {
+	return map[string]streamtypes.AttributeValue{
+		"id":                    streamString(m.ID),
+		"status":                streamString(m.Status),
+		"target_satellite_id":   streamString(m.TargetSatelliteID),
+		"observer_satellite_id": streamString(m.ObserverSatelliteID),
+		"image_ids":             &streamtypes.AttributeValueMemberL{Value: []streamtypes.AttributeValue{streamString(m.ID + "-frame")}},
+	}
+}
+
+func streamRecord(op streamtypes.OperationType, id string, before, after *Mission) streamtypes.Record {
+	rec := &streamtypes.StreamRecord{
+		Keys:                        map[string]streamtypes.AttributeValue{"id": streamString(id)},
+		ApproximateCreationDateTime: aws.Time(time.Now()),
+	}
+	if before != nil {
+		rec.OldImage = streamImage(*before)
+	}
+	if after != nil {
+		rec.NewImage = streamImage(*after)
+	}
+	return streamtypes.Record{EventID: aws.String(string(op) + "-" + id), EventName: op, Dynamodb: rec}
+}
+
+func TestMissionStreamApply(t *testing.T) {
+	ctx := context.Background()
+	pending := Mission{ID: "m1", Status: "In Progress", TargetSatelliteID: "SAT-1", ObserverSatelliteID: "SAT-2"}
+	done := pending
+	done.Status = "complete"
+	other := Mission{ID: "m2", Status: "planned", TargetSatelliteID: "SAT-1"}
+	api := &API{
+		Config:    &Config{},
+		MissionDB: missionMap{"m2": &other},
+		Missions:  &MissionCache{cache: newMemoryKV(), ttl: time.Minute},
+		Events:    newEventBus(),
+	}
+	stream := newMissionStream(api, &fakeStream{}, "arn")
+	stream.index.load(ctx, missionMap{})
+	api.Missions.StoreMission(ctx, &pending)
+	events, cancel := api.Events.Subscribe(16, nil)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		rec  streamtypes.Record
+		want []string
+	}{
+		{"insert", streamRecord(streamtypes.OperationTypeInsert, "m1", nil, &pending), []string{EventMissionCreated}},
+		{"complete", streamRecord(streamtypes.OperationTypeModify, "m1", &pending, &done), []string{EventMissionUpdated, EventMissionCompleted}},
+		{"modify completed", streamRecord(streamtypes.OperationTypeModify, "m1", &done, &done), []string{EventMissionUpdated}},
+		{"keys only", streamRecord(streamtypes.OperationTypeModify, "m2", nil, nil), []string{EventMissionUpdated}},
+		{"remove", streamRecord(streamtypes.OperationTypeRemove, "m1", &done, nil), []string{EventMissionDeleted}},
+		{"no key", streamtypes.Record{EventName: streamtypes.OperationTypeModify, Dynamodb: &streamtypes.StreamRecord{}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream.apply(ctx, tt.rec)
+			var got []string
+			for len(events) > 0 {
+				e := <-events
+				if e.Type != EventMissionDeleted && e.Mission == nil {
+					t.Errorf("%s event has no mission", e.Type)
+				}
+				if e.SourceID != aws.ToString(tt.rec.EventID) {
+					t.Errorf("%s event source = %q", e.Type, e.SourceID)
+				}
+				got = append(got, e.Type)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("published %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, cached := api.Missions.Mission(ctx, "m1"); cached {
+		t.Error("changed mission still cached")
+	}
+	stats, ok := stream.index.Stats("")
+	if !ok || stats.Total != 1 || stats.ByStatus["planned"] != 1 || stats.BySatellite["SAT-1"] != 1 || stats.BySatellite["SAT-2"] != 0 {
+		t.Errorf("stats = %+v, ready %v", stats, ok)
+	}
+}
+
+func TestMissionIndexLoad(t *testing.T) {
+	ctx := context.Background()
+	index := newMissionIndex()
+	if _, ok := index.Stats(""); ok {
+		t.Error("index ready before loading")
+	}
+	// Changes read from the stream while the table is scanned win over the
+	// scanned copies.
+	index.put(&Mission{ID: "m1", Status: "complete", TargetSatelliteID: "SAT-1", ImageIDs: []string{"a"}})
+	index.remove("m3")
+	err := index.load(ctx, missionMap{
+		"m1": {ID: "m1", Status: "planned", TargetSatelliteID: "SAT-1"},
+		"m2": {ID: "m2", Status: "planned", TargetSatelliteID: "SAT-2", ObserverSatelliteID: "SAT-1", ImageIDs: []string{"b", "c"}},
+		"m3": {ID: "m3", Status: "planned", TargetSatelliteID: "SAT-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, ok := index.Stats("")
+	want := MissionStats{Total: 2, ByStatus: map[string]int{"complete": 1, "planned": 1}, BySatellite: map[string]int{"SAT-1": 2, "SAT-2": 1}, Updated: stats.Updated}
+	if !ok || !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	missions, _ := index.Satellite("", "SAT-1")
+	if len(missions) != 2 || missions[0].ID != "m1" || missions[1].ID != "m2" {
+		t.Errorf("SAT-1 missions = %+v", missions)
+	}
+	images := index.satelliteImages("", map[string]bool{"SAT-2": true})
+	slices.Sort(images)
+	if !slices.Equal(images, []string{"b", "c"}) {
+		t.Errorf("SAT-2 images = %v", images)
+	}
+}
+
+func TestMissionStreamShards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seq := func(end bool) *streamtypes.SequenceNumberRange {
+		r := &streamtypes.SequenceNumberRange{StartingSequenceNumber: aws.String("1")}
+		if end {
+			r.EndingSequenceNumber = aws.String("9")
+		}
+		return r
+	}
+	fake := &fakeStream{
+		shards: []streamtypes.Shard{
+			{ShardId: aws.String("old"), SequenceNumberRange: seq(true)},
+			{ShardId: aws.String("live"), ParentShardId: aws.String("old"), SequenceNumberRange: seq(false)},
+		},
+		batches:   map[string][][]streamtypes.Record{},
+		open:      map[string]bool{"live": true},
+		positions: map[string]streamtypes.ShardIteratorType{},
+	}
+	api := &API{Config: &Config{}, MissionDB: missionMap{}, Events: newEventBus()}
+	stream := newMissionStream(api, fake, "arn")
+	stream.poll = time.Millisecond
+	events, unsubscribe := api.Events.Subscribe(4, nil)
+	defer unsubscribe()
+
+	// At startup the open shard is read from its tip and the closed one is
+	// not read at all.
+	if err := stream.startShards(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	if fake.positions["live"] != streamtypes.ShardIteratorTypeLatest || fake.positions["old"] != "" {
+		t.Errorf("positions = %v", fake.positions)
+	}
+	// The live shard splits: its child waits until it is read to the end.
+	fake.shards = append(fake.shards, streamtypes.Shard{ShardId: aws.String("child"), ParentShardId: aws.String("live"), SequenceNumberRange: seq(false)})
+	fake.batches["child"] = [][]streamtypes.Record{{streamRecord(streamtypes.OperationTypeInsert, "m1", nil, &Mission{ID: "m1"})}}
+	fake.open["child"] = true
+	fake.mu.Unlock()
+	if err := stream.startShards(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	if _, started := fake.positions["child"]; started {
+		t.Error("child read before its parent finished")
+	}
+	fake.open["live"] = false
+	fake.mu.Unlock()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		stream.mu.Lock()
+		done := stream.shards["live"]
+		stream.mu.Unlock()
+		if done {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("live shard never finished")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if err := stream.startShards(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Type != EventMissionCreated || e.MissionID != "m1" {
+			t.Errorf("event = %+v", e)
+		}
+	case <-deadline:
+		t.Fatal("child shard's record not published")
+	}
+	fake.mu.Lock()
+	if fake.positions["child"] != streamtypes.ShardIteratorTypeTrimHorizon {
+		t.Errorf("child read from %q", fake.positions["child"])
+	}
+	fake.mu.Unlock()
+}
+
+func TestMissionIndexRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	get := func(api *API, path string) *httptest.ResponseRecorder {
+		router := gin.New()
+		api.routesV1(router, routeTimeout(0), routeTimeout(0))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	without := &API{Config: &Config{}}
+	loading := &API{Config: &Config{}}
+	loading.Stream = newMissionStream(loading, &fakeStream{}, "arn")
+	loaded := &API{Config: &Config{}}
+	loaded.Stream = newMissionStream(loaded, &fakeStream{}, "arn")
+	loaded.Stream.index.load(context.Background(), missionMap{"m1": {ID: "m1", Status: "complete", TargetSatelliteID: "SAT-1"}})
+
+	tests := []struct {
+		name, path string
+		api        *API
+		want       int
+		body       string
+	}{
+		{"no stream", "/missions/stats", without, http.StatusNotImplemented, "FEATURE_UNAVAILABLE"},
+		{"loading", "/satellite/SAT-1/missions", loading, http.StatusServiceUnavailable, "FEATURE_UNAVAILABLE"},
+		{"stats", "/missions/stats", loaded, http.StatusOK, `"complete": 1`},
+		{"satellite", "/satellite/SAT-1/missions", loaded, http.StatusOK, `"id": "m1"`},
+		{"unknown satellite", "/satellite/SAT-9/missions", loaded, http.StatusOK, `"missions": []`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.api, tt.path)
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("status = %d, body %s", w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ObservationGeometry is how the observer saw the target when an image was
+// captured, stored as the geometry attribute of its ImageRecord.
+type ObservationGeometry struct {
+	MissionID   string  `dynamodbav:"mission_id" json:"mission_id"`
+	CaptureTime int64   `dynamodbav:"capture_time" json:"capture_time"`
+	RangeKM     float64 `dynamodbav:"range_km" json:"range_km"`
+	// RangeRateKMS is positive while the target recedes.
+	RangeRateKMS  float64 `dynamodbav:"range_rate_kms" json:"range_rate_kms"`
+	PhaseAngleDeg float64 `dynamodbav:"phase_angle_deg" json:"phase_angle_deg"`
+	// SunVector is the unit vector from the observer to the Sun in the
+	// sensor frame, the pointing plan's body frame with +Z on the target.
+	SunVector [3]float64 `dynamodbav:"sun_vector" json:"sun_vector"`
+	// TargetRADeg and TargetDecDeg are the target's direction from the
+	// observer, on the TEME equator.
+	TargetRADeg      float64 `dynamodbav:"target_ra_deg" json:"target_ra_deg"`
+	TargetDecDeg     float64 `dynamodbav:"target_dec_deg" json:"target_dec_deg"`
+	TargetSunlit     bool    `dynamodbav:"target_sunlit" json:"target_sunlit"`
+	ObserverEclipsed bool    `dynamodbav:"observer_eclipsed" json:"observer_eclipsed"`
+	// EarthBackground is set when the Earth is behind the target.
+	EarthBackground bool `dynamodbav:"earth_background" json:"earth_background"`
+	// TargetTLEEpoch and ObserverTLEEpoch are the epochs of the element
+	// sets propagated.
+	TargetTLEEpoch   time.Time `dynamodbav:"target_tle_epoch" json:"target_tle_epoch"`
+	ObserverTLEEpoch time.Time `dynamodbav:"observer_tle_epoch" json:"observer_tle_epoch"`
+	// Coverage is what the observer's sensor saw of the target, when it has
+	// one registered.
+	Coverage *SensorCoverage `dynamodbav:"coverage,omitempty" json:"coverage,omitempty"`
+	Computed int64           `dynamodbav:"computed" json:"computed"`
+}
+
+// observationGeometry propagates the pair to t.
+func observationGeometry(pair orbitPair, t time.Time) (ObservationGeometry, error) {
+	o, err := pair.observer.At(t)
+	if err != nil {
+		return ObservationGeometry{}, fmt.Errorf("observer at %s: %w", t.Format(time.RFC3339), err)
+	}
+	tg, err := pair.target.At(t)
+	if err != nil {
+		return ObservationGeometry{}, fmt.Errorf("target at %s: %w", t.Format(time.RFC3339), err)
+	}
+	sun := sunPosition(t)
+	los := sub(tg.Position, o.Position)
+	z := unit(los)
+	p := pointAt(o, tg.Position)
+	toSun := unit(sub(sun, o.Position))
+	round := func(v, scale float64) float64 { return math.Round(v*scale) / scale }
+
+	g := ObservationGeometry{
+		CaptureTime:      t.Unix(),
+		RangeKM:          round(norm(los), 1e3),
+		RangeRateKMS:     round(dot(sub(tg.Velocity, o.Velocity), z), 1e6),
+		PhaseAngleDeg:    round(angleDeg(sub(sun, tg.Position), sub(o.Position, tg.Position)), 100),
+		TargetRADeg:      round(math.Mod(math.Atan2(z[1], z[0])*180/math.Pi+360, 360), 1e4),
+		TargetDecDeg:     round(math.Asin(z[2])*180/math.Pi, 1e4),
+		TargetSunlit:     sunlit(tg.Position, sun),
+		ObserverEclipsed: !sunlit(o.Position, sun),
+		EarthBackground:  earthBackground(o.Position, tg.Position),
+		TargetTLEEpoch:   pair.targetTLE.Epoch,
+		ObserverTLEEpoch: pair.observerTLE.Epoch,
+		Computed:         time.Now().Unix(),
+	}
+	for i, v := range rotate(p.Quaternion, toSun) {
+		g.SunVector[i] = round(v, 1e6)
+	}
+	return g, nil
+}
+
+// rotate applies the attitude quaternion q, scalar last, to v.
+func rotate(q [4]float64, v [3]float64) [3]float64 {
+	q1, q2, q3, q4 := q[0], q[1], q[2], q[3]
+	m := [3][3]float64{
+		{q1*q1 - q2*q2 - q3*q3 + q4*q4, 2 * (q1*q2 + q3*q4), 2 * (q1*q3 - q2*q4)},
+		{2 * (q1*q2 - q3*q4), -q1*q1 + q2*q2 - q3*q3 + q4*q4, 2 * (q2*q3 + q1*q4)},
+		{2 * (q1*q3 + q2*q4), 2 * (q2*q3 - q1*q4), -q1*q1 - q2*q2 + q3*q3 + q4*q4},
+	}
+	return [3]float64{dot(m[0], v), dot(m[1], v), dot(m[2], v)}
+}
+
+// imageGeometry computes the observation geometry of an image captured at
+// t for the mission's target and observer.
+func (api *API) imageGeometry(ctx context.Context, missionID string, t time.Time) (ObservationGeometry, error) {
+	mission, err := api.loadMission(ctx, missionID)
+	if err != nil {
+		return ObservationGeometry{}, err
+	}
+	if mission.TargetSatelliteID == "" || mission.ObserverSatelliteID == "" {
+		return ObservationGeometry{}, newProblem(http.StatusUnprocessableEntity, CodePropagationFailed, "the mission needs a target and an observer satellite")
+	}
+	pair, err := api.orbits(ctx, mission.TargetSatelliteID, mission.ObserverSatelliteID, t)
+	if err != nil {
+		return ObservationGeometry{}, err
+	}
+	g, err := observationGeometry(pair, t.UTC())
+	if err != nil {
+		return ObservationGeometry{}, newProblem(http.StatusUnprocessableEntity, CodePropagationFailed, err.Error())
+	}
+	g.MissionID = missionID
+	g.Coverage = api.sensorCoverage(mission.ObserverSatelliteID, mission.TargetSatelliteID, g.RangeKM)
+	return g, nil
+}
+
+// annotateGeometry stores the observation geometry of an image just
+// ingested into the mission, and returns it. A failure is logged and does
+// not fail the ingest.
+func (api *API) annotateGeometry(ctx context.Context, id, missionID string, captured time.Time) *ObservationGeometry {
+	g, err := api.imageGeometry(ctx, missionID, captured)
+	if err != nil {
+		slog.WarnContext(ctx, "no observation geometry for ingested image", "id", id, "mission", missionID, "err", err)
+		return nil
+	}
+	if err := api.Images.SetImageAttribute(ctx, id, "geometry", g); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to store observation geometry", "id", id, "err", err)
+	}
+	return &g
+}
+
+// getImageGeometry computes the image's observation geometry at its
+// capture time for ?mission=, by default the mission it was linked to at
+// ingest. ?persist=true also stores it on the image record.
+func (api *API) getImageGeometry(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	persist, _ := strconv.ParseBool(c.Query("persist"))
+
+	missionID := c.Query("mission")
+	if missionID == "" {
+		record, err := api.Images.ImageRecord(ctx, id)
+		if err != nil && !errors.Is(err, errImageTableUnset) {
+			slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load image record")
+			return
+		}
+		if record != nil && record.Ingest != nil {
+			missionID = record.Ingest.MissionID
+		}
+		if missionID == "" {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "The image was not linked to a mission at ingest. Give 'mission'.")
+			return
+		}
+	}
+	captured, err := api.captureTime(ctx, api.Config.ImagesBucket, id)
+	if err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		slog.ErrorContext(ctx, "s3 HeadObject failed", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
+		return
+	}
+
+	g, err := api.imageGeometry(ctx, missionID, captured)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		var p *Problem
+		if !errors.As(err, &p) {
+			slog.ErrorContext(ctx, "failed to compute observation geometry", "id", id, "mission", missionID, "err", err)
+		}
+		respondProblem(c, problemFor(err))
+		return
+	}
+	if persist {
+		if err := api.Images.SetImageAttribute(ctx, id, "geometry", g); err != nil {
+			slog.ErrorContext(ctx, "failed to store observation geometry", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to store observation geometry")
+			return
+		}
+	}
+	c.IndentedJSON(http.StatusOK, g)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestObservationGeometry(t *testing.T) {
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	target, _ := newSGP4(iss)
+	shifted := shiftedISS(t)
+	observer, _ := newSGP4(shifted)
+	pair := orbitPair{target: target, observer: observer, targetTLE: iss, observerTLE: shifted}
+	at := iss.Epoch.Add(30 * time.Minute).Truncate(time.Second)
+
+	g, err := observationGeometry(pair, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _ := observer.At(at)
+	tg, _ := target.At(at)
+	sun := sunPosition(at)
+	los := unit(sub(tg.Position, o.Position))
+	// The target's RA and Dec give back the line of sight.
+	ra, dec := g.TargetRADeg*math.Pi/180, g.TargetDecDeg*math.Pi/180
+	if d := angleDeg(los, [3]float64{math.Cos(dec) * math.Cos(ra), math.Cos(dec) * math.Sin(ra), math.Sin(dec)}); d > 1e-3 {
+		t.Errorf("RA %v Dec %v are %v° off the line of sight", g.TargetRADeg, g.TargetDecDeg, d)
+	}
+	// The sensor frame's +Z is the boresight, so the Sun vector's z is the
+	// cosine of the sun angle.
+	if want := math.Cos(sunAngle(o.Position, tg.Position, sun) * math.Pi / 180); math.Abs(g.SunVector[2]-want) > 1e-5 || math.Abs(norm(g.SunVector)-1) > 1e-5 {
+		t.Errorf("sun vector %v, want z %v", g.SunVector, want)
+	}
+	if math.Abs(g.RangeKM-distance(o.Position, tg.Position)) > 1e-3 || g.CaptureTime != at.Unix() || !g.ObserverTLEEpoch.Equal(shifted.Epoch) {
+		t.Errorf("geometry %+v", g)
+	}
+	// The range rate is the range's derivative.
+	later, _ := observationGeometry(pair, at.Add(time.Second))
+	if rate := later.RangeKM - g.RangeKM; math.Abs(rate-g.RangeRateKMS) > 0.01 {
+		t.Errorf("range rate %v, want about %v", g.RangeRateKMS, rate)
+	}
+}
+
+func TestImageGeometry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	shifted := shiftedISS(t)
+	captured := iss.Epoch.Add(30 * time.Minute).Truncate(time.Second)
+	for _, m := range []*Mission{
+		{ID: "rpo", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser"},
+		{ID: "survey"},
+	} {
+		if err := putMission(ctx, meta, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"rpo-01", "survey-01", "loose"} {
+		_, err := store.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("sat"), Key: aws.String(imageKey(id)), Body: bytes.NewReader(frame),
+			Metadata: map[string]string{"capture-time": strconv.FormatInt(captured.Unix(), 10)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{
+		Config:     &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern)},
+		MissionDB:  meta,
+		Images:     meta,
+		S3:         store,
+		Limiter:    newProcessLimiter(0, defaultQueueTimeout),
+		Events:     newEventBus(),
+		Satellites: newSatelliteStore(nil, ""),
+		TLEs:       newTLEStore(nil, ""),
+	}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive, TLE: &iss})
+	api.Satellites.Put(ctx, Satellite{ID: "chaser", NoradID: 99999, Name: "Chaser", Status: SatelliteActive, TLE: &shifted})
+
+	// Ingest records the geometry of an image linked to a mission with
+	// satellites, and skips the rest.
+	for _, id := range []string{"rpo-01", "survey-01", "loose"} {
+		if err := api.ingestImage(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record, err := meta.ImageRecord(ctx, "rpo-01")
+	if err != nil || record == nil || record.Geometry == nil {
+		t.Fatalf("ImageRecord = %+v, %v", record, err)
+	}
+	if g := record.Geometry; g.MissionID != "rpo" || g.CaptureTime != captured.Unix() || g.RangeKM <= 0 {
+		t.Errorf("ingested geometry %+v", g)
+	}
+	if record, _ := meta.ImageRecord(ctx, "survey-01"); record == nil || record.Geometry != nil {
+		t.Errorf("survey-01 record %+v", record)
+	}
+
+	router := gin.New()
+	router.GET("/image/:id/geometry", api.getImageGeometry)
+	tests := []struct {
+		path string
+		want int
+		code ErrorCode
+	}{
+		{"/image/loose/geometry", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/loose/geometry?mission=none", http.StatusNotFound, CodeMissionNotFound},
+		{"/image/survey-01/geometry", http.StatusUnprocessableEntity, CodePropagationFailed},
+		{"/image/gone/geometry?mission=rpo", http.StatusNotFound, CodeImageNotFound},
+		{"/image/rpo-01/geometry", http.StatusOK, ""},
+		{"/image/loose/geometry?mission=rpo&persist=true", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s: %d %s, want %d %s", tt.path, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+	if record, _ := meta.ImageRecord(ctx, "loose"); record == nil || record.Geometry == nil || record.Geometry.MissionID != "rpo" {
+		t.Errorf("persisted record %+v", record)
+	}
+}
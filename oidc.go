@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOIDCRolesClaim  = "groups"
+	defaultOIDCTenantClaim = "tenant"
+	// The signing keys are refetched every jwksTTL, and sooner when a token
+	// names a key not yet seen, so a rotation is picked up, but at most once
+	// every jwksMinRefresh.
+	jwksTTL        = time.Hour
+	jwksMinRefresh = time.Minute
+	oidcTimeout    = 10 * time.Second
+	// jwtLeeway allows for clock skew between the issuer and this server.
+	jwtLeeway = time.Minute
+)
+
+var errInvalidToken = errors.New("invalid token")
+
+// OIDCConfig is the identity provider whose tokens are accepted as bearer
+// tokens. An empty Issuer accepts none.
+type OIDCConfig struct {
+	Issuer string
+	// Audience, when set, must be in the token's aud claim, or be its
+	// client_id as in Cognito access tokens.
+	Audience string
+	// RolesClaim is the claim holding the caller's roles or groups; dots
+	// descend into objects, as in realm_access.roles.
+	RolesClaim string
+	// Roles maps each value of the roles claim to the scopes it grants.
+	// Values naming one of the server's roles grant its scopes unmapped.
+	Roles map[string][]string
+	// TenantClaim is the claim naming the tenant the caller acts for with
+	// MULTI_TENANT, found as RolesClaim is.
+	TenantClaim string
+}
+
+// loadOIDCConfig reads OIDC_ISSUER, OIDC_AUDIENCE, OIDC_ROLES_CLAIM,
+// OIDC_TENANT_CLAIM and OIDC_ROLES, which is a comma-separated list of
+// claim=grant pairs, each grant a role or scope and several separated by
+// spaces.
+func loadOIDCConfig() (OIDCConfig, []error) {
+	c := OIDCConfig{
+		Issuer:      os.Getenv("OIDC_ISSUER"),
+		Audience:    os.Getenv("OIDC_AUDIENCE"),
+		RolesClaim:  os.Getenv("OIDC_ROLES_CLAIM"),
+		Roles:       map[string][]string{},
+		TenantClaim: os.Getenv("OIDC_TENANT_CLAIM"),
+	}
+	if c.RolesClaim == "" {
+		c.RolesClaim = defaultOIDCRolesClaim
+	}
+	if c.TenantClaim == "" {
+		c.TenantClaim = defaultOIDCTenantClaim
+	}
+	var errs []error
+	if c.Issuer != "" {
+		u, err := url.Parse(c.Issuer)
+		local := err == nil && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1")
+		if err != nil || u.Host == "" || (u.Scheme != "https" && !(u.Scheme == "http" && local)) {
+			errs = append(errs, fmt.Errorf("OIDC_ISSUER %q is not an https URL", c.Issuer))
+		}
+	}
+	for _, item := range splitList(os.Getenv("OIDC_ROLES")) {
+		claim, granted, ok := strings.Cut(item, "=")
+		claim = strings.TrimSpace(claim)
+		if !ok || claim == "" || len(strings.Fields(granted)) == 0 {
+			errs = append(errs, fmt.Errorf("OIDC_ROLES entry %q is not claim=role", item))
+			continue
+		}
+		for _, grant := range strings.Fields(granted) {
+			switch {
+			case roleScopes[grant] != nil:
+				c.Roles[claim] = append(c.Roles[claim], roleScopes[grant]...)
+			case slices.Contains(scopes, grant):
+				c.Roles[claim] = append(c.Roles[claim], grant)
+			default:
+				errs = append(errs, fmt.Errorf("OIDC_ROLES entry %q: unknown role or scope %q", item, grant))
+			}
+		}
+	}
+	if len(c.Roles) > 0 && c.Issuer == "" {
+		errs = append(errs, errors.New("OIDC_ROLES needs OIDC_ISSUER"))
+	}
+	return c, errs
+}
+
+// isJWT reports whether token has the shape of a signed JWT.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+// OIDCVerifier checks JWTs signed by the issuer's keys, which it discovers
+// from the issuer's OpenID configuration and caches.
+type OIDCVerifier struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	jwksURI string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newOIDCVerifier(cfg OIDCConfig) *OIDCVerifier {
+	return &OIDCVerifier{cfg: cfg, client: &http.Client{Timeout: oidcTimeout}}
+}
+
+// jwtHeader is the JOSE header of a token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the registered claims checked, and the scope claim of
+// OAuth access tokens.
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ClientID  string      `json:"client_id"`
+	Expires   float64     `json:"exp"`
+	NotBefore float64     `json:"nbf"`
+	Scope     string      `json:"scope"`
+}
+
+// jwtAudience is an aud claim, which is a string or a list of them.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = []string{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// Verify checks token's signature and claims, and returns the caller with
+// the scopes its roles and scope claim grant. A token that fails a check
+// yields an error wrapping errInvalidToken.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", errInvalidToken)
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", errInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", errInvalidToken)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if !verifyJWTSignature(header.Alg, key, hash, h.Sum(nil), sig) {
+		return nil, fmt.Errorf("%w: bad signature", errInvalidToken)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != v.cfg.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", errInvalidToken, claims.Issuer)
+	case v.cfg.Audience != "" && !slices.Contains(claims.Audience, v.cfg.Audience) && claims.ClientID != v.cfg.Audience:
+		return nil, fmt.Errorf("%w: not issued for %s", errInvalidToken, v.cfg.Audience)
+	case claims.Expires == 0 || now.Add(-jwtLeeway).After(time.Unix(int64(claims.Expires), 0)):
+		return nil, fmt.Errorf("%w: expired", errInvalidToken)
+	case claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(int64(claims.NotBefore), 0)):
+		return nil, fmt.Errorf("%w: not yet valid", errInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", errInvalidToken)
+	}
+
+	var raw map[string]any
+	if err := decodeJWTPart(parts[1], &raw); err != nil {
+		return nil, err
+	}
+	p := &Principal{ID: claims.Subject, Roles: claimStrings(raw, v.cfg.RolesClaim)}
+	switch tenants := claimStrings(raw, v.cfg.TenantClaim); {
+	case len(tenants) > 1 || len(tenants) == 1 && !tenantName.MatchString(tenants[0]):
+		return nil, fmt.Errorf("%w: claim %s is not one tenant name", errInvalidToken, v.cfg.TenantClaim)
+	case len(tenants) == 1:
+		p.Tenant = tenants[0]
+	}
+	for _, role := range p.Roles {
+		p.Scopes = append(p.Scopes, v.cfg.Roles[role]...)
+		p.Scopes = append(p.Scopes, roleScopes[role]...)
+	}
+	// Resource server scopes are often namespaced, as sat-api/images:read.
+	for _, s := range strings.Fields(claims.Scope) {
+		if s = s[strings.LastIndex(s, "/")+1:]; slices.Contains(scopes, s) {
+			p.Scopes = append(p.Scopes, s)
+		}
+	}
+	p.Scopes = slices.Compact(slices.Sorted(slices.Values(p.Scopes)))
+	return p, nil
+}
+
+func decodeJWTPart(part string, dst any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed", errInvalidToken)
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("%w: malformed", errInvalidToken)
+	}
+	return nil
+}
+
+// claimStrings returns the strings in the claim at path, which may be one
+// string, a list or a space-separated string.
+func claimStrings(claims map[string]any, path string) []string {
+	var v any = claims
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = obj[name]
+	}
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(key, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size || key.Curve != jwtCurves[alg] {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+var jwtCurves = map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}
+
+// key returns the signing key kid names, refetching the key set when it is
+// stale or lacks kid. A token without kid may use the only key.
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.lookup(kid)
+	since := time.Since(v.fetched)
+	if since > jwksTTL || (!ok && since > jwksMinRefresh) {
+		if err := v.fetch(ctx); err != nil {
+			if !ok {
+				return nil, fmt.Errorf("fetch OIDC signing keys: %w", err)
+			}
+			slog.WarnContext(ctx, "failed to refresh OIDC signing keys", "issuer", v.cfg.Issuer, "err", err)
+		}
+		key, ok = v.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", errInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (v *OIDCVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// fetch reads the issuer's key set, discovering where it is on first use.
+func (v *OIDCVerifier) fetch(ctx context.Context) error {
+	if v.jwksURI == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, strings.TrimSuffix(v.cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return err
+		}
+		if discovery.Issuer != v.cfg.Issuer || discovery.JWKSURI == "" {
+			return fmt.Errorf("OpenID configuration is for issuer %q, with jwks_uri %q", discovery.Issuer, discovery.JWKSURI)
+		}
+		v.jwksURI = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, v.jwksURI, &set); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.WarnContext(ctx, "skipped OIDC signing key", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+	return nil
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, target string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+}
+
+// jwk is a public key in a JSON Web Key Set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("malformed n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("malformed e")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curve, ok := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("malformed point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
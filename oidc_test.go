@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testIssuer is an identity provider serving its OpenID configuration and
+// signing keys.
+type testIssuer struct {
+	srv       *httptest.Server
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+	jwksCalls atomic.Int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.srv.URL, "jwks_uri": iss.srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.jwksCalls.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	})
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+// sign returns a token with claims, signed with the key kid names.
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		sig = []byte("unsigned")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := newOIDCVerifier(OIDCConfig{
+		Issuer:      iss.srv.URL,
+		Audience:    "mission-dashboard",
+		RolesClaim:  "realm_access.roles",
+		Roles:       map[string][]string{"sat-ops": {ScopeMissionsRead, ScopeMissionsWrite}, "sat-admins": {ScopeAdmin}},
+		TenantClaim: "org",
+	})
+	now := time.Now().Unix()
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"iss": iss.srv.URL, "sub": "user-1", "aud": []string{"mission-dashboard", "account"},
+			"exp": now + 300, "iat": now, "realm_access": map[string]any{"roles": []string{"sat-ops", "offline_access"}},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		scopes []string
+	}{
+		{"rsa", iss.sign(t, "RS256", "rsa-1", claims(nil)), []string{ScopeMissionsRead, ScopeMissionsWrite}},
+		{"ecdsa", iss.sign(t, "ES256", "ec-1", claims(nil)), []string{ScopeMissionsRead, ScopeMissionsWrite}},
+		{"client_id audience", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"aud": nil, "client_id": "mission-dashboard"})), []string{ScopeMissionsRead, ScopeMissionsWrite}},
+		{"scope claim", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"realm_access": nil, "scope": "openid sat-api/images:read images:write"})), []string{ScopeImagesRead, ScopeImagesWrite}},
+		{"no roles", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"realm_access": nil})), nil},
+		{"expired", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"exp": now - 120})), nil},
+		{"not yet valid", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"nbf": now + 120})), nil},
+		{"no expiry", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"exp": nil})), nil},
+		{"other issuer", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"iss": "https://idp.example.com"})), nil},
+		{"other audience", iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"aud": "billing"})), nil},
+		{"wrong key", iss.sign(t, "RS256", "ec-1", claims(nil)), nil},
+		{"encryption key", iss.sign(t, "RS256", "enc-1", claims(nil)), nil},
+		{"unknown key", iss.sign(t, "RS256", "rsa-2", claims(nil)), nil},
+		{"none", iss.sign(t, "none", "rsa-1", claims(nil)), nil},
+		{"hmac", iss.sign(t, "HS256", "rsa-1", claims(nil)), nil},
+	}
+	valid := map[string]bool{"rsa": true, "ecdsa": true, "client_id audience": true, "scope claim": true, "no roles": true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := v.Verify(context.Background(), tt.token)
+			if !valid[tt.name] {
+				if !errors.Is(err, errInvalidToken) {
+					t.Fatalf("Verify = %+v, %v, want errInvalidToken", p, err)
+				}
+				return
+			}
+			if err != nil || p.ID != "user-1" || !slices.Equal(p.Scopes, tt.scopes) {
+				t.Fatalf("Verify = %+v, %v, want scopes %v", p, err, tt.scopes)
+			}
+		})
+	}
+	if p, err := v.Verify(context.Background(), iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"org": "acme"}))); err != nil || p.Tenant != "acme" {
+		t.Errorf("tenant claim: Verify = %+v, %v", p, err)
+	}
+	for _, org := range []any{"Acme Corp", []string{"acme", "globex"}} {
+		if _, err := v.Verify(context.Background(), iss.sign(t, "RS256", "rsa-1", claims(map[string]any{"org": org}))); !errors.Is(err, errInvalidToken) {
+			t.Errorf("tenant claim %v: err = %v, want errInvalidToken", org, err)
+		}
+	}
+	// A token naming an unknown key refetches the key set, at most once per
+	// jwksMinRefresh.
+	unknown := iss.sign(t, "RS256", "rsa-2", claims(nil))
+	v.fetched = time.Now().Add(-2 * jwksMinRefresh)
+	for range 3 {
+		v.Verify(context.Background(), unknown)
+	}
+	if got := iss.jwksCalls.Load(); got != 2 {
+		t.Errorf("key set fetched %d times, want 2", got)
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	claims := map[string]any{
+		"cognito:groups": []any{"sat-ops", 7, "sat-admins"},
+		"roles":          "viewer operator",
+		"realm_access":   map[string]any{"roles": []any{"sat-ops"}},
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"cognito:groups", []string{"sat-ops", "sat-admins"}},
+		{"roles", []string{"viewer", "operator"}},
+		{"realm_access.roles", []string{"sat-ops"}},
+		{"realm_access.groups", nil},
+		{"roles.nested", nil},
+		{"groups", nil},
+	}
+	for _, tt := range tests {
+		if got := claimStrings(claims, tt.path); !slices.Equal(got, tt.want) {
+			t.Errorf("claimStrings(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRequireScopeOIDC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	iss := newTestIssuer(t)
+	creds := &Credentials{OIDC: newOIDCVerifier(OIDCConfig{
+		Issuer: iss.srv.URL, RolesClaim: "groups", Roles: map[string][]string{"sat-ops": {ScopeMissionsWrite}},
+	})}
+	router := gin.New()
+	router.POST("/invalidate", requireScope(&Config{AuthRequired: true}, creds, ScopeMissionsWrite, false), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"role with scope", iss.sign(t, "RS256", "rsa-1", map[string]any{"iss": iss.srv.URL, "sub": "u1", "exp": exp, "groups": []string{"sat-ops"}}), http.StatusNoContent},
+		{"server role", iss.sign(t, "RS256", "rsa-1", map[string]any{"iss": iss.srv.URL, "sub": "u1", "exp": exp, "groups": []string{RoleOperator}}), http.StatusNoContent},
+		{"role without scope", iss.sign(t, "RS256", "rsa-1", map[string]any{"iss": iss.srv.URL, "sub": "u1", "exp": exp, "groups": []string{"analysts"}}), http.StatusForbidden},
+		{"expired", iss.sign(t, "RS256", "rsa-1", map[string]any{"iss": iss.srv.URL, "sub": "u1", "exp": 1, "groups": []string{"sat-ops"}}), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/invalidate", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
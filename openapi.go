@@ -0,0 +1,1031 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenAPI 3.0 document for /api/v1 is built from the types below rather
+// than maintained by hand: response schemas are derived from the Go types
+// the handlers encode, and TestOpenAPICoversRoutes fails when a route is
+// added without an operation.
+
+type openAPIDoc struct {
+	OpenAPI    string                  `json:"openapi"`
+	Info       openAPIInfo             `json:"info"`
+	Servers    []openAPIServer         `json:"servers"`
+	Paths      map[string]*openAPIPath `json:"paths"`
+	Components openAPIComponents       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIPath struct {
+	Get    *openAPIOperation `json:"get,omitempty"`
+	Head   *openAPIOperation `json:"head,omitempty"`
+	Post   *openAPIOperation `json:"post,omitempty"`
+	Put    *openAPIOperation `json:"put,omitempty"`
+	Delete *openAPIOperation `json:"delete,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+// openAPISchema is the part of the Schema Object the spec uses.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []any                     `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties any                       `json:"additionalProperties,omitempty"`
+	OneOf                []*openAPISchema          `json:"oneOf,omitempty"`
+}
+
+var (
+	timeType    = reflect.TypeFor[time.Time]()
+	rawJSONType = reflect.TypeFor[json.RawMessage]()
+)
+
+// specBuilder accumulates operations and the component schemas they refer
+// to.
+type specBuilder struct {
+	doc *openAPIDoc
+}
+
+// schemaFor derives a schema from t the way encoding/json encodes it. Named
+// structs become components referenced by name.
+func (b *specBuilder) schemaFor(t reflect.Type) *openAPISchema {
+	switch t {
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case rawJSONType:
+		return &openAPISchema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schemaFor(t.Elem())
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s := &openAPISchema{Type: "integer"}
+		if t.Size() == 8 {
+			s.Format = "int64"
+		}
+		return s
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := b.doc.Components.Schemas[name]; !ok {
+			// Reserve the name first so recursive types terminate.
+			b.doc.Components.Schemas[name] = nil
+			b.doc.Components.Schemas[name] = b.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	}
+	return &openAPISchema{}
+}
+
+func (b *specBuilder) structSchema(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := b.structSchema(f.Type)
+			for k, v := range embedded.Properties {
+				s.Properties[k] = v
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = b.schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+func (b *specBuilder) ref(v any) *openAPISchema {
+	return b.schemaFor(reflect.TypeOf(v))
+}
+
+func (b *specBuilder) add(method, path string, op *openAPIOperation) {
+	item := b.doc.Paths[path]
+	if item == nil {
+		item = &openAPIPath{}
+		b.doc.Paths[path] = item
+	}
+	if op.Responses == nil {
+		op.Responses = map[string]*openAPIResponse{}
+	}
+	op.Responses["default"] = &openAPIResponse{
+		Description: "An error, as an RFC 7807 problem.",
+		Content:     map[string]openAPIMediaType{problemContentType: {Schema: b.ref(Problem{})}},
+	}
+	switch method {
+	case http.MethodGet:
+		item.Get = op
+	case http.MethodHead:
+		item.Head = op
+	case http.MethodPost:
+		item.Post = op
+	case http.MethodPut:
+		item.Put = op
+	case http.MethodDelete:
+		item.Delete = op
+	}
+}
+
+func jsonContent(s *openAPISchema) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{"application/json": {Schema: s}}
+}
+
+func binaryContent(contentTypes ...string) map[string]openAPIMediaType {
+	content := map[string]openAPIMediaType{}
+	for _, ct := range contentTypes {
+		content[ct] = openAPIMediaType{Schema: &openAPISchema{Type: "string", Format: "binary"}}
+	}
+	return content
+}
+
+func pathParam(name, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "path", Description: description, Required: true, Schema: &openAPISchema{Type: "string"}}
+}
+
+func queryParam(name, typ, description string, enum ...any) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Schema: &openAPISchema{Type: typ, Enum: enum}}
+}
+
+func boundedParam(name, description string, lo, hi float64) openAPIParameter {
+	p := queryParam(name, "integer", description)
+	p.Schema.Minimum, p.Schema.Maximum = &lo, &hi
+	return p
+}
+
+var (
+	imageFormats             = []any{"jpeg", "png", "webp", "avif", "tiff"}
+	imageTypes               = []string{"image/jpeg", "image/png", "image/webp", "image/avif", "image/tiff"}
+	jobStatuses              = []any{JobQueued, JobRunning, JobSucceeded, JobFailed}
+	openAPIThumbnailSizes    = []any{64, 128, 256, 512}
+	openAPISatelliteStatuses = []any{SatelliteActive, SatelliteInactive, SatelliteDecayed, SatelliteUnknown}
+
+	missionID        = pathParam("id", "Mission ID.")
+	imageID          = pathParam("id", "Image ID.")
+	jobID            = pathParam("id", "Job ID.")
+	webhookID        = pathParam("id", "Webhook ID.")
+	apiKeyID         = pathParam("id", "API key ID.")
+	roleSubject      = pathParam("subject", "API key ID or OIDC subject.")
+	timeWindowParams = []openAPIParameter{
+		queryParam("start", "string", "Unix time in seconds or RFC 3339 time; defaults to now."),
+		queryParam("end", "string", "Unix time in seconds or RFC 3339 time; defaults to 90 minutes after start."),
+		queryParam("step", "string", "Duration such as 30s, or seconds, between samples; defaults to 60s."),
+	}
+	accessConstraintParams = []openAPIParameter{
+		queryParam("max_range_km", "number", "Greatest observer to target range; defaults to 1000."),
+		queryParam("min_sun_angle", "number", "Least angle in degrees between the target and the Sun seen from a sunlit observer; defaults to 30."),
+		queryParam("target_sunlit", "boolean", "Require the target to be out of Earth's shadow; defaults to true."),
+		queryParam("observer_eclipsed", "boolean", "Require the observer to be in Earth's shadow; defaults to false."),
+	}
+	expandParam = queryParam("expand", "string", "Comma-separated: satellites embeds the mission's target and observer from the catalog, positions their ground positions at TCA, storage its stored and served bytes.")
+
+	adminOnly = []map[string][]string{{"adminToken": {}}}
+)
+
+// processingParams are the query parameters of GET /image/:id, also
+// accepted by the mission archive and as the spec of a batch job.
+var processingParams = []openAPIParameter{
+	queryParam("width", "integer", "Output width in pixels, at most MAX_OUTPUT_DIMENSION."),
+	queryParam("height", "integer", "Output height in pixels, at most MAX_OUTPUT_DIMENSION."),
+	queryParam("crop", "string", "Region x,y,w,h in full-resolution pixels, cut out before any other processing."),
+	queryParam("contrast", "number", "Contrast adjustment; positive increases it."),
+	queryParam("format", "string", "Output encoding. Negotiated from Accept when omitted.", imageFormats...),
+	boundedParam("quality", "Encoder quality for jpeg, webp and avif.", 1, 100),
+	queryParam("lossless", "boolean", "Lossless webp or avif."),
+	queryParam("compression", "string", "png: default, none, speed or best. tiff: none disables Deflate."),
+	queryParam("scale", "string", "Radiometric stretch: minmax, percentile[:lo,hi] or fixed:lo,hi."),
+	queryParam("starsuppress", "boolean", "Remove the sky background and faint stars."),
+	boundedParam("starsuppress_cell", "Background mesh cell size in pixels.", 8, 512),
+	queryParam("starsuppress_sigma", "number", "Residual clipping threshold in noise standard deviations."),
+	queryParam("overlay", "string", "Banner or watermark text; none removes the server default."),
+	queryParam("overlay_position", "string", "Where the overlay is drawn.",
+		"top", "bottom", "top-bottom", "top-left", "top-right", "bottom-left", "bottom-right", "center"),
+	queryParam("overlay_opacity", "number", "Overlay opacity from 0 to 1."),
+	queryParam("annotations", "boolean", "Burn the image's stored annotations into the output."),
+	queryParam("detections", "string", "overlay draws the objects found at ingest, labelled with their confidence.", "overlay"),
+	queryParam("stripMetadata", "boolean", "Remove EXIF, XMP, IPTC and GeoTIFF tags from the delivered file."),
+}
+
+var photometryParams = []openAPIParameter{
+	queryParam("x", "integer", "Column of the position hint. Defaults to the image centre."),
+	queryParam("y", "integer", "Row of the position hint. Defaults to the image centre."),
+	boundedParam("search", "Search radius around the hint in pixels.", 1, maxPhotometrySearch),
+}
+
+func params(groups ...[]openAPIParameter) []openAPIParameter {
+	var all []openAPIParameter
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
+
+func ok(description string, content map[string]openAPIMediaType) map[string]*openAPIResponse {
+	return map[string]*openAPIResponse{"200": {Description: description, Content: content}}
+}
+
+// newOpenAPISpec describes every route registered by routesV1.
+func newOpenAPISpec() *openAPIDoc {
+	b := &specBuilder{doc: &openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "Satellite image server",
+			Version:     "1",
+			Description: "Missions, satellite imagery and image processing jobs. Errors are RFC 7807 problems whose code field is stable.",
+		},
+		Servers: []openAPIServer{{URL: apiV1Prefix}},
+		Paths:   map[string]*openAPIPath{},
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{},
+			SecuritySchemes: map[string]*openAPISecurityScheme{"adminToken": {
+				Type: "http", Scheme: "bearer",
+				Description: "ADMIN_TOKEN, an API key, or a JWT from OIDC_ISSUER. Each route needs a scope: missions:read, missions:write, missions:approve, images:read, images:write or admin. " +
+					"Without credentials the read scopes are granted unless AUTH_REQUIRED is set. " +
+					"With RATE_LIMIT_JSON or RATE_LIMIT_IMAGE set, callers over their limit get 429 with Retry-After.",
+			}},
+		},
+	}}
+	// Problems may carry extension members beside the standard ones.
+	b.ref(Problem{})
+	b.doc.Components.Schemas["Problem"].AdditionalProperties = true
+	accepted := map[string]*openAPIResponse{"202": {Description: "A job was started.", Content: jsonContent(b.ref(JobAccepted{}))}}
+
+	b.add(http.MethodGet, "/rate-limits", &openAPIOperation{
+		OperationID: "getRateLimits", Summary: "Get the caller's remaining requests", Tags: []string{"rate-limits"},
+		Description: "Lists each limited route class with the requests the caller has left. Reading it takes none of them.",
+		Responses:   ok("The caller's rate limits.", jsonContent(b.ref(RateLimitResponse{}))),
+	})
+	b.add(http.MethodGet, "/usage", &openAPIOperation{
+		OperationID: "getUsage", Summary: "Get daily usage per tenant and client", Tags: []string{"usage"},
+		Description: "Requests, response bytes and processing seconds, rolled up by UTC day. Covers the last 30 days by default and at most 92.",
+		Parameters: []openAPIParameter{
+			queryParam("tenant", "string", "Only this tenant's usage. Callers other than ADMIN_TOKEN always get their own tenant's."),
+			queryParam("client", "string", "Only this client's usage: an API key ID, OIDC subject, admin, or ip:<address>."),
+			queryParam("since", "string", "First UTC day, such as 2026-10-01."),
+			queryParam("until", "string", "Last UTC day, today by default."),
+		},
+		Responses: ok("The usage.", jsonContent(b.ref(UsageResponse{}))),
+		Security:  adminOnly,
+	})
+	b.add(http.MethodGet, "/missions", &openAPIOperation{
+		OperationID: "listMissions", Summary: "List missions", Tags: []string{"missions"},
+		Parameters: []openAPIParameter{
+			boundedParam("count", "Page size.", 1, 100),
+			queryParam("nextToken", "string", "Token from the previous page."),
+			expandParam,
+		},
+		Responses: ok("A page of missions, expanded for expand=.", jsonContent(&openAPISchema{
+			OneOf: []*openAPISchema{b.ref(PaginatedMissionsResponse{}), b.ref(ExpandedMissionsResponse{})},
+		})),
+	})
+	b.add(http.MethodGet, "/missions/events", &openAPIOperation{
+		OperationID: "streamMissionEvents", Summary: "Stream mission and image events", Tags: []string{"missions"},
+		Description: "Server-Sent Events. Each event's id is the Event id, its name the Event type and its data the Event as JSON. " +
+			"A reconnecting client's Last-Event-ID header replays what it missed, or sends a reset event when that is no longer retained.",
+		Parameters: []openAPIParameter{
+			queryParam("type", "string", "Comma-separated event types to send."),
+			queryParam("mission", "string", "Comma-separated mission IDs whose events to send."),
+			queryParam("satellite", "string", "Comma-separated satellite IDs whose missions' events to send."),
+			{Name: "Last-Event-ID", In: "header", Description: "The id of the last event received.", Schema: &openAPISchema{Type: "string"}},
+		},
+		Responses: ok("An event stream.", map[string]openAPIMediaType{"text/event-stream": {Schema: b.ref(Event{})}}),
+	})
+	b.add(http.MethodGet, "/missions/stats", &openAPIOperation{
+		OperationID: "getMissionStats", Summary: "Count missions by status and satellite", Tags: []string{"missions"},
+		Description: "Served from the mission index, which needs MISSION_STREAM_ARN.",
+		Responses:   ok("The counts.", jsonContent(b.ref(MissionStats{}))),
+	})
+	b.add(http.MethodPost, "/cdm", &openAPIOperation{
+		OperationID: "postCDM", Summary: "Store a CCSDS Conjunction Data Message", Tags: []string{"conjunctions"},
+		Description: "Objects are linked to the catalog by NORAD ID. A later message with the same MESSAGE_ID replaces the conjunction.",
+		Parameters: []openAPIParameter{
+			queryParam("propose", "boolean", "Propose a characterization mission when the collision probability is at least CDM_PROPOSAL_PC."),
+		},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"text/plain":      {Schema: &openAPISchema{Type: "string", Description: "The CDM in keyword = value notation."}},
+			"application/xml": {Schema: &openAPISchema{Type: "string", Description: "The CDM in XML."}},
+		}},
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "Replaced.", Content: jsonContent(b.ref(CDMResponse{}))},
+			"201": {Description: "Added.", Content: jsonContent(b.ref(CDMResponse{}))},
+		},
+		Security: adminOnly,
+	})
+	b.add(http.MethodGet, "/conjunctions", &openAPIOperation{
+		OperationID: "listConjunctions", Summary: "List the conjunctions received", Tags: []string{"conjunctions"},
+		Parameters: []openAPIParameter{
+			queryParam("satellite", "string", "Only conjunctions involving this satellite."),
+			queryParam("min_pc", "number", "Only conjunctions at least this probable."),
+		},
+		Responses: ok("The conjunctions, in TCA order.", jsonContent(b.ref(ConjunctionListResponse{}))),
+	})
+	b.add(http.MethodGet, "/conjunction/{id}", &openAPIOperation{
+		OperationID: "getConjunction", Summary: "Get a conjunction", Tags: []string{"conjunctions"},
+		Parameters: []openAPIParameter{pathParam("id", "The CDM's MESSAGE_ID.")},
+		Responses:  ok("The conjunction.", jsonContent(b.ref(Conjunction{}))),
+	})
+	b.add(http.MethodGet, "/satellites", &openAPIOperation{
+		OperationID: "listSatellites", Summary: "List the satellite catalog", Tags: []string{"satellites"},
+		Parameters: []openAPIParameter{queryParam("status", "string", "Only satellites with this status.", openAPISatelliteStatuses...)},
+		Responses:  ok("The satellites, in ID order.", jsonContent(b.ref(SatelliteListResponse{}))),
+	})
+	b.add(http.MethodPost, "/satellites", &openAPIOperation{
+		OperationID: "putSatellite", Summary: "Add or replace a satellite", Tags: []string{"satellites"},
+		Description: "Replaces the satellite with the same ID, which defaults to the NORAD ID. A TLE must be for the NORAD ID. The catalog is shared by every tenant, so with MULTI_TENANT only ADMIN_TOKEN may change it.",
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(satelliteRequest{}))},
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "Replaced.", Content: jsonContent(b.ref(Satellite{}))},
+			"201": {Description: "Added.", Content: jsonContent(b.ref(Satellite{}))},
+		},
+		Security: adminOnly,
+	})
+	b.add(http.MethodGet, "/satellite/{id}", &openAPIOperation{
+		OperationID: "getSatellite", Summary: "Get a satellite", Tags: []string{"satellites"},
+		Parameters: []openAPIParameter{pathParam("id", "Satellite ID.")},
+		Responses:  ok("The satellite.", jsonContent(b.ref(Satellite{}))),
+	})
+	b.add(http.MethodGet, "/satellite/{id}/tle", &openAPIOperation{
+		OperationID: "getSatelliteTLE", Summary: "Get the satellite's element set nearest an epoch", Tags: []string{"satellites"},
+		Parameters: []openAPIParameter{
+			pathParam("id", "Satellite ID."),
+			queryParam("epoch", "string", "Unix time in seconds or RFC 3339 time; defaults to now."),
+		},
+		Responses: ok("The element set.", jsonContent(b.ref(TLERecord{}))),
+	})
+	b.add(http.MethodGet, "/sensors", &openAPIOperation{
+		OperationID: "listSensors", Summary: "List the sensor registry", Tags: []string{"satellites"},
+		Responses: ok("The sensors, in satellite ID order.", jsonContent(b.ref(SensorListResponse{}))),
+	})
+	b.add(http.MethodGet, "/satellite/{id}/sensor", &openAPIOperation{
+		OperationID: "getSensor", Summary: "Get the satellite's sensor", Tags: []string{"satellites"},
+		Parameters: []openAPIParameter{pathParam("id", "Satellite ID.")},
+		Responses:  ok("The sensor, with its derived field of view and plate scale.", jsonContent(b.ref(Sensor{}))),
+	})
+	b.add(http.MethodPut, "/satellite/{id}/sensor", &openAPIOperation{
+		OperationID: "putSensor", Summary: "Register or replace the satellite's sensor", Tags: []string{"satellites"},
+		Description: "The satellite must be in the catalog. fov_deg, ifov_urad and plate_scale_arcsec are derived from the optics. With MULTI_TENANT only ADMIN_TOKEN may change sensors.",
+		Parameters:  []openAPIParameter{pathParam("id", "Satellite ID.")},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(sensorRequest{}))},
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "Replaced.", Content: jsonContent(b.ref(Sensor{}))},
+			"201": {Description: "Registered.", Content: jsonContent(b.ref(Sensor{}))},
+		},
+		Security: adminOnly,
+	})
+	b.add(http.MethodDelete, "/satellite/{id}/sensor", &openAPIOperation{
+		OperationID: "deleteSensor", Summary: "Remove the satellite's sensor", Tags: []string{"satellites"},
+		Description: "With MULTI_TENANT only ADMIN_TOKEN may change sensors.",
+		Parameters:  []openAPIParameter{pathParam("id", "Satellite ID.")},
+		Responses:   map[string]*openAPIResponse{"204": {Description: "Deleted."}},
+		Security:    adminOnly,
+	})
+	b.add(http.MethodGet, "/access-windows", &openAPIOperation{
+		OperationID: "listAccessWindows", Summary: "Find when an observer can image a target", Tags: []string{"satellites"},
+		Description: "Windows are clipped to start and end, and carry the closest approach within them.",
+		Parameters: params([]openAPIParameter{
+			{Name: "observer", In: "query", Description: "Observer satellite ID.", Required: true, Schema: &openAPISchema{Type: "string"}},
+			{Name: "target", In: "query", Description: "Target satellite ID.", Required: true, Schema: &openAPISchema{Type: "string"}},
+			queryParam("start", "string", "Unix time in seconds or RFC 3339 time; defaults to now."),
+			queryParam("end", "string", "Unix time in seconds or RFC 3339 time; defaults to 24 hours after start."),
+			queryParam("step", "string", "Duration such as 30s, or seconds, between samples; defaults to 30s."),
+		}, accessConstraintParams),
+		Responses: ok("The windows, in time order.", jsonContent(b.ref(AccessWindowsResponse{}))),
+	})
+	b.add(http.MethodGet, "/satellite/{id}/groundtrack", &openAPIOperation{
+		OperationID: "getGroundTrack", Summary: "Get the satellite's ground track as GeoJSON", Tags: []string{"satellites"},
+		Description: "A Feature whose geometry is a LineString, or a MultiLineString split at the antimeridian.",
+		Parameters:  params([]openAPIParameter{pathParam("id", "Satellite ID.")}, timeWindowParams),
+		Responses:   ok("The ground track.", map[string]openAPIMediaType{"application/geo+json": {Schema: b.ref(GroundTrack{})}}),
+	})
+	b.add(http.MethodGet, "/satellite/{id}/ephemeris", &openAPIOperation{
+		OperationID: "getEphemeris", Summary: "Propagate the satellite's orbit with SGP4", Tags: []string{"satellites"},
+		Description: "Positions are in km and velocities in km/s, in the TEME frame (eci) and the Earth-fixed frame (ecef).",
+		Parameters: params([]openAPIParameter{pathParam("id", "Satellite ID.")}, timeWindowParams, []openAPIParameter{
+			queryParam("format", "string", "Response format.", "json", "csv"),
+		}),
+		Responses: ok("The samples, from start to end.", map[string]openAPIMediaType{
+			"application/json": {Schema: b.ref(EphemerisResponse{})},
+			"text/csv":         {Schema: &openAPISchema{Type: "string"}},
+		}),
+	})
+	b.add(http.MethodGet, "/satellite/{id}/missions", &openAPIOperation{
+		OperationID: "listSatelliteMissions", Summary: "List the missions a satellite is the target or observer of", Tags: []string{"missions"},
+		Description: "Served from the mission index, which needs MISSION_STREAM_ARN.",
+		Parameters:  []openAPIParameter{pathParam("id", "Satellite ID.")},
+		Responses:   ok("The missions, in ID order.", jsonContent(b.ref(SatelliteMissionsResponse{}))),
+	})
+	b.add(http.MethodGet, "/satellite/{id}/characterization", &openAPIOperation{
+		OperationID: "getCharacterization", Summary: "Summarize what has been collected of a target", Tags: []string{"missions"},
+		Description: "Aggregates the geometry and photometry stored on the images of every mission the satellite is the target of: the best-resolved frames, brightness statistics, and a tumble period from each mission's light curve.",
+		Parameters:  []openAPIParameter{pathParam("id", "Satellite ID.")},
+		Responses:   ok("The summary.", jsonContent(b.ref(TargetCharacterization{}))),
+	})
+	b.add(http.MethodGet, "/mission/{id}", &openAPIOperation{
+		OperationID: "getMission", Summary: "Get a mission", Tags: []string{"missions"},
+		Parameters: []openAPIParameter{missionID, expandParam},
+		Responses:  ok("The mission, expanded for expand=.", jsonContent(&openAPISchema{OneOf: []*openAPISchema{b.ref(Mission{}), b.ref(ExpandedMission{})}})),
+	})
+	b.add(http.MethodPost, "/mission/{id}/invalidate", &openAPIOperation{
+		OperationID: "invalidateMission", Summary: "Drop a mission from the cache", Tags: []string{"missions"},
+		Parameters: []openAPIParameter{missionID},
+		Responses:  map[string]*openAPIResponse{"204": {Description: "Invalidated."}},
+		Security:   adminOnly,
+	})
+	b.add(http.MethodPost, "/mission/{id}/approve", &openAPIOperation{
+		OperationID: "approveMission", Summary: "Approve a proposed or pending mission", Tags: []string{"missions"},
+		Description: "Needs the missions:approve scope, which the approver role grants and the operator role does not. A mission in any other status gets 409 MISSION_STATUS_CONFLICT.",
+		Parameters:  []openAPIParameter{missionID},
+		Responses:   ok("The approved mission.", jsonContent(b.ref(Mission{}))),
+		Security:    adminOnly,
+	})
+	b.add(http.MethodPost, "/mission/{id}/cancel", &openAPIOperation{
+		OperationID: "cancelMission", Summary: "Cancel a mission that has not finished", Tags: []string{"missions"},
+		Description: "Needs the missions:write scope. A completed, failed or already cancelled mission gets 409 MISSION_STATUS_CONFLICT.",
+		Parameters:  []openAPIParameter{missionID},
+		Responses:   ok("The cancelled mission.", jsonContent(b.ref(Mission{}))),
+		Security:    adminOnly,
+	})
+	b.add(http.MethodPost, "/missions/{id}/recompute-geometry", &openAPIOperation{
+		OperationID: "recomputeMissionGeometry", Summary: "Recompute a mission's TCA, minimum range and relative velocity from the latest TLEs", Tags: []string{"missions"},
+		Description: "Searches start to end, or the collection window, or six hours either side of the stored TCA, and scores the collection's feasibility at TCA under the access window constraints.",
+		Parameters: params([]openAPIParameter{missionID,
+			queryParam("start", "string", "Unix time in seconds or RFC 3339 time."),
+			queryParam("end", "string", "Unix time in seconds or RFC 3339 time, at most 7 days after start."),
+			queryParam("reject_infeasible", "boolean", "Answer 422 COLLECTION_INFEASIBLE, storing nothing, when a constraint fails at TCA."),
+		}, accessConstraintParams),
+		Responses: ok("The updated mission and the element sets used.", jsonContent(b.ref(RecomputeGeometryResponse{}))),
+		Security:  adminOnly,
+	})
+	b.add(http.MethodPost, "/schedule/optimize", &openAPIOperation{
+		OperationID: "optimizeSchedule", Summary: "Deconflict missions' collections into a prioritized schedule", Tags: []string{"missions"},
+		Description: "Places the missions greedily by priority within their collection windows, leaving each observer time to slew and settle between targets and holding it to its duty cycle and downlink budget. Each placement is stored as the mission's schedule.",
+		Parameters: []openAPIParameter{
+			queryParam("dry_run", "boolean", "Answer with the schedule without storing it."),
+		},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(scheduleRequest{}))},
+		Responses:   ok("The schedule, and the missions left out.", jsonContent(b.ref(ScheduleResponse{}))),
+		Security:    adminOnly,
+	})
+	b.add(http.MethodGet, "/mission/{id}/pointing-plan", &openAPIOperation{
+		OperationID: "getPointingPlan", Summary: "Pointing profile for the observer to track the target", Tags: []string{"missions"},
+		Description: "Samples the collection window, or start to end. Quaternions rotate TEME to a body frame with +Z on the line of sight and +X toward the orbit normal, scalar last.",
+		Parameters: []openAPIParameter{missionID,
+			queryParam("start", "string", "Unix time in seconds or RFC 3339 time; defaults to the collection window's start."),
+			queryParam("end", "string", "Unix time in seconds or RFC 3339 time; defaults to the collection window's end."),
+			queryParam("step", "string", "Duration such as 30s, or seconds, between samples; defaults to 10s."),
+			queryParam("format", "string", "Response format.", "json", "csv", "aem"),
+		},
+		Responses: ok("The samples, from start to end.", map[string]openAPIMediaType{
+			"application/json": {Schema: b.ref(PointingPlanResponse{})},
+			"text/csv":         {Schema: &openAPISchema{Type: "string"}},
+			"text/plain":       {Schema: &openAPISchema{Type: "string"}},
+		}),
+	})
+	b.add(http.MethodGet, "/mission/{id}/downlinks", &openAPIOperation{
+		OperationID: "getMissionDownlinks", Summary: "List the downlinks of a mission's imagery", Tags: []string{"missions"},
+		Parameters: []openAPIParameter{missionID},
+		Responses:  ok("The downlinks, in pass order, and whether the mission is overdue for one.", jsonContent(b.ref(MissionDownlinksResponse{}))),
+	})
+	b.add(http.MethodPost, "/mission/{id}/downlinks", &openAPIOperation{
+		OperationID: "recordMissionDownlink", Summary: "Record a downlink of a mission's imagery", Tags: []string{"missions"},
+		Parameters:  []openAPIParameter{missionID},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(downlinkRequest{}))},
+		Responses:   map[string]*openAPIResponse{"201": {Description: "Recorded.", Content: jsonContent(b.ref(Downlink{}))}},
+		Security:    adminOnly,
+	})
+	b.add(http.MethodGet, "/downlinks/overdue", &openAPIOperation{
+		OperationID: "listOverdueDownlinks", Summary: "List missions overdue for a downlink", Tags: []string{"missions"},
+		Description: "Missions whose collection window ended more than DOWNLINK_GRACE ago, within the last seven days, with nothing downlinked.",
+		Responses:   ok("The missions, in the order they fell due.", jsonContent(b.ref(OverdueDownlinksResponse{}))),
+	})
+	b.add(http.MethodGet, "/mission/{id}/timeline", &openAPIOperation{
+		OperationID: "getMissionTimeline", Summary: "A mission's events in time order", Tags: []string{"missions"},
+		Description: "Merges the mission's creation, status changes, collection window, TCA, images and downlinks.",
+		Parameters:  []openAPIParameter{missionID},
+		Responses:   ok("The entries, oldest first.", jsonContent(b.ref(MissionTimelineResponse{}))),
+	})
+	b.add(http.MethodGet, "/mission/{id}/detections", &openAPIOperation{
+		OperationID: "getMissionDetections", Summary: "Measured directions to the target for orbit determination", Tags: []string{"missions"},
+		Description: "Collects the detections of the mission's images in time order. The TDM is a CCSDS 503.0-B-2 tracking data message of RA/Dec angles in ICRF.",
+		Parameters:  []openAPIParameter{missionID, queryParam("format", "string", "Response format.", "json", "tdm")},
+		Responses: ok("The detections.", map[string]openAPIMediaType{
+			"application/json": {Schema: b.ref(MissionDetectionsResponse{})},
+			"text/plain":       {Schema: &openAPISchema{Type: "string"}},
+		}),
+	})
+	b.add(http.MethodGet, "/mission/{id}/images", &openAPIOperation{
+		OperationID: "listMissionImages", Summary: "List a mission's images with their metadata", Tags: []string{"missions"},
+		Parameters: []openAPIParameter{
+			missionID,
+			queryParam("minQuality", "number", "Keep only frames scoring at least this, from 0 to 100."),
+			queryParam("maxCloud", "number", "Keep only earth-background frames at most this percent cloud, from 0 to 100."),
+		},
+		Responses: ok("The mission's image records.", jsonContent(b.ref(MissionImagesResponse{}))),
+	})
+	b.add(http.MethodGet, "/mission/{id}/images.zip", &openAPIOperation{
+		OperationID: "getMissionArchive", Summary: "Download a mission's images as a zip", Tags: []string{"missions"},
+		Parameters: params([]openAPIParameter{missionID}, processingParams),
+		Responses:  ok("A zip of the images and a manifest.json.", binaryContent("application/zip")),
+	})
+	b.add(http.MethodGet, "/mission/{id}/contact-sheet", &openAPIOperation{
+		OperationID: "getContactSheet", Summary: "Render a grid of a mission's thumbnails", Tags: []string{"missions"},
+		Parameters: []openAPIParameter{missionID,
+			queryParam("size", "integer", "Thumbnail size in pixels.", openAPIThumbnailSizes...),
+			queryParam("cols", "integer", "Number of columns."),
+			queryParam("format", "string", "Output encoding.", imageFormats...),
+		},
+		Responses: ok("The contact sheet.", binaryContent(imageTypes...)),
+	})
+	b.add(http.MethodGet, "/mission/{id}/timelapse", &openAPIOperation{
+		OperationID: "getTimelapse", Summary: "Animate a mission's images", Tags: []string{"missions"},
+		Parameters: []openAPIParameter{missionID,
+			boundedParam("fps", "Frames per second.", 1, 30),
+			queryParam("format", "string", "Output container.", "gif", "mp4"),
+			boundedParam("width", "Frame width in pixels.", 1, 1024),
+			queryParam("async", "boolean", "Always render as a background job."),
+		},
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The animation.", Content: binaryContent("image/gif", "video/mp4")},
+			"202": accepted["202"],
+		},
+	})
+	b.add(http.MethodGet, "/mission/{id}/lightcurve", &openAPIOperation{
+		OperationID: "getLightCurve", Summary: "Brightness of a mission's target over time", Tags: []string{"missions"},
+		Parameters: params([]openAPIParameter{missionID,
+			queryParam("format", "string", "Response format.", "json", "csv"),
+			queryParam("recompute", "boolean", "Measure every frame instead of using stored photometry."),
+			queryParam("persist", "boolean", "Store new measurements on the image records."),
+		}, photometryParams),
+		Responses: ok("The light curve.", map[string]openAPIMediaType{
+			"application/json": {Schema: b.ref(LightCurveResponse{})},
+			"text/csv":         {Schema: &openAPISchema{Type: "string"}},
+		}),
+	})
+
+	image := &openAPIOperation{
+		OperationID: "getImage", Summary: "Download an image, optionally processed", Tags: []string{"images"},
+		Description: "A URL from POST /image/{id}/signed-url needs no credentials until it expires. format=fits downloads a FITS original as it was uploaded, and takes no other processing parameters.",
+		Parameters: params([]openAPIParameter{imageID}, processingParams, []openAPIParameter{
+			queryParam("exp", "integer", "Expiry of a signed URL, as a unix time."),
+			queryParam("sig", "string", "Signature of a signed URL."),
+			queryParam("tenant", "string", "Tenant a signed URL was issued for, with MULTI_TENANT set."),
+		}),
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The image.", Content: binaryContent(append(slices.Clone(imageTypes), fitsContentType)...)},
+			"206": {Description: "The requested byte range of the image.", Content: binaryContent(imageTypes...)},
+			"304": {Description: "The client's copy is current."},
+		},
+	}
+	b.add(http.MethodGet, "/image/{id}", image)
+	head := *image
+	head.OperationID, head.Summary = "headImage", "Check an image without downloading it"
+	b.add(http.MethodHead, "/image/{id}", &head)
+	b.add(http.MethodPost, "/image/{id}/signed-url", &openAPIOperation{
+		OperationID: "signImageURL", Summary: "Issue an expiring image URL that needs no credentials", Tags: []string{"images"},
+		Description: "For <img> tags and other clients that cannot send Authorization. Anyone holding the URL can fetch the image until it expires.",
+		Parameters:  []openAPIParameter{imageID},
+		RequestBody: &openAPIRequestBody{Content: jsonContent(b.ref(signedURLRequest{}))},
+		Responses:   ok("The signed URL.", jsonContent(b.ref(SignedURLResponse{}))),
+	})
+	b.add(http.MethodGet, "/image/{id}/metadata", &openAPIOperation{
+		OperationID: "getImageMetadata", Summary: "Get an image's dimensions, format and georeferencing", Tags: []string{"images"},
+		Parameters: []openAPIParameter{imageID},
+		Responses:  ok("The metadata.", jsonContent(b.ref(ImageMetadata{}))),
+	})
+	b.add(http.MethodGet, "/image/{id}/photometry", &openAPIOperation{
+		OperationID: "getPhotometry", Summary: "Measure the brightest point source near a position", Tags: []string{"images"},
+		Parameters: params([]openAPIParameter{imageID, queryParam("persist", "boolean", "Store the result on the image record.")}, photometryParams),
+		Responses:  ok("The measurement.", jsonContent(b.ref(Photometry{}))),
+	})
+	b.add(http.MethodGet, "/image/{id}/versions", &openAPIOperation{
+		OperationID: "getImageVersions", Summary: "List the image's ingested versions and their provenance", Tags: []string{"images"},
+		Parameters: []openAPIParameter{imageID},
+		Responses:  ok("The versions, newest first.", jsonContent(b.ref(ImageVersionsResponse{}))),
+	})
+	b.add(http.MethodGet, "/image/{id}/versions/{version}", &openAPIOperation{
+		OperationID: "getImageVersion", Summary: "Download one version of the image as uploaded", Tags: []string{"images"},
+		Description: "Earlier versions can be downloaded only when KEEP_IMAGE_VERSIONS kept a copy of them.",
+		Parameters:  []openAPIParameter{imageID, pathParam("version", "Version number, from 1.")},
+		Responses:   ok("The upload.", binaryContent("application/octet-stream")),
+	})
+	b.add(http.MethodGet, "/image/{id}/geometry", &openAPIOperation{
+		OperationID: "getImageGeometry", Summary: "Compute how the observer saw the target when the image was captured", Tags: []string{"images"},
+		Description: "Propagates the mission's target and observer to the capture time from their element sets nearest it.",
+		Parameters: []openAPIParameter{imageID,
+			queryParam("mission", "string", "Mission whose satellites to use; defaults to the one the image was linked to at ingest."),
+			queryParam("persist", "boolean", "Store the result on the image record."),
+		},
+		Responses: ok("The observation geometry.", jsonContent(b.ref(ObservationGeometry{}))),
+	})
+	b.add(http.MethodPost, "/image/{id}/platesolve", &openAPIOperation{
+		OperationID: "postPlateSolve", Summary: "Plate solve the image's star field", Tags: []string{"images"},
+		Description: "Starts a platesolve job. Its output is the WCS, which is also stored on the image record.",
+		Parameters: []openAPIParameter{imageID,
+			queryParam("ra", "number", "RA in degrees to centre the search on; defaults to the target's direction in the image's observation geometry."),
+			queryParam("dec", "number", "Dec in degrees to centre the search on."),
+			queryParam("radius", "number", "Search radius in degrees."),
+			queryParam("scale_low", "number", "Least plate scale in arcseconds per pixel."),
+			queryParam("scale_high", "number", "Greatest plate scale in arcseconds per pixel."),
+		},
+		Responses: accepted,
+	})
+	b.add(http.MethodGet, "/image/{id}/detections", &openAPIOperation{
+		OperationID: "getImageDetections", Summary: "Get the target directions measured in an image", Tags: []string{"images"},
+		Parameters: []openAPIParameter{imageID},
+		Responses:  ok("The detections.", jsonContent(b.ref(detectionSet{}))),
+	})
+	b.add(http.MethodPut, "/image/{id}/detections", &openAPIOperation{
+		OperationID: "putImageDetections", Summary: "Replace an analyst's detections in an image", Tags: []string{"images"},
+		Description: "Each detection is a pixel, measured through the image's plate solution, or an RA and Dec. The photometry detection is kept.",
+		Parameters:  []openAPIParameter{imageID},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(&openAPISchema{
+			Type:       "object",
+			Properties: map[string]*openAPISchema{"detections": {Type: "array", Items: b.ref(detectionInput{})}},
+			Required:   []string{"detections"},
+		})},
+		Responses: ok("The stored detections.", jsonContent(b.ref(detectionSet{}))),
+	})
+	b.add(http.MethodGet, "/image/{id}/annotations", &openAPIOperation{
+		OperationID: "getAnnotations", Summary: "Get an image's annotations", Tags: []string{"images"},
+		Parameters: []openAPIParameter{imageID},
+		Responses:  ok("The annotations.", jsonContent(b.ref(annotationSet{}))),
+	})
+	b.add(http.MethodPut, "/image/{id}/annotations", &openAPIOperation{
+		OperationID: "putAnnotations", Summary: "Replace an image's annotations", Tags: []string{"images"},
+		Parameters: []openAPIParameter{imageID},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(&openAPISchema{
+			Type:       "object",
+			Properties: map[string]*openAPISchema{"annotations": {Type: "array", Items: b.ref(Annotation{})}},
+			Required:   []string{"annotations"},
+		})},
+		Responses: ok("The stored annotations.", jsonContent(b.ref(annotationSet{}))),
+	})
+	b.add(http.MethodGet, "/image/{id}/thumbnail", &openAPIOperation{
+		OperationID: "getThumbnail", Summary: "Get a JPEG preview", Tags: []string{"images"},
+		Parameters: []openAPIParameter{imageID, queryParam("size", "integer", "Longest side in pixels.", openAPIThumbnailSizes...)},
+		Responses:  ok("The thumbnail.", binaryContent(thumbnailContentType)),
+	})
+	b.add(http.MethodGet, "/image/{id}/tiles", &openAPIOperation{
+		OperationID: "getTileManifest", Summary: "Get the zoom pyramid manifest", Tags: []string{"tiles"},
+		Parameters: []openAPIParameter{imageID},
+		Responses:  ok("The manifest.", jsonContent(b.ref(PyramidManifest{}))),
+	})
+	b.add(http.MethodGet, "/image/{id}/tiles/{z}/{x}/{y}", &openAPIOperation{
+		OperationID: "getTile", Summary: "Get a 256px XYZ tile", Tags: []string{"tiles"},
+		Parameters: []openAPIParameter{imageID,
+			pathParam("z", "Zoom level."),
+			pathParam("x", "Tile column."),
+			pathParam("y", "Tile row, optionally followed by .jpg."),
+		},
+		Responses: ok("The tile.", binaryContent("image/jpeg")),
+	})
+	b.add(http.MethodPost, "/images/{id}/derivatives", &openAPIOperation{
+		OperationID: "postDerivatives", Summary: "Queue thumbnail and pyramid generation", Tags: []string{"images"},
+		Parameters: []openAPIParameter{imageID},
+		Responses: map[string]*openAPIResponse{"202": {Description: "Queued.", Content: jsonContent(&openAPISchema{
+			Type:       "object",
+			Properties: map[string]*openAPISchema{"id": {Type: "string"}, "status": {Type: "string"}},
+		})}},
+	})
+	b.add(http.MethodGet, "/images/diff", &openAPIOperation{
+		OperationID: "getImageDiff", Summary: "Align two images and map their differences", Tags: []string{"images"},
+		Parameters: []openAPIParameter{
+			{Name: "a", In: "query", Description: "First image ID.", Required: true, Schema: &openAPISchema{Type: "string"}},
+			{Name: "b", In: "query", Description: "Second image ID.", Required: true, Schema: &openAPISchema{Type: "string"}},
+			queryParam("output", "string", "A difference image, or only the metrics.", "image", "json"),
+			queryParam("format", "string", "Encoding of the difference image.", imageFormats...),
+		},
+		Responses: ok("The difference map or its metrics.", map[string]openAPIMediaType{
+			"application/json": {Schema: b.ref(DiffMetrics{})},
+			"image/*":          {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+		}),
+	})
+	b.add(http.MethodGet, "/images/similar/{id}", &openAPIOperation{
+		OperationID: "getSimilarImages", Summary: "Find images that look like one", Tags: []string{"images"},
+		Description: "Compares the perceptual hash computed at ingest with every other image's, across missions.",
+		Parameters: []openAPIParameter{
+			imageID,
+			boundedParam("limit", "Most images returned.", 1, maxSimilarLimit),
+			boundedParam("maxDistance", "Most of the 64 hash bits that may differ.", 0, maxSimilarDistance),
+		},
+		Responses: ok("The images, nearest first.", jsonContent(b.ref(SimilarImagesResponse{}))),
+	})
+	b.add(http.MethodGet, "/images/search", &openAPIOperation{
+		OperationID: "getImageSearch", Summary: "Find images covering a region", Tags: []string{"images"},
+		Description: "Searches the footprints read at ingest from GeoTIFF corners or EXIF GPS positions.",
+		Parameters: []openAPIParameter{
+			{Name: "bbox", In: "query", Description: "west,south,east,north in degrees; west above east crosses the antimeridian.", Required: true, Schema: &openAPISchema{Type: "string"}},
+			queryParam("start", "string", "Earliest capture time, unix seconds or RFC 3339."),
+			queryParam("end", "string", "Latest capture time, unix seconds or RFC 3339."),
+			boundedParam("limit", "Most images returned.", 1, maxImageSearchLimit),
+		},
+		Responses: ok("The images, in capture order.", jsonContent(b.ref(ImageSearchResponse{}))),
+	})
+	b.add(http.MethodPost, "/images/stack", &openAPIOperation{
+		OperationID: "postStack", Summary: "Register and stack frames of the same target", Tags: []string{"images"},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(stackRequest{}))},
+		Responses: map[string]*openAPIResponse{
+			"200": {Description: "The stacked image.", Content: binaryContent(imageTypes...)},
+			"202": accepted["202"],
+		},
+	})
+
+	stacSearchParams := []openAPIParameter{
+		queryParam("bbox", "string", "west,south,east,north in degrees; only items placed inside it."),
+		queryParam("datetime", "string", "RFC 3339 time, or interval start/end with '..' for an open end."),
+		boundedParam("limit", "Most items returned.", 1, maxSTACLimit),
+		queryParam("token", "string", "Token of the next page, from the next link."),
+	}
+	geoJSON := func(s *openAPISchema) map[string]openAPIMediaType {
+		return map[string]openAPIMediaType{geoJSONType: {Schema: s}}
+	}
+	b.add(http.MethodGet, "/stac", &openAPIOperation{
+		OperationID: "getSTACCatalog", Summary: "Get the STAC root catalog", Tags: []string{"stac"},
+		Responses: ok("The catalog.", jsonContent(b.ref(STACCatalog{}))),
+	})
+	b.add(http.MethodGet, "/stac/conformance", &openAPIOperation{
+		OperationID: "getSTACConformance", Summary: "List the STAC API conformance classes", Tags: []string{"stac"},
+		Responses: ok("The conformance classes.", jsonContent(b.ref(STACConformance{}))),
+	})
+	b.add(http.MethodGet, "/stac/collections", &openAPIOperation{
+		OperationID: "getSTACCollections", Summary: "List the missions as STAC collections", Tags: []string{"stac"},
+		Responses: ok("The collections.", jsonContent(b.ref(STACCollections{}))),
+	})
+	b.add(http.MethodGet, "/stac/collections/{id}", &openAPIOperation{
+		OperationID: "getSTACCollection", Summary: "Get a mission as a STAC collection", Tags: []string{"stac"},
+		Parameters: []openAPIParameter{missionID},
+		Responses:  ok("The collection.", jsonContent(b.ref(STACCollection{}))),
+	})
+	b.add(http.MethodGet, "/stac/collections/{id}/items", &openAPIOperation{
+		OperationID: "getSTACItems", Summary: "List a mission's images as STAC items", Tags: []string{"stac"},
+		Parameters: params([]openAPIParameter{missionID}, stacSearchParams),
+		Responses:  ok("A page of items.", geoJSON(b.ref(STACItemCollection{}))),
+	})
+	b.add(http.MethodGet, "/stac/collections/{id}/items/{itemId}", &openAPIOperation{
+		OperationID: "getSTACItem", Summary: "Get an image as a STAC item", Tags: []string{"stac"},
+		Parameters: []openAPIParameter{missionID, pathParam("itemId", "Image ID.")},
+		Responses:  ok("The item.", geoJSON(b.ref(STACItem{}))),
+	})
+	b.add(http.MethodGet, "/stac/search", &openAPIOperation{
+		OperationID: "getSTACSearch", Summary: "Search the images of every mission", Tags: []string{"stac"},
+		Parameters: params(stacSearchParams, []openAPIParameter{
+			queryParam("collections", "string", "Comma-separated mission IDs."),
+			queryParam("ids", "string", "Comma-separated image IDs."),
+		}),
+		Responses: ok("A page of items.", geoJSON(b.ref(STACItemCollection{}))),
+	})
+	b.add(http.MethodPost, "/stac/search", &openAPIOperation{
+		OperationID: "postSTACSearch", Summary: "Search the images of every mission", Tags: []string{"stac"},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(stacSearch{}))},
+		Responses:   ok("A page of items.", geoJSON(b.ref(STACItemCollection{}))),
+	})
+
+	b.add(http.MethodGet, "/jobs", &openAPIOperation{
+		OperationID: "listJobs", Summary: "List background jobs, newest first", Tags: []string{"jobs"},
+		Parameters: []openAPIParameter{
+			queryParam("type", "string", "Only jobs of this type."),
+			queryParam("status", "string", "Only jobs in this state.", jobStatuses...),
+			boundedParam("limit", "Page size.", 1, maxJobList),
+			queryParam("nextToken", "string", "Token from the previous page."),
+		},
+		Responses: ok("A page of jobs.", jsonContent(b.ref(JobListResponse{}))),
+	})
+	spec := &openAPISchema{Type: "object", Description: "Query parameters of GET /image/{id}.", Properties: map[string]*openAPISchema{}}
+	for _, p := range processingParams {
+		spec.Properties[p.Name] = p.Schema
+	}
+	b.add(http.MethodPost, "/jobs/process", &openAPIOperation{
+		OperationID: "postProcessJob", Summary: "Process a list of images with one spec", Tags: []string{"jobs"},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(&openAPISchema{
+			Type:       "object",
+			Properties: map[string]*openAPISchema{"ids": {Type: "array", Items: &openAPISchema{Type: "string"}}, "spec": spec},
+			Required:   []string{"ids"},
+		})},
+		Responses: accepted,
+	})
+	b.add(http.MethodGet, "/jobs/{id}", &openAPIOperation{
+		OperationID: "getJob", Summary: "Get a job's status and progress", Tags: []string{"jobs"},
+		Parameters: []openAPIParameter{jobID},
+		Responses:  ok("The job.", jsonContent(b.ref(Job{}))),
+	})
+	b.add(http.MethodGet, "/jobs/{id}/output", &openAPIOperation{
+		OperationID: "getJobOutput", Summary: "Download a finished job's output", Tags: []string{"jobs"},
+		Parameters: []openAPIParameter{jobID},
+		Responses:  ok("The output, with the job's content type.", binaryContent("application/octet-stream")),
+	})
+
+	b.add(http.MethodPost, "/webhooks", &openAPIOperation{
+		OperationID: "createWebhook", Summary: "Register a webhook", Tags: []string{"webhooks"},
+		Description: "Events are POSTed to the URL as JSON, signed in the X-Sat-Signature header. " +
+			"The response holds the signing secret, generated when none is given; it is not shown again.",
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(webhookRequest{}))},
+		Responses:   map[string]*openAPIResponse{"201": {Description: "The webhook, with its secret.", Content: jsonContent(b.ref(Webhook{}))}},
+		Security:    adminOnly,
+	})
+	b.add(http.MethodGet, "/webhooks", &openAPIOperation{
+		OperationID: "listWebhooks", Summary: "List webhooks", Tags: []string{"webhooks"},
+		Responses: ok("The webhooks, without their secrets.", jsonContent(b.ref(WebhookListResponse{}))),
+		Security:  adminOnly,
+	})
+	b.add(http.MethodGet, "/webhooks/{id}", &openAPIOperation{
+		OperationID: "getWebhook", Summary: "Get a webhook", Tags: []string{"webhooks"},
+		Parameters: []openAPIParameter{webhookID},
+		Responses:  ok("The webhook, without its secret.", jsonContent(b.ref(Webhook{}))),
+		Security:   adminOnly,
+	})
+	b.add(http.MethodDelete, "/webhooks/{id}", &openAPIOperation{
+		OperationID: "deleteWebhook", Summary: "Delete a webhook", Tags: []string{"webhooks"},
+		Parameters: []openAPIParameter{webhookID},
+		Responses:  map[string]*openAPIResponse{"204": {Description: "Deleted."}},
+		Security:   adminOnly,
+	})
+	b.add(http.MethodGet, "/webhooks/{id}/deliveries", &openAPIOperation{
+		OperationID: "listWebhookDeliveries", Summary: "List a webhook's recent deliveries", Tags: []string{"webhooks"},
+		Parameters: []openAPIParameter{webhookID},
+		Responses:  ok("The deliveries made by this instance, newest first.", jsonContent(b.ref(WebhookDeliveriesResponse{}))),
+		Security:   adminOnly,
+	})
+
+	b.add(http.MethodPost, "/api-keys", &openAPIOperation{
+		OperationID: "createAPIKey", Summary: "Create an API key", Tags: []string{"api-keys"},
+		Description: "The response holds the key, which is not shown again. Send it as a bearer token.",
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(apiKeyRequest{}))},
+		Responses:   map[string]*openAPIResponse{"201": {Description: "The API key, with the key itself.", Content: jsonContent(b.ref(APIKey{}))}},
+		Security:    adminOnly,
+	})
+	b.add(http.MethodGet, "/api-keys", &openAPIOperation{
+		OperationID: "listAPIKeys", Summary: "List API keys", Tags: []string{"api-keys"},
+		Responses: ok("The API keys, oldest first.", jsonContent(b.ref(APIKeyListResponse{}))),
+		Security:  adminOnly,
+	})
+	b.add(http.MethodGet, "/api-keys/{id}", &openAPIOperation{
+		OperationID: "getAPIKey", Summary: "Get an API key", Tags: []string{"api-keys"},
+		Parameters: []openAPIParameter{apiKeyID},
+		Responses:  ok("The API key, without the key itself.", jsonContent(b.ref(APIKey{}))),
+		Security:   adminOnly,
+	})
+	b.add(http.MethodDelete, "/api-keys/{id}", &openAPIOperation{
+		OperationID: "deleteAPIKey", Summary: "Revoke an API key", Tags: []string{"api-keys"},
+		Parameters: []openAPIParameter{apiKeyID},
+		Responses:  map[string]*openAPIResponse{"204": {Description: "Revoked."}},
+		Security:   adminOnly,
+	})
+
+	b.add(http.MethodGet, "/roles", &openAPIOperation{
+		OperationID: "listRoles", Summary: "List roles and the scopes they grant", Tags: []string{"roles"},
+		Responses: ok("The roles.", jsonContent(b.ref(RoleListResponse{}))),
+		Security:  adminOnly,
+	})
+	b.add(http.MethodGet, "/role-assignments", &openAPIOperation{
+		OperationID: "listRoleAssignments", Summary: "List role assignments", Tags: []string{"roles"},
+		Responses: ok("The assignments, by subject.", jsonContent(b.ref(RoleAssignmentListResponse{}))),
+		Security:  adminOnly,
+	})
+	b.add(http.MethodGet, "/role-assignments/{subject}", &openAPIOperation{
+		OperationID: "getRoleAssignment", Summary: "Get the roles assigned to a subject", Tags: []string{"roles"},
+		Parameters: []openAPIParameter{roleSubject},
+		Responses:  ok("The assignment.", jsonContent(b.ref(RoleAssignment{}))),
+		Security:   adminOnly,
+	})
+	b.add(http.MethodPut, "/role-assignments/{subject}", &openAPIOperation{
+		OperationID: "putRoleAssignment", Summary: "Assign roles to a subject", Tags: []string{"roles"},
+		Description: "Replaces the subject's roles. The subject need not have authenticated yet.",
+		Parameters:  []openAPIParameter{roleSubject},
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(b.ref(roleAssignmentRequest{}))},
+		Responses:   ok("The assignment.", jsonContent(b.ref(RoleAssignment{}))),
+		Security:    adminOnly,
+	})
+	b.add(http.MethodDelete, "/role-assignments/{subject}", &openAPIOperation{
+		OperationID: "deleteRoleAssignment", Summary: "Remove a subject's roles", Tags: []string{"roles"},
+		Parameters: []openAPIParameter{roleSubject},
+		Responses:  map[string]*openAPIResponse{"204": {Description: "Removed."}},
+		Security:   adminOnly,
+	})
+	return b.doc
+}
+
+// openAPIHandler serves the spec, encoded once.
+func openAPIHandler() gin.HandlerFunc {
+	body, err := json.MarshalIndent(newOpenAPISpec(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Data(http.StatusOK, "application/json", body)
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN, pointed at /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Satellite image server API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func getSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	(&API{Config: &Config{}}).routesV1(router, routeTimeout(0), routeTimeout(0))
+	doc := newOpenAPISpec()
+
+	ops := 0
+	for _, item := range doc.Paths {
+		for _, op := range []*openAPIOperation{item.Get, item.Head, item.Post, item.Put, item.Delete} {
+			if op != nil {
+				ops++
+			}
+		}
+	}
+	routes := router.Routes()
+	if ops != len(routes) {
+		t.Errorf("spec has %d operations for %d routes", ops, len(routes))
+	}
+	for _, r := range routes {
+		path := ginParam.ReplaceAllString(r.Path, "{$1}")
+		item := doc.Paths[path]
+		if item == nil {
+			t.Errorf("%s %s: path %s missing from the spec", r.Method, r.Path, path)
+			continue
+		}
+		op := map[string]*openAPIOperation{
+			http.MethodGet: item.Get, http.MethodHead: item.Head, http.MethodPost: item.Post, http.MethodPut: item.Put,
+			http.MethodDelete: item.Delete,
+		}[r.Method]
+		if op == nil {
+			t.Errorf("%s %s has no operation", r.Method, path)
+			continue
+		}
+		for _, name := range ginParam.FindAllStringSubmatch(r.Path, -1) {
+			found := false
+			for _, p := range op.Parameters {
+				found = found || (p.In == "path" && p.Name == name[1])
+			}
+			if !found {
+				t.Errorf("%s %s does not declare path parameter %s", r.Method, path, name[1])
+			}
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/openapi.json", openAPIHandler())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	var doc openAPIDoc
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Servers[0].URL != apiV1Prefix {
+		t.Errorf("openapi %q, servers %+v", doc.OpenAPI, doc.Servers)
+	}
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/(\w+)"`).FindAllStringSubmatch(w.Body.String(), -1) {
+		if doc.Components.Schemas[ref[1]] == nil {
+			t.Errorf("dangling reference to %s", ref[1])
+		}
+	}
+
+	mission := doc.Components.Schemas["Mission"]
+	if mission == nil || mission.Properties["image_ids"].Type != "array" || !strings.Contains(strings.Join(mission.Required, ","), "target_satellite_id") {
+		t.Errorf("Mission schema = %+v", mission)
+	}
+	if job := doc.Components.Schemas["Job"]; job.Properties["version"] != nil || job.Properties["params"] == nil {
+		t.Errorf("Job schema should follow its json tags: %+v", job.Properties)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+)
+
+const (
+	maxOverlayText = 120
+	// overlayTargetSide is the image size at which banner text is drawn at
+	// 1x; larger images scale the glyphs up by whole multiples.
+	overlayTargetSide = 600
+)
+
+// overlayPositions lists the accepted positions. Banner positions draw a
+// full-width strip; the rest draw a free-standing watermark.
+var overlayPositions = map[string]bool{
+	"top": true, "bottom": true, "top-bottom": true,
+	"top-left": true, "top-right": true, "bottom-left": true, "bottom-right": true, "center": true,
+}
+
+// OverlaySpec describes a watermark or classification banner stamped onto
+// processed output.
+type OverlaySpec struct {
+	Text       string
+	Position   string
+	Opacity    float64
+	Color      color.NRGBA
+	Background color.NRGBA
+	// Locked rejects per-request overrides, for deployments where the
+	// banner is a marking requirement rather than a preference.
+	Locked bool
+}
+
+// newOverlaySpec returns the default styling: white text on solid black
+// strips across the top and bottom.
+func newOverlaySpec() *OverlaySpec {
+	return &OverlaySpec{
+		Position:   "top-bottom",
+		Opacity:    1,
+		Color:      color.NRGBA{0xff, 0xff, 0xff, 0xff},
+		Background: color.NRGBA{0x00, 0x00, 0x00, 0xff},
+	}
+}
+
+// variant names the look of o in the keys of stored derivatives that carry
+// it, so marked and unmarked copies, or copies under an older banner, are
+// never served for one another. It is empty for a nil spec.
+func (o *OverlaySpec) variant() string {
+	if o == nil {
+		return ""
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%q %s %g %v %v", o.Text, o.Position, o.Opacity, o.Color, o.Background))
+	return "marked-" + hex.EncodeToString(sum[:4])
+}
+
+func (o *OverlaySpec) isBanner() bool {
+	return o.Position == "top" || o.Position == "bottom" || o.Position == "top-bottom"
+}
+
+// loadOverlayConfig reads the server-wide overlay from the environment. It
+// returns nil when OVERLAY_TEXT is unset.
+func loadOverlayConfig() (*OverlaySpec, []error) {
+	text := os.Getenv("OVERLAY_TEXT")
+	if text == "" {
+		return nil, nil
+	}
+
+	var errs []error
+	spec := newOverlaySpec()
+	if err := spec.apply(text, os.Getenv("OVERLAY_POSITION"), os.Getenv("OVERLAY_OPACITY")); err != nil {
+		errs = append(errs, fmt.Errorf("invalid overlay configuration: %w", err))
+	}
+	for _, c := range []struct {
+		name string
+		dst  *color.NRGBA
+	}{
+		{"OVERLAY_COLOR", &spec.Color},
+		{"OVERLAY_BACKGROUND", &spec.Background},
+	} {
+		if s := os.Getenv(c.name); s != "" {
+			v, err := parseHexColor(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+			}
+			*c.dst = v
+		}
+	}
+	if s := os.Getenv("OVERLAY_LOCKED"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OVERLAY_LOCKED %q is not a boolean", s))
+		}
+		spec.Locked = b
+	}
+	return spec, errs
+}
+
+// apply sets any non-empty value on the spec.
+func (o *OverlaySpec) apply(text, position, opacity string) error {
+	if text != "" {
+		if len(text) > maxOverlayText {
+			return errors.New("Invalid 'overlay' parameter. Must be at most 120 characters.")
+		}
+		o.Text = text
+	}
+	if position != "" {
+		if !overlayPositions[position] {
+			return errors.New("Invalid 'overlay_position' parameter. Must be one of top, bottom, top-bottom, top-left, top-right, bottom-left, bottom-right, center.")
+		}
+		o.Position = position
+	}
+	if opacity != "" {
+		v, err := strconv.ParseFloat(opacity, 64)
+		if err != nil || v < 0 || v > 1 {
+			return errors.New("Invalid 'overlay_opacity' parameter. Must be a number between 0 and 1.")
+		}
+		o.Opacity = v
+	}
+	return nil
+}
+
+// parseOverlay combines the server default with the request's overlay,
+// overlay_position and overlay_opacity parameters. overlay=none turns the
+// overlay off. It returns nil when nothing should be drawn.
+func parseOverlay(defaults *OverlaySpec, text, position, opacity string) (*OverlaySpec, error) {
+	overridden := text != "" || position != "" || opacity != ""
+	if defaults != nil && defaults.Locked {
+		if overridden {
+			return nil, errors.New("The overlay is fixed by server configuration and cannot be changed per request.")
+		}
+		return defaults, nil
+	}
+	if text == "none" {
+		return nil, nil
+	}
+
+	spec := newOverlaySpec()
+	if defaults != nil {
+		*spec = *defaults
+	}
+	if err := spec.apply(text, position, opacity); err != nil {
+		return nil, err
+	}
+	if spec.Text == "" || spec.Opacity == 0 {
+		return nil, nil
+	}
+	return spec, nil
+}
+
+// drawOverlay stamps o onto img. Text is scaled by whole multiples of the
+// 7x13 bitmap font so it stays crisp and readable on large captures.
+func drawOverlay(img image.Image, o *OverlaySpec) *image.NRGBA {
+	dst := imaging.Clone(img)
+	b := dst.Bounds()
+
+	face := basicfont.Face7x13
+	text := o.Text
+	scale := max(1, min(b.Dx(), b.Dy())/overlayTargetSide)
+	for scale > 1 && len(text)*face.Advance*scale > b.Dx() {
+		scale--
+	}
+	text = truncate(text, b.Dx()/face.Advance)
+
+	glyphs := overlayGlyphs(text, scale)
+	gw, gh := glyphs.Bounds().Dx(), glyphs.Bounds().Dy()
+	pad := 2 * scale
+	margin := 4 * scale
+
+	fg := withOpacity(o.Color, o.Opacity)
+	bg := image.NewUniform(withOpacity(o.Background, o.Opacity))
+	stamp := func(x, y int) {
+		draw.DrawMask(dst, image.Rect(x, y, x+gw, y+gh), image.NewUniform(fg), image.Point{}, glyphs, image.Point{}, draw.Over)
+	}
+
+	if o.isBanner() {
+		stripH := gh + 2*pad
+		var strips []int
+		if o.Position != "bottom" {
+			strips = append(strips, 0)
+		}
+		if o.Position != "top" {
+			strips = append(strips, b.Dy()-stripH)
+		}
+		for _, y := range strips {
+			draw.Draw(dst, image.Rect(0, y, b.Dx(), y+stripH), bg, image.Point{}, draw.Over)
+			stamp((b.Dx()-gw)/2, y+pad)
+		}
+		return dst
+	}
+
+	var x, y int
+	switch {
+	case o.Position == "center":
+		x, y = (b.Dx()-gw)/2, (b.Dy()-gh)/2
+	default:
+		x, y = margin, margin
+		if strings.HasSuffix(o.Position, "right") {
+			x = b.Dx() - gw - margin
+		}
+		if strings.HasPrefix(o.Position, "bottom") {
+			y = b.Dy() - gh - margin
+		}
+	}
+	// Watermarks sit on a backing box of their own so they read on both
+	// bright and dark scenes.
+	draw.Draw(dst, image.Rect(x-pad, y-pad, x+gw+pad, y+gh+pad), bg, image.Point{}, draw.Over)
+	stamp(x, y)
+	return dst
+}
+
+// overlayGlyphs renders text as an alpha mask, enlarged by scale with
+// nearest-neighbour sampling.
+func overlayGlyphs(text string, scale int) *image.Alpha {
+	face := basicfont.Face7x13
+	small := image.NewAlpha(image.Rect(0, 0, len(text)*face.Advance, face.Height))
+	d := &font.Drawer{Dst: small, Src: image.Opaque, Face: face}
+	drawCaption(d, text, 0, face.Ascent)
+
+	if scale == 1 {
+		return small
+	}
+	sb := small.Bounds()
+	big := image.NewAlpha(image.Rect(0, 0, sb.Dx()*scale, sb.Dy()*scale))
+	for y := 0; y < big.Rect.Dy(); y++ {
+		for x := 0; x < big.Rect.Dx(); x++ {
+			big.Pix[y*big.Stride+x] = small.Pix[(y/scale)*small.Stride+x/scale]
+		}
+	}
+	return big
+}
+
+func withOpacity(c color.NRGBA, opacity float64) color.NRGBA {
+	c.A = uint8(float64(c.A) * opacity)
+	return c
+}
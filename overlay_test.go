@@ -0,0 +1,113 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseOverlay(t *testing.T) {
+	server := newOverlaySpec()
+	server.Text = "UNCLASSIFIED"
+	locked := *server
+	locked.Locked = true
+
+	tests := []struct {
+		name                    string
+		defaults                *OverlaySpec
+		text, position, opacity string
+		wantText, wantPosition  string
+		wantOpacity             float64
+		wantNil, wantErr        bool
+	}{
+		{name: "no overlay", wantNil: true},
+		{name: "server default", defaults: server, wantText: "UNCLASSIFIED", wantPosition: "top-bottom", wantOpacity: 1},
+		{name: "request text", text: "draft", wantText: "draft", wantPosition: "top-bottom", wantOpacity: 1},
+		{name: "restyled default", defaults: server, position: "bottom-right", opacity: "0.5", wantText: "UNCLASSIFIED", wantPosition: "bottom-right", wantOpacity: 0.5},
+		{name: "turned off", defaults: server, text: "none", wantNil: true},
+		{name: "transparent", text: "draft", opacity: "0", wantNil: true},
+		{name: "position alone", position: "center", wantNil: true},
+		{name: "locked", defaults: &locked, wantText: "UNCLASSIFIED", wantPosition: "top-bottom", wantOpacity: 1},
+		{name: "locked override", defaults: &locked, text: "none", wantErr: true},
+		{name: "bad position", text: "x", position: "middle", wantErr: true},
+		{name: "bad opacity", text: "x", opacity: "2", wantErr: true},
+		{name: "long text", text: string(make([]byte, maxOverlayText+1)), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOverlay(tt.defaults, tt.text, tt.position, tt.opacity)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if (got == nil) != tt.wantNil {
+			t.Errorf("%s: %+v", tt.name, got)
+			continue
+		}
+		if got != nil && (got.Text != tt.wantText || got.Position != tt.wantPosition || got.Opacity != tt.wantOpacity) {
+			t.Errorf("%s: %+v", tt.name, got)
+		}
+	}
+	if server.Text != "UNCLASSIFIED" || server.Position != "top-bottom" {
+		t.Errorf("server default changed: %+v", server)
+	}
+}
+
+func TestOverlayVariant(t *testing.T) {
+	a := &OverlaySpec{Text: "SECRET", Position: "top", Opacity: 1}
+	b := *a
+	b.Position = "bottom"
+	if (*OverlaySpec)(nil).variant() != "" || a.variant() == b.variant() || a.variant() != (&OverlaySpec{Text: "SECRET", Position: "top", Opacity: 1}).variant() {
+		t.Errorf("variants %q %q", a.variant(), b.variant())
+	}
+}
+
+func TestDrawOverlay(t *testing.T) {
+	gray := color.NRGBA{0x80, 0x80, 0x80, 0xff}
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for i := 0; i < len(src.Pix); i += 4 {
+		copy(src.Pix[i:], []uint8{0x80, 0x80, 0x80, 0xff})
+	}
+	black := color.NRGBA{0, 0, 0, 0xff}
+
+	banner := newOverlaySpec()
+	banner.Text = "SECRET"
+	out := drawOverlay(src, banner)
+	// Both strips are black behind the text; the middle is untouched.
+	for _, tt := range []struct {
+		name string
+		y    int
+		want color.NRGBA
+	}{
+		{"top strip", 0, black},
+		{"bottom strip", 99, black},
+		{"middle", 50, gray},
+	} {
+		if got := out.NRGBAAt(1, tt.y); got != tt.want {
+			t.Errorf("banner %s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	white := 0
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 200; x++ {
+			if out.NRGBAAt(x, y) == (color.NRGBA{0xff, 0xff, 0xff, 0xff}) {
+				white++
+			}
+		}
+	}
+	if white == 0 {
+		t.Error("no banner text drawn")
+	}
+
+	// A half-opaque corner watermark blends its box with the image.
+	mark := &OverlaySpec{Text: "X", Position: "bottom-right", Opacity: 0.5, Color: color.NRGBA{0xff, 0xff, 0xff, 0xff}, Background: black}
+	out = drawOverlay(src, mark)
+	if got := out.NRGBAAt(1, 1); got != gray {
+		t.Errorf("top-left corner %v", got)
+	}
+	if got := out.NRGBAAt(199-4, 99-4); got.R < 0x38 || got.R > 0x48 {
+		t.Errorf("watermark box %v, want about half of %v", got, gray)
+	}
+}
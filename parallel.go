@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"runtime"
+	"sync"
+)
+
+// minStripRows keeps strips large enough that goroutine start-up stays
+// negligible next to the per-row work.
+const minStripRows = 32
+
+// parallelRows splits the rows [0, h) into contiguous strips, one per core,
+// and calls fn(y0, y1) for each strip concurrently. It returns when every
+// strip is done. Images too short to split are handled inline.
+func parallelRows(h int, fn func(y0, y1 int)) {
+	strips := min(runtime.GOMAXPROCS(0), h/minStripRows)
+	if strips <= 1 {
+		fn(0, h)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < strips; i++ {
+		y0, y1 := h*i/strips, h*(i+1)/strips
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(y0, y1)
+		}()
+	}
+	wg.Wait()
+}
+
+// drawParallel is draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min,
+// draw.Src) split into strips, for the full-image pixel format conversions
+// that would otherwise run on one core.
+func drawParallel(dst draw.Image, src image.Image) {
+	b, sp := dst.Bounds(), src.Bounds().Min
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		r := image.Rect(b.Min.X, b.Min.Y+y0, b.Max.X, b.Min.Y+y1)
+		draw.Draw(dst, r, src, sp.Add(image.Pt(0, y0)), draw.Src)
+	})
+}
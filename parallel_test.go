@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestParallelRows(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for _, h := range []int{0, 1, minStripRows - 1, 2 * minStripRows, 1001} {
+		var mu sync.Mutex
+		seen := make([]int, h)
+		strips := 0
+		parallelRows(h, func(y0, y1 int) {
+			mu.Lock()
+			defer mu.Unlock()
+			strips++
+			for y := y0; y < y1; y++ {
+				seen[y]++
+			}
+		})
+		for y, n := range seen {
+			if n != 1 {
+				t.Errorf("h=%d: row %d visited %d times", h, y, n)
+			}
+		}
+		if want := max(min(4, h/minStripRows), 1); strips != want {
+			t.Errorf("h=%d: %d strips, want %d", h, strips, want)
+		}
+	}
+}
+
+func TestDrawParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	src := testStarField(300, 200, 3, 30000, [2]float64{100, 50})
+	src.Rect = src.Rect.Add(image.Pt(-7, 11))
+	dst, want := image.NewNRGBA64(image.Rect(5, 5, 305, 205)), image.NewNRGBA64(image.Rect(5, 5, 305, 205))
+	drawParallel(dst, src)
+	draw.Draw(want, want.Bounds(), src, src.Bounds().Min, draw.Src)
+	if !reflect.DeepEqual(dst.Pix, want.Pix) {
+		t.Error("drawParallel differs from draw.Draw")
+	}
+}
+
+// The strip-parallel stages give the same pixels whatever the core count.
+func TestParallelStagesMatchSerial(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	gray := testStarField(256, 200, 2, 20000, [2]float64{60, 40}, [2]float64{200, 150})
+	gray.Rect = gray.Rect.Add(image.Pt(3, -9))
+	rgba := image.NewNRGBA64(image.Rect(0, 0, 256, 200))
+	draw.Draw(rgba, rgba.Bounds(), gray, gray.Bounds().Min, draw.Src)
+	scale := &RadiometricScale{Mode: "percentile", Lo: 2, Hi: 98}
+	stars := &StarSuppress{Cell: 32, Sigma: 3}
+
+	for _, src := range []image.Image{gray, rgba} {
+		stages := map[string]func() image.Image{
+			"scale": func() image.Image { return applyScale(src, scale) },
+			"stars": func() image.Image { return applyStarSuppress(src, stars) },
+		}
+		for name, stage := range stages {
+			runtime.GOMAXPROCS(1)
+			serial := stage()
+			runtime.GOMAXPROCS(4)
+			parallel := stage()
+			if !reflect.DeepEqual(serial, parallel) {
+				t.Errorf("%s of %T differs across strips", name, src)
+			}
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPhotometrySearch = 50
+	maxPhotometrySearch     = 500
+	minAperture             = 3
+	maxAperture             = 30
+	// A source must peak this many background sigmas above the sky.
+	detectionSigma = 5
+	// fwhmToAperture sizes the aperture from the measured FWHM; 1.5x keeps
+	// almost all the flux of a Gaussian profile.
+	fwhmToAperture = 1.5
+	sigmaToFWHM    = 2.3548
+)
+
+var (
+	errNoSource    = errors.New("no point source found near the hinted location")
+	errHintOutside = errors.New("hint lies outside the image")
+)
+
+// Photometry is a measurement of one point source. Positions are
+// full-resolution pixel coordinates; brightness values are in the source's
+// native sample units (0–255 or 0–65535).
+type Photometry struct {
+	X           float64 `dynamodbav:"x" json:"x"`
+	Y           float64 `dynamodbav:"y" json:"y"`
+	FWHM        float64 `dynamodbav:"fwhm" json:"fwhm"`
+	Flux        float64 `dynamodbav:"flux" json:"flux"`
+	SNR         float64 `dynamodbav:"snr" json:"snr"`
+	Peak        float64 `dynamodbav:"peak" json:"peak"`
+	Background  float64 `dynamodbav:"background" json:"background"`
+	Aperture    float64 `dynamodbav:"aperture" json:"aperture"`
+	Saturated   bool    `dynamodbav:"saturated" json:"saturated"`
+	CaptureTime int64   `dynamodbav:"capture_time" json:"capture_time"`
+	Measured    int64   `dynamodbav:"measured" json:"measured"`
+}
+
+// samplePlane is a luminance window into the source in native units.
+type samplePlane struct {
+	rect image.Rectangle
+	pix  []float64
+	max  float64
+}
+
+func extractSamples(img image.Image, r image.Rectangle) samplePlane {
+	r = r.Intersect(img.Bounds())
+	p := samplePlane{rect: r, pix: make([]float64, 0, r.Dx()*r.Dy()), max: 255}
+	div := 257.0
+	if isHighBitDepth(img) {
+		p.max, div = 65535, 1
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p.pix = append(p.pix, float64(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)/div)
+		}
+	}
+	return p
+}
+
+func (p samplePlane) at(x, y int) (float64, bool) {
+	if !image.Pt(x, y).In(p.rect) {
+		return 0, false
+	}
+	return p.pix[(y-p.rect.Min.Y)*p.rect.Dx()+(x-p.rect.Min.X)], true
+}
+
+// measurePhotometry finds the brightest compact source within search pixels
+// of hint and measures it with aperture photometry. The background is the
+// median of an annulus around the source, and the aperture is resized from
+// the measured FWHM over a few iterations.
+func measurePhotometry(img image.Image, hint image.Point, search int) (*Photometry, error) {
+	margin := search + 3*maxAperture + 1
+	p := extractSamples(img, image.Rect(hint.X-margin, hint.Y-margin, hint.X+margin+1, hint.Y+margin+1))
+
+	// Peak of a 3x3 box average, so a single hot pixel does not win.
+	var peak image.Point
+	best := math.Inf(-1)
+	win := image.Rect(hint.X-search, hint.Y-search, hint.X+search+1, hint.Y+search+1).Intersect(p.rect)
+	for y := win.Min.Y; y < win.Max.Y; y++ {
+		for x := win.Min.X; x < win.Max.X; x++ {
+			var sum float64
+			var n int
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if v, ok := p.at(x+dx, y+dy); ok {
+						sum += v
+						n++
+					}
+				}
+			}
+			if avg := sum / float64(n); avg > best {
+				best, peak = avg, image.Pt(x, y)
+			}
+		}
+	}
+	if math.IsInf(best, -1) {
+		return nil, errNoSource
+	}
+
+	cx, cy := float64(peak.X), float64(peak.Y)
+	aperture := 5.0
+	var m Photometry
+	for iter := 0; iter < 4; iter++ {
+		bg, bgSigma := annulusBackground(p, cx, cy, 2*aperture, 3*aperture+5)
+
+		var sw, sx, sy, sr2, flux, peakV float64
+		var n int
+		r := int(math.Ceil(aperture))
+		for y := int(cy) - r; y <= int(cy)+r; y++ {
+			for x := int(cx) - r; x <= int(cx)+r; x++ {
+				dx, dy := float64(x)-cx, float64(y)-cy
+				if dx*dx+dy*dy > aperture*aperture {
+					continue
+				}
+				v, ok := p.at(x, y)
+				if !ok {
+					continue
+				}
+				flux += v - bg
+				n++
+				peakV = max(peakV, v)
+				if w := v - bg; w > 0 {
+					sw += w
+					sx += w * float64(x)
+					sy += w * float64(y)
+					sr2 += w * (dx*dx + dy*dy)
+				}
+			}
+		}
+		if sw == 0 || peakV-bg < detectionSigma*bgSigma {
+			return nil, errNoSource
+		}
+
+		m = Photometry{
+			X:          sx / sw,
+			Y:          sy / sw,
+			FWHM:       sigmaToFWHM * math.Sqrt(sr2/(2*sw)),
+			Flux:       flux,
+			Peak:       peakV,
+			Background: bg,
+			Aperture:   aperture,
+			Saturated:  peakV >= p.max,
+		}
+		if bgSigma > 0 {
+			m.SNR = flux / (bgSigma * math.Sqrt(float64(n)))
+		}
+
+		cx, cy = m.X, m.Y
+		aperture = math.Max(minAperture, math.Min(maxAperture, fwhmToAperture*m.FWHM))
+	}
+
+	round := func(v, scale float64) float64 { return math.Round(v*scale) / scale }
+	m.X, m.Y = round(m.X, 1000), round(m.Y, 1000)
+	m.FWHM, m.Aperture = round(m.FWHM, 1000), round(m.Aperture, 1000)
+	m.Flux, m.SNR = round(m.Flux, 100), round(m.SNR, 100)
+	m.Background = round(m.Background, 100)
+	return &m, nil
+}
+
+// annulusBackground returns the median and MAD-derived sigma of the samples
+// between radii inner and outer around (cx, cy).
+func annulusBackground(p samplePlane, cx, cy, inner, outer float64) (float64, float64) {
+	var samples []float64
+	r := int(math.Ceil(outer))
+	for y := int(cy) - r; y <= int(cy)+r; y++ {
+		for x := int(cx) - r; x <= int(cx)+r; x++ {
+			d2 := (float64(x)-cx)*(float64(x)-cx) + (float64(y)-cy)*(float64(y)-cy)
+			if d2 < inner*inner || d2 > outer*outer {
+				continue
+			}
+			if v, ok := p.at(x, y); ok {
+				samples = append(samples, v)
+			}
+		}
+	}
+	bg := median(samples)
+	for i, v := range samples {
+		samples[i] = math.Abs(v - bg)
+	}
+	return bg, 1.4826 * median(samples)
+}
+
+// parsePhotometryParams reads the ?x=&y= hint and ?search= radius shared by
+// the photometry and light-curve endpoints. A nil hint means the image centre.
+func parsePhotometryParams(c *gin.Context) (*image.Point, int, error) {
+	var hint *image.Point
+	xStr, yStr := c.Query("x"), c.Query("y")
+	if xStr != "" || yStr != "" {
+		x, errX := strconv.Atoi(xStr)
+		y, errY := strconv.Atoi(yStr)
+		if errX != nil || errY != nil || x < 0 || y < 0 {
+			return nil, 0, errors.New("Invalid 'x'/'y' parameters. Both must be non-negative pixel coordinates.")
+		}
+		hint = &image.Point{X: x, Y: y}
+	}
+
+	search := defaultPhotometrySearch
+	if s := c.Query("search"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v <= 0 || v > maxPhotometrySearch {
+			return nil, 0, errors.New("Invalid 'search' parameter. Must be an integer between 1 and 500.")
+		}
+		search = v
+	}
+	return hint, search, nil
+}
+
+// measureImage loads the source image and measures the source nearest hint
+// (or the centre, if hint is nil), stamping the capture and measurement times.
+func (api *API) measureImage(ctx context.Context, bucketName, id string, hint *image.Point, search int) (*Photometry, error) {
+	img, release, err := api.loadSourceImage(ctx, bucketName, id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	b := img.Bounds()
+	if hint == nil {
+		hint = &image.Point{X: b.Dx() / 2, Y: b.Dy() / 2}
+	}
+	if !hint.Add(b.Min).In(b) {
+		return nil, errHintOutside
+	}
+
+	m, err := measurePhotometry(img, hint.Add(b.Min), search)
+	if err != nil {
+		return nil, err
+	}
+	m.X -= float64(b.Min.X)
+	m.Y -= float64(b.Min.Y)
+	m.Measured = time.Now().Unix()
+	if t, err := api.captureTime(ctx, bucketName, id); err == nil {
+		m.CaptureTime = t.Unix()
+	}
+	return m, nil
+}
+
+// getPhotometry measures the brightest point source near ?x=&y= (default the
+// image centre) within ?search= pixels. ?persist=true also stores the result
+// on the image record for light curves.
+func (api *API) getPhotometry(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	hint, search, err := parsePhotometryParams(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	persist, _ := strconv.ParseBool(c.Query("persist"))
+
+	ctx := c.Request.Context()
+	m, err := api.measureImage(ctx, bucketName, id, hint, search)
+	if err != nil {
+		switch {
+		case isNotFound(err):
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+		case errors.Is(err, errHintOutside):
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'x'/'y' parameters. The hint lies outside the image.")
+		case errors.Is(err, errNoSource):
+			respondError(c, http.StatusUnprocessableEntity, CodeNoSourceDetected, err.Error())
+		case errors.Is(err, errImageTooLarge):
+			respondError(c, http.StatusUnprocessableEntity, CodeImageTooLarge, imageTooLargeMessage())
+		case errors.Is(err, errOverloaded):
+			respondOverloaded(c)
+		default:
+			slog.ErrorContext(ctx, "failed to load image", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+		}
+		return
+	}
+
+	if persist {
+		if err := api.Images.SetImageAttribute(ctx, id, "photometry", m); err != nil {
+			slog.ErrorContext(ctx, "failed to store photometry", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to store photometry")
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, m)
+}
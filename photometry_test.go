@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testStarField is a 16-bit w×h sky at 1000 with a little noise and a
+// Gaussian star of the given sigma and peak above it at each of stars.
+func testStarField(w, h int, sigma, peak float64, stars ...[2]float64) *image.Gray16 {
+	r := rand.New(rand.NewPCG(3, 4))
+	img := image.NewGray16(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 1000 + r.NormFloat64()*10
+			for _, s := range stars {
+				dx, dy := float64(x)-s[0], float64(y)-s[1]
+				v += peak * math.Exp(-(dx*dx+dy*dy)/(2*sigma*sigma))
+			}
+			img.SetGray16(x, y, color.Gray16{Y: clampSample(v)})
+		}
+	}
+	return img
+}
+
+func TestMeasurePhotometry(t *testing.T) {
+	img := testStarField(160, 120, 2, 20000, [2]float64{60.3, 40.7}, [2]float64{140, 100})
+	m, err := measurePhotometry(img, image.Pt(55, 45), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFlux := 2 * math.Pi * 2 * 2 * 20000
+	if math.Abs(m.X-60.3) > 0.1 || math.Abs(m.Y-40.7) > 0.1 {
+		t.Errorf("centroid %g, %g, want 60.3, 40.7", m.X, m.Y)
+	}
+	if math.Abs(m.FWHM-2*sigmaToFWHM) > 0.5 || math.Abs(m.Flux-wantFlux)/wantFlux > 0.05 || math.Abs(m.Background-1000) > 5 {
+		t.Errorf("measured %+v, want FWHM %g and flux %g", m, 2*sigmaToFWHM, wantFlux)
+	}
+	if m.SNR < 100 || m.Saturated || m.Aperture < minAperture || m.Aperture > maxAperture {
+		t.Errorf("measured %+v", m)
+	}
+
+	// The second star is beyond the search radius of this hint.
+	if m, err := measurePhotometry(img, image.Pt(110, 80), 20); err != errNoSource {
+		t.Errorf("empty sky: %+v, %v", m, err)
+	}
+
+	clipped := testStarField(80, 80, 2, 70000, [2]float64{40, 40})
+	if m, err := measurePhotometry(clipped, image.Pt(40, 40), 10); err != nil || !m.Saturated {
+		t.Errorf("clipped star: %+v, %v", m, err)
+	}
+}
+
+func TestGetPhotometry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := testFSStore(t)
+	for id, img := range map[string]image.Image{
+		"star": testStarField(120, 100, 2, 20000, [2]float64{50, 60}),
+		"sky":  testStarField(120, 100, 2, 0),
+	} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		putTestObject(t, store, imageKey(id), buf.Bytes())
+	}
+	db := testSQLStore(t)
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store, MissionDB: db, Images: db, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	router := gin.New()
+	router.GET("/image/:id/photometry", api.getPhotometry)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/image/star/photometry?x=52&y=58&search=10&persist=true")
+	var m Photometry
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil || w.Code != http.StatusOK || math.Round(m.X) != 50 || math.Round(m.Y) != 60 || m.Measured == 0 {
+		t.Fatalf("photometry: %d %s", w.Code, w.Body)
+	}
+	record, err := db.ImageRecord(context.Background(), "star")
+	if err != nil || record == nil || record.Photometry == nil || record.Photometry.X != m.X {
+		t.Errorf("persisted %+v, %v", record, err)
+	}
+
+	for path, want := range map[string]int{
+		// Without a hint the centre, (60, 50), is searched, which reaches
+		// the star within the default radius.
+		"/image/star/photometry":                    http.StatusOK,
+		"/image/star/photometry?x=10":               http.StatusBadRequest,
+		"/image/star/photometry?x=500&y=10":         http.StatusBadRequest,
+		"/image/star/photometry?search=0":           http.StatusBadRequest,
+		"/image/sky/photometry?x=60&y=50":           http.StatusUnprocessableEntity,
+		"/image/gone/photometry?x=60&y=50":          http.StatusNotFound,
+		"/image/star/photometry?x=50&y=60&search=5": http.StatusOK,
+	} {
+		if w := get(path); w.Code != want {
+			t.Errorf("GET %s: %d, want %d", path, w.Code, want)
+		}
+	}
+}
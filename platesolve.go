@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// A solve may wait in the solver's queue; it is given up after
+	// plateSolveTimeout and polled every plateSolvePoll.
+	plateSolveTimeout       = 15 * time.Minute
+	plateSolvePoll          = 5 * time.Second
+	plateSolveRequest       = time.Minute
+	defaultPlateSolveRadius = 5
+	maxSolverResponse       = 1 << 20
+)
+
+var errNoPlateSolution = errors.New("the solver found no match for the star field")
+
+// WCS is an image's astrometric solution in the FITS world coordinate
+// system, stored as the wcs attribute of its ImageRecord.
+type WCS struct {
+	// Projection is the FITS CTYPE1 projection, such as TAN.
+	Projection string `dynamodbav:"projection" json:"projection"`
+	// CRPix is the reference pixel in 1-based FITS pixel coordinates, where
+	// pixel (1, 1) is the centre of the image's top-left pixel, and CRVal its
+	// RA and Dec in degrees.
+	CRPix [2]float64 `dynamodbav:"crpix" json:"crpix"`
+	CRVal [2]float64 `dynamodbav:"crval" json:"crval"`
+	// CD maps pixel offsets to degrees on the tangent plane.
+	CD     [2][2]float64 `dynamodbav:"cd" json:"cd"`
+	Width  int           `dynamodbav:"width" json:"width"`
+	Height int           `dynamodbav:"height" json:"height"`
+	// RADeg and DecDeg are where the image's centre points.
+	RADeg  float64 `dynamodbav:"ra_deg" json:"ra_deg"`
+	DecDeg float64 `dynamodbav:"dec_deg" json:"dec_deg"`
+	// RollDeg is the position angle, east of north, of the image's up
+	// direction.
+	RollDeg float64 `dynamodbav:"roll_deg" json:"roll_deg"`
+	// PlateScale is in arcseconds per pixel.
+	PlateScale float64 `dynamodbav:"plate_scale_arcsec" json:"plate_scale_arcsec"`
+	// Mirrored is set when the image is the sky seen in a mirror: east is
+	// clockwise from north.
+	Mirrored bool   `dynamodbav:"mirrored" json:"mirrored"`
+	Solver   string `dynamodbav:"solver" json:"solver"`
+	// SolverJob is the solver's ID for the solve.
+	SolverJob string `dynamodbav:"solver_job,omitempty" json:"solver_job,omitempty"`
+	Solved    int64  `dynamodbav:"solved" json:"solved"`
+}
+
+// Sky is the RA and Dec in degrees of pixel (x, y), 0-based from the
+// image's top-left pixel, by the gnomonic projection.
+func (w WCS) Sky(x, y float64) (ra, dec float64) {
+	dx, dy := x+1-w.CRPix[0], y+1-w.CRPix[1]
+	rad := math.Pi / 180
+	xi := (w.CD[0][0]*dx + w.CD[0][1]*dy) * rad
+	eta := (w.CD[1][0]*dx + w.CD[1][1]*dy) * rad
+	ra0, dec0 := w.CRVal[0]*rad, w.CRVal[1]*rad
+	den := math.Cos(dec0) - eta*math.Sin(dec0)
+	ra = math.Mod((ra0+math.Atan2(xi, den))/rad+360, 360)
+	dec = math.Atan2(math.Sin(dec0)+eta*math.Cos(dec0), math.Hypot(xi, den)) / rad
+	return ra, dec
+}
+
+// describe fills in the centre, roll, plate scale and handedness from the
+// projection and the image size.
+func (w *WCS) describe() {
+	cx, cy := float64(w.Width-1)/2, float64(w.Height-1)/2
+	ra, dec := w.Sky(cx, cy)
+	upRA, upDec := w.Sky(cx, cy-1)
+	rad := math.Pi / 180
+	dRA := (upRA - ra) * rad
+	pa := math.Atan2(math.Sin(dRA)*math.Cos(upDec*rad), math.Cos(dec*rad)*math.Sin(upDec*rad)-math.Sin(dec*rad)*math.Cos(upDec*rad)*math.Cos(dRA))
+	det := w.CD[0][0]*w.CD[1][1] - w.CD[0][1]*w.CD[1][0]
+
+	w.RADeg = math.Round(ra*1e6) / 1e6
+	w.DecDeg = math.Round(dec*1e6) / 1e6
+	w.RollDeg = math.Round(math.Mod(pa/rad+360, 360)*1e4) / 1e4
+	w.PlateScale = math.Round(math.Sqrt(math.Abs(det))*3600*1e4) / 1e4
+	// With rows counting down the image, a CD matrix of positive
+	// determinant keeps east counterclockwise from north.
+	w.Mirrored = det < 0
+}
+
+// parseWCSHeader reads a solution from the cards of a FITS header.
+func parseWCSHeader(data []byte) (WCS, error) {
+	cards := map[string]string{}
+	for off := 0; off+80 <= len(data); off += 80 {
+		card := string(data[off : off+80])
+		key := strings.TrimSpace(card[:8])
+		if key == "END" {
+			break
+		}
+		if card[8:10] != "= " {
+			continue
+		}
+		value := strings.TrimSpace(card[10:])
+		if strings.HasPrefix(value, "'") {
+			if end := strings.Index(value[1:], "'"); end >= 0 {
+				value = value[1 : end+1]
+			}
+		} else if slash := strings.Index(value, "/"); slash >= 0 {
+			value = value[:slash]
+		}
+		cards[key] = strings.TrimSpace(value)
+	}
+
+	w := WCS{Projection: strings.TrimLeft(strings.TrimPrefix(cards["CTYPE1"], "RA--"), "-")}
+	if !strings.HasPrefix(cards["CTYPE1"], "RA--") || w.Projection == "" {
+		return WCS{}, fmt.Errorf("CTYPE1 %q is not an RA projection", cards["CTYPE1"])
+	}
+	for _, f := range []struct {
+		key string
+		v   *float64
+	}{
+		{"CRPIX1", &w.CRPix[0]}, {"CRPIX2", &w.CRPix[1]}, {"CRVAL1", &w.CRVal[0]}, {"CRVAL2", &w.CRVal[1]},
+		{"CD1_1", &w.CD[0][0]}, {"CD1_2", &w.CD[0][1]}, {"CD2_1", &w.CD[1][0]}, {"CD2_2", &w.CD[1][1]},
+	} {
+		v, err := strconv.ParseFloat(cards[f.key], 64)
+		if err != nil {
+			return WCS{}, fmt.Errorf("%s %q is not a number", f.key, cards[f.key])
+		}
+		*f.v = v
+	}
+	w.Width, _ = strconv.Atoi(cards["IMAGEW"])
+	w.Height, _ = strconv.Atoi(cards["IMAGEH"])
+	return w, nil
+}
+
+// SolveHint narrows the solver's search. A zero RadiusDeg or ScaleHigh
+// leaves that part of the search open.
+type SolveHint struct {
+	RADeg     float64 `json:"ra_deg,omitempty"`
+	DecDeg    float64 `json:"dec_deg,omitempty"`
+	RadiusDeg float64 `json:"radius_deg,omitempty"`
+	// ScaleLow and ScaleHigh bound the plate scale in arcseconds per pixel.
+	ScaleLow  float64 `json:"scale_low,omitempty"`
+	ScaleHigh float64 `json:"scale_high,omitempty"`
+}
+
+// plateSolver matches an image's star field to a catalog.
+type plateSolver interface {
+	Solve(ctx context.Context, name string, data []byte, hint SolveHint) (WCS, error)
+}
+
+// astrometryNet solves through the API of an astrometry.net server, such
+// as nova.astrometry.net or a local instance.
+type astrometryNet struct {
+	url, apiKey string
+	client      *http.Client
+	poll        time.Duration
+}
+
+func newAstrometryNet(baseURL, apiKey string) *astrometryNet {
+	return &astrometryNet{
+		url:    strings.TrimRight(baseURL, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: plateSolveRequest},
+		poll:   plateSolvePoll,
+	}
+}
+
+// Solve logs in, uploads the image and waits for its job to finish.
+func (a *astrometryNet) Solve(ctx context.Context, name string, data []byte, hint SolveHint) (WCS, error) {
+	ctx, cancel := context.WithTimeout(ctx, plateSolveTimeout)
+	defer cancel()
+
+	var login struct{ Session string }
+	if err := a.call(ctx, "/api/login", map[string]any{"apikey": a.apiKey}, nil, &login); err != nil {
+		return WCS{}, permanent(fmt.Errorf("log in: %w", err))
+	}
+	args := map[string]any{
+		"session":              login.Session,
+		"publicly_visible":     "n",
+		"allow_commercial_use": "n",
+		"allow_modifications":  "n",
+		"crpix_center":         true,
+	}
+	if hint.RadiusDeg > 0 {
+		args["center_ra"], args["center_dec"], args["radius"] = hint.RADeg, hint.DecDeg, hint.RadiusDeg
+	}
+	if hint.ScaleHigh > 0 {
+		args["scale_units"], args["scale_type"] = "arcsecperpix", "ul"
+		args["scale_lower"], args["scale_upper"] = hint.ScaleLow, hint.ScaleHigh
+	}
+	var upload struct {
+		SubID int `json:"subid"`
+	}
+	if err := a.call(ctx, "/api/upload", args, &multipartFile{name, data}, &upload); err != nil {
+		return WCS{}, fmt.Errorf("upload: %w", err)
+	}
+
+	job, err := a.await(ctx, upload.SubID)
+	if err != nil {
+		return WCS{}, err
+	}
+	header, err := a.get(ctx, fmt.Sprintf("/wcs_file/%d", job))
+	if err != nil {
+		return WCS{}, fmt.Errorf("get solution: %w", err)
+	}
+	w, err := parseWCSHeader(header)
+	if err != nil {
+		return WCS{}, permanent(fmt.Errorf("solution: %w", err))
+	}
+	w.Solver, w.SolverJob = "astrometry.net", strconv.Itoa(job)
+	return w, nil
+}
+
+// await polls the submission until its job has finished, and returns the
+// job's ID.
+func (a *astrometryNet) await(ctx context.Context, subID int) (int, error) {
+	ticker := time.NewTicker(a.poll)
+	defer ticker.Stop()
+	job := 0
+	for {
+		if job == 0 {
+			var sub struct{ Jobs []*int }
+			if err := a.call(ctx, fmt.Sprintf("/api/submissions/%d", subID), nil, nil, &sub); err != nil {
+				return 0, fmt.Errorf("submission %d: %w", subID, err)
+			}
+			if len(sub.Jobs) > 0 && sub.Jobs[0] != nil {
+				job = *sub.Jobs[0]
+			}
+		}
+		if job != 0 {
+			var status struct{ Status string }
+			if err := a.call(ctx, fmt.Sprintf("/api/jobs/%d", job), nil, nil, &status); err != nil {
+				return 0, fmt.Errorf("job %d: %w", job, err)
+			}
+			switch status.Status {
+			case "success":
+				return job, nil
+			case "failure":
+				return 0, permanent(errNoPlateSolution)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return 0, permanent(fmt.Errorf("no solution after %s: %w", plateSolveTimeout, ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+}
+
+type multipartFile struct {
+	name string
+	data []byte
+}
+
+// call sends args as the request-json form field, with file when set, or
+// GETs path when args is nil, and decodes the JSON answer into out.
+func (a *astrometryNet) call(ctx context.Context, path string, args map[string]any, file *multipartFile, out any) error {
+	var req *http.Request
+	var err error
+	switch {
+	case args == nil:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, a.url+path, nil)
+	case file != nil:
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		js, _ := json.Marshal(args)
+		mw.WriteField("request-json", string(js))
+		fw, _ := mw.CreateFormFile("file", file.name)
+		fw.Write(file.data)
+		mw.Close()
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, a.url+path, &body)
+		if req != nil {
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+		}
+	default:
+		js, _ := json.Marshal(args)
+		form := url.Values{"request-json": {string(js)}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, a.url+path, strings.NewReader(form.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, path, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSolverResponse))
+	if err != nil {
+		return err
+	}
+	var status struct{ Status, ErrorMessage string }
+	if json.Unmarshal(data, &status) == nil && status.Status == "error" {
+		return fmt.Errorf("%s: %s", path, status.ErrorMessage)
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (a *astrometryNet) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSolverResponse))
+}
+
+// platesolveSpec is the input of a platesolve job.
+type platesolveSpec struct {
+	ImageID string    `json:"image_id"`
+	Hint    SolveHint `json:"hint"`
+}
+
+// postPlateSolve starts a platesolve job for the image and answers 202 with
+// its handle. ?ra=&dec= and ?radius= centre the search, by default on the
+// target's direction in the image's observation geometry, and ?scale_low=
+// and ?scale_high= bound the plate scale.
+func (api *API) postPlateSolve(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if api.PlateSolver == nil {
+		respondError(c, http.StatusNotImplemented, CodeFeatureUnavailable, "plate solving needs PLATESOLVE_URL")
+		return
+	}
+	hint := SolveHint{RadiusDeg: defaultPlateSolveRadius}
+	num := func(name string) (float64, bool, error) {
+		v := c.Query(name)
+		if v == "" {
+			return 0, false, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, false, fmt.Errorf("Invalid '%s' parameter. Must be a number.", name)
+		}
+		return f, true, nil
+	}
+	ra, hasRA, err1 := num("ra")
+	dec, hasDec, err2 := num("dec")
+	radius, hasRadius, err3 := num("radius")
+	low, _, err4 := num("scale_low")
+	high, hasHigh, err5 := num("scale_high")
+	if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, strings.SplitN(err.Error(), "\n", 2)[0])
+		return
+	}
+	switch {
+	case hasRA != hasDec || hasRA && (ra < 0 || ra >= 360 || dec < -90 || dec > 90):
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'ra'/'dec' parameters. Give both, ra from 0 up to 360 and dec from -90 to 90 degrees.")
+		return
+	case hasRadius && !(radius > 0 && radius <= 180):
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'radius' parameter. Must be degrees above 0 and at most 180.")
+		return
+	case hasHigh && !(low >= 0 && high > low):
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'scale_low'/'scale_high' parameters. Must be arcseconds per pixel with scale_high above scale_low.")
+		return
+	}
+	if hasRadius {
+		hint.RadiusDeg = radius
+	}
+	hint.ScaleLow, hint.ScaleHigh = low, high
+
+	if _, err := api.S3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(api.Config.ImagesBucket), Key: aws.String(imageKey(id))}); err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		slog.ErrorContext(ctx, "s3 HeadObject failed", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
+		return
+	}
+	if hasRA {
+		hint.RADeg, hint.DecDeg = ra, dec
+	} else {
+		record, err := api.Images.ImageRecord(ctx, id)
+		if err != nil && !errors.Is(err, errImageTableUnset) {
+			slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		}
+		if record != nil && record.Geometry != nil {
+			hint.RADeg, hint.DecDeg = record.Geometry.TargetRADeg, record.Geometry.TargetDecDeg
+		} else {
+			hint.RadiusDeg = 0
+		}
+	}
+
+	job, err := api.Jobs.Submit(ctx, "platesolve", platesolveSpec{ImageID: id, Hint: hint}, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to submit platesolve job", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to start job")
+		return
+	}
+	c.JSON(http.StatusAccepted, newJobAccepted(job))
+}
+
+// runPlatesolveJob solves the image, stores the solution on its record,
+// with the detection at its photometry centroid, and writes it as the job's
+// JSON output.
+func (api *API) runPlatesolveJob(ctx context.Context, job Job) (string, string, error) {
+	bucketName := api.Config.ImagesBucket
+	var spec platesolveSpec
+	if err := json.Unmarshal(job.Params, &spec); err != nil {
+		return "", "", permanent(fmt.Errorf("decode params: %w", err))
+	}
+	if api.PlateSolver == nil {
+		return "", "", permanent(errors.New("plate solving needs PLATESOLVE_URL"))
+	}
+	key := imageKey(spec.ImageID)
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key), Range: firstPart()}, s3Accelerate(bucketName)...)
+	if err != nil {
+		return "", "", fmt.Errorf("get %s: %w", spec.ImageID, err)
+	}
+	raw, err := api.readObject(ctx, bucketName, key, out, out.Body)
+	out.Body.Close()
+	if err != nil {
+		return "", "", fmt.Errorf("read %s: %w", spec.ImageID, err)
+	}
+	data := bytes.Clone(raw.Bytes())
+	putBuffer(raw)
+	api.Jobs.SetProgress(job.ID, 0.1)
+
+	w, err := api.PlateSolver.Solve(ctx, path.Base(key), data, spec.Hint)
+	if err != nil {
+		return "", "", err
+	}
+	if w.Width == 0 || w.Height == 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return "", "", permanent(fmt.Errorf("decode %s: %w", spec.ImageID, err))
+		}
+		w.Width, w.Height = cfg.Width, cfg.Height
+	}
+	w.describe()
+	w.Solved = time.Now().Unix()
+	if err := api.Images.SetImageAttribute(ctx, spec.ImageID, "wcs", w); err != nil && !errors.Is(err, errImageTableUnset) {
+		return "", "", fmt.Errorf("store solution: %w", err)
+	}
+	slog.InfoContext(ctx, "plate solved image", "id", spec.ImageID, "ra", w.RADeg, "dec", w.DecDeg, "scale", w.PlateScale)
+	if err := api.detectFromPhotometry(ctx, spec.ImageID, w); err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to store photometry detection", "id", spec.ImageID, "err", err)
+	}
+
+	body, _ := json.MarshalIndent(w, "", "    ")
+	outKey := fmt.Sprintf("platesolve/%s/%s.json", spec.ImageID, job.ID)
+	if _, err := api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(outKey),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		slog.ErrorContext(ctx, "s3 PutObject failed", "key", outKey, "err", err)
+		return "", "", fmt.Errorf("store output: %w", err)
+	}
+	return outKey, "application/json", nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// rolledCD is the CD matrix, rows counting down, of an image with the given
+// plate scale in arcseconds whose up direction is at roll east of north.
+func rolledCD(scale, roll float64, mirrored bool) [2][2]float64 {
+	s, r := scale/3600, roll*math.Pi/180
+	cd := [2][2]float64{{-s * math.Cos(r), -s * math.Sin(r)}, {s * math.Sin(r), -s * math.Cos(r)}}
+	if mirrored {
+		cd[0][0], cd[1][0] = -cd[0][0], -cd[1][0]
+	}
+	return cd
+}
+
+func TestWCS(t *testing.T) {
+	tests := []struct {
+		ra, dec, scale, roll float64
+		mirrored             bool
+	}{
+		{10, 20, 2, 0, false},
+		{359.99, -45, 8.5, 90, false},
+		{180, 60, 1, 237.5, true},
+	}
+	for _, tt := range tests {
+		w := WCS{Projection: "TAN", CRPix: [2]float64{320.5, 240.5}, CRVal: [2]float64{tt.ra, tt.dec}, CD: rolledCD(tt.scale, tt.roll, tt.mirrored), Width: 640, Height: 480}
+		if ra, dec := w.Sky(319.5, 239.5); math.Abs(ra-tt.ra) > 1e-9 || math.Abs(dec-tt.dec) > 1e-9 {
+			t.Errorf("%+v: reference pixel at %v %v", tt, ra, dec)
+		}
+		w.describe()
+		if math.Abs(w.RADeg-tt.ra) > 1e-6 || math.Abs(w.DecDeg-tt.dec) > 1e-6 || math.Abs(w.PlateScale-tt.scale) > 1e-4 || math.Abs(w.RollDeg-tt.roll) > 1e-3 || w.Mirrored != tt.mirrored {
+			t.Errorf("%+v: described as %+v", tt, w)
+		}
+		// One pixel up moves one plate scale north at zero roll.
+		if tt.roll == 0 {
+			if _, dec := w.Sky(319.5, 238.5); math.Abs((dec-tt.dec)*3600-tt.scale) > 1e-6 {
+				t.Errorf("%+v: a pixel up is %v arcsec north", tt, (dec-tt.dec)*3600)
+			}
+		}
+	}
+}
+
+// wcsHeader renders w as the FITS header an astrometry.net server returns.
+func wcsHeader(w WCS) []byte {
+	var b bytes.Buffer
+	card := func(key, value string) { fmt.Fprintf(&b, "%-8s= %-70s", key, value) }
+	card("SIMPLE", "T")
+	card("CTYPE1", "'RA---TAN-SIP'       / TAN (gnomic) projection + SIP distortions")
+	card("CTYPE2", "'DEC--TAN-SIP'")
+	for i, key := range []string{"CRVAL1", "CRVAL2"} {
+		card(key, fmt.Sprintf("%.12g / RA  of reference point", w.CRVal[i]))
+	}
+	card("CRPIX1", fmt.Sprint(w.CRPix[0]))
+	card("CRPIX2", fmt.Sprint(w.CRPix[1]))
+	card("CD1_1", fmt.Sprint(w.CD[0][0]))
+	card("CD1_2", fmt.Sprint(w.CD[0][1]))
+	card("CD2_1", fmt.Sprint(w.CD[1][0]))
+	card("CD2_2", fmt.Sprint(w.CD[1][1]))
+	card("IMAGEW", fmt.Sprint(w.Width))
+	card("IMAGEH", fmt.Sprint(w.Height))
+	fmt.Fprintf(&b, "%-80s", "COMMENT = not a value card")
+	fmt.Fprintf(&b, "%-80s", "END")
+	return b.Bytes()
+}
+
+// fakeNova is an astrometry.net server whose job ends in status.
+func fakeNova(t *testing.T, solution WCS, status string, uploads *atomic.Int32) *httptest.Server {
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		var args map[string]string
+		json.Unmarshal([]byte(r.FormValue("request-json")), &args)
+		if args["apikey"] != "key" {
+			fmt.Fprint(w, `{"status": "error", "errormessage": "bad apikey"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status": "success", "session": "s1"}`)
+	})
+	mux.HandleFunc("POST /api/upload", func(w http.ResponseWriter, r *http.Request) {
+		var args map[string]any
+		json.Unmarshal([]byte(r.FormValue("request-json")), &args)
+		f, _, err := r.FormFile("file")
+		if err != nil || args["session"] != "s1" || args["center_ra"] == nil || args["publicly_visible"] != "n" {
+			t.Errorf("upload %v: %v", args, err)
+			http.Error(w, "bad upload", http.StatusBadRequest)
+			return
+		}
+		if data, _ := io.ReadAll(f); len(data) == 0 {
+			t.Error("upload without the image")
+		}
+		uploads.Add(1)
+		fmt.Fprint(w, `{"status": "success", "subid": 7}`)
+	})
+	mux.HandleFunc("GET /api/submissions/7", func(w http.ResponseWriter, r *http.Request) {
+		// The job is only created on the second poll.
+		if polls++; polls == 1 {
+			fmt.Fprint(w, `{"jobs": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"jobs": [42]}`)
+	})
+	mux.HandleFunc("GET /api/jobs/42", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status": %q}`, status)
+	})
+	mux.HandleFunc("GET /wcs_file/42", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(wcsHeader(solution))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPlateSolve(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey("frame")), Body: bytes.NewReader(frame)}); err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	solution := WCS{CRPix: [2]float64{512.5, 512.5}, CRVal: [2]float64{83.82, -5.39}, CD: rolledCD(4, 30, false)}
+	var uploads atomic.Int32
+	nova := newAstrometryNet(fakeNova(t, solution, "success", &uploads).URL, "key")
+	nova.poll = time.Millisecond
+	api := &API{
+		Config: &Config{ImagesBucket: "sat"},
+		Images: meta,
+		S3:     store,
+		Jobs:   newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers),
+	}
+	router := gin.New()
+	router.POST("/image/:id/platesolve", api.postPlateSolve)
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w
+	}
+	if w := do("/image/frame/platesolve"); w.Code != http.StatusNotImplemented {
+		t.Errorf("without a solver: %d %s", w.Code, w.Body)
+	}
+	api.PlateSolver = nova
+
+	tests := []struct {
+		path string
+		want int
+		code ErrorCode
+	}{
+		{"/image/frame/platesolve?ra=x", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/frame/platesolve?ra=10", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/frame/platesolve?ra=10&dec=91", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/frame/platesolve?ra=10&dec=0&radius=0", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/frame/platesolve?ra=10&dec=0&scale_low=5&scale_high=2", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/gone/platesolve?ra=10&dec=0", http.StatusNotFound, CodeImageNotFound},
+	}
+	for _, tt := range tests {
+		w := do(tt.path)
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s: %d %s, want %d %s", tt.path, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+
+	w := do("/image/frame/platesolve?ra=84&dec=-5&scale_low=3&scale_high=5")
+	var accepted JobAccepted
+	if err := json.Unmarshal(w.Body.Bytes(), &accepted); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	job, err := api.Jobs.Get(ctx, accepted.JobID)
+	if err != nil || job.Type != "platesolve" {
+		t.Fatalf("job %+v, %v", job, err)
+	}
+	key, contentType, err := api.runPlatesolveJob(ctx, job)
+	if err != nil || contentType != "application/json" || uploads.Load() != 1 {
+		t.Fatalf("runPlatesolveJob = %q, %q, %v", key, contentType, err)
+	}
+	record, err := meta.ImageRecord(ctx, "frame")
+	if err != nil || record == nil || record.WCS == nil {
+		t.Fatalf("ImageRecord = %+v, %v", record, err)
+	}
+	got := record.WCS
+	if got.Projection != "TAN-SIP" || got.Width != seedImageSize || got.Height != seedImageSize || got.SolverJob != "42" || math.Abs(got.RollDeg-30) > 1e-3 || math.Abs(got.PlateScale-4) > 1e-4 {
+		t.Errorf("stored solution %+v", got)
+	}
+	out, err := store.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("sat"), Key: aws.String(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Body.Close()
+	var written WCS
+	if err := json.NewDecoder(out.Body).Decode(&written); err != nil || written.CRVal != solution.CRVal {
+		t.Errorf("job output %+v, %v", written, err)
+	}
+
+	// A field the solver cannot match fails for good.
+	api.PlateSolver = newAstrometryNet(fakeNova(t, solution, "failure", &uploads).URL, "key")
+	api.PlateSolver.(*astrometryNet).poll = time.Millisecond
+	var perm permanentError
+	if _, _, err := api.runPlatesolveJob(ctx, job); !errors.Is(err, errNoPlateSolution) || !errors.As(err, &perm) {
+		t.Errorf("unsolved field: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultPointingStep = 10 * time.Second
+
+// aemTime is the CCSDS ASCII time code, in UTC without a zone designator.
+const aemTime = "2006-01-02T15:04:05.000"
+
+// PointingSample is where the observer must look to keep the target on
+// its boresight at Time.
+type PointingSample struct {
+	Time time.Time `json:"time"`
+	// LineOfSight is the unit vector from observer to target in TEME.
+	LineOfSight [3]float64 `json:"line_of_sight"`
+	RangeKM     float64    `json:"range_km"`
+	// AzimuthDeg and ElevationDeg are the line of sight in the observer's
+	// local orbital frame: azimuth from the along-track direction toward
+	// the orbit normal, and elevation above the local horizontal.
+	AzimuthDeg   float64 `json:"azimuth_deg"`
+	ElevationDeg float64 `json:"elevation_deg"`
+	// Quaternion rotates TEME to the body frame, scalar last. The body +Z
+	// axis is the line of sight and +X lies toward the orbit normal.
+	// Successive quaternions keep the same sign, so they interpolate
+	// smoothly.
+	Quaternion [4]float64 `json:"quaternion"`
+	// Occulted is set while the Earth blocks the line of sight.
+	Occulted bool `json:"occulted,omitempty"`
+}
+
+// PointingPlanResponse is the body of GET /mission/:id/pointing-plan.
+type PointingPlanResponse struct {
+	Mission     string    `json:"mission"`
+	Observer    string    `json:"observer"`
+	Target      string    `json:"target"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	StepSeconds float64   `json:"step_seconds"`
+	// ObserverTLE and TargetTLE are the element sets propagated.
+	ObserverTLE TLE              `json:"observer_tle"`
+	TargetTLE   TLE              `json:"target_tle"`
+	Samples     []PointingSample `json:"samples"`
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func unit(a [3]float64) [3]float64 {
+	n := norm(a)
+	return [3]float64{a[0] / n, a[1] / n, a[2] / n}
+}
+
+// pointAt is the observer's pointing at target.
+func pointAt(observer StateVector, target [3]float64) PointingSample {
+	los := sub(target, observer.Position)
+	z := unit(los)
+	radial := unit(observer.Position)
+	normal := unit(cross(observer.Position, observer.Velocity))
+	along := cross(normal, radial)
+
+	s := PointingSample{
+		LineOfSight:  z,
+		RangeKM:      norm(los),
+		AzimuthDeg:   math.Mod(math.Atan2(dot(z, normal), dot(z, along))*180/math.Pi+360, 360),
+		ElevationDeg: math.Asin(math.Max(-1, math.Min(1, dot(z, radial)))) * 180 / math.Pi,
+		Occulted:     occulted(observer.Position, target),
+	}
+	// +X is the orbit normal made perpendicular to the line of sight, or the
+	// along-track direction when the two are nearly parallel.
+	ref := normal
+	if math.Abs(dot(ref, z)) > 0.999 {
+		ref = along
+	}
+	d := dot(ref, z)
+	x := unit([3]float64{ref[0] - d*z[0], ref[1] - d*z[1], ref[2] - d*z[2]})
+	s.Quaternion = dcmQuaternion([3][3]float64{x, cross(z, x), z})
+	return s
+}
+
+// dcmQuaternion is the quaternion, scalar last and with a non-negative
+// scalar, of the direction cosine matrix whose rows are the body axes in
+// the reference frame.
+func dcmQuaternion(m [3][3]float64) [4]float64 {
+	var q [4]float64
+	// Shepperd's method: divide by the largest component.
+	tr := m[0][0] + m[1][1] + m[2][2]
+	switch {
+	case tr > m[0][0] && tr > m[1][1] && tr > m[2][2]:
+		s := 2 * math.Sqrt(1+tr)
+		q = [4]float64{(m[1][2] - m[2][1]) / s, (m[2][0] - m[0][2]) / s, (m[0][1] - m[1][0]) / s, s / 4}
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[0][0]-m[1][1]-m[2][2])
+		q = [4]float64{s / 4, (m[0][1] + m[1][0]) / s, (m[2][0] + m[0][2]) / s, (m[1][2] - m[2][1]) / s}
+	case m[1][1] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[1][1]-m[0][0]-m[2][2])
+		q = [4]float64{(m[0][1] + m[1][0]) / s, s / 4, (m[1][2] + m[2][1]) / s, (m[2][0] - m[0][2]) / s}
+	default:
+		s := 2 * math.Sqrt(1+m[2][2]-m[0][0]-m[1][1])
+		q = [4]float64{(m[2][0] + m[0][2]) / s, (m[1][2] + m[2][1]) / s, s / 4, (m[0][1] - m[1][0]) / s}
+	}
+	if q[3] < 0 {
+		q = [4]float64{-q[0], -q[1], -q[2], -q[3]}
+	}
+	return q
+}
+
+// pointingPlan samples the observer's pointing at the target from start to
+// end every step.
+func pointingPlan(pair orbitPair, start, end time.Time, step time.Duration) ([]PointingSample, error) {
+	samples := []PointingSample{}
+	var prev [4]float64
+	for t := start; !t.After(end); t = t.Add(step) {
+		o, err := pair.observer.At(t)
+		if err != nil {
+			return nil, fmt.Errorf("observer at %s: %w", t.Format(time.RFC3339), err)
+		}
+		tg, err := pair.target.At(t)
+		if err != nil {
+			return nil, fmt.Errorf("target at %s: %w", t.Format(time.RFC3339), err)
+		}
+		s := pointAt(o, tg.Position)
+		s.Time = t
+		if q := s.Quaternion; q[0]*prev[0]+q[1]*prev[1]+q[2]*prev[2]+q[3]*prev[3] < 0 {
+			s.Quaternion = [4]float64{-q[0], -q[1], -q[2], -q[3]}
+		}
+		prev = s.Quaternion
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// getPointingPlan answers with the observer's pointing profile for tracking
+// the target through the mission's collection window, or ?start= to ?end=,
+// every ?step=. ?format=csv returns CSV and ?format=aem a CCSDS attitude
+// ephemeris message.
+func (api *API) getPointingPlan(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" && format != "aem" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'format' parameter. Must be json, csv or aem.")
+		return
+	}
+	step, err := parseStep(c, defaultPointingStep)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+
+	var start, end time.Time
+	switch {
+	case c.Query("start") != "" || c.Query("end") != "":
+		var serr, eerr error
+		start, serr = parseAuditTime(c.Query("start"))
+		end, eerr = parseAuditTime(c.Query("end"))
+		if serr != nil || eerr != nil || end.Before(start) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'start'/'end' parameters. Both must be unix times or RFC 3339 times, with end no earlier than start.")
+			return
+		}
+	case mission.CollectionWindowEnd >= mission.CollectionWindowStart && mission.CollectionWindowStart > 0:
+		start, end = time.Unix(mission.CollectionWindowStart, 0), time.Unix(mission.CollectionWindowEnd, 0)
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "The mission has no collection window. Give 'start' and 'end'.")
+		return
+	}
+	start, end = start.UTC(), end.UTC()
+	if err := checkSamples(start, end, step); err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+
+	if mission.TargetSatelliteID == "" || mission.ObserverSatelliteID == "" {
+		respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, "the mission needs a target and an observer satellite")
+		return
+	}
+	pair, err := api.orbits(ctx, mission.TargetSatelliteID, mission.ObserverSatelliteID, start.Add(end.Sub(start)/2))
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	samples, err := pointingPlan(pair, start, end, step)
+	if err != nil {
+		respondError(c, http.StatusUnprocessableEntity, CodePropagationFailed, err.Error())
+		return
+	}
+	plan := PointingPlanResponse{
+		Mission: id, Observer: mission.ObserverSatelliteID, Target: mission.TargetSatelliteID,
+		Start: start, End: end, StepSeconds: step.Seconds(),
+		ObserverTLE: pair.observerTLE, TargetTLE: pair.targetTLE, Samples: samples,
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	switch format {
+	case "csv":
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", pointingCSV(samples))
+	case "aem":
+		observer, _ := api.Satellites.Get(mission.ObserverSatelliteID)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "mission-"+id+".aem"))
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", pointingAEM(plan, observer, time.Now()))
+	default:
+		c.IndentedJSON(http.StatusOK, plan)
+	}
+}
+
+// pointingCSV writes one row per sample.
+func pointingCSV(samples []PointingSample) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"time", "los_x", "los_y", "los_z", "range_km", "azimuth_deg", "elevation_deg", "q1", "q2", "q3", "qc", "occulted"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	for _, s := range samples {
+		row := []string{s.Time.Format(time.RFC3339Nano)}
+		for _, v := range s.LineOfSight {
+			row = append(row, f(v))
+		}
+		row = append(row, f(s.RangeKM), f(s.AzimuthDeg), f(s.ElevationDeg))
+		for _, v := range s.Quaternion {
+			row = append(row, f(v))
+		}
+		w.Write(append(row, strconv.FormatBool(s.Occulted)))
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// pointingAEM writes the plan's quaternions as a CCSDS attitude ephemeris
+// message in KVN (CCSDS 504.0-B-1).
+func pointingAEM(plan PointingPlanResponse, observer Satellite, created time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "CCSDS_AEM_VERS = 1.0\nCREATION_DATE = %s\nORIGINATOR = %s\n\n", created.UTC().Format(aemTime), eventSource)
+	b.WriteString("META_START\n")
+	fmt.Fprintf(&b, "COMMENT Mission %s: +Z body on the line of sight to %s, +X toward the orbit normal\n", plan.Mission, plan.Target)
+	fmt.Fprintf(&b, "OBJECT_NAME = %s\n", cmp.Or(observer.Name, plan.Observer))
+	objectID := plan.Observer
+	if observer.NoradID != 0 {
+		objectID = strconv.Itoa(observer.NoradID)
+	}
+	fmt.Fprintf(&b, "OBJECT_ID = %s\n", objectID)
+	b.WriteString("CENTER_NAME = EARTH\nREF_FRAME_A = TEME\nREF_FRAME_B = SC_BODY_1\nATTITUDE_DIR = A2B\nTIME_SYSTEM = UTC\n")
+	fmt.Fprintf(&b, "START_TIME = %s\nSTOP_TIME = %s\n", plan.Start.Format(aemTime), plan.End.Format(aemTime))
+	if n := len(plan.Samples); n > 0 {
+		fmt.Fprintf(&b, "USEABLE_START_TIME = %s\nUSEABLE_STOP_TIME = %s\n", plan.Samples[0].Time.Format(aemTime), plan.Samples[n-1].Time.Format(aemTime))
+	}
+	b.WriteString("ATTITUDE_TYPE = QUATERNION\nQUATERNION_TYPE = LAST\nMETA_STOP\n\nDATA_START\n")
+	for _, s := range plan.Samples {
+		q := s.Quaternion
+		fmt.Fprintf(&b, "%s %.9f %.9f %.9f %.9f\n", s.Time.Format(aemTime), q[0], q[1], q[2], q[3])
+	}
+	b.WriteString("DATA_STOP\n")
+	return b.Bytes()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPointAt(t *testing.T) {
+	// A circular equatorial orbit: along-track is +Y, the orbit normal +Z.
+	observer := StateVector{Position: [3]float64{7000, 0, 0}, Velocity: [3]float64{0, 7.5, 0}}
+	tests := []struct {
+		target  [3]float64
+		az, el  float64
+		occults bool
+	}{
+		{[3]float64{7000, 10, 0}, 0, 0, false},
+		{[3]float64{7000, 0, 10}, 90, 0, false},
+		{[3]float64{7000, -10, 0}, 180, 0, false},
+		{[3]float64{7010, 0, 0}, 0, 90, false},
+		{[3]float64{7000, 0, -10}, 270, 0, false},
+		{[3]float64{-7000, 0, 0}, 0, -90, true},
+	}
+	for _, tt := range tests {
+		s := pointAt(observer, tt.target)
+		if math.Abs(s.ElevationDeg-tt.el) > 1e-6 || math.Abs(tt.el) < 90 && math.Abs(s.AzimuthDeg-tt.az) > 1e-6 || s.Occulted != tt.occults {
+			t.Errorf("%v: az %v el %v occulted %v, want %v %v %v", tt.target, s.AzimuthDeg, s.ElevationDeg, s.Occulted, tt.az, tt.el, tt.occults)
+		}
+		if z := rotate(s.Quaternion, s.LineOfSight); math.Abs(z[0]) > 1e-9 || math.Abs(z[1]) > 1e-9 || math.Abs(z[2]-1) > 1e-9 {
+			t.Errorf("%v: quaternion %v takes the line of sight to %v", tt.target, s.Quaternion, z)
+		}
+		if q := s.Quaternion; math.Abs(q[0]*q[0]+q[1]*q[1]+q[2]*q[2]+q[3]*q[3]-1) > 1e-9 {
+			t.Errorf("%v: quaternion %v is not a unit", tt.target, s.Quaternion)
+		}
+	}
+}
+
+func TestPointingPlan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	shifted := shiftedISS(t)
+	epoch := iss.Epoch.Unix()
+	missions := missionMap{
+		"m1": {ID: "m1", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", CollectionWindowStart: epoch + 1800, CollectionWindowEnd: epoch + 2400},
+		"m2": {ID: "m2", TargetSatelliteID: "iss", ObserverSatelliteID: "chaser"},
+		"m3": {ID: "m3", TargetSatelliteID: "iss", ObserverSatelliteID: "nope", CollectionWindowStart: epoch, CollectionWindowEnd: epoch + 60},
+	}
+	api := &API{MissionDB: missions, Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, "")}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive, TLE: &iss})
+	api.Satellites.Put(ctx, Satellite{ID: "chaser", NoradID: 99999, Name: "Chaser", Status: SatelliteActive, TLE: &shifted})
+	router := gin.New()
+	router.GET("/mission/:id/pointing-plan", api.getPointingPlan)
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	tests := []struct {
+		path string
+		want int
+		code ErrorCode
+	}{
+		{"/mission/none/pointing-plan", http.StatusNotFound, CodeMissionNotFound},
+		{"/mission/m2/pointing-plan", http.StatusBadRequest, CodeInvalidParameter},
+		{"/mission/m1/pointing-plan?format=oem", http.StatusBadRequest, CodeInvalidParameter},
+		{"/mission/m1/pointing-plan?step=0", http.StatusBadRequest, CodeInvalidParameter},
+		{"/mission/m1/pointing-plan?start=100", http.StatusBadRequest, CodeInvalidParameter},
+		{"/mission/m2/pointing-plan?start=0&end=100000&step=1", http.StatusBadRequest, CodeLimitExceeded},
+		{"/mission/m3/pointing-plan", http.StatusNotFound, CodeSatelliteNotFound},
+	}
+	for _, tt := range tests {
+		w := do(tt.path)
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s: %d %s, want %d %s", tt.path, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+
+	w := do("/mission/m1/pointing-plan?step=1m")
+	var plan PointingPlanResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if len(plan.Samples) != 11 || plan.Start.Unix() != epoch+1800 || plan.ObserverTLE.Line2 != shifted.Line2 {
+		t.Fatalf("plan %+v", plan)
+	}
+	chaser, _ := newSGP4(shifted)
+	target, _ := newSGP4(iss)
+	for i, s := range plan.Samples {
+		o, _ := chaser.At(s.Time)
+		tg, _ := target.At(s.Time)
+		if r := distance(o.Position, tg.Position); math.Abs(r-s.RangeKM) > 1e-6 {
+			t.Errorf("sample %d: range %v, want %v", i, s.RangeKM, r)
+		}
+		if z := rotate(s.Quaternion, unit(sub(tg.Position, o.Position))); math.Abs(z[2]-1) > 1e-9 {
+			t.Errorf("sample %d: boresight is off the target by %v", i, z)
+		}
+		if i > 0 {
+			p := plan.Samples[i-1].Quaternion
+			if p[0]*s.Quaternion[0]+p[1]*s.Quaternion[1]+p[2]*s.Quaternion[2]+p[3]*s.Quaternion[3] < 0 {
+				t.Errorf("sample %d: the quaternion flips sign", i)
+			}
+		}
+	}
+
+	w = do("/mission/m1/pointing-plan?step=60&format=csv")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 12 || len(rows[0]) != 12 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("CSV: %v %v", rows, err)
+	}
+
+	w = do("/mission/m1/pointing-plan?step=60&format=aem")
+	aem := w.Body.String()
+	data := aem[strings.Index(aem, "DATA_START\n")+len("DATA_START\n") : strings.Index(aem, "DATA_STOP")]
+	for _, want := range []string{"CCSDS_AEM_VERS = 1.0\n", "OBJECT_NAME = Chaser\n", "OBJECT_ID = 99999\n", "REF_FRAME_A = TEME\n", "QUATERNION_TYPE = LAST\n", "START_TIME = 2008-09-20T12:55:40.000\n"} {
+		if !strings.Contains(aem, want) {
+			t.Errorf("AEM lacks %q:\n%s", want, aem)
+		}
+	}
+	if lines := strings.Split(strings.TrimSpace(data), "\n"); len(lines) != 11 || len(strings.Fields(lines[0])) != 5 {
+		t.Errorf("AEM data:\n%s", data)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"sync"
+)
+
+// maxPooledBytes keeps one outsized request from pinning its buffers in the
+// pools for the life of the process.
+const maxPooledBytes = 256 << 20
+
+// The pools below recycle the large, short-lived allocations of the
+// processing path: encoded bytes on their way in and out, pixel buffers of
+// intermediate images, and the sample slices used to find stretch limits.
+// Under concurrent load this trades steady memory for far less GC work.
+var (
+	bufferPool sync.Pool // *bytes.Buffer
+	pixPool    sync.Pool // *[]byte
+	samplePool sync.Pool // *[]uint16
+)
+
+func getBuffer() *bytes.Buffer {
+	if b, ok := bufferPool.Get().(*bytes.Buffer); ok {
+		return b
+	}
+	return new(bytes.Buffer)
+}
+
+// putBuffer returns b to the pool. b must not be used afterwards, including
+// through slices of its Bytes.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBytes {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// readBody reads r into a pooled buffer, sized up front when the length is
+// known. The caller hands it back with putBuffer.
+func readBody(r io.Reader, sizeHint int64) (*bytes.Buffer, error) {
+	b := getBuffer()
+	if sizeHint > 0 && sizeHint <= maxPooledBytes {
+		b.Grow(int(sizeHint))
+	}
+	if _, err := b.ReadFrom(r); err != nil {
+		putBuffer(b)
+		return nil, err
+	}
+	return b, nil
+}
+
+// getPix returns a pixel buffer of n bytes. Its contents are undefined, so
+// callers must overwrite every byte.
+func getPix(n int) []byte {
+	if p, ok := pixPool.Get().(*[]byte); ok && cap(*p) >= n {
+		return (*p)[:n]
+	}
+	return make([]byte, n)
+}
+
+func getSamples(n int) []uint16 {
+	if p, ok := samplePool.Get().(*[]uint16); ok && cap(*p) >= n {
+		return (*p)[:0]
+	}
+	return make([]uint16, 0, n)
+}
+
+func putSamples(s []uint16) {
+	if cap(s)*2 > maxPooledBytes {
+		return
+	}
+	samplePool.Put(&s)
+}
+
+// newPooledNRGBA64 and newPooledGray16 are image.NewNRGBA64 and
+// image.NewGray16 backed by getPix, for images that are fully drawn over.
+func newPooledNRGBA64(r image.Rectangle) *image.NRGBA64 {
+	w, h := r.Dx(), r.Dy()
+	return &image.NRGBA64{Pix: getPix(8 * w * h), Stride: 8 * w, Rect: r}
+}
+
+func newPooledGray16(r image.Rectangle) *image.Gray16 {
+	w, h := r.Dx(), r.Dy()
+	return &image.Gray16{Pix: getPix(2 * w * h), Stride: 2 * w, Rect: r}
+}
+
+// recycleImage returns the pixels of an image made by newPooledNRGBA64 or
+// newPooledGray16 to the pool once nothing refers to it.
+func recycleImage(img image.Image) {
+	var pix []byte
+	switch m := img.(type) {
+	case *image.NRGBA64:
+		pix = m.Pix
+	case *image.Gray16:
+		pix = m.Pix
+	default:
+		return
+	}
+	if cap(pix) > maxPooledBytes {
+		return
+	}
+	pixPool.Put(&pix)
+}
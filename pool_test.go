@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("read failed") }
+
+func TestReadBody(t *testing.T) {
+	b, err := readBody(strings.NewReader("frame"), 5)
+	if err != nil || b.String() != "frame" {
+		t.Fatalf("readBody = %q, %v", b, err)
+	}
+	putBuffer(b)
+	// A recycled buffer comes back empty.
+	if b := getBuffer(); b.Len() != 0 {
+		t.Errorf("pooled buffer holds %q", b)
+	}
+	if b, err := readBody(io.MultiReader(strings.NewReader("part"), failingReader{}), 0); err == nil || b != nil {
+		t.Errorf("failed read = %v, %v", b, err)
+	}
+}
+
+func TestPooledSlices(t *testing.T) {
+	for _, n := range []int{16, 1024, 8} {
+		if p := getPix(n); len(p) != n {
+			t.Errorf("getPix(%d) has length %d", n, len(p))
+		}
+		s := getSamples(n)
+		if len(s) != 0 || cap(s) < n {
+			t.Errorf("getSamples(%d) has length %d, capacity %d", n, len(s), cap(s))
+		}
+		putSamples(append(s, 1, 2, 3))
+	}
+}
+
+func TestPooledImages(t *testing.T) {
+	r := image.Rect(10, 20, 50, 45)
+	for i := 0; i < 2; i++ {
+		rgba := newPooledNRGBA64(r)
+		want := image.NewNRGBA64(r)
+		if rgba.Bounds() != r || rgba.Stride != want.Stride || len(rgba.Pix) != len(want.Pix) {
+			t.Errorf("NRGBA64 %v stride %d, %d bytes", rgba.Bounds(), rgba.Stride, len(rgba.Pix))
+		}
+		gray := newPooledGray16(r)
+		if gray.Bounds() != r || gray.Stride != 2*r.Dx() || len(gray.Pix) != 2*r.Dx()*r.Dy() {
+			t.Errorf("Gray16 %v stride %d, %d bytes", gray.Bounds(), gray.Stride, len(gray.Pix))
+		}
+		// Pixels are addressed from the rectangle's corner like any image.
+		gray.Pix[0], gray.Pix[1] = 0x12, 0x34
+		if got := gray.Gray16At(10, 20).Y; got != 0x1234 {
+			t.Errorf("corner pixel %#x", got)
+		}
+		recycleImage(rgba)
+		recycleImage(gray)
+		recycleImage(image.NewGray(r))
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const problemContentType = "application/problem+json"
+
+// ErrorCode is the machine-readable "code" of an error response. Clients
+// should branch on it rather than on the human-readable detail, which may
+// change wording.
+type ErrorCode string
+
+const (
+	CodeInvalidParameter       ErrorCode = "INVALID_PARAMETER"
+	CodeInvalidBody            ErrorCode = "INVALID_BODY"
+	CodeLimitExceeded          ErrorCode = "LIMIT_EXCEEDED"
+	CodePayloadTooLarge        ErrorCode = "PAYLOAD_TOO_LARGE"
+	CodeUnauthorized           ErrorCode = "UNAUTHORIZED"
+	CodeAdminDisabled          ErrorCode = "ADMIN_DISABLED"
+	CodeForbidden              ErrorCode = "FORBIDDEN"
+	CodeInvalidSignature       ErrorCode = "INVALID_SIGNATURE"
+	CodeSignatureExpired       ErrorCode = "SIGNATURE_EXPIRED"
+	CodeEncryptionKey          ErrorCode = "ENCRYPTION_KEY_REQUIRED"
+	CodeNotFound               ErrorCode = "NOT_FOUND"
+	CodeMissionNotFound        ErrorCode = "MISSION_NOT_FOUND"
+	CodeMissionEmpty           ErrorCode = "MISSION_EMPTY"
+	CodeImageNotFound          ErrorCode = "IMAGE_NOT_FOUND"
+	CodeJobNotFound            ErrorCode = "JOB_NOT_FOUND"
+	CodeJobNotFinished         ErrorCode = "JOB_NOT_FINISHED"
+	CodeWebhookNotFound        ErrorCode = "WEBHOOK_NOT_FOUND"
+	CodeAPIKeyNotFound         ErrorCode = "API_KEY_NOT_FOUND"
+	CodeRoleAssignmentNotFound ErrorCode = "ROLE_ASSIGNMENT_NOT_FOUND"
+	CodeTileNotFound           ErrorCode = "TILE_NOT_FOUND"
+	CodeFlagNotFound           ErrorCode = "FEATURE_FLAG_NOT_FOUND"
+	CodeSatelliteNotFound      ErrorCode = "SATELLITE_NOT_FOUND"
+	CodeTLENotFound            ErrorCode = "TLE_NOT_FOUND"
+	CodeConjunctionNotFound    ErrorCode = "CONJUNCTION_NOT_FOUND"
+	CodeSensorNotFound         ErrorCode = "SENSOR_NOT_FOUND"
+	CodeRangeNotSatisfiable    ErrorCode = "RANGE_NOT_SATISFIABLE"
+	CodeImageTooLarge          ErrorCode = "IMAGE_TOO_LARGE"
+	CodeImageUnsupported       ErrorCode = "IMAGE_UNSUPPORTED"
+	CodeNoSourceDetected       ErrorCode = "NO_SOURCE_DETECTED"
+	CodeNotPlateSolved         ErrorCode = "NOT_PLATE_SOLVED"
+	CodePropagationFailed      ErrorCode = "PROPAGATION_FAILED"
+	CodeCollectionInfeasible   ErrorCode = "COLLECTION_INFEASIBLE"
+	CodeMissionStatusConflict  ErrorCode = "MISSION_STATUS_CONFLICT"
+	CodeImageDecodeFailed      ErrorCode = "IMAGE_DECODE_FAILED"
+	CodeImageEncodeFailed      ErrorCode = "IMAGE_ENCODE_FAILED"
+	CodeChecksumUnavailable    ErrorCode = "CHECKSUM_UNAVAILABLE"
+	CodeChecksumMismatch       ErrorCode = "CHECKSUM_MISMATCH"
+	CodeInternal               ErrorCode = "INTERNAL_ERROR"
+	CodeFeatureUnavailable     ErrorCode = "FEATURE_UNAVAILABLE"
+	CodeOverloaded             ErrorCode = "OVERLOADED"
+	CodeRateLimited            ErrorCode = "RATE_LIMITED"
+	CodeQueueFull              ErrorCode = "QUEUE_FULL"
+	CodeTilesPending           ErrorCode = "TILES_PENDING"
+	CodeTimeout                ErrorCode = "TIMEOUT"
+)
+
+// Problem is an RFC 7807 problem details object, the body of every error
+// response. Type is always "about:blank", so Title is the status text and
+// Code carries the specific error. Extensions are extra members, such as
+// the parameter a limit applies to, written beside the standard ones.
+type Problem struct {
+	Type       string         `json:"type"`
+	Title      string         `json:"title"`
+	Status     int            `json:"status"`
+	Code       ErrorCode      `json:"code"`
+	Detail     string         `json:"detail,omitempty"`
+	Extensions map[string]any `json:"-"`
+}
+
+func newProblem(status int, code ErrorCode, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Code: code, Detail: detail}
+}
+
+// with adds an extension member and returns p.
+func (p *Problem) with(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) Error() string { return p.Detail }
+
+// MarshalJSON writes the standard members first, then the extensions in key
+// order.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	body, err := json.Marshal((*problem)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return body, err
+	}
+	body = body[:len(body)-1]
+	for _, key := range slices.Sorted(maps.Keys(p.Extensions)) {
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(p.Extensions[key])
+		if err != nil {
+			return nil, err
+		}
+		body = append(append(append(append(body, ','), k...), ':'), v...)
+	}
+	return append(body, '}'), nil
+}
+
+// respondProblem sends p as application/problem+json and aborts the rest of
+// the handler chain.
+func respondProblem(c *gin.Context, p *Problem) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		p = newProblem(http.StatusInternalServerError, CodeInternal, "")
+		body, _ = json.Marshal(p)
+	}
+	c.Abort()
+	c.Data(p.Status, problemContentType, body)
+}
+
+// problemFor is err as the problem it carries. Errors the service layer did
+// not classify, already logged where they happened, become a bare 500.
+func problemFor(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	return newProblem(http.StatusInternalServerError, CodeInternal, "")
+}
+
+// respondError sends a problem without extension members.
+func respondError(c *gin.Context, status int, code ErrorCode, detail string) {
+	respondProblem(c, newProblem(status, code, detail))
+}
+
+// routeNotFound answers unknown paths with a problem instead of gin's plain
+// text 404.
+func routeNotFound(c *gin.Context) {
+	respondError(c, http.StatusNotFound, CodeNotFound, "no route for "+c.Request.Method+" "+c.Request.URL.Path)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProblemResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(assignRequestID())
+	router.NoRoute(routeNotFound)
+	router.GET("/mission", func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+	})
+	router.GET("/job", func(c *gin.Context) {
+		respondProblem(c, newProblem(http.StatusConflict, CodeJobNotFinished, "job has no output yet").with("job_status", JobRunning))
+	})
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/mission", http.StatusNotFound,
+			`{"request_id":"abc","type":"about:blank","title":"Not Found","status":404,"code":"MISSION_NOT_FOUND","detail":"mission not found"}`},
+		{"/job", http.StatusConflict,
+			`{"request_id":"abc","type":"about:blank","title":"Conflict","status":409,"code":"JOB_NOT_FINISHED","detail":"job has no output yet","job_status":"running"}`},
+		{"/nowhere", http.StatusNotFound,
+			`{"request_id":"abc","type":"about:blank","title":"Not Found","status":404,"code":"NOT_FOUND","detail":"no route for GET /nowhere"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(requestIDHeader, "abc")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body, tt.wantCode, tt.wantBody)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, problemContentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// ProcessOptions is the parsed set of processing parameters for one request.
+type ProcessOptions struct {
+	Width    int
+	Height   int
+	Crop     *image.Rectangle
+	Contrast float64
+	Scale    *RadiometricScale
+	Stars    *StarSuppress
+	Format   *OutputFormat
+	// Negotiated is set when Format came from the Accept header rather
+	// than ?format=, so the response varies on Accept.
+	Negotiated bool
+	Encode     EncodeOptions
+	// Annotate burns Annotations, loaded by the handler, into the output.
+	Annotate    bool
+	Annotations []Annotation
+	// DrawObjects burns the detected Objects, loaded by the handler, into
+	// the output.
+	DrawObjects bool
+	Objects     []ObjectDetection
+	Overlay     *OverlaySpec
+	// StripMetadata removes embedded tags, including GeoTIFF georeferencing,
+	// from the delivered file.
+	StripMetadata bool
+}
+
+func imageKey(id string) string {
+	return fmt.Sprintf("images/%s.jpg", id)
+}
+
+// parseProcessOptions reads the processing query parameters. When processing
+// is needed and no format was given, the format is negotiated from Accept.
+// overlay is the server's default watermark, applied to processed output.
+func parseProcessOptions(c *gin.Context, overlay *OverlaySpec) (ProcessOptions, error) {
+	return parseProcessValues(c.Request.URL.Query(), c.GetHeader("Accept"), overlay)
+}
+
+// parseProcessValues is parseProcessOptions for parameters that arrive some
+// other way than a request's query string, such as a batch job's spec.
+func parseProcessValues(q url.Values, accept string, overlay *OverlaySpec) (ProcessOptions, error) {
+	var opts ProcessOptions
+
+	opts.Width, _ = strconv.Atoi(q.Get("width"))
+	opts.Height, _ = strconv.Atoi(q.Get("height"))
+	opts.Contrast, _ = strconv.ParseFloat(q.Get("contrast"), 64)
+	opts.Annotate, _ = strconv.ParseBool(q.Get("annotations"))
+	opts.StripMetadata, _ = strconv.ParseBool(q.Get("stripMetadata"))
+	switch q.Get("detections") {
+	case "":
+	case "overlay":
+		opts.DrawObjects = true
+	default:
+		return opts, errors.New("Invalid 'detections' parameter. Must be overlay.")
+	}
+
+	if cropStr := q.Get("crop"); cropStr != "" {
+		crop, err := parseCrop(cropStr)
+		if err != nil {
+			return opts, err
+		}
+		opts.Crop = &crop
+	}
+
+	if formatStr := q.Get("format"); formatStr != "" {
+		f, ok := lookupFormat(formatStr)
+		if !ok {
+			return opts, errors.New("Invalid 'format' parameter. Must be one of jpeg, png, webp, avif, tiff.")
+		}
+		opts.Format = f
+	}
+
+	var err error
+	opts.Encode, err = parseEncodeOptions(q.Get("quality"), q.Get("lossless"), q.Get("compression"))
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Scale, err = parseScale(q.Get("scale"))
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Stars, err = parseStarSuppress(q.Get("starsuppress"), q.Get("starsuppress_cell"), q.Get("starsuppress_sigma"))
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Overlay, err = parseOverlay(overlay, q.Get("overlay"), q.Get("overlay_position"), q.Get("overlay_opacity"))
+	if err != nil {
+		return opts, err
+	}
+	// Asking for an overlay by text is a processing request in its own right;
+	// the server default only applies to output that is processed anyway.
+	overlayRequested := q.Get("overlay") != "" && q.Get("overlay") != "none"
+
+	if (opts.NeedsProcessing() || overlayRequested) && opts.Format == nil {
+		opts.Format = negotiateFormat(accept)
+		opts.Negotiated = true
+	}
+
+	return opts, nil
+}
+
+func (o ProcessOptions) NeedsProcessing() bool {
+	return o.Width > 0 || o.Height > 0 || o.Crop != nil || o.Contrast != 0 || o.Format != nil || o.Scale != nil || o.Stars != nil || o.Annotate || o.DrawObjects
+}
+
+// parseCrop reads a crop region given as x,y,w,h in full-resolution pixels.
+func parseCrop(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, errors.New("Invalid 'crop' parameter. Use crop=x,y,w,h in pixels.")
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return image.Rectangle{}, errors.New("Invalid 'crop' parameter. Use crop=x,y,w,h in pixels.")
+		}
+		v[i] = n
+	}
+	if v[2] == 0 || v[3] == 0 {
+		return image.Rectangle{}, errors.New("Invalid 'crop' parameter. Width and height must be positive.")
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+var errCropOutside = errors.New("Invalid 'crop' parameter. Region lies outside the image.")
+
+// cropImage returns the r sub-image of img without converting its pixel type,
+// so 16-bit sources stay 16-bit.
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	return imaging.Crop(img, r)
+}
+
+// renderProcessed produces the processed image and, for TIFF output, the
+// georeferencing to carry over. Large tiled TIFFs are read region by region
+// with ranged GETs when the request only needs part of the pixels; anything
+// else is read in full from out. The work holds a share of api.Limiter, which
+// the caller gives back with release once it has encoded the image.
+func (api *API) renderProcessed(ctx context.Context, bucketName, key string, out *s3.GetObjectOutput, opts ProcessOptions) (img image.Image, geo *GeoInfo, release func(), err error) {
+	body := bufio.NewReaderSize(out.Body, cogHeaderBytes)
+
+	if opts.Crop != nil || opts.Width > 0 || opts.Height > 0 {
+		head, _ := body.Peek(cogHeaderBytes)
+		if isTIFFHeader(head) && objectSize(out) >= cogMinBytes {
+			img, geo, release, err := api.renderCOG(ctx, bucketName, key, head, objectSize(out), opts)
+			if err == nil {
+				out.Body.Close()
+				return img, geo, release, nil
+			}
+			if !errors.Is(err, errNotCOG) {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	raw, err := api.readObject(ctx, bucketName, key, out, body)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("read %s: %w", key, err)
+	}
+	defer putBuffer(raw)
+	data := raw.Bytes()
+
+	pixels, err := sourcePixels(data)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("decode %s: %w", key, err)
+	}
+	release, err = api.Limiter.Acquire(ctx, pixels*bytesPerDecodedPixel)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	src, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("decode %s: %w", key, err)
+	}
+
+	region := src.Bounds()
+	if opts.Crop != nil {
+		region = opts.Crop.Add(region.Min).Intersect(region)
+		if region.Empty() {
+			return nil, nil, nil, errCropOutside
+		}
+	}
+
+	if opts.Format.Name == "tiff" && !opts.StripMetadata {
+		var gerr error
+		if geo, gerr = parseGeoTIFF(data); gerr != nil && gerr != errNotTIFF {
+			slog.ErrorContext(ctx, "failed to parse geotiff tags", "key", key, "err", gerr)
+		}
+		if geo != nil {
+			geo = geo.cropped(region.Sub(src.Bounds().Min))
+		}
+	}
+
+	return processImage(cropImage(src, region), region.Sub(src.Bounds().Min), opts), geo, release, nil
+}
+
+// loadSourceImage fetches and decodes the original for id. The decoded image
+// holds a share of api.Limiter, which the caller gives back with release once
+// it has reduced the image to its working size or finished with it.
+func (api *API) loadSourceImage(ctx context.Context, bucketName, id string) (img image.Image, release func(), err error) {
+	key := imageKey(id)
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+		Range:  firstPart(),
+	}, s3Accelerate(bucketName)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get %s: %w", id, err)
+	}
+	defer out.Body.Close()
+
+	raw, err := api.readObject(ctx, bucketName, key, out, out.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", id, err)
+	}
+	defer putBuffer(raw)
+	data := raw.Bytes()
+	pixels, err := sourcePixels(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode %s: %w", id, err)
+	}
+	release, err = api.Limiter.Acquire(ctx, pixels*bytesPerDecodedPixel)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err = imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("decode %s: %w", id, err)
+	}
+	return img, release, nil
+}
+
+// processImage applies background suppression, the radiometric stretch,
+// resize and contrast steps in that order so that the first two see the full
+// source bit depth, then draws any annotations and the watermark. Cropping
+// has already happened by the time it is called; region is the part of the
+// full-resolution source that src covers.
+//
+// Every step returns a new image, so the result of a pooled step is recycled
+// as soon as the following step has consumed it. All steps spread their rows
+// across GOMAXPROCS: the stretch and star suppression through parallelRows,
+// and imaging's resize and contrast adjustments internally.
+func processImage(src image.Image, region image.Rectangle, o ProcessOptions) image.Image {
+	img := src
+	pooled := false
+	next := func(m image.Image, fromPool bool) {
+		if pooled {
+			recycleImage(img)
+		}
+		img, pooled = m, fromPool
+	}
+
+	if o.Stars != nil {
+		next(applyStarSuppress(img, o.Stars), true)
+	}
+
+	if o.Scale != nil {
+		next(applyScale(img, o.Scale), true)
+	}
+
+	if o.Width > 0 || o.Height > 0 {
+		// Not split into strips: imaging spreads both Lanczos passes across
+		// GOMAXPROCS itself.
+		next(imaging.Resize(img, o.Width, o.Height, imaging.Lanczos), false)
+	}
+
+	if o.Contrast != 0 {
+		next(imaging.AdjustContrast(img, o.Contrast), false)
+	}
+
+	if len(o.Annotations) > 0 {
+		next(drawAnnotations(img, o.Annotations, region), false)
+	}
+
+	if boxes := objectAnnotations(o.Objects); len(boxes) > 0 {
+		next(drawAnnotations(img, boxes, region), false)
+	}
+
+	if o.Overlay != nil {
+		next(drawOverlay(img, o.Overlay), false)
+	}
+
+	return img
+}
+
+// encodeProcessed writes img in the requested format. TIFF output from a
+// GeoTIFF source keeps its georeferencing, rescaled to the output size.
+func encodeProcessed(w io.Writer, img image.Image, o ProcessOptions, geo *GeoInfo) error {
+	if geo == nil || o.Format.Name != "tiff" {
+		return o.Format.Encode(w, img, o.Encode)
+	}
+
+	var buf bytes.Buffer
+	if err := o.Format.Encode(&buf, img, o.Encode); err != nil {
+		return err
+	}
+	b := img.Bounds()
+	return writeGeoTags(w, buf.Bytes(), geo.resized(b.Dx(), b.Dy()))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// The upload metadata in which a pipeline declares how an image was made, as
+// x-amz-meta-source-version, x-amz-meta-software and x-amz-meta-processing.
+const (
+	sourceVersionMetadata = "source-version"
+	softwareMetadata      = "software"
+	processingMetadata    = "processing"
+)
+
+// ImageVersion is one ingested upload of an image. Uploading other bytes
+// under the same ID, such as a capture reprocessed with a new calibration,
+// adds a version.
+type ImageVersion struct {
+	Version  int    `dynamodbav:"version" json:"version"`
+	SHA256   string `dynamodbav:"sha256" json:"sha256"`
+	Bytes    int64  `dynamodbav:"bytes" json:"bytes"`
+	Format   string `dynamodbav:"format,omitempty" json:"format,omitempty"`
+	Uploaded int64  `dynamodbav:"uploaded,omitempty" json:"uploaded,omitempty"`
+	Ingested int64  `dynamodbav:"ingested" json:"ingested"`
+	// Kept says a copy of the upload was kept, so the version can still be
+	// downloaded once it is replaced.
+	Kept       bool        `dynamodbav:"kept,omitempty" json:"kept,omitempty"`
+	Provenance *Provenance `dynamodbav:"provenance,omitempty" json:"provenance,omitempty"`
+}
+
+// Provenance is how a version was made, as its uploader declared it.
+// SourceVersion names what it was made from, such as a raw frame or the
+// version of the image it reprocesses, Processing the parameters used, such
+// as the calibration and stretch, and Software the pipeline and its version.
+type Provenance struct {
+	SourceVersion string            `dynamodbav:"source_version,omitempty" json:"source_version,omitempty"`
+	Software      string            `dynamodbav:"software,omitempty" json:"software,omitempty"`
+	Processing    map[string]string `dynamodbav:"processing,omitempty" json:"processing,omitempty"`
+}
+
+// ImageVersionsResponse is the body of GET /image/:id/versions.
+type ImageVersionsResponse struct {
+	ImageID string `json:"image_id"`
+	// Versions are newest first.
+	Versions []ImageVersion `json:"versions"`
+}
+
+// versionKey is where version of image id is kept.
+func versionKey(id string, version int) string {
+	return fmt.Sprintf("versions/%s/%d.jpg", id, version)
+}
+
+// parseProvenance reads the provenance an upload's metadata declares, or
+// returns nil when it declares none. Processing is a query string, such as
+// calibration=flat-2026-10&scale=percentile:1,99; one that does not parse is
+// kept whole under "".
+func parseProvenance(metadata map[string]string) *Provenance {
+	p := &Provenance{SourceVersion: metadata[sourceVersionMetadata], Software: metadata[softwareMetadata]}
+	if v := metadata[processingMetadata]; v != "" {
+		p.Processing = map[string]string{}
+		q, err := url.ParseQuery(v)
+		if err != nil {
+			p.Processing[""] = v
+		}
+		for name := range q {
+			p.Processing[name] = q.Get(name)
+		}
+	}
+	if p.SourceVersion == "" && p.Software == "" && p.Processing == nil {
+		return nil
+	}
+	return p
+}
+
+// recordVersion adds the ingested upload rec to image id's versions, with the
+// provenance its metadata declares, unless it has the bytes of the latest.
+// With KEEP_IMAGE_VERSIONS a copy of it is kept.
+func (api *API) recordVersion(ctx context.Context, id string, rec IngestRecord, out *s3.GetObjectOutput) {
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil {
+		if !errors.Is(err, errImageTableUnset) {
+			slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		}
+		return
+	}
+	var versions []ImageVersion
+	if record != nil {
+		versions = record.Versions
+	}
+	if n := len(versions); n > 0 && versions[n-1].SHA256 == rec.SHA256 {
+		return
+	}
+	v := ImageVersion{
+		Version:    len(versions) + 1,
+		SHA256:     rec.SHA256,
+		Bytes:      rec.Bytes,
+		Format:     rec.Format,
+		Uploaded:   aws.ToTime(out.LastModified).Unix(),
+		Ingested:   rec.Ingested,
+		Provenance: parseProvenance(out.Metadata),
+	}
+	if api.Config.KeepImageVersions {
+		if err := api.keepVersion(ctx, id, v.Version, rec); err != nil {
+			slog.ErrorContext(ctx, "failed to keep image version", "id", id, "version", v.Version, "err", err)
+		} else {
+			v.Kept = true
+		}
+	}
+	versions = append(versions, v)
+	if err := api.Images.SetImageAttribute(ctx, id, "versions", versions); err != nil {
+		slog.ErrorContext(ctx, "failed to record image version", "id", id, "version", v.Version, "err", err)
+	}
+	// GET /image/:id serves the latest version, not what memory holds of
+	// the one before.
+	api.forgetImage(ctx, id)
+}
+
+// keepVersion copies image id's original to where version is kept. An
+// original stored by content is copied as its pointer, which then also
+// references the blob.
+func (api *API) keepVersion(ctx context.Context, id string, version int, rec IngestRecord) error {
+	bucketName := api.Config.ImagesBucket
+	key := versionKey(id, version)
+	_, err := api.S3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(bucketName),
+		Key:        aws.String(key),
+		CopySource: aws.String(bucketName + "/" + imageKey(id)),
+	})
+	if err != nil || !rec.ContentAddressed {
+		return err
+	}
+	return api.Content.store(ctx, bucketName, tenantObjectKey(ctx, key), rec.SHA256)
+}
+
+// imageVersions loads image id's versions, writing the error response and
+// returning nil when it cannot.
+func (api *API) imageVersions(c *gin.Context, id string) []ImageVersion {
+	ctx := c.Request.Context()
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load image record")
+		return nil
+	}
+	if record == nil || len(record.Versions) == 0 {
+		respondError(c, http.StatusNotFound, CodeImageNotFound, "no versions recorded for the image")
+		return nil
+	}
+	return record.Versions
+}
+
+// getImageVersions lists an image's versions, newest first.
+func (api *API) getImageVersions(c *gin.Context) {
+	id := c.Param("id")
+	versions := api.imageVersions(c, id)
+	if versions == nil {
+		return
+	}
+	versions = slices.Clone(versions)
+	slices.Reverse(versions)
+	c.JSON(http.StatusOK, ImageVersionsResponse{ImageID: id, Versions: versions})
+}
+
+// getImageVersion downloads one version of an image: the original for the
+// latest, and its kept copy for an earlier one.
+func (api *API) getImageVersion(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	n, err := strconv.Atoi(c.Param("version"))
+	if err != nil || n < 1 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "version must be a positive integer")
+		return
+	}
+	versions := api.imageVersions(c, id)
+	if versions == nil {
+		return
+	}
+	if n > len(versions) {
+		respondError(c, http.StatusNotFound, CodeImageNotFound, "version not found")
+		return
+	}
+	v := versions[n-1]
+	key := imageKey(id)
+	if n < len(versions) {
+		if !v.Kept {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "the version was replaced without a copy being kept")
+			return
+		}
+		key = versionKey(id, n)
+	}
+
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(api.Config.ImagesBucket),
+		Key:    aws.String(key),
+	}, s3Accelerate(api.Config.ImagesBucket)...)
+	if err != nil {
+		switch {
+		case isColdObject(err) && key == imageKey(id):
+			api.respondCold(c, id)
+		case isNotFound(err):
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+		default:
+			slog.ErrorContext(ctx, "s3 GetObject failed", "key", key, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
+		}
+		return
+	}
+	defer out.Body.Close()
+
+	if out.ContentType != nil {
+		c.Header("Content-Type", aws.ToString(out.ContentType))
+	}
+	if out.ContentLength != nil {
+		c.Header("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	c.Header(checksumHeader, v.SHA256)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, out.Body); err != nil {
+		slog.ErrorContext(ctx, "streaming failed", "key", key, "err", err)
+	}
+}
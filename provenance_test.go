@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseProvenance(t *testing.T) {
+	if p := parseProvenance(map[string]string{"sha256": "abc"}); p != nil {
+		t.Errorf("no provenance declared: %+v", p)
+	}
+	got := parseProvenance(map[string]string{
+		sourceVersionMetadata: "1",
+		softwareMetadata:      "calpipe 2.4.1",
+		processingMetadata:    "calibration=flat-2026-10&scale=percentile:1,99",
+	})
+	want := &Provenance{SourceVersion: "1", Software: "calpipe 2.4.1", Processing: map[string]string{"calibration": "flat-2026-10", "scale": "percentile:1,99"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProvenance = %+v, want %+v", got, want)
+	}
+}
+
+func TestImageVersions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reprocessed, err := seedImage(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep, KeepImageVersions: true},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        store,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+	ingest := func(data []byte, metadata map[string]string) {
+		t.Helper()
+		_, err := store.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(imageKey("m31")), Body: bytes.NewReader(data), Metadata: metadata})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ingestImage(ctx, "m31"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ingest(frame, map[string]string{softwareMetadata: "calpipe 2.4.0"})
+	ingest(frame, nil)
+	ingest(reprocessed, map[string]string{sourceVersionMetadata: "1", processingMetadata: "calibration=flat-2026-10"})
+
+	router := gin.New()
+	router.GET("/image/:id/versions", api.getImageVersions)
+	router.GET("/image/:id/versions/:version", api.getImageVersion)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/image/m31/versions")
+	var resp ImageVersionsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("list: %d %s", w.Code, w.Body)
+	}
+	if len(resp.Versions) != 2 {
+		t.Fatalf("versions %+v, want 2: the same bytes again are not a version", resp.Versions)
+	}
+	latest, first := resp.Versions[0], resp.Versions[1]
+	if latest.Version != 2 || latest.SHA256 != contentSHA256(reprocessed) || !latest.Kept ||
+		latest.Provenance == nil || latest.Provenance.SourceVersion != "1" || latest.Provenance.Processing["calibration"] != "flat-2026-10" {
+		t.Errorf("latest %+v", latest)
+	}
+	if first.Version != 1 || first.SHA256 != contentSHA256(frame) || first.Provenance == nil || first.Provenance.Software != "calpipe 2.4.0" {
+		t.Errorf("first %+v", first)
+	}
+
+	for path, want := range map[string][]byte{"/image/m31/versions/1": frame, "/image/m31/versions/2": reprocessed} {
+		w := get(path)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) || w.Header().Get(checksumHeader) != contentSHA256(want) {
+			t.Errorf("GET %s: %d, %d bytes", path, w.Code, w.Body.Len())
+		}
+	}
+	for path, want := range map[string]int{
+		"/image/m31/versions/3":    http.StatusNotFound,
+		"/image/m31/versions/zero": http.StatusBadRequest,
+		"/image/m42/versions":      http.StatusNotFound,
+	} {
+		if w := get(path); w.Code != want {
+			t.Errorf("GET %s: %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestRecordVersionDropsMemory(t *testing.T) {
+	ctx := context.Background()
+	meta := testSQLStore(t)
+	api := &API{Config: &Config{ImagesBucket: "sat"}, Images: meta, Memory: newMemoryCache(defaultMemoryCacheMB<<20, defaultMemoryCacheTTL)}
+	stale := cachedObject{Data: []byte("version 1")}
+	for _, key := range []string{imageKey("m31"), "processed/m31\nw=32", imageKey("m42")} {
+		api.Memory.Add(key, stale)
+	}
+	out := &s3.GetObjectOutput{LastModified: aws.Time(time.Now())}
+	api.recordVersion(ctx, "m31", IngestRecord{SHA256: contentSHA256([]byte("version 2")), Ingested: time.Now().Unix()}, out)
+	for key, held := range map[string]bool{imageKey("m31"): false, "processed/m31\nw=32": false, imageKey("m42"): true} {
+		if _, ok := api.Memory.Get(key); ok != held {
+			t.Errorf("memory holds %q: %v, want %v", key, ok, held)
+		}
+	}
+}
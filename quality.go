@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"image"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// qualitySampleSide bounds the native-resolution centre patch used for
+	// the blur and noise estimates, which a resample would distort.
+	qualitySampleSide = 1024
+	// sharpReference is the Laplacian variance treated as fully sharp.
+	sharpReference = 1000
+	// snrReference is the SNR in dB treated as noise-free.
+	snrReference = 40
+	// saturationLimit is the saturated-pixel percentage that zeroes the
+	// saturation part of the score.
+	saturationLimit = 5
+)
+
+// QualityMetrics are the ingest-time image quality measurements. Score is a
+// 0–100 summary weighted 60% sharpness, 25% SNR and 15% saturation.
+type QualityMetrics struct {
+	Score        float64 `dynamodbav:"score" json:"score"`
+	BlurVariance float64 `dynamodbav:"blur_variance" json:"blur_variance"`
+	SNR          float64 `dynamodbav:"snr_db" json:"snr_db"`
+	SaturatedPct float64 `dynamodbav:"saturated_pct" json:"saturated_pct"`
+	Computed     int64   `dynamodbav:"computed" json:"computed"`
+}
+
+// computeQuality measures sharpness as the variance of the Laplacian, noise
+// from the median response to Immerkær's noise mask, and SNR as the spread
+// between the median and the 99th percentile over that noise, so dark-sky
+// frames with a bright target still score on the target. Saturation is
+// sampled over the whole frame.
+func computeQuality(src image.Image) QualityMetrics {
+	b := src.Bounds()
+	patch := image.Rect(0, 0, min(b.Dx(), qualitySampleSide), min(b.Dy(), qualitySampleSide))
+	patch = patch.Add(b.Min).Add(image.Pt((b.Dx()-patch.Dx())/2, (b.Dy()-patch.Dy())/2))
+	p := toGrayPlane(cropImage(src, patch))
+
+	m := QualityMetrics{Computed: time.Now().Unix()}
+	if p.w < 3 || p.h < 3 {
+		return m
+	}
+
+	var sum, sumSq float64
+	n := float64((p.w - 2) * (p.h - 2))
+	residuals := make([]float64, 0, (p.w-2)*(p.h-2))
+	for y := 1; y < p.h-1; y++ {
+		for x := 1; x < p.w-1; x++ {
+			c := p.at(x, y)
+			edges := p.at(x-1, y) + p.at(x+1, y) + p.at(x, y-1) + p.at(x, y+1)
+			corners := p.at(x-1, y-1) + p.at(x+1, y-1) + p.at(x-1, y+1) + p.at(x+1, y+1)
+
+			lap := edges - 4*c
+			sum += lap
+			sumSq += lap * lap
+
+			residuals = append(residuals, math.Abs(4*c-2*edges+corners))
+		}
+	}
+	mean := sum / n
+	m.BlurVariance = sumSq/n - mean*mean
+	// The mask has unit-variance gain 6; taking the median rather than the
+	// mean keeps genuine edges from being counted as noise.
+	sort.Float64s(residuals)
+	sigma := residuals[len(residuals)/2] / (6 * 0.6745)
+
+	sorted := append([]float64(nil), p.pix...)
+	sort.Float64s(sorted)
+	signal := sorted[len(sorted)*99/100] - sorted[len(sorted)/2]
+	switch {
+	case sigma < 1e-6:
+		m.SNR = snrReference
+	case signal <= 0:
+		m.SNR = 0
+	default:
+		m.SNR = 20 * math.Log10(signal/sigma)
+	}
+
+	// Nearest-neighbour sampling keeps clipped pixels at full scale, which an
+	// averaging filter would not.
+	sample := imaging.Fit(src, qualitySampleSide, qualitySampleSide, imaging.NearestNeighbor)
+	var saturated int
+	for i := 0; i < len(sample.Pix); i += 4 {
+		if sample.Pix[i] == 0xff || sample.Pix[i+1] == 0xff || sample.Pix[i+2] == 0xff {
+			saturated++
+		}
+	}
+	m.SaturatedPct = 100 * float64(saturated) / float64(len(sample.Pix)/4)
+
+	clamp := func(v float64) float64 { return math.Max(0, math.Min(1, v)) }
+	sharp := clamp(math.Log10(1+m.BlurVariance) / math.Log10(1+sharpReference))
+	snr := clamp(m.SNR / snrReference)
+	unsaturated := 1 - clamp(m.SaturatedPct/saturationLimit)
+	m.Score = 100 * (0.6*sharp + 0.25*snr + 0.15*unsaturated)
+
+	m.Score = math.Round(m.Score*10) / 10
+	m.BlurVariance = math.Round(m.BlurVariance*100) / 100
+	m.SNR = math.Round(m.SNR*100) / 100
+	m.SaturatedPct = math.Round(m.SaturatedPct*1000) / 1000
+	return m
+}
+
+// recordQuality scores src and stores the result on the image record.
+func (api *API) recordQuality(ctx context.Context, id string, src image.Image) error {
+	return api.Images.SetImageAttribute(ctx, id, "quality", computeQuality(src))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+func TestComputeQuality(t *testing.T) {
+	// A checkerboard of 4px squares on mid gray: sharp, noise-free and
+	// unsaturated.
+	sharp := image.NewGray(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			sharp.SetGray(x, y, color.Gray{Y: uint8(60 + 120*((x/4+y/4)%2))})
+		}
+	}
+	crisp := computeQuality(sharp)
+	blurred := computeQuality(imaging.Blur(sharp, 3))
+	if crisp.BlurVariance <= blurred.BlurVariance || crisp.Score <= blurred.Score {
+		t.Errorf("blur not penalised: sharp %+v, blurred %+v", crisp, blurred)
+	}
+	if crisp.SaturatedPct != 0 || crisp.Computed == 0 || crisp.Score <= 0 || crisp.Score > 100 {
+		t.Errorf("sharp %+v", crisp)
+	}
+
+	noisy := image.NewGray(sharp.Rect)
+	r := rand.New(rand.NewPCG(1, 2))
+	for i, v := range sharp.Pix {
+		noisy.Pix[i] = uint8(int(v) + r.IntN(41) - 20)
+	}
+	if got := computeQuality(noisy); got.SNR >= crisp.SNR {
+		t.Errorf("noise not penalised: clean SNR %g, noisy %g", crisp.SNR, got.SNR)
+	}
+
+	white := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range white.Pix {
+		white.Pix[i] = 0xff
+	}
+	if got := computeQuality(white); got.SaturatedPct != 100 {
+		t.Errorf("white frame %g%% saturated", got.SaturatedPct)
+	}
+
+	if got := computeQuality(image.NewGray(image.Rect(0, 0, 2, 2))); got.Score != 0 || got.Computed == 0 {
+		t.Errorf("tiny frame %+v", got)
+	}
+}
+
+func TestGetMissionImagesQuality(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	db := testSQLStore(t)
+	data, _ := json.Marshal(Mission{ID: "m1", ImageIDs: []string{"low", "high", "unscored"}})
+	if _, err := db.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", "m1", string(data)); err != nil {
+		t.Fatal(err)
+	}
+	for id, score := range map[string]float64{"low": 20, "high": 90} {
+		if err := db.SetImageAttribute(ctx, id, "quality", QualityMetrics{Score: score}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{Config: &Config{}, MissionDB: db, Images: db}
+	router := gin.New()
+	router.GET("/mission/:id/images", api.getMissionImages)
+	get := func(path string) (int, []string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp MissionImagesResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var ids []string
+		for _, img := range resp.Images {
+			ids = append(ids, img.ID)
+		}
+		return w.Code, ids
+	}
+
+	for _, tt := range []struct {
+		path string
+		want int
+		ids  []string
+	}{
+		{"/mission/m1/images", http.StatusOK, []string{"low", "high", "unscored"}},
+		{"/mission/m1/images?minQuality=50", http.StatusOK, []string{"high"}},
+		{"/mission/m1/images?minQuality=0", http.StatusOK, []string{"low", "high"}},
+		{"/mission/m1/images?minQuality=101", http.StatusBadRequest, nil},
+		{"/mission/m1/images?minQuality=good", http.StatusBadRequest, nil},
+		{"/mission/none/images", http.StatusNotFound, nil},
+	} {
+		if code, ids := get(tt.path); code != tt.want || !slices.Equal(ids, tt.ids) {
+			t.Errorf("GET %s: %d %v, want %d %v", tt.path, code, ids, tt.want, tt.ids)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RadiometricScale stretches source sample values so that [Lo, Hi] maps onto
+// the full output range. It runs on the 16-bit samples before any resize or
+// encode step quantizes the image.
+type RadiometricScale struct {
+	Mode string
+	// Lo and Hi are percentiles for "percentile" and raw sample values for
+	// "fixed". They are unused for "minmax".
+	Lo, Hi float64
+}
+
+const (
+	defaultPercentileLo = 2
+	defaultPercentileHi = 98
+)
+
+// parseScale accepts minmax, percentile, percentile:lo,hi and fixed:lo,hi.
+func parseScale(s string) (*RadiometricScale, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	mode, args, hasArgs := strings.Cut(strings.ToLower(s), ":")
+	switch mode {
+	case "minmax":
+		if hasArgs {
+			return nil, errors.New("Invalid 'scale' parameter. minmax takes no arguments.")
+		}
+		return &RadiometricScale{Mode: mode}, nil
+	case "percentile":
+		if !hasArgs {
+			return &RadiometricScale{Mode: mode, Lo: defaultPercentileLo, Hi: defaultPercentileHi}, nil
+		}
+		lo, hi, err := parseRange(args)
+		if err != nil || lo < 0 || hi > 100 {
+			return nil, errors.New("Invalid 'scale' parameter. percentile bounds must be between 0 and 100.")
+		}
+		return &RadiometricScale{Mode: mode, Lo: lo, Hi: hi}, nil
+	case "fixed":
+		lo, hi, err := parseRange(args)
+		if !hasArgs || err != nil || lo < 0 {
+			return nil, errors.New("Invalid 'scale' parameter. Use fixed:lo,hi with non-negative sample values.")
+		}
+		return &RadiometricScale{Mode: mode, Lo: lo, Hi: hi}, nil
+	}
+
+	return nil, errors.New("Invalid 'scale' parameter. Must be one of minmax, percentile, fixed:lo,hi.")
+}
+
+func parseRange(s string) (float64, float64, error) {
+	loStr, hiStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, errors.New("expected lo,hi")
+	}
+	lo, err := strconv.ParseFloat(strings.TrimSpace(loStr), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := strconv.ParseFloat(strings.TrimSpace(hiStr), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if hi <= lo {
+		return 0, 0, errors.New("hi must be greater than lo")
+	}
+	return lo, hi, nil
+}
+
+// isHighBitDepth reports whether img carries more than 8 bits per sample.
+func isHighBitDepth(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		return true
+	}
+	return false
+}
+
+// applyScale returns a 16-bit copy of img with the requested stretch applied.
+// Grayscale sources stay single-channel so PNG and TIFF output remain compact.
+func applyScale(img image.Image, scale *RadiometricScale) image.Image {
+	b := img.Bounds()
+
+	w := b.Dx()
+
+	// Every pass below is split into row strips. Sample j of row y lives at
+	// a fixed index, so strips fill and read samples without coordinating.
+	if gray, ok := img.(*image.Gray16); ok {
+		samples := getSamples(w * b.Dy())[:w*b.Dy()]
+		defer func() { putSamples(samples) }()
+		parallelRows(b.Dy(), func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := 0; x < w; x++ {
+					samples[y*w+x] = gray.Gray16At(b.Min.X+x, b.Min.Y+y).Y
+				}
+			}
+		})
+		lo, hi := scaleBounds(samples, scale, true)
+
+		dst := newPooledGray16(b)
+		parallelRows(b.Dy(), func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := 0; x < w; x++ {
+					v := stretch(samples[y*w+x], lo, hi)
+					i := dst.PixOffset(b.Min.X+x, b.Min.Y+y)
+					dst.Pix[i] = uint8(v >> 8)
+					dst.Pix[i+1] = uint8(v)
+				}
+			}
+		})
+		return dst
+	}
+
+	dst := newPooledNRGBA64(b)
+	drawParallel(dst, img)
+
+	samples := getSamples(len(dst.Pix) / 8 * 3)[:len(dst.Pix)/8*3]
+	defer func() { putSamples(samples) }()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for i := y0 * dst.Stride; i < y1*dst.Stride; i += 8 {
+			for c := 0; c < 6; c += 2 {
+				samples[i/8*3+c/2] = uint16(dst.Pix[i+c])<<8 | uint16(dst.Pix[i+c+1])
+			}
+		}
+	})
+	lo, hi := scaleBounds(samples, scale, isHighBitDepth(img))
+
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for i := y0 * dst.Stride; i < y1*dst.Stride; i += 8 {
+			for c := 0; c < 6; c += 2 {
+				v := stretch(uint16(dst.Pix[i+c])<<8|uint16(dst.Pix[i+c+1]), lo, hi)
+				dst.Pix[i+c] = uint8(v >> 8)
+				dst.Pix[i+c+1] = uint8(v)
+			}
+		}
+	})
+	return dst
+}
+
+// scaleBounds resolves the stretch limits in 16-bit sample units. Fixed bounds
+// are given in the source's native units, so 8-bit values are widened first.
+func scaleBounds(samples []uint16, scale *RadiometricScale, highBitDepth bool) (uint16, uint16) {
+	switch scale.Mode {
+	case "fixed":
+		lo, hi := scale.Lo, scale.Hi
+		if !highBitDepth {
+			lo, hi = lo*257, hi*257
+		}
+		return clampSample(lo), clampSample(hi)
+	case "percentile":
+		var hist [65536]int
+		for _, s := range samples {
+			hist[s]++
+		}
+		return percentileOf(hist[:], len(samples), scale.Lo), percentileOf(hist[:], len(samples), scale.Hi)
+	default:
+		lo, hi := uint16(math.MaxUint16), uint16(0)
+		for _, s := range samples {
+			lo = min(lo, s)
+			hi = max(hi, s)
+		}
+		return lo, hi
+	}
+}
+
+func percentileOf(hist []int, total int, p float64) uint16 {
+	target := int(math.Ceil(p / 100 * float64(total)))
+	seen := 0
+	for v, n := range hist {
+		seen += n
+		if seen >= target && seen > 0 {
+			return uint16(v)
+		}
+	}
+	return math.MaxUint16
+}
+
+func stretch(v, lo, hi uint16) uint16 {
+	if hi <= lo {
+		return v
+	}
+	if v <= lo {
+		return 0
+	}
+	if v >= hi {
+		return math.MaxUint16
+	}
+	return uint16(float64(v-lo) * math.MaxUint16 / float64(hi-lo))
+}
+
+func clampSample(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(math.MaxUint16, math.Round(v))))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseScale(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *RadiometricScale
+		wantErr bool
+	}{
+		{in: ""},
+		{in: "minmax", want: &RadiometricScale{Mode: "minmax"}},
+		{in: "MinMax", want: &RadiometricScale{Mode: "minmax"}},
+		{in: "percentile", want: &RadiometricScale{Mode: "percentile", Lo: 2, Hi: 98}},
+		{in: "percentile:0.5, 99.5", want: &RadiometricScale{Mode: "percentile", Lo: 0.5, Hi: 99.5}},
+		{in: "fixed:100,4000", want: &RadiometricScale{Mode: "fixed", Lo: 100, Hi: 4000}},
+		{in: "minmax:1,2", wantErr: true},
+		{in: "percentile:-1,50", wantErr: true},
+		{in: "percentile:50,101", wantErr: true},
+		{in: "percentile:90,10", wantErr: true},
+		{in: "fixed", wantErr: true},
+		{in: "fixed:-5,10", wantErr: true},
+		{in: "fixed:10,10", wantErr: true},
+		{in: "fixed:a,b", wantErr: true},
+		{in: "gamma", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseScale(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseScale(%q) error = %v", tt.in, err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("parseScale(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStretch(t *testing.T) {
+	tests := []struct {
+		v, lo, hi, want uint16
+	}{
+		{0, 100, 200, 0},
+		{100, 100, 200, 0},
+		{150, 100, 200, 32767},
+		{200, 100, 200, 65535},
+		{60000, 100, 200, 65535},
+		// Empty bounds leave the sample as it is.
+		{150, 200, 200, 150},
+		{150, 300, 200, 150},
+	}
+	for _, tt := range tests {
+		if got := stretch(tt.v, tt.lo, tt.hi); got != tt.want {
+			t.Errorf("stretch(%d, %d, %d) = %d, want %d", tt.v, tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
+
+func TestScaleBounds(t *testing.T) {
+	// 1 to 100, so each percentile is its own sample.
+	samples := make([]uint16, 100)
+	for i := range samples {
+		samples[i] = uint16(i + 1)
+	}
+	tests := []struct {
+		scale        RadiometricScale
+		highBitDepth bool
+		lo, hi       uint16
+	}{
+		{RadiometricScale{Mode: "minmax"}, true, 1, 100},
+		{RadiometricScale{Mode: "percentile", Lo: 2, Hi: 98}, true, 2, 98},
+		{RadiometricScale{Mode: "percentile", Lo: 0, Hi: 100}, true, 1, 100},
+		{RadiometricScale{Mode: "fixed", Lo: 10, Hi: 20}, true, 10, 20},
+		// 8-bit fixed bounds are widened to 16 bits.
+		{RadiometricScale{Mode: "fixed", Lo: 10, Hi: 20}, false, 2570, 5140},
+		{RadiometricScale{Mode: "fixed", Lo: 0, Hi: 1e6}, true, 0, 65535},
+	}
+	for _, tt := range tests {
+		lo, hi := scaleBounds(samples, &tt.scale, tt.highBitDepth)
+		if lo != tt.lo || hi != tt.hi {
+			t.Errorf("scaleBounds(%+v, %v) = %d, %d, want %d, %d", tt.scale, tt.highBitDepth, lo, hi, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestPercentileOf(t *testing.T) {
+	hist := make([]int, 65536)
+	hist[10] = 50
+	hist[1000] = 50
+	for _, tt := range []struct {
+		p    float64
+		want uint16
+	}{
+		{0, 10},
+		{50, 10},
+		{51, 1000},
+		{100, 1000},
+	} {
+		if got := percentileOf(hist, 100, tt.p); got != tt.want {
+			t.Errorf("percentileOf(%v) = %d, want %d", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestApplyScale(t *testing.T) {
+	// A gray ramp from 1000 to 2000, tall enough to be split into strips.
+	gray := image.NewGray16(image.Rect(0, 0, 11, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 11; x++ {
+			gray.SetGray16(x, y, color.Gray16{Y: uint16(1000 + x*100)})
+		}
+	}
+	out, ok := applyScale(gray, &RadiometricScale{Mode: "minmax"}).(*image.Gray16)
+	if !ok {
+		t.Fatalf("gray scaled to %T", out)
+	}
+	for _, y := range []int{0, 199} {
+		if lo, hi := out.Gray16At(0, y).Y, out.Gray16At(10, y).Y; lo != 0 || hi != 65535 {
+			t.Errorf("row %d stretched to %d..%d", y, lo, hi)
+		}
+	}
+	if mid := out.Gray16At(5, 100).Y; mid != 32767 {
+		t.Errorf("middle stretched to %d", mid)
+	}
+
+	// 8-bit color is widened and stretched in every channel.
+	rgb := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	rgb.Set(0, 0, color.RGBA{10, 20, 30, 255})
+	rgb.Set(1, 0, color.RGBA{20, 30, 40, 255})
+	scaled := applyScale(rgb, &RadiometricScale{Mode: "fixed", Lo: 10, Hi: 40})
+	if _, ok := scaled.(*image.NRGBA64); !ok {
+		t.Fatalf("color scaled to %T", scaled)
+	}
+	if r, g, b, _ := scaled.At(0, 0).RGBA(); r != 0 || g != 21845 || b != 43690 {
+		t.Errorf("first pixel stretched to %d, %d, %d", r, g, b)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route classes, each with its own RATE_LIMIT_ setting and buckets. JSON
+// routes are the cheap ones served within REQUEST_TIMEOUT; image routes read
+// or render imagery within PROCESSING_TIMEOUT.
+const (
+	rateClassJSON  = "json"
+	rateClassImage = "image"
+)
+
+var rateClasses = []string{rateClassJSON, rateClassImage}
+
+// rateSweepInterval is how often buckets that have refilled are dropped, so
+// clients that went away stop taking memory.
+const rateSweepInterval = time.Minute
+
+// RateLimit allows Requests per Per to each client, in bursts of up to
+// Requests. The zero value allows everything.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l RateLimit) enabled() bool { return l.Requests > 0 && l.Per > 0 }
+
+// perSecond is the rate the bucket refills at.
+func (l RateLimit) perSecond() float64 { return float64(l.Requests) / l.Per.Seconds() }
+
+// parseRateLimit reads a limit such as "600/m", "10/s" or "1000/1h".
+func parseRateLimit(v string) (RateLimit, error) {
+	n, per, ok := strings.Cut(v, "/")
+	requests, err := strconv.Atoi(strings.TrimSpace(n))
+	if !ok || err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("%q is not a limit like 600/m", v)
+	}
+	per = strings.TrimSpace(per)
+	if per == "s" || per == "m" || per == "h" {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("%q is not a limit like 600/m", v)
+	}
+	return RateLimit{Requests: requests, Per: d}, nil
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter keeps a token bucket per client and route class. Buckets live
+// in this process, so each instance allows the full rate.
+type RateLimiter struct {
+	limits map[string]RateLimit
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{limits: limits, now: time.Now, buckets: make(map[string]*tokenBucket)}
+}
+
+// Start drops full buckets every rateSweepInterval until ctx is done.
+func (l *RateLimiter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(rateSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.sweep()
+			}
+		}
+	}()
+}
+
+func (l *RateLimiter) sweep() {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		class, _, _ := strings.Cut(key, "\x00")
+		if limit := l.limits[class]; now.Sub(b.updated) >= limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// bucket returns the client's bucket for class refilled to now, creating a
+// full one if there is none. l.mu must be held.
+func (l *RateLimiter) bucket(class, client string, limit RateLimit, now time.Time) *tokenBucket {
+	key := class + "\x00" + client
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Requests), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*limit.perSecond())
+	b.updated = now
+	return b
+}
+
+// Allow takes a token from the client's bucket for class. When it is empty
+// it returns false and how long until a token is available. remaining is
+// what is left afterwards.
+func (l *RateLimiter) Allow(class, client string) (ok bool, remaining int, retryAfter time.Duration) {
+	if l == nil || !l.limits[class].enabled() {
+		return true, 0, 0
+	}
+	limit := l.limits[class]
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(class, client, limit, now)
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / limit.perSecond()
+		return false, 0, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// RateLimitUsage is a client's standing in one route class.
+type RateLimitUsage struct {
+	Class      string `json:"class"`
+	Limit      int    `json:"limit"`
+	PerSeconds int64  `json:"per_seconds"`
+	Remaining  int    `json:"remaining"`
+	// Reset is the number of seconds until the bucket is full again.
+	Reset int64 `json:"reset"`
+}
+
+// RateLimitResponse is the body of GET /rate-limits.
+type RateLimitResponse struct {
+	Client string           `json:"client"`
+	Limits []RateLimitUsage `json:"limits"`
+}
+
+// Usage reports the client's remaining requests in every limited class,
+// without taking any.
+func (l *RateLimiter) Usage(client string) []RateLimitUsage {
+	usage := []RateLimitUsage{}
+	if l == nil {
+		return usage
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, class := range rateClasses {
+		limit := l.limits[class]
+		if !limit.enabled() {
+			continue
+		}
+		b := l.bucket(class, client, limit, now)
+		usage = append(usage, RateLimitUsage{
+			Class:      class,
+			Limit:      limit.Requests,
+			PerSeconds: int64(limit.Per.Seconds()),
+			Remaining:  int(b.tokens),
+			Reset:      int64(math.Ceil((float64(limit.Requests) - b.tokens) / limit.perSecond())),
+		})
+	}
+	return usage
+}
+
+// rateClient is who a request is counted against: the authenticated
+// caller, or the client address of an anonymous one.
+func rateClient(c *gin.Context) string {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(*Principal).ID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// rateLimit counts requests against the caller's bucket for class, and
+// answers 429 with Retry-After once it is empty. It runs after the scope
+// check, which identifies the caller.
+func (api *API) rateLimit(class string) gin.HandlerFunc {
+	limit := api.Config.RateLimits[class]
+	return func(c *gin.Context) {
+		if api.RateLimiter == nil || !limit.enabled() {
+			c.Next()
+			return
+		}
+		ok, remaining, wait := api.RateLimiter.Allow(class, rateClient(c))
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			rateLimited.WithLabelValues(class).Inc()
+			seconds := int64(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+			respondProblem(c, newProblem(http.StatusTooManyRequests, CodeRateLimited,
+				fmt.Sprintf("Rate limit of %d %s requests per %s exceeded. Retry in %d s.", limit.Requests, class, limit.Per, seconds)).
+				with("limit", limit.Requests).with("retry_after", seconds))
+			return
+		}
+		c.Next()
+	}
+}
+
+func (api *API) getRateLimits(c *gin.Context) {
+	client := rateClient(c)
+	c.IndentedJSON(http.StatusOK, RateLimitResponse{Client: client, Limits: api.RateLimiter.Usage(client)})
+}
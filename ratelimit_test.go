@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want RateLimit
+		ok   bool
+	}{
+		{"600/m", RateLimit{600, time.Minute}, true},
+		{"10/s", RateLimit{10, time.Second}, true},
+		{" 1000 / 1h ", RateLimit{1000, time.Hour}, true},
+		{"5/30s", RateLimit{5, 30 * time.Second}, true},
+		{"600", RateLimit{}, false},
+		{"0/m", RateLimit{}, false},
+		{"ten/m", RateLimit{}, false},
+		{"10/day", RateLimit{}, false},
+		{"10/-1m", RateLimit{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseRateLimit(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Unix(1791936000, 0)
+	l := newRateLimiter(map[string]RateLimit{rateClassImage: {Requests: 2, Per: time.Minute}})
+	l.now = func() time.Time { return now }
+
+	for i, wantRemaining := range []int{1, 0} {
+		if ok, remaining, _ := l.Allow(rateClassImage, "k1"); !ok || remaining != wantRemaining {
+			t.Fatalf("request %d: ok %v, remaining %d", i, ok, remaining)
+		}
+	}
+	if ok, _, wait := l.Allow(rateClassImage, "k1"); ok || wait != 30*time.Second {
+		t.Fatalf("over the limit: ok %v, wait %v", ok, wait)
+	}
+	if ok, _, _ := l.Allow(rateClassImage, "k2"); !ok {
+		t.Error("another client was limited")
+	}
+	if ok, _, _ := l.Allow(rateClassJSON, "k1"); !ok {
+		t.Error("an unlimited class was limited")
+	}
+	if usage := l.Usage("k1"); len(usage) != 1 || usage[0].Remaining != 0 || usage[0].Reset != 60 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	now = now.Add(30 * time.Second)
+	if ok, _, _ := l.Allow(rateClassImage, "k1"); !ok {
+		t.Error("bucket did not refill")
+	}
+	now = now.Add(time.Minute)
+	l.sweep()
+	if len(l.buckets) != 0 {
+		t.Errorf("%d buckets left after sweep", len(l.buckets))
+	}
+}
+
+func TestRateLimitRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &Config{AdminToken: "secret", RateLimits: map[string]RateLimit{rateClassJSON: {Requests: 1, Per: time.Hour}}}
+	api := &API{Config: cfg, RateLimiter: newRateLimiter(cfg.RateLimits), Roles: newRoleStore(nil, "")}
+	router := gin.New()
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	do := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("/roles", "secret"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: %d %v", w.Code, w.Header())
+	}
+	w := do("/roles", "secret")
+	var p Problem
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" || json.Unmarshal(w.Body.Bytes(), &p) != nil || p.Code != CodeRateLimited {
+		t.Fatalf("second request: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+
+	w = do("/rate-limits", "secret")
+	var usage RateLimitResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &usage) != nil || usage.Client != "admin" ||
+		len(usage.Limits) != 1 || usage.Limits[0].Class != rateClassJSON || usage.Limits[0].Remaining != 0 {
+		t.Errorf("usage: %d %s", w.Code, w.Body)
+	}
+	if w := do("/rate-limits", ""); w.Code != http.StatusOK || !json.Valid(w.Body.Bytes()) {
+		t.Errorf("anonymous usage: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleApprover = "approver"
+	RoleAdmin    = "admin"
+)
+
+var roles = []string{RoleViewer, RoleOperator, RoleApprover, RoleAdmin}
+
+// roleScopes are the scopes each role grants. Viewers read; operators also
+// change missions and images; approvers read and approve; admins do
+// everything.
+var roleScopes = map[string][]string{
+	RoleViewer:   {ScopeMissionsRead, ScopeImagesRead},
+	RoleOperator: {ScopeMissionsRead, ScopeMissionsWrite, ScopeImagesRead, ScopeImagesWrite},
+	RoleApprover: {ScopeMissionsRead, ScopeImagesRead, ScopeMissionsApprove},
+	RoleAdmin:    {ScopeAdmin},
+}
+
+const (
+	// With ROLES_TABLE set, the assignments are reread every roleRefresh,
+	// so a change made through another instance applies within it.
+	roleRefresh       = time.Minute
+	maxRoleAssignees  = 10000
+	maxSubjectIDBytes = 256
+)
+
+var errRoleAssignmentNotFound = errors.New("role assignment not found")
+
+// RoleAssignment grants roles to a subject: an API key's ID or an OIDC
+// token's sub.
+type RoleAssignment struct {
+	Subject string   `dynamodbav:"id" json:"subject"`
+	Roles   []string `dynamodbav:"roles" json:"roles"`
+	// Tenant is the tenant the assigning request acted for with
+	// MULTI_TENANT. The roles are only granted to a subject acting for it.
+	Tenant  string `dynamodbav:"tenant,omitempty" json:"tenant,omitempty"`
+	Updated int64  `dynamodbav:"updated" json:"updated"`
+}
+
+// RoleStore holds the role assignments. When ROLES_TABLE is set they are
+// saved to DynamoDB and shared by every instance; without it they live only
+// in this process.
+type RoleStore struct {
+	mu          sync.RWMutex
+	assignments map[string]*RoleAssignment
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newRoleStore(db *dynamodb.Client, table string) *RoleStore {
+	s := &RoleStore{assignments: make(map[string]*RoleAssignment), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the assignments and rereads them until ctx is done.
+func (s *RoleStore) Start(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load role assignments", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(roleRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload role assignments", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Put replaces the roles assigned to a.Subject.
+func (s *RoleStore) Put(ctx context.Context, a RoleAssignment) (RoleAssignment, error) {
+	s.mu.RLock()
+	_, exists := s.assignments[a.Subject]
+	n := len(s.assignments)
+	s.mu.RUnlock()
+	if !exists && n >= maxRoleAssignees {
+		return RoleAssignment{}, newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("At most %d subjects may be assigned roles.", maxRoleAssignees))
+	}
+	a.Updated = time.Now().Unix()
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(a)
+		if err != nil {
+			return RoleAssignment{}, fmt.Errorf("marshal role assignment: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return RoleAssignment{}, err
+		}
+	}
+	stored := a
+	s.mu.Lock()
+	s.assignments[a.Subject] = &stored
+	s.mu.Unlock()
+	return a, nil
+}
+
+func (s *RoleStore) Get(subject string) (RoleAssignment, error) {
+	if s == nil {
+		return RoleAssignment{}, errRoleAssignmentNotFound
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.assignments[subject]
+	if !ok {
+		return RoleAssignment{}, errRoleAssignmentNotFound
+	}
+	return *a, nil
+}
+
+// List returns every assignment in subject order.
+func (s *RoleStore) List() []RoleAssignment {
+	s.mu.RLock()
+	list := make([]RoleAssignment, 0, len(s.assignments))
+	for _, a := range s.assignments {
+		list = append(list, *a)
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	return list
+}
+
+// Delete removes the subject's roles.
+func (s *RoleStore) Delete(ctx context.Context, subject string) error {
+	if _, err := s.Get(subject); err != nil {
+		return err
+	}
+	if s.db != nil {
+		_, err := s.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: subject},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	delete(s.assignments, subject)
+	s.mu.Unlock()
+	return nil
+}
+
+// refresh replaces the assignments with those in ROLES_TABLE.
+func (s *RoleStore) refresh(ctx context.Context) error {
+	assignments := make(map[string]*RoleAssignment)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []RoleAssignment
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for i := range items {
+			assignments[items[i].Subject] = &items[i]
+		}
+	}
+	s.mu.Lock()
+	s.assignments = assignments
+	s.mu.Unlock()
+	return nil
+}
+
+// grant returns p with the roles assigned to it and their scopes added,
+// when actsFor accepts the tenant they were assigned for.
+func (s *RoleStore) grant(p *Principal, actsFor func(tenant string) bool) *Principal {
+	a, err := s.Get(p.ID)
+	if err != nil || !actsFor(a.Tenant) {
+		return p
+	}
+	granted := *p
+	granted.Roles = slices.Compact(slices.Sorted(slices.Values(append(slices.Clone(p.Roles), a.Roles...))))
+	granted.Scopes = slices.Clone(p.Scopes)
+	for _, role := range a.Roles {
+		granted.Scopes = append(granted.Scopes, roleScopes[role]...)
+	}
+	granted.Scopes = slices.Compact(slices.Sorted(slices.Values(granted.Scopes)))
+	return &granted
+}
+
+// roleAssignmentRequest is the body of PUT /role-assignments/:subject.
+type roleAssignmentRequest struct {
+	Roles []string `json:"roles"`
+}
+
+func (req roleAssignmentRequest) validate(subject string) (RoleAssignment, error) {
+	if subject == "" || len(subject) > maxSubjectIDBytes {
+		return RoleAssignment{}, newProblem(http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("The subject must be 1 to %d bytes.", maxSubjectIDBytes))
+	}
+	if len(req.Roles) == 0 {
+		return RoleAssignment{}, newProblem(http.StatusBadRequest, CodeInvalidBody, "'roles' must name at least one role. DELETE the assignment to remove every role.")
+	}
+	for _, role := range req.Roles {
+		if !slices.Contains(roles, role) {
+			return RoleAssignment{}, newProblem(http.StatusBadRequest, CodeInvalidBody,
+				fmt.Sprintf("Unknown role %q. Must be one of %s.", role, strings.Join(roles, ", ")))
+		}
+	}
+	return RoleAssignment{Subject: subject, Roles: slices.Compact(slices.Sorted(slices.Values(req.Roles)))}, nil
+}
+
+// Role is a role and the scopes it grants, as listed by GET /roles.
+type Role struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// RoleListResponse is the body of GET /roles.
+type RoleListResponse struct {
+	Roles []Role `json:"roles"`
+}
+
+// RoleAssignmentListResponse is the body of GET /role-assignments.
+type RoleAssignmentListResponse struct {
+	Assignments []RoleAssignment `json:"assignments"`
+}
+
+func getRoles(c *gin.Context) {
+	list := make([]Role, len(roles))
+	for i, name := range roles {
+		list[i] = Role{Name: name, Scopes: roleScopes[name]}
+	}
+	c.IndentedJSON(http.StatusOK, RoleListResponse{Roles: list})
+}
+
+// getRoleAssignments lists the assignments of the caller's tenant, or
+// every assignment for ADMIN_TOKEN.
+func (api *API) getRoleAssignments(c *gin.Context) {
+	assignments := []RoleAssignment{}
+	for _, a := range api.Roles.List() {
+		if api.ownsTenant(c.Request.Context(), a.Tenant) {
+			assignments = append(assignments, a)
+		}
+	}
+	c.IndentedJSON(http.StatusOK, RoleAssignmentListResponse{Assignments: assignments})
+}
+
+// tenantRoleAssignment returns the assignment of subject made for the
+// caller's tenant. Another tenant's is not found.
+func (api *API) tenantRoleAssignment(ctx context.Context, subject string) (RoleAssignment, error) {
+	a, err := api.Roles.Get(subject)
+	if err == nil && !api.ownsTenant(ctx, a.Tenant) {
+		return RoleAssignment{}, errRoleAssignmentNotFound
+	}
+	return a, err
+}
+
+func (api *API) getRoleAssignment(c *gin.Context) {
+	a, err := api.tenantRoleAssignment(c.Request.Context(), c.Param("subject"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeRoleAssignmentNotFound, "role assignment not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, a)
+}
+
+// putRoleAssignment replaces the roles of a subject, which need not have
+// authenticated yet, for the tenant the request acts for. A subject
+// assigned roles for another tenant is not found.
+func (api *API) putRoleAssignment(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req roleAssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"roles\": [...]}.")
+		return
+	}
+	if existing, err := api.Roles.Get(c.Param("subject")); err == nil && !api.ownsTenant(ctx, existing.Tenant) {
+		respondError(c, http.StatusNotFound, CodeRoleAssignmentNotFound, "role assignment not found")
+		return
+	}
+	a, err := req.validate(c.Param("subject"))
+	if err == nil {
+		a.Tenant = tenantFrom(ctx)
+		a, err = api.Roles.Put(ctx, a)
+	}
+	if err != nil {
+		var p *Problem
+		if !errors.As(err, &p) {
+			slog.ErrorContext(c.Request.Context(), "failed to save role assignment", "err", err)
+		}
+		respondProblem(c, problemFor(err))
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "assigned roles", "subject", a.Subject, "roles", a.Roles)
+	c.IndentedJSON(http.StatusOK, a)
+}
+
+func (api *API) deleteRoleAssignment(c *gin.Context) {
+	subject := c.Param("subject")
+	_, err := api.tenantRoleAssignment(c.Request.Context(), subject)
+	if err == nil {
+		err = api.Roles.Delete(c.Request.Context(), subject)
+	}
+	if err != nil {
+		if errors.Is(err, errRoleAssignmentNotFound) {
+			respondError(c, http.StatusNotFound, CodeRoleAssignmentNotFound, "role assignment not found")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to delete role assignment", "subject", subject, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete role assignment")
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "removed roles", "subject", subject)
+	c.Status(http.StatusNoContent)
+}
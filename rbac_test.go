@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoleStoreGrant(t *testing.T) {
+	ctx := context.Background()
+	s := newRoleStore(nil, "")
+	if _, err := s.Put(ctx, RoleAssignment{Subject: "user-1", Roles: []string{RoleApprover}}); err != nil {
+		t.Fatal(err)
+	}
+	p := &Principal{ID: "user-1", Scopes: []string{ScopeImagesWrite}, Roles: []string{"sat-ops"}}
+	anyTenant := func(string) bool { return true }
+	got := s.grant(p, anyTenant)
+	if want := []string{ScopeImagesRead, ScopeImagesWrite, ScopeMissionsApprove, ScopeMissionsRead}; !slices.Equal(got.Scopes, want) {
+		t.Errorf("scopes = %v, want %v", got.Scopes, want)
+	}
+	if want := []string{RoleApprover, "sat-ops"}; !slices.Equal(got.Roles, want) {
+		t.Errorf("roles = %v, want %v", got.Roles, want)
+	}
+	if len(p.Scopes) != 1 {
+		t.Errorf("grant changed its argument: %+v", p)
+	}
+	if other := (&Principal{ID: "user-2"}); s.grant(other, anyTenant) != other {
+		t.Error("unassigned subject was granted roles")
+	}
+}
+
+func TestRoleAssignmentRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		req     roleAssignmentRequest
+		ok      bool
+	}{
+		{"roles", "user-1", roleAssignmentRequest{Roles: []string{RoleViewer, RoleOperator, RoleViewer}}, true},
+		{"no roles", "user-1", roleAssignmentRequest{}, false},
+		{"unknown role", "user-1", roleAssignmentRequest{Roles: []string{"owner"}}, false},
+		{"scope", "user-1", roleAssignmentRequest{Roles: []string{ScopeMissionsWrite}}, false},
+		{"long subject", strings.Repeat("s", maxSubjectIDBytes+1), roleAssignmentRequest{Roles: []string{RoleViewer}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := tt.req.validate(tt.subject)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && (a.Subject != tt.subject || !slices.Equal(a.Roles, []string{RoleOperator, RoleViewer})) {
+				t.Errorf("assignment = %+v", a)
+			}
+		})
+	}
+}
+
+func TestRoleEnforcement(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	creds := &Credentials{Keys: newAPIKeyStore(nil, ""), Roles: newRoleStore(nil, "")}
+	cfg := &Config{AuthRequired: true}
+	router := gin.New()
+	for _, scope := range scopes {
+		router.GET("/"+strings.ReplaceAll(scope, ":", "/"), requireScope(cfg, creds, scope, false), func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+	}
+	tokens := make(map[string]string)
+	for _, role := range roles {
+		// The keys carry only missions:read, so anything else they may do
+		// comes from their role.
+		key, err := creds.Keys.Create(ctx, APIKey{Scopes: []string{ScopeMissionsRead}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := creds.Roles.Put(ctx, RoleAssignment{Subject: key.ID, Roles: []string{role}}); err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = key.Key
+	}
+
+	allowed := map[string][]string{
+		RoleViewer:   {ScopeMissionsRead, ScopeImagesRead},
+		RoleOperator: {ScopeMissionsRead, ScopeMissionsWrite, ScopeImagesRead, ScopeImagesWrite},
+		RoleApprover: {ScopeMissionsRead, ScopeMissionsApprove, ScopeImagesRead},
+		RoleAdmin:    scopes,
+	}
+	for _, role := range roles {
+		for _, scope := range scopes {
+			req := httptest.NewRequest(http.MethodGet, "/"+strings.ReplaceAll(scope, ":", "/"), nil)
+			req.Header.Set("Authorization", "Bearer "+tokens[role])
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			want := http.StatusForbidden
+			if slices.Contains(allowed[role], scope) {
+				want = http.StatusNoContent
+			}
+			if w.Code != want {
+				t.Errorf("%s with %s: status = %d, want %d", role, scope, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestRoleAssignmentRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{Config: &Config{AdminToken: "secret"}, Roles: newRoleStore(nil, "")}
+	router := gin.New()
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPut, "/role-assignments/user-1", `{"roles": ["everything"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid put: status = %d", w.Code)
+	}
+	w := do(http.MethodPut, "/role-assignments/user-1", `{"roles": ["viewer"]}`)
+	var a RoleAssignment
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &a) != nil || a.Subject != "user-1" || a.Updated == 0 {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	w = do(http.MethodGet, "/role-assignments", "")
+	var list RoleAssignmentListResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list.Assignments) != 1 {
+		t.Errorf("list: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/role-assignments/user-1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/role-assignments/user-1", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), string(CodeRoleAssignmentNotFound)) {
+		t.Errorf("get after delete: %d %s", w.Code, w.Body)
+	}
+	var roleList RoleListResponse
+	if w := do(http.MethodGet, "/roles", ""); json.Unmarshal(w.Body.Bytes(), &roleList) != nil || len(roleList.Roles) != len(roles) {
+		t.Errorf("roles: %d %s", w.Code, w.Body)
+	}
+}
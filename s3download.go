@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultDownloadPartMB      = 16
+	defaultDownloadConcurrency = 8
+)
+
+// Large objects are read in parallel ranged GETs, and listed buckets
+// through S3 Transfer Acceleration. Every read goes through the package
+// functions below, so configureDownloads sets these from cfg at startup.
+var (
+	accelerateBuckets   []string
+	downloadPartSize    int64 = defaultDownloadPartMB << 20
+	downloadConcurrency       = defaultDownloadConcurrency
+)
+
+// configureDownloads applies cfg to every object read. Acceleration is an
+// AWS feature, so other STORAGE_BACKENDs never use it.
+func configureDownloads(cfg *Config) {
+	accelerateBuckets = nil
+	if cfg.StorageBackend == "s3" {
+		accelerateBuckets = cfg.S3AccelerateBuckets
+	}
+	downloadPartSize = cfg.DownloadPartSize
+	downloadConcurrency = cfg.DownloadConcurrency
+}
+
+// s3Accelerate returns the per-request option that routes a call through S3
+// Transfer Acceleration when bucket is listed in S3_ACCELERATE_BUCKETS. The
+// bucket must have acceleration enabled, which is why it is opt-in per bucket.
+func s3Accelerate(bucket string) []func(*s3.Options) {
+	if !slices.Contains(accelerateBuckets, bucket) && !slices.Contains(accelerateBuckets, "*") {
+		return nil
+	}
+	return []func(*s3.Options){func(o *s3.Options) { o.UseAccelerate = true }}
+}
+
+// downloadConfig returns the part size and number of parallel ranged GETs
+// used to read large objects.
+func downloadConfig() (partSize int64, concurrency int) {
+	return downloadPartSize, downloadConcurrency
+}
+
+// memoryBody stands in for an object body that has already been read into
+// memory, so readObject does not fetch it again.
+type memoryBody struct{ *bytes.Reader }
+
+func (memoryBody) Close() error { return nil }
+
+// firstPart is the Range header for the opening GET of an object that is
+// about to be read in full with readObject. Small objects come back whole;
+// for larger ones the Content-Range reveals the size, and readObject fetches
+// the rest in parallel while this response is still being read.
+func firstPart() *string {
+	partSize, _ := downloadConfig()
+	return aws.String(fmt.Sprintf("bytes=0-%d", partSize-1))
+}
+
+// contentRange parses a "bytes first-last/size" Content-Range value.
+func contentRange(v string) (first, last, size int64, ok bool) {
+	if _, err := fmt.Sscanf(v, "bytes %d-%d/%d", &first, &last, &size); err != nil {
+		return 0, 0, 0, false
+	}
+	return first, last, size, first <= last && last < size
+}
+
+// objectSize is the size of the whole object behind out, which for a ranged
+// response is not its ContentLength.
+func objectSize(out *s3.GetObjectOutput) int64 {
+	if _, _, size, ok := contentRange(aws.ToString(out.ContentRange)); ok {
+		return size
+	}
+	return aws.ToInt64(out.ContentLength)
+}
+
+// readObject reads the whole of the object behind out into a pooled buffer,
+// which the caller returns with putBuffer. body is out.Body or a reader
+// wrapping it. When out answered a firstPart GET of a larger object, the body
+// supplies the first part while the remaining ones are fetched as parallel
+// ranged GETs, each pinned to the same ETag so a concurrent overwrite fails
+// the read rather than splicing two versions together.
+func (api *API) readObject(ctx context.Context, bucket, key string, out *s3.GetObjectOutput, body io.Reader) (*bytes.Buffer, error) {
+	first, last, size, ranged := contentRange(aws.ToString(out.ContentRange))
+	if _, inMemory := out.Body.(memoryBody); inMemory || !ranged || first != 0 || last+1 == size {
+		return readBody(body, aws.ToInt64(out.ContentLength))
+	}
+
+	partSize, concurrency := downloadConfig()
+	buf := getBuffer()
+	buf.Grow(int(size))
+	// The parts are written straight into the buffer's spare capacity, and
+	// the final Write below then only extends its length over them.
+	data := buf.AvailableBuffer()[:size]
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency + 1)
+	g.Go(func() error {
+		_, err := io.ReadFull(body, data[:last+1])
+		return err
+	})
+	for off := last + 1; off < size; off += partSize {
+		end := min(off+partSize, size)
+		g.Go(func() error {
+			part, err := api.S3.GetObject(gctx, &s3.GetObjectInput{
+				Bucket:  aws.String(bucket),
+				Key:     aws.String(key),
+				Range:   aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+				IfMatch: out.ETag,
+			}, s3Accelerate(bucket)...)
+			if err != nil {
+				return fmt.Errorf("get bytes %d-%d: %w", off, end-1, err)
+			}
+			defer part.Body.Close()
+			_, err = io.ReadFull(part.Body, data[off:end])
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	buf.Write(data)
+	return buf, nil
+}
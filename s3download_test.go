@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 serves one object over the S3 REST API, honoring Range and
+// If-Match. ignoreRange makes it answer every GET with the whole object, as
+// a proxy that strips Range would. onRanged runs before each GET after the
+// first, to simulate an overwrite mid-download.
+type fakeS3 struct {
+	mu          sync.Mutex
+	data        []byte
+	etag        string
+	ignoreRange bool
+	gets        int
+	onRanged    func(*fakeS3)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.gets++
+	if f.gets > 1 && f.onRanged != nil {
+		f.onRanged(f)
+	}
+	data, etag := f.data, f.etag
+	ignoreRange := f.ignoreRange
+	f.mu.Unlock()
+
+	if im := r.Header.Get("If-Match"); im != "" && im != etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	var first, last int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last); err != nil || ignoreRange {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+		return
+	}
+	last = min(last, len(data)-1)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+	w.Header().Set("Content-Length", strconv.Itoa(last-first+1))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(data[first : last+1])
+}
+
+func testObject(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i>>11)
+	}
+	return data
+}
+
+func TestReadObject(t *testing.T) {
+	const mb = 1 << 20
+	defer func(size int64, n int) { downloadPartSize, downloadConcurrency = size, n }(downloadConfig())
+	downloadPartSize, downloadConcurrency = mb, 3
+
+	tests := []struct {
+		name        string
+		size        int
+		ignoreRange bool
+		overwrite   bool
+		wantGets    int
+		wantErr     bool
+	}{
+		{name: "smaller than a part", size: mb / 2, wantGets: 1},
+		{name: "exactly one part", size: mb, wantGets: 1},
+		{name: "uneven tail", size: 3*mb + 12345, wantGets: 4},
+		{name: "whole parts", size: 4 * mb, wantGets: 4},
+		{name: "range not honored", size: 3 * mb, ignoreRange: true, wantGets: 1},
+		{name: "overwritten mid-read", size: 3 * mb, overwrite: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := testObject(tt.size)
+			f := &fakeS3{data: want, etag: `"v1"`, ignoreRange: tt.ignoreRange}
+			if tt.overwrite {
+				f.onRanged = func(f *fakeS3) { f.etag = `"v2"` }
+			}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			client := s3.New(s3.Options{
+				Region:       "us-east-1",
+				BaseEndpoint: aws.String(srv.URL),
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+			})
+			api := &API{S3: client}
+			ctx := context.Background()
+
+			out, err := client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("images/x.tif"),
+				Range:  firstPart(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Body.Close()
+			if got := objectSize(out); got != int64(tt.size) {
+				t.Errorf("objectSize = %d, want %d", got, tt.size)
+			}
+
+			buf, err := api.readObject(ctx, "bucket", "images/x.tif", out, out.Body)
+			if tt.wantErr {
+				if err == nil {
+					putBuffer(buf)
+					t.Fatal("readObject succeeded across an overwrite")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer putBuffer(buf)
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("read %d bytes that differ from the %d-byte source", buf.Len(), len(want))
+			}
+			if f.gets != tt.wantGets {
+				t.Errorf("made %d GETs, want %d", f.gets, tt.wantGets)
+			}
+		})
+	}
+}
+
+func TestReadObjectInMemory(t *testing.T) {
+	want := testObject(2 << 20)
+	out := &s3.GetObjectOutput{
+		Body:          memoryBody{bytes.NewReader(want)},
+		ContentLength: aws.Int64(int64(len(want))),
+		ContentRange:  aws.String(fmt.Sprintf("bytes 0-1023/%d", len(want))),
+	}
+	// No client: an in-memory body must never be fetched again.
+	buf, err := (&API{}).readObject(context.Background(), "bucket", "key", out, out.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer putBuffer(buf)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Error("in-memory body was not read as is")
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	tests := []struct {
+		in                string
+		first, last, size int64
+		ok                bool
+	}{
+		{"bytes 0-99/1000", 0, 99, 1000, true},
+		{"bytes 100-999/1000", 100, 999, 1000, true},
+		{"bytes 0-999/1000", 0, 999, 1000, true},
+		{"bytes 0-1000/1000", 0, 0, 0, false},
+		{"bytes */1000", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		first, last, size, ok := contentRange(tt.in)
+		if ok != tt.ok || (ok && (first != tt.first || last != tt.last || size != tt.size)) {
+			t.Errorf("contentRange(%q) = %d, %d, %d, %v", tt.in, first, last, size, ok)
+		}
+	}
+}
+
+func TestS3Accelerate(t *testing.T) {
+	defer func(buckets []string) { accelerateBuckets = buckets }(accelerateBuckets)
+	accelerate := func(backend string, buckets ...string) {
+		partSize, concurrency := downloadConfig()
+		configureDownloads(&Config{StorageBackend: backend, S3AccelerateBuckets: buckets, DownloadPartSize: partSize, DownloadConcurrency: concurrency})
+	}
+	accelerate("s3", "other", "images")
+	if len(s3Accelerate("images")) != 1 {
+		t.Error("listed bucket not accelerated")
+	}
+	if s3Accelerate("plain") != nil {
+		t.Error("unlisted bucket accelerated")
+	}
+	accelerate("minio", "images")
+	if s3Accelerate("images") != nil {
+		t.Error("bucket accelerated on minio")
+	}
+}
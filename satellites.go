@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SatelliteActive   = "active"
+	SatelliteInactive = "inactive"
+	SatelliteDecayed  = "decayed"
+	SatelliteUnknown  = "unknown"
+)
+
+var satelliteStatuses = []string{SatelliteActive, SatelliteInactive, SatelliteDecayed, SatelliteUnknown}
+
+const (
+	// With SATELLITES_TABLE set, the catalog is reread every
+	// satelliteRefresh, so a change made through another instance applies
+	// within it.
+	satelliteRefresh = time.Minute
+	maxSatellites    = 100000
+	maxNoradID       = 999999999
+)
+
+var (
+	errSatelliteNotFound = errors.New("satellite not found")
+	satelliteID          = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+// Satellite is a catalog entry for a spacecraft that missions name as their
+// target or observer.
+type Satellite struct {
+	ID       string `dynamodbav:"id" json:"id"`
+	NoradID  int    `dynamodbav:"norad_id" json:"norad_id"`
+	Name     string `dynamodbav:"name" json:"name"`
+	Operator string `dynamodbav:"operator,omitempty" json:"operator,omitempty"`
+	// RCS is the radar cross-section in square metres.
+	RCS     float64 `dynamodbav:"rcs,omitempty" json:"rcs,omitempty"`
+	Status  string  `dynamodbav:"status" json:"status"`
+	TLE     *TLE    `dynamodbav:"tle,omitempty" json:"tle,omitempty"`
+	Updated int64   `dynamodbav:"updated" json:"updated"`
+}
+
+// SatelliteStore holds the satellite catalog. When SATELLITES_TABLE is set
+// it is saved to DynamoDB and shared by every instance; without it, it lives
+// only in this process. Every tenant shares the one catalog.
+type SatelliteStore struct {
+	mu         sync.RWMutex
+	satellites map[string]*Satellite
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newSatelliteStore(db *dynamodb.Client, table string) *SatelliteStore {
+	s := &SatelliteStore{satellites: make(map[string]*Satellite), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the catalog and rereads it until ctx is done.
+func (s *SatelliteStore) Start(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load satellites", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(satelliteRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload satellites", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Put creates or replaces the satellite sat.ID, reporting whether it is new.
+func (s *SatelliteStore) Put(ctx context.Context, sat Satellite) (Satellite, bool, error) {
+	s.mu.RLock()
+	_, exists := s.satellites[sat.ID]
+	n := len(s.satellites)
+	s.mu.RUnlock()
+	if !exists && n >= maxSatellites {
+		return Satellite{}, false, newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("The catalog holds at most %d satellites.", maxSatellites))
+	}
+	sat.Updated = time.Now().Unix()
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(sat)
+		if err != nil {
+			return Satellite{}, false, fmt.Errorf("marshal satellite: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return Satellite{}, false, err
+		}
+	}
+	stored := sat
+	s.mu.Lock()
+	s.satellites[sat.ID] = &stored
+	s.mu.Unlock()
+	return sat, !exists, nil
+}
+
+func (s *SatelliteStore) Get(id string) (Satellite, error) {
+	if s == nil {
+		return Satellite{}, errSatelliteNotFound
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sat, ok := s.satellites[id]
+	if !ok {
+		return Satellite{}, errSatelliteNotFound
+	}
+	return *sat, nil
+}
+
+// ByNorad returns the satellite with the NORAD catalog number, the first by
+// ID if several have it.
+func (s *SatelliteStore) ByNorad(norad int) (Satellite, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found *Satellite
+	for _, sat := range s.satellites {
+		if sat.NoradID == norad && (found == nil || sat.ID < found.ID) {
+			found = sat
+		}
+	}
+	if found == nil {
+		return Satellite{}, false
+	}
+	return *found, true
+}
+
+// List returns the satellites with status, or every satellite when it is
+// empty, in ID order.
+func (s *SatelliteStore) List(status string) []Satellite {
+	s.mu.RLock()
+	list := make([]Satellite, 0, len(s.satellites))
+	for _, sat := range s.satellites {
+		if status == "" || sat.Status == status {
+			list = append(list, *sat)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// refresh replaces the catalog with SATELLITES_TABLE's.
+func (s *SatelliteStore) refresh(ctx context.Context) error {
+	satellites := make(map[string]*Satellite)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []Satellite
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for i := range items {
+			satellites[items[i].ID] = &items[i]
+		}
+	}
+	s.mu.Lock()
+	s.satellites = satellites
+	s.mu.Unlock()
+	return nil
+}
+
+// ExpandedMission is a mission with what ?expand= asked for embedded. A
+// satellite missing from the catalog, or one whose position at TCA cannot be
+// computed, is left out.
+type ExpandedMission struct {
+	Mission
+	TargetSatellite   *Satellite `json:"target_satellite,omitempty"`
+	ObserverSatellite *Satellite `json:"observer_satellite,omitempty"`
+	// TargetPosition and ObserverPosition are the points below the
+	// satellites at TCA.
+	TargetPosition   *GroundPosition `json:"target_position,omitempty"`
+	ObserverPosition *GroundPosition `json:"observer_position,omitempty"`
+	// Storage is what the mission stores and has served.
+	Storage *MissionStorage `json:"storage,omitempty"`
+}
+
+// ExpandedMissionsResponse is PaginatedMissionsResponse with ?expand=.
+type ExpandedMissionsResponse struct {
+	Missions  []ExpandedMission `json:"missions"`
+	NextToken *string           `json:"nextToken,omitempty"`
+}
+
+// missionExpand is what ?expand= asks to embed in a mission response.
+type missionExpand struct {
+	satellites, positions, storage bool
+}
+
+func (e missionExpand) any() bool { return e.satellites || e.positions || e.storage }
+
+// parseExpand reads ?expand=, a comma-separated list of satellites,
+// positions and storage.
+func parseExpand(v string) (missionExpand, error) {
+	var e missionExpand
+	for _, name := range splitList(v) {
+		switch name {
+		case "satellites":
+			e.satellites = true
+		case "positions":
+			e.positions = true
+		case "storage":
+			e.storage = true
+		default:
+			return e, newProblem(http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'expand' parameter %q. Must be satellites, positions or storage.", name))
+		}
+	}
+	return e, nil
+}
+
+// expandMissions embeds what e asks for in missions. modified is the later
+// of lastModified and the last change of the satellites used, and zero if
+// lastModified is or storage changes with every request.
+func (api *API) expandMissions(ctx context.Context, missions []Mission, modified time.Time, e missionExpand) ([]ExpandedMission, time.Time) {
+	lookup := func(id string) *Satellite {
+		if id == "" {
+			return nil
+		}
+		sat, err := api.Satellites.Get(id)
+		if err != nil {
+			return nil
+		}
+		if t := time.Unix(sat.Updated, 0); !modified.IsZero() && t.After(modified) {
+			modified = t
+		}
+		return &sat
+	}
+	expanded := make([]ExpandedMission, len(missions))
+	for i, m := range missions {
+		expanded[i] = ExpandedMission{Mission: m}
+		target, observer := lookup(m.TargetSatelliteID), lookup(m.ObserverSatelliteID)
+		if e.satellites {
+			expanded[i].TargetSatellite, expanded[i].ObserverSatellite = target, observer
+		}
+		if e.positions {
+			if target != nil {
+				expanded[i].TargetPosition = api.positionAt(ctx, m.TargetSatelliteID, m.TCA)
+			}
+			if observer != nil {
+				expanded[i].ObserverPosition = api.positionAt(ctx, m.ObserverSatelliteID, m.TCA)
+			}
+		}
+		if e.storage && api.Storage != nil {
+			storage, err := api.Storage.Get(ctx, tenantFrom(ctx), m.ID)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to load mission storage", "mission", m.ID, "err", err)
+			} else {
+				expanded[i].Storage = &storage
+			}
+		}
+	}
+	if e.storage {
+		modified = time.Time{}
+	}
+	return expanded, modified
+}
+
+// satelliteRequest is the body of POST /satellites.
+type satelliteRequest struct {
+	// ID defaults to the NORAD ID.
+	ID       string  `json:"id"`
+	NoradID  int     `json:"norad_id"`
+	Name     string  `json:"name"`
+	Operator string  `json:"operator"`
+	RCS      float64 `json:"rcs"`
+	// Status defaults to active.
+	Status string `json:"status"`
+	TLE    *struct {
+		Line1 string `json:"line1"`
+		Line2 string `json:"line2"`
+	} `json:"tle"`
+}
+
+func (req satelliteRequest) validate() (Satellite, error) {
+	sat := Satellite{
+		ID:       req.ID,
+		NoradID:  req.NoradID,
+		Name:     strings.TrimSpace(req.Name),
+		Operator: strings.TrimSpace(req.Operator),
+		RCS:      req.RCS,
+		Status:   req.Status,
+	}
+	if sat.ID == "" {
+		sat.ID = strconv.Itoa(req.NoradID)
+	}
+	if sat.Status == "" {
+		sat.Status = SatelliteActive
+	}
+	invalid := func(msg string) (Satellite, error) {
+		return Satellite{}, newProblem(http.StatusBadRequest, CodeInvalidBody, msg)
+	}
+	switch {
+	case req.NoradID < 1 || req.NoradID > maxNoradID:
+		return invalid(fmt.Sprintf("'norad_id' must be from 1 to %d.", maxNoradID))
+	case !satelliteID.MatchString(sat.ID):
+		return invalid("'id' must be 1 to 64 letters, digits, '.', '_' or '-'.")
+	case sat.Name == "":
+		return invalid("'name' is required.")
+	case req.RCS < 0:
+		return invalid("'rcs' must not be negative.")
+	case !slices.Contains(satelliteStatuses, sat.Status):
+		return invalid(fmt.Sprintf("Unknown status %q. Must be one of %s.", sat.Status, strings.Join(satelliteStatuses, ", ")))
+	}
+	if req.TLE != nil {
+		tle, catalog, err := parseTLE(req.TLE.Line1, req.TLE.Line2)
+		if err != nil {
+			return invalid("Invalid 'tle': " + err.Error() + ".")
+		}
+		if catalog != req.NoradID {
+			return invalid(fmt.Sprintf("'tle' is for catalog number %d, not %d.", catalog, req.NoradID))
+		}
+		sat.TLE = &tle
+	}
+	return sat, nil
+}
+
+// SatelliteListResponse is the body of GET /satellites.
+type SatelliteListResponse struct {
+	Satellites []Satellite `json:"satellites"`
+}
+
+func (api *API) getSatellites(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && !slices.Contains(satelliteStatuses, status) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'status' parameter. Must be one of %s.", strings.Join(satelliteStatuses, ", ")))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, SatelliteListResponse{Satellites: api.Satellites.List(status)})
+}
+
+func (api *API) getSatellite(c *gin.Context) {
+	sat, err := api.Satellites.Get(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeSatelliteNotFound, "satellite not found")
+		return
+	}
+	conditionalJSON(c, sat, time.Unix(sat.Updated, 0))
+}
+
+// postSatellite adds a satellite to the catalog, or replaces the one with
+// its ID. A TLE sent with it is added to the history; without one, the
+// replaced entry's TLE is kept.
+func (api *API) postSatellite(c *gin.Context) {
+	var req satelliteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected a satellite with at least norad_id and name.")
+		return
+	}
+	sat, err := req.validate()
+	created := false
+	if err == nil && sat.TLE != nil {
+		err = api.TLEs.Add(c.Request.Context(), newTLERecord(sat.ID, *sat.TLE, TLESourceManual))
+	}
+	if err == nil {
+		if old, oldErr := api.Satellites.Get(sat.ID); oldErr == nil && sat.TLE == nil {
+			sat.TLE = old.TLE
+		}
+		sat, created, err = api.Satellites.Put(c.Request.Context(), sat)
+	}
+	if err != nil {
+		var p *Problem
+		if !errors.As(err, &p) {
+			slog.ErrorContext(c.Request.Context(), "failed to save satellite", "err", err)
+		}
+		respondProblem(c, problemFor(err))
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "saved satellite", "id", sat.ID, "norad_id", sat.NoradID, "created", created)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.IndentedJSON(status, sat)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSatellites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{
+		Config:     &Config{},
+		Satellites: newSatelliteStore(nil, ""),
+		TLEs:       newTLEStore(nil, ""),
+		MissionDB: missionMap{"m1": &Mission{
+			ID: "m1", TargetSatelliteID: "25544", ObserverSatelliteID: "unlisted", TCA: 1221913540, UpdatedAt: 1000,
+		}},
+	}
+	router := gin.New()
+	router.GET("/satellites", api.getSatellites)
+	router.POST("/satellites", api.postSatellite)
+	router.GET("/satellite/:id", api.getSatellite)
+	router.GET("/satellite/:id/tle", api.getSatelliteTLE)
+	router.GET("/missions", api.getMissions)
+	router.GET("/mission/:id", api.getMissionById)
+	do := func(method, path, body string) (int, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var got map[string]any
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return w.Code, got
+	}
+
+	iss := `{"norad_id": 25544, "name": "ISS (ZARYA)", "operator": "NASA", "rcs": 399.05, "tle": {"line1": "` + issLine1 + `", "line2": "` + issLine2 + `"}}`
+	tests := []struct {
+		method, path, body string
+		want               int
+		code               ErrorCode
+	}{
+		{http.MethodPost, "/satellites", `{"name": "nameless"}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/satellites", `{"norad_id": 1, "name": "x", "status": "lost"}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/satellites", `{"norad_id": 25545, "name": "x", "tle": {"line1": "` + issLine1 + `", "line2": "` + issLine2 + `"}}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPost, "/satellites", iss, http.StatusCreated, ""},
+		{http.MethodPost, "/satellites", iss, http.StatusOK, ""},
+		{http.MethodPost, "/satellites", `{"id": "sentinel-2a", "norad_id": 40697, "name": "Sentinel-2A", "status": "inactive"}`, http.StatusCreated, ""},
+		{http.MethodGet, "/satellites?status=lost", "", http.StatusBadRequest, CodeInvalidParameter},
+		{http.MethodGet, "/satellite/40697", "", http.StatusNotFound, CodeSatelliteNotFound},
+		{http.MethodGet, "/mission/m1?expand=images", "", http.StatusBadRequest, CodeInvalidParameter},
+		{http.MethodGet, "/satellite/40697/tle", "", http.StatusNotFound, CodeSatelliteNotFound},
+		{http.MethodGet, "/satellite/sentinel-2a/tle", "", http.StatusNotFound, CodeTLENotFound},
+		{http.MethodGet, "/satellite/25544/tle?epoch=yesterday", "", http.StatusBadRequest, CodeInvalidParameter},
+	}
+	for _, tt := range tests {
+		code, body := do(tt.method, tt.path, tt.body)
+		if code != tt.want || tt.code != "" && body["code"] != string(tt.code) {
+			t.Errorf("%s %s %s: %d %v, want %d %s", tt.method, tt.path, tt.body, code, body, tt.want, tt.code)
+		}
+	}
+
+	if _, body := do(http.MethodGet, "/satellite/25544", ""); body["status"] != SatelliteActive || body["tle"] == nil {
+		t.Errorf("GET /satellite/25544 = %v", body)
+	}
+	if _, body := do(http.MethodGet, "/satellite/25544/tle?epoch=2008-09-20T00:00:00Z", ""); body["source"] != TLESourceManual || body["line1"] != issLine1 {
+		t.Errorf("GET /satellite/25544/tle = %v", body)
+	}
+	_, body := do(http.MethodGet, "/satellites?status=inactive", "")
+	if list, _ := body["satellites"].([]any); len(list) != 1 {
+		t.Errorf("GET /satellites?status=inactive = %v", body)
+	}
+
+	_, body = do(http.MethodGet, "/mission/m1?expand=satellites", "")
+	target, _ := body["target_satellite"].(map[string]any)
+	if body["id"] != "m1" || target["name"] != "ISS (ZARYA)" || body["observer_satellite"] != nil {
+		t.Errorf("expanded mission = %v", body)
+	}
+	_, body = do(http.MethodGet, "/missions?expand=satellites", "")
+	missions, _ := body["missions"].([]any)
+	if len(missions) != 1 || missions[0].(map[string]any)["target_satellite"] == nil {
+		t.Errorf("expanded missions = %v", body)
+	}
+	_, body = do(http.MethodGet, "/mission/m1?expand=positions", "")
+	position, _ := body["target_position"].(map[string]any)
+	if lat, _ := position["lat"].(float64); body["target_satellite"] != nil || body["observer_position"] != nil || lat < 51 || lat > 52 {
+		t.Errorf("mission with positions = %v", body)
+	}
+	if _, body = do(http.MethodGet, "/mission/m1", ""); body["target_satellite"] != nil {
+		t.Errorf("unexpanded mission = %v", body)
+	}
+}
@@ -0,0 +1,2020 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v32.1.0
+// source: satpb/sat.proto
+
+// The gRPC counterpart of /api/v1 for internal consumers. Messages mirror
+// the REST JSON; zero values mean "unset" as an absent field does there.
+
+package satpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Mission struct {
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	Id                    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name                  string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Status                string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Priority              int32                  `protobuf:"varint,4,opt,name=priority,proto3" json:"priority,omitempty"`
+	TargetSatelliteId     string                 `protobuf:"bytes,5,opt,name=target_satellite_id,json=targetSatelliteId,proto3" json:"target_satellite_id,omitempty"`
+	ObserverSatelliteId   string                 `protobuf:"bytes,6,opt,name=observer_satellite_id,json=observerSatelliteId,proto3" json:"observer_satellite_id,omitempty"`
+	Tca                   int64                  `protobuf:"varint,7,opt,name=tca,proto3" json:"tca,omitempty"`
+	MinRangeKm            float64                `protobuf:"fixed64,8,opt,name=min_range_km,json=minRangeKm,proto3" json:"min_range_km,omitempty"`
+	CollectionWindowStart int64                  `protobuf:"varint,9,opt,name=collection_window_start,json=collectionWindowStart,proto3" json:"collection_window_start,omitempty"`
+	CollectionWindowEnd   int64                  `protobuf:"varint,10,opt,name=collection_window_end,json=collectionWindowEnd,proto3" json:"collection_window_end,omitempty"`
+	CollectionType        string                 `protobuf:"bytes,11,opt,name=collection_type,json=collectionType,proto3" json:"collection_type,omitempty"`
+	PointingTarget        string                 `protobuf:"bytes,12,opt,name=pointing_target,json=pointingTarget,proto3" json:"pointing_target,omitempty"`
+	ImageIds              []string               `protobuf:"bytes,13,rep,name=image_ids,json=imageIds,proto3" json:"image_ids,omitempty"`
+	UpdatedAt             int64                  `protobuf:"varint,14,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *Mission) Reset() {
+	*x = Mission{}
+	mi := &file_satpb_sat_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Mission) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Mission) ProtoMessage() {}
+
+func (x *Mission) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Mission.ProtoReflect.Descriptor instead.
+func (*Mission) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Mission) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Mission) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Mission) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Mission) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Mission) GetTargetSatelliteId() string {
+	if x != nil {
+		return x.TargetSatelliteId
+	}
+	return ""
+}
+
+func (x *Mission) GetObserverSatelliteId() string {
+	if x != nil {
+		return x.ObserverSatelliteId
+	}
+	return ""
+}
+
+func (x *Mission) GetTca() int64 {
+	if x != nil {
+		return x.Tca
+	}
+	return 0
+}
+
+func (x *Mission) GetMinRangeKm() float64 {
+	if x != nil {
+		return x.MinRangeKm
+	}
+	return 0
+}
+
+func (x *Mission) GetCollectionWindowStart() int64 {
+	if x != nil {
+		return x.CollectionWindowStart
+	}
+	return 0
+}
+
+func (x *Mission) GetCollectionWindowEnd() int64 {
+	if x != nil {
+		return x.CollectionWindowEnd
+	}
+	return 0
+}
+
+func (x *Mission) GetCollectionType() string {
+	if x != nil {
+		return x.CollectionType
+	}
+	return ""
+}
+
+func (x *Mission) GetPointingTarget() string {
+	if x != nil {
+		return x.PointingTarget
+	}
+	return ""
+}
+
+func (x *Mission) GetImageIds() []string {
+	if x != nil {
+		return x.ImageIds
+	}
+	return nil
+}
+
+func (x *Mission) GetUpdatedAt() int64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+type ListMissionsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// page_size defaults to 10 and is capped at 100.
+	PageSize      int32  `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMissionsRequest) Reset() {
+	*x = ListMissionsRequest{}
+	mi := &file_satpb_sat_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMissionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMissionsRequest) ProtoMessage() {}
+
+func (x *ListMissionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMissionsRequest.ProtoReflect.Descriptor instead.
+func (*ListMissionsRequest) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListMissionsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListMissionsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListMissionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Missions      []*Mission             `protobuf:"bytes,1,rep,name=missions,proto3" json:"missions,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMissionsResponse) Reset() {
+	*x = ListMissionsResponse{}
+	mi := &file_satpb_sat_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMissionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMissionsResponse) ProtoMessage() {}
+
+func (x *ListMissionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMissionsResponse.ProtoReflect.Descriptor instead.
+func (*ListMissionsResponse) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListMissionsResponse) GetMissions() []*Mission {
+	if x != nil {
+		return x.Missions
+	}
+	return nil
+}
+
+func (x *ListMissionsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type GetMissionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMissionRequest) Reset() {
+	*x = GetMissionRequest{}
+	mi := &file_satpb_sat_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMissionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMissionRequest) ProtoMessage() {}
+
+func (x *GetMissionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMissionRequest.ProtoReflect.Descriptor instead.
+func (*GetMissionRequest) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetMissionRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type QualityMetrics struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Score         float64                `protobuf:"fixed64,1,opt,name=score,proto3" json:"score,omitempty"`
+	BlurVariance  float64                `protobuf:"fixed64,2,opt,name=blur_variance,json=blurVariance,proto3" json:"blur_variance,omitempty"`
+	SnrDb         float64                `protobuf:"fixed64,3,opt,name=snr_db,json=snrDb,proto3" json:"snr_db,omitempty"`
+	SaturatedPct  float64                `protobuf:"fixed64,4,opt,name=saturated_pct,json=saturatedPct,proto3" json:"saturated_pct,omitempty"`
+	Computed      int64                  `protobuf:"varint,5,opt,name=computed,proto3" json:"computed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QualityMetrics) Reset() {
+	*x = QualityMetrics{}
+	mi := &file_satpb_sat_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QualityMetrics) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QualityMetrics) ProtoMessage() {}
+
+func (x *QualityMetrics) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QualityMetrics.ProtoReflect.Descriptor instead.
+func (*QualityMetrics) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *QualityMetrics) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *QualityMetrics) GetBlurVariance() float64 {
+	if x != nil {
+		return x.BlurVariance
+	}
+	return 0
+}
+
+func (x *QualityMetrics) GetSnrDb() float64 {
+	if x != nil {
+		return x.SnrDb
+	}
+	return 0
+}
+
+func (x *QualityMetrics) GetSaturatedPct() float64 {
+	if x != nil {
+		return x.SaturatedPct
+	}
+	return 0
+}
+
+func (x *QualityMetrics) GetComputed() int64 {
+	if x != nil {
+		return x.Computed
+	}
+	return 0
+}
+
+type Photometry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	X             float64                `protobuf:"fixed64,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y             float64                `protobuf:"fixed64,2,opt,name=y,proto3" json:"y,omitempty"`
+	Fwhm          float64                `protobuf:"fixed64,3,opt,name=fwhm,proto3" json:"fwhm,omitempty"`
+	Flux          float64                `protobuf:"fixed64,4,opt,name=flux,proto3" json:"flux,omitempty"`
+	Snr           float64                `protobuf:"fixed64,5,opt,name=snr,proto3" json:"snr,omitempty"`
+	Peak          float64                `protobuf:"fixed64,6,opt,name=peak,proto3" json:"peak,omitempty"`
+	Background    float64                `protobuf:"fixed64,7,opt,name=background,proto3" json:"background,omitempty"`
+	Aperture      float64                `protobuf:"fixed64,8,opt,name=aperture,proto3" json:"aperture,omitempty"`
+	Saturated     bool                   `protobuf:"varint,9,opt,name=saturated,proto3" json:"saturated,omitempty"`
+	CaptureTime   int64                  `protobuf:"varint,10,opt,name=capture_time,json=captureTime,proto3" json:"capture_time,omitempty"`
+	Measured      int64                  `protobuf:"varint,11,opt,name=measured,proto3" json:"measured,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Photometry) Reset() {
+	*x = Photometry{}
+	mi := &file_satpb_sat_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Photometry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Photometry) ProtoMessage() {}
+
+func (x *Photometry) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Photometry.ProtoReflect.Descriptor instead.
+func (*Photometry) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Photometry) GetX() float64 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *Photometry) GetY() float64 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *Photometry) GetFwhm() float64 {
+	if x != nil {
+		return x.Fwhm
+	}
+	return 0
+}
+
+func (x *Photometry) GetFlux() float64 {
+	if x != nil {
+		return x.Flux
+	}
+	return 0
+}
+
+func (x *Photometry) GetSnr() float64 {
+	if x != nil {
+		return x.Snr
+	}
+	return 0
+}
+
+func (x *Photometry) GetPeak() float64 {
+	if x != nil {
+		return x.Peak
+	}
+	return 0
+}
+
+func (x *Photometry) GetBackground() float64 {
+	if x != nil {
+		return x.Background
+	}
+	return 0
+}
+
+func (x *Photometry) GetAperture() float64 {
+	if x != nil {
+		return x.Aperture
+	}
+	return 0
+}
+
+func (x *Photometry) GetSaturated() bool {
+	if x != nil {
+		return x.Saturated
+	}
+	return false
+}
+
+func (x *Photometry) GetCaptureTime() int64 {
+	if x != nil {
+		return x.CaptureTime
+	}
+	return 0
+}
+
+func (x *Photometry) GetMeasured() int64 {
+	if x != nil {
+		return x.Measured
+	}
+	return 0
+}
+
+type ImageRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Quality       *QualityMetrics        `protobuf:"bytes,2,opt,name=quality,proto3" json:"quality,omitempty"`
+	Photometry    *Photometry            `protobuf:"bytes,3,opt,name=photometry,proto3" json:"photometry,omitempty"`
+	Exif          map[string]string      `protobuf:"bytes,4,rep,name=exif,proto3" json:"exif,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Updated       int64                  `protobuf:"varint,5,opt,name=updated,proto3" json:"updated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImageRecord) Reset() {
+	*x = ImageRecord{}
+	mi := &file_satpb_sat_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImageRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageRecord) ProtoMessage() {}
+
+func (x *ImageRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageRecord.ProtoReflect.Descriptor instead.
+func (*ImageRecord) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ImageRecord) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ImageRecord) GetQuality() *QualityMetrics {
+	if x != nil {
+		return x.Quality
+	}
+	return nil
+}
+
+func (x *ImageRecord) GetPhotometry() *Photometry {
+	if x != nil {
+		return x.Photometry
+	}
+	return nil
+}
+
+func (x *ImageRecord) GetExif() map[string]string {
+	if x != nil {
+		return x.Exif
+	}
+	return nil
+}
+
+func (x *ImageRecord) GetUpdated() int64 {
+	if x != nil {
+		return x.Updated
+	}
+	return 0
+}
+
+type ListMissionImagesRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	MissionId string                 `protobuf:"bytes,1,opt,name=mission_id,json=missionId,proto3" json:"mission_id,omitempty"`
+	// min_quality keeps only frames scoring at least this, when set.
+	MinQuality    *float64 `protobuf:"fixed64,2,opt,name=min_quality,json=minQuality,proto3,oneof" json:"min_quality,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMissionImagesRequest) Reset() {
+	*x = ListMissionImagesRequest{}
+	mi := &file_satpb_sat_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMissionImagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMissionImagesRequest) ProtoMessage() {}
+
+func (x *ListMissionImagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMissionImagesRequest.ProtoReflect.Descriptor instead.
+func (*ListMissionImagesRequest) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListMissionImagesRequest) GetMissionId() string {
+	if x != nil {
+		return x.MissionId
+	}
+	return ""
+}
+
+func (x *ListMissionImagesRequest) GetMinQuality() float64 {
+	if x != nil && x.MinQuality != nil {
+		return *x.MinQuality
+	}
+	return 0
+}
+
+type ListMissionImagesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MissionId     string                 `protobuf:"bytes,1,opt,name=mission_id,json=missionId,proto3" json:"mission_id,omitempty"`
+	Images        []*ImageRecord         `protobuf:"bytes,2,rep,name=images,proto3" json:"images,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMissionImagesResponse) Reset() {
+	*x = ListMissionImagesResponse{}
+	mi := &file_satpb_sat_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMissionImagesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMissionImagesResponse) ProtoMessage() {}
+
+func (x *ListMissionImagesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMissionImagesResponse.ProtoReflect.Descriptor instead.
+func (*ListMissionImagesResponse) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListMissionImagesResponse) GetMissionId() string {
+	if x != nil {
+		return x.MissionId
+	}
+	return ""
+}
+
+func (x *ListMissionImagesResponse) GetImages() []*ImageRecord {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+type Point struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	X             float64                `protobuf:"fixed64,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y             float64                `protobuf:"fixed64,2,opt,name=y,proto3" json:"y,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Point) Reset() {
+	*x = Point{}
+	mi := &file_satpb_sat_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Point) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Point) ProtoMessage() {}
+
+func (x *Point) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Point.ProtoReflect.Descriptor instead.
+func (*Point) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Point) GetX() float64 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *Point) GetY() float64 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+type GeoInfo struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Crs   string                 `protobuf:"bytes,1,opt,name=crs,proto3" json:"crs,omitempty"`
+	// geotransform follows the GDAL convention, six values.
+	Geotransform  []float64 `protobuf:"fixed64,2,rep,packed,name=geotransform,proto3" json:"geotransform,omitempty"`
+	UpperLeft     *Point    `protobuf:"bytes,3,opt,name=upper_left,json=upperLeft,proto3" json:"upper_left,omitempty"`
+	UpperRight    *Point    `protobuf:"bytes,4,opt,name=upper_right,json=upperRight,proto3" json:"upper_right,omitempty"`
+	LowerLeft     *Point    `protobuf:"bytes,5,opt,name=lower_left,json=lowerLeft,proto3" json:"lower_left,omitempty"`
+	LowerRight    *Point    `protobuf:"bytes,6,opt,name=lower_right,json=lowerRight,proto3" json:"lower_right,omitempty"`
+	Center        *Point    `protobuf:"bytes,7,opt,name=center,proto3" json:"center,omitempty"`
+	Width         int32     `protobuf:"varint,8,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32     `protobuf:"varint,9,opt,name=height,proto3" json:"height,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GeoInfo) Reset() {
+	*x = GeoInfo{}
+	mi := &file_satpb_sat_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GeoInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GeoInfo) ProtoMessage() {}
+
+func (x *GeoInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GeoInfo.ProtoReflect.Descriptor instead.
+func (*GeoInfo) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GeoInfo) GetCrs() string {
+	if x != nil {
+		return x.Crs
+	}
+	return ""
+}
+
+func (x *GeoInfo) GetGeotransform() []float64 {
+	if x != nil {
+		return x.Geotransform
+	}
+	return nil
+}
+
+func (x *GeoInfo) GetUpperLeft() *Point {
+	if x != nil {
+		return x.UpperLeft
+	}
+	return nil
+}
+
+func (x *GeoInfo) GetUpperRight() *Point {
+	if x != nil {
+		return x.UpperRight
+	}
+	return nil
+}
+
+func (x *GeoInfo) GetLowerLeft() *Point {
+	if x != nil {
+		return x.LowerLeft
+	}
+	return nil
+}
+
+func (x *GeoInfo) GetLowerRight() *Point {
+	if x != nil {
+		return x.LowerRight
+	}
+	return nil
+}
+
+func (x *GeoInfo) GetCenter() *Point {
+	if x != nil {
+		return x.Center
+	}
+	return nil
+}
+
+func (x *GeoInfo) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *GeoInfo) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+type ImageMetadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	ContentType   string                 `protobuf:"bytes,3,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	ContentLength int64                  `protobuf:"varint,4,opt,name=content_length,json=contentLength,proto3" json:"content_length,omitempty"`
+	Etag          string                 `protobuf:"bytes,5,opt,name=etag,proto3" json:"etag,omitempty"`
+	LastModified  int64                  `protobuf:"varint,6,opt,name=last_modified,json=lastModified,proto3" json:"last_modified,omitempty"`
+	CaptureTime   int64                  `protobuf:"varint,7,opt,name=capture_time,json=captureTime,proto3" json:"capture_time,omitempty"`
+	Format        string                 `protobuf:"bytes,8,opt,name=format,proto3" json:"format,omitempty"`
+	Width         int32                  `protobuf:"varint,9,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32                  `protobuf:"varint,10,opt,name=height,proto3" json:"height,omitempty"`
+	Geo           *GeoInfo               `protobuf:"bytes,11,opt,name=geo,proto3" json:"geo,omitempty"`
+	Exif          map[string]string      `protobuf:"bytes,12,rep,name=exif,proto3" json:"exif,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Quality       *QualityMetrics        `protobuf:"bytes,13,opt,name=quality,proto3" json:"quality,omitempty"`
+	Photometry    *Photometry            `protobuf:"bytes,14,opt,name=photometry,proto3" json:"photometry,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImageMetadata) Reset() {
+	*x = ImageMetadata{}
+	mi := &file_satpb_sat_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImageMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageMetadata) ProtoMessage() {}
+
+func (x *ImageMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageMetadata.ProtoReflect.Descriptor instead.
+func (*ImageMetadata) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ImageMetadata) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ImageMetadata) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ImageMetadata) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *ImageMetadata) GetContentLength() int64 {
+	if x != nil {
+		return x.ContentLength
+	}
+	return 0
+}
+
+func (x *ImageMetadata) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *ImageMetadata) GetLastModified() int64 {
+	if x != nil {
+		return x.LastModified
+	}
+	return 0
+}
+
+func (x *ImageMetadata) GetCaptureTime() int64 {
+	if x != nil {
+		return x.CaptureTime
+	}
+	return 0
+}
+
+func (x *ImageMetadata) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ImageMetadata) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *ImageMetadata) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *ImageMetadata) GetGeo() *GeoInfo {
+	if x != nil {
+		return x.Geo
+	}
+	return nil
+}
+
+func (x *ImageMetadata) GetExif() map[string]string {
+	if x != nil {
+		return x.Exif
+	}
+	return nil
+}
+
+func (x *ImageMetadata) GetQuality() *QualityMetrics {
+	if x != nil {
+		return x.Quality
+	}
+	return nil
+}
+
+func (x *ImageMetadata) GetPhotometry() *Photometry {
+	if x != nil {
+		return x.Photometry
+	}
+	return nil
+}
+
+type GetImageMetadataRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetImageMetadataRequest) Reset() {
+	*x = GetImageMetadataRequest{}
+	mi := &file_satpb_sat_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetImageMetadataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetImageMetadataRequest) ProtoMessage() {}
+
+func (x *GetImageMetadataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetImageMetadataRequest.ProtoReflect.Descriptor instead.
+func (*GetImageMetadataRequest) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *GetImageMetadataRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type Rect struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	X             int32                  `protobuf:"varint,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y             int32                  `protobuf:"varint,2,opt,name=y,proto3" json:"y,omitempty"`
+	Width         int32                  `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32                  `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Rect) Reset() {
+	*x = Rect{}
+	mi := &file_satpb_sat_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Rect) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rect) ProtoMessage() {}
+
+func (x *Rect) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rect.ProtoReflect.Descriptor instead.
+func (*Rect) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Rect) GetX() int32 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *Rect) GetY() int32 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *Rect) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *Rect) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+// ProcessingOptions are the query parameters of GET /image/:id.
+type ProcessingOptions struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Width             int32                  `protobuf:"varint,1,opt,name=width,proto3" json:"width,omitempty"`
+	Height            int32                  `protobuf:"varint,2,opt,name=height,proto3" json:"height,omitempty"`
+	Crop              *Rect                  `protobuf:"bytes,3,opt,name=crop,proto3" json:"crop,omitempty"`
+	Contrast          float64                `protobuf:"fixed64,4,opt,name=contrast,proto3" json:"contrast,omitempty"`
+	Format            string                 `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`
+	Quality           int32                  `protobuf:"varint,6,opt,name=quality,proto3" json:"quality,omitempty"`
+	Lossless          bool                   `protobuf:"varint,7,opt,name=lossless,proto3" json:"lossless,omitempty"`
+	Compression       string                 `protobuf:"bytes,8,opt,name=compression,proto3" json:"compression,omitempty"`
+	Scale             string                 `protobuf:"bytes,9,opt,name=scale,proto3" json:"scale,omitempty"`
+	StarSuppress      bool                   `protobuf:"varint,10,opt,name=star_suppress,json=starSuppress,proto3" json:"star_suppress,omitempty"`
+	StarSuppressCell  int32                  `protobuf:"varint,11,opt,name=star_suppress_cell,json=starSuppressCell,proto3" json:"star_suppress_cell,omitempty"`
+	StarSuppressSigma float64                `protobuf:"fixed64,12,opt,name=star_suppress_sigma,json=starSuppressSigma,proto3" json:"star_suppress_sigma,omitempty"`
+	Overlay           string                 `protobuf:"bytes,13,opt,name=overlay,proto3" json:"overlay,omitempty"`
+	OverlayPosition   string                 `protobuf:"bytes,14,opt,name=overlay_position,json=overlayPosition,proto3" json:"overlay_position,omitempty"`
+	OverlayOpacity    float64                `protobuf:"fixed64,15,opt,name=overlay_opacity,json=overlayOpacity,proto3" json:"overlay_opacity,omitempty"`
+	Annotations       bool                   `protobuf:"varint,16,opt,name=annotations,proto3" json:"annotations,omitempty"`
+	StripMetadata     bool                   `protobuf:"varint,17,opt,name=strip_metadata,json=stripMetadata,proto3" json:"strip_metadata,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ProcessingOptions) Reset() {
+	*x = ProcessingOptions{}
+	mi := &file_satpb_sat_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessingOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessingOptions) ProtoMessage() {}
+
+func (x *ProcessingOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessingOptions.ProtoReflect.Descriptor instead.
+func (*ProcessingOptions) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ProcessingOptions) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *ProcessingOptions) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *ProcessingOptions) GetCrop() *Rect {
+	if x != nil {
+		return x.Crop
+	}
+	return nil
+}
+
+func (x *ProcessingOptions) GetContrast() float64 {
+	if x != nil {
+		return x.Contrast
+	}
+	return 0
+}
+
+func (x *ProcessingOptions) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ProcessingOptions) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *ProcessingOptions) GetLossless() bool {
+	if x != nil {
+		return x.Lossless
+	}
+	return false
+}
+
+func (x *ProcessingOptions) GetCompression() string {
+	if x != nil {
+		return x.Compression
+	}
+	return ""
+}
+
+func (x *ProcessingOptions) GetScale() string {
+	if x != nil {
+		return x.Scale
+	}
+	return ""
+}
+
+func (x *ProcessingOptions) GetStarSuppress() bool {
+	if x != nil {
+		return x.StarSuppress
+	}
+	return false
+}
+
+func (x *ProcessingOptions) GetStarSuppressCell() int32 {
+	if x != nil {
+		return x.StarSuppressCell
+	}
+	return 0
+}
+
+func (x *ProcessingOptions) GetStarSuppressSigma() float64 {
+	if x != nil {
+		return x.StarSuppressSigma
+	}
+	return 0
+}
+
+func (x *ProcessingOptions) GetOverlay() string {
+	if x != nil {
+		return x.Overlay
+	}
+	return ""
+}
+
+func (x *ProcessingOptions) GetOverlayPosition() string {
+	if x != nil {
+		return x.OverlayPosition
+	}
+	return ""
+}
+
+func (x *ProcessingOptions) GetOverlayOpacity() float64 {
+	if x != nil {
+		return x.OverlayOpacity
+	}
+	return 0
+}
+
+func (x *ProcessingOptions) GetAnnotations() bool {
+	if x != nil {
+		return x.Annotations
+	}
+	return false
+}
+
+func (x *ProcessingOptions) GetStripMetadata() bool {
+	if x != nil {
+		return x.StripMetadata
+	}
+	return false
+}
+
+type GetImageRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Options *ProcessingOptions     `protobuf:"bytes,2,opt,name=options,proto3" json:"options,omitempty"`
+	// accept negotiates the output format when options.format is empty, as
+	// the Accept header does.
+	Accept        string `protobuf:"bytes,3,opt,name=accept,proto3" json:"accept,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetImageRequest) Reset() {
+	*x = GetImageRequest{}
+	mi := &file_satpb_sat_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetImageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetImageRequest) ProtoMessage() {}
+
+func (x *GetImageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetImageRequest.ProtoReflect.Descriptor instead.
+func (*GetImageRequest) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *GetImageRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *GetImageRequest) GetOptions() *ProcessingOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+func (x *GetImageRequest) GetAccept() string {
+	if x != nil {
+		return x.Accept
+	}
+	return ""
+}
+
+type ImageChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// content_type, etag and size are set on the first chunk only. size is 0
+	// when the length is not known up front.
+	ContentType   string `protobuf:"bytes,1,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Etag          string `protobuf:"bytes,2,opt,name=etag,proto3" json:"etag,omitempty"`
+	Size          int64  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Data          []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImageChunk) Reset() {
+	*x = ImageChunk{}
+	mi := &file_satpb_sat_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImageChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageChunk) ProtoMessage() {}
+
+func (x *ImageChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageChunk.ProtoReflect.Descriptor instead.
+func (*ImageChunk) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ImageChunk) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *ImageChunk) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *ImageChunk) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ImageChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type SubmitProcessJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ids           []string               `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+	Options       *ProcessingOptions     `protobuf:"bytes,2,opt,name=options,proto3" json:"options,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitProcessJobRequest) Reset() {
+	*x = SubmitProcessJobRequest{}
+	mi := &file_satpb_sat_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitProcessJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitProcessJobRequest) ProtoMessage() {}
+
+func (x *SubmitProcessJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitProcessJobRequest.ProtoReflect.Descriptor instead.
+func (*SubmitProcessJobRequest) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *SubmitProcessJobRequest) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+func (x *SubmitProcessJobRequest) GetOptions() *ProcessingOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type JobItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Output        string                 `protobuf:"bytes,3,opt,name=output,proto3" json:"output,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobItem) Reset() {
+	*x = JobItem{}
+	mi := &file_satpb_sat_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobItem) ProtoMessage() {}
+
+func (x *JobItem) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobItem.ProtoReflect.Descriptor instead.
+func (*JobItem) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *JobItem) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *JobItem) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *JobItem) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+func (x *JobItem) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type Job struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Progress      float64                `protobuf:"fixed64,4,opt,name=progress,proto3" json:"progress,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	Output        string                 `protobuf:"bytes,6,opt,name=output,proto3" json:"output,omitempty"`
+	ContentType   string                 `protobuf:"bytes,7,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Attempts      int32                  `protobuf:"varint,8,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	MaxAttempts   int32                  `protobuf:"varint,9,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+	NextAttempt   int64                  `protobuf:"varint,10,opt,name=next_attempt,json=nextAttempt,proto3" json:"next_attempt,omitempty"`
+	Created       int64                  `protobuf:"varint,11,opt,name=created,proto3" json:"created,omitempty"`
+	Updated       int64                  `protobuf:"varint,12,opt,name=updated,proto3" json:"updated,omitempty"`
+	Items         []*JobItem             `protobuf:"bytes,13,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Job) Reset() {
+	*x = Job{}
+	mi := &file_satpb_sat_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Job) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Job) ProtoMessage() {}
+
+func (x *Job) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Job.ProtoReflect.Descriptor instead.
+func (*Job) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *Job) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Job) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Job) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Job) GetProgress() float64 {
+	if x != nil {
+		return x.Progress
+	}
+	return 0
+}
+
+func (x *Job) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Job) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+func (x *Job) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *Job) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *Job) GetMaxAttempts() int32 {
+	if x != nil {
+		return x.MaxAttempts
+	}
+	return 0
+}
+
+func (x *Job) GetNextAttempt() int64 {
+	if x != nil {
+		return x.NextAttempt
+	}
+	return 0
+}
+
+func (x *Job) GetCreated() int64 {
+	if x != nil {
+		return x.Created
+	}
+	return 0
+}
+
+func (x *Job) GetUpdated() int64 {
+	if x != nil {
+		return x.Updated
+	}
+	return 0
+}
+
+func (x *Job) GetItems() []*JobItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type GetJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetJobRequest) Reset() {
+	*x = GetJobRequest{}
+	mi := &file_satpb_sat_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetJobRequest) ProtoMessage() {}
+
+func (x *GetJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_satpb_sat_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetJobRequest.ProtoReflect.Descriptor instead.
+func (*GetJobRequest) Descriptor() ([]byte, []int) {
+	return file_satpb_sat_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *GetJobRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+var File_satpb_sat_proto protoreflect.FileDescriptor
+
+const file_satpb_sat_proto_rawDesc = "" +
+	"\n" +
+	"\x0fsatpb/sat.proto\x12\x06sat.v1\"\xf3\x03\n" +
+	"\aMission\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1a\n" +
+	"\bpriority\x18\x04 \x01(\x05R\bpriority\x12.\n" +
+	"\x13target_satellite_id\x18\x05 \x01(\tR\x11targetSatelliteId\x122\n" +
+	"\x15observer_satellite_id\x18\x06 \x01(\tR\x13observerSatelliteId\x12\x10\n" +
+	"\x03tca\x18\a \x01(\x03R\x03tca\x12 \n" +
+	"\fmin_range_km\x18\b \x01(\x01R\n" +
+	"minRangeKm\x126\n" +
+	"\x17collection_window_start\x18\t \x01(\x03R\x15collectionWindowStart\x122\n" +
+	"\x15collection_window_end\x18\n" +
+	" \x01(\x03R\x13collectionWindowEnd\x12'\n" +
+	"\x0fcollection_type\x18\v \x01(\tR\x0ecollectionType\x12'\n" +
+	"\x0fpointing_target\x18\f \x01(\tR\x0epointingTarget\x12\x1b\n" +
+	"\timage_ids\x18\r \x03(\tR\bimageIds\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\x0e \x01(\x03R\tupdatedAt\"Q\n" +
+	"\x13ListMissionsRequest\x12\x1b\n" +
+	"\tpage_size\x18\x01 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x02 \x01(\tR\tpageToken\"k\n" +
+	"\x14ListMissionsResponse\x12+\n" +
+	"\bmissions\x18\x01 \x03(\v2\x0f.sat.v1.MissionR\bmissions\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"#\n" +
+	"\x11GetMissionRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xa3\x01\n" +
+	"\x0eQualityMetrics\x12\x14\n" +
+	"\x05score\x18\x01 \x01(\x01R\x05score\x12#\n" +
+	"\rblur_variance\x18\x02 \x01(\x01R\fblurVariance\x12\x15\n" +
+	"\x06snr_db\x18\x03 \x01(\x01R\x05snrDb\x12#\n" +
+	"\rsaturated_pct\x18\x04 \x01(\x01R\fsaturatedPct\x12\x1a\n" +
+	"\bcomputed\x18\x05 \x01(\x03R\bcomputed\"\x8f\x02\n" +
+	"\n" +
+	"Photometry\x12\f\n" +
+	"\x01x\x18\x01 \x01(\x01R\x01x\x12\f\n" +
+	"\x01y\x18\x02 \x01(\x01R\x01y\x12\x12\n" +
+	"\x04fwhm\x18\x03 \x01(\x01R\x04fwhm\x12\x12\n" +
+	"\x04flux\x18\x04 \x01(\x01R\x04flux\x12\x10\n" +
+	"\x03snr\x18\x05 \x01(\x01R\x03snr\x12\x12\n" +
+	"\x04peak\x18\x06 \x01(\x01R\x04peak\x12\x1e\n" +
+	"\n" +
+	"background\x18\a \x01(\x01R\n" +
+	"background\x12\x1a\n" +
+	"\baperture\x18\b \x01(\x01R\baperture\x12\x1c\n" +
+	"\tsaturated\x18\t \x01(\bR\tsaturated\x12!\n" +
+	"\fcapture_time\x18\n" +
+	" \x01(\x03R\vcaptureTime\x12\x1a\n" +
+	"\bmeasured\x18\v \x01(\x03R\bmeasured\"\x89\x02\n" +
+	"\vImageRecord\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x120\n" +
+	"\aquality\x18\x02 \x01(\v2\x16.sat.v1.QualityMetricsR\aquality\x122\n" +
+	"\n" +
+	"photometry\x18\x03 \x01(\v2\x12.sat.v1.PhotometryR\n" +
+	"photometry\x121\n" +
+	"\x04exif\x18\x04 \x03(\v2\x1d.sat.v1.ImageRecord.ExifEntryR\x04exif\x12\x18\n" +
+	"\aupdated\x18\x05 \x01(\x03R\aupdated\x1a7\n" +
+	"\tExifEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"o\n" +
+	"\x18ListMissionImagesRequest\x12\x1d\n" +
+	"\n" +
+	"mission_id\x18\x01 \x01(\tR\tmissionId\x12$\n" +
+	"\vmin_quality\x18\x02 \x01(\x01H\x00R\n" +
+	"minQuality\x88\x01\x01B\x0e\n" +
+	"\f_min_quality\"g\n" +
+	"\x19ListMissionImagesResponse\x12\x1d\n" +
+	"\n" +
+	"mission_id\x18\x01 \x01(\tR\tmissionId\x12+\n" +
+	"\x06images\x18\x02 \x03(\v2\x13.sat.v1.ImageRecordR\x06images\"#\n" +
+	"\x05Point\x12\f\n" +
+	"\x01x\x18\x01 \x01(\x01R\x01x\x12\f\n" +
+	"\x01y\x18\x02 \x01(\x01R\x01y\"\xd0\x02\n" +
+	"\aGeoInfo\x12\x10\n" +
+	"\x03crs\x18\x01 \x01(\tR\x03crs\x12\"\n" +
+	"\fgeotransform\x18\x02 \x03(\x01R\fgeotransform\x12,\n" +
+	"\n" +
+	"upper_left\x18\x03 \x01(\v2\r.sat.v1.PointR\tupperLeft\x12.\n" +
+	"\vupper_right\x18\x04 \x01(\v2\r.sat.v1.PointR\n" +
+	"upperRight\x12,\n" +
+	"\n" +
+	"lower_left\x18\x05 \x01(\v2\r.sat.v1.PointR\tlowerLeft\x12.\n" +
+	"\vlower_right\x18\x06 \x01(\v2\r.sat.v1.PointR\n" +
+	"lowerRight\x12%\n" +
+	"\x06center\x18\a \x01(\v2\r.sat.v1.PointR\x06center\x12\x14\n" +
+	"\x05width\x18\b \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\t \x01(\x05R\x06height\"\x94\x04\n" +
+	"\rImageMetadata\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12!\n" +
+	"\fcontent_type\x18\x03 \x01(\tR\vcontentType\x12%\n" +
+	"\x0econtent_length\x18\x04 \x01(\x03R\rcontentLength\x12\x12\n" +
+	"\x04etag\x18\x05 \x01(\tR\x04etag\x12#\n" +
+	"\rlast_modified\x18\x06 \x01(\x03R\flastModified\x12!\n" +
+	"\fcapture_time\x18\a \x01(\x03R\vcaptureTime\x12\x16\n" +
+	"\x06format\x18\b \x01(\tR\x06format\x12\x14\n" +
+	"\x05width\x18\t \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\n" +
+	" \x01(\x05R\x06height\x12!\n" +
+	"\x03geo\x18\v \x01(\v2\x0f.sat.v1.GeoInfoR\x03geo\x123\n" +
+	"\x04exif\x18\f \x03(\v2\x1f.sat.v1.ImageMetadata.ExifEntryR\x04exif\x120\n" +
+	"\aquality\x18\r \x01(\v2\x16.sat.v1.QualityMetricsR\aquality\x122\n" +
+	"\n" +
+	"photometry\x18\x0e \x01(\v2\x12.sat.v1.PhotometryR\n" +
+	"photometry\x1a7\n" +
+	"\tExifEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\")\n" +
+	"\x17GetImageMetadataRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"P\n" +
+	"\x04Rect\x12\f\n" +
+	"\x01x\x18\x01 \x01(\x05R\x01x\x12\f\n" +
+	"\x01y\x18\x02 \x01(\x05R\x01y\x12\x14\n" +
+	"\x05width\x18\x03 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\"\xbf\x04\n" +
+	"\x11ProcessingOptions\x12\x14\n" +
+	"\x05width\x18\x01 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x02 \x01(\x05R\x06height\x12 \n" +
+	"\x04crop\x18\x03 \x01(\v2\f.sat.v1.RectR\x04crop\x12\x1a\n" +
+	"\bcontrast\x18\x04 \x01(\x01R\bcontrast\x12\x16\n" +
+	"\x06format\x18\x05 \x01(\tR\x06format\x12\x18\n" +
+	"\aquality\x18\x06 \x01(\x05R\aquality\x12\x1a\n" +
+	"\blossless\x18\a \x01(\bR\blossless\x12 \n" +
+	"\vcompression\x18\b \x01(\tR\vcompression\x12\x14\n" +
+	"\x05scale\x18\t \x01(\tR\x05scale\x12#\n" +
+	"\rstar_suppress\x18\n" +
+	" \x01(\bR\fstarSuppress\x12,\n" +
+	"\x12star_suppress_cell\x18\v \x01(\x05R\x10starSuppressCell\x12.\n" +
+	"\x13star_suppress_sigma\x18\f \x01(\x01R\x11starSuppressSigma\x12\x18\n" +
+	"\aoverlay\x18\r \x01(\tR\aoverlay\x12)\n" +
+	"\x10overlay_position\x18\x0e \x01(\tR\x0foverlayPosition\x12'\n" +
+	"\x0foverlay_opacity\x18\x0f \x01(\x01R\x0eoverlayOpacity\x12 \n" +
+	"\vannotations\x18\x10 \x01(\bR\vannotations\x12%\n" +
+	"\x0estrip_metadata\x18\x11 \x01(\bR\rstripMetadata\"n\n" +
+	"\x0fGetImageRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x123\n" +
+	"\aoptions\x18\x02 \x01(\v2\x19.sat.v1.ProcessingOptionsR\aoptions\x12\x16\n" +
+	"\x06accept\x18\x03 \x01(\tR\x06accept\"k\n" +
+	"\n" +
+	"ImageChunk\x12!\n" +
+	"\fcontent_type\x18\x01 \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04etag\x18\x02 \x01(\tR\x04etag\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\x12\x12\n" +
+	"\x04data\x18\x04 \x01(\fR\x04data\"`\n" +
+	"\x17SubmitProcessJobRequest\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\tR\x03ids\x123\n" +
+	"\aoptions\x18\x02 \x01(\v2\x19.sat.v1.ProcessingOptionsR\aoptions\"_\n" +
+	"\aJobItem\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x16\n" +
+	"\x06output\x18\x03 \x01(\tR\x06output\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"\xeb\x02\n" +
+	"\x03Job\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1a\n" +
+	"\bprogress\x18\x04 \x01(\x01R\bprogress\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12\x16\n" +
+	"\x06output\x18\x06 \x01(\tR\x06output\x12!\n" +
+	"\fcontent_type\x18\a \x01(\tR\vcontentType\x12\x1a\n" +
+	"\battempts\x18\b \x01(\x05R\battempts\x12!\n" +
+	"\fmax_attempts\x18\t \x01(\x05R\vmaxAttempts\x12!\n" +
+	"\fnext_attempt\x18\n" +
+	" \x01(\x03R\vnextAttempt\x12\x18\n" +
+	"\acreated\x18\v \x01(\x03R\acreated\x12\x18\n" +
+	"\aupdated\x18\f \x01(\x03R\aupdated\x12%\n" +
+	"\x05items\x18\r \x03(\v2\x0f.sat.v1.JobItemR\x05items\"\x1f\n" +
+	"\rGetJobRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id2\xe7\x03\n" +
+	"\x0fSatImageService\x12I\n" +
+	"\fListMissions\x12\x1b.sat.v1.ListMissionsRequest\x1a\x1c.sat.v1.ListMissionsResponse\x128\n" +
+	"\n" +
+	"GetMission\x12\x19.sat.v1.GetMissionRequest\x1a\x0f.sat.v1.Mission\x12X\n" +
+	"\x11ListMissionImages\x12 .sat.v1.ListMissionImagesRequest\x1a!.sat.v1.ListMissionImagesResponse\x12J\n" +
+	"\x10GetImageMetadata\x12\x1f.sat.v1.GetImageMetadataRequest\x1a\x15.sat.v1.ImageMetadata\x129\n" +
+	"\bGetImage\x12\x17.sat.v1.GetImageRequest\x1a\x12.sat.v1.ImageChunk0\x01\x12@\n" +
+	"\x10SubmitProcessJob\x12\x1f.sat.v1.SubmitProcessJobRequest\x1a\v.sat.v1.Job\x12,\n" +
+	"\x06GetJob\x12\x15.sat.v1.GetJobRequest\x1a\v.sat.v1.JobB\x1cZ\x1asat-thumbnail-server/satpbb\x06proto3"
+
+var (
+	file_satpb_sat_proto_rawDescOnce sync.Once
+	file_satpb_sat_proto_rawDescData []byte
+)
+
+func file_satpb_sat_proto_rawDescGZIP() []byte {
+	file_satpb_sat_proto_rawDescOnce.Do(func() {
+		file_satpb_sat_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_satpb_sat_proto_rawDesc), len(file_satpb_sat_proto_rawDesc)))
+	})
+	return file_satpb_sat_proto_rawDescData
+}
+
+var file_satpb_sat_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
+var file_satpb_sat_proto_goTypes = []any{
+	(*Mission)(nil),                   // 0: sat.v1.Mission
+	(*ListMissionsRequest)(nil),       // 1: sat.v1.ListMissionsRequest
+	(*ListMissionsResponse)(nil),      // 2: sat.v1.ListMissionsResponse
+	(*GetMissionRequest)(nil),         // 3: sat.v1.GetMissionRequest
+	(*QualityMetrics)(nil),            // 4: sat.v1.QualityMetrics
+	(*Photometry)(nil),                // 5: sat.v1.Photometry
+	(*ImageRecord)(nil),               // 6: sat.v1.ImageRecord
+	(*ListMissionImagesRequest)(nil),  // 7: sat.v1.ListMissionImagesRequest
+	(*ListMissionImagesResponse)(nil), // 8: sat.v1.ListMissionImagesResponse
+	(*Point)(nil),                     // 9: sat.v1.Point
+	(*GeoInfo)(nil),                   // 10: sat.v1.GeoInfo
+	(*ImageMetadata)(nil),             // 11: sat.v1.ImageMetadata
+	(*GetImageMetadataRequest)(nil),   // 12: sat.v1.GetImageMetadataRequest
+	(*Rect)(nil),                      // 13: sat.v1.Rect
+	(*ProcessingOptions)(nil),         // 14: sat.v1.ProcessingOptions
+	(*GetImageRequest)(nil),           // 15: sat.v1.GetImageRequest
+	(*ImageChunk)(nil),                // 16: sat.v1.ImageChunk
+	(*SubmitProcessJobRequest)(nil),   // 17: sat.v1.SubmitProcessJobRequest
+	(*JobItem)(nil),                   // 18: sat.v1.JobItem
+	(*Job)(nil),                       // 19: sat.v1.Job
+	(*GetJobRequest)(nil),             // 20: sat.v1.GetJobRequest
+	nil,                               // 21: sat.v1.ImageRecord.ExifEntry
+	nil,                               // 22: sat.v1.ImageMetadata.ExifEntry
+}
+var file_satpb_sat_proto_depIdxs = []int32{
+	0,  // 0: sat.v1.ListMissionsResponse.missions:type_name -> sat.v1.Mission
+	4,  // 1: sat.v1.ImageRecord.quality:type_name -> sat.v1.QualityMetrics
+	5,  // 2: sat.v1.ImageRecord.photometry:type_name -> sat.v1.Photometry
+	21, // 3: sat.v1.ImageRecord.exif:type_name -> sat.v1.ImageRecord.ExifEntry
+	6,  // 4: sat.v1.ListMissionImagesResponse.images:type_name -> sat.v1.ImageRecord
+	9,  // 5: sat.v1.GeoInfo.upper_left:type_name -> sat.v1.Point
+	9,  // 6: sat.v1.GeoInfo.upper_right:type_name -> sat.v1.Point
+	9,  // 7: sat.v1.GeoInfo.lower_left:type_name -> sat.v1.Point
+	9,  // 8: sat.v1.GeoInfo.lower_right:type_name -> sat.v1.Point
+	9,  // 9: sat.v1.GeoInfo.center:type_name -> sat.v1.Point
+	10, // 10: sat.v1.ImageMetadata.geo:type_name -> sat.v1.GeoInfo
+	22, // 11: sat.v1.ImageMetadata.exif:type_name -> sat.v1.ImageMetadata.ExifEntry
+	4,  // 12: sat.v1.ImageMetadata.quality:type_name -> sat.v1.QualityMetrics
+	5,  // 13: sat.v1.ImageMetadata.photometry:type_name -> sat.v1.Photometry
+	13, // 14: sat.v1.ProcessingOptions.crop:type_name -> sat.v1.Rect
+	14, // 15: sat.v1.GetImageRequest.options:type_name -> sat.v1.ProcessingOptions
+	14, // 16: sat.v1.SubmitProcessJobRequest.options:type_name -> sat.v1.ProcessingOptions
+	18, // 17: sat.v1.Job.items:type_name -> sat.v1.JobItem
+	1,  // 18: sat.v1.SatImageService.ListMissions:input_type -> sat.v1.ListMissionsRequest
+	3,  // 19: sat.v1.SatImageService.GetMission:input_type -> sat.v1.GetMissionRequest
+	7,  // 20: sat.v1.SatImageService.ListMissionImages:input_type -> sat.v1.ListMissionImagesRequest
+	12, // 21: sat.v1.SatImageService.GetImageMetadata:input_type -> sat.v1.GetImageMetadataRequest
+	15, // 22: sat.v1.SatImageService.GetImage:input_type -> sat.v1.GetImageRequest
+	17, // 23: sat.v1.SatImageService.SubmitProcessJob:input_type -> sat.v1.SubmitProcessJobRequest
+	20, // 24: sat.v1.SatImageService.GetJob:input_type -> sat.v1.GetJobRequest
+	2,  // 25: sat.v1.SatImageService.ListMissions:output_type -> sat.v1.ListMissionsResponse
+	0,  // 26: sat.v1.SatImageService.GetMission:output_type -> sat.v1.Mission
+	8,  // 27: sat.v1.SatImageService.ListMissionImages:output_type -> sat.v1.ListMissionImagesResponse
+	11, // 28: sat.v1.SatImageService.GetImageMetadata:output_type -> sat.v1.ImageMetadata
+	16, // 29: sat.v1.SatImageService.GetImage:output_type -> sat.v1.ImageChunk
+	19, // 30: sat.v1.SatImageService.SubmitProcessJob:output_type -> sat.v1.Job
+	19, // 31: sat.v1.SatImageService.GetJob:output_type -> sat.v1.Job
+	25, // [25:32] is the sub-list for method output_type
+	18, // [18:25] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
+}
+
+func init() { file_satpb_sat_proto_init() }
+func file_satpb_sat_proto_init() {
+	if File_satpb_sat_proto != nil {
+		return
+	}
+	file_satpb_sat_proto_msgTypes[7].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_satpb_sat_proto_rawDesc), len(file_satpb_sat_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   23,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_satpb_sat_proto_goTypes,
+		DependencyIndexes: file_satpb_sat_proto_depIdxs,
+		MessageInfos:      file_satpb_sat_proto_msgTypes,
+	}.Build()
+	File_satpb_sat_proto = out.File
+	file_satpb_sat_proto_goTypes = nil
+	file_satpb_sat_proto_depIdxs = nil
+}
@@ -0,0 +1,360 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v32.1.0
+// source: satpb/sat.proto
+
+// The gRPC counterpart of /api/v1 for internal consumers. Messages mirror
+// the REST JSON; zero values mean "unset" as an absent field does there.
+
+package satpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SatImageService_ListMissions_FullMethodName      = "/sat.v1.SatImageService/ListMissions"
+	SatImageService_GetMission_FullMethodName        = "/sat.v1.SatImageService/GetMission"
+	SatImageService_ListMissionImages_FullMethodName = "/sat.v1.SatImageService/ListMissionImages"
+	SatImageService_GetImageMetadata_FullMethodName  = "/sat.v1.SatImageService/GetImageMetadata"
+	SatImageService_GetImage_FullMethodName          = "/sat.v1.SatImageService/GetImage"
+	SatImageService_SubmitProcessJob_FullMethodName  = "/sat.v1.SatImageService/SubmitProcessJob"
+	SatImageService_GetJob_FullMethodName            = "/sat.v1.SatImageService/GetJob"
+)
+
+// SatImageServiceClient is the client API for SatImageService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SatImageServiceClient interface {
+	ListMissions(ctx context.Context, in *ListMissionsRequest, opts ...grpc.CallOption) (*ListMissionsResponse, error)
+	GetMission(ctx context.Context, in *GetMissionRequest, opts ...grpc.CallOption) (*Mission, error)
+	ListMissionImages(ctx context.Context, in *ListMissionImagesRequest, opts ...grpc.CallOption) (*ListMissionImagesResponse, error)
+	GetImageMetadata(ctx context.Context, in *GetImageMetadataRequest, opts ...grpc.CallOption) (*ImageMetadata, error)
+	// GetImage streams an image, processed as options asks, in chunks of at
+	// most 64 KiB. The first chunk carries the content type.
+	GetImage(ctx context.Context, in *GetImageRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ImageChunk], error)
+	SubmitProcessJob(ctx context.Context, in *SubmitProcessJobRequest, opts ...grpc.CallOption) (*Job, error)
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*Job, error)
+}
+
+type satImageServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSatImageServiceClient(cc grpc.ClientConnInterface) SatImageServiceClient {
+	return &satImageServiceClient{cc}
+}
+
+func (c *satImageServiceClient) ListMissions(ctx context.Context, in *ListMissionsRequest, opts ...grpc.CallOption) (*ListMissionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMissionsResponse)
+	err := c.cc.Invoke(ctx, SatImageService_ListMissions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *satImageServiceClient) GetMission(ctx context.Context, in *GetMissionRequest, opts ...grpc.CallOption) (*Mission, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Mission)
+	err := c.cc.Invoke(ctx, SatImageService_GetMission_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *satImageServiceClient) ListMissionImages(ctx context.Context, in *ListMissionImagesRequest, opts ...grpc.CallOption) (*ListMissionImagesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMissionImagesResponse)
+	err := c.cc.Invoke(ctx, SatImageService_ListMissionImages_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *satImageServiceClient) GetImageMetadata(ctx context.Context, in *GetImageMetadataRequest, opts ...grpc.CallOption) (*ImageMetadata, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ImageMetadata)
+	err := c.cc.Invoke(ctx, SatImageService_GetImageMetadata_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *satImageServiceClient) GetImage(ctx context.Context, in *GetImageRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ImageChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SatImageService_ServiceDesc.Streams[0], SatImageService_GetImage_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetImageRequest, ImageChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SatImageService_GetImageClient = grpc.ServerStreamingClient[ImageChunk]
+
+func (c *satImageServiceClient) SubmitProcessJob(ctx context.Context, in *SubmitProcessJobRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, SatImageService_SubmitProcessJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *satImageServiceClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, SatImageService_GetJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SatImageServiceServer is the server API for SatImageService service.
+// All implementations must embed UnimplementedSatImageServiceServer
+// for forward compatibility.
+type SatImageServiceServer interface {
+	ListMissions(context.Context, *ListMissionsRequest) (*ListMissionsResponse, error)
+	GetMission(context.Context, *GetMissionRequest) (*Mission, error)
+	ListMissionImages(context.Context, *ListMissionImagesRequest) (*ListMissionImagesResponse, error)
+	GetImageMetadata(context.Context, *GetImageMetadataRequest) (*ImageMetadata, error)
+	// GetImage streams an image, processed as options asks, in chunks of at
+	// most 64 KiB. The first chunk carries the content type.
+	GetImage(*GetImageRequest, grpc.ServerStreamingServer[ImageChunk]) error
+	SubmitProcessJob(context.Context, *SubmitProcessJobRequest) (*Job, error)
+	GetJob(context.Context, *GetJobRequest) (*Job, error)
+	mustEmbedUnimplementedSatImageServiceServer()
+}
+
+// UnimplementedSatImageServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSatImageServiceServer struct{}
+
+func (UnimplementedSatImageServiceServer) ListMissions(context.Context, *ListMissionsRequest) (*ListMissionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMissions not implemented")
+}
+func (UnimplementedSatImageServiceServer) GetMission(context.Context, *GetMissionRequest) (*Mission, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMission not implemented")
+}
+func (UnimplementedSatImageServiceServer) ListMissionImages(context.Context, *ListMissionImagesRequest) (*ListMissionImagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMissionImages not implemented")
+}
+func (UnimplementedSatImageServiceServer) GetImageMetadata(context.Context, *GetImageMetadataRequest) (*ImageMetadata, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetImageMetadata not implemented")
+}
+func (UnimplementedSatImageServiceServer) GetImage(*GetImageRequest, grpc.ServerStreamingServer[ImageChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method GetImage not implemented")
+}
+func (UnimplementedSatImageServiceServer) SubmitProcessJob(context.Context, *SubmitProcessJobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitProcessJob not implemented")
+}
+func (UnimplementedSatImageServiceServer) GetJob(context.Context, *GetJobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedSatImageServiceServer) mustEmbedUnimplementedSatImageServiceServer() {}
+func (UnimplementedSatImageServiceServer) testEmbeddedByValue()                         {}
+
+// UnsafeSatImageServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SatImageServiceServer will
+// result in compilation errors.
+type UnsafeSatImageServiceServer interface {
+	mustEmbedUnimplementedSatImageServiceServer()
+}
+
+func RegisterSatImageServiceServer(s grpc.ServiceRegistrar, srv SatImageServiceServer) {
+	// If the following call pancis, it indicates UnimplementedSatImageServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SatImageService_ServiceDesc, srv)
+}
+
+func _SatImageService_ListMissions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMissionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SatImageServiceServer).ListMissions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SatImageService_ListMissions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SatImageServiceServer).ListMissions(ctx, req.(*ListMissionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SatImageService_GetMission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SatImageServiceServer).GetMission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SatImageService_GetMission_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SatImageServiceServer).GetMission(ctx, req.(*GetMissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SatImageService_ListMissionImages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMissionImagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SatImageServiceServer).ListMissionImages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SatImageService_ListMissionImages_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SatImageServiceServer).ListMissionImages(ctx, req.(*ListMissionImagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SatImageService_GetImageMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetImageMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SatImageServiceServer).GetImageMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SatImageService_GetImageMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SatImageServiceServer).GetImageMetadata(ctx, req.(*GetImageMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SatImageService_GetImage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetImageRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SatImageServiceServer).GetImage(m, &grpc.GenericServerStream[GetImageRequest, ImageChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SatImageService_GetImageServer = grpc.ServerStreamingServer[ImageChunk]
+
+func _SatImageService_SubmitProcessJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitProcessJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SatImageServiceServer).SubmitProcessJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SatImageService_SubmitProcessJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SatImageServiceServer).SubmitProcessJob(ctx, req.(*SubmitProcessJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SatImageService_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SatImageServiceServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SatImageService_GetJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SatImageServiceServer).GetJob(ctx, req.(*GetJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SatImageService_ServiceDesc is the grpc.ServiceDesc for SatImageService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SatImageService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sat.v1.SatImageService",
+	HandlerType: (*SatImageServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListMissions",
+			Handler:    _SatImageService_ListMissions_Handler,
+		},
+		{
+			MethodName: "GetMission",
+			Handler:    _SatImageService_GetMission_Handler,
+		},
+		{
+			MethodName: "ListMissionImages",
+			Handler:    _SatImageService_ListMissionImages_Handler,
+		},
+		{
+			MethodName: "GetImageMetadata",
+			Handler:    _SatImageService_GetImageMetadata_Handler,
+		},
+		{
+			MethodName: "SubmitProcessJob",
+			Handler:    _SatImageService_SubmitProcessJob_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _SatImageService_GetJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetImage",
+			Handler:       _SatImageService_GetImage_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "satpb/sat.proto",
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scheduleMaxMissions = 200
+	// scheduleUnknownSlewDeg is the turn assumed to or from a collection
+	// whose geometry cannot be propagated.
+	scheduleUnknownSlewDeg = 180
+)
+
+// ScheduleConstraints are what one observer can collect.
+type ScheduleConstraints struct {
+	// SlewRateDegS is how fast the observer turns between targets, and
+	// SettleS how long it then waits before collecting.
+	SlewRateDegS float64 `json:"slew_rate_deg_s"`
+	SettleS      float64 `json:"settle_s"`
+	// MaxDutyCycle is the largest fraction of any DutyPeriodS the observer
+	// may spend collecting.
+	MaxDutyCycle float64 `json:"max_duty_cycle"`
+	DutyPeriodS  float64 `json:"duty_period_s"`
+	// DownlinkBudgetMB caps the data the schedule may produce, with a frame
+	// of FrameMB every FrameIntervalS. Zero is no cap. FrameMB defaults to
+	// the observer's registered sensor.
+	DownlinkBudgetMB float64 `json:"downlink_budget_mb"`
+	FrameIntervalS   float64 `json:"frame_interval_s"`
+	FrameMB          float64 `json:"frame_mb"`
+	// MinDwellS is the shortest collection worth scheduling.
+	MinDwellS float64 `json:"min_dwell_s"`
+}
+
+var defaultScheduleConstraints = ScheduleConstraints{
+	SlewRateDegS:   1,
+	MaxDutyCycle:   1,
+	DutyPeriodS:    5400,
+	FrameIntervalS: 10,
+	MinDwellS:      60,
+}
+
+func (k ScheduleConstraints) validate() error {
+	finite := func(v float64) bool { return !math.IsInf(v, 0) && !math.IsNaN(v) }
+	switch {
+	case !(k.SlewRateDegS > 0) || !finite(k.SlewRateDegS):
+		return errors.New("'slew_rate_deg_s' must be above 0")
+	case !(k.SettleS >= 0) || !finite(k.SettleS):
+		return errors.New("'settle_s' must not be negative")
+	case !(k.MaxDutyCycle > 0 && k.MaxDutyCycle <= 1):
+		return errors.New("'max_duty_cycle' must be above 0 and at most 1")
+	case !(k.DutyPeriodS > 0) || !finite(k.DutyPeriodS):
+		return errors.New("'duty_period_s' must be above 0")
+	case !(k.DownlinkBudgetMB >= 0) || !finite(k.DownlinkBudgetMB):
+		return errors.New("'downlink_budget_mb' must not be negative")
+	case !(k.FrameIntervalS > 0) || !finite(k.FrameIntervalS):
+		return errors.New("'frame_interval_s' must be above 0")
+	case !(k.FrameMB >= 0) || !finite(k.FrameMB):
+		return errors.New("'frame_mb' must not be negative")
+	case !(k.MinDwellS > 0) || !finite(k.MinDwellS):
+		return errors.New("'min_dwell_s' must be above 0")
+	}
+	return nil
+}
+
+// frames is how many frames a collection of seconds takes.
+func (k ScheduleConstraints) frames(seconds int64) int {
+	return int(float64(seconds)/k.FrameIntervalS) + 1
+}
+
+// ScheduleAssignment is where POST /schedule/optimize placed a mission's
+// collection.
+type ScheduleAssignment struct {
+	Start int64 `dynamodbav:"start" json:"start"`
+	End   int64 `dynamodbav:"end" json:"end"`
+	// Rank is the mission's place in priority order among those scheduled,
+	// from 1.
+	Rank   int     `dynamodbav:"rank" json:"rank"`
+	Frames int     `dynamodbav:"frames" json:"frames"`
+	DataMB float64 `dynamodbav:"data_mb" json:"data_mb"`
+	// SlewDeg is the observer's turn from its previous collection.
+	SlewDeg     float64 `dynamodbav:"slew_deg" json:"slew_deg"`
+	ScheduledAt int64   `dynamodbav:"scheduled_at" json:"scheduled_at"`
+}
+
+type scheduleRequest struct {
+	MissionIDs []string `json:"mission_ids"`
+	// Constraints apply to every observer, and Observers replace fields of
+	// them for one.
+	Constraints json.RawMessage            `json:"constraints"`
+	Observers   map[string]json.RawMessage `json:"observers"`
+}
+
+// ScheduledMission is a mission the optimizer placed.
+type ScheduledMission struct {
+	MissionID           string `json:"mission_id"`
+	ObserverSatelliteID string `json:"observer_satellite_id"`
+	Priority            int    `json:"priority"`
+	ScheduleAssignment
+}
+
+// RejectedMission is a mission the optimizer left out, and why.
+type RejectedMission struct {
+	MissionID string `json:"mission_id"`
+	Reason    string `json:"reason"`
+}
+
+// ObserverLoad is what the schedule asks of one observer.
+type ObserverLoad struct {
+	CollectS int64 `json:"collect_s"`
+	// DutyCycle is the busiest duty period's fraction spent collecting.
+	DutyCycle        float64 `json:"duty_cycle"`
+	DataMB           float64 `json:"data_mb"`
+	DownlinkBudgetMB float64 `json:"downlink_budget_mb,omitempty"`
+}
+
+// ScheduleResponse is the body of POST /schedule/optimize.
+type ScheduleResponse struct {
+	// Scheduled is in time order.
+	Scheduled []ScheduledMission      `json:"scheduled"`
+	Rejected  []RejectedMission       `json:"rejected"`
+	Observers map[string]ObserverLoad `json:"observers"`
+	// Persisted is false for a dry run.
+	Persisted bool `json:"persisted"`
+}
+
+// scheduleCandidate is a mission the optimizer may place.
+type scheduleCandidate struct {
+	mission *Mission
+	k       ScheduleConstraints
+	// pair is nil when the satellites cannot be propagated.
+	pair *orbitPair
+}
+
+// boresight is the observer's line of sight to the target at unix time t.
+func (c *scheduleCandidate) boresight(t int64) ([3]float64, bool) {
+	if c.pair == nil {
+		return [3]float64{}, false
+	}
+	at := time.Unix(t, 0).UTC()
+	o, err := c.pair.observer.At(at)
+	if err != nil {
+		return [3]float64{}, false
+	}
+	tg, err := c.pair.target.At(at)
+	if err != nil {
+		return [3]float64{}, false
+	}
+	return unit(sub(tg.Position, o.Position)), true
+}
+
+// slew is the observer's turn between a's target and b's at t.
+func slew(a, b *scheduleCandidate, t int64) float64 {
+	u, ok := a.boresight(t)
+	v, ok2 := b.boresight(t)
+	if !ok || !ok2 {
+		return scheduleUnknownSlewDeg
+	}
+	return math.Acos(math.Max(-1, math.Min(1, dot(u, v)))) * 180 / math.Pi
+}
+
+// slewGap is the seconds between a collection of a ending at t and one of b.
+func slewGap(a, b *scheduleCandidate, t int64) int64 {
+	return int64(math.Ceil(slew(a, b, t)/a.k.SlewRateDegS + a.k.SettleS))
+}
+
+type scheduleSlot struct {
+	start, end int64
+	c          *scheduleCandidate
+}
+
+// peakDuty is the largest fraction of any period seconds the slots cover.
+// The busiest period starts with a slot or ends with one.
+func peakDuty(slots []scheduleSlot, period float64) float64 {
+	p := int64(math.Ceil(period))
+	covered := func(from, to int64) int64 {
+		var n int64
+		for _, s := range slots {
+			n += max(0, min(to, s.end)-max(from, s.start))
+		}
+		return n
+	}
+	var peak int64
+	for _, s := range slots {
+		peak = max(peak, covered(s.start, s.start+p), covered(s.end-p, s.end))
+	}
+	return float64(peak) / float64(p)
+}
+
+// scheduleOrder ranks candidates: the lowest priority number first, with
+// unset (0) priorities last, then the most feasible, then the earliest.
+func scheduleOrder(a, b *scheduleCandidate) int {
+	rank := func(c *scheduleCandidate) int {
+		if c.mission.Priority <= 0 {
+			return math.MaxInt
+		}
+		return c.mission.Priority
+	}
+	score := func(c *scheduleCandidate) float64 {
+		if c.mission.Feasibility == nil {
+			return 0
+		}
+		return c.mission.Feasibility.Score
+	}
+	return cmp.Or(
+		cmp.Compare(rank(a), rank(b)),
+		cmp.Compare(score(b), score(a)),
+		cmp.Compare(a.mission.CollectionWindowStart, b.mission.CollectionWindowStart),
+		cmp.Compare(a.mission.ID, b.mission.ID),
+	)
+}
+
+// optimizeSchedule places candidates greedily in scheduleOrder. Each gets
+// the part of its collection window left free by the observer's collections
+// already placed, less the time to slew and settle between them: the free
+// span holding TCA, or else the longest. The span is then cut down around
+// TCA to fit the downlink budget and duty cycle. A candidate with less than
+// its MinDwellS left is rejected.
+func optimizeSchedule(candidates []*scheduleCandidate) ([]ScheduledMission, []RejectedMission, map[string]ObserverLoad) {
+	slices.SortStableFunc(candidates, scheduleOrder)
+	placed := map[string][]scheduleSlot{}
+	data := map[string]float64{}
+	var accepted []scheduleSlot
+	var rejected []RejectedMission
+	for _, c := range candidates {
+		m, k := c.mission, c.k
+		observer := m.ObserverSatelliteID
+		dwell := int64(math.Ceil(k.MinDwellS))
+		reject := func(format string, args ...any) {
+			rejected = append(rejected, RejectedMission{MissionID: m.ID, Reason: fmt.Sprintf(format, args...)})
+		}
+
+		free := [][2]int64{{m.CollectionWindowStart, m.CollectionWindowEnd}}
+		var blocking []string
+		for _, s := range placed[observer] {
+			from, to := s.start-slewGap(c, s.c, s.start), s.end+slewGap(s.c, c, s.end)
+			var next [][2]int64
+			for _, f := range free {
+				if to <= f[0] || from >= f[1] {
+					next = append(next, f)
+					continue
+				}
+				if from > f[0] {
+					next = append(next, [2]int64{f[0], from})
+				}
+				if to < f[1] {
+					next = append(next, [2]int64{to, f[1]})
+				}
+				if !slices.Contains(blocking, s.c.mission.ID) {
+					blocking = append(blocking, s.c.mission.ID)
+				}
+			}
+			free = next
+		}
+		var span [2]int64
+		found := false
+		for _, f := range free {
+			if f[1]-f[0] < dwell {
+				continue
+			}
+			if m.TCA >= f[0] && m.TCA <= f[1] {
+				span, found = f, true
+				break
+			}
+			if !found || f[1]-f[0] > span[1]-span[0] {
+				span, found = f, true
+			}
+		}
+		if !found {
+			reject("no %d s of the collection window is free of %s on %s", dwell, strings.Join(blocking, ", "), observer)
+			continue
+		}
+
+		limit := span[1] - span[0]
+		if k.DownlinkBudgetMB > 0 && k.FrameMB > 0 {
+			left := k.DownlinkBudgetMB - data[observer]
+			frames := int64(math.Floor(left/k.FrameMB + 1e-9))
+			if k.frames(dwell) > int(frames) {
+				reject("the downlink budget has %.1f MB left on %s, short of %.1f MB for %d s", max(left, 0), observer, float64(k.frames(dwell))*k.FrameMB, dwell)
+				continue
+			}
+			limit = min(limit, int64(math.Floor(float64(frames-1)*k.FrameIntervalS)))
+		}
+		centre := min(max(m.TCA, span[0]), span[1])
+		if m.TCA == 0 {
+			centre = (span[0] + span[1]) / 2
+		}
+		slotOf := func(length int64) scheduleSlot {
+			start := min(max(centre-length/2, span[0]), span[1]-length)
+			return scheduleSlot{start: start, end: start + length, c: c}
+		}
+		fits := func(length int64) bool {
+			return peakDuty(append(slices.Clone(placed[observer]), slotOf(length)), k.DutyPeriodS) <= k.MaxDutyCycle+1e-9
+		}
+		if !fits(dwell) {
+			reject("%d s would exceed the %g duty cycle over %g s on %s", dwell, k.MaxDutyCycle, k.DutyPeriodS, observer)
+			continue
+		}
+		// Shorter slots sit inside longer ones, so fitting is monotonic.
+		lo, hi := dwell, limit
+		for lo < hi {
+			mid := lo + (hi-lo+1)/2
+			if fits(mid) {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		slot := slotOf(lo)
+		placed[observer] = append(placed[observer], slot)
+		slices.SortFunc(placed[observer], func(a, b scheduleSlot) int { return cmp.Compare(a.start, b.start) })
+		data[observer] += float64(k.frames(lo)) * k.FrameMB
+		accepted = append(accepted, slot)
+	}
+
+	scheduled := make([]ScheduledMission, len(accepted))
+	for i, s := range accepted {
+		k := s.c.k
+		scheduled[i] = ScheduledMission{
+			MissionID:           s.c.mission.ID,
+			ObserverSatelliteID: s.c.mission.ObserverSatelliteID,
+			Priority:            s.c.mission.Priority,
+			ScheduleAssignment: ScheduleAssignment{
+				Start:  s.start,
+				End:    s.end,
+				Rank:   i + 1,
+				Frames: k.frames(s.end - s.start),
+				DataMB: math.Round(float64(k.frames(s.end-s.start))*k.FrameMB*1000) / 1000,
+			},
+		}
+	}
+	slices.SortStableFunc(scheduled, func(a, b ScheduledMission) int {
+		return cmp.Or(cmp.Compare(a.Start, b.Start), cmp.Compare(a.Rank, b.Rank))
+	})
+	loads := map[string]ObserverLoad{}
+	for observer, slots := range placed {
+		load := ObserverLoad{
+			DutyCycle:        math.Round(peakDuty(slots, slots[0].c.k.DutyPeriodS)*1e4) / 1e4,
+			DataMB:           math.Round(data[observer]*1000) / 1000,
+			DownlinkBudgetMB: slots[0].c.k.DownlinkBudgetMB,
+		}
+		for i, s := range slots {
+			load.CollectS += s.end - s.start
+			if i == 0 {
+				continue
+			}
+			prev := slots[i-1]
+			for j := range scheduled {
+				if scheduled[j].MissionID == s.c.mission.ID {
+					scheduled[j].SlewDeg = math.Round(slew(prev.c, s.c, prev.end)*100) / 100
+				}
+			}
+		}
+		loads[observer] = load
+	}
+	return scheduled, rejected, loads
+}
+
+// scheduleConstraints reads the constraints for each observer, starting
+// from the defaults and then req.Constraints and req.Observers. FrameMB
+// falls back to the observer's sensor.
+func (api *API) scheduleConstraints(req scheduleRequest, observers []string) (map[string]ScheduleConstraints, error) {
+	base := defaultScheduleConstraints
+	if len(req.Constraints) > 0 {
+		if err := json.Unmarshal(req.Constraints, &base); err != nil {
+			return nil, newProblem(http.StatusBadRequest, CodeInvalidBody, "Invalid 'constraints': "+err.Error())
+		}
+	}
+	out := make(map[string]ScheduleConstraints, len(observers))
+	for _, observer := range observers {
+		k := base
+		if raw, ok := req.Observers[observer]; ok {
+			if err := json.Unmarshal(raw, &k); err != nil {
+				return nil, newProblem(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Invalid constraints for observer %s: %s", observer, err))
+			}
+		}
+		if err := k.validate(); err != nil {
+			return nil, newProblem(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Invalid constraints for observer %s: %s.", observer, err))
+		}
+		if k.FrameMB == 0 {
+			if sensor, err := api.Sensors.Get(observer); err == nil {
+				bytes := (sensor.BitDepth + 7) / 8
+				k.FrameMB = float64(sensor.Width*sensor.Height*bytes) / 1e6
+			}
+		}
+		out[observer] = k
+	}
+	return out, nil
+}
+
+// postOptimizeSchedule deconflicts the listed missions' collections across
+// their observers and stores each placement as the mission's schedule.
+// Listed missions left out have any earlier schedule removed. ?dry_run=true
+// stores nothing.
+func (api *API) postOptimizeSchedule(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req scheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"mission_ids\": [...], \"constraints\": {...}}.")
+		return
+	}
+	if len(req.MissionIDs) == 0 || len(req.MissionIDs) > scheduleMaxMissions {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Between 1 and %d mission IDs are required.", scheduleMaxMissions))
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'dry_run' parameter. Must be true or false.")
+		return
+	}
+
+	missions := make([]*Mission, 0, len(req.MissionIDs))
+	var observers []string
+	for _, id := range req.MissionIDs {
+		if slices.ContainsFunc(missions, func(m *Mission) bool { return m.ID == id }) {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Mission %s is listed twice.", id))
+			return
+		}
+		mission, err := api.loadMission(ctx, id)
+		if err != nil {
+			if errors.Is(err, errMissionNotFound) {
+				respondProblem(c, newProblem(http.StatusNotFound, CodeMissionNotFound, fmt.Sprintf("mission %s not found", id)).with("mission_id", id))
+				return
+			}
+			slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+			return
+		}
+		missions = append(missions, mission)
+		if mission.ObserverSatelliteID != "" && !slices.Contains(observers, mission.ObserverSatelliteID) {
+			observers = append(observers, mission.ObserverSatelliteID)
+		}
+	}
+	constraints, err := api.scheduleConstraints(req, observers)
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+
+	var candidates []*scheduleCandidate
+	rejected := []RejectedMission{}
+	for _, m := range missions {
+		var reason string
+		switch {
+		case missionComplete(m):
+			reason = "the mission is " + m.Status
+		case m.ObserverSatelliteID == "":
+			reason = "the mission has no observer satellite"
+		case m.CollectionWindowStart <= 0 || m.CollectionWindowEnd <= m.CollectionWindowStart:
+			reason = "the mission has no collection window"
+		}
+		if reason != "" {
+			rejected = append(rejected, RejectedMission{MissionID: m.ID, Reason: reason})
+			continue
+		}
+		candidate := &scheduleCandidate{mission: m, k: constraints[m.ObserverSatelliteID]}
+		mid := time.Unix((m.CollectionWindowStart+m.CollectionWindowEnd)/2, 0)
+		if pair, err := api.orbits(ctx, m.TargetSatelliteID, m.ObserverSatelliteID, mid); err == nil {
+			candidate.pair = &pair
+		} else {
+			slog.DebugContext(ctx, "scheduling without geometry", "id", m.ID, "err", err)
+		}
+		candidates = append(candidates, candidate)
+	}
+	scheduled, conflicts, loads := optimizeSchedule(candidates)
+	out := ScheduleResponse{Scheduled: scheduled, Rejected: append(rejected, conflicts...), Observers: loads, Persisted: !dryRun}
+	now := time.Now().Unix()
+	for i := range out.Scheduled {
+		out.Scheduled[i].ScheduledAt = now
+	}
+
+	if !dryRun {
+		update := func(id string, s *ScheduleAssignment) bool {
+			if err := api.MissionDB.SetSchedule(ctx, id, s); err != nil {
+				slog.ErrorContext(ctx, "failed to store mission schedule", "id", id, "err", err)
+				respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to update mission")
+				return false
+			}
+			api.missionChanged(ctx, id)
+			return true
+		}
+		for _, s := range out.Scheduled {
+			if !update(s.MissionID, &s.ScheduleAssignment) {
+				return
+			}
+		}
+		for _, r := range out.Rejected {
+			if m := missions[slices.IndexFunc(missions, func(m *Mission) bool { return m.ID == r.MissionID })]; m.Schedule != nil {
+				if !update(r.MissionID, nil) {
+					return
+				}
+			}
+		}
+	}
+	slog.InfoContext(ctx, "optimized schedule", "missions", len(missions), "scheduled", len(out.Scheduled), "dry_run", dryRun)
+	c.IndentedJSON(http.StatusOK, out)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOptimizeSchedule(t *testing.T) {
+	mission := func(id string, priority int, start, end, tca int64) *Mission {
+		return &Mission{ID: id, Priority: priority, ObserverSatelliteID: "obs", CollectionWindowStart: start, CollectionWindowEnd: end, TCA: tca}
+	}
+	k := defaultScheduleConstraints
+	budget := k
+	budget.FrameMB, budget.DownlinkBudgetMB = 10, 400
+	duty := k
+	duty.MaxDutyCycle, duty.DutyPeriodS = 0.5, 1000
+
+	tests := []struct {
+		name      string
+		k         ScheduleConstraints
+		missions  []*Mission
+		want      map[string][2]int64
+		rejected  map[string]string
+		maxDuty   float64
+		maxDataMB float64
+	}{
+		{
+			name: "slews between collections",
+			k:    k,
+			// Without geometry each turn is taken as 180° at 1°/s.
+			missions: []*Mission{mission("c", 3, 900, 1100, 1000), mission("b", 2, 1500, 2500, 2000), mission("a", 1, 1000, 1600, 1300)},
+			want:     map[string][2]int64{"a": {1000, 1600}, "b": {1780, 2500}},
+			rejected: map[string]string{"c": "free of a on obs"},
+		},
+		{
+			name:     "unset priorities go last",
+			k:        k,
+			missions: []*Mission{mission("a", 0, 1000, 1600, 1300), mission("b", 5, 1000, 1600, 1300)},
+			want:     map[string][2]int64{"b": {1000, 1600}},
+			rejected: map[string]string{"a": "free of b"},
+		},
+		{
+			name:      "downlink budget",
+			k:         budget,
+			missions:  []*Mission{mission("a", 1, 1000, 1600, 1300), mission("b", 2, 2000, 2600, 2300)},
+			want:      map[string][2]int64{"a": {1105, 1495}},
+			rejected:  map[string]string{"b": "downlink budget has 0.0 MB left"},
+			maxDataMB: 400,
+		},
+		{
+			name:     "duty cycle",
+			k:        duty,
+			missions: []*Mission{mission("a", 1, 1000, 1600, 1300), mission("b", 2, 1500, 2500, 2400), mission("c", 3, 3000, 3100, 3050)},
+			want:     map[string][2]int64{"a": {1050, 1550}, "b": {2050, 2500}, "c": {3000, 3100}},
+			maxDuty:  0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var candidates []*scheduleCandidate
+			for _, m := range tt.missions {
+				candidates = append(candidates, &scheduleCandidate{mission: m, k: tt.k})
+			}
+			scheduled, rejected, loads := optimizeSchedule(candidates)
+			got := map[string][2]int64{}
+			for i, s := range scheduled {
+				got[s.MissionID] = [2]int64{s.Start, s.End}
+				if i > 0 && s.Start < scheduled[i-1].Start {
+					t.Errorf("%s is before %s", s.MissionID, scheduled[i-1].MissionID)
+				}
+				if i > 0 && s.SlewDeg != scheduleUnknownSlewDeg {
+					t.Errorf("%s slew %v", s.MissionID, s.SlewDeg)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("scheduled %+v, want %v", scheduled, tt.want)
+			}
+			for id, span := range tt.want {
+				if got[id] != span {
+					t.Errorf("%s at %v, want %v", id, got[id], span)
+				}
+			}
+			if len(rejected) != len(tt.rejected) {
+				t.Errorf("rejected %+v, want %v", rejected, tt.rejected)
+			}
+			for _, r := range rejected {
+				if !strings.Contains(r.Reason, tt.rejected[r.MissionID]) || tt.rejected[r.MissionID] == "" {
+					t.Errorf("%s rejected: %s", r.MissionID, r.Reason)
+				}
+			}
+			load := loads["obs"]
+			if tt.maxDuty > 0 && (load.DutyCycle > tt.maxDuty || load.DutyCycle < tt.maxDuty-0.01) {
+				t.Errorf("duty cycle %v, want %v", load.DutyCycle, tt.maxDuty)
+			}
+			if load.DataMB != tt.maxDataMB {
+				t.Errorf("data %v MB, want %v", load.DataMB, tt.maxDataMB)
+			}
+		})
+	}
+}
+
+func TestPostOptimizeSchedule(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	shifted := shiftedISS(t)
+	epoch := iss.Epoch.Unix()
+	missions := missionMap{
+		"m1":   {ID: "m1", Priority: 1, TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", CollectionWindowStart: epoch, CollectionWindowEnd: epoch + 600, TCA: epoch + 300},
+		"m2":   {ID: "m2", Priority: 2, TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", CollectionWindowStart: epoch + 300, CollectionWindowEnd: epoch + 1200, TCA: epoch + 900},
+		"m3":   {ID: "m3", Priority: 3, TargetSatelliteID: "iss", ObserverSatelliteID: "chaser", CollectionWindowStart: epoch, CollectionWindowEnd: epoch + 600, Schedule: &ScheduleAssignment{Start: 1, End: 2}},
+		"done": {ID: "done", Status: "Completed", ObserverSatelliteID: "chaser", CollectionWindowStart: epoch, CollectionWindowEnd: epoch + 600},
+		"open": {ID: "open", ObserverSatelliteID: "chaser"},
+	}
+	api := &API{MissionDB: missions, Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, ""), Sensors: newSensorStore(nil, "")}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive, TLE: &iss})
+	api.Satellites.Put(ctx, Satellite{ID: "chaser", NoradID: 99999, Name: "Chaser", Status: SatelliteActive, TLE: &shifted})
+	api.Sensors.Put(ctx, Sensor{SatelliteID: "chaser", FocalLengthMM: 500, PixelPitchUM: 5, Width: 1000, Height: 1000, BitDepth: 12})
+	router := gin.New()
+	router.POST("/schedule/optimize", api.postOptimizeSchedule)
+	do := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w
+	}
+
+	tests := []struct {
+		path, body string
+		want       int
+		code       ErrorCode
+	}{
+		{"/schedule/optimize", `{"mission_ids": []}`, http.StatusBadRequest, CodeInvalidBody},
+		{"/schedule/optimize", `{"mission_ids": ["m1", "m1"]}`, http.StatusBadRequest, CodeInvalidBody},
+		{"/schedule/optimize", `{"mission_ids": ["m1"], "constraints": {"max_duty_cycle": 2}}`, http.StatusBadRequest, CodeInvalidBody},
+		{"/schedule/optimize", `{"mission_ids": ["m1"], "observers": {"chaser": {"slew_rate_deg_s": 0}}}`, http.StatusBadRequest, CodeInvalidBody},
+		{"/schedule/optimize", `{"mission_ids": ["m1"], "constraints": {"settle_s": "long"}}`, http.StatusBadRequest, CodeInvalidBody},
+		{"/schedule/optimize?dry_run=maybe", `{"mission_ids": ["m1"]}`, http.StatusBadRequest, CodeInvalidParameter},
+		{"/schedule/optimize", `{"mission_ids": ["m1", "gone"]}`, http.StatusNotFound, CodeMissionNotFound},
+	}
+	for _, tt := range tests {
+		w := do(tt.path, tt.body)
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s %s: %d %s, want %d %s", tt.path, tt.body, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+
+	body := `{"mission_ids": ["m3", "m2", "m1", "done", "open"], "constraints": {"settle_s": 30}}`
+	w := do("/schedule/optimize?dry_run=true", body)
+	var dry ScheduleResponse
+	json.Unmarshal(w.Body.Bytes(), &dry)
+	if w.Code != http.StatusOK || dry.Persisted || len(dry.Scheduled) != 2 || missions["m1"].Schedule != nil {
+		t.Fatalf("dry run: %d %s", w.Code, w.Body)
+	}
+
+	w = do("/schedule/optimize", body)
+	var resp ScheduleResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || !resp.Persisted || len(resp.Scheduled) != 2 {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	first, second := resp.Scheduled[0], resp.Scheduled[1]
+	// Both track the ISS, so the observer barely turns and only settles.
+	if first.MissionID != "m1" || first.Start != epoch || first.End != epoch+600 || first.Rank != 1 || first.Frames != 61 || first.DataMB != 122 {
+		t.Errorf("first %+v", first)
+	}
+	if second.MissionID != "m2" || second.Start-first.End < 30 || second.Start-first.End > 31 || second.End != epoch+1200 || second.SlewDeg > 0.5 {
+		t.Errorf("second %+v", second)
+	}
+	reasons := map[string]string{}
+	for _, r := range resp.Rejected {
+		reasons[r.MissionID] = r.Reason
+	}
+	if reasons["done"] != "the mission is Completed" || reasons["open"] != "the mission has no collection window" || !strings.Contains(reasons["m3"], "free of m1") {
+		t.Errorf("rejected %+v", resp.Rejected)
+	}
+	if load := resp.Observers["chaser"]; load.CollectS != 600+second.End-second.Start || load.DataMB != 122+second.DataMB {
+		t.Errorf("load %+v", load)
+	}
+
+	if s := missions["m1"].Schedule; s == nil || s.Start != first.Start || s.ScheduledAt == 0 {
+		t.Errorf("stored m1 schedule %+v", s)
+	}
+	if s := missions["m2"].Schedule; s == nil || s.Start != second.Start || s.SlewDeg != second.SlewDeg {
+		t.Errorf("stored m2 schedule %+v", s)
+	}
+	if missions["m3"].Schedule != nil {
+		t.Errorf("rejected m3 kept its schedule %+v", missions["m3"].Schedule)
+	}
+}
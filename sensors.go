@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// With SENSORS_TABLE set, the registry is reread every sensorRefresh.
+	sensorRefresh     = time.Minute
+	maxSensorPixels   = 1 << 16
+	maxSensorBitDepth = 32
+)
+
+var errSensorNotFound = errors.New("sensor not found")
+
+// Sensor is the imaging payload of an observer satellite. FOVDeg, IFOVUrad
+// and PlateScale follow from the optics and are filled in when it is saved.
+type Sensor struct {
+	SatelliteID   string  `dynamodbav:"id" json:"satellite_id"`
+	Name          string  `dynamodbav:"name,omitempty" json:"name,omitempty"`
+	FocalLengthMM float64 `dynamodbav:"focal_length_mm" json:"focal_length_mm"`
+	PixelPitchUM  float64 `dynamodbav:"pixel_pitch_um" json:"pixel_pitch_um"`
+	// Width and Height are the detector's size in pixels.
+	Width    int `dynamodbav:"width" json:"width"`
+	Height   int `dynamodbav:"height" json:"height"`
+	BitDepth int `dynamodbav:"bit_depth" json:"bit_depth"`
+	// FOVDeg is the full field of view across the width and the height.
+	FOVDeg [2]float64 `dynamodbav:"fov_deg" json:"fov_deg"`
+	// IFOVUrad is one pixel's angle in microradians, and PlateScale the same
+	// in arcseconds.
+	IFOVUrad   float64 `dynamodbav:"ifov_urad" json:"ifov_urad"`
+	PlateScale float64 `dynamodbav:"plate_scale_arcsec" json:"plate_scale_arcsec"`
+	Updated    int64   `dynamodbav:"updated" json:"updated"`
+}
+
+// derive fills in the angles that follow from the optics.
+func (s *Sensor) derive() {
+	ifov := s.PixelPitchUM * 1e-3 / s.FocalLengthMM
+	fov := func(pixels int) float64 {
+		return math.Round(2*math.Atan(float64(pixels)*ifov/2)*180/math.Pi*1e6) / 1e6
+	}
+	s.FOVDeg = [2]float64{fov(s.Width), fov(s.Height)}
+	s.IFOVUrad = math.Round(ifov*1e6*1e4) / 1e4
+	s.PlateScale = math.Round(ifov*180/math.Pi*3600*1e4) / 1e4
+}
+
+// SensorCoverage is what a sensor sees of the target from RangeKM.
+type SensorCoverage struct {
+	RangeKM float64 `dynamodbav:"range_km" json:"range_km"`
+	// PixelScaleM is the span of one pixel at the target, and FootprintM
+	// that of the whole field across its width and height, in metres.
+	PixelScaleM float64    `dynamodbav:"pixel_scale_m" json:"pixel_scale_m"`
+	FootprintM  [2]float64 `dynamodbav:"footprint_m" json:"footprint_m"`
+	// TargetPixels is the target's expected extent in pixels, taking the
+	// square root of its radar cross-section as its size, and TargetFill
+	// that over the field's shorter side. Both are zero when the target has
+	// no RCS.
+	TargetPixels float64 `dynamodbav:"target_pixels,omitempty" json:"target_pixels,omitempty"`
+	TargetFill   float64 `dynamodbav:"target_fill,omitempty" json:"target_fill,omitempty"`
+}
+
+// coverage is what s sees from rangeKM of a target with the radar
+// cross-section rcs, in square metres.
+func (s Sensor) coverage(rangeKM, rcs float64) SensorCoverage {
+	ifov := s.IFOVUrad * 1e-6
+	rangeM := rangeKM * 1000
+	footprint := func(deg float64) float64 {
+		return math.Round(2*rangeM*math.Tan(deg*math.Pi/360)*1000) / 1000
+	}
+	c := SensorCoverage{
+		RangeKM:     rangeKM,
+		PixelScaleM: math.Round(rangeM*ifov*1e6) / 1e6,
+		FootprintM:  [2]float64{footprint(s.FOVDeg[0]), footprint(s.FOVDeg[1])},
+	}
+	if rcs > 0 && ifov > 0 && rangeM > 0 {
+		pixels := math.Sqrt(rcs) / (rangeM * ifov)
+		c.TargetPixels = math.Round(pixels*100) / 100
+		c.TargetFill = math.Round(pixels/float64(min(s.Width, s.Height))*1e4) / 1e4
+	}
+	return c
+}
+
+// sensorCoverage is what the observer's sensor sees of the target from
+// rangeKM, or nil when the observer has no sensor registered.
+func (api *API) sensorCoverage(observerID, targetID string, rangeKM float64) *SensorCoverage {
+	sensor, err := api.Sensors.Get(observerID)
+	if err != nil {
+		return nil
+	}
+	var rcs float64
+	if target, err := api.Satellites.Get(targetID); err == nil {
+		rcs = target.RCS
+	}
+	c := sensor.coverage(rangeKM, rcs)
+	return &c
+}
+
+// SensorStore holds the sensor registry, one sensor per observer satellite.
+// When SENSORS_TABLE is set it is saved to DynamoDB and shared by every
+// instance; without it, it lives only in this process.
+type SensorStore struct {
+	mu      sync.RWMutex
+	sensors map[string]*Sensor
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newSensorStore(db *dynamodb.Client, table string) *SensorStore {
+	s := &SensorStore{sensors: make(map[string]*Sensor), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the registry and rereads it until ctx is done.
+func (s *SensorStore) Start(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load sensors", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(sensorRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload sensors", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Put creates or replaces the sensor of sensor.SatelliteID, reporting
+// whether it is new.
+func (s *SensorStore) Put(ctx context.Context, sensor Sensor) (Sensor, bool, error) {
+	sensor.derive()
+	sensor.Updated = time.Now().Unix()
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(sensor)
+		if err != nil {
+			return Sensor{}, false, fmt.Errorf("marshal sensor: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return Sensor{}, false, err
+		}
+	}
+	stored := sensor
+	s.mu.Lock()
+	_, exists := s.sensors[sensor.SatelliteID]
+	s.sensors[sensor.SatelliteID] = &stored
+	s.mu.Unlock()
+	return sensor, !exists, nil
+}
+
+func (s *SensorStore) Get(satelliteID string) (Sensor, error) {
+	if s == nil {
+		return Sensor{}, errSensorNotFound
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sensor, ok := s.sensors[satelliteID]
+	if !ok {
+		return Sensor{}, errSensorNotFound
+	}
+	return *sensor, nil
+}
+
+// Delete removes the satellite's sensor.
+func (s *SensorStore) Delete(ctx context.Context, satelliteID string) error {
+	if _, err := s.Get(satelliteID); err != nil {
+		return err
+	}
+	if s.db != nil {
+		_, err := s.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: satelliteID}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	delete(s.sensors, satelliteID)
+	s.mu.Unlock()
+	return nil
+}
+
+// List returns every sensor in satellite ID order.
+func (s *SensorStore) List() []Sensor {
+	s.mu.RLock()
+	list := make([]Sensor, 0, len(s.sensors))
+	for _, sensor := range s.sensors {
+		list = append(list, *sensor)
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].SatelliteID < list[j].SatelliteID })
+	return list
+}
+
+// refresh replaces the registry with SENSORS_TABLE's.
+func (s *SensorStore) refresh(ctx context.Context) error {
+	sensors := make(map[string]*Sensor)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []Sensor
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for i := range items {
+			sensors[items[i].SatelliteID] = &items[i]
+		}
+	}
+	s.mu.Lock()
+	s.sensors = sensors
+	s.mu.Unlock()
+	return nil
+}
+
+// sensorRequest is the body of PUT /satellite/:id/sensor.
+type sensorRequest struct {
+	Name          string  `json:"name"`
+	FocalLengthMM float64 `json:"focal_length_mm"`
+	PixelPitchUM  float64 `json:"pixel_pitch_um"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	BitDepth      int     `json:"bit_depth"`
+}
+
+func (req sensorRequest) validate(satelliteID string) (Sensor, error) {
+	invalid := func(msg string) (Sensor, error) {
+		return Sensor{}, newProblem(http.StatusBadRequest, CodeInvalidBody, msg)
+	}
+	switch {
+	case !(req.FocalLengthMM > 0) || math.IsInf(req.FocalLengthMM, 0):
+		return invalid("'focal_length_mm' must be above 0.")
+	case !(req.PixelPitchUM > 0) || math.IsInf(req.PixelPitchUM, 0):
+		return invalid("'pixel_pitch_um' must be above 0.")
+	case req.Width < 1 || req.Width > maxSensorPixels || req.Height < 1 || req.Height > maxSensorPixels:
+		return invalid(fmt.Sprintf("'width' and 'height' must be from 1 to %d pixels.", maxSensorPixels))
+	case req.BitDepth < 1 || req.BitDepth > maxSensorBitDepth:
+		return invalid(fmt.Sprintf("'bit_depth' must be from 1 to %d.", maxSensorBitDepth))
+	}
+	return Sensor{
+		SatelliteID:   satelliteID,
+		Name:          strings.TrimSpace(req.Name),
+		FocalLengthMM: req.FocalLengthMM,
+		PixelPitchUM:  req.PixelPitchUM,
+		Width:         req.Width,
+		Height:        req.Height,
+		BitDepth:      req.BitDepth,
+	}, nil
+}
+
+// SensorListResponse is the body of GET /sensors.
+type SensorListResponse struct {
+	Sensors []Sensor `json:"sensors"`
+}
+
+func (api *API) getSensors(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, SensorListResponse{Sensors: api.Sensors.List()})
+}
+
+func (api *API) getSensor(c *gin.Context) {
+	sensor, err := api.Sensors.Get(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeSensorNotFound, "sensor not found")
+		return
+	}
+	conditionalJSON(c, sensor, time.Unix(sensor.Updated, 0))
+}
+
+// putSensor registers the imaging payload of a catalog satellite, replacing
+// any it had.
+func (api *API) putSensor(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	var req sensorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected a sensor with focal_length_mm, pixel_pitch_um, width, height and bit_depth.")
+		return
+	}
+	sensor, err := req.validate(id)
+	if err == nil {
+		if _, err = api.Satellites.Get(id); err != nil {
+			err = newProblem(http.StatusNotFound, CodeSatelliteNotFound, "satellite not found")
+		}
+	}
+	created := false
+	if err == nil {
+		sensor, created, err = api.Sensors.Put(ctx, sensor)
+	}
+	if err != nil {
+		var p *Problem
+		if !errors.As(err, &p) {
+			slog.ErrorContext(ctx, "failed to save sensor", "satellite", id, "err", err)
+		}
+		respondProblem(c, problemFor(err))
+		return
+	}
+	slog.InfoContext(ctx, "saved sensor", "satellite", id, "plate_scale", sensor.PlateScale, "created", created)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.IndentedJSON(status, sensor)
+}
+
+func (api *API) deleteSensor(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if err := api.Sensors.Delete(ctx, id); err != nil {
+		if errors.Is(err, errSensorNotFound) {
+			respondError(c, http.StatusNotFound, CodeSensorNotFound, "sensor not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete sensor", "satellite", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete sensor")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
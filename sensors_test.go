@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSensorCoverage(t *testing.T) {
+	s := Sensor{FocalLengthMM: 1000, PixelPitchUM: 10, Width: 2048, Height: 1024, BitDepth: 12}
+	s.derive()
+	if s.IFOVUrad != 10 || math.Abs(s.PlateScale-2.0626) > 1e-4 || s.FOVDeg != [2]float64{1.173377, 0.586704} {
+		t.Errorf("derived %+v", s)
+	}
+	tests := []struct {
+		rangeKM, rcs float64
+		want         SensorCoverage
+	}{
+		{10, 4, SensorCoverage{RangeKM: 10, PixelScaleM: 0.1, FootprintM: [2]float64{204.8, 102.4}, TargetPixels: 20, TargetFill: 0.0195}},
+		{100, 0, SensorCoverage{RangeKM: 100, PixelScaleM: 1, FootprintM: [2]float64{2048, 1024}}},
+	}
+	for _, tt := range tests {
+		got := s.coverage(tt.rangeKM, tt.rcs)
+		if math.Abs(got.PixelScaleM-tt.want.PixelScaleM) > 1e-9 || math.Abs(got.FootprintM[0]-tt.want.FootprintM[0]) > 0.01 || math.Abs(got.FootprintM[1]-tt.want.FootprintM[1]) > 0.01 ||
+			got.TargetPixels != tt.want.TargetPixels || got.TargetFill != tt.want.TargetFill {
+			t.Errorf("coverage(%v, %v) = %+v, want %+v", tt.rangeKM, tt.rcs, got, tt.want)
+		}
+	}
+}
+
+func TestSensors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	iss, _, _ := parseTLE(issLine1, issLine2)
+	shifted := shiftedISS(t)
+	api := &API{Satellites: newSatelliteStore(nil, ""), TLEs: newTLEStore(nil, ""), Sensors: newSensorStore(nil, "")}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", RCS: 400, Status: SatelliteActive, TLE: &iss})
+	api.Satellites.Put(ctx, Satellite{ID: "chaser", NoradID: 99999, Name: "Chaser", Status: SatelliteActive, TLE: &shifted})
+	router := gin.New()
+	router.GET("/sensors", api.getSensors)
+	router.GET("/satellite/:id/sensor", api.getSensor)
+	router.PUT("/satellite/:id/sensor", api.putSensor)
+	router.DELETE("/satellite/:id/sensor", api.deleteSensor)
+	router.GET("/access-windows", api.getAccessWindows)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	camera := `{"name": "Inspector", "focal_length_mm": 500, "pixel_pitch_um": 5.5, "width": 4096, "height": 3000, "bit_depth": 12}`
+	tests := []struct {
+		method, path, body string
+		want               int
+		code               ErrorCode
+	}{
+		{http.MethodGet, "/satellite/chaser/sensor", "", http.StatusNotFound, CodeSensorNotFound},
+		{http.MethodPut, "/satellite/chaser/sensor", `{"focal_length_mm": 0, "pixel_pitch_um": 5, "width": 10, "height": 10, "bit_depth": 8}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/satellite/chaser/sensor", `{"focal_length_mm": 500, "pixel_pitch_um": 5, "width": 0, "height": 10, "bit_depth": 8}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/satellite/chaser/sensor", `{"focal_length_mm": 500, "pixel_pitch_um": 5, "width": 10, "height": 10, "bit_depth": 64}`, http.StatusBadRequest, CodeInvalidBody},
+		{http.MethodPut, "/satellite/nope/sensor", camera, http.StatusNotFound, CodeSatelliteNotFound},
+		{http.MethodPut, "/satellite/chaser/sensor", camera, http.StatusCreated, ""},
+		{http.MethodPut, "/satellite/chaser/sensor", camera, http.StatusOK, ""},
+		{http.MethodGet, "/satellite/chaser/sensor", "", http.StatusOK, ""},
+		{http.MethodDelete, "/satellite/iss/sensor", "", http.StatusNotFound, CodeSensorNotFound},
+	}
+	for _, tt := range tests {
+		w := do(tt.method, tt.path, tt.body)
+		var got Problem
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if w.Code != tt.want || got.Code != tt.code {
+			t.Errorf("%s %s: %d %s, want %d %s", tt.method, tt.path, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+	var list SensorListResponse
+	json.Unmarshal(do(http.MethodGet, "/sensors", "").Body.Bytes(), &list)
+	if len(list.Sensors) != 1 || list.Sensors[0].SatelliteID != "chaser" || list.Sensors[0].IFOVUrad != 11 {
+		t.Errorf("sensors %+v", list.Sensors)
+	}
+
+	// Planning sees the target through the observer's sensor at TCA.
+	at := "&start=" + iss.Epoch.Truncate(time.Second).Format(time.RFC3339) + "&end=" + iss.Epoch.Add(6*time.Hour).Format(time.RFC3339)
+	var access AccessWindowsResponse
+	json.Unmarshal(do(http.MethodGet, "/access-windows?observer=chaser&target=iss&max_range_km=300"+at, "").Body.Bytes(), &access)
+	if len(access.Windows) == 0 {
+		t.Fatal("no access windows")
+	}
+	for _, w := range access.Windows {
+		c := w.Coverage
+		if c == nil || c.RangeKM != w.MinRangeKM || math.Abs(c.PixelScaleM-w.MinRangeKM*11e-3) > 1e-6 || math.Abs(c.TargetPixels*c.PixelScaleM-20) > 0.01 {
+			t.Errorf("window %+v coverage %+v", w, c)
+		}
+	}
+	var reverse AccessWindowsResponse
+	json.Unmarshal(do(http.MethodGet, "/access-windows?observer=iss&target=chaser&max_range_km=300"+at, "").Body.Bytes(), &reverse)
+	if len(reverse.Windows) == 0 || reverse.Windows[0].Coverage != nil {
+		t.Errorf("coverage without a sensor: %+v", reverse.Windows)
+	}
+
+	if w := do(http.MethodDelete, "/satellite/chaser/sensor", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: %d %s", w.Code, w.Body)
+	}
+	if _, err := api.Sensors.Get("chaser"); err == nil {
+		t.Error("sensor still registered")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WGS-72 constants, which the published element sets are fitted with.
+const (
+	earthRadiusKm = 6378.135
+	earthMu       = 398600.8
+	sgp4J2        = 0.001082616
+	sgp4J3        = -0.00000253881
+	sgp4J4        = -0.00000165597
+	sgp4J3oJ2     = sgp4J3 / sgp4J2
+	// earthRotation is the Earth's rotation rate in rad/s.
+	earthRotation = 7.2921158553e-5
+)
+
+// sgp4XKE is sqrt(GM) in earth radii^1.5 per minute.
+var sgp4XKE = 60 / math.Sqrt(earthRadiusKm*earthRadiusKm*earthRadiusKm/earthMu)
+
+var (
+	// errDeepSpace is returned for orbits with a period of 225 minutes or
+	// more, which need the SDP4 deep-space terms.
+	errDeepSpace = errors.New("the orbit's period is 225 minutes or more; deep-space (SDP4) propagation is not supported")
+	errDecayed   = errors.New("the satellite has decayed by this time")
+)
+
+// sgp4 propagates one element set with the near-Earth SGP4 model, as in
+// Vallado et al., "Revisiting Spacetrack Report #3" (2006).
+type sgp4 struct {
+	epoch time.Time
+
+	bstar, ecco, inclo, nodeo, argpo, mo, no float64
+
+	isimp                                      bool
+	aycof, con41, cc1, cc4, cc5, d2, d3, d4    float64
+	delmo, eta, argpdot, omgcof, sinmao        float64
+	t2cof, t3cof, t4cof, t5cof, x1mth2, x7thm1 float64
+	mdot, nodedot, xlcof, xmcof, nodecf        float64
+}
+
+// StateVector is a position in km and velocity in km/s.
+type StateVector struct {
+	Position [3]float64 `json:"position"`
+	Velocity [3]float64 `json:"velocity"`
+}
+
+// newSGP4 reads the mean elements of tle and initializes the model.
+func newSGP4(tle TLE) (*sgp4, error) {
+	field := func(line string, from, to int) (float64, error) {
+		return strconv.ParseFloat(strings.TrimSpace(line[from:to]), 64)
+	}
+	var errs []error
+	get := func(line string, from, to int) float64 {
+		v, err := field(line, from, to)
+		errs = append(errs, err)
+		return v
+	}
+	deg := math.Pi / 180
+	p := &sgp4{epoch: tle.Epoch}
+	p.inclo = get(tle.Line2, 8, 16) * deg
+	p.nodeo = get(tle.Line2, 17, 25) * deg
+	p.ecco = get(tle.Line2, 26, 33) / 1e7
+	p.argpo = get(tle.Line2, 34, 42) * deg
+	p.mo = get(tle.Line2, 43, 51) * deg
+	p.no = get(tle.Line2, 52, 63) * 2 * math.Pi / 1440
+	// B* is written as a signed mantissa with an implied leading decimal
+	// point, then a signed exponent: " 28098-4" is 0.28098e-4.
+	b := strings.TrimSpace(tle.Line1[53:61])
+	if len(b) > 2 {
+		mantissa, exp := b[:len(b)-2], b[len(b)-2:]
+		sign := ""
+		if mantissa[0] == '-' || mantissa[0] == '+' {
+			sign, mantissa = mantissa[:1], mantissa[1:]
+		}
+		p.bstar, _ = strconv.ParseFloat(sign+"0."+mantissa+"e"+exp, 64)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("TLE elements: %w", err)
+	}
+	if p.no <= 0 || p.ecco >= 1 {
+		return nil, errors.New("TLE elements do not describe an orbit")
+	}
+	return p, p.init()
+}
+
+func (p *sgp4) init() error {
+	const x2o3 = 2.0 / 3.0
+	ss := 78/earthRadiusKm + 1
+	qzms2t := math.Pow((120-78)/earthRadiusKm, 4)
+
+	// Recover the original mean motion and semi-major axis from the
+	// Kozai mean motion the element set carries.
+	eccsq := p.ecco * p.ecco
+	omeosq := 1 - eccsq
+	rteosq := math.Sqrt(omeosq)
+	cosio := math.Cos(p.inclo)
+	cosio2 := cosio * cosio
+	ak := math.Pow(sgp4XKE/p.no, x2o3)
+	d1 := 0.75 * sgp4J2 * (3*cosio2 - 1) / (rteosq * omeosq)
+	del := d1 / (ak * ak)
+	adel := ak * (1 - del*del - del*(1.0/3.0+134*del*del/81))
+	del = d1 / (adel * adel)
+	p.no /= 1 + del
+	if 2*math.Pi/p.no >= 225 {
+		return errDeepSpace
+	}
+
+	ao := math.Pow(sgp4XKE/p.no, x2o3)
+	sinio := math.Sin(p.inclo)
+	po := ao * omeosq
+	con42 := 1 - 5*cosio2
+	p.con41 = -con42 - cosio2 - cosio2
+	posq := po * po
+	rp := ao * (1 - p.ecco)
+
+	// Perigees under 220 km get the simplified drag terms.
+	p.isimp = rp < 220/earthRadiusKm+1
+	sfour, qzms24 := ss, qzms2t
+	if perige := (rp - 1) * earthRadiusKm; perige < 156 {
+		sfour = perige - 78
+		if perige < 98 {
+			sfour = 20
+		}
+		qzms24 = math.Pow((120-sfour)/earthRadiusKm, 4)
+		sfour = sfour/earthRadiusKm + 1
+	}
+	pinvsq := 1 / posq
+	tsi := 1 / (ao - sfour)
+	p.eta = ao * p.ecco * tsi
+	etasq := p.eta * p.eta
+	eeta := p.ecco * p.eta
+	psisq := math.Abs(1 - etasq)
+	coef := qzms24 * math.Pow(tsi, 4)
+	coef1 := coef / math.Pow(psisq, 3.5)
+	cc2 := coef1 * p.no * (ao*(1+1.5*etasq+eeta*(4+etasq)) +
+		0.375*sgp4J2*tsi/psisq*p.con41*(8+3*etasq*(8+etasq)))
+	p.cc1 = p.bstar * cc2
+	cc3 := 0.0
+	if p.ecco > 1e-4 {
+		cc3 = -2 * coef * tsi * sgp4J3oJ2 * p.no * sinio / p.ecco
+	}
+	p.x1mth2 = 1 - cosio2
+	p.cc4 = 2 * p.no * coef1 * ao * omeosq * (p.eta*(2+0.5*etasq) + p.ecco*(0.5+2*etasq) -
+		sgp4J2*tsi/(ao*psisq)*(-3*p.con41*(1-2*eeta+etasq*(1.5-0.5*eeta))+
+			0.75*p.x1mth2*(2*etasq-eeta*(1+etasq))*math.Cos(2*p.argpo)))
+	p.cc5 = 2 * coef1 * ao * omeosq * (1 + 2.75*(etasq+eeta) + eeta*etasq)
+
+	cosio4 := cosio2 * cosio2
+	temp1 := 1.5 * sgp4J2 * pinvsq * p.no
+	temp2 := 0.5 * temp1 * sgp4J2 * pinvsq
+	temp3 := -0.46875 * sgp4J4 * pinvsq * pinvsq * p.no
+	p.mdot = p.no + 0.5*temp1*rteosq*p.con41 + 0.0625*temp2*rteosq*(13-78*cosio2+137*cosio4)
+	p.argpdot = -0.5*temp1*con42 + 0.0625*temp2*(7-114*cosio2+395*cosio4) + temp3*(3-36*cosio2+49*cosio4)
+	xhdot1 := -temp1 * cosio
+	p.nodedot = xhdot1 + (0.5*temp2*(4-19*cosio2)+2*temp3*(3-7*cosio2))*cosio
+	p.omgcof = p.bstar * cc3 * math.Cos(p.argpo)
+	if p.ecco > 1e-4 {
+		p.xmcof = -x2o3 * coef * p.bstar / eeta
+	}
+	p.nodecf = 3.5 * omeosq * xhdot1 * p.cc1
+	p.t2cof = 1.5 * p.cc1
+	// Avoid dividing by zero for an inclination of 180 degrees.
+	if math.Abs(cosio+1) > 1.5e-12 {
+		p.xlcof = -0.25 * sgp4J3oJ2 * sinio * (3 + 5*cosio) / (1 + cosio)
+	} else {
+		p.xlcof = -0.25 * sgp4J3oJ2 * sinio * (3 + 5*cosio) / 1.5e-12
+	}
+	p.aycof = -0.5 * sgp4J3oJ2 * sinio
+	p.delmo = math.Pow(1+p.eta*math.Cos(p.mo), 3)
+	p.sinmao = math.Sin(p.mo)
+	p.x7thm1 = 7*cosio2 - 1
+
+	if !p.isimp {
+		cc1sq := p.cc1 * p.cc1
+		p.d2 = 4 * ao * tsi * cc1sq
+		temp := p.d2 * tsi * p.cc1 / 3
+		p.d3 = (17*ao + sfour) * temp
+		p.d4 = 0.5 * temp * ao * tsi * (221*ao + 31*sfour) * p.cc1
+		p.t3cof = p.d2 + 2*cc1sq
+		p.t4cof = 0.25 * (3*p.d3 + p.cc1*(12*p.d2+10*cc1sq))
+		p.t5cof = 0.2 * (3*p.d4 + 12*p.cc1*p.d3 + 6*p.d2*p.d2 + 15*cc1sq*(2*p.d2+cc1sq))
+	}
+	return nil
+}
+
+// At returns the satellite's state at t in the TEME frame SGP4 works in.
+func (p *sgp4) At(t time.Time) (StateVector, error) {
+	return p.propagate(t.Sub(p.epoch).Minutes())
+}
+
+// propagate returns the state tsince minutes after the epoch.
+func (p *sgp4) propagate(tsince float64) (StateVector, error) {
+	const twoPi = 2 * math.Pi
+	// Secular gravity and atmospheric drag.
+	xmdf := p.mo + p.mdot*tsince
+	argpdf := p.argpo + p.argpdot*tsince
+	nodedf := p.nodeo + p.nodedot*tsince
+	argpm, mm := argpdf, xmdf
+	t2 := tsince * tsince
+	nodem := nodedf + p.nodecf*t2
+	tempa := 1 - p.cc1*tsince
+	tempe := p.bstar * p.cc4 * tsince
+	templ := p.t2cof * t2
+	if !p.isimp {
+		delomg := p.omgcof * tsince
+		delm := p.xmcof * (math.Pow(1+p.eta*math.Cos(xmdf), 3) - p.delmo)
+		mm = xmdf + delomg + delm
+		argpm = argpdf - delomg - delm
+		t3 := t2 * tsince
+		t4 := t3 * tsince
+		tempa -= p.d2*t2 + p.d3*t3 + p.d4*t4
+		tempe += p.bstar * p.cc5 * (math.Sin(mm) - p.sinmao)
+		templ += p.t3cof*t3 + t4*(p.t4cof+tsince*p.t5cof)
+	}
+	am := math.Pow(sgp4XKE/p.no, 2.0/3.0) * tempa * tempa
+	nm := sgp4XKE / math.Pow(am, 1.5)
+	em := p.ecco - tempe
+	if em >= 1 || em < -0.001 {
+		return StateVector{}, errDecayed
+	}
+	em = max(em, 1e-6)
+	mm += p.no * templ
+	xlm := mm + argpm + nodem
+	nodem = math.Mod(nodem, twoPi)
+	argpm = math.Mod(argpm, twoPi)
+	xlm = math.Mod(xlm, twoPi)
+	mm = math.Mod(xlm-argpm-nodem, twoPi)
+	sinip, cosip := math.Sincos(p.inclo)
+
+	// Long-period periodics.
+	axnl := em * math.Cos(argpm)
+	temp := 1 / (am * (1 - em*em))
+	aynl := em*math.Sin(argpm) + temp*p.aycof
+	xl := mm + argpm + nodem + temp*p.xlcof*axnl
+
+	// Solve Kepler's equation.
+	u := math.Mod(xl-nodem, twoPi)
+	eo1 := u
+	var sineo1, coseo1 float64
+	for tem5, i := 1.0, 0; math.Abs(tem5) >= 1e-12 && i < 10; i++ {
+		sineo1, coseo1 = math.Sincos(eo1)
+		tem5 = (u - aynl*coseo1 + axnl*sineo1 - eo1) / (1 - coseo1*axnl - sineo1*aynl)
+		tem5 = math.Max(-0.95, math.Min(0.95, tem5))
+		eo1 += tem5
+	}
+
+	// Short-period periodics.
+	ecose := axnl*coseo1 + aynl*sineo1
+	esine := axnl*sineo1 - aynl*coseo1
+	el2 := axnl*axnl + aynl*aynl
+	pl := am * (1 - el2)
+	if pl < 0 {
+		return StateVector{}, errDecayed
+	}
+	rl := am * (1 - ecose)
+	rdotl := math.Sqrt(am) * esine / rl
+	rvdotl := math.Sqrt(pl) / rl
+	betal := math.Sqrt(1 - el2)
+	temp = esine / (1 + betal)
+	sinu := am / rl * (sineo1 - aynl - axnl*temp)
+	cosu := am / rl * (coseo1 - axnl + aynl*temp)
+	su := math.Atan2(sinu, cosu)
+	sin2u := (cosu + cosu) * sinu
+	cos2u := 1 - 2*sinu*sinu
+	temp = 1 / pl
+	temp1 := 0.5 * sgp4J2 * temp
+	temp2 := temp1 * temp
+
+	mrt := rl*(1-1.5*temp2*betal*p.con41) + 0.5*temp1*p.x1mth2*cos2u
+	if mrt < 1 {
+		return StateVector{}, errDecayed
+	}
+	su -= 0.25 * temp2 * p.x7thm1 * sin2u
+	xnode := nodem + 1.5*temp2*cosip*sin2u
+	xinc := p.inclo + 1.5*temp2*cosip*sinip*cos2u
+	mvt := rdotl - nm*temp1*p.x1mth2*sin2u/sgp4XKE
+	rvdot := rvdotl + nm*temp1*(p.x1mth2*cos2u+1.5*p.con41)/sgp4XKE
+
+	sinsu, cossu := math.Sincos(su)
+	snod, cnod := math.Sincos(xnode)
+	sini, cosi := math.Sincos(xinc)
+	xmx := -snod * cosi
+	xmy := cnod * cosi
+	ux, uy, uz := xmx*sinsu+cnod*cossu, xmy*sinsu+snod*cossu, sini*sinsu
+	vx, vy, vz := xmx*cossu-cnod*sinsu, xmy*cossu-snod*sinsu, sini*cossu
+	vkmpersec := earthRadiusKm * sgp4XKE / 60
+	return StateVector{
+		Position: [3]float64{mrt * ux * earthRadiusKm, mrt * uy * earthRadiusKm, mrt * uz * earthRadiusKm},
+		Velocity: [3]float64{
+			(mvt*ux + rvdot*vx) * vkmpersec,
+			(mvt*uy + rvdot*vy) * vkmpersec,
+			(mvt*uz + rvdot*vz) * vkmpersec,
+		},
+	}, nil
+}
+
+// gmst is the Greenwich mean sidereal time at t in radians (IAU 1982),
+// taking UTC for UT1.
+func gmst(t time.Time) float64 {
+	jd := float64(t.UnixNano())/86400e9 + 2440587.5
+	tut1 := (jd - 2451545) / 36525
+	sec := -6.2e-6*tut1*tut1*tut1 + 0.093104*tut1*tut1 + (876600*3600+8640184.812866)*tut1 + 67310.54841
+	theta := math.Mod(sec*math.Pi/180/240, 2*math.Pi)
+	if theta < 0 {
+		theta += 2 * math.Pi
+	}
+	return theta
+}
+
+// temeToECEF rotates a TEME state at t into the Earth-fixed frame, ignoring
+// polar motion.
+func temeToECEF(s StateVector, t time.Time) StateVector {
+	sin, cos := math.Sincos(gmst(t))
+	r, v := s.Position, s.Velocity
+	x, y := cos*r[0]+sin*r[1], -sin*r[0]+cos*r[1]
+	return StateVector{
+		Position: [3]float64{x, y, r[2]},
+		Velocity: [3]float64{
+			cos*v[0] + sin*v[1] + earthRotation*y,
+			-sin*v[0] + cos*v[1] - earthRotation*x,
+			v[2],
+		},
+	}
+}
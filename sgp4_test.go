@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// The first verification case of Vallado et al., "Revisiting Spacetrack
+// Report #3", with its published states.
+const (
+	vanguardLine1 = "1 00005U 58002B   00179.78495062  .00000023  00000-0  28098-4 0  4753"
+	vanguardLine2 = "2 00005  34.2682 348.7242 1859667 331.7664  19.3264 10.82419157413667"
+)
+
+func TestSGP4(t *testing.T) {
+	tle, _, err := parseTLE(vanguardLine1, vanguardLine2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newSGP4(tle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		minutes float64
+		r, v    [3]float64
+	}{
+		{0, [3]float64{7022.46529266, -1400.08296755, 0.03995155}, [3]float64{1.893841015, 6.405893759, 4.534807250}},
+		{360, [3]float64{-7154.03120202, -3783.17682504, -3536.19412294}, [3]float64{4.741887409, -4.151817765, -2.093935425}},
+		{720, [3]float64{-7134.59340119, 6531.68641334, 3260.27186483}, [3]float64{-4.113793027, -2.911922039, -2.557327851}},
+	}
+	for _, tt := range tests {
+		s, err := p.propagate(tt.minutes)
+		if err != nil {
+			t.Fatalf("%v min: %v", tt.minutes, err)
+		}
+		for i := range 3 {
+			if math.Abs(s.Position[i]-tt.r[i]) > 1e-6 || math.Abs(s.Velocity[i]-tt.v[i]) > 1e-9 {
+				t.Errorf("%v min: %v, want r %v v %v", tt.minutes, s, tt.r, tt.v)
+				break
+			}
+		}
+	}
+
+	// A geostationary element set needs SDP4.
+	geo1 := "1 26038U 00002A   08264.50000000 -.00000261  00000-0  10000-3 0  9998"
+	geo2 := "2 26038   0.0295  93.2876 0002413 276.4400 331.3555  1.00271509 31019"
+	geo1 = geo1[:tleLineLen-1] + string(tleChecksum(geo1))
+	geo2 = geo2[:tleLineLen-1] + string(tleChecksum(geo2))
+	if tle, _, err := parseTLE(geo1, geo2); err != nil {
+		t.Fatal(err)
+	} else if _, err := newSGP4(tle); err != errDeepSpace {
+		t.Errorf("geostationary: %v", err)
+	}
+}
+
+func TestTEMEToECEF(t *testing.T) {
+	j2000 := time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := gmst(j2000)*180/math.Pi, 280.46061837; math.Abs(got-want) > 1e-6 {
+		t.Errorf("gmst(J2000) = %v°, want %v°", got, want)
+	}
+
+	// A point on the TEME x axis at J2000 lies at longitude -GMST, and one
+	// at rest in TEME moves against the Earth at its rotation rate.
+	s := temeToECEF(StateVector{Position: [3]float64{7000, 0, 0}}, j2000)
+	if lon := math.Atan2(s.Position[1], s.Position[0]) * 180 / math.Pi; math.Abs(lon-(360-280.46061837)) > 1e-6 {
+		t.Errorf("longitude %v", lon)
+	}
+	if r := math.Hypot(s.Position[0], s.Position[1]); math.Abs(r-7000) > 1e-9 {
+		t.Errorf("|r| = %v", r)
+	}
+	if speed := math.Hypot(s.Velocity[0], s.Velocity[1]); math.Abs(speed-7000*earthRotation) > 1e-9 {
+		t.Errorf("|v| = %v", speed)
+	}
+}
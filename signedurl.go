@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSignedURLTTL = time.Hour
+	maxSignedURLTTL     = 7 * 24 * time.Hour
+	// minSigningKeyLen is the shortest URL_SIGNING_KEYS entry accepted.
+	minSigningKeyLen = 32
+)
+
+// signImageQuery returns the signature of an image URL: the HMAC-SHA256 of
+// the image ID and its query, which must hold exp and not sig. The query is
+// encoded in key order, so parameters may be reordered without breaking it,
+// and the path prefix is left out, so the URL works under /api/v1 and at the
+// unversioned path alike.
+func signImageQuery(key, id string, q url.Values) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("/image/" + id + "\n" + q.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedImage checks the sig and exp of q against every key, so a URL
+// signed with a key being rotated out keeps working while it is listed.
+func verifySignedImage(keys []string, id string, q url.Values, now time.Time) error {
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil || len(q["sig"]) != 1 || len(q["exp"]) != 1 {
+		return newProblem(http.StatusForbidden, CodeInvalidSignature, "the URL signature is malformed")
+	}
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return newProblem(http.StatusForbidden, CodeInvalidSignature, "the URL expiry is malformed")
+	}
+	signed := url.Values{}
+	for k, vs := range q {
+		if k != "sig" {
+			signed[k] = vs
+		}
+	}
+	for _, key := range keys {
+		want, _ := base64.RawURLEncoding.DecodeString(signImageQuery(key, id, signed))
+		if hmac.Equal(sig, want) {
+			if now.Unix() >= exp {
+				return newProblem(http.StatusForbidden, CodeSignatureExpired, "the signed URL has expired")
+			}
+			return nil
+		}
+	}
+	return newProblem(http.StatusForbidden, CodeInvalidSignature, "the URL signature does not match")
+}
+
+// requireSignedOr admits a request to /image/:id that carries a valid
+// ?sig=, without credentials, and hands any other to scopeCheck. An
+// invalid or expired signature is refused even if the request also carries
+// credentials, so a broken embed is noticed rather than working only for
+// signed-in users. A signed request counts against its client address, and
+// acts for the tenant it was signed for.
+func (api *API) requireSignedOr(scopeCheck gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := api.Config.URLSigningKeys
+		if len(keys) == 0 || !c.Request.URL.Query().Has("sig") {
+			scopeCheck(c)
+			return
+		}
+		q := c.Request.URL.Query()
+		if err := verifySignedImage(keys, c.Param("id"), q, time.Now()); err != nil {
+			respondProblem(c, problemFor(err))
+			return
+		}
+		if api.Config.MultiTenant {
+			tenant := q.Get(tenantParam)
+			if !tenantName.MatchString(tenant) {
+				respondError(c, http.StatusForbidden, CodeInvalidSignature, "the signed URL names no tenant")
+				return
+			}
+			c.Request = c.Request.WithContext(withTenant(c.Request.Context(), tenant))
+		}
+		c.Next()
+	}
+}
+
+// signedURLRequest is the body of POST /image/:id/signed-url.
+type signedURLRequest struct {
+	// Query is the processing parameters the URL is for, such as
+	// "width=256&format=webp".
+	Query string `json:"query,omitempty"`
+	// ExpiresIn is how many seconds the URL works for.
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+}
+
+// SignedURLResponse is the body of a signed URL issued.
+type SignedURLResponse struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"`
+}
+
+// postSignedURL issues a URL for the image with the requested parameters,
+// which works without credentials until it expires.
+func (api *API) postSignedURL(c *gin.Context) {
+	keys := api.Config.URLSigningKeys
+	if len(keys) == 0 {
+		respondError(c, http.StatusNotImplemented, CodeFeatureUnavailable, "signed URLs need URL_SIGNING_KEYS")
+		return
+	}
+	var req signedURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"query\": \"...\", \"expires_in\": seconds}.")
+		return
+	}
+	q, err := url.ParseQuery(req.Query)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "'query' is not a URL query string.")
+		return
+	}
+	if q.Has("sig") || q.Has("exp") || q.Has("access_token") || q.Has(tenantParam) {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "'query' may not hold sig, exp, access_token or tenant.")
+		return
+	}
+	if err := api.Config.Limits.checkQuery(q); err != nil {
+		respondLimit(c, err)
+		return
+	}
+	ttl := defaultSignedURLTTL
+	if req.ExpiresIn != 0 {
+		if req.ExpiresIn < 0 || req.ExpiresIn > int64(maxSignedURLTTL.Seconds()) {
+			respondError(c, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("'expires_in' must be between 1 and %d seconds.", int64(maxSignedURLTTL.Seconds())))
+			return
+		}
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	id := c.Param("id")
+	expires := time.Now().Add(ttl).Unix()
+	q.Set("exp", strconv.FormatInt(expires, 10))
+	if tenant := tenantFrom(c.Request.Context()); tenant != "" {
+		q.Set(tenantParam, tenant)
+	}
+	q.Set("sig", signImageQuery(keys[0], id, q))
+	path := strings.TrimSuffix(c.Request.URL.Path, "/signed-url")
+	c.IndentedJSON(http.StatusOK, SignedURLResponse{URL: path + "?" + q.Encode(), Expires: expires})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifySignedImage(t *testing.T) {
+	oldKey, newKey := strings.Repeat("o", 32), strings.Repeat("n", 32)
+	now := time.Unix(1791936000, 0)
+	signed := func(key, id, query string) url.Values {
+		q, _ := url.ParseQuery(query)
+		q.Set("sig", signImageQuery(key, id, q))
+		return q
+	}
+	valid := "width=256&format=webp&exp=" + strconv.FormatInt(now.Unix()+60, 10)
+	tampered := signed(newKey, "i1", valid)
+	tampered.Set("width", "4096")
+
+	tests := []struct {
+		name string
+		id   string
+		q    url.Values
+		want ErrorCode
+	}{
+		{"valid", "i1", signed(newKey, "i1", valid), ""},
+		{"old key", "i1", signed(oldKey, "i1", valid), ""},
+		{"unknown key", "i1", signed(strings.Repeat("x", 32), "i1", valid), CodeInvalidSignature},
+		{"other image", "i2", signed(newKey, "i1", valid), CodeInvalidSignature},
+		{"changed parameter", "i1", tampered, CodeInvalidSignature},
+		{"expired", "i1", signed(newKey, "i1", "exp="+strconv.FormatInt(now.Unix(), 10)), CodeSignatureExpired},
+		{"no expiry", "i1", signed(newKey, "i1", "width=256"), CodeInvalidSignature},
+		{"garbled", "i1", url.Values{"exp": {"1"}, "sig": {"!!"}}, CodeInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignedImage([]string{newKey, oldKey}, tt.id, tt.q, now)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if p := problemFor(err); err == nil || p.Code != tt.want || p.Status != http.StatusForbidden {
+				t.Errorf("err = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedURLRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &Config{
+		AdminToken: "secret", AuthRequired: true, URLSigningKeys: []string{strings.Repeat("k", 32)},
+		Limits: RequestLimits{MaxDimension: 8192, MaxCropPixels: 1 << 20, MaxOps: 32},
+	}
+	api := &API{Config: cfg}
+	router := gin.New()
+	group := router.Group(apiV1Prefix)
+	group.GET("/image/:id", api.requireSignedOr(api.require(ScopeImagesRead)), func(c *gin.Context) {
+		c.String(http.StatusOK, c.Query("width"))
+	})
+	group.POST("/image/:id/signed-url", api.require(ScopeImagesRead), api.postSignedURL)
+	do := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/v1/image/i1/signed-url", `{"query": "width=256", "expires_in": 600}`, "secret")
+	var signed SignedURLResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &signed) != nil || !strings.HasPrefix(signed.URL, "/api/v1/image/i1?") {
+		t.Fatalf("sign: %d %s", w.Code, w.Body)
+	}
+	if d := time.Until(time.Unix(signed.Expires, 0)); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("expires in %v, want 10m", d)
+	}
+	if w := do(http.MethodGet, signed.URL, "", ""); w.Code != http.StatusOK || w.Body.String() != "256" {
+		t.Errorf("signed GET: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, strings.Replace(signed.URL, "width=256", "width=512", 1), "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("altered GET: status = %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/v1/image/i1?width=256", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned GET: status = %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/image/i1/signed-url", "", "secret"); w.Code != http.StatusOK {
+		t.Errorf("sign without a body: %d %s", w.Code, w.Body)
+	}
+	for _, body := range []string{`{"query": "exp=1"}`, `{"expires_in": 604801}`, `{"query": "width=100000"}`} {
+		if w := do(http.MethodPost, "/api/v1/image/i1/signed-url", body, "secret"); w.Code != http.StatusBadRequest {
+			t.Errorf("sign %s: status = %d", body, w.Code)
+		}
+	}
+	if w := do(http.MethodPost, "/api/v1/image/i1/signed-url", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("sign anonymously: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"math/bits"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	imageHashRefresh = 5 * time.Minute
+	// The perceptual hash is the sign of the lowest phashFrequencies²
+	// DCT frequencies of the frame shrunk to phashSide pixels a side.
+	phashSide        = 32
+	phashFrequencies = 8
+	// GET /images/similar/:id answers with up to ?limit= images differing
+	// in at most ?maxDistance= of the hash's 64 bits.
+	defaultSimilarLimit    = 20
+	maxSimilarLimit        = 100
+	defaultSimilarDistance = 10
+	maxSimilarDistance     = 32
+)
+
+// phashCos[u][x] is the DCT-II basis cos((2x+1)uπ / 2·phashSide).
+var phashCos = func() (c [phashFrequencies][phashSide]float64) {
+	for u := range phashFrequencies {
+		for x := range phashSide {
+			c[u][x] = math.Cos(float64((2*x+1)*u) * math.Pi / (2 * phashSide))
+		}
+	}
+	return c
+}()
+
+// perceptualHash is the 64-bit pHash of src: each bit is set when a low
+// frequency of its brightness is above the median of the others, so
+// resampling, recompression and small changes of exposure keep the hash.
+func perceptualHash(src image.Image) uint64 {
+	small := imaging.Resize(imaging.Grayscale(src), phashSide, phashSide, imaging.Box)
+	var coef [phashFrequencies * phashFrequencies]float64
+	for v := range phashFrequencies {
+		for u := range phashFrequencies {
+			var sum float64
+			for y := range phashSide {
+				row := small.Pix[y*small.Stride:]
+				var line float64
+				for x := range phashSide {
+					line += float64(row[4*x]) * phashCos[u][x]
+				}
+				sum += line * phashCos[v][y]
+			}
+			coef[v*phashFrequencies+u] = sum
+		}
+	}
+	// The DC term, the mean brightness, is left out of the median.
+	sorted := slices.Clone(coef[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+	var hash uint64
+	for i, c := range coef {
+		if c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+func formatHash(h uint64) string { return fmt.Sprintf("%016x", h) }
+
+// recordHash stores the image's perceptual hash on its record and in the
+// index searched for similar images.
+func (api *API) recordHash(ctx context.Context, id string, h uint64) error {
+	if err := api.Hashes.Put(ctx, tenantRecordID(ctx, id), h); err != nil {
+		return err
+	}
+	return api.Images.SetImageAttribute(ctx, id, "phash", formatHash(h))
+}
+
+// ImageHash is an image's perceptual hash and checksum in
+// IMAGE_HASHES_TABLE. Either may be missing.
+type ImageHash struct {
+	// ID is the image's IMAGE_TABLE key, and so carries the tenant with
+	// MULTI_TENANT.
+	ID     string `dynamodbav:"id"`
+	PHash  string `dynamodbav:"phash,omitempty"`
+	SHA256 string `dynamodbav:"sha256,omitempty"`
+}
+
+// ImageHashIndex holds every image's perceptual hash and the SHA-256 of its
+// upload in memory, to be searched for near and exact copies. When
+// IMAGE_HASHES_TABLE is set they are saved to DynamoDB and shared by every
+// instance; without it they live only in this process. A nil index holds
+// nothing.
+type ImageHashIndex struct {
+	mu     sync.RWMutex
+	hashes map[string]uint64
+	sums   map[string]string
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newImageHashIndex(db *dynamodb.Client, table string) *ImageHashIndex {
+	s := &ImageHashIndex{hashes: make(map[string]uint64), sums: make(map[string]string), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the hashes and rereads them until ctx is done.
+func (s *ImageHashIndex) Start(ctx context.Context) {
+	if s == nil || s.db == nil {
+		return
+	}
+	if err := s.refresh(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to load image hashes", "err", err)
+	}
+	go func() {
+		ticker := time.NewTicker(imageHashRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to reload image hashes", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Put stores the hash of the image keyed id.
+func (s *ImageHashIndex) Put(ctx context.Context, id string, hash uint64) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.set(ctx, id, "phash", formatHash(hash)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.hashes[id] = hash
+	s.mu.Unlock()
+	return nil
+}
+
+// PutChecksum stores the SHA-256 of the upload of the image keyed id.
+func (s *ImageHashIndex) PutChecksum(ctx context.Context, id, sum string) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.set(ctx, id, "sha256", sum); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.sums[id] = sum
+	s.mu.Unlock()
+	return nil
+}
+
+// set updates one attribute of the image's item, keeping the other.
+func (s *ImageHashIndex) set(ctx context.Context, id, name, value string) error {
+	if s.db == nil {
+		return nil
+	}
+	_, err := s.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String("SET #a = :v"),
+		ExpressionAttributeNames:  map[string]string{"#a": name},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":v": &types.AttributeValueMemberS{Value: value}},
+	})
+	return err
+}
+
+type hashMatch struct {
+	id       string
+	distance int
+}
+
+// Near returns the images keyed with prefix, other than self, whose hashes
+// differ from hash in at most maxDistance bits, nearest first, up to limit
+// of them. The keys are returned without the prefix.
+func (s *ImageHashIndex) Near(prefix, self string, hash uint64, maxDistance, limit int) []hashMatch {
+	if s == nil {
+		return nil
+	}
+	var matches []hashMatch
+	s.mu.RLock()
+	for key, h := range s.hashes {
+		id, ok := strings.CutPrefix(key, prefix)
+		if !ok || id == self || (prefix == "" && strings.Contains(id, "/")) {
+			continue
+		}
+		if d := bits.OnesCount64(h ^ hash); d <= maxDistance {
+			matches = append(matches, hashMatch{id, d})
+		}
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(matches, func(a, b hashMatch) int {
+		return cmp.Or(cmp.Compare(a.distance, b.distance), cmp.Compare(a.id, b.id))
+	})
+	return matches[:min(len(matches), limit)]
+}
+
+// Exact returns the first, by ID, of the images keyed with prefix, other than
+// self, uploaded with checksum sum, without the prefix.
+func (s *ImageHashIndex) Exact(prefix, self, sum string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	var first string
+	s.mu.RLock()
+	for key, v := range s.sums {
+		id, ok := strings.CutPrefix(key, prefix)
+		if !ok || id == self || v != sum || (prefix == "" && strings.Contains(id, "/")) {
+			continue
+		}
+		if first == "" || id < first {
+			first = id
+		}
+	}
+	s.mu.RUnlock()
+	return first, first != ""
+}
+
+// refresh replaces the hashes and checksums with IMAGE_HASHES_TABLE's.
+func (s *ImageHashIndex) refresh(ctx context.Context) error {
+	hashes := make(map[string]uint64)
+	sums := make(map[string]string)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []ImageHash
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if h, err := strconv.ParseUint(item.PHash, 16, 64); err == nil {
+				hashes[item.ID] = h
+			}
+			if item.SHA256 != "" {
+				sums[item.ID] = item.SHA256
+			}
+		}
+	}
+	s.mu.Lock()
+	s.hashes, s.sums = hashes, sums
+	s.mu.Unlock()
+	return nil
+}
+
+// SimilarImage is an image that looks like the one searched from.
+type SimilarImage struct {
+	ImageID string `json:"image_id"`
+	// MissionID is the mission it was linked to at ingest, if any.
+	MissionID   string `json:"mission_id,omitempty"`
+	CaptureTime int64  `json:"capture_time,omitempty"`
+	// Distance is the number of the 64 hash bits that differ, and
+	// Similarity is the share that agree.
+	Distance   int     `json:"distance"`
+	Similarity float64 `json:"similarity"`
+}
+
+// SimilarImagesResponse is the body of GET /images/similar/:id.
+type SimilarImagesResponse struct {
+	ImageID   string         `json:"image_id"`
+	PHash     string         `json:"phash"`
+	MissionID string         `json:"mission_id,omitempty"`
+	Images    []SimilarImage `json:"images"`
+}
+
+// imageMission and imageCaptureTime read what ingest recorded of an image.
+func imageMission(r *ImageRecord) string {
+	if r == nil || r.Ingest == nil {
+		return ""
+	}
+	return r.Ingest.MissionID
+}
+
+func imageCaptureTime(r *ImageRecord) int64 {
+	switch {
+	case r == nil:
+		return 0
+	case r.Geometry != nil:
+		return r.Geometry.CaptureTime
+	case r.Photometry != nil:
+		return r.Photometry.CaptureTime
+	}
+	return 0
+}
+
+// getSimilarImages lists the images, of any mission, whose perceptual
+// hashes are nearest the image's.
+func (api *API) getSimilarImages(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	limit := defaultSimilarLimit
+	if s := c.Query("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxSimilarLimit {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'limit' parameter. Must be an integer between 1 and %d.", maxSimilarLimit))
+			return
+		}
+		limit = v
+	}
+	maxDistance := defaultSimilarDistance
+	if s := c.Query("maxDistance"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 || v > maxSimilarDistance {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'maxDistance' parameter. Must be an integer between 0 and %d.", maxSimilarDistance))
+			return
+		}
+		maxDistance = v
+	}
+
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load image record")
+		return
+	}
+	var hash uint64
+	if record != nil && record.PHash != "" {
+		hash, err = strconv.ParseUint(record.PHash, 16, 64)
+	}
+	if record == nil || record.PHash == "" || err != nil {
+		respondError(c, http.StatusNotFound, CodeImageNotFound, "The image has no perceptual hash. Hashes are computed at ingest, or by POST /images/:id/derivatives.")
+		return
+	}
+
+	matches := api.Hashes.Near(tenantRecordID(ctx, ""), id, hash, maxDistance, limit)
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.id
+	}
+	records, err := api.Images.ImageRecords(ctx, ids)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image records", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+		return
+	}
+	resp := SimilarImagesResponse{ImageID: id, PHash: record.PHash, MissionID: imageMission(record), Images: []SimilarImage{}}
+	for _, m := range matches {
+		r := records[m.id]
+		resp.Images = append(resp.Images, SimilarImage{
+			ImageID:     m.id,
+			MissionID:   imageMission(r),
+			CaptureTime: imageCaptureTime(r),
+			Distance:    m.distance,
+			Similarity:  math.Round(1000*(1-float64(m.distance)/64)) / 1000,
+		})
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// spacecraft draws a bright body off the frame's centre with a panel at
+// angle a, in eighths of a turn, against black.
+func spacecraft(side, a int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for y := range side {
+		for x := range side {
+			dx, dy := x-side*2/5, y-side*9/20
+			body := dx*dx+dy*dy < side*side/64
+			var panel bool
+			switch a % 4 {
+			case 0:
+				panel = dy > -side/20 && dy < side/20 && dx > -side/3 && dx < side/3
+			case 1:
+				panel = dx-dy > -side/14 && dx-dy < side/14 && dx > -side/4 && dx < side/4
+			case 2:
+				panel = dx > -side/20 && dx < side/20 && dy > -side/3 && dy < side/3
+			default:
+				panel = dx+dy > -side/14 && dx+dy < side/14 && dx > -side/4 && dx < side/4
+			}
+			switch {
+			case body:
+				img.SetGray(x, y, color.Gray{230})
+			case panel:
+				img.SetGray(x, y, color.Gray{200})
+			}
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	ref := perceptualHash(spacecraft(512, 0))
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, spacecraft(512, 0), imaging.JPEG, imaging.JPEGQuality(40)); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := imaging.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		img     image.Image
+		nearest bool
+	}{
+		{"smaller", spacecraft(200, 0), true},
+		{"recompressed", recompressed, true},
+		{"brighter", imaging.AdjustBrightness(spacecraft(512, 0), 10), true},
+		{"panel turned", spacecraft(512, 2), false},
+		{"panel diagonal", spacecraft(512, 1), false},
+	}
+	for _, tt := range tests {
+		d := bits.OnesCount64(perceptualHash(tt.img) ^ ref)
+		if tt.nearest && d > 4 || !tt.nearest && d <= defaultSimilarDistance {
+			t.Errorf("%s: distance %d", tt.name, d)
+		}
+	}
+}
+
+func TestSimilarImages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	meta := testSQLStore(t)
+	api := &API{Images: meta, Hashes: newImageHashIndex(nil, "")}
+	for id, img := range map[string]image.Image{
+		"m1-00": spacecraft(512, 0),
+		"m2-00": spacecraft(256, 0),
+		"m2-01": spacecraft(512, 2),
+	} {
+		if err := api.recordHash(ctx, id, perceptualHash(img)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta.SetImageAttribute(ctx, "m2-00", "ingest", IngestRecord{Status: IngestIngested, MissionID: "m2"})
+	meta.SetImageAttribute(ctx, "m2-00", "geometry", ObservationGeometry{CaptureTime: 1700000000})
+	// Another tenant's copy of the frame is not theirs to find.
+	api.Hashes.Put(ctx, "acme/m1-00", perceptualHash(spacecraft(512, 0)))
+
+	router := gin.New()
+	router.GET("/images/similar/:id", api.getSimilarImages)
+	get := func(path string) (*httptest.ResponseRecorder, SimilarImagesResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp SimilarImagesResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := get("/images/similar/m1-00")
+	if w.Code != http.StatusOK || len(resp.Images) != 1 || resp.PHash == "" {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if got := resp.Images[0]; got.ImageID != "m2-00" || got.MissionID != "m2" || got.CaptureTime != 1700000000 || got.Similarity < 0.9 {
+		t.Errorf("match %+v", got)
+	}
+	if _, resp := get("/images/similar/m1-00?maxDistance=32&limit=1"); len(resp.Images) != 1 {
+		t.Errorf("limit: %+v", resp.Images)
+	}
+	for _, q := range []string{"maxDistance=64", "limit=0"} {
+		if w, _ := get("/images/similar/m1-00?" + q); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d", q, w.Code)
+		}
+	}
+	if w, _ := get("/images/similar/unhashed"); w.Code != http.StatusNotFound {
+		t.Errorf("unhashed: %d", w.Code)
+	}
+
+	tenantCtx := withTenant(ctx, "acme")
+	if got := api.Hashes.Near(tenantRecordID(tenantCtx, ""), "other", perceptualHash(spacecraft(512, 0)), 0, 10); len(got) != 1 || got[0].id != "m1-00" {
+		t.Errorf("tenant matches %+v", got)
+	}
+}
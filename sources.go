@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// sourceExtensions are the extensions an original may be uploaded under,
+// in the order they are probed for. Handlers name every original by the
+// first, as imageKey does.
+var sourceExtensions = []string{".jpg", ".png", ".tif", ".tiff", ".webp", ".fits"}
+
+// sourceContentTypes are the content types of originals by extension.
+var sourceContentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".webp": "image/webp",
+	".fits": "application/fits",
+}
+
+// sourceKey is where image id is uploaded under ext.
+func sourceKey(id, ext string) string {
+	return "images/" + id + ext
+}
+
+// uploadLocation is the location of an upload of image id to key, or nil
+// for images/<id>.jpg.
+func (s *layoutStore) uploadLocation(id, key string) *ObjectLocation {
+	if key == imageKey(id) {
+		return nil
+	}
+	return &ObjectLocation{Bucket: s.bucket, Key: key, ContentType: sourceContentTypes[path.Ext(key)]}
+}
+
+// originalKey is where image id's original was uploaded, under whichever
+// extension.
+func (api *API) originalKey(ctx context.Context, id string) string {
+	bucketName := api.Config.ImagesBucket
+	if api.Layout != nil {
+		if loc, _ := api.Layout.location(ctx, bucketName, imageKey(id), false); loc != nil && loc.isUpload(bucketName) {
+			return loc.Key
+		}
+	}
+	return imageKey(id)
+}
+
+// probe looks for the original named by bucket and key under the other
+// extensions, for one that was not recorded where it was uploaded, and
+// returns the first found, or nil.
+func (s *layoutStore) probe(ctx context.Context, bucket, key string) *ObjectLocation {
+	id, ok := imageIDFromKey(key)
+	if bucket != s.bucket || !ok || key != imageKey(id) {
+		return nil
+	}
+	upload := s.findUpload(ctx, id)
+	if upload == "" || upload == key {
+		return nil
+	}
+	loc := s.uploadLocation(id, upload)
+	s.remember(ctx, id, loc)
+	return loc
+}
+
+// findUpload returns the key of the first upload of image id found under
+// the source extensions, or "".
+func (s *layoutStore) findUpload(ctx context.Context, id string) string {
+	for _, ext := range sourceExtensions {
+		key := sourceKey(id, ext)
+		_, err := s.ObjectStore.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+		if err == nil {
+			return key
+		}
+	}
+	return ""
+}
+
+// sourceIDFromKey returns the image ID and extension of an upload key.
+func sourceIDFromKey(key string) (id, ext string, ok bool) {
+	name, ok := strings.CutPrefix(key, "images/")
+	if !ok || strings.Contains(name, "/") {
+		return "", "", false
+	}
+	for _, ext := range sourceExtensions {
+		if id, ok := strings.CutSuffix(name, ext); ok && id != "" {
+			return id, ext, true
+		}
+	}
+	return "", "", false
+}
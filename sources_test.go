@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSourceIDFromKey(t *testing.T) {
+	for key, want := range map[string]string{
+		"images/m31.jpg":      "m31",
+		"images/m31.v2.png":   "m31.v2",
+		"images/m31.tiff":     "m31",
+		"images/m31.fits":     "m31",
+		"images/m31.gif":      "",
+		"images/.png":         "",
+		"images/raw/m31.tif":  "",
+		"thumbnails/m31.webp": "",
+	} {
+		if id, _, ok := sourceIDFromKey(key); id != want || ok != (want != "") {
+			t.Errorf("sourceIDFromKey(%q) = %q, %v", key, id, ok)
+		}
+	}
+}
+
+func TestSourceExtensions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	raw, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := testSQLStore(t)
+	var frame bytes.Buffer
+	if err := png.Encode(&frame, image.NewGray(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+	jpeg, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := routeObjects(raw, meta, "sat", LayoutConfig{})
+	api := &API{
+		Config:    &Config{ImagesBucket: "sat", IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep},
+		MissionDB: meta,
+		Images:    meta,
+		S3:        layout,
+		Layout:    layout,
+		Limiter:   newProcessLimiter(0, defaultQueueTimeout),
+		Events:    newEventBus(),
+	}
+	put := func(key string, data []byte) {
+		t.Helper()
+		_, err := raw.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("sat"), Key: aws.String(key), Body: bytes.NewReader(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := gin.New()
+	router.GET("/image/:id", api.getSatImageByID)
+	serves := func(id string, want []byte, contentType string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/image/"+id, nil))
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) || w.Header().Get("Content-Type") != contentType {
+			t.Fatalf("GET /image/%s: %d, %d bytes of %s", id, w.Code, w.Body.Len(), w.Header().Get("Content-Type"))
+		}
+	}
+
+	put(sourceKey("m31", ".png"), frame.Bytes())
+	if err := api.ingestUpload(ctx, "m31", sourceKey("m31", ".png")); err != nil {
+		t.Fatal(err)
+	}
+	record, err := meta.ImageRecord(ctx, "m31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil || record.Ingest == nil || record.Ingest.Status != IngestIngested || record.Location == nil ||
+		*record.Location != (ObjectLocation{Bucket: "sat", Key: "images/m31.png", ContentType: "image/png"}) {
+		t.Fatalf("record %+v", record)
+	}
+	serves("m31", frame.Bytes(), "image/png")
+
+	// An image without a record is found by probing.
+	put(sourceKey("m42", ".png"), frame.Bytes())
+	serves("m42", frame.Bytes(), "image/png")
+
+	// Uploading again as a JPEG replaces the PNG.
+	put(imageKey("m31"), jpeg)
+	if err := api.ingestUpload(ctx, "m31", imageKey("m31")); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := meta.ImageRecord(ctx, "m31"); err != nil || record.Location != nil {
+		t.Fatalf("location %+v, %v", record.Location, err)
+	}
+	if _, err := raw.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("sat"), Key: aws.String(sourceKey("m31", ".png"))}); !isNotFound(err) {
+		t.Errorf("the replaced PNG was kept: %v", err)
+	}
+	serves("m31", jpeg, "")
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// sqlIdentifier is what MISSION_TABLE and IMAGE_TABLE may hold for the SQL
+// backends, since table names cannot be bound as parameters.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlStore keeps missions and image records in Postgres or SQLite. Each row
+// holds the item's JSON document beside its id, so writers store missions in
+// the same shape they would put in DynamoDB:
+//
+//	missions (id TEXT PRIMARY KEY, data JSONB NOT NULL)
+//	images   (id TEXT PRIMARY KEY, data JSONB NOT NULL, updated BIGINT NOT NULL)
+//
+// The tables are created on startup if they do not exist, named by
+// MISSION_TABLE and IMAGE_TABLE (default missions and images).
+type sqlStore struct {
+	db       *sql.DB
+	dialect  string
+	missions string
+	images   string
+}
+
+// openSQLStore connects to dsn and creates the tables, named missionTable
+// and imageTable or missions and images when those are empty.
+func openSQLStore(ctx context.Context, dialect, dsn, missionTable, imageTable string) (*sqlStore, error) {
+	if dsn == "" {
+		return nil, errors.New("DATABASE_URL is not set")
+	}
+	s := &sqlStore{dialect: dialect, missions: "missions", images: "images"}
+	if missionTable != "" {
+		s.missions = missionTable
+	}
+	if imageTable != "" {
+		s.images = imageTable
+	}
+	for _, name := range []string{s.missions, s.images} {
+		if !sqlIdentifier.MatchString(name) {
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
+	}
+
+	driver, jsonType := "pgx", "JSONB"
+	if dialect == "sqlite" {
+		driver, jsonType = "sqlite", "TEXT"
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == "sqlite" {
+		// SQLite allows one writer at a time; a single connection queues
+		// them here instead of failing with SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+	s.db = db
+
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS " + s.missions + " (id TEXT PRIMARY KEY, data " + jsonType + " NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS " + s.images + " (id TEXT PRIMARY KEY, data " + jsonType + " NOT NULL, updated BIGINT NOT NULL DEFAULT 0)",
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *sqlStore) Mission(ctx context.Context, id string) (*Mission, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM "+s.missions+" WHERE id = $1", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMissionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mission Mission
+	if err := json.Unmarshal(data, &mission); err != nil {
+		return nil, fmt.Errorf("unmarshal mission %s: %w", id, err)
+	}
+	mission.ID = id
+	return &mission, nil
+}
+
+// Missions pages in id order. The token is the last id of the previous page,
+// base64 encoded.
+func (s *sqlStore) Missions(ctx context.Context, limit int32, token string) ([]Mission, string, error) {
+	var after string
+	if token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil || len(decoded) == 0 {
+			return nil, "", errInvalidMissionToken
+		}
+		after = string(decoded)
+	}
+
+	// One row past the page tells whether there is another.
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, data FROM "+s.missions+" WHERE id > $1 ORDER BY id LIMIT $2", after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var missions []Mission
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, "", err
+		}
+		var mission Mission
+		if err := json.Unmarshal(data, &mission); err != nil {
+			return nil, "", fmt.Errorf("unmarshal mission %s: %w", id, err)
+		}
+		mission.ID = id
+		missions = append(missions, mission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(missions) <= int(limit) {
+		return missions, "", nil
+	}
+	missions = missions[:limit]
+	return missions, base64.StdEncoding.EncodeToString([]byte(missions[limit-1].ID)), nil
+}
+
+func scanImageRecord(id string, data []byte, updated int64) (*ImageRecord, error) {
+	var record ImageRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("unmarshal image record %s: %w", id, err)
+	}
+	record.ID, record.Updated = id, updated
+	return &record, nil
+}
+
+// updateMission rewrites the mission's document in a transaction, keeping
+// the fields Mission does not know about, and locks the row on Postgres so
+// concurrent updates do not lose one another. SQLite has a single
+// connection, which serializes them already. change edits doc, the document
+// as read, and reports false to leave the mission alone.
+func (s *sqlStore) updateMission(ctx context.Context, id string, change func(doc map[string]json.RawMessage, mission *Mission) bool) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	query := "SELECT data FROM " + s.missions + " WHERE id = $1"
+	if s.dialect != "sqlite" {
+		query += " FOR UPDATE"
+	}
+	var data []byte
+	err = tx.QueryRowContext(ctx, query, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errMissionNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	var doc map[string]json.RawMessage
+	var mission Mission
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return false, fmt.Errorf("unmarshal mission %s: %w", id, err)
+	}
+	if err := json.Unmarshal(data, &mission); err != nil {
+		return false, fmt.Errorf("unmarshal mission %s: %w", id, err)
+	}
+	if !change(doc, &mission) {
+		return false, nil
+	}
+	doc["updated_at"] = json.RawMessage(strconv.FormatInt(time.Now().Unix(), 10))
+	if data, err = json.Marshal(doc); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE "+s.missions+" SET data = $1 WHERE id = $2", string(data), id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *sqlStore) LinkImage(ctx context.Context, missionID, imageID string) (bool, error) {
+	return s.updateMission(ctx, missionID, func(doc map[string]json.RawMessage, mission *Mission) bool {
+		if slices.Contains(mission.ImageIDs, imageID) {
+			return false
+		}
+		doc["image_ids"], _ = json.Marshal(append(mission.ImageIDs, imageID))
+		return true
+	})
+}
+
+func (s *sqlStore) UnlinkImage(ctx context.Context, missionID, imageID string) (bool, error) {
+	return s.updateMission(ctx, missionID, func(doc map[string]json.RawMessage, mission *Mission) bool {
+		i := slices.Index(mission.ImageIDs, imageID)
+		if i < 0 {
+			return false
+		}
+		doc["image_ids"], _ = json.Marshal(slices.Delete(mission.ImageIDs, i, i+1))
+		return true
+	})
+}
+
+func (s *sqlStore) SetGeometry(ctx context.Context, id string, g MissionGeometry) error {
+	_, err := s.updateMission(ctx, id, func(doc map[string]json.RawMessage, _ *Mission) bool {
+		doc["tca"], _ = json.Marshal(g.TCA)
+		doc["min_range_km"], _ = json.Marshal(g.MinRangeKM)
+		doc["relative_velocity_kms"], _ = json.Marshal(g.RelativeVelocityKMS)
+		doc["geometry_updated_at"] = json.RawMessage(strconv.FormatInt(time.Now().Unix(), 10))
+		if g.Feasibility != nil {
+			doc["feasibility"], _ = json.Marshal(g.Feasibility)
+		}
+		return true
+	})
+	return err
+}
+
+func (s *sqlStore) SetSchedule(ctx context.Context, id string, schedule *ScheduleAssignment) error {
+	_, err := s.updateMission(ctx, id, func(doc map[string]json.RawMessage, _ *Mission) bool {
+		if schedule == nil {
+			delete(doc, "schedule")
+		} else {
+			doc["schedule"], _ = json.Marshal(schedule)
+		}
+		return true
+	})
+	return err
+}
+
+func (s *sqlStore) SetStatus(ctx context.Context, id, status string, allowed func(current string) bool) error {
+	var current string
+	changed, err := s.updateMission(ctx, id, func(doc map[string]json.RawMessage, mission *Mission) bool {
+		current = mission.Status
+		if !allowed(current) {
+			return false
+		}
+		doc["status"], _ = json.Marshal(status)
+		return true
+	})
+	if err == nil && !changed {
+		return fmt.Errorf("%w: the mission is %q", errMissionStatus, current)
+	}
+	return err
+}
+
+func (s *sqlStore) ImageRecord(ctx context.Context, id string) (*ImageRecord, error) {
+	var data []byte
+	var updated int64
+	err := s.db.QueryRowContext(ctx, "SELECT data, updated FROM "+s.images+" WHERE id = $1", id).Scan(&data, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return scanImageRecord(id, data, updated)
+}
+
+// ImageRecords reads the records batchGetLimit ids at a time.
+func (s *sqlStore) ImageRecords(ctx context.Context, ids []string) (map[string]*ImageRecord, error) {
+	records := make(map[string]*ImageRecord, len(ids))
+	for start := 0; start < len(ids); start += batchGetLimit {
+		chunk := ids[start:min(start+batchGetLimit, len(ids))]
+		params := make([]string, len(chunk))
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = id
+		}
+		rows, err := s.db.QueryContext(ctx,
+			"SELECT id, data, updated FROM "+s.images+" WHERE id IN ("+strings.Join(params, ", ")+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			var data []byte
+			var updated int64
+			if err := rows.Scan(&id, &data, &updated); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			record, err := scanImageRecord(id, data, updated)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			records[id] = record
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// ListImageRecords pages in id order, with tokens as Missions has.
+func (s *sqlStore) ListImageRecords(ctx context.Context, limit int32, token string) ([]ImageRecord, string, error) {
+	var after string
+	if token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil || len(decoded) == 0 {
+			return nil, "", errInvalidImageToken
+		}
+		after = string(decoded)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, data, updated FROM "+s.images+" WHERE id > $1 ORDER BY id LIMIT $2", after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var records []ImageRecord
+	for rows.Next() {
+		var id string
+		var data []byte
+		var updated int64
+		if err := rows.Scan(&id, &data, &updated); err != nil {
+			return nil, "", err
+		}
+		record, err := scanImageRecord(id, data, updated)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, *record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(records) <= int(limit) {
+		return records, "", nil
+	}
+	records = records[:limit]
+	return records, base64.StdEncoding.EncodeToString([]byte(records[limit-1].ID)), nil
+}
+
+// SetImageAttribute replaces one top-level key of the record's document in a
+// single upsert, so concurrent ingest steps never lose one another's writes.
+func (s *sqlStore) SetImageAttribute(ctx context.Context, id, name string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", name, err)
+	}
+	query := "INSERT INTO " + s.images + " (id, data, updated) VALUES ($1, jsonb_build_object($2::text, $3::jsonb), $4) " +
+		"ON CONFLICT (id) DO UPDATE SET data = " + s.images + ".data || excluded.data, updated = excluded.updated"
+	args := []any{id, name, string(data), time.Now().Unix()}
+	if s.dialect == "sqlite" {
+		query = "INSERT INTO " + s.images + " (id, data, updated) VALUES ($1, json_object($2, json($3)), $4) " +
+			"ON CONFLICT (id) DO UPDATE SET data = json_set(" + s.images + ".data, $5, json($3)), updated = excluded.updated"
+		args = append(args, `$."`+name+`"`)
+	}
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testSQLStore(t *testing.T) *sqlStore {
+	t.Helper()
+	s, err := openSQLStore(context.Background(), "sqlite", filepath.Join(t.TempDir(), "meta.db"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func TestSQLStoreMissions(t *testing.T) {
+	s := testSQLStore(t)
+	ctx := context.Background()
+	for i := range 5 {
+		m := Mission{Name: fmt.Sprintf("mission %d", i), ImageIDs: []string{"a", "b"}, UpdatedAt: int64(i)}
+		data, _ := json.Marshal(m)
+		if _, err := s.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", fmt.Sprintf("m%d", i), string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := s.Mission(ctx, "m3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != "m3" || m.Name != "mission 3" || len(m.ImageIDs) != 2 {
+		t.Errorf("Mission(m3) = %+v", m)
+	}
+	if _, err := s.Mission(ctx, "none"); !errors.Is(err, errMissionNotFound) {
+		t.Errorf("Mission(none) error = %v", err)
+	}
+
+	var ids []string
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not end")
+		}
+		page, next, err := s.Missions(ctx, 2, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range page {
+			ids = append(ids, m.ID)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if fmt.Sprint(ids) != "[m0 m1 m2 m3 m4]" {
+		t.Errorf("paged through %v", ids)
+	}
+	if _, _, err := s.Missions(ctx, 2, "not base64!"); !errors.Is(err, errInvalidMissionToken) {
+		t.Errorf("bad token error = %v", err)
+	}
+}
+
+func TestSQLStoreImageAttributes(t *testing.T) {
+	s := testSQLStore(t)
+	ctx := context.Background()
+
+	if r, err := s.ImageRecord(ctx, "img1"); r != nil || err != nil {
+		t.Fatalf("ImageRecord before any write = %v, %v", r, err)
+	}
+	if err := s.SetImageAttribute(ctx, "img1", "exif", map[string]string{"Make": "Sat"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetImageAttribute(ctx, "img1", "quality", QualityMetrics{Score: 42}); err != nil {
+		t.Fatal(err)
+	}
+	// Replacing an attribute replaces it whole rather than merging into it.
+	if err := s.SetImageAttribute(ctx, "img1", "exif", map[string]string{"Model": "X"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetImageAttribute(ctx, "img2", "quality", QualityMetrics{Score: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.ImageRecord(ctx, "img1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != "img1" || r.Quality == nil || r.Quality.Score != 42 || len(r.EXIF) != 1 || r.EXIF["Model"] != "X" || r.Updated == 0 {
+		t.Errorf("ImageRecord(img1) = %+v", r)
+	}
+
+	records, err := s.ImageRecords(ctx, []string{"img1", "img2", "img3", "img1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records["img2"].Quality.Score != 7 {
+		t.Errorf("ImageRecords = %v", records)
+	}
+
+	page, next, err := s.ListImageRecords(ctx, 1, "")
+	if err != nil || len(page) != 1 || page[0].ID != "img1" || next == "" {
+		t.Fatalf("ListImageRecords first page = %v, %q, %v", page, next, err)
+	}
+	page, next, err = s.ListImageRecords(ctx, 1, next)
+	if err != nil || len(page) != 1 || page[0].ID != "img2" || page[0].Quality.Score != 7 || next != "" {
+		t.Errorf("ListImageRecords last page = %v, %q, %v", page, next, err)
+	}
+	if _, _, err := s.ListImageRecords(ctx, 1, "not base64!"); !errors.Is(err, errInvalidImageToken) {
+		t.Errorf("bad token error = %v", err)
+	}
+}
+
+func TestSQLStoreTableNames(t *testing.T) {
+	if _, err := openSQLStore(context.Background(), "sqlite", filepath.Join(t.TempDir(), "meta.db"), "missions; DROP TABLE x", ""); err == nil {
+		t.Error("unsafe table name accepted")
+	}
+}
+
+func TestSQLStoreLinkImage(t *testing.T) {
+	s := testSQLStore(t)
+	ctx := context.Background()
+	// Fields Mission does not know about are kept.
+	if _, err := s.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", "m1", `{"name": "m1", "image_ids": null, "owner": "ops"}`); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		linked, err := s.LinkImage(ctx, "m1", "i1")
+		if err != nil || linked != want {
+			t.Errorf("LinkImage #%d = %v, %v, want %v", i, linked, err, want)
+		}
+	}
+	var data string
+	if err := s.db.QueryRow("SELECT data FROM missions WHERE id = $1", "m1").Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains([]byte(data), []byte(`"owner":"ops"`)) || !bytes.Contains([]byte(data), []byte(`"image_ids":["i1"]`)) {
+		t.Errorf("data = %s", data)
+	}
+	if _, err := s.LinkImage(ctx, "none", "i1"); !errors.Is(err, errMissionNotFound) {
+		t.Errorf("LinkImage(none) error = %v", err)
+	}
+
+	for i, want := range []bool{true, false} {
+		unlinked, err := s.UnlinkImage(ctx, "m1", "i1")
+		if err != nil || unlinked != want {
+			t.Errorf("UnlinkImage #%d = %v, %v, want %v", i, unlinked, err, want)
+		}
+	}
+	if m, err := s.Mission(ctx, "m1"); err != nil || len(m.ImageIDs) != 0 {
+		t.Errorf("Mission after UnlinkImage = %+v, %v", m, err)
+	}
+}
+
+func TestSQLStoreSetGeometry(t *testing.T) {
+	s := testSQLStore(t)
+	ctx := context.Background()
+	if _, err := s.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", "m1", `{"name": "m1", "tca": 5, "owner": "ops"}`); err != nil {
+		t.Fatal(err)
+	}
+	feasibility := &Feasibility{Score: 0.5, Feasible: true, PhaseAngleDeg: 60, TargetSunlit: true}
+	if err := s.SetGeometry(ctx, "m1", MissionGeometry{TCA: 1000, MinRangeKM: 12.5, RelativeVelocityKMS: 0.25, Feasibility: feasibility}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.Mission(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TCA != 1000 || m.MinRangeKM != 12.5 || m.RelativeVelocityKMS != 0.25 || m.GeometryUpdatedAt == 0 || m.UpdatedAt == 0 || m.Name != "m1" ||
+		!reflect.DeepEqual(m.Feasibility, feasibility) {
+		t.Errorf("Mission(m1) = %+v", m)
+	}
+	if err := s.SetGeometry(ctx, "none", MissionGeometry{}); !errors.Is(err, errMissionNotFound) {
+		t.Errorf("SetGeometry(none) error = %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sseBuffer            = 64
+	sseHeartbeatInterval = 15 * time.Second
+	// sseRetry is the reconnection delay suggested to EventSource clients.
+	sseRetry = 5 * time.Second
+)
+
+// eventFilter is what one event stream asked for. With no missions or
+// satellites every event passes. Image events name only the image, so they
+// pass when the image belongs to a mission the filter has matched: one
+// listed in ?mission= or, with the mission index, of a listed satellite,
+// both looked up when the stream opens, or one a mission event on the
+// stream showed to involve a listed satellite.
+type eventFilter struct {
+	types      map[string]bool
+	missions   map[string]bool
+	satellites map[string]bool
+	images     map[string]bool
+}
+
+func (f *eventFilter) match(e Event) bool {
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	if len(f.missions) == 0 && len(f.satellites) == 0 {
+		return true
+	}
+	switch {
+	case e.Mission != nil:
+		m := e.Mission
+		if !f.missions[m.ID] && !f.satellites[m.TargetSatelliteID] && !f.satellites[m.ObserverSatelliteID] {
+			return false
+		}
+		for _, id := range m.ImageIDs {
+			f.images[id] = true
+		}
+		return true
+	case e.MissionID != "":
+		return f.missions[e.MissionID] || f.images[e.ImageID]
+	case e.ImageID != "":
+		return f.images[e.ImageID]
+	}
+	return false
+}
+
+// listParam is a query parameter given repeatedly, comma-separated, or both.
+func listParam(c *gin.Context, name string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range c.QueryArray(name) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				set[item] = true
+			}
+		}
+	}
+	return set
+}
+
+// getMissionEvents streams events as Server-Sent Events, for clients that
+// cannot use /ws. ?type=, ?mission= and ?satellite= narrow the stream. A
+// client reconnecting with Last-Event-ID gets the events it missed, or a
+// "reset" event when they are no longer retained and it should refetch.
+func (api *API) getMissionEvents(c *gin.Context) {
+	filter := &eventFilter{
+		types:      listParam(c, "type"),
+		missions:   listParam(c, "mission"),
+		satellites: listParam(c, "satellite"),
+		images:     map[string]bool{},
+	}
+	for t := range filter.types {
+		if !slices.Contains(eventTypes, t) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter,
+				fmt.Sprintf("Invalid 'type' parameter %q. Must be one of %s.", t, strings.Join(eventTypes, ", ")))
+			return
+		}
+	}
+	var lastID uint64
+	resuming := false
+	if v := c.GetHeader("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid Last-Event-ID header. Must be an event id.")
+			return
+		}
+		lastID, resuming = id, true
+	}
+
+	ctx := c.Request.Context()
+	for id := range filter.missions {
+		mission, err := api.loadMission(ctx, id)
+		if err != nil {
+			continue
+		}
+		for _, imageID := range mission.ImageIDs {
+			filter.images[imageID] = true
+		}
+	}
+	for _, imageID := range api.Stream.Index().satelliteImages(tenantFrom(ctx), filter.satellites) {
+		filter.images[imageID] = true
+	}
+
+	var backlog []Event
+	var events <-chan Event
+	var cancel func()
+	complete := true
+	if resuming {
+		backlog, events, cancel, complete = api.Events.Resume(lastID, sseBuffer, tenantEvents(ctx, nil))
+	} else {
+		events, cancel = api.Events.Subscribe(sseBuffer, tenantEvents(ctx, nil))
+	}
+	defer cancel()
+	eventSubscribers.WithLabelValues("sse").Inc()
+	defer eventSubscribers.WithLabelValues("sse").Dec()
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Stops nginx and similar proxies from holding events back.
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetry.Milliseconds())
+	if !complete {
+		fmt.Fprint(c.Writer, "event: reset\ndata: {}\n\n")
+	}
+	send := func(e Event) error {
+		if !filter.match(e) {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		return err
+	}
+	for _, e := range backlog {
+		if send(e) != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				// Fell behind; the client reconnects with Last-Event-ID and
+				// catches up from the history.
+				return
+			}
+			if send(e) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readSSE reads events from an SSE stream until it has n, returning the
+// "event" names with the ids of those that had one.
+func readSSE(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	var got []string
+	var id, name string
+	for len(got) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("after %v: %v", got, err)
+		}
+		switch line = strings.TrimSuffix(line, "\n"); {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case line == "" && name != "":
+			got = append(got, name+"@"+id)
+			id, name = "", ""
+		}
+	}
+	return got
+}
+
+func TestMissionEventStream(t *testing.T) {
+	api := testGRPCAPI(t)
+	api.Events = newEventBus()
+	db := api.MissionDB.(*sqlStore)
+	data, _ := json.Marshal(Mission{ID: "m1", ImageIDs: []string{"img1"}})
+	if _, err := db.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", "m1", string(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(compressResponses())
+	api.routesV1(router.Group(apiV1Prefix), routeTimeout(0), routeTimeout(0))
+	srv := httptest.NewServer(router)
+	// Registered first, so it runs after the streams below are closed.
+	t.Cleanup(srv.Close)
+
+	open := func(query, lastID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/missions/events"+query, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	_, stream := open("?mission=m1&type=mission.updated,image.ingested", "")
+	// The stream is subscribed once the retry line arrives.
+	if line, _ := stream.ReadString('\n'); !strings.HasPrefix(line, "retry: ") {
+		t.Fatalf("first line %q", line)
+	}
+	first := api.Events.Publish(Event{Type: EventMissionUpdated, MissionID: "m2", Mission: &Mission{ID: "m2"}})
+	api.Events.Publish(Event{Type: EventImageIngested, ImageID: "other"})
+	api.Events.Publish(Event{Type: EventMissionDeleted, MissionID: "m1"})
+	updated := api.Events.Publish(Event{Type: EventMissionUpdated, MissionID: "m1", Mission: &Mission{ID: "m1"}})
+	ingested := api.Events.Publish(Event{Type: EventImageIngested, ImageID: "img1"})
+
+	got := readSSE(t, stream, 2)
+	want := []string{"mission.updated@" + strconv.FormatUint(updated.ID, 10), "image.ingested@" + strconv.FormatUint(ingested.ID, 10)}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// Resuming replays what came after the given id, filtered the same way.
+	_, resumed := open("?mission=m1", strconv.FormatUint(first.ID, 10))
+	got = readSSE(t, resumed, 3)
+	if !strings.HasPrefix(got[0], "mission.deleted@") || got[2] != want[1] {
+		t.Errorf("resumed events = %v", got)
+	}
+
+	// An id the server no longer has gets a reset first.
+	_, reset := open("", "1")
+	if got := readSSE(t, reset, 1); got[0] != "reset@" {
+		t.Errorf("stale resume = %v", got)
+	}
+
+	for _, q := range []string{"?type=mission.exploded", ""} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/missions/events"+q, nil)
+		if q == "" {
+			req.Header.Set("Last-Event-ID", "abc")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		cancel()
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: %v, %v", q, resp, err)
+		}
+	}
+}
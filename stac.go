@@ -0,0 +1,654 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The SpatioTemporal Asset Catalog served under /stac: the server is the
+// root catalog, each mission a collection and each of its images an item.
+const (
+	stacVersion      = "1.0.0"
+	stacCatalogID    = "sat-image-server"
+	defaultSTACLimit = 10
+	maxSTACLimit     = 1000
+	stacEOExtension  = "https://stac-extensions.github.io/eo/v1.1.0/schema.json"
+	geoJSONType      = "application/geo+json"
+)
+
+var stacConformance = []string{
+	"https://api.stacspec.org/v1.0.0/core",
+	"https://api.stacspec.org/v1.0.0/collections",
+	"https://api.stacspec.org/v1.0.0/ogcapi-features",
+	"https://api.stacspec.org/v1.0.0/item-search",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+}
+
+type STACLink struct {
+	Rel   string `json:"rel"`
+	Href  string `json:"href"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+	// Method and Body are how to follow a link to a POST search.
+	Method string      `json:"method,omitempty"`
+	Body   *stacSearch `json:"body,omitempty"`
+}
+
+// STACCatalog is the root of the catalog, GET /stac.
+type STACCatalog struct {
+	Type        string     `json:"type"`
+	STACVersion string     `json:"stac_version"`
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	ConformsTo  []string   `json:"conformsTo"`
+	Links       []STACLink `json:"links"`
+}
+
+type STACConformance struct {
+	ConformsTo []string `json:"conformsTo"`
+}
+
+// STACCollection is a mission.
+type STACCollection struct {
+	Type        string     `json:"type"`
+	STACVersion string     `json:"stac_version"`
+	ID          string     `json:"id"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description"`
+	License     string     `json:"license"`
+	Extent      STACExtent `json:"extent"`
+	// Summaries names the observer as the platform and the target.
+	Summaries map[string][]string `json:"summaries,omitempty"`
+	Links     []STACLink          `json:"links"`
+}
+
+type STACExtent struct {
+	Spatial  STACSpatialExtent  `json:"spatial"`
+	Temporal STACTemporalExtent `json:"temporal"`
+}
+
+type STACSpatialExtent struct {
+	BBox [][4]float64 `json:"bbox"`
+}
+
+type STACTemporalExtent struct {
+	// Interval holds one [start, end]; either is null when open.
+	Interval [][2]*time.Time `json:"interval"`
+}
+
+type STACCollections struct {
+	Collections []STACCollection `json:"collections"`
+	Links       []STACLink       `json:"links"`
+}
+
+// STACItem is an image, a GeoJSON Feature.
+type STACItem struct {
+	Type           string   `json:"type"`
+	STACVersion    string   `json:"stac_version"`
+	STACExtensions []string `json:"stac_extensions,omitempty"`
+	ID             string   `json:"id"`
+	Collection     string   `json:"collection"`
+	// Geometry is null, and BBox absent, for an image that cannot be
+	// placed on the ground.
+	Geometry   *GeoJSONGeometry     `json:"geometry"`
+	BBox       []float64            `json:"bbox,omitempty"`
+	Properties STACItemProperties   `json:"properties"`
+	Links      []STACLink           `json:"links"`
+	Assets     map[string]STACAsset `json:"assets"`
+}
+
+type STACItemProperties struct {
+	// Datetime is the capture time. When the image has none it is null,
+	// and StartDatetime and EndDatetime are the mission's collection
+	// window.
+	Datetime      *time.Time `json:"datetime"`
+	StartDatetime *time.Time `json:"start_datetime,omitempty"`
+	EndDatetime   *time.Time `json:"end_datetime,omitempty"`
+	Created       *time.Time `json:"created,omitempty"`
+	Platform      string     `json:"platform,omitempty"`
+	CloudCover    *float64   `json:"eo:cloud_cover,omitempty"`
+}
+
+type STACAsset struct {
+	Href  string   `json:"href"`
+	Type  string   `json:"type,omitempty"`
+	Title string   `json:"title,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// STACItemCollection is a page of items, a GeoJSON FeatureCollection.
+type STACItemCollection struct {
+	Type           string     `json:"type"`
+	Features       []STACItem `json:"features"`
+	NumberReturned int        `json:"numberReturned"`
+	Links          []STACLink `json:"links"`
+}
+
+// stacSearch is the body of POST /stac/search, and the query of GET.
+type stacSearch struct {
+	BBox        []float64 `json:"bbox,omitempty"`
+	Datetime    string    `json:"datetime,omitempty"`
+	Collections []string  `json:"collections,omitempty"`
+	IDs         []string  `json:"ids,omitempty"`
+	Limit       int       `json:"limit,omitempty"`
+	// Token is the opaque token of the next page.
+	Token string `json:"token,omitempty"`
+}
+
+// stacFilter is a validated stacSearch.
+type stacFilter struct {
+	bbox        *[4]float64
+	start, end  time.Time
+	ids         []string
+	limit, skip int
+}
+
+// searchFromQuery reads a stacSearch from GET parameters, whose lists are
+// comma-separated.
+func searchFromQuery(q url.Values) (stacSearch, error) {
+	s := stacSearch{Datetime: q.Get("datetime"), Token: q.Get("token")}
+	if v := q.Get("bbox"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			n, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil {
+				return s, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'bbox' parameter. Must be west,south,east,north in degrees.")
+			}
+			s.BBox = append(s.BBox, n)
+		}
+	}
+	if v := q.Get("collections"); v != "" {
+		s.Collections = strings.Split(v, ",")
+	}
+	if v := q.Get("ids"); v != "" {
+		s.IDs = strings.Split(v, ",")
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return s, newProblem(http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'limit' parameter. Must be an integer between 1 and %d.", maxSTACLimit))
+		}
+		s.Limit = n
+	}
+	return s, nil
+}
+
+// filter validates the search. A limit over maxSTACLimit is lowered to it.
+func (s stacSearch) filter() (stacFilter, error) {
+	f := stacFilter{ids: s.IDs, limit: cmp.Or(s.Limit, defaultSTACLimit)}
+	if f.limit < 1 {
+		return f, newProblem(http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid 'limit' parameter. Must be an integer between 1 and %d.", maxSTACLimit))
+	}
+	f.limit = min(f.limit, maxSTACLimit)
+	if s.BBox != nil {
+		// A 3D box is west, south, bottom, east, north, top.
+		b := s.BBox
+		if len(b) == 6 {
+			b = []float64{b[0], b[1], b[3], b[4]}
+		}
+		if len(b) != 4 || b[1] > b[3] || b[1] < -90 || b[3] > 90 || b[0] < -180 || b[0] > 180 || b[2] < -180 || b[2] > 180 {
+			return f, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'bbox' parameter. Must be west,south,east,north in degrees.")
+		}
+		f.bbox = &[4]float64{b[0], b[1], b[2], b[3]}
+	}
+	if s.Datetime != "" {
+		start, end, err := parseSTACDatetime(s.Datetime)
+		if err != nil {
+			return f, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid 'datetime' parameter. Must be an RFC 3339 time or an interval start/end, either end open as '..'.")
+		}
+		f.start, f.end = start, end
+	}
+	if s.Token != "" {
+		n, err := strconv.Atoi(s.Token)
+		if err != nil || n < 0 {
+			return f, newProblem(http.StatusBadRequest, CodeInvalidParameter, "Invalid pagination token")
+		}
+		f.skip = n
+	}
+	return f, nil
+}
+
+// parseSTACDatetime parses a time or a closed or half-open interval. An open
+// end is returned as the zero time.
+func parseSTACDatetime(s string) (time.Time, time.Time, error) {
+	parse := func(v string) (time.Time, error) {
+		if v == "" || v == ".." {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, v)
+	}
+	a, b, interval := strings.Cut(s, "/")
+	start, err := parse(a)
+	if err != nil {
+		return start, start, err
+	}
+	if !interval {
+		if start.IsZero() {
+			return start, start, errors.New("no time")
+		}
+		return start, start, nil
+	}
+	end, err := parse(b)
+	switch {
+	case err != nil:
+		return start, end, err
+	case start.IsZero() && end.IsZero():
+		return start, end, errors.New("both ends open")
+	case !start.IsZero() && !end.IsZero() && end.Before(start):
+		return start, end, errors.New("ends before it starts")
+	}
+	return start, end, nil
+}
+
+// matches reports whether the item passes the filter's bbox, datetime and
+// ids.
+func (f stacFilter) matches(item STACItem) bool {
+	if len(f.ids) > 0 && !slices.Contains(f.ids, item.ID) {
+		return false
+	}
+	if f.bbox != nil {
+		if item.BBox == nil {
+			return false
+		}
+		if !bboxIntersects(*f.bbox, [4]float64(item.BBox)) {
+			return false
+		}
+	}
+	if !f.start.IsZero() || !f.end.IsZero() {
+		p := item.Properties
+		from, to := p.StartDatetime, p.EndDatetime
+		if p.Datetime != nil {
+			from, to = p.Datetime, p.Datetime
+		}
+		if from == nil || to == nil {
+			return false
+		}
+		if !f.start.IsZero() && to.Before(f.start) || !f.end.IsZero() && from.After(f.end) {
+			return false
+		}
+	}
+	return true
+}
+
+// stacOrigin is the scheme and host the request was made to, as the
+// catalog's links are absolute.
+func stacOrigin(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if p := c.GetHeader("X-Forwarded-Proto"); p == "https" || p == "http" {
+		scheme = p
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// stacBase is the absolute URL of the request's API version: the origin
+// followed by /api/v1, or by nothing for the unversioned routes.
+func stacBase(c *gin.Context) string {
+	prefix, _, _ := strings.Cut(c.Request.URL.Path, "/stac")
+	return stacOrigin(c) + prefix
+}
+
+func unixTime(sec int64) *time.Time {
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0).UTC()
+	return &t
+}
+
+func stacCollection(base string, m *Mission) STACCollection {
+	self := base + "/stac/collections/" + url.PathEscape(m.ID)
+	description := "Images of mission " + m.ID + "."
+	if m.TargetSatelliteID != "" && m.ObserverSatelliteID != "" {
+		description = fmt.Sprintf("Images of %s taken by %s.", m.TargetSatelliteID, m.ObserverSatelliteID)
+	}
+	col := STACCollection{
+		Type:        "Collection",
+		STACVersion: stacVersion,
+		ID:          m.ID,
+		Title:       m.Name,
+		Description: description,
+		License:     "proprietary",
+		Extent: STACExtent{
+			Spatial:  STACSpatialExtent{BBox: [][4]float64{{-180, -90, 180, 90}}},
+			Temporal: STACTemporalExtent{Interval: [][2]*time.Time{{unixTime(m.CollectionWindowStart), unixTime(m.CollectionWindowEnd)}}},
+		},
+		Links: []STACLink{
+			{Rel: "self", Href: self, Type: "application/json"},
+			{Rel: "root", Href: base + "/stac", Type: "application/json"},
+			{Rel: "parent", Href: base + "/stac", Type: "application/json"},
+			{Rel: "items", Href: self + "/items", Type: geoJSONType},
+		},
+	}
+	if m.ObserverSatelliteID != "" || m.TargetSatelliteID != "" {
+		col.Summaries = map[string][]string{}
+		if m.ObserverSatelliteID != "" {
+			col.Summaries["platform"] = []string{m.ObserverSatelliteID}
+		}
+		if m.TargetSatelliteID != "" {
+			col.Summaries["target"] = []string{m.TargetSatelliteID}
+		}
+	}
+	return col
+}
+
+// stacItem describes the image id of mission m. Its geometry is the
+// footprint of its GeoTIFF corners or, failing those, the position in its
+// EXIF GPS tags or below the observer at capture.
+func (api *API) stacItem(ctx context.Context, base string, m *Mission, id string, r *ImageRecord) STACItem {
+	collection := base + "/stac/collections/" + url.PathEscape(m.ID)
+	image := base + "/image/" + url.PathEscape(id)
+	item := STACItem{
+		Type:        "Feature",
+		STACVersion: stacVersion,
+		ID:          id,
+		Collection:  m.ID,
+		Properties:  STACItemProperties{Platform: m.ObserverSatelliteID},
+		Links: []STACLink{
+			{Rel: "self", Href: collection + "/items/" + url.PathEscape(id), Type: geoJSONType},
+			{Rel: "root", Href: base + "/stac", Type: "application/json"},
+			{Rel: "parent", Href: collection, Type: "application/json"},
+			{Rel: "collection", Href: collection, Type: "application/json"},
+		},
+		Assets: map[string]STACAsset{
+			"data":      {Href: image, Type: "image/jpeg", Title: "Image", Roles: []string{"data"}},
+			"thumbnail": {Href: image + "/thumbnail", Type: "image/jpeg", Title: "Thumbnail", Roles: []string{"thumbnail"}},
+			"metadata":  {Href: image + "/metadata", Type: "application/json", Title: "Metadata", Roles: []string{"metadata"}},
+		},
+	}
+
+	captured := imageCaptureTime(r)
+	if t := unixTime(captured); t != nil {
+		item.Properties.Datetime = t
+	} else if m.CollectionWindowStart != 0 && m.CollectionWindowEnd != 0 {
+		item.Properties.StartDatetime, item.Properties.EndDatetime = unixTime(m.CollectionWindowStart), unixTime(m.CollectionWindowEnd)
+	} else if r != nil && r.Ingest != nil {
+		item.Properties.Datetime = unixTime(r.Ingest.Ingested)
+	}
+	if r != nil && r.Ingest != nil {
+		item.Properties.Created = unixTime(r.Ingest.Ingested)
+	}
+	if r != nil && r.Contamination != nil {
+		cloud := r.Contamination.CloudPct
+		item.Properties.CloudCover = &cloud
+		item.STACExtensions = []string{stacEOExtension}
+	}
+
+	if r != nil && r.Footprint != nil && len(r.Footprint.Polygon) > 1 {
+		item.Geometry = &GeoJSONGeometry{Type: "Polygon", Coordinates: [][][2]float64{r.Footprint.Polygon}}
+		item.BBox = r.Footprint.BBox[:]
+		return item
+	}
+	var lon, lat float64
+	placed := false
+	if r != nil && r.EXIF != nil {
+		la, errLat := strconv.ParseFloat(r.EXIF["GPSLatitude"], 64)
+		lo, errLon := strconv.ParseFloat(r.EXIF["GPSLongitude"], 64)
+		lat, lon, placed = la, lo, errLat == nil && errLon == nil
+	}
+	if !placed {
+		if pos := api.positionAt(ctx, m.ObserverSatelliteID, captured); pos != nil {
+			lat, lon, placed = pos.Lat, pos.Lon, true
+		}
+	}
+	if placed {
+		item.Geometry = &GeoJSONGeometry{Type: "Point", Coordinates: [2]float64{lon, lat}}
+		item.BBox = []float64{lon, lat, lon, lat}
+	}
+	return item
+}
+
+// stacMissions loads the named missions, skipping those that do not exist,
+// or every mission when none is named, in ID order.
+func (api *API) stacMissions(ctx context.Context, ids []string) ([]Mission, error) {
+	var missions []Mission
+	if len(ids) > 0 {
+		for _, id := range ids {
+			m, err := api.loadMission(ctx, id)
+			if errors.Is(err, errMissionNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			missions = append(missions, *m)
+		}
+	} else {
+		token := ""
+		for {
+			page, next, err := api.MissionDB.Missions(ctx, streamIndexPage, token)
+			if err != nil {
+				return nil, err
+			}
+			missions = append(missions, page...)
+			if next == "" {
+				break
+			}
+			token = next
+		}
+	}
+	slices.SortFunc(missions, func(a, b Mission) int { return cmp.Compare(a.ID, b.ID) })
+	return missions, nil
+}
+
+// searchItems returns the page of items of missions f selects, and whether
+// more follow. Missions are read in turn only until the page is full.
+func (api *API) searchItems(ctx context.Context, base string, missions []Mission, f stacFilter) ([]STACItem, bool, error) {
+	items := []STACItem{}
+	skipped := 0
+	for i := range missions {
+		m := &missions[i]
+		records, err := api.Images.ImageRecords(ctx, m.ImageIDs)
+		if err != nil && !errors.Is(err, errImageTableUnset) {
+			return nil, false, err
+		}
+		for _, id := range m.ImageIDs {
+			item := api.stacItem(ctx, base, m, id, records[id])
+			if !f.matches(item) {
+				continue
+			}
+			if skipped < f.skip {
+				skipped++
+				continue
+			}
+			if len(items) == f.limit {
+				return items, true, nil
+			}
+			items = append(items, item)
+		}
+	}
+	return items, false, nil
+}
+
+func (api *API) getSTACCatalog(c *gin.Context) {
+	base := stacBase(c)
+	c.IndentedJSON(http.StatusOK, STACCatalog{
+		Type:        "Catalog",
+		STACVersion: stacVersion,
+		ID:          stacCatalogID,
+		Title:       "Satellite imagery",
+		Description: "Images of satellite inspection missions, one collection per mission.",
+		ConformsTo:  stacConformance,
+		Links: []STACLink{
+			{Rel: "self", Href: base + "/stac", Type: "application/json"},
+			{Rel: "root", Href: base + "/stac", Type: "application/json"},
+			{Rel: "conformance", Href: base + "/stac/conformance", Type: "application/json"},
+			{Rel: "data", Href: base + "/stac/collections", Type: "application/json"},
+			{Rel: "search", Href: base + "/stac/search", Type: geoJSONType, Method: http.MethodGet},
+			{Rel: "search", Href: base + "/stac/search", Type: geoJSONType, Method: http.MethodPost},
+			{Rel: "service-desc", Href: stacOrigin(c) + "/openapi.json", Type: "application/vnd.oai.openapi+json;version=3.0"},
+		},
+	})
+}
+
+func getSTACConformance(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, STACConformance{ConformsTo: stacConformance})
+}
+
+func (api *API) getSTACCollections(c *gin.Context) {
+	ctx := c.Request.Context()
+	base := stacBase(c)
+	missions, err := api.stacMissions(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list missions", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve missions")
+		return
+	}
+	resp := STACCollections{
+		Collections: make([]STACCollection, len(missions)),
+		Links: []STACLink{
+			{Rel: "self", Href: base + "/stac/collections", Type: "application/json"},
+			{Rel: "root", Href: base + "/stac", Type: "application/json"},
+			{Rel: "parent", Href: base + "/stac", Type: "application/json"},
+		},
+	}
+	for i := range missions {
+		resp.Collections[i] = stacCollection(base, &missions[i])
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// stacMission loads the collection's mission, answering 404 when it does
+// not exist.
+func (api *API) stacMission(c *gin.Context) (*Mission, bool) {
+	ctx := c.Request.Context()
+	m, err := api.loadMission(ctx, c.Param("id"))
+	if errors.Is(err, errMissionNotFound) {
+		respondError(c, http.StatusNotFound, CodeMissionNotFound, "collection not found")
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load mission", "id", c.Param("id"), "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return nil, false
+	}
+	return m, true
+}
+
+func (api *API) getSTACCollection(c *gin.Context) {
+	m, ok := api.stacMission(c)
+	if !ok {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, stacCollection(stacBase(c), m))
+}
+
+func (api *API) getSTACItem(c *gin.Context) {
+	ctx := c.Request.Context()
+	m, ok := api.stacMission(c)
+	if !ok {
+		return
+	}
+	id := c.Param("itemId")
+	if !slices.Contains(m.ImageIDs, id) {
+		respondError(c, http.StatusNotFound, CodeImageNotFound, "item not found")
+		return
+	}
+	record, err := api.Images.ImageRecord(ctx, id)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image record", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+		return
+	}
+	c.Header("Content-Type", geoJSONType)
+	c.IndentedJSON(http.StatusOK, api.stacItem(ctx, stacBase(c), m, id, record))
+}
+
+// getSTACItems lists a collection's items, filtered by the ?bbox= and
+// ?datetime= of GET /stac/search.
+func (api *API) getSTACItems(c *gin.Context) {
+	m, ok := api.stacMission(c)
+	if !ok {
+		return
+	}
+	search, err := searchFromQuery(c.Request.URL.Query())
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	search.Collections, search.IDs = nil, nil
+	api.respondItems(c, []Mission{*m}, search, http.MethodGet)
+}
+
+func (api *API) getSTACSearch(c *gin.Context) {
+	search, err := searchFromQuery(c.Request.URL.Query())
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	api.searchSTAC(c, search, http.MethodGet)
+}
+
+func (api *API) postSTACSearch(c *gin.Context) {
+	var search stacSearch
+	if err := c.ShouldBindJSON(&search); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid search: "+err.Error())
+		return
+	}
+	api.searchSTAC(c, search, http.MethodPost)
+}
+
+func (api *API) searchSTAC(c *gin.Context, search stacSearch, method string) {
+	ctx := c.Request.Context()
+	missions, err := api.stacMissions(ctx, search.Collections)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list missions", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve missions")
+		return
+	}
+	api.respondItems(c, missions, search, method)
+}
+
+// respondItems answers with a page of the missions' items, linking to the
+// next page the way the request was made.
+func (api *API) respondItems(c *gin.Context, missions []Mission, search stacSearch, method string) {
+	ctx := c.Request.Context()
+	f, err := search.filter()
+	if err != nil {
+		respondProblem(c, problemFor(err))
+		return
+	}
+	base := stacBase(c)
+	items, more, err := api.searchItems(ctx, base, missions, f)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load image records", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+		return
+	}
+	self := stacOrigin(c) + c.Request.URL.Path
+	resp := STACItemCollection{
+		Type:           "FeatureCollection",
+		Features:       items,
+		NumberReturned: len(items),
+		Links: []STACLink{
+			{Rel: "root", Href: base + "/stac", Type: "application/json"},
+		},
+	}
+	if more {
+		next := strconv.Itoa(f.skip + len(items))
+		if method == http.MethodPost {
+			search.Token = next
+			resp.Links = append(resp.Links, STACLink{Rel: "next", Href: self, Type: geoJSONType, Method: http.MethodPost, Body: &search})
+		} else {
+			q := c.Request.URL.Query()
+			q.Set("token", next)
+			resp.Links = append(resp.Links, STACLink{Rel: "next", Href: self + "?" + q.Encode(), Type: geoJSONType})
+		}
+	}
+	c.Header("Content-Type", geoJSONType)
+	c.IndentedJSON(http.StatusOK, resp)
+}
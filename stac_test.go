@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseSTACDatetime(t *testing.T) {
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in         string
+		start, end time.Time
+		wantErr    bool
+	}{
+		{in: "2026-10-14T00:00:00Z", start: day, end: day},
+		{in: "2026-10-14T00:00:00Z/2026-10-15T00:00:00Z", start: day, end: day.AddDate(0, 0, 1)},
+		{in: "../2026-10-14T00:00:00Z", end: day},
+		{in: "2026-10-14T00:00:00Z/", start: day},
+		{in: "../..", wantErr: true},
+		{in: "2026-10-15T00:00:00Z/2026-10-14T00:00:00Z", wantErr: true},
+		{in: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, end, err := parseSTACDatetime(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v", tt.in, err)
+			continue
+		}
+		if !tt.wantErr && (!start.Equal(tt.start) || !end.Equal(tt.end)) {
+			t.Errorf("%q: %v to %v", tt.in, start, end)
+		}
+	}
+}
+
+func TestSTACSearch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	meta := testSQLStore(t)
+	for _, m := range []*Mission{
+		{ID: "m1", Name: "Survey", ImageIDs: []string{"m1-00", "m1-01"}, CollectionWindowStart: 1791936000, CollectionWindowEnd: 1791939600},
+		{ID: "m2", ImageIDs: []string{"m2-00", "m2-01"}},
+	} {
+		if err := putMission(ctx, meta, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	place := func(id, lat, lon string, captured int64) {
+		meta.SetImageAttribute(ctx, id, "exif", map[string]string{"GPSLatitude": lat, "GPSLongitude": lon})
+		meta.SetImageAttribute(ctx, id, "geometry", ObservationGeometry{CaptureTime: captured})
+	}
+	place("m1-00", "10", "20", 1791936100)
+	place("m1-01", "-5", "179.5", 1791937000)
+	place("m2-00", "50", "2", 1800000000)
+	meta.SetImageAttribute(ctx, "m2-00", "contamination", Contamination{CloudPct: 40})
+
+	api := &API{MissionDB: meta, Images: meta}
+	router := gin.New()
+	v1 := router.Group(apiV1Prefix)
+	v1.GET("/stac", api.getSTACCatalog)
+	v1.GET("/stac/collections", api.getSTACCollections)
+	v1.GET("/stac/collections/:id", api.getSTACCollection)
+	v1.GET("/stac/collections/:id/items", api.getSTACItems)
+	v1.GET("/stac/collections/:id/items/:itemId", api.getSTACItem)
+	v1.GET("/stac/search", api.getSTACSearch)
+	v1.POST("/stac/search", api.postSTACSearch)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Host = "sat.example.com"
+		router.ServeHTTP(w, r)
+		return w
+	}
+	search := func(path string) (int, STACItemCollection) {
+		w := do(http.MethodGet, path, "")
+		var resp STACItemCollection
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	ids := func(items []STACItem) string {
+		var s []string
+		for _, it := range items {
+			s = append(s, it.ID)
+		}
+		return strings.Join(s, ",")
+	}
+
+	w := do(http.MethodGet, "/api/v1/stac", "")
+	var root STACCatalog
+	json.Unmarshal(w.Body.Bytes(), &root)
+	if w.Code != http.StatusOK || root.Type != "Catalog" || len(root.Links) == 0 || root.Links[0].Href != "http://sat.example.com/api/v1/stac" {
+		t.Fatalf("root: %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "m1-00,m1-01,m2-00,m2-01"},
+		{"?bbox=0,0,30,30", "m1-00"},
+		{"?bbox=170,-10,-170,10", "m1-01"},
+		{"?datetime=2026-10-14T00:00:00Z/2026-10-31T00:00:00Z", "m1-00,m1-01"},
+		{"?collections=m2,nope", "m2-00,m2-01"},
+		{"?ids=m2-00,m1-01", "m1-01,m2-00"},
+	}
+	for _, tt := range tests {
+		code, resp := search("/api/v1/stac/search" + tt.query)
+		if code != http.StatusOK || ids(resp.Features) != tt.want || resp.NumberReturned != len(resp.Features) {
+			t.Errorf("%q: %d %s, want %s", tt.query, code, ids(resp.Features), tt.want)
+		}
+	}
+	for _, q := range []string{"bbox=1,2,3", "bbox=0,50,10,40", "datetime=soon", "limit=0", "token=x"} {
+		if code, _ := search("/api/v1/stac/search?" + q); code != http.StatusBadRequest {
+			t.Errorf("%s: %d", q, code)
+		}
+	}
+
+	// Pages follow the next link.
+	_, page := search("/api/v1/stac/search?limit=3")
+	var next string
+	for _, l := range page.Links {
+		if l.Rel == "next" {
+			next = strings.TrimPrefix(l.Href, "http://sat.example.com")
+		}
+	}
+	if ids(page.Features) != "m1-00,m1-01,m2-00" || next == "" {
+		t.Fatalf("first page %s, next %q", ids(page.Features), next)
+	}
+	if _, page := search(next); ids(page.Features) != "m2-01" || len(page.Links) != 1 {
+		t.Errorf("second page %s %+v", ids(page.Features), page.Links)
+	}
+
+	w = do(http.MethodPost, "/api/v1/stac/search", `{"bbox": [0, 40, 10, 60], "limit": 5}`)
+	var posted STACItemCollection
+	json.Unmarshal(w.Body.Bytes(), &posted)
+	if w.Code != http.StatusOK || ids(posted.Features) != "m2-00" {
+		t.Fatalf("post: %d %s", w.Code, w.Body)
+	}
+	item := posted.Features[0]
+	if item.Properties.CloudCover == nil || *item.Properties.CloudCover != 40 || item.Geometry == nil || item.Assets["data"].Href != "http://sat.example.com/api/v1/image/m2-00" {
+		t.Errorf("item %+v", item)
+	}
+
+	if _, resp := search("/api/v1/stac/collections/m1/items?bbox=0,0,30,30"); ids(resp.Features) != "m1-00" {
+		t.Errorf("collection items %s", ids(resp.Features))
+	}
+	w = do(http.MethodGet, "/api/v1/stac/collections/m2/items/m2-01", "")
+	var unplaced STACItem
+	json.Unmarshal(w.Body.Bytes(), &unplaced)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != geoJSONType || unplaced.Geometry != nil || !bytes.Contains(w.Body.Bytes(), []byte(`"geometry": null`)) {
+		t.Errorf("unplaced item: %d %s", w.Code, w.Body)
+	}
+	for _, path := range []string{"/api/v1/stac/collections/m2/items/m1-00", "/api/v1/stac/collections/nope"} {
+		if w := do(http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: %d", path, w.Code)
+		}
+	}
+	w = do(http.MethodGet, "/api/v1/stac/collections", "")
+	var cols STACCollections
+	json.Unmarshal(w.Body.Bytes(), &cols)
+	if len(cols.Collections) != 2 || cols.Collections[0].Title != "Survey" || cols.Collections[0].Extent.Temporal.Interval[0][0] == nil {
+		t.Errorf("collections %s", w.Body)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	stackMaxFrames = 32
+	// Median stacking keeps every frame in memory, so it takes fewer.
+	stackMaxMedianFrames = 16
+	stackMaxSide         = 2048
+	// Stacks with more frames than this are rendered as a background job.
+	stackSyncFrames = 8
+)
+
+type stackRequest struct {
+	IDs    []string `json:"ids"`
+	Method string   `json:"method"`
+	Align  *bool    `json:"align"`
+	Format string   `json:"format"`
+	Scale  string   `json:"scale"`
+	Async  bool     `json:"async"`
+}
+
+type stackSpec struct {
+	ids    []string
+	median bool
+	align  bool
+	format *OutputFormat
+	scale  *RadiometricScale
+}
+
+// postStack averages several frames of the same target into one composite,
+// registering each against the first frame by translation. Small stacks are
+// returned directly; larger ones (or "async": true) start a job.
+func (api *API) postStack(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+
+	var req stackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"ids\": [...]}.")
+		return
+	}
+	spec, err := parseStackRequest(req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, err.Error())
+		return
+	}
+
+	if req.Async || len(req.IDs) > stackSyncFrames {
+		job, err := api.Jobs.Submit(c.Request.Context(), "stack", req, nil)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to submit stack job", "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to start job")
+			return
+		}
+		c.JSON(http.StatusAccepted, newJobAccepted(job))
+		return
+	}
+
+	img, offsets, err := api.stackImages(c.Request.Context(), bucketName, spec, nil)
+	if err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		if errors.Is(err, errOverloaded) {
+			respondOverloaded(c)
+			return
+		}
+		if errors.Is(err, errImageTooLarge) {
+			respondError(c, http.StatusUnprocessableEntity, CodeImageTooLarge, imageTooLargeMessage())
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to stack images", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := spec.format.Encode(&buf, img, EncodeOptions{Quality: defaultQuality}); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to encode stack", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+		return
+	}
+
+	c.Header("X-Stack-Frames", strconv.Itoa(len(spec.ids)))
+	c.Header("X-Stack-Offsets", formatOffsets(offsets))
+	c.Header("Access-Control-Expose-Headers", "X-Stack-Frames, X-Stack-Offsets")
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, spec.format.ContentType, buf.Bytes())
+}
+
+// parseStackRequest validates a stack request. It runs again when a stack
+// job starts, since the request is what the job saves.
+func parseStackRequest(req stackRequest) (stackSpec, error) {
+	// PNG by default, so the extra bit depth a stack recovers survives.
+	spec := stackSpec{ids: req.IDs, align: req.Align == nil || *req.Align}
+	spec.format, _ = lookupFormat("png")
+	switch req.Method {
+	case "", "mean":
+	case "median":
+		spec.median = true
+	default:
+		return spec, errors.New("Invalid 'method'. Must be mean or median.")
+	}
+
+	limit := stackMaxFrames
+	if spec.median {
+		limit = stackMaxMedianFrames
+	}
+	if len(req.IDs) < 2 || len(req.IDs) > limit {
+		return spec, fmt.Errorf("Between 2 and %d image IDs are required for this stacking method.", limit)
+	}
+
+	if req.Format != "" {
+		f, ok := lookupFormat(req.Format)
+		if !ok {
+			return spec, errors.New("Invalid 'format'. Must be one of jpeg, png, webp, avif, tiff.")
+		}
+		spec.format = f
+	}
+
+	var err error
+	spec.scale, err = parseScale(req.Scale)
+	return spec, err
+}
+
+func (api *API) runStackJob(ctx context.Context, job Job) (string, string, error) {
+	bucketName := api.Config.ImagesBucket
+	var req stackRequest
+	if err := json.Unmarshal(job.Params, &req); err != nil {
+		return "", "", permanent(fmt.Errorf("decode params: %w", err))
+	}
+	spec, err := parseStackRequest(req)
+	if err != nil {
+		return "", "", permanent(err)
+	}
+	api.Jobs.SetProgress(job.ID, 0)
+
+	img, _, err := api.stackImages(ctx, bucketName, spec, func(p float64) {
+		api.Jobs.SetProgress(job.ID, p)
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	var buf bytes.Buffer
+	if err := spec.format.Encode(&buf, img, EncodeOptions{Quality: defaultQuality}); err != nil {
+		return "", "", fmt.Errorf("encode: %w", err)
+	}
+
+	key := fmt.Sprintf("stacks/%s.%s", job.ID, spec.format.Extension)
+	_, err = api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String(spec.format.ContentType),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "s3 PutObject failed", "key", key, "err", err)
+		return "", "", fmt.Errorf("store output: %w", err)
+	}
+	return key, spec.format.ContentType, nil
+}
+
+// stackImages combines the frames at the first frame's size (capped at
+// stackMaxSide). Each output pixel is the mean or median of the frames that
+// cover it after registration, so edges uncovered by a shifted frame are not
+// darkened. The result is 16-bit, though frames that had to be resized
+// arrive at 8 bits, and stamping the server's overlay brings it to 8 bits too. The returned offsets are each frame's shift relative to
+// the first.
+func (api *API) stackImages(ctx context.Context, bucketName string, spec stackSpec, progress func(float64)) (image.Image, []image.Point, error) {
+	ref, release, err := api.loadSourceImage(ctx, bucketName, spec.ids[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	channels := 3
+	switch ref.(type) {
+	case *image.Gray, *image.Gray16:
+		channels = 1
+	}
+	if b := ref.Bounds(); max(b.Dx(), b.Dy()) > stackMaxSide {
+		ref = imaging.Fit(ref, stackMaxSide, stackMaxSide, imaging.Lanczos)
+	}
+	// Once at working size the reference is small next to a full decode, and
+	// holding its share while loading the other frames could leave them
+	// waiting on it for good.
+	release()
+	b := ref.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	offsets := make([]image.Point, len(spec.ids))
+	sum := make([]float64, w*h*channels)
+	count := make([]uint16, w*h)
+	var frames [][]uint16
+
+	for i, id := range spec.ids {
+		img, done := ref, func() {}
+		if i > 0 {
+			if img, done, err = api.loadSourceImage(ctx, bucketName, id); err != nil {
+				return nil, nil, err
+			}
+			if ib := img.Bounds(); ib.Dx() != w || ib.Dy() != h {
+				img = imaging.Resize(img, w, h, imaging.Lanczos)
+			}
+			if spec.align {
+				dx, dy := alignTranslation(ref, img)
+				offsets[i] = image.Pt(dx, dy)
+			}
+		}
+
+		samples := stackSamples(img, channels)
+		done()
+		if spec.median {
+			frames = append(frames, samples)
+		} else {
+			off := offsets[i]
+			for y := max(0, off.Y); y < min(h, h+off.Y); y++ {
+				for x := max(0, off.X); x < min(w, w+off.X); x++ {
+					src := ((y-off.Y)*w + (x - off.X)) * channels
+					dst := (y*w + x) * channels
+					for c := 0; c < channels; c++ {
+						sum[dst+c] += float64(samples[src+c])
+					}
+					count[y*w+x]++
+				}
+			}
+		}
+
+		if progress != nil {
+			progress(0.9 * float64(i+1) / float64(len(spec.ids)))
+		}
+	}
+
+	out := make([]uint16, w*h*channels)
+	if spec.median {
+		values := make([]uint16, 0, len(frames))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				for c := 0; c < channels; c++ {
+					values = values[:0]
+					for i, f := range frames {
+						sx, sy := x-offsets[i].X, y-offsets[i].Y
+						if sx >= 0 && sx < w && sy >= 0 && sy < h {
+							values = append(values, f[(sy*w+sx)*channels+c])
+						}
+					}
+					slices.Sort(values)
+					if n := len(values); n > 0 {
+						out[(y*w+x)*channels+c] = values[n/2]
+					}
+				}
+			}
+		}
+	} else {
+		for i := range out {
+			if n := count[i/channels]; n > 0 {
+				out[i] = clampSample(sum[i] / float64(n))
+			}
+		}
+	}
+
+	var result image.Image
+	if channels == 1 {
+		gray := image.NewGray16(image.Rect(0, 0, w, h))
+		for i, v := range out {
+			gray.Pix[2*i] = uint8(v >> 8)
+			gray.Pix[2*i+1] = uint8(v)
+		}
+		result = gray
+	} else {
+		rgb := image.NewNRGBA64(image.Rect(0, 0, w, h))
+		for i := 0; i < w*h; i++ {
+			for c := 0; c < 3; c++ {
+				v := out[i*3+c]
+				rgb.Pix[i*8+2*c] = uint8(v >> 8)
+				rgb.Pix[i*8+2*c+1] = uint8(v)
+			}
+			rgb.Pix[i*8+6], rgb.Pix[i*8+7] = 0xff, 0xff
+		}
+		result = rgb
+	}
+
+	if spec.scale != nil {
+		result = applyScale(result, spec.scale)
+	}
+	if api.Overlay != nil {
+		result = drawOverlay(result, api.Overlay)
+	}
+	return result, offsets, nil
+}
+
+// stackSamples flattens img into 16-bit samples, one or three per pixel.
+func stackSamples(img image.Image, channels int) []uint16 {
+	b := img.Bounds()
+	samples := make([]uint16, 0, b.Dx()*b.Dy()*channels)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if channels == 1 {
+				samples = append(samples, color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+				continue
+			}
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			samples = append(samples, c.R, c.G, c.B)
+		}
+	}
+	return samples
+}
+
+func formatOffsets(offsets []image.Point) string {
+	var buf bytes.Buffer
+	for i, p := range offsets {
+		if i > 0 {
+			buf.WriteByte(';')
+		}
+		fmt.Fprintf(&buf, "%d,%d", p.X, p.Y)
+	}
+	return buf.String()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestParseStackRequest(t *testing.T) {
+	ids := func(n int) []string { return make([]string, n) }
+	off := false
+	tests := []struct {
+		name    string
+		req     stackRequest
+		wantErr bool
+	}{
+		{name: "defaults", req: stackRequest{IDs: ids(2)}},
+		{name: "median", req: stackRequest{IDs: ids(stackMaxMedianFrames), Method: "median"}},
+		{name: "unaligned tiff", req: stackRequest{IDs: ids(3), Align: &off, Format: "tiff", Scale: "minmax"}},
+		{name: "one frame", req: stackRequest{IDs: ids(1)}, wantErr: true},
+		{name: "too many", req: stackRequest{IDs: ids(stackMaxFrames + 1)}, wantErr: true},
+		{name: "too many for median", req: stackRequest{IDs: ids(stackMaxMedianFrames + 1), Method: "median"}, wantErr: true},
+		{name: "unknown method", req: stackRequest{IDs: ids(2), Method: "sum"}, wantErr: true},
+		{name: "unknown format", req: stackRequest{IDs: ids(2), Format: "bmp"}, wantErr: true},
+		{name: "bad scale", req: stackRequest{IDs: ids(2), Scale: "gamma"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		spec, err := parseStackRequest(tt.req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if err != nil {
+			continue
+		}
+		if spec.median != (tt.req.Method == "median") || spec.align != (tt.req.Align == nil) || spec.format == nil {
+			t.Errorf("%s: spec %+v", tt.name, spec)
+		}
+	}
+	if spec, _ := parseStackRequest(stackRequest{IDs: ids(2)}); spec.format.Name != "png" {
+		t.Errorf("default format %s", spec.format.Name)
+	}
+}
+
+func TestStackImages(t *testing.T) {
+	ctx := context.Background()
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := imaging.Decode(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b is a moved by (5, -3); white is an outlier frame.
+	a := imaging.Crop(src, image.Rect(20, 20, 532, 532))
+	b := imaging.Crop(src, image.Rect(25, 17, 537, 529))
+	white := image.NewNRGBA(a.Bounds())
+	for i := range white.Pix {
+		white.Pix[i] = 0xff
+	}
+	for id, img := range map[string]image.Image{"a": a, "b": b, "white": white} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		putTestObject(t, store, imageKey(id), buf.Bytes())
+	}
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	// sample is the red of the stack at (x, y) as 8 bits.
+	sample := func(img image.Image, x, y int) uint8 {
+		return uint8(color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64).R >> 8)
+	}
+
+	stacked, offsets, err := api.stackImages(ctx, "bucket", stackSpec{ids: []string{"a", "b"}, align: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 2 || offsets[0] != (image.Point{}) || offsets[1] != image.Pt(5, -3) {
+		t.Fatalf("offsets %v", offsets)
+	}
+	if formatOffsets(offsets) != "0,0;5,-3" {
+		t.Errorf("formatOffsets = %s", formatOffsets(offsets))
+	}
+	// Registered, both frames agree, so their mean is a.
+	for _, p := range []image.Point{{100, 100}, {300, 200}, {500, 10}} {
+		if got, want := sample(stacked, p.X, p.Y), a.NRGBAAt(p.X, p.Y).R; got != want {
+			t.Errorf("mean at %v = %d, want %d", p, got, want)
+		}
+	}
+
+	// The median of a, a and white is a; their mean is brighter.
+	spec := stackSpec{ids: []string{"a", "white", "a"}}
+	median, _, err := api.stackImages(ctx, "bucket", stackSpec{ids: spec.ids, median: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mean, _, err := api.stackImages(ctx, "bucket", spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sample(median, 100, 100), a.NRGBAAt(100, 100).R; got != want {
+		t.Errorf("median = %d, want %d", got, want)
+	}
+	if got, want := int(sample(mean, 100, 100)), (2*int(a.NRGBAAt(100, 100).R)+255)/3; got < want-1 || got > want+1 {
+		t.Errorf("mean = %d, want %d", got, want)
+	}
+
+	if _, _, err := api.stackImages(ctx, "bucket", stackSpec{ids: []string{"a", "gone"}}, nil); !isNotFound(err) {
+		t.Errorf("missing frame: %v", err)
+	}
+}
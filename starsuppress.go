@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"math"
+	"slices"
+	"strconv"
+)
+
+const (
+	defaultStarSuppressCell  = 64
+	defaultStarSuppressSigma = 3
+	// starSuppressSamples caps how many pixels of each cell feed its median.
+	starSuppressSamples = 1024
+)
+
+// StarSuppress subtracts a smooth background estimate and clears residuals
+// within Sigma noise levels of it. What survives is whatever stands clearly
+// above the sky: the target and the brightest stars, rather than the faint
+// star field and sky glow around them.
+type StarSuppress struct {
+	// Cell is the side of the square mesh cells the background is
+	// estimated over. It should be several times the size of a star.
+	Cell  int
+	Sigma float64
+}
+
+func parseStarSuppress(enabled, cell, sigma string) (*StarSuppress, error) {
+	on, _ := strconv.ParseBool(enabled)
+	if !on {
+		return nil, nil
+	}
+
+	s := &StarSuppress{Cell: defaultStarSuppressCell, Sigma: defaultStarSuppressSigma}
+	if cell != "" {
+		v, err := strconv.Atoi(cell)
+		if err != nil || v < 8 || v > 512 {
+			return nil, errors.New("Invalid 'starsuppress_cell' parameter. Must be an integer between 8 and 512.")
+		}
+		s.Cell = v
+	}
+	if sigma != "" {
+		v, err := strconv.ParseFloat(sigma, 64)
+		if err != nil || v < 0 || v > 20 {
+			return nil, errors.New("Invalid 'starsuppress_sigma' parameter. Must be a number between 0 and 20.")
+		}
+		s.Sigma = v
+	}
+	return s, nil
+}
+
+// applyStarSuppress returns a 16-bit copy of img with the background removed
+// from each colour channel. Like applyScale, grayscale sources stay
+// single-channel.
+func applyStarSuppress(img image.Image, s *StarSuppress) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if gray, ok := img.(*image.Gray16); ok {
+		dst := newPooledGray16(b)
+		drawParallel(dst, gray)
+		suppressChannel(dst.Pix, 0, 2, w, h, dst.Stride, s)
+		return dst
+	}
+
+	dst := newPooledNRGBA64(b)
+	drawParallel(dst, img)
+	for c := 0; c < 3; c++ {
+		suppressChannel(dst.Pix, 2*c, 8, w, h, dst.Stride, s)
+	}
+	return dst
+}
+
+// suppressChannel works in place on one big-endian 16-bit channel of pix,
+// starting at byte offset off with step bytes between pixels.
+func suppressChannel(pix []byte, off, step, w, h, stride int, s *StarSuppress) {
+	at := func(x, y int) int { return y*stride + x*step + off }
+	get := func(x, y int) float64 {
+		i := at(x, y)
+		return float64(uint16(pix[i])<<8 | uint16(pix[i+1]))
+	}
+
+	// Median and MAD per mesh cell, from a strided sample of its pixels.
+	gx, gy := (w+s.Cell-1)/s.Cell, (h+s.Cell-1)/s.Cell
+	level := make([]float64, gx*gy)
+	noise := make([]float64, gx*gy)
+	sampleStep := max(1, int(math.Sqrt(float64(s.Cell*s.Cell)/starSuppressSamples)))
+	// Cells are independent, so rows of the mesh are measured in parallel
+	// (parallelRows works in units of mesh rows here, not pixels).
+	parallelRows(gy*minStripRows, func(r0, r1 int) {
+		samples := make([]float64, 0, starSuppressSamples*2)
+		for cy := r0 / minStripRows; cy < r1/minStripRows; cy++ {
+			for cx := 0; cx < gx; cx++ {
+				samples = samples[:0]
+				for y := cy * s.Cell; y < min(h, (cy+1)*s.Cell); y += sampleStep {
+					for x := cx * s.Cell; x < min(w, (cx+1)*s.Cell); x += sampleStep {
+						samples = append(samples, get(x, y))
+					}
+				}
+				med := median(samples)
+				for i, v := range samples {
+					samples[i] = math.Abs(v - med)
+				}
+				level[cy*gx+cx] = med
+				noise[cy*gx+cx] = 1.4826 * median(samples)
+			}
+		}
+	})
+
+	// A 3x3 median over the mesh stops a cell filled by the target itself
+	// from being mistaken for bright sky and subtracted away.
+	level = medianFilterGrid(level, gx, gy)
+	sigma := median(slices.Clone(noise))
+	floor := s.Sigma * sigma
+
+	parallelRows(h, func(ys, ye int) {
+		for y := ys; y < ye; y++ {
+			fy := math.Max(0, math.Min(float64(gy-1), (float64(y)+0.5)/float64(s.Cell)-0.5))
+			y0 := int(fy)
+			y1 := min(y0+1, gy-1)
+			ty := fy - float64(y0)
+			for x := 0; x < w; x++ {
+				fx := math.Max(0, math.Min(float64(gx-1), (float64(x)+0.5)/float64(s.Cell)-0.5))
+				x0 := int(fx)
+				x1 := min(x0+1, gx-1)
+				tx := fx - float64(x0)
+
+				top := level[y0*gx+x0]*(1-tx) + level[y0*gx+x1]*tx
+				bottom := level[y1*gx+x0]*(1-tx) + level[y1*gx+x1]*tx
+				bg := top*(1-ty) + bottom*ty
+
+				v := get(x, y) - bg
+				if v <= floor {
+					v = 0
+				}
+				out := clampSample(v)
+				i := at(x, y)
+				pix[i] = uint8(out >> 8)
+				pix[i+1] = uint8(out)
+			}
+		}
+	})
+}
+
+func medianFilterGrid(grid []float64, gx, gy int) []float64 {
+	out := make([]float64, len(grid))
+	window := make([]float64, 0, 9)
+	for y := 0; y < gy; y++ {
+		for x := 0; x < gx; x++ {
+			window = window[:0]
+			for ny := max(0, y-1); ny <= min(gy-1, y+1); ny++ {
+				for nx := max(0, x-1); nx <= min(gx-1, x+1); nx++ {
+					window = append(window, grid[ny*gx+nx])
+				}
+			}
+			out[y*gx+x] = median(window)
+		}
+	}
+	return out
+}
+
+// median sorts v in place and returns its middle value.
+func median(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	slices.Sort(v)
+	if len(v)%2 == 1 {
+		return v[len(v)/2]
+	}
+	return (v[len(v)/2-1] + v[len(v)/2]) / 2
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestParseStarSuppress(t *testing.T) {
+	for _, tt := range []struct {
+		enabled, cell, sigma string
+		want                 *StarSuppress
+		wantErr              bool
+	}{
+		{enabled: ""},
+		{enabled: "false", cell: "4"},
+		{enabled: "true", want: &StarSuppress{Cell: defaultStarSuppressCell, Sigma: defaultStarSuppressSigma}},
+		{enabled: "1", cell: "32", sigma: "1.5", want: &StarSuppress{Cell: 32, Sigma: 1.5}},
+		{enabled: "true", cell: "4", wantErr: true},
+		{enabled: "true", cell: "1024", wantErr: true},
+		{enabled: "true", sigma: "-1", wantErr: true},
+		{enabled: "true", sigma: "many", wantErr: true},
+	} {
+		got, err := parseStarSuppress(tt.enabled, tt.cell, tt.sigma)
+		if (err != nil) != tt.wantErr || (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("parseStarSuppress(%q, %q, %q) = %+v, %v", tt.enabled, tt.cell, tt.sigma, got, err)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	for _, tt := range []struct {
+		in   []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{3}, 3},
+		{[]float64{5, 1, 3}, 3},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	} {
+		if got := median(tt.in); got != tt.want {
+			t.Errorf("median(%v) = %g, want %g", tt.in, got, tt.want)
+		}
+	}
+
+	// One bright cell in the mesh is replaced by its neighbours' level.
+	grid := []float64{1, 1, 1, 1, 100, 1, 1, 1, 1}
+	if got := medianFilterGrid(grid, 3, 3); got[4] != 1 || got[0] != 1 {
+		t.Errorf("medianFilterGrid = %v", got)
+	}
+}
+
+func TestApplyStarSuppress(t *testing.T) {
+	// Noisy sky rising from 10000 on the left to 20000 on the right, with
+	// one bright target.
+	const w, h = 256, 128
+	r := rand.New(rand.NewPCG(1, 2))
+	src := image.NewGray16(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.SetGray16(x, y, color.Gray16{Y: uint16(10000 + x*10000/w + r.IntN(200))})
+		}
+	}
+	for y := 60; y < 64; y++ {
+		for x := 100; x < 104; x++ {
+			src.SetGray16(x, y, color.Gray16{Y: 60000})
+		}
+	}
+	out, ok := applyStarSuppress(src, &StarSuppress{Cell: 32, Sigma: 3}).(*image.Gray16)
+	if !ok {
+		t.Fatalf("gray suppressed to %T", out)
+	}
+	var lit int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if out.Gray16At(x, y).Y > 0 {
+				lit++
+			}
+		}
+	}
+	if target := out.Gray16At(101, 61).Y; target < 35000 {
+		t.Errorf("target suppressed to %d", target)
+	}
+	// Only the target, and a few noise spikes, survive the gradient.
+	if lit > 16+w*h/100 {
+		t.Errorf("%d pixels left above the sky", lit)
+	}
+	if src.Gray16At(0, 0).Y == 0 {
+		t.Error("source modified")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ObjectStore is the subset of the S3 API the server uses. Every backend
+// speaks it in S3's own request and response types, so handlers do not care
+// where objects live: *s3.Client satisfies it directly for AWS, MinIO and
+// GCS, and fsStore emulates it on a local directory, ignoring the per-call
+// options.
+type ObjectStore interface {
+	GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CopyObject(ctx context.Context, in *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	HeadBucket(ctx context.Context, in *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	RestoreObject(ctx context.Context, in *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error)
+}
+
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// initStorage returns the ObjectStore named by STORAGE_BACKEND:
+//
+//   - s3 (default): AWS S3 with the default SDK credential chain.
+//   - minio: any S3-compatible server at STORAGE_ENDPOINT, addressed
+//     path-style, with its access keys in AWS_ACCESS_KEY_ID and
+//     AWS_SECRET_ACCESS_KEY.
+//   - gcs: Google Cloud Storage through its S3-compatible XML API, with HMAC
+//     keys in the same variables. STORAGE_ENDPOINT defaults to
+//     storage.googleapis.com.
+//   - filesystem: buckets as directories under STORAGE_ROOT, for development
+//     and air-gapped installs.
+func initStorage(cfg *Config) ObjectStore {
+	switch cfg.StorageBackend {
+	case "minio":
+		slog.Info("storing objects in minio", "endpoint", cfg.StorageEndpoint)
+		return newS3Compatible(cfg.StorageEndpoint)
+	case "gcs":
+		endpoint := cfg.StorageEndpoint
+		if endpoint == "" {
+			endpoint = gcsEndpoint
+		}
+		slog.Info("storing objects in gcs", "endpoint", endpoint)
+		return gcsStore{newS3Compatible(endpoint)}
+	case "filesystem":
+		store, err := newFSStore(cfg.StorageRoot)
+		if err != nil {
+			fatal("unable to open STORAGE_ROOT", "err", err)
+		}
+		slog.Info("storing objects on the filesystem", "root", store.root)
+		return store
+	}
+	return initS3()
+}
+
+// initS3 honors AWS_ENDPOINT_URL and AWS_ENDPOINT_URL_S3 as the SDK does.
+// Emulators such as LocalStack behind such an endpoint serve buckets by path
+// rather than by host name.
+func initS3() *s3.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), withAWSMetrics())
+	if err != nil {
+		fatal("unable to load SDK config", "err", err)
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = o.BaseEndpoint != nil
+	})
+}
+
+// newS3Compatible is an S3 client for a non-AWS endpoint. Such servers
+// generally know neither virtual-hosted bucket names nor the CRC checksums
+// the SDK adds to every request by default, so both are turned off.
+func newS3Compatible(endpoint string) *s3.Client {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), withAWSMetrics())
+	if err != nil {
+		fatal("unable to load SDK config", "err", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "auto"
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+}
+
+// gcsStore adapts the GCS XML API, which has no multi-object delete, by
+// deleting the objects one at a time. As with S3, per-object failures are
+// reported in Errors rather than failing the call.
+type gcsStore struct {
+	*s3.Client
+}
+
+func (g gcsStore) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	out := &s3.DeleteObjectsOutput{}
+	if in.Delete == nil {
+		return out, nil
+	}
+	for _, obj := range in.Delete.Objects {
+		_, err := g.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: in.Bucket, Key: obj.Key}, optFns...)
+		if err != nil && !isNotFound(err) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			out.Errors = append(out.Errors, s3types.Error{Key: obj.Key, Message: aws.String(err.Error())})
+			continue
+		}
+		if !aws.ToBool(in.Delete.Quiet) {
+			out.Deleted = append(out.Deleted, s3types.DeletedObject{Key: obj.Key})
+		}
+	}
+	return out, nil
+}
+
+// deleteKeys removes keys in batches of the 1000 DeleteObjects allows.
+func deleteKeys(ctx context.Context, store ObjectStore, bucketName string, keys []string) error {
+	for len(keys) > 0 {
+		n := min(len(keys), 1000)
+		objects := make([]s3types.ObjectIdentifier, n)
+		for i, k := range keys[:n] {
+			objects[i] = s3types.ObjectIdentifier{Key: aws.String(k)}
+		}
+		_, err := store.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &s3types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gin-gonic/gin"
+)
+
+// With MULTI_TENANT set every request acts for one tenant, resolved from its
+// credentials, and sees only that tenant's data: its objects live under
+// tenants/<tenant>/ in the images bucket and its mission and image records
+// are keyed <tenant>/<id>. The object, mission and image stores are wrapped
+// to add and strip the prefixes, so handlers work in the tenant's own keys
+// and IDs and have no way to name another tenant's. A context without a
+// tenant, as the server's own background work has, sees the stores
+// unscoped.
+//
+// The other stores keep tenants apart in one of two ways. Conjunctions,
+// downlinks, mission history, image hashes and footprints are keyed by
+// tenantRecordID like the mission table. Jobs, webhooks, API keys, role
+// assignments, audit entries, usage and storage accounts record their
+// tenant and are filtered by it. The satellite, TLE and sensor catalog is
+// shared by every tenant, so with MULTI_TENANT only ADMIN_TOKEN may change
+// it; feature flags name their tenants themselves.
+const (
+	defaultTenant = "default"
+	// tenantObjectRoot holds every tenant's objects.
+	tenantObjectRoot = "tenants/"
+	// tenantHeader names the tenant an ADMIN_TOKEN request acts for.
+	tenantHeader = "X-Tenant"
+	// tenantParam carries the tenant of a signed image URL, covered by
+	// its signature.
+	tenantParam = "tenant"
+)
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type tenantContextKey struct{}
+
+// withTenant returns ctx acting for tenant; an empty tenant leaves ctx as
+// it is.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenantFrom returns the tenant ctx acts for, or "" for none.
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// tenantObjectKey is the bucket key of ctx's tenant's object key.
+func tenantObjectKey(ctx context.Context, key string) string {
+	if tenant := tenantFrom(ctx); tenant != "" {
+		return tenantObjectRoot + tenant + "/" + key
+	}
+	return key
+}
+
+// tenantRecordID is the table key of ctx's tenant's record id.
+func tenantRecordID(ctx context.Context, id string) string {
+	if tenant := tenantFrom(ctx); tenant != "" {
+		return tenant + "/" + id
+	}
+	return id
+}
+
+// splitObjectKey returns the tenant of a bucket key and the key within the
+// tenant, or false for a key outside tenantObjectRoot.
+func splitObjectKey(key string) (tenant, rest string, ok bool) {
+	rest, ok = strings.CutPrefix(key, tenantObjectRoot)
+	if !ok {
+		return "", key, false
+	}
+	tenant, rest, ok = strings.Cut(rest, "/")
+	if !ok || !tenantName.MatchString(tenant) {
+		return "", key, false
+	}
+	return tenant, rest, true
+}
+
+// splitRecordID is the inverse of tenantRecordID.
+func splitRecordID(raw string) (tenant, id string, ok bool) {
+	tenant, id, ok = strings.Cut(raw, "/")
+	if !ok || !tenantName.MatchString(tenant) {
+		return "", raw, false
+	}
+	return tenant, id, true
+}
+
+// resolveTenant returns the tenant p acts for, or "" without MULTI_TENANT.
+// API keys and OIDC tokens act for the tenant they carry and anonymous
+// callers for DEFAULT_TENANT. The holder of ADMIN_TOKEN may act for any,
+// named by requested, the X-Tenant header; anyone else naming a tenant
+// must name their own.
+func resolveTenant(cfg *Config, p *Principal, requested string) (string, error) {
+	if !cfg.MultiTenant {
+		return "", nil
+	}
+	own := cfg.DefaultTenant
+	if p != nil && p.Tenant != "" {
+		own = p.Tenant
+	}
+	switch {
+	case requested == "":
+		return own, nil
+	case !tenantName.MatchString(requested):
+		return "", newProblem(http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("the %s header is not a tenant name", tenantHeader))
+	case p == adminPrincipal || p == maintenancePrincipal || requested == own:
+		return requested, nil
+	}
+	return "", newProblem(http.StatusForbidden, CodeForbidden, fmt.Sprintf("the credentials do not act for tenant %q", requested))
+}
+
+// listedTenant is the tenant whose API keys, webhooks, role assignments
+// and audit entries the caller of ctx may see, or "" for every tenant's.
+// With MULTI_TENANT only the holder of ADMIN_TOKEN sees them all.
+func (api *API) listedTenant(ctx context.Context) string {
+	if api.Config.MultiTenant && principalFrom(ctx) != adminPrincipal {
+		return tenantFrom(ctx)
+	}
+	return ""
+}
+
+// ownsTenant reports whether the caller of ctx may see and change a record
+// of tenant. Records made without MULTI_TENANT belong to DEFAULT_TENANT.
+func (api *API) ownsTenant(ctx context.Context, tenant string) bool {
+	listed := api.listedTenant(ctx)
+	return listed == "" || cmp.Or(tenant, api.Config.DefaultTenant) == listed
+}
+
+// sharedCatalog refuses callers acting for a single tenant a change to the
+// satellite catalog, which every tenant reads.
+func (api *API) sharedCatalog(c *gin.Context) {
+	if api.listedTenant(c.Request.Context()) != "" {
+		respondError(c, http.StatusForbidden, CodeForbidden, "the satellite catalog is shared by every tenant, so only ADMIN_TOKEN may change it")
+		return
+	}
+	c.Next()
+}
+
+// tenantEvents narrows filter, which may be nil, to the events of ctx's
+// tenant.
+func tenantEvents(ctx context.Context, filter func(Event) bool) func(Event) bool {
+	tenant := tenantFrom(ctx)
+	if tenant == "" {
+		return filter
+	}
+	return func(e Event) bool {
+		return e.Tenant == tenant && (filter == nil || filter(e))
+	}
+}
+
+// tenantStore keeps each tenant's objects under its own prefix.
+type tenantStore struct {
+	ObjectStore
+}
+
+func (s tenantStore) key(ctx context.Context, key *string) *string {
+	return aws.String(tenantObjectKey(ctx, aws.ToString(key)))
+}
+
+// strip returns the tenant's key of a bucket key listed or deleted.
+func (s tenantStore) strip(ctx context.Context, key *string) *string {
+	if key == nil {
+		return nil
+	}
+	return aws.String(strings.TrimPrefix(*key, tenantObjectKey(ctx, "")))
+}
+
+func (s tenantStore) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if tenantFrom(ctx) != "" {
+		scoped := *in
+		scoped.Key = s.key(ctx, in.Key)
+		in = &scoped
+	}
+	return s.ObjectStore.GetObject(ctx, in, optFns...)
+}
+
+func (s tenantStore) HeadObject(ctx context.Context, in *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if tenantFrom(ctx) != "" {
+		scoped := *in
+		scoped.Key = s.key(ctx, in.Key)
+		in = &scoped
+	}
+	return s.ObjectStore.HeadObject(ctx, in, optFns...)
+}
+
+func (s tenantStore) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if tenantFrom(ctx) != "" {
+		scoped := *in
+		scoped.Key = s.key(ctx, in.Key)
+		in = &scoped
+	}
+	return s.ObjectStore.PutObject(ctx, in, optFns...)
+}
+
+// CopyObject copies within the tenant: CopySource, "bucket/key", names one
+// of its keys.
+func (s tenantStore) CopyObject(ctx context.Context, in *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	if tenantFrom(ctx) != "" {
+		scoped := *in
+		scoped.Key = s.key(ctx, in.Key)
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(aws.ToString(in.CopySource), "/"), "/")
+		scoped.CopySource = aws.String(bucket + "/" + tenantObjectKey(ctx, key))
+		in = &scoped
+	}
+	return s.ObjectStore.CopyObject(ctx, in, optFns...)
+}
+
+func (s tenantStore) RestoreObject(ctx context.Context, in *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error) {
+	if tenantFrom(ctx) != "" {
+		scoped := *in
+		scoped.Key = s.key(ctx, in.Key)
+		in = &scoped
+	}
+	return s.ObjectStore.RestoreObject(ctx, in, optFns...)
+}
+
+func (s tenantStore) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if tenantFrom(ctx) == "" || in.Delete == nil {
+		return s.ObjectStore.DeleteObjects(ctx, in, optFns...)
+	}
+	scoped, del := *in, *in.Delete
+	del.Objects = make([]s3types.ObjectIdentifier, len(in.Delete.Objects))
+	for i, obj := range in.Delete.Objects {
+		obj.Key = s.key(ctx, obj.Key)
+		del.Objects[i] = obj
+	}
+	scoped.Delete = &del
+	out, err := s.ObjectStore.DeleteObjects(ctx, &scoped, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out.Deleted {
+		out.Deleted[i].Key = s.strip(ctx, out.Deleted[i].Key)
+	}
+	for i := range out.Errors {
+		out.Errors[i].Key = s.strip(ctx, out.Errors[i].Key)
+	}
+	return out, nil
+}
+
+// ListObjectsV2 lists the tenant's keys under Prefix, with the tenant's
+// prefix taken off them again.
+func (s tenantStore) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if tenantFrom(ctx) == "" {
+		return s.ObjectStore.ListObjectsV2(ctx, in, optFns...)
+	}
+	scoped := *in
+	scoped.Prefix = s.key(ctx, in.Prefix)
+	if in.StartAfter != nil {
+		scoped.StartAfter = s.key(ctx, in.StartAfter)
+	}
+	out, err := s.ObjectStore.ListObjectsV2(ctx, &scoped, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.Prefix = in.Prefix
+	out.StartAfter = in.StartAfter
+	for i := range out.Contents {
+		out.Contents[i].Key = s.strip(ctx, out.Contents[i].Key)
+	}
+	for i := range out.CommonPrefixes {
+		out.CommonPrefixes[i].Prefix = s.strip(ctx, out.CommonPrefixes[i].Prefix)
+	}
+	return out, nil
+}
+
+// tenantMissionStore keys each tenant's missions by tenantRecordID.
+type tenantMissionStore struct {
+	MissionStore
+}
+
+func (s tenantMissionStore) Mission(ctx context.Context, id string) (*Mission, error) {
+	if tenantFrom(ctx) == "" {
+		return s.MissionStore.Mission(ctx, id)
+	}
+	m, err := s.MissionStore.Mission(ctx, tenantRecordID(ctx, id))
+	if err != nil {
+		return nil, err
+	}
+	return m.withID(id), nil
+}
+
+// Missions reads pages of the whole table until it has limit of the
+// tenant's missions or reaches the end, so one page of a table shared by
+// many tenants may take several reads.
+func (s tenantMissionStore) Missions(ctx context.Context, limit int32, token string) ([]Mission, string, error) {
+	if tenantFrom(ctx) == "" {
+		return s.MissionStore.Missions(ctx, limit, token)
+	}
+	prefix := tenantRecordID(ctx, "")
+	found := make([]Mission, 0, limit)
+	for {
+		page, next, err := s.MissionStore.Missions(ctx, limit-int32(len(found)), token)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, m := range page {
+			if id, ok := strings.CutPrefix(m.ID, prefix); ok {
+				m.ID = id
+				found = append(found, m)
+			}
+		}
+		token = next
+		if token == "" || int32(len(found)) >= limit {
+			return found, token, nil
+		}
+	}
+}
+
+func (s tenantMissionStore) LinkImage(ctx context.Context, missionID, imageID string) (bool, error) {
+	return s.MissionStore.LinkImage(ctx, tenantRecordID(ctx, missionID), imageID)
+}
+
+func (s tenantMissionStore) UnlinkImage(ctx context.Context, missionID, imageID string) (bool, error) {
+	return s.MissionStore.UnlinkImage(ctx, tenantRecordID(ctx, missionID), imageID)
+}
+
+func (s tenantMissionStore) SetGeometry(ctx context.Context, id string, g MissionGeometry) error {
+	return s.MissionStore.SetGeometry(ctx, tenantRecordID(ctx, id), g)
+}
+
+func (s tenantMissionStore) SetSchedule(ctx context.Context, id string, schedule *ScheduleAssignment) error {
+	return s.MissionStore.SetSchedule(ctx, tenantRecordID(ctx, id), schedule)
+}
+
+func (s tenantMissionStore) SetStatus(ctx context.Context, id, status string, allowed func(current string) bool) error {
+	return s.MissionStore.SetStatus(ctx, tenantRecordID(ctx, id), status, allowed)
+}
+
+// tenantImageStore keys each tenant's image records by tenantRecordID.
+type tenantImageStore struct {
+	ImageStore
+}
+
+func (s tenantImageStore) ImageRecord(ctx context.Context, id string) (*ImageRecord, error) {
+	rec, err := s.ImageStore.ImageRecord(ctx, tenantRecordID(ctx, id))
+	if rec != nil {
+		scoped := *rec
+		scoped.ID = id
+		rec = &scoped
+	}
+	return rec, err
+}
+
+func (s tenantImageStore) ImageRecords(ctx context.Context, ids []string) (map[string]*ImageRecord, error) {
+	if tenantFrom(ctx) == "" {
+		return s.ImageStore.ImageRecords(ctx, ids)
+	}
+	scoped := make([]string, len(ids))
+	for i, id := range ids {
+		scoped[i] = tenantRecordID(ctx, id)
+	}
+	raw, err := s.ImageStore.ImageRecords(ctx, scoped)
+	if err != nil {
+		return nil, err
+	}
+	prefix := tenantRecordID(ctx, "")
+	records := make(map[string]*ImageRecord, len(raw))
+	for key, rec := range raw {
+		id := strings.TrimPrefix(key, prefix)
+		scoped := *rec
+		scoped.ID = id
+		records[id] = &scoped
+	}
+	return records, nil
+}
+
+// ListImageRecords reads pages of the whole table until it has limit of
+// the tenant's records or reaches the end, as Missions does.
+func (s tenantImageStore) ListImageRecords(ctx context.Context, limit int32, token string) ([]ImageRecord, string, error) {
+	if tenantFrom(ctx) == "" {
+		return s.ImageStore.ListImageRecords(ctx, limit, token)
+	}
+	prefix := tenantRecordID(ctx, "")
+	found := make([]ImageRecord, 0, limit)
+	for {
+		page, next, err := s.ImageStore.ListImageRecords(ctx, limit-int32(len(found)), token)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, rec := range page {
+			if id, ok := strings.CutPrefix(rec.ID, prefix); ok {
+				rec.ID = id
+				found = append(found, rec)
+			}
+		}
+		token = next
+		if token == "" || int32(len(found)) >= limit {
+			return found, token, nil
+		}
+	}
+}
+
+func (s tenantImageStore) SetImageAttribute(ctx context.Context, id, name string, value any) error {
+	return s.ImageStore.SetImageAttribute(ctx, tenantRecordID(ctx, id), name, value)
+}
+
+// scopeTenants wraps the stores to keep tenants apart when MULTI_TENANT is
+// set, and returns them as they are otherwise.
+func scopeTenants(cfg *Config, store ObjectStore, missions MissionStore, images ImageStore) (ObjectStore, MissionStore, ImageStore) {
+	if !cfg.MultiTenant {
+		return store, missions, images
+	}
+	return tenantStore{store}, tenantMissionStore{missions}, tenantImageStore{images}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestResolveTenant(t *testing.T) {
+	cfg := &Config{MultiTenant: true, DefaultTenant: "default"}
+	acme := &Principal{ID: "key1", Tenant: "acme"}
+	tests := []struct {
+		name      string
+		cfg       *Config
+		p         *Principal
+		requested string
+		want      string
+		status    int
+	}{
+		{"single tenant", &Config{}, acme, "other", "", 0},
+		{"own tenant", cfg, acme, "", "acme", 0},
+		{"own tenant named", cfg, acme, "acme", "acme", 0},
+		{"other tenant", cfg, acme, "other", "", http.StatusForbidden},
+		{"anonymous", cfg, nil, "", "default", 0},
+		{"anonymous naming default", cfg, nil, "default", "default", 0},
+		{"anonymous naming other", cfg, nil, "acme", "", http.StatusForbidden},
+		{"no tenant on key", cfg, &Principal{ID: "key2"}, "", "default", 0},
+		{"admin", cfg, adminPrincipal, "", "default", 0},
+		{"admin naming tenant", cfg, adminPrincipal, "acme", "acme", 0},
+		{"invalid name", cfg, adminPrincipal, "Acme/x", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveTenant(tt.cfg, tt.p, tt.requested)
+			if tt.status != 0 {
+				if err == nil || problemFor(err).Status != tt.status {
+					t.Fatalf("err = %v, want status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("resolveTenant = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantStore(t *testing.T) {
+	fs, err := newFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := tenantStore{fs}
+	acme := withTenant(context.Background(), "acme")
+	globex := withTenant(context.Background(), "globex")
+	for _, ctx := range []context.Context{acme, globex} {
+		if _, err := store.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("img1"),
+			Body:   strings.NewReader(tenantFrom(ctx)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := store.GetObject(acme, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("img1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(out.Body)
+	out.Body.Close()
+	if string(body) != "acme" {
+		t.Errorf("acme's img1 = %q", body)
+	}
+
+	list, err := store.ListObjectsV2(globex, &s3.ListObjectsV2Input{Bucket: aws.String("bucket"), Prefix: aws.String("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range list.Contents {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	if !slices.Equal(keys, []string{"img1"}) {
+		t.Errorf("globex lists %v", keys)
+	}
+
+	raw, err := store.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("bucket"), Prefix: aws.String(tenantObjectRoot)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = nil
+	for _, obj := range raw.Contents {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	if want := []string{"tenants/acme/img1", "tenants/globex/img1"}; !slices.Equal(keys, want) {
+		t.Errorf("bucket holds %v, want %v", keys, want)
+	}
+}
+
+func TestTenantMissionStore(t *testing.T) {
+	store := tenantMissionStore{missionMap{
+		"acme/m1":   {ID: "acme/m1"},
+		"acme/m2":   {ID: "acme/m2"},
+		"globex/m1": {ID: "globex/m1", Status: "Complete"},
+		"m0":        {ID: "m0"},
+	}}
+	acme := withTenant(context.Background(), "acme")
+	globex := withTenant(context.Background(), "globex")
+
+	missions, _, err := store.Missions(acme, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, m := range missions {
+		ids = append(ids, m.ID)
+	}
+	if !slices.Equal(ids, []string{"m1", "m2"}) {
+		t.Errorf("acme lists %v", ids)
+	}
+
+	m, err := store.Mission(globex, "m1")
+	if err != nil || m.ID != "m1" || m.Status != "Complete" {
+		t.Errorf("globex's m1 = %+v, %v", m, err)
+	}
+	if _, err := store.Mission(globex, "m2"); err != errMissionNotFound {
+		t.Errorf("globex's m2: err = %v", err)
+	}
+	if m, err := store.Mission(context.Background(), "acme/m1"); err != nil || m.ID != "acme/m1" {
+		t.Errorf("unscoped acme/m1 = %+v, %v", m, err)
+	}
+}
+
+func TestTenantAdminRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{
+		Config:   &Config{AdminToken: "secret", MultiTenant: true, DefaultTenant: defaultTenant},
+		Keys:     newAPIKeyStore(nil, ""),
+		Webhooks: newWebhookStore(nil, "", defaultWebhookAttempts),
+		Roles:    newRoleStore(nil, ""),
+		Audit:    newAuditLog(nil, ""),
+	}
+	router := gin.New()
+	router.Use(api.auditRequests())
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	router.GET("/admin/audit", api.require(ScopeAdmin), api.getAudit)
+	do := func(method, path, body, token, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	create := func(tenant, scope string) APIKey {
+		t.Helper()
+		w := do(http.MethodPost, "/api-keys", `{"scopes": ["`+scope+`"]}`, "secret", tenant)
+		var key APIKey
+		if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &key) != nil || key.Tenant != tenant {
+			t.Fatalf("create %s key: %d %s", tenant, w.Code, w.Body)
+		}
+		return key
+	}
+	acme, globex := create("acme", ScopeAdmin), create("globex", ScopeAdmin)
+	viewer := create("globex", ScopeImagesRead)
+
+	w := do(http.MethodPost, "/webhooks", `{"url": "https://acme.example.com/hook"}`, acme.Key, "")
+	var hook Webhook
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &hook) != nil {
+		t.Fatalf("create webhook: %d %s", w.Code, w.Body)
+	}
+	for _, subject := range []string{"user-1", viewer.ID} {
+		if w := do(http.MethodPut, "/role-assignments/"+subject, `{"roles": ["admin"]}`, acme.Key, ""); w.Code != http.StatusOK {
+			t.Fatalf("assign %s: %d %s", subject, w.Code, w.Body)
+		}
+	}
+
+	// globex's admin sees none of acme's keys, webhooks or assignments.
+	for _, r := range []struct{ method, path, body string }{
+		{http.MethodGet, "/api-keys/" + acme.ID, ""},
+		{http.MethodDelete, "/api-keys/" + acme.ID, ""},
+		{http.MethodGet, "/webhooks/" + hook.ID, ""},
+		{http.MethodDelete, "/webhooks/" + hook.ID, ""},
+		{http.MethodGet, "/webhooks/" + hook.ID + "/deliveries", ""},
+		{http.MethodGet, "/role-assignments/user-1", ""},
+		{http.MethodPut, "/role-assignments/user-1", `{"roles": ["viewer"]}`},
+		{http.MethodDelete, "/role-assignments/user-1", ""},
+	} {
+		if w := do(r.method, r.path, r.body, globex.Key, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s as globex: %d %s", r.method, r.path, w.Code, w.Body)
+		}
+	}
+	var keys APIKeyListResponse
+	if w := do(http.MethodGet, "/api-keys", "", globex.Key, ""); json.Unmarshal(w.Body.Bytes(), &keys) != nil || len(keys.APIKeys) != 2 {
+		t.Errorf("globex keys: %d %s", w.Code, w.Body)
+	}
+	var hooks WebhookListResponse
+	if w := do(http.MethodGet, "/webhooks", "", globex.Key, ""); json.Unmarshal(w.Body.Bytes(), &hooks) != nil || len(hooks.Webhooks) != 0 {
+		t.Errorf("globex webhooks: %d %s", w.Code, w.Body)
+	}
+	var assignments RoleAssignmentListResponse
+	if w := do(http.MethodGet, "/role-assignments", "", globex.Key, ""); json.Unmarshal(w.Body.Bytes(), &assignments) != nil || len(assignments.Assignments) != 0 {
+		t.Errorf("globex assignments: %d %s", w.Code, w.Body)
+	}
+	// Roles acme assigned to globex's key grant it nothing.
+	if w := do(http.MethodGet, "/api-keys", "", viewer.Key, ""); w.Code != http.StatusForbidden {
+		t.Errorf("viewer assigned admin by acme: status = %d", w.Code)
+	}
+	var audit AuditResponse
+	if w := do(http.MethodGet, "/admin/audit", "", globex.Key, ""); json.Unmarshal(w.Body.Bytes(), &audit) != nil || len(audit.Entries) == 0 {
+		t.Fatalf("globex audit: %d %s", w.Code, w.Body)
+	}
+	for _, e := range audit.Entries {
+		if e.Tenant != "globex" {
+			t.Errorf("globex audit has %+v", e)
+		}
+	}
+
+	// ADMIN_TOKEN sees and changes every tenant's.
+	if w := do(http.MethodGet, "/api-keys", "", "secret", ""); json.Unmarshal(w.Body.Bytes(), &keys) != nil || len(keys.APIKeys) != 3 {
+		t.Errorf("every key: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/admin/audit", "", "secret", ""); json.Unmarshal(w.Body.Bytes(), &audit) != nil || !slices.ContainsFunc(audit.Entries, func(e AuditEntry) bool { return e.Tenant == "acme" }) {
+		t.Errorf("every audit entry: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/webhooks/"+hook.ID, "", "secret", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete acme's webhook with ADMIN_TOKEN: status = %d", w.Code)
+	}
+}
+
+// TestTenantListings fills every store the API exposes for two tenants and
+// checks that nothing either one lists or reads names the other.
+func TestTenantListings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	fs := testFSStore(t)
+	meta := testSQLStore(t)
+	cfg := &Config{
+		AdminToken: "secret", MultiTenant: true, DefaultTenant: defaultTenant, ImagesBucket: "bucket",
+		IngestMissionPattern: regexp.MustCompile(defaultIngestMissionPattern), Duplicates: duplicatesKeep, DuplicateDistance: defaultDuplicateDistance,
+		Limits: RequestLimits{MaxDimension: defaultMaxOutputDimension, MaxCropPixels: defaultMaxImagePixels, MaxBodyBytes: defaultMaxBodyBytes, MaxOps: defaultMaxRequestOps},
+	}
+	store, missions, images := scopeTenants(cfg, fs, meta, meta)
+	api := &API{
+		Config:       cfg,
+		MissionDB:    missions,
+		Images:       images,
+		S3:           store,
+		Limiter:      newProcessLimiter(0, defaultQueueTimeout),
+		Events:       newEventBus(),
+		Jobs:         newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers),
+		Webhooks:     newWebhookStore(nil, "", defaultWebhookAttempts),
+		Keys:         newAPIKeyStore(nil, ""),
+		Roles:        newRoleStore(nil, ""),
+		Audit:        newAuditLog(nil, ""),
+		Usage:        newUsageMeter(nil, ""),
+		Storage:      newStorageMeter(nil, ""),
+		Satellites:   newSatelliteStore(nil, ""),
+		TLEs:         newTLEStore(nil, ""),
+		Sensors:      newSensorStore(nil, ""),
+		Downlinks:    newDownlinkStore(nil, ""),
+		History:      newMissionHistory(nil, ""),
+		Hashes:       newImageHashIndex(nil, ""),
+		Footprints:   newFootprintIndex(nil, ""),
+		Conjunctions: newConjunctionStore(nil, ""),
+	}
+	api.Satellites.Put(ctx, Satellite{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive})
+	api.Jobs.Register("process", api.runProcessJob)
+	router := gin.New()
+	router.Use(api.meterUsage(), api.auditRequests())
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	router.GET("/admin/audit", api.require(ScopeAdmin), api.getAudit)
+	router.GET("/admin/storage", api.require(ScopeAdmin), api.getStorageReport)
+	do := func(method, path, body, token, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	frame, err := seedImage(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants := []string{"acme", "globex"}
+	// markers are what a tenant's data can be recognised by: its name,
+	// which its IDs, names and labels carry, and its job's ID.
+	keys, markers := map[string]string{}, map[string][]string{}
+	for _, tenant := range tenants {
+		tctx := withTenant(ctx, tenant)
+		mission := tenant + "sat"
+		if err := putMission(ctx, meta, &Mission{ID: tenantRecordID(tctx, mission), Name: tenant + " survey", Status: "active",
+			ObserverSatelliteID: "iss", TargetSatelliteID: "iss", ImageIDs: []string{}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.PutObject(tctx, &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String(imageKey(mission + "-01")), Body: bytes.NewReader(frame)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ingestImage(tctx, mission+"-01"); err != nil {
+			t.Fatal(err)
+		}
+
+		w := do(http.MethodPost, "/api-keys", `{"name": "`+tenant+` admin", "scopes": ["admin"]}`, "secret", tenant)
+		var key APIKey
+		if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &key) != nil {
+			t.Fatalf("create %s key: %d %s", tenant, w.Code, w.Body)
+		}
+		keys[tenant] = key.Key
+		markers[tenant] = []string{tenant}
+		cdm := strings.Replace(testCDM, "MESSAGE_ID = 201113719185", "MESSAGE_ID = "+tenant+"-cdm", 1)
+		for _, r := range []struct{ method, path, body string }{
+			{http.MethodPost, "/cdm", cdm},
+			{http.MethodPost, "/webhooks", `{"url": "https://` + tenant + `.example.com/hook"}`},
+			{http.MethodPut, "/role-assignments/" + tenant + "-user", `{"roles": ["viewer"]}`},
+			{http.MethodPost, "/mission/" + mission + "/downlinks", `{"ground_station": "` + tenant + `-station", "pass_start": 1, "pass_end": 2}`},
+			{http.MethodPut, "/image/" + mission + "-01/annotations", `{"annotations": [{"type": "text", "x": 1, "y": 1, "label": "` + tenant + `"}]}`},
+		} {
+			if w := do(r.method, r.path, r.body, key.Key, ""); w.Code >= 300 {
+				t.Fatalf("%s %s as %s: %d %s", r.method, r.path, tenant, w.Code, w.Body)
+			}
+		}
+		w = do(http.MethodPost, "/jobs/process", `{"ids": ["`+mission+`-01"], "spec": {"width": 16}}`, key.Key, "")
+		var job JobAccepted
+		if w.Code != http.StatusAccepted || json.Unmarshal(w.Body.Bytes(), &job) != nil || job.JobID == "" {
+			t.Fatalf("POST /jobs/process as %s: %d %s", tenant, w.Code, w.Body)
+		}
+		markers[tenant] = append(markers[tenant], job.JobID)
+	}
+	leaks := func(body, tenant string) bool {
+		return slices.ContainsFunc(markers[tenant], func(m string) bool { return strings.Contains(body, m) })
+	}
+
+	for i, tenant := range tenants {
+		other := tenants[1-i]
+		own := tenant + "sat"
+		for _, path := range []string{
+			"/missions",
+			"/downlinks/overdue",
+			"/mission/" + own + "/downlinks",
+			"/mission/" + own + "/timeline",
+			"/mission/" + own + "/images",
+			"/conjunctions",
+			"/images/search?bbox=0,0,1,1",
+			"/images/similar/" + own + "-01",
+			"/stac/collections",
+			"/stac/search",
+			"/jobs",
+			"/webhooks",
+			"/api-keys",
+			"/role-assignments",
+			"/usage",
+			"/admin/audit",
+			"/admin/storage",
+		} {
+			w := do(http.MethodGet, path, "", keys[tenant], "")
+			if w.Code != http.StatusOK {
+				t.Errorf("GET %s as %s: %d %s", path, tenant, w.Code, w.Body)
+			}
+			if leaks(w.Body.String(), other) {
+				t.Errorf("GET %s as %s shows %s's data:\n%s", path, tenant, other, w.Body)
+			}
+		}
+		// The other's image IDs are just names to an image route, but
+		// nothing stored under them may come back.
+		if w := do(http.MethodGet, "/image/"+other+"sat-01/annotations", "", keys[tenant], ""); strings.Contains(w.Body.String(), `"label"`) {
+			t.Errorf("%s reads %s's annotations: %s", tenant, other, w.Body)
+		}
+		for _, path := range []string{
+			"/mission/" + other + "sat",
+			"/mission/" + other + "sat/downlinks",
+			"/image/" + other + "sat-01/metadata",
+			"/conjunction/" + other + "-cdm",
+		} {
+			if w := do(http.MethodGet, path, "", keys[tenant], ""); w.Code != http.StatusNotFound {
+				t.Errorf("GET %s as %s: %d %s", path, tenant, w.Code, w.Body)
+			}
+		}
+		// Each tenant does see its own.
+		for _, path := range []string{"/missions", "/conjunctions", "/jobs", "/webhooks", "/role-assignments", "/mission/" + own + "/downlinks", "/image/" + own + "-01/annotations"} {
+			if w := do(http.MethodGet, path, "", keys[tenant], ""); !leaks(w.Body.String(), tenant) {
+				t.Errorf("GET %s as %s lists nothing of its own:\n%s", path, tenant, w.Body)
+			}
+		}
+	}
+
+	// The catalog is shared, so only ADMIN_TOKEN may change it.
+	for _, r := range []struct{ method, path, body string }{
+		{http.MethodPost, "/satellites", `{"norad_id": 5, "name": "Vanguard 1"}`},
+		{http.MethodPut, "/satellite/iss/sensor", `{"focal_length_mm": 100, "pixel_pitch_um": 5, "width": 1024, "height": 1024, "bit_depth": 12}`},
+		{http.MethodDelete, "/satellite/iss/sensor", ""},
+	} {
+		if w := do(r.method, r.path, r.body, keys["acme"], ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s as acme: %d %s", r.method, r.path, w.Code, w.Body)
+		}
+	}
+	if w := do(http.MethodPost, "/satellites", `{"norad_id": 5, "name": "Vanguard 1"}`, "secret", ""); w.Code != http.StatusCreated {
+		t.Errorf("POST /satellites with ADMIN_TOKEN: %d %s", w.Code, w.Body)
+	}
+}
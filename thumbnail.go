@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultThumbnailSize = 256
+	thumbnailQuality     = 85
+	// Imagery is served only to authorised clients, so shared caches must
+	// not keep copies of thumbnails or tiles.
+	thumbnailCacheControl = "private, max-age=86400"
+	thumbnailContentType  = "image/jpeg"
+)
+
+var thumbnailSizes = map[int]bool{64: true, 128: true, 256: true, 512: true}
+
+// thumbnailKey is where the size-px variant of id is stored, under a
+// directory of its own when it carries an overlay.
+func thumbnailKey(id string, size int, overlay *OverlaySpec) string {
+	if v := overlay.variant(); v != "" {
+		return fmt.Sprintf("thumbnails/%s/%d/%s.jpg", v, size, id)
+	}
+	return fmt.Sprintf("thumbnails/%d/%s.jpg", size, id)
+}
+
+func isNotFound(err error) bool {
+	var noSuchKey *s3types.NoSuchKey
+	var notFound *s3types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+// isInvalidRange says whether a read failed for its Range header, because no
+// byte of it is in the object or it is malformed.
+func isInvalidRange(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ErrorCode()
+	return code == "InvalidRange" || code == "InvalidArgument"
+}
+
+// getThumbnail serves a small JPEG variant of an image. Variants are generated
+// on first request and stored under thumbnails/ so later requests are a single
+// S3 read.
+func (api *API) getThumbnail(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	size := defaultThumbnailSize
+	if sizeStr := c.Query("size"); sizeStr != "" {
+		parsed, err := strconv.Atoi(sizeStr)
+		if err != nil || !thumbnailSizes[parsed] {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'size' parameter. Must be one of 64, 128, 256, 512.")
+			return
+		}
+		size = parsed
+	}
+
+	ctx := c.Request.Context()
+	thumbKey := thumbnailKey(id, size, api.Overlay)
+	if obj, ok := api.Memory.Get(tenantObjectKey(ctx, thumbKey)); ok {
+		serveCachedObject(c, obj, "memory")
+		return
+	}
+
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(thumbKey),
+	})
+	if err == nil {
+		defer out.Body.Close()
+		// Thumbnails are small enough to buffer, which lets the bytes be
+		// kept in memory for the next request.
+		data, err := io.ReadAll(out.Body)
+		if err != nil {
+			slog.ErrorContext(ctx, "reading failed", "key", thumbKey, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read image")
+			return
+		}
+		obj := cachedObject{Data: data, ContentType: thumbnailContentType, ETag: aws.ToString(out.ETag), CacheControl: thumbnailCacheControl}
+		api.Memory.Add(tenantObjectKey(ctx, thumbKey), obj)
+		serveCachedObject(c, obj, "miss")
+		return
+	}
+	if !isNotFound(err) {
+		slog.ErrorContext(ctx, "s3 GetObject failed", "key", thumbKey, "err", err)
+	}
+
+	data, err := api.generateThumbnail(ctx, bucketName, id, size, api.Overlay)
+	if err != nil {
+		if isNotFound(err) {
+			respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+			return
+		}
+		if isColdObject(err) {
+			api.respondCold(c, id)
+			return
+		}
+		if errors.Is(err, errOverloaded) {
+			respondOverloaded(c)
+			return
+		}
+		if errors.Is(err, errImageTooLarge) {
+			respondError(c, http.StatusUnprocessableEntity, CodeImageTooLarge, imageTooLargeMessage())
+			return
+		}
+		slog.ErrorContext(ctx, "failed to generate thumbnail", "id", id, "size", size, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeImageDecodeFailed, "failed to process image")
+		return
+	}
+
+	api.Memory.Add(tenantObjectKey(ctx, thumbKey), cachedObject{Data: data, ContentType: thumbnailContentType, CacheControl: thumbnailCacheControl})
+	c.Header("Cache-Control", thumbnailCacheControl)
+	c.Data(http.StatusOK, thumbnailContentType, data)
+}
+
+// generateThumbnail renders the size-px variant of id from the original,
+// stamped with overlay if it is non-nil, and stores it back to S3.
+func (api *API) generateThumbnail(ctx context.Context, bucketName, id string, size int, overlay *OverlaySpec) ([]byte, error) {
+	key := imageKey(id)
+
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+		Range:  firstPart(),
+	}, s3Accelerate(bucketName)...)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	buf, err := api.readObject(ctx, bucketName, key, out, out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", key, err)
+	}
+	defer putBuffer(buf)
+	raw := buf.Bytes()
+	pixels, err := sourcePixels(raw)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", key, err)
+	}
+	release, err := api.Limiter.Acquire(ctx, pixels*bytesPerDecodedPixel)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	src, err := imaging.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", key, err)
+	}
+
+	var thumb image.Image = imaging.Fit(src, size, size, imaging.Lanczos)
+	if overlay != nil {
+		thumb = drawOverlay(thumb, overlay)
+	}
+	data, err := api.putJPEG(ctx, bucketName, thumbnailKey(id, size, overlay), thumb, thumbnailQuality)
+	if err != nil && data != nil {
+		// The variant is still servable; the next request will retry the write.
+		slog.ErrorContext(ctx, "failed to store thumbnail", "id", id, "size", size, "err", err)
+		return data, nil
+	}
+	return data, err
+}
+
+// thumbnailBytes returns the stored size-px variant of id, generating it first
+// if needed. It is unmarked, for composites that carry the overlay once as a
+// whole.
+func (api *API) thumbnailBytes(ctx context.Context, bucketName, id string, size int) ([]byte, error) {
+	key := thumbnailKey(id, size, nil)
+	if obj, ok := api.Memory.Get(tenantObjectKey(ctx, key)); ok {
+		return obj.Data, nil
+	}
+
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		defer out.Body.Close()
+		data, err := io.ReadAll(out.Body)
+		if err == nil {
+			api.Memory.Add(tenantObjectKey(ctx, key), cachedObject{Data: data, ContentType: thumbnailContentType, ETag: aws.ToString(out.ETag), CacheControl: thumbnailCacheControl})
+		}
+		return data, err
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+	return api.generateThumbnail(ctx, bucketName, id, size, nil)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetThumbnail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, store, imageKey("a"), frame)
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	router := gin.New()
+	router.GET("/image/:id/thumbnail", api.getThumbnail)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/image/a/thumbnail?size=128")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != thumbnailContentType {
+		t.Fatalf("thumbnail: %d %s", w.Code, w.Body)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+	if err != nil || format != "jpeg" || cfg.Width != 128 || cfg.Height != 128 {
+		t.Errorf("thumbnail is a %dx%d %s: %v", cfg.Width, cfg.Height, format, err)
+	}
+	out, err := store.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(thumbnailKey("a", 128, nil))})
+	if err != nil {
+		t.Fatalf("thumbnail not stored: %v", err)
+	}
+	out.Body.Close()
+
+	// Later requests read the stored variant rather than render again.
+	putTestObject(t, store, thumbnailKey("a", 128, nil), []byte("stored"))
+	if w := get("/image/a/thumbnail?size=128"); w.Code != http.StatusOK || w.Body.String() != "stored" {
+		t.Errorf("second request: %d %q", w.Code, w.Body)
+	}
+
+	for path, want := range map[string]int{
+		"/image/a/thumbnail":          http.StatusOK,
+		"/image/a/thumbnail?size=100": http.StatusBadRequest,
+		"/image/a/thumbnail?size=big": http.StatusBadRequest,
+		"/image/gone/thumbnail":       http.StatusNotFound,
+	} {
+		if w := get(path); w.Code != want {
+			t.Errorf("GET %s: %d, want %d", path, w.Code, want)
+		}
+	}
+}
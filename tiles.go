@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+const tileUploadConcurrency = 8
+
+// tileKey is where a tile of id is stored, under a directory of its own when
+// the tiles carry an overlay.
+func tileKey(id string, overlay *OverlaySpec, z, x, y int) string {
+	if v := overlay.variant(); v != "" {
+		return fmt.Sprintf("tiles/%s/%s/%d/%d/%d.jpg", id, v, z, x, y)
+	}
+	return fmt.Sprintf("tiles/%s/%d/%d/%d.jpg", id, z, x, y)
+}
+
+// TileCount returns the number of tile columns and rows at zoom level z.
+func (m PyramidManifest) TileCount(z int) (int, int) {
+	w, h := m.LevelSize(z)
+	return (w + m.TileSize - 1) / m.TileSize, (h + m.TileSize - 1) / m.TileSize
+}
+
+// putTiles cuts level into TileSize squares, stamps each with the server's
+// overlay, and stores them. Tiles on the right and bottom edges are cropped to
+// the image rather than padded. origin is the tile column and row of level's
+// top-left corner, for a level that is stored a block at a time.
+func (api *API) putTiles(ctx context.Context, bucketName, id string, z int, level image.Image, origin image.Point) error {
+	b := level.Bounds()
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(tileUploadConcurrency)
+
+	for ty := 0; ty*tileSize < b.Dy(); ty++ {
+		for tx := 0; tx*tileSize < b.Dx(); tx++ {
+			rect := image.Rect(tx*tileSize, ty*tileSize, (tx+1)*tileSize, (ty+1)*tileSize).Add(b.Min).Intersect(b)
+			var tile image.Image = imaging.Crop(level, rect)
+			if api.Overlay != nil {
+				tile = drawOverlay(tile, api.Overlay)
+			}
+			key := tileKey(id, api.Overlay, z, origin.X+tx, origin.Y+ty)
+			g.Go(func() error {
+				_, err := api.putJPEG(ctx, bucketName, key, tile, pyramidQuality)
+				return err
+			})
+		}
+	}
+
+	return g.Wait()
+}
+
+func (api *API) loadPyramidManifest(ctx context.Context, bucketName, id string) (*PyramidManifest, error) {
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(pyramidManifestKey(id)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var manifest PyramidManifest
+	if err := json.NewDecoder(out.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// getTileManifest returns the pyramid description a viewer needs to configure
+// its tile source.
+func (api *API) getTileManifest(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	manifest, err := api.loadPyramidManifest(c.Request.Context(), bucketName, id)
+	if err != nil {
+		api.handleMissingPyramid(c, id, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, manifest)
+}
+
+// getTile serves /image/:id/tiles/:z/:x/:y.jpg in the XYZ scheme: level 0 is
+// a single tile holding the whole image and each level doubles the resolution.
+func (api *API) getTile(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	z, errZ := strconv.Atoi(c.Param("z"))
+	x, errX := strconv.Atoi(c.Param("x"))
+	y, errY := strconv.Atoi(strings.TrimSuffix(c.Param("y"), ".jpg"))
+	if errZ != nil || errX != nil || errY != nil || z < 0 || x < 0 || y < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid tile coordinates. z, x and y must be non-negative integers.")
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := tileKey(id, api.Overlay, z, x, y)
+
+	out, err := api.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if !isNotFound(err) {
+			slog.ErrorContext(ctx, "s3 GetObject failed", "key", key, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to retrieve tile")
+			return
+		}
+
+		// Distinguish a tile outside the pyramid from a pyramid that has not
+		// been generated yet.
+		manifest, err := api.loadPyramidManifest(ctx, bucketName, id)
+		if err != nil {
+			api.handleMissingPyramid(c, id, err)
+			return
+		}
+		if manifest.Overlay != api.Overlay.variant() {
+			// The pyramid was cut under a different overlay; regenerate it
+			// rather than serve tiles with the wrong marking.
+			api.queuePyramid(c, id)
+			return
+		}
+		cols, rows := manifest.TileCount(min(z, manifest.MaxZoom))
+		if z > manifest.MaxZoom || x >= cols || y >= rows {
+			respondError(c, http.StatusNotFound, CodeTileNotFound, "tile out of range")
+			return
+		}
+		slog.WarnContext(ctx, "tile missing from generated pyramid", "key", key)
+		respondError(c, http.StatusNotFound, CodeTileNotFound, "tile not found")
+		return
+	}
+	defer out.Body.Close()
+
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("Cache-Control", thumbnailCacheControl)
+	if out.ContentLength != nil {
+		c.Header("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	if out.ETag != nil {
+		c.Header("ETag", aws.ToString(out.ETag))
+	}
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, out.Body); err != nil {
+		slog.ErrorContext(ctx, "streaming failed", "key", key, "err", err)
+	}
+}
+
+// handleMissingPyramid queues generation when an image has no pyramid yet and
+// tells the client to retry shortly.
+func (api *API) handleMissingPyramid(c *gin.Context, id string, err error) {
+	if !isNotFound(err) {
+		slog.ErrorContext(c.Request.Context(), "failed to load pyramid manifest", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load tile pyramid")
+		return
+	}
+	api.queuePyramid(c, id)
+}
+
+// queuePyramid queues (re)generation of id's pyramid, if the image exists,
+// and tells the client to retry shortly.
+func (api *API) queuePyramid(c *gin.Context, id string) {
+	bucketName := api.Config.ImagesBucket
+	_, err := api.S3.HeadObject(c.Request.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(imageKey(id)),
+	})
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeImageNotFound, "object not found")
+		return
+	}
+
+	api.Derivatives.Enqueue(c.Request.Context(), id)
+	c.Header("Retry-After", "10")
+	respondError(c, http.StatusServiceUnavailable, CodeTilesPending, "tile pyramid is being generated")
+}
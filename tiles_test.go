@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTileCount(t *testing.T) {
+	m := PyramidManifest{Width: 1000, Height: 300, TileSize: tileSize, MaxZoom: maxZoom(1000, 300)}
+	for z, want := range [][2]int{{1, 1}, {2, 1}, {4, 2}} {
+		if cols, rows := m.TileCount(z); cols != want[0] || rows != want[1] {
+			t.Errorf("TileCount(%d) = %d×%d, want %d×%d", z, cols, rows, want[0], want[1])
+		}
+	}
+}
+
+func TestTileKey(t *testing.T) {
+	if got := tileKey("a", nil, 2, 3, 1); got != "tiles/a/2/3/1.jpg" {
+		t.Errorf("unmarked tile key %s", got)
+	}
+	overlay := &OverlaySpec{Text: "SECRET", Position: "top"}
+	if got := tileKey("a", overlay, 2, 3, 1); got != "tiles/a/"+overlay.variant()+"/2/3/1.jpg" || !strings.HasPrefix(overlay.variant(), "marked-") {
+		t.Errorf("marked tile key %s", got)
+	}
+}
+
+func TestGetTile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := testFSStore(t)
+	frame, err := seedImage(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestObject(t, store, imageKey("a"), frame)
+	putTestObject(t, store, imageKey("pending"), frame)
+	meta := testSQLStore(t)
+	api := &API{Config: &Config{ImagesBucket: "bucket"}, S3: store, MissionDB: meta, Images: meta, Limiter: newProcessLimiter(0, defaultQueueTimeout)}
+	api.Derivatives = newDerivativeWorker(api, nil, "", defaultDerivativeWorkers)
+	if err := api.generateDerivatives(context.Background(), "a", nil); err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/image/:id/tiles/:z/:x/:y", api.getTile)
+
+	tests := []struct {
+		path string
+		want int
+		code ErrorCode
+	}{
+		{"/image/a/tiles/0/0/0.jpg", http.StatusOK, ""},
+		{"/image/a/tiles/2/3/3.jpg", http.StatusOK, ""},
+		{"/image/a/tiles/2/4/0.jpg", http.StatusNotFound, CodeTileNotFound},
+		{"/image/a/tiles/3/0/0.jpg", http.StatusNotFound, CodeTileNotFound},
+		{"/image/a/tiles/1/-1/0.jpg", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/a/tiles/z/0/0.jpg", http.StatusBadRequest, CodeInvalidParameter},
+		{"/image/pending/tiles/0/0/0.jpg", http.StatusServiceUnavailable, CodeTilesPending},
+		{"/image/gone/tiles/0/0/0.jpg", http.StatusNotFound, CodeImageNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.want || tt.code != "" && !strings.Contains(w.Body.String(), string(tt.code)) {
+			t.Errorf("GET %s: %d %s, want %d %s", tt.path, w.Code, w.Body, tt.want, tt.code)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+			if err != nil || cfg.Width != tileSize || cfg.Height != tileSize {
+				t.Errorf("GET %s: a %dx%d tile, %v", tt.path, cfg.Width, cfg.Height, err)
+			}
+		}
+	}
+	if len(api.Derivatives.jobs) != 1 {
+		t.Errorf("%d pyramids queued, want 1", len(api.Derivatives.jobs))
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultTimelapseFPS = 5
+	maxTimelapseFPS     = 30
+	// Sequences longer than this are rendered as a background job.
+	timelapseSyncFrames = 24
+	timelapseMaxFrames  = 1000
+	timelapseMaxSide    = 1024
+)
+
+var errFFmpegUnavailable = errors.New("mp4 output requires ffmpeg on PATH")
+
+// timelapseSpec is both the render settings and the job's saved params.
+type timelapseSpec struct {
+	MissionID string   `json:"mission_id"`
+	FPS       int      `json:"fps"`
+	Format    string   `json:"format"`
+	Width     int      `json:"width,omitempty"`
+	ImageIDs  []string `json:"image_ids"`
+}
+
+type timelapseFrame struct {
+	id       string
+	captured time.Time
+}
+
+// getTimelapse assembles the mission's images, ordered by capture time, into
+// an animated GIF or MP4. Short sequences are returned directly; longer ones
+// (or ?async=true) start a job and return 202 with its handle.
+func (api *API) getTimelapse(c *gin.Context) {
+	bucketName := api.Config.ImagesBucket
+	id := c.Param("id")
+	if id == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "missing id")
+		return
+	}
+
+	spec := timelapseSpec{MissionID: id, FPS: defaultTimelapseFPS, Format: "gif"}
+
+	if fpsStr := c.Query("fps"); fpsStr != "" {
+		fps, err := strconv.Atoi(fpsStr)
+		if err != nil || fps <= 0 || fps > maxTimelapseFPS {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'fps' parameter. Must be an integer between 1 and 30.")
+			return
+		}
+		spec.FPS = fps
+	}
+
+	if format := c.Query("format"); format != "" {
+		if format != "gif" && format != "mp4" {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'format' parameter. Must be gif or mp4.")
+			return
+		}
+		spec.Format = format
+	}
+	if spec.Format == "mp4" {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			respondError(c, http.StatusNotImplemented, CodeFeatureUnavailable, errFFmpegUnavailable.Error())
+			return
+		}
+	}
+
+	if widthStr := c.Query("width"); widthStr != "" {
+		width, err := strconv.Atoi(widthStr)
+		if err != nil || width <= 0 || width > timelapseMaxSide {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'width' parameter. Must be an integer between 1 and 1024.")
+			return
+		}
+		spec.Width = width
+	}
+
+	async, _ := strconv.ParseBool(c.Query("async"))
+
+	ctx := c.Request.Context()
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+	if len(mission.ImageIDs) == 0 {
+		respondError(c, http.StatusNotFound, CodeMissionEmpty, "mission has no images")
+		return
+	}
+	if len(mission.ImageIDs) > timelapseMaxFrames {
+		respondError(c, http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("mission has more than %d images", timelapseMaxFrames))
+		return
+	}
+
+	spec.ImageIDs = mission.ImageIDs
+
+	if async || len(mission.ImageIDs) > timelapseSyncFrames {
+		job, err := api.Jobs.Submit(ctx, "timelapse", spec, nil)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to submit timelapse job", "mission", id, "err", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to start job")
+			return
+		}
+		c.JSON(http.StatusAccepted, newJobAccepted(job))
+		return
+	}
+
+	data, contentType, err := api.renderTimelapse(ctx, bucketName, spec, nil)
+	if err != nil {
+		if errors.Is(err, errOverloaded) {
+			respondOverloaded(c)
+			return
+		}
+		if errors.Is(err, errImageTooLarge) {
+			respondError(c, http.StatusUnprocessableEntity, CodeImageTooLarge, imageTooLargeMessage())
+			return
+		}
+		slog.ErrorContext(ctx, "failed to render timelapse", "mission", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeImageEncodeFailed, "failed to render timelapse")
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (api *API) runTimelapseJob(ctx context.Context, job Job) (string, string, error) {
+	bucketName := api.Config.ImagesBucket
+	var spec timelapseSpec
+	if err := json.Unmarshal(job.Params, &spec); err != nil {
+		return "", "", permanent(fmt.Errorf("decode params: %w", err))
+	}
+	api.Jobs.SetProgress(job.ID, 0)
+
+	data, contentType, err := api.renderTimelapse(ctx, bucketName, spec, func(p float64) {
+		api.Jobs.SetProgress(job.ID, p)
+	})
+	if err != nil {
+		if errors.Is(err, errFFmpegUnavailable) {
+			err = permanent(err)
+		}
+		return "", "", err
+	}
+
+	key := fmt.Sprintf("timelapse/%s/%s.%s", spec.MissionID, job.ID, spec.Format)
+	_, err = api.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "s3 PutObject failed", "key", key, "err", err)
+		return "", "", fmt.Errorf("store output: %w", err)
+	}
+	return key, contentType, nil
+}
+
+// renderTimelapse orders the frames by capture time, decodes and resizes them
+// to a common size, and encodes the animation. Each frame is handed to the
+// encoder as soon as it is ready, so no more than one is decoded at a time.
+// progress, if non-nil, is called after each frame with the fraction completed.
+func (api *API) renderTimelapse(ctx context.Context, bucketName string, spec timelapseSpec, progress func(float64)) ([]byte, string, error) {
+	frames := make([]timelapseFrame, len(spec.ImageIDs))
+	var g errgroup.Group
+	g.SetLimit(contactSheetFetchLimit)
+	for i, imageID := range spec.ImageIDs {
+		frames[i].id = imageID
+		g.Go(func() error {
+			t, err := api.captureTime(ctx, bucketName, imageID)
+			if err != nil {
+				return fmt.Errorf("head %s: %w", imageID, err)
+			}
+			frames[i].captured = t
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, "", err
+	}
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].captured.Before(frames[j].captured) })
+
+	var enc frameWriter
+	if spec.Format == "mp4" {
+		mp4, err := startMP4(ctx, spec.FPS)
+		if err != nil {
+			return nil, "", err
+		}
+		enc = mp4
+	} else {
+		enc = &gifWriter{delay: max(1, 100/spec.FPS)}
+	}
+
+	var size image.Point
+	for i, frame := range frames {
+		img, release, err := api.loadSourceImage(ctx, bucketName, frame.id)
+		if err != nil {
+			enc.Finish()
+			return nil, "", err
+		}
+
+		// The first frame fixes the output size; the rest are fitted to it.
+		if i == 0 {
+			switch {
+			case spec.Width > 0:
+				img = imaging.Resize(img, spec.Width, 0, imaging.Lanczos)
+			default:
+				img = imaging.Fit(img, timelapseMaxSide, timelapseMaxSide, imaging.Lanczos)
+			}
+			size = img.Bounds().Size()
+		} else {
+			img = imaging.Resize(img, size.X, size.Y, imaging.Lanczos)
+		}
+		release()
+		if api.Overlay != nil {
+			img = drawOverlay(img, api.Overlay)
+		}
+		if err := enc.WriteFrame(img); err != nil {
+			enc.Finish()
+			return nil, "", err
+		}
+
+		if progress != nil {
+			progress(0.9 * float64(i+1) / float64(len(frames)))
+		}
+	}
+
+	data, err := enc.Finish()
+	if spec.Format == "mp4" {
+		return data, "video/mp4", err
+	}
+	return data, "image/gif", err
+}
+
+// frameWriter encodes an animation one frame at a time, so that a sequence
+// holds only its encoded output rather than every decoded frame.
+type frameWriter interface {
+	WriteFrame(img image.Image) error
+	// Finish returns the encoded animation. It must be called even after a
+	// failed WriteFrame, to release the encoder.
+	Finish() ([]byte, error)
+}
+
+// gifHeaderLen is the length of the header, logical screen descriptor and
+// Plan9 global color table that gif.EncodeAll writes ahead of the first frame.
+const gifHeaderLen = 13 + 3*256
+
+// gifWriter assembles an animated GIF from single-frame encodings, which all
+// share the global Plan9 palette and so differ only in their image blocks.
+type gifWriter struct {
+	buf   bytes.Buffer
+	delay int
+}
+
+func (w *gifWriter) WriteFrame(img image.Image) error {
+	b := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, b.Min)
+
+	var one bytes.Buffer
+	err := gif.EncodeAll(&one, &gif.GIF{
+		Image:  []*image.Paletted{paletted},
+		Delay:  []int{w.delay},
+		Config: image.Config{ColorModel: color.Palette(palette.Plan9), Width: b.Dx(), Height: b.Dy()},
+	})
+	if err != nil {
+		return err
+	}
+	data := one.Bytes()
+	if w.buf.Len() == 0 {
+		w.buf.Write(data[:gifHeaderLen])
+		// The NETSCAPE2.0 extension with a loop count of 0, to loop forever.
+		w.buf.WriteString("\x21\xff\x0bNETSCAPE2.0\x03\x01\x00\x00\x00")
+	}
+	// Everything between the header and the trailer is the frame itself.
+	w.buf.Write(data[gifHeaderLen : len(data)-1])
+	return nil
+}
+
+func (w *gifWriter) Finish() ([]byte, error) {
+	w.buf.WriteByte(0x3b)
+	return w.buf.Bytes(), nil
+}
+
+// mp4Writer pipes JPEG frames through ffmpeg into a fragmented H.264 MP4,
+// which can be written to a pipe without seeking back to patch the moov atom.
+type mp4Writer struct {
+	cmd            *exec.Cmd
+	stdin          io.WriteCloser
+	stdout, stderr bytes.Buffer
+	writeErr       error
+}
+
+func startMP4(ctx context.Context, fps int) (*mp4Writer, error) {
+	w := &mp4Writer{}
+	w.cmd = exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner", "-loglevel", "error",
+		"-f", "image2pipe", "-framerate", strconv.Itoa(fps), "-i", "pipe:0",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-movflags", "frag_keyframe+empty_moov",
+		"-f", "mp4", "pipe:1",
+	)
+	w.cmd.Stdout = &w.stdout
+	w.cmd.Stderr = &w.stderr
+	stdin, err := w.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	w.stdin = stdin
+	if err := w.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+	return w, nil
+}
+
+func (w *mp4Writer) WriteFrame(img image.Image) error {
+	if err := imaging.Encode(w.stdin, img, imaging.JPEG, imaging.JPEGQuality(defaultQuality)); err != nil {
+		w.writeErr = fmt.Errorf("write frame: %w", err)
+		return w.writeErr
+	}
+	return nil
+}
+
+func (w *mp4Writer) Finish() ([]byte, error) {
+	w.stdin.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, w.stderr.String())
+	}
+	if w.writeErr != nil {
+		return nil, w.writeErr
+	}
+	return w.stdout.Bytes(), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"testing"
+)
+
+func TestGIFWriter(t *testing.T) {
+	w := &gifWriter{delay: 20}
+	shades := []uint8{0, 255, 0}
+	for _, v := range shades {
+		img := image.NewNRGBA(image.Rect(10, 10, 42, 34))
+		for i := range img.Pix {
+			img.Pix[i] = v
+		}
+		if err := w.WriteFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := w.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != len(shades) {
+		t.Fatalf("decoded %d frames, want %d", len(anim.Image), len(shades))
+	}
+	if anim.LoopCount != 0 {
+		t.Errorf("LoopCount = %d, want 0", anim.LoopCount)
+	}
+	if anim.Config.Width != 32 || anim.Config.Height != 24 {
+		t.Errorf("screen is %dx%d, want 32x24", anim.Config.Width, anim.Config.Height)
+	}
+	for i, frame := range anim.Image {
+		if anim.Delay[i] != 20 {
+			t.Errorf("frame %d delay = %d, want 20", i, anim.Delay[i])
+		}
+		r, _, _, _ := frame.At(5, 5).RGBA()
+		if got, want := int(r>>8), int(shades[i]); got < want-16 || got > want+16 {
+			t.Errorf("frame %d is %d, want about %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	missionHistoryRefresh = 5 * time.Minute
+	missionHistoryBuffer  = 256
+	// Each mission keeps its newest maxMissionStatusChanges changes.
+	maxMissionStatusChanges = 1000
+)
+
+// Timeline entry kinds, in the order entries at the same time are listed.
+const (
+	TimelineCreated       = "created"
+	TimelineApproved      = "approved"
+	TimelineStatus        = "status"
+	TimelineWindowStart   = "window_start"
+	TimelineTCA           = "tca"
+	TimelineImageCaptured = "image_captured"
+	TimelineImageIngested = "image_ingested"
+	TimelineWindowEnd     = "window_end"
+	TimelineDownlink      = "downlink"
+)
+
+var timelineKinds = []string{TimelineCreated, TimelineApproved, TimelineStatus, TimelineWindowStart, TimelineTCA, TimelineImageCaptured, TimelineImageIngested, TimelineWindowEnd, TimelineDownlink}
+
+// missionApprovedStatus is the status, compared without regard to case, a
+// mission is approved by changing to.
+const missionApprovedStatus = "approved"
+
+// MissionStatusChange records a mission's status as a mission event showed
+// it. The first change seen of a mission that existed before is a baseline:
+// neither Created nor with a Previous status, it says only what the status
+// was then.
+type MissionStatusChange struct {
+	ID string `dynamodbav:"id" json:"id"`
+	// MissionID is the mission's MISSION_TABLE key.
+	MissionID string `dynamodbav:"mission_id" json:"mission_id"`
+	Time      int64  `dynamodbav:"time" json:"time"`
+	Status    string `dynamodbav:"status" json:"status"`
+	Previous  string `dynamodbav:"previous,omitempty" json:"previous,omitempty"`
+	Created   bool   `dynamodbav:"created,omitempty" json:"created,omitempty"`
+}
+
+// MissionHistory records when missions were created and changed status,
+// from the mission events. When MISSION_HISTORY_TABLE is set changes are
+// saved to DynamoDB and shared by every instance, each change once however
+// many instances see it; without it they live only in this process.
+type MissionHistory struct {
+	mu      sync.RWMutex
+	changes map[string][]MissionStatusChange
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newMissionHistory(db *dynamodb.Client, table string) *MissionHistory {
+	h := &MissionHistory{changes: make(map[string][]MissionStatusChange), table: table}
+	if h.table != "" {
+		h.db = db
+	}
+	return h
+}
+
+// Start loads the history, rereads it until ctx is done, and records the
+// mission events bus publishes.
+func (h *MissionHistory) Start(ctx context.Context, bus *EventBus) {
+	if h.db != nil {
+		if err := h.refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to load mission history", "err", err)
+		}
+		go func() {
+			ticker := time.NewTicker(missionHistoryRefresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := h.refresh(ctx); err != nil {
+						slog.ErrorContext(ctx, "failed to reload mission history", "err", err)
+					}
+				}
+			}
+		}()
+	}
+	go consumeEvents(ctx, bus, missionHistoryBuffer, "mission history", func(e Event) {
+		if err := h.observe(ctx, e); err != nil {
+			slog.ErrorContext(ctx, "failed to record mission history", "mission", e.MissionID, "err", err)
+		}
+	})
+}
+
+// observe records e's mission's creation or status change, if it is one.
+func (h *MissionHistory) observe(ctx context.Context, e Event) error {
+	if e.Mission == nil || (e.Type != EventMissionCreated && e.Type != EventMissionUpdated) {
+		return nil
+	}
+	change := MissionStatusChange{MissionID: e.MissionID, Time: e.Time, Status: e.Mission.Status, Created: e.Type == EventMissionCreated}
+	if e.Tenant != "" {
+		change.MissionID = e.Tenant + "/" + e.MissionID
+	}
+	if last, ok := h.last(change.MissionID); ok {
+		if !change.Created && last.Status == change.Status {
+			return nil
+		}
+		if !change.Created {
+			change.Previous = last.Status
+		}
+	}
+	// Every instance sees a streamed change under the same source ID.
+	change.ID = newJobID()
+	if e.SourceID != "" {
+		change.ID = "source/" + e.SourceID
+	}
+	return h.add(ctx, change)
+}
+
+func (h *MissionHistory) last(missionID string) (MissionStatusChange, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	changes := h.changes[missionID]
+	if len(changes) == 0 {
+		return MissionStatusChange{}, false
+	}
+	return changes[len(changes)-1], true
+}
+
+func (h *MissionHistory) add(ctx context.Context, change MissionStatusChange) error {
+	if h.db != nil {
+		item, err := attributevalue.MarshalMap(change)
+		if err != nil {
+			return fmt.Errorf("marshal mission status change: %w", err)
+		}
+		_, err = h.db.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(h.table),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		})
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			// Another instance recorded it first.
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	changes := h.changes[change.MissionID]
+	if slices.ContainsFunc(changes, func(c MissionStatusChange) bool { return c.ID == change.ID }) {
+		return nil
+	}
+	changes = append(changes, change)
+	sortStatusChanges(changes)
+	if len(changes) > maxMissionStatusChanges {
+		changes = changes[len(changes)-maxMissionStatusChanges:]
+	}
+	h.changes[change.MissionID] = changes
+	return nil
+}
+
+// ForMission returns the changes of the mission keyed missionID, oldest
+// first.
+func (h *MissionHistory) ForMission(missionID string) []MissionStatusChange {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return slices.Clone(h.changes[missionID])
+}
+
+// refresh replaces the history with MISSION_HISTORY_TABLE's.
+func (h *MissionHistory) refresh(ctx context.Context) error {
+	changes := make(map[string][]MissionStatusChange)
+	paginator := dynamodb.NewScanPaginator(h.db, &dynamodb.ScanInput{TableName: aws.String(h.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []MissionStatusChange
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for _, c := range items {
+			changes[c.MissionID] = append(changes[c.MissionID], c)
+		}
+	}
+	for id, list := range changes {
+		sortStatusChanges(list)
+		if len(list) > maxMissionStatusChanges {
+			changes[id] = list[len(list)-maxMissionStatusChanges:]
+		}
+	}
+	h.mu.Lock()
+	h.changes = changes
+	h.mu.Unlock()
+	return nil
+}
+
+func sortStatusChanges(changes []MissionStatusChange) {
+	slices.SortStableFunc(changes, func(a, b MissionStatusChange) int {
+		return cmp.Or(cmp.Compare(a.Time, b.Time), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+// TimelineEntry is one moment in a mission's timeline. Which of the other
+// fields are set depends on Kind.
+type TimelineEntry struct {
+	Time int64  `json:"time"`
+	Kind string `json:"kind"`
+	// Status, and for a change PreviousStatus, are set on created,
+	// approved and status entries.
+	Status         string `json:"status,omitempty"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+	ImageID        string `json:"image_id,omitempty"`
+	// DownlinkID, GroundStation, PassEnd and VolumeMB are set on downlink
+	// entries, whose Time is the pass's start.
+	DownlinkID    string  `json:"downlink_id,omitempty"`
+	GroundStation string  `json:"ground_station,omitempty"`
+	PassEnd       int64   `json:"pass_end,omitempty"`
+	VolumeMB      float64 `json:"volume_mb,omitempty"`
+}
+
+// MissionTimelineResponse is the body of GET /mission/:id/timeline.
+type MissionTimelineResponse struct {
+	MissionID string          `json:"mission_id"`
+	Entries   []TimelineEntry `json:"entries"`
+	// Undated are the mission's images with neither a capture nor an
+	// ingest time stored.
+	Undated []string `json:"undated_image_ids,omitempty"`
+}
+
+// missionTimeline merges the mission's history, collection window, images
+// and downlinks into time order. records are its images' records.
+func (api *API) missionTimeline(ctx context.Context, m *Mission, records map[string]*ImageRecord) MissionTimelineResponse {
+	out := MissionTimelineResponse{MissionID: m.ID, Entries: []TimelineEntry{}}
+	recordID := tenantRecordID(ctx, m.ID)
+	add := func(e TimelineEntry) { out.Entries = append(out.Entries, e) }
+
+	for _, c := range api.History.ForMission(recordID) {
+		switch {
+		case c.Created:
+			add(TimelineEntry{Time: c.Time, Kind: TimelineCreated, Status: c.Status})
+		case c.Previous == "":
+			// A baseline: the status may have been set at any time before.
+		case strings.EqualFold(c.Status, missionApprovedStatus):
+			add(TimelineEntry{Time: c.Time, Kind: TimelineApproved, Status: c.Status, PreviousStatus: c.Previous})
+		default:
+			add(TimelineEntry{Time: c.Time, Kind: TimelineStatus, Status: c.Status, PreviousStatus: c.Previous})
+		}
+	}
+	if m.CollectionWindowStart > 0 {
+		add(TimelineEntry{Time: m.CollectionWindowStart, Kind: TimelineWindowStart})
+	}
+	if m.TCA > 0 {
+		add(TimelineEntry{Time: m.TCA, Kind: TimelineTCA})
+	}
+	if m.CollectionWindowEnd > 0 {
+		add(TimelineEntry{Time: m.CollectionWindowEnd, Kind: TimelineWindowEnd})
+	}
+	for _, id := range m.ImageIDs {
+		r := records[id]
+		switch {
+		case r != nil && r.Geometry != nil && r.Geometry.CaptureTime > 0:
+			add(TimelineEntry{Time: r.Geometry.CaptureTime, Kind: TimelineImageCaptured, ImageID: id})
+		case r != nil && r.Ingest != nil && r.Ingest.Ingested > 0:
+			add(TimelineEntry{Time: r.Ingest.Ingested, Kind: TimelineImageIngested, ImageID: id})
+		default:
+			out.Undated = append(out.Undated, id)
+		}
+	}
+	for _, d := range api.Downlinks.ForMission(recordID) {
+		add(TimelineEntry{Time: d.PassStart, Kind: TimelineDownlink, DownlinkID: d.ID, GroundStation: d.GroundStation, PassEnd: d.PassEnd, VolumeMB: d.VolumeMB})
+	}
+
+	slices.SortStableFunc(out.Entries, func(a, b TimelineEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.Time, b.Time),
+			cmp.Compare(slices.Index(timelineKinds, a.Kind), slices.Index(timelineKinds, b.Kind)),
+			cmp.Compare(a.ImageID, b.ImageID),
+		)
+	})
+	return out
+}
+
+// getMissionTimeline lists what happened, and is due to happen, to the
+// mission in time order.
+func (api *API) getMissionTimeline(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	mission, err := api.loadMission(ctx, id)
+	if err != nil {
+		if errors.Is(err, errMissionNotFound) {
+			respondError(c, http.StatusNotFound, CodeMissionNotFound, "mission not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to load mission", "id", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve mission")
+		return
+	}
+	records, err := api.Images.ImageRecords(ctx, mission.ImageIDs)
+	if err != nil && !errors.Is(err, errImageTableUnset) {
+		slog.ErrorContext(ctx, "failed to load image records", "mission", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to retrieve image metadata")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, api.missionTimeline(ctx, mission.withID(id), records))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMissionTimeline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	meta := testSQLStore(t)
+	mission := &Mission{ID: "m1", Status: "proposed", CollectionWindowStart: 5000, CollectionWindowEnd: 5600, TCA: 5300, ImageIDs: []string{"f1", "f2", "f3"}}
+	if err := putMission(ctx, meta, mission); err != nil {
+		t.Fatal(err)
+	}
+	meta.SetImageAttribute(ctx, "f1", "geometry", ObservationGeometry{CaptureTime: 5400})
+	meta.SetImageAttribute(ctx, "f2", "ingest", IngestRecord{Status: "accepted", Ingested: 5100})
+	api := &API{
+		MissionDB: meta,
+		Images:    meta,
+		History:   newMissionHistory(nil, ""),
+		Downlinks: newDownlinkStore(nil, ""),
+	}
+	api.Downlinks.Add(ctx, Downlink{MissionID: "m1", GroundStation: "Svalbard", PassStart: 6000, PassEnd: 6400, VolumeMB: 12})
+
+	with := func(status string) *Mission {
+		m := *mission
+		m.Status = status
+		return &m
+	}
+	for _, e := range []Event{
+		{Type: EventMissionCreated, Time: 1000, MissionID: "m1", SourceID: "s1", Mission: with("proposed")},
+		// Changes that leave the status alone, and repeats of a change
+		// another instance saw, are not recorded.
+		{Type: EventMissionUpdated, Time: 1500, MissionID: "m1", Mission: with("proposed")},
+		{Type: EventMissionUpdated, Time: 2000, MissionID: "m1", SourceID: "s2", Mission: with("Approved")},
+		{Type: EventMissionCompleted, Time: 2000, MissionID: "m1", SourceID: "s2", Mission: with("Approved")},
+		{Type: EventMissionUpdated, Time: 5000, MissionID: "m1", Mission: with("active")},
+		{Type: EventMissionDeleted, Time: 7000, MissionID: "m1"},
+		// A mission first seen changed is recorded only as a baseline.
+		{Type: EventMissionUpdated, Time: 3000, MissionID: "other", Mission: &Mission{ID: "other", Status: "planned"}},
+	} {
+		if err := api.History.observe(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := api.History.add(ctx, MissionStatusChange{ID: "source/s2", MissionID: "m1", Time: 2000, Status: "Approved", Previous: "proposed"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.History.ForMission("m1"); len(got) != 3 {
+		t.Errorf("m1 history %+v", got)
+	}
+	if got := api.History.ForMission("other"); len(got) != 1 || got[0].Previous != "" || got[0].Created {
+		t.Errorf("other history %+v", got)
+	}
+
+	router := gin.New()
+	router.GET("/mission/:id/timeline", api.getMissionTimeline)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mission/none/timeline", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown mission: %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mission/m1/timeline", nil))
+	var resp MissionTimelineResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.MissionID != "m1" {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	var got []string
+	for _, e := range resp.Entries {
+		got = append(got, e.Kind+"@"+strconv.FormatInt(e.Time, 10)+":"+e.Status+e.ImageID+e.GroundStation)
+	}
+	want := "created@1000:proposed approved@2000:Approved status@5000:active window_start@5000: image_ingested@5100:f2 tca@5300: image_captured@5400:f1 window_end@5600: downlink@6000:Svalbard"
+	if strings.Join(got, " ") != want {
+		t.Errorf("timeline\n %s\nwant\n %s", strings.Join(got, " "), want)
+	}
+	if e := resp.Entries[2]; e.PreviousStatus != "Approved" {
+		t.Errorf("status change %+v", e)
+	}
+	if d := resp.Entries[8]; d.PassEnd != 6400 || d.VolumeMB != 12 || d.DownlinkID == "" {
+		t.Errorf("downlink %+v", d)
+	}
+	if strings.Join(resp.Undated, ",") != "f3" {
+		t.Errorf("undated %v", resp.Undated)
+	}
+}
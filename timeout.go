@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRequestTimeout    = 15 * time.Second
+	defaultProcessingTimeout = 5 * time.Minute
+)
+
+// timeoutBody is what a request that ran out of time gets instead of the
+// error its handler reported.
+var timeoutBody = func() string {
+	body, _ := json.Marshal(newProblem(http.StatusGatewayTimeout, CodeTimeout, "Request timed out"))
+	return string(body)
+}()
+
+// routeTimeout gives each request a context that ends after d, so the
+// DynamoDB and S3 calls made with c.Request.Context() are cancelled with it.
+// A handler that then fails with a 5xx, or writes nothing at all, is answered
+// with 504 instead. A response already under way when time runs out is cut
+// off, since its status has been sent. A zero d leaves requests unbounded.
+func routeTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		w := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = w
+		c.Next()
+		if w.expired() && !w.Written() {
+			w.timedOut = true
+		}
+		if w.timedOut {
+			w.respond()
+		}
+	}
+}
+
+// timeoutWriter swaps a 5xx written after the deadline for the 504.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx       context.Context
+	timedOut  bool
+	responded bool
+}
+
+func (w *timeoutWriter) expired() bool {
+	return errors.Is(w.ctx.Err(), context.DeadlineExceeded)
+}
+
+// respond sends the 504 once, discarding whatever headers the handler set
+// for its own error body.
+func (w *timeoutWriter) respond() {
+	if w.responded {
+		return
+	}
+	w.responded = true
+	h := w.Header()
+	for _, name := range []string{"Content-Length", "Content-Encoding", "ETag", "Last-Modified", "Cache-Control"} {
+		h.Del(name)
+	}
+	h.Set("Content-Type", problemContentType)
+	w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	w.ResponseWriter.WriteString(timeoutBody)
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if !w.Written() && code >= http.StatusInternalServerError && w.expired() {
+		w.timedOut = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if w.timedOut {
+		w.respond()
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	if w.timedOut {
+		w.respond()
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.timedOut {
+		w.respond()
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
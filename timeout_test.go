@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// waitForDeadline blocks like a DynamoDB or S3 call made with the
+	// request's context.
+	waitForDeadline := func(c *gin.Context) { <-c.Request.Context().Done() }
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		handler  gin.HandlerFunc
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "fast",
+			timeout:  time.Second,
+			handler:  func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) },
+			wantCode: http.StatusOK,
+			wantBody: `{"ok":true}`,
+		},
+		{
+			name:    "error after the deadline",
+			timeout: 10 * time.Millisecond,
+			handler: func(c *gin.Context) {
+				waitForDeadline(c)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve mission"})
+			},
+			wantCode: http.StatusGatewayTimeout,
+			wantBody: timeoutBody,
+		},
+		{
+			name:    "aborted after the deadline",
+			timeout: 10 * time.Millisecond,
+			handler: func(c *gin.Context) {
+				waitForDeadline(c)
+				c.AbortWithStatus(http.StatusServiceUnavailable)
+			},
+			wantCode: http.StatusGatewayTimeout,
+			wantBody: timeoutBody,
+		},
+		{
+			name:     "nothing written",
+			timeout:  10 * time.Millisecond,
+			handler:  waitForDeadline,
+			wantCode: http.StatusGatewayTimeout,
+			wantBody: timeoutBody,
+		},
+		{
+			name:    "client error after the deadline",
+			timeout: 10 * time.Millisecond,
+			handler: func(c *gin.Context) {
+				waitForDeadline(c)
+				c.JSON(http.StatusNotFound, gin.H{"error": "mission not found"})
+			},
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":"mission not found"}`,
+		},
+		{
+			name:    "disabled",
+			timeout: 0,
+			handler: func(c *gin.Context) {
+				if _, ok := c.Request.Context().Deadline(); ok {
+					c.Status(http.StatusInternalServerError)
+					return
+				}
+				c.String(http.StatusOK, "unbounded")
+			},
+			wantCode: http.StatusOK,
+			wantBody: "unbounded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(compressResponses())
+			router.GET("/x", routeTimeout(tt.timeout), tt.handler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
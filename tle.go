@@ -0,0 +1,278 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+// tleLineLen is the length of each line of a two-line element set.
+const tleLineLen = 69
+
+// TLE is a two-line element set: a satellite's orbit at Epoch, in the
+// format NORAD publishes.
+type TLE struct {
+	Line1 string    `dynamodbav:"line1" json:"line1"`
+	Line2 string    `dynamodbav:"line2" json:"line2"`
+	Epoch time.Time `dynamodbav:"epoch" json:"epoch"`
+}
+
+// parseTLE checks the two lines of an element set and reads their epoch
+// and catalog number.
+func parseTLE(line1, line2 string) (TLE, int, error) {
+	line1, line2 = strings.TrimRight(line1, " \r\n"), strings.TrimRight(line2, " \r\n")
+	for i, line := range []string{line1, line2} {
+		n := byte('1' + i)
+		switch {
+		case len(line) != tleLineLen:
+			return TLE{}, 0, fmt.Errorf("TLE line %c is %d characters, not %d", n, len(line), tleLineLen)
+		case line[0] != n || line[1] != ' ':
+			return TLE{}, 0, fmt.Errorf("TLE line %c does not start with %q", n, string(n)+" ")
+		case tleChecksum(line) != line[tleLineLen-1]:
+			return TLE{}, 0, fmt.Errorf("TLE line %c fails its checksum", n)
+		}
+	}
+	catalog, err := strconv.Atoi(strings.TrimSpace(line1[2:7]))
+	if err != nil {
+		return TLE{}, 0, fmt.Errorf("TLE catalog number %q is not a number", line1[2:7])
+	}
+	if line2[2:7] != line1[2:7] {
+		return TLE{}, 0, errors.New("TLE lines are for different catalog numbers")
+	}
+	epoch, err := tleEpoch(line1[18:32])
+	if err != nil {
+		return TLE{}, 0, err
+	}
+	return TLE{Line1: line1, Line2: line2, Epoch: epoch}, catalog, nil
+}
+
+// tleChecksum is the digit a TLE line ends with: the sum of its digits,
+// with each minus sign counting one, modulo 10.
+func tleChecksum(line string) byte {
+	sum := 0
+	for _, c := range line[:tleLineLen-1] {
+		switch {
+		case c >= '0' && c <= '9':
+			sum += int(c - '0')
+		case c == '-':
+			sum++
+		}
+	}
+	return byte('0' + sum%10)
+}
+
+// tleEpoch reads a YYDDD.DDDDDDDD epoch. Two-digit years from 57 are 19xx.
+func tleEpoch(field string) (time.Time, error) {
+	year, err := strconv.Atoi(field[:2])
+	day, derr := strconv.ParseFloat(strings.TrimSpace(field[2:]), 64)
+	if err != nil || derr != nil || day < 1 || day >= 367 {
+		return time.Time{}, fmt.Errorf("TLE epoch %q is not YYDDD.DDDDDDDD", field)
+	}
+	if year < 57 {
+		year += 2000
+	} else {
+		year += 1900
+	}
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return start.Add(time.Duration((day - 1) * float64(24*time.Hour))).Round(time.Microsecond), nil
+}
+
+const (
+	// tleSatelliteIndex is the TLE_TABLE index partitioned by satellite and
+	// sorted by epoch, which GET /satellite/:id/tle queries.
+	tleSatelliteIndex = "satellite-epoch"
+	// Without TLE_TABLE the newest tleMemoryPerSatellite element sets of
+	// each satellite are kept.
+	tleMemoryPerSatellite = 1000
+)
+
+// The sources a TLERecord can come from.
+const (
+	TLESourceManual     = "manual"
+	TLESourceCelestrak  = "celestrak"
+	TLESourceSpaceTrack = "spacetrack"
+)
+
+var errTLENotFound = errors.New("no TLE recorded for the satellite")
+
+// TLERecord is one element set in a satellite's history.
+type TLERecord struct {
+	// ID is the satellite and epoch in unix milliseconds, joined by a
+	// slash, so the same element set fetched twice is stored once.
+	ID        string `dynamodbav:"id" json:"-"`
+	Satellite string `dynamodbav:"satellite" json:"satellite"`
+	// EpochMS is the epoch in unix milliseconds, the sort key of
+	// tleSatelliteIndex.
+	EpochMS int64 `dynamodbav:"epoch_ms" json:"-"`
+	TLE
+	// Source is where the element set came from: manual for POST
+	// /satellites, or the TLE_SOURCE that fetched it.
+	Source  string `dynamodbav:"source" json:"source"`
+	Fetched int64  `dynamodbav:"fetched" json:"fetched"`
+}
+
+func newTLERecord(satellite string, tle TLE, source string) TLERecord {
+	ms := tle.Epoch.UnixMilli()
+	return TLERecord{
+		ID:        satellite + "/" + strconv.FormatInt(ms, 10),
+		Satellite: satellite,
+		EpochMS:   ms,
+		TLE:       tle,
+		Source:    source,
+		Fetched:   time.Now().Unix(),
+	}
+}
+
+// TLEStore is the history of every satellite's element sets. With TLE_TABLE
+// set it is kept in DynamoDB and shared by every instance; without it each
+// instance keeps the newest of each satellite in memory.
+type TLEStore struct {
+	mu      sync.Mutex
+	history map[string][]TLERecord
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newTLEStore(db *dynamodb.Client, table string) *TLEStore {
+	s := &TLEStore{history: make(map[string][]TLERecord), table: table}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Add records rec, replacing an element set of the same satellite and
+// epoch.
+func (s *TLEStore) Add(ctx context.Context, rec TLERecord) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		history := s.history[rec.Satellite]
+		i, found := slices.BinarySearchFunc(history, rec.EpochMS, func(r TLERecord, ms int64) int { return cmp.Compare(r.EpochMS, ms) })
+		if found {
+			history[i] = rec
+			return nil
+		}
+		history = slices.Insert(history, i, rec)
+		if len(history) > tleMemoryPerSatellite {
+			history = history[len(history)-tleMemoryPerSatellite:]
+		}
+		s.history[rec.Satellite] = history
+		return nil
+	}
+	item, err := attributevalue.MarshalMap(rec)
+	if err != nil {
+		return fmt.Errorf("marshal TLE: %w", err)
+	}
+	_, err = s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item})
+	return err
+}
+
+// Closest returns the satellite's element set with the epoch nearest t,
+// the earlier of two equally near, or errTLENotFound when it has none.
+func (s *TLEStore) Closest(ctx context.Context, satellite string, t time.Time) (TLERecord, error) {
+	ms := t.UnixMilli()
+	var before, after *TLERecord
+	if s.db == nil {
+		s.mu.Lock()
+		history := s.history[satellite]
+		i, found := slices.BinarySearchFunc(history, ms, func(r TLERecord, ms int64) int { return cmp.Compare(r.EpochMS, ms) })
+		if found {
+			i++
+		}
+		if i > 0 {
+			rec := history[i-1]
+			before = &rec
+		}
+		if i < len(history) {
+			rec := history[i]
+			after = &rec
+		}
+		s.mu.Unlock()
+	} else {
+		var err error
+		if before, err = s.query(ctx, satellite, "<=", ms, false); err != nil {
+			return TLERecord{}, err
+		}
+		if after, err = s.query(ctx, satellite, ">", ms, true); err != nil {
+			return TLERecord{}, err
+		}
+	}
+	switch {
+	case before == nil && after == nil:
+		return TLERecord{}, errTLENotFound
+	case after == nil || before != nil && ms-before.EpochMS <= after.EpochMS-ms:
+		return *before, nil
+	}
+	return *after, nil
+}
+
+// query returns the first of the satellite's element sets whose epoch is op
+// ms, in ascending or descending epoch order, or nil for none.
+func (s *TLEStore) query(ctx context.Context, satellite, op string, ms int64, ascending bool) (*TLERecord, error) {
+	out, err := s.db.Query(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(s.table),
+		IndexName:                aws.String(tleSatelliteIndex),
+		KeyConditionExpression:   aws.String("#satellite = :satellite AND #epoch " + op + " :ms"),
+		ExpressionAttributeNames: map[string]string{"#satellite": "satellite", "#epoch": "epoch_ms"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":satellite": &types.AttributeValueMemberS{Value: satellite},
+			":ms":        &types.AttributeValueMemberN{Value: strconv.FormatInt(ms, 10)},
+		},
+		ScanIndexForward: aws.Bool(ascending),
+		Limit:            aws.Int32(1),
+	})
+	if err != nil || len(out.Items) == 0 {
+		return nil, err
+	}
+	var rec TLERecord
+	if err := attributevalue.UnmarshalMap(out.Items[0], &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// getSatelliteTLE answers with the satellite's element set nearest ?epoch=,
+// a unix time in seconds or an RFC 3339 time, and now without it.
+func (api *API) getSatelliteTLE(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := api.Satellites.Get(id); err != nil {
+		respondError(c, http.StatusNotFound, CodeSatelliteNotFound, "satellite not found")
+		return
+	}
+	epoch := time.Now()
+	if v := c.Query("epoch"); v != "" {
+		t, err := parseAuditTime(v)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'epoch' parameter. Must be a unix time or an RFC 3339 time.")
+			return
+		}
+		epoch = t
+	}
+	rec, err := api.TLEs.Closest(c.Request.Context(), id, epoch)
+	if err != nil {
+		if errors.Is(err, errTLENotFound) {
+			respondError(c, http.StatusNotFound, CodeTLENotFound, "no TLE recorded for the satellite")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to read TLE history", "satellite", id, "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to read the TLE history")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rec)
+}
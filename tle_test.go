@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	issLine1 = "1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927"
+	issLine2 = "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537"
+)
+
+// withEpoch is line1 with its epoch replaced by a YYDDD.DDDDDDDD field and
+// its checksum fixed.
+func withEpoch(line1, epoch string) string {
+	line := line1[:18] + epoch + line1[32:]
+	return line[:tleLineLen-1] + string(tleChecksum(line))
+}
+
+func TestParseTLE(t *testing.T) {
+	tle, catalog, err := parseTLE(issLine1, issLine2+"\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2008, time.September, 20, 12, 25, 40, 104192000, time.UTC); catalog != 25544 || !tle.Epoch.Equal(want) || tle.Line2 != issLine2 {
+		t.Errorf("parseTLE = %+v, %d; want epoch %v, catalog 25544", tle, catalog, want)
+	}
+
+	tests := []struct {
+		name, line1, line2, wantErr string
+	}{
+		{"short", issLine1[:60], issLine2, "line 1 is 60 characters"},
+		{"swapped", issLine2, issLine1, `line 1 does not start with "1 "`},
+		{"checksum", issLine1[:68] + "0", issLine2, "line 1 fails its checksum"},
+		{"catalog", issLine1, "2 25545" + issLine2[7:68] + "8", "different catalog numbers"},
+	}
+	for _, tt := range tests {
+		if _, _, err := parseTLE(tt.line1, tt.line2); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTLEStoreClosest(t *testing.T) {
+	ctx := context.Background()
+	s := newTLEStore(nil, "")
+	if _, err := s.Closest(ctx, "25544", time.Now()); err != errTLENotFound {
+		t.Fatalf("empty history: %v", err)
+	}
+	for _, epoch := range []string{"08264.00000000", "08266.00000000", "08270.00000000", "08266.00000000"} {
+		tle, _, err := parseTLE(withEpoch(issLine1, epoch), issLine2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Add(ctx, newTLERecord("25544", tle, TLESourceManual)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s.history["25544"]); n != 3 {
+		t.Errorf("history holds %d element sets, want 3", n)
+	}
+
+	day := func(d float64) time.Time {
+		return time.Date(2008, time.January, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration((d - 1) * float64(24*time.Hour)))
+	}
+	tests := []struct {
+		at, want float64
+	}{
+		{200, 264},
+		{264, 264},
+		{265, 264},
+		{265.5, 266},
+		{268, 266},
+		{269, 270},
+		{400, 270},
+	}
+	for _, tt := range tests {
+		rec, err := s.Closest(ctx, "25544", day(tt.at))
+		if err != nil || !rec.Epoch.Equal(day(tt.want)) {
+			t.Errorf("Closest(day %v) = %v, %v; want day %v", tt.at, rec.Epoch, err, tt.want)
+		}
+	}
+}
+
+func TestTLEFetcher(t *testing.T) {
+	newer := withEpoch(issLine1, "08265.00000000")
+	hubble1 := "1 20580U 90037B   08264.91266205  .00000419  00000-0  19784-4 0  9990"
+	hubble1 = hubble1[:tleLineLen-1] + string(tleChecksum(hubble1))
+	hubble2 := "2 20580  28.4697  61.4936 0003185 115.0520 245.0458 15.00643089    01"
+	hubble2 = hubble2[:tleLineLen-1] + string(tleChecksum(hubble2))
+
+	var loggedIn bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /NORAD/elements/gp.php", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("CATNR") {
+		case "25544":
+			w.Write([]byte("ISS (ZARYA)\r\n" + newer + "\r\n" + issLine2 + "\r\n"))
+		case "20580":
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("No GP data found\n"))
+		}
+	})
+	mux.HandleFunc("POST /ajaxauth/login", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("identity") != "user" || r.PostForm.Get("password") != "secret" {
+			w.Write([]byte(`{"Login":"Failed"}`))
+			return
+		}
+		loggedIn = true
+		http.SetCookie(w, &http.Cookie{Name: "chocolatechip", Value: "session", Path: "/"})
+		w.Write([]byte(`""`))
+	})
+	mux.HandleFunc("GET /basicspacedata/query/class/gp/NORAD_CAT_ID/{ids}/orderby/NORAD_CAT_ID/format/tle", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("chocolatechip"); err != nil || c.Value != "session" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.PathValue("ids") != "20580,25544,99999" {
+			t.Errorf("asked Space-Track for %s", r.PathValue("ids"))
+		}
+		w.Write([]byte(newer + "\n" + issLine2 + "\n" + hubble1 + "\n" + hubble2 + "\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		source, password string
+		want             map[string]string
+	}{
+		{TLESourceCelestrak, "", map[string]string{"iss": "08265", "hubble": "", "debris": "", "old": ""}},
+		{TLESourceSpaceTrack, "wrong", map[string]string{"iss": "08264", "hubble": "", "debris": "", "old": ""}},
+		{TLESourceSpaceTrack, "secret", map[string]string{"iss": "08265", "hubble": "08264", "debris": "", "old": ""}},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		sats := newSatelliteStore(nil, "")
+		history := newTLEStore(nil, "")
+		iss, _, _ := parseTLE(issLine1, issLine2)
+		for _, sat := range []Satellite{
+			{ID: "iss", NoradID: 25544, Name: "ISS", Status: SatelliteActive, TLE: &iss},
+			{ID: "hubble", NoradID: 20580, Name: "HST", Status: SatelliteActive},
+			{ID: "debris", NoradID: 99999, Name: "Debris", Status: SatelliteUnknown},
+			{ID: "old", NoradID: 11, Name: "Vanguard", Status: SatelliteDecayed},
+		} {
+			sats.Put(ctx, sat)
+		}
+		cfg := TLEConfig{Source: tt.source, URL: srv.URL, Username: "user", Password: tt.password}
+		newTLEFetcher(cfg, sats, history).refresh(ctx)
+
+		for id, want := range tt.want {
+			sat, _ := sats.Get(id)
+			got := ""
+			if sat.TLE != nil {
+				got = sat.TLE.Line1[18:23]
+			}
+			if got != want {
+				t.Errorf("%s (password %q): %s has TLE epoch %q, want %q", tt.source, tt.password, id, got, want)
+			}
+		}
+		if rec, err := history.Closest(ctx, "iss", time.Now()); tt.want["iss"] == "08265" && (err != nil || rec.Source != tt.source) {
+			t.Errorf("%s: history has %+v, %v", tt.source, rec, err)
+		}
+	}
+	if !loggedIn {
+		t.Error("never logged in to Space-Track")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCelestrakURL  = "https://celestrak.org"
+	defaultSpaceTrackURL = "https://www.space-track.org"
+	defaultTLERefresh    = 6 * time.Hour
+	tleFetchTimeout      = time.Minute
+	// Space-Track is asked for spaceTrackBatch satellites at a time.
+	spaceTrackBatch = 100
+	maxTLEResponse  = 16 << 20
+)
+
+// TLEConfig is where the element sets of the catalog's satellites are
+// fetched from.
+type TLEConfig struct {
+	// Source is celestrak or spacetrack; empty fetches nothing.
+	Source string
+	// URL replaces the source's address, for a mirror.
+	URL                string
+	Username, Password string
+	Refresh            time.Duration
+}
+
+// loadTLEConfig reads TLE_SOURCE, TLE_SOURCE_URL, TLE_REFRESH and the
+// Space-Track credentials.
+func loadTLEConfig() (TLEConfig, []error) {
+	c := TLEConfig{
+		Source:   strings.ToLower(os.Getenv("TLE_SOURCE")),
+		URL:      os.Getenv("TLE_SOURCE_URL"),
+		Username: os.Getenv("SPACETRACK_USERNAME"),
+		Password: os.Getenv("SPACETRACK_PASSWORD"),
+		Refresh:  defaultTLERefresh,
+	}
+	var errs []error
+	if v := os.Getenv("TLE_REFRESH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			errs = append(errs, fmt.Errorf("TLE_REFRESH %q is not a duration of at least 1m", v))
+		}
+		c.Refresh = d
+	}
+	switch c.Source {
+	case "":
+	case TLESourceCelestrak:
+		if c.URL == "" {
+			c.URL = defaultCelestrakURL
+		}
+	case TLESourceSpaceTrack:
+		if c.URL == "" {
+			c.URL = defaultSpaceTrackURL
+		}
+		if c.Username == "" || c.Password == "" {
+			errs = append(errs, errors.New("TLE_SOURCE=spacetrack needs SPACETRACK_USERNAME and SPACETRACK_PASSWORD"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("TLE_SOURCE %q is not celestrak or spacetrack", c.Source))
+	}
+	if u, err := url.Parse(c.URL); c.URL != "" && (err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "") {
+		errs = append(errs, fmt.Errorf("TLE_SOURCE_URL %q is not an http or https URL", c.URL))
+	}
+	return c, errs
+}
+
+// TLEFetcher keeps the catalog's element sets current: every TLE_REFRESH it
+// asks TLE_SOURCE for those of each satellite that has not decayed, adds
+// them to the history and makes the newest each satellite's current TLE.
+type TLEFetcher struct {
+	cfg        TLEConfig
+	satellites *SatelliteStore
+	history    *TLEStore
+}
+
+func newTLEFetcher(cfg TLEConfig, satellites *SatelliteStore, history *TLEStore) *TLEFetcher {
+	return &TLEFetcher{cfg: cfg, satellites: satellites, history: history}
+}
+
+// Start fetches the element sets now and every TLE_REFRESH until ctx is
+// done. It does nothing without TLE_SOURCE.
+func (f *TLEFetcher) Start(ctx context.Context) {
+	if f.cfg.Source == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(f.cfg.Refresh)
+		defer ticker.Stop()
+		for {
+			f.refresh(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refresh runs one round of fetching.
+func (f *TLEFetcher) refresh(ctx context.Context) {
+	var ids []int
+	for _, sat := range f.satellites.List("") {
+		if sat.Status != SatelliteDecayed {
+			ids = append(ids, sat.NoradID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	fetched, failed := f.fetch(ctx, ids)
+
+	counts := map[string]int{}
+	for _, sat := range f.satellites.List("") {
+		if sat.Status == SatelliteDecayed {
+			continue
+		}
+		result := f.apply(ctx, sat, fetched, failed)
+		counts[result]++
+		tleFetched.WithLabelValues(result).Inc()
+	}
+	slog.InfoContext(ctx, "refreshed TLEs", "source", f.cfg.Source, "results", counts)
+}
+
+// apply records the element set fetched for sat, returning the result it is
+// counted under: updated, unchanged, missing or failed.
+func (f *TLEFetcher) apply(ctx context.Context, sat Satellite, fetched map[int]TLE, failed map[int]bool) string {
+	tle, ok := fetched[sat.NoradID]
+	switch {
+	case failed[sat.NoradID]:
+		return "failed"
+	case !ok:
+		return "missing"
+	}
+	if err := f.history.Add(ctx, newTLERecord(sat.ID, tle, f.cfg.Source)); err != nil {
+		slog.ErrorContext(ctx, "failed to record TLE", "satellite", sat.ID, "err", err)
+		return "failed"
+	}
+	if sat.TLE != nil && !tle.Epoch.After(sat.TLE.Epoch) {
+		return "unchanged"
+	}
+	sat.TLE = &tle
+	if _, _, err := f.satellites.Put(ctx, sat); err != nil {
+		slog.ErrorContext(ctx, "failed to update satellite TLE", "satellite", sat.ID, "err", err)
+		return "failed"
+	}
+	return "updated"
+}
+
+// fetch asks the source for the element sets of the catalog numbers ids. It
+// returns those found, and the numbers whose requests failed.
+func (f *TLEFetcher) fetch(ctx context.Context, ids []int) (map[int]TLE, map[int]bool) {
+	fetched, failed := map[int]TLE{}, map[int]bool{}
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, Timeout: tleFetchTimeout}
+	fail := func(batch []int, err error) {
+		slog.ErrorContext(ctx, "failed to fetch TLEs", "source", f.cfg.Source, "satellites", len(batch), "err", err)
+		for _, id := range batch {
+			failed[id] = true
+		}
+	}
+
+	if f.cfg.Source == TLESourceSpaceTrack {
+		if err := f.spaceTrackLogin(ctx, client); err != nil {
+			fail(ids, err)
+			return fetched, failed
+		}
+		for start := 0; start < len(ids); start += spaceTrackBatch {
+			batch := ids[start:min(start+spaceTrackBatch, len(ids))]
+			numbers := make([]string, len(batch))
+			for i, id := range batch {
+				numbers[i] = strconv.Itoa(id)
+			}
+			err := f.get(ctx, client, f.cfg.URL+"/basicspacedata/query/class/gp/NORAD_CAT_ID/"+strings.Join(numbers, ",")+"/orderby/NORAD_CAT_ID/format/tle", fetched)
+			if err != nil {
+				fail(batch, err)
+			}
+		}
+		return fetched, failed
+	}
+
+	// Celestrak answers for one catalog number at a time.
+	for _, id := range ids {
+		if err := f.get(ctx, client, f.cfg.URL+"/NORAD/elements/gp.php?FORMAT=TLE&CATNR="+strconv.Itoa(id), fetched); err != nil {
+			fail([]int{id}, err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fetched, failed
+}
+
+// spaceTrackLogin signs client in to Space-Track, which keeps the session
+// in a cookie.
+func (f *TLEFetcher) spaceTrackLogin(ctx context.Context, client *http.Client) error {
+	form := url.Values{"identity": {f.cfg.Username}, "password": {f.cfg.Password}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.cfg.URL+"/ajaxauth/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return err
+	}
+	// A refused login is still answered 200, with {"Login":"Failed"}.
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(body), "Failed") {
+		return fmt.Errorf("space-track login: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// get reads the element sets at u into fetched.
+func (f *TLEFetcher) get(ctx context.Context, client *http.Client, u string, fetched map[int]TLE) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
+	}
+	return parseTLEs(io.LimitReader(resp.Body, maxTLEResponse), fetched)
+}
+
+// parseTLEs reads the element sets in r, in two- or three-line format, into
+// found, keeping the newest of each catalog number. Lines that are not part
+// of a valid element set are skipped.
+func parseTLEs(r io.Reader, found map[int]TLE) error {
+	scanner := bufio.NewScanner(r)
+	var prev string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if strings.HasPrefix(line, "2 ") && strings.HasPrefix(prev, "1 ") {
+			if tle, catalog, err := parseTLE(prev, line); err == nil {
+				if old, ok := found[catalog]; !ok || tle.Epoch.After(old.Epoch) {
+					found[catalog] = tle
+				}
+			}
+		}
+		prev = line
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	defaultAutocertCache = "autocert-cache"
+	// readHeaderTimeout bounds how long a client may take to send its
+	// request headers, so idle connections cannot pile up.
+	readHeaderTimeout = 10 * time.Second
+	// clientCertPrefix starts the ID of callers authenticated by a client
+	// certificate, followed by its subject's common name.
+	clientCertPrefix = "cert:"
+)
+
+// TLSConfig is how the server terminates TLS itself, rather than behind a
+// proxy.
+type TLSConfig struct {
+	// CertFile and KeyFile are a PEM certificate chain and its key.
+	CertFile, KeyFile string
+	// AutocertDomains are the host names to get certificates for from
+	// Let's Encrypt, cached in AutocertCache, in place of CertFile.
+	AutocertDomains []string
+	AutocertCache   string
+	AutocertEmail   string
+	// HTTPPort, when set, serves plain HTTP redirecting to HTTPS, and the
+	// ACME HTTP-01 challenges of autocert.
+	HTTPPort int
+	// ClientCAFile holds the CAs client certificates are verified against;
+	// ClientCertRequired refuses connections without one.
+	ClientCAFile       string
+	ClientCertRequired bool
+	// ClientScopes are granted to callers sending a verified client
+	// certificate and no bearer token.
+	ClientScopes []string
+	// H2C serves HTTP/2 without TLS, for load balancers that speak it to
+	// their backends.
+	H2C bool
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || len(c.AutocertDomains) > 0
+}
+
+// loadTLSConfig reads TLS_CERT_FILE, TLS_KEY_FILE, TLS_AUTOCERT_DOMAINS,
+// TLS_AUTOCERT_CACHE, TLS_AUTOCERT_EMAIL, TLS_HTTP_PORT, TLS_CLIENT_CA_FILE,
+// TLS_CLIENT_CERT_REQUIRED, TLS_CLIENT_SCOPES, a comma-separated list of
+// roles and scopes, and HTTP2_CLEARTEXT.
+func loadTLSConfig() (TLSConfig, []error) {
+	c := TLSConfig{
+		CertFile:        os.Getenv("TLS_CERT_FILE"),
+		KeyFile:         os.Getenv("TLS_KEY_FILE"),
+		AutocertDomains: splitList(os.Getenv("TLS_AUTOCERT_DOMAINS")),
+		AutocertCache:   os.Getenv("TLS_AUTOCERT_CACHE"),
+		AutocertEmail:   os.Getenv("TLS_AUTOCERT_EMAIL"),
+		ClientCAFile:    os.Getenv("TLS_CLIENT_CA_FILE"),
+	}
+	if c.AutocertCache == "" {
+		c.AutocertCache = defaultAutocertCache
+	}
+	var errs []error
+	if v := os.Getenv("TLS_HTTP_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("TLS_HTTP_PORT %q is not a port number", v))
+		}
+		c.HTTPPort = port
+	}
+	for _, b := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"TLS_CLIENT_CERT_REQUIRED", &c.ClientCertRequired},
+		{"HTTP2_CLEARTEXT", &c.H2C},
+	} {
+		if v := os.Getenv(b.name); v != "" {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q is not a boolean", b.name, v))
+			}
+			*b.dst = enabled
+		}
+	}
+	for _, grant := range splitList(os.Getenv("TLS_CLIENT_SCOPES")) {
+		switch {
+		case roleScopes[grant] != nil:
+			c.ClientScopes = append(c.ClientScopes, roleScopes[grant]...)
+		case slices.Contains(scopes, grant):
+			c.ClientScopes = append(c.ClientScopes, grant)
+		default:
+			errs = append(errs, fmt.Errorf("TLS_CLIENT_SCOPES: unknown role or scope %q", grant))
+		}
+	}
+	c.ClientScopes = slices.Compact(slices.Sorted(slices.Values(c.ClientScopes)))
+	return c, errs
+}
+
+// validate reports the settings that contradict each other.
+func (c TLSConfig) validate() []error {
+	var errs []error
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if c.CertFile != "" && len(c.AutocertDomains) > 0 {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS cannot both be set"))
+	}
+	if !c.enabled() {
+		for _, s := range []struct {
+			name string
+			set  bool
+		}{
+			{"TLS_HTTP_PORT", c.HTTPPort != 0},
+			{"TLS_CLIENT_CA_FILE", c.ClientCAFile != ""},
+		} {
+			if s.set {
+				errs = append(errs, fmt.Errorf("%s needs TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS", s.name))
+			}
+		}
+	}
+	if c.H2C && c.enabled() {
+		errs = append(errs, errors.New("HTTP2_CLEARTEXT cannot be set with TLS, which negotiates HTTP/2 itself"))
+	}
+	if c.ClientCAFile == "" && (c.ClientCertRequired || len(c.ClientScopes) > 0) {
+		errs = append(errs, errors.New("TLS_CLIENT_CERT_REQUIRED and TLS_CLIENT_SCOPES need TLS_CLIENT_CA_FILE"))
+	}
+	return errs
+}
+
+// serverTLS is the TLS configuration both the HTTP and gRPC listeners use,
+// and the ACME client behind it when autocert is on.
+type serverTLS struct {
+	config   *tls.Config
+	autocert *autocert.Manager
+}
+
+// newServerTLS loads the certificates c names, or returns nil when TLS is
+// off.
+func newServerTLS(c TLSConfig) (*serverTLS, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	s := &serverTLS{}
+	if len(c.AutocertDomains) > 0 {
+		s.autocert = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutocertDomains...),
+			Cache:      autocert.DirCache(c.AutocertCache),
+			Email:      c.AutocertEmail,
+		}
+		s.config = s.autocert.TLSConfig()
+	} else {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS_CERT_FILE: %w", err)
+		}
+		s.config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	s.config.MinVersion = tls.VersionTLS12
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read TLS_CLIENT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE %s holds no PEM certificates", c.ClientCAFile)
+		}
+		s.config.ClientCAs = pool
+		s.config.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.ClientCertRequired {
+			s.config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return s, nil
+}
+
+// grpcCredentials are the transport credentials of the gRPC server, which
+// negotiates h2 on the same certificates.
+func (s *serverTLS) grpcCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(s.config.Clone())
+}
+
+// newHTTPServer returns the server for handler on PORT. With TLS it serves
+// HTTP/1.1 and HTTP/2, negotiated by ALPN; without, HTTP/1.1 and, with
+// HTTP2_CLEARTEXT, HTTP/2 as h2c.
+func newHTTPServer(cfg *Config, s *serverTLS, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Port),
+		Handler:           identifyClientCert(handler),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	if s != nil {
+		srv.TLSConfig = s.config.Clone()
+	}
+	if cfg.TLS.H2C {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
+
+// serveHTTP serves handler on PORT until the process exits, and with
+// TLS_HTTP_PORT the redirect to it.
+func serveHTTP(cfg *Config, s *serverTLS, handler http.Handler) {
+	srv := newHTTPServer(cfg, s, handler)
+	if s == nil {
+		slog.Info("serving http", "port", cfg.Port, "h2c", cfg.TLS.H2C)
+		if err := srv.ListenAndServe(); err != nil {
+			fatal("http server failed", "err", err)
+		}
+		return
+	}
+	if cfg.TLS.HTTPPort != 0 {
+		go serveRedirect(cfg, s)
+	}
+	slog.Info("serving https", "port", cfg.Port, "autocert", s.autocert != nil, "client_ca", cfg.TLS.ClientCAFile != "")
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
+		fatal("https server failed", "err", err)
+	}
+}
+
+// serveRedirect answers plain HTTP on TLS_HTTP_PORT, redirecting to PORT,
+// and serves autocert's HTTP-01 challenges.
+func serveRedirect(cfg *Config, s *serverTLS) {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if cfg.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if s.autocert != nil {
+		handler = s.autocert.HTTPHandler(handler)
+	}
+	srv := &http.Server{Addr: ":" + strconv.Itoa(cfg.TLS.HTTPPort), Handler: handler, ReadHeaderTimeout: readHeaderTimeout}
+	slog.Info("serving https redirect", "port", cfg.TLS.HTTPPort)
+	if err := srv.ListenAndServe(); err != nil {
+		fatal("http redirect server failed", "err", err)
+	}
+}
+
+type clientCertContextKey struct{}
+
+// withClientCert records the verified client certificate of a connection,
+// if any, for authenticate.
+func withClientCert(ctx context.Context, state *tls.ConnectionState) context.Context {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, clientCertContextKey{}, state.VerifiedChains[0][0])
+}
+
+func clientCertFrom(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(clientCertContextKey{}).(*x509.Certificate)
+	return cert
+}
+
+// identifyClientCert passes each request's verified client certificate on
+// in its context.
+func identifyClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			r = r.WithContext(withClientCert(r.Context(), r.TLS))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcClientCert is withClientCert for the peer of a gRPC call.
+func grpcClientCert(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx
+	}
+	return withClientCert(ctx, &info.State)
+}
+
+// certPrincipal is the caller holding the verified client certificate in
+// ctx, or nil when there is none or TLS_CLIENT_SCOPES grants it nothing.
+func certPrincipal(ctx context.Context, cfg *Config) *Principal {
+	cert := clientCertFrom(ctx)
+	if cert == nil || len(cfg.TLS.ClientScopes) == 0 {
+		return nil
+	}
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = strings.ToLower(cert.SerialNumber.Text(16))
+	}
+	return &Principal{ID: clientCertPrefix + name, Scopes: cfg.TLS.ClientScopes}
+}
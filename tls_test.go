@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testCert issues a certificate for name signed by parent, or self-signed
+// when parent is nil.
+func testCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes cert, and its key when key is set, to files in dir.
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate, key bool) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if key {
+		der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyFile = filepath.Join(dir, name+"-key.pem")
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestServeTLS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	ca := testCert(t, "test-ca", nil)
+	caFile, _ := writePEM(t, dir, "ca", ca, false)
+	certFile, keyFile := writePEM(t, dir, "server", testCert(t, "127.0.0.1", &ca), true)
+	client := testCert(t, "ingest-worker", &ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	for _, required := range []bool{false, true} {
+		cfg := &Config{TLS: TLSConfig{
+			CertFile:           certFile,
+			KeyFile:            keyFile,
+			ClientCAFile:       caFile,
+			ClientCertRequired: required,
+			ClientScopes:       []string{ScopeImagesWrite},
+		}}
+		s, err := newServerTLS(cfg.TLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router := gin.New()
+		router.GET("/whoami", requireScope(cfg, nil, "", false), func(c *gin.Context) {
+			id := "anonymous"
+			if p := principalFrom(c.Request.Context()); p != nil {
+				id = p.ID
+			}
+			c.String(http.StatusOK, c.Request.Proto+" "+id)
+		})
+		srv := newHTTPServer(cfg, s, router)
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go srv.ServeTLS(lis, "", "")
+		t.Cleanup(func() { srv.Close() })
+
+		get := func(certs ...tls.Certificate) (string, error) {
+			c := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					if len(certs) == 0 {
+						return &tls.Certificate{}, nil
+					}
+					return &certs[0], nil
+				}},
+				ForceAttemptHTTP2: true,
+			}}
+			resp, err := c.Get("https://" + lis.Addr().String() + "/whoami")
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			return string(body), err
+		}
+
+		if got, err := get(client); err != nil || got != "HTTP/2.0 cert:ingest-worker" {
+			t.Errorf("required=%v: with a client certificate: %q, %v", required, got, err)
+		}
+		got, err := get()
+		switch {
+		case required && err == nil:
+			t.Errorf("required=%v: without a client certificate: %q", required, got)
+		case !required && (err != nil || got != "HTTP/2.0 anonymous"):
+			t.Errorf("required=%v: without a client certificate: %q, %v", required, got, err)
+		}
+		if _, err := get(testCert(t, "stranger", nil)); err == nil {
+			t.Errorf("required=%v: an unknown CA's certificate was accepted", required)
+		}
+	}
+}
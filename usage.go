@@ -0,0 +1,410 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// usageDayIndex is the USAGE_TABLE index partitioned by UTC day, which
+	// GET /usage queries a day at a time.
+	usageDayIndex = "day-id"
+	// usageFlushInterval is how often counts are added to USAGE_TABLE,
+	// and so how far behind it may be.
+	usageFlushInterval = time.Minute
+	usageWriteTimeout  = 10 * time.Second
+	// Without USAGE_TABLE the last usageMemoryDays are kept.
+	usageMemoryDays = 92
+	// GET /usage covers the last defaultUsageDays by default and at most
+	// maxUsageDays.
+	defaultUsageDays = 30
+	maxUsageDays     = 92
+	// anonymousClient is who a job submitted without credentials is
+	// charged to.
+	anonymousClient = "anonymous"
+)
+
+// usageExempt are the routes not metered: probes, metrics and docs.
+var usageExempt = map[string]bool{
+	"/ping":         true,
+	"/metrics":      true,
+	"/healthz":      true,
+	"/livez":        true,
+	"/readyz":       true,
+	"/openapi.json": true,
+	"/docs":         true,
+}
+
+// UsageRollup is what one client of one tenant used on one UTC day.
+type UsageRollup struct {
+	ID string `dynamodbav:"id" json:"-"`
+	// Day is a UTC date such as 2026-10-14. Totals have none.
+	Day    string `dynamodbav:"day" json:"day,omitempty"`
+	Tenant string `dynamodbav:"tenant,omitempty" json:"tenant,omitempty"`
+	// Client is the API key ID, OIDC subject or admin, or ip:<address>
+	// for anonymous requests.
+	Client   string `dynamodbav:"client" json:"client"`
+	Requests int64  `dynamodbav:"requests" json:"requests"`
+	// Bytes is the size of the response bodies, before compression.
+	Bytes int64 `dynamodbav:"bytes" json:"bytes"`
+	// CPUSeconds is the time spent decoding, processing and encoding
+	// imagery, in requests and in the jobs they started.
+	CPUSeconds float64 `dynamodbav:"cpu_seconds" json:"cpu_seconds"`
+}
+
+func (r *UsageRollup) add(o *UsageRollup) {
+	r.Requests += o.Requests
+	r.Bytes += o.Bytes
+	r.CPUSeconds += o.CPUSeconds
+}
+
+// usageID keys the rollup in USAGE_TABLE. Tenant names hold no slash, so
+// the parts cannot run together.
+func usageID(day, tenant, client string) string {
+	return day + "/" + tenant + "/" + client
+}
+
+// usageTally is the processing time of one request or job, charged to its
+// caller once it is done.
+type usageTally struct {
+	cpu atomic.Int64
+}
+
+type usageTallyKey struct{}
+
+func withUsageTally(ctx context.Context, t *usageTally) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, usageTallyKey{}, t)
+}
+
+func usageTallyFrom(ctx context.Context) *usageTally {
+	t, _ := ctx.Value(usageTallyKey{}).(*usageTally)
+	return t
+}
+
+// chargeProcessing adds d of processing to ctx's tally, if it has one.
+func chargeProcessing(ctx context.Context, d time.Duration) {
+	if t := usageTallyFrom(ctx); t != nil {
+		t.cpu.Add(int64(d))
+	}
+}
+
+// usageFilter selects rollups for GET /usage. Empty fields match all.
+type usageFilter struct {
+	Tenant, Client string
+	// Since and Until are the first and last UTC days.
+	Since, Until time.Time
+}
+
+func (f usageFilter) matches(r *UsageRollup) bool {
+	return (f.Tenant == "" || r.Tenant == f.Tenant) &&
+		(f.Client == "" || r.Client == f.Client) &&
+		r.Day >= f.Since.Format(time.DateOnly) && r.Day <= f.Until.Format(time.DateOnly)
+}
+
+// UsageMeter counts requests, response bytes and processing time per tenant
+// and client, rolled up by UTC day. With USAGE_TABLE set every instance adds
+// its counts to DynamoDB each minute; without it each keeps its own in
+// memory.
+type UsageMeter struct {
+	now func() time.Time
+
+	mu sync.Mutex
+	// rollups are the totals without USAGE_TABLE, and the counts not yet
+	// added to it with one.
+	rollups map[string]*UsageRollup
+
+	db    *dynamodb.Client
+	table string
+}
+
+func newUsageMeter(db *dynamodb.Client, table string) *UsageMeter {
+	m := &UsageMeter{now: time.Now, rollups: map[string]*UsageRollup{}, table: table}
+	if m.table != "" {
+		m.db = db
+	}
+	return m
+}
+
+// Start adds the counts to USAGE_TABLE every usageFlushInterval, or drops
+// days older than usageMemoryDays without it, until ctx is done.
+func (m *UsageMeter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if m.db != nil {
+					m.flush(ctx)
+				} else {
+					m.sweep()
+				}
+			}
+		}
+	}()
+}
+
+// Add counts usage by client of tenant today.
+func (m *UsageMeter) Add(tenant, client string, requests, bytes int64, cpu time.Duration) {
+	if m == nil {
+		return
+	}
+	day := m.now().UTC().Format(time.DateOnly)
+	m.merge(&UsageRollup{Day: day, Tenant: tenant, Client: client, Requests: requests, Bytes: bytes, CPUSeconds: cpu.Seconds()})
+}
+
+func (m *UsageMeter) merge(r *UsageRollup) {
+	id := usageID(r.Day, r.Tenant, r.Client)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if have, ok := m.rollups[id]; ok {
+		have.add(r)
+		return
+	}
+	r.ID = id
+	m.rollups[id] = r
+}
+
+func (m *UsageMeter) sweep() {
+	oldest := m.now().UTC().AddDate(0, 0, -usageMemoryDays).Format(time.DateOnly)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, r := range m.rollups {
+		if r.Day < oldest {
+			delete(m.rollups, id)
+		}
+	}
+}
+
+// flush adds the pending counts to USAGE_TABLE. Counts that could not be
+// written are kept for the next flush.
+func (m *UsageMeter) flush(ctx context.Context) {
+	m.mu.Lock()
+	pending := m.rollups
+	m.rollups = map[string]*UsageRollup{}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, usageWriteTimeout)
+	defer cancel()
+	for _, r := range pending {
+		if err := m.write(ctx, r); err != nil {
+			usageFailures.Inc()
+			slog.ErrorContext(ctx, "failed to record usage", "tenant", r.Tenant, "client", r.Client, "err", err)
+			m.merge(r)
+		}
+	}
+}
+
+// write adds r to its item, creating it on the first write of the day.
+func (m *UsageMeter) write(ctx context.Context, r *UsageRollup) error {
+	_, err := m.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(m.table),
+		Key:                      map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: r.ID}},
+		UpdateExpression:         aws.String("SET #day = :day, #tenant = :tenant, #client = :client ADD #requests :requests, #bytes :bytes, #cpu :cpu"),
+		ExpressionAttributeNames: map[string]string{"#day": "day", "#tenant": "tenant", "#client": "client", "#requests": "requests", "#bytes": "bytes", "#cpu": "cpu_seconds"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":day":      &types.AttributeValueMemberS{Value: r.Day},
+			":tenant":   &types.AttributeValueMemberS{Value: r.Tenant},
+			":client":   &types.AttributeValueMemberS{Value: r.Client},
+			":requests": &types.AttributeValueMemberN{Value: strconv.FormatInt(r.Requests, 10)},
+			":bytes":    &types.AttributeValueMemberN{Value: strconv.FormatInt(r.Bytes, 10)},
+			":cpu":      &types.AttributeValueMemberN{Value: strconv.FormatFloat(r.CPUSeconds, 'f', 6, 64)},
+		},
+	})
+	return err
+}
+
+// Query returns the rollups f selects, by day and then tenant and client.
+func (m *UsageMeter) Query(ctx context.Context, f usageFilter) ([]UsageRollup, error) {
+	var found []UsageRollup
+	if m.db == nil {
+		m.mu.Lock()
+		for _, r := range m.rollups {
+			if f.matches(r) {
+				found = append(found, *r)
+			}
+		}
+		m.mu.Unlock()
+	} else {
+		for day := f.Since; !day.After(f.Until); day = day.AddDate(0, 0, 1) {
+			rollups, err := m.queryDay(ctx, day.Format(time.DateOnly), f)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, rollups...)
+		}
+	}
+	slices.SortFunc(found, func(a, b UsageRollup) int {
+		return cmp.Or(cmp.Compare(a.Day, b.Day), cmp.Compare(a.Tenant, b.Tenant), cmp.Compare(a.Client, b.Client))
+	})
+	return found, nil
+}
+
+func (m *UsageMeter) queryDay(ctx context.Context, day string, f usageFilter) ([]UsageRollup, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(m.table),
+		IndexName:                 aws.String(usageDayIndex),
+		KeyConditionExpression:    aws.String("#day = :day"),
+		ExpressionAttributeNames:  map[string]string{"#day": "day"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":day": &types.AttributeValueMemberS{Value: day}},
+	}
+	if f.Tenant != "" {
+		input.KeyConditionExpression = aws.String("#day = :day AND begins_with(id, :prefix)")
+		input.ExpressionAttributeValues[":prefix"] = &types.AttributeValueMemberS{Value: usageID(day, f.Tenant, "")}
+	}
+	if f.Client != "" {
+		input.FilterExpression = aws.String("#client = :client")
+		input.ExpressionAttributeNames["#client"] = "client"
+		input.ExpressionAttributeValues[":client"] = &types.AttributeValueMemberS{Value: f.Client}
+	}
+	var found []UsageRollup
+	paginator := dynamodb.NewQueryPaginator(m.db, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var rollups []UsageRollup
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &rollups); err != nil {
+			return nil, err
+		}
+		found = append(found, rollups...)
+	}
+	return found, nil
+}
+
+// meterUsage counts every request to a metered route against its caller,
+// as rateClient names them, once it has been answered. Processing done for
+// it is charged to the same caller.
+func (api *API) meterUsage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tally := &usageTally{}
+		c.Request = c.Request.WithContext(withUsageTally(c.Request.Context(), tally))
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" || usageExempt[route] {
+			return
+		}
+		api.Usage.Add(tenantFrom(c.Request.Context()), rateClient(c), 1, int64(max(c.Writer.Size(), 0)), time.Duration(tally.cpu.Load()))
+		api.meterServed(c)
+	}
+}
+
+// meteredJob charges the processing of each attempt of fn's jobs to the
+// caller that submitted it.
+func (api *API) meteredJob(fn JobFunc) JobFunc {
+	return func(ctx context.Context, job Job) (string, string, error) {
+		tally := &usageTally{}
+		defer func() {
+			client := job.Client
+			if client == "" {
+				client = anonymousClient
+			}
+			api.Usage.Add(job.Tenant, client, 0, 0, time.Duration(tally.cpu.Load()))
+		}()
+		return fn(withUsageTally(ctx, tally), job)
+	}
+}
+
+// UsageResponse is the body of GET /usage.
+type UsageResponse struct {
+	Since string `json:"since"`
+	Until string `json:"until"`
+	// Days are the rollups, by day and then tenant and client.
+	Days []UsageRollup `json:"days"`
+	// Totals sum Days per tenant and client, the heaviest users of
+	// processing first.
+	Totals []UsageRollup `json:"totals"`
+}
+
+// usageTotals sums rollups per tenant and client.
+func usageTotals(rollups []UsageRollup) []UsageRollup {
+	sums := map[string]*UsageRollup{}
+	for _, r := range rollups {
+		id := usageID("", r.Tenant, r.Client)
+		if _, ok := sums[id]; !ok {
+			sums[id] = &UsageRollup{Tenant: r.Tenant, Client: r.Client}
+		}
+		sums[id].add(&r)
+	}
+	totals := []UsageRollup{}
+	for _, r := range sums {
+		totals = append(totals, *r)
+	}
+	slices.SortFunc(totals, func(a, b UsageRollup) int {
+		return cmp.Or(cmp.Compare(b.CPUSeconds, a.CPUSeconds), cmp.Compare(b.Requests, a.Requests),
+			cmp.Compare(a.Tenant, b.Tenant), cmp.Compare(a.Client, b.Client))
+	})
+	return totals
+}
+
+// getUsage reports the usage matching ?tenant=, ?client=, ?since= and
+// ?until=. Callers other than ADMIN_TOKEN see only their own tenant's.
+func (api *API) getUsage(c *gin.Context) {
+	ctx := c.Request.Context()
+	today := api.Usage.now().UTC().Truncate(24 * time.Hour)
+	f := usageFilter{Tenant: c.Query("tenant"), Client: c.Query("client"), Until: today}
+	if p := principalFrom(ctx); api.Config.MultiTenant && p != adminPrincipal {
+		f.Tenant = tenantFrom(ctx)
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"until", &f.Until}, {"since", &f.Since}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid '%s' parameter. Must be a date such as 2026-10-14.", p.name))
+				return
+			}
+			*p.dst = t
+		}
+	}
+	if f.Since.IsZero() {
+		f.Since = f.Until.AddDate(0, 0, 1-defaultUsageDays)
+	}
+	if f.Since.After(f.Until) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "Invalid 'since' parameter. Must not be after 'until'.")
+		return
+	}
+	if f.Since.AddDate(0, 0, maxUsageDays).Before(f.Until.AddDate(0, 0, 1)) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("The range from 'since' to 'until' may cover at most %d days.", maxUsageDays))
+		return
+	}
+
+	rollups, err := api.Usage.Query(ctx, f)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to query usage", "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to query usage")
+		return
+	}
+	if rollups == nil {
+		rollups = []UsageRollup{}
+	}
+	c.IndentedJSON(http.StatusOK, UsageResponse{
+		Since:  f.Since.Format(time.DateOnly),
+		Until:  f.Until.Format(time.DateOnly),
+		Days:   rollups,
+		Totals: usageTotals(rollups),
+	})
+}
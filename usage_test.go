@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMeterUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &Config{AdminToken: "secret"}
+	meter := newUsageMeter(nil, "")
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	meter.now = func() time.Time { return now }
+	api := &API{Config: cfg, Usage: meter}
+	router := gin.New()
+	router.Use(api.meterUsage())
+	router.GET("/healthz", getHealthz)
+	router.GET("/image/:id", api.require(ScopeImagesRead), func(c *gin.Context) {
+		chargeProcessing(c.Request.Context(), 1500*time.Millisecond)
+		c.String(http.StatusOK, "0123456789")
+	})
+	router.GET("/usage", api.require(ScopeAdmin), api.getUsage)
+	do := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("/image/i1", "")
+	do("/image/i2", "")
+	do("/image/i1", "secret")
+	do("/healthz", "")
+	job := api.meteredJob(func(ctx context.Context, job Job) (string, string, error) {
+		chargeProcessing(ctx, 2*time.Second)
+		return "", "", nil
+	})
+	job(context.Background(), Job{Client: "key1"})
+	now = now.AddDate(0, 0, -1)
+	do("/image/i3", "")
+	now = now.AddDate(0, 0, 1)
+
+	usage := func(query string) UsageResponse {
+		t.Helper()
+		w := do("/usage"+query, "secret")
+		var resp UsageResponse
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("GET /usage%s: %d %s", query, w.Code, w.Body)
+		}
+		return resp
+	}
+
+	all := usage("")
+	if all.Since != "2026-09-15" || all.Until != "2026-10-14" || len(all.Days) != 4 {
+		t.Fatalf("usage = %+v", all)
+	}
+	want := []UsageRollup{
+		{Client: "ip:192.0.2.1", Requests: 3, Bytes: 30, CPUSeconds: 4.5},
+		{Client: "key1", CPUSeconds: 2},
+		{Client: "admin", Requests: 1, Bytes: 10, CPUSeconds: 1.5},
+	}
+	if len(all.Totals) != len(want) {
+		t.Fatalf("totals = %+v", all.Totals)
+	}
+	for i, w := range want {
+		if all.Totals[i] != w {
+			t.Errorf("totals[%d] = %+v, want %+v", i, all.Totals[i], w)
+		}
+	}
+
+	tests := []struct {
+		query string
+		days  int
+	}{
+		{"?client=admin", 1},
+		{"?since=2026-10-14", 3},
+		{"?until=2026-10-13", 1},
+		{"?tenant=acme", 0},
+	}
+	for _, tt := range tests {
+		if got := usage(tt.query); len(got.Days) != tt.days {
+			t.Errorf("%s: days = %+v, want %d", tt.query, got.Days, tt.days)
+		}
+	}
+
+	for _, query := range []string{"?since=yesterday", "?since=2026-10-15", "?since=2026-01-01"} {
+		if w := do("/usage"+query, "secret"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", query, w.Code)
+		}
+	}
+}
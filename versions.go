@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const apiV1Prefix = "/api/v1"
+
+var (
+	// legacyDeprecatedAt is when the unversioned routes were deprecated in
+	// favour of /api/v1, sent in their Deprecation header.
+	legacyDeprecatedAt = time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+	// defaultLegacySunset is when they may be removed, unless
+	// LEGACY_ROUTES_SUNSET says otherwise.
+	defaultLegacySunset = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// routesV1 registers version 1 of the API on r. Each version gets its own
+// function like this one, so /api/v2 can point a route at a handler with a
+// different response shape while v1 and its legacy aliases keep theirs.
+// Handlers that no version changes are shared.
+func (api *API) routesV1(r gin.IRouter, short, long gin.HandlerFunc) {
+	missionsRead := api.require(ScopeMissionsRead)
+	missionsWrite := api.require(ScopeMissionsWrite)
+	missionsApprove := api.require(ScopeMissionsApprove)
+	imagesRead := api.require(ScopeImagesRead)
+	imagesWrite := api.require(ScopeImagesWrite)
+	admin := api.require(ScopeAdmin)
+	cheap := api.rateLimit(rateClassJSON)
+	costly := api.rateLimit(rateClassImage)
+	r.GET("/rate-limits", short, api.require(""), api.getRateLimits)
+	r.GET("/usage", short, admin, cheap, api.getUsage)
+	r.GET("/missions", short, missionsRead, cheap, api.getMissions)
+	// An event stream is open for as long as the client listens.
+	r.GET("/missions/events", api.requireStream(ScopeMissionsRead), cheap, api.getMissionEvents)
+	r.GET("/missions/stats", short, missionsRead, cheap, api.getMissionStats)
+	r.POST("/missions/:id/recompute-geometry", short, missionsWrite, cheap, api.postRecomputeGeometry)
+	r.GET("/access-windows", short, missionsRead, cheap, api.getAccessWindows)
+	r.POST("/cdm", short, missionsWrite, cheap, api.postCDM)
+	r.POST("/schedule/optimize", short, missionsWrite, cheap, api.postOptimizeSchedule)
+	r.GET("/mission/:id/downlinks", short, missionsRead, cheap, api.getMissionDownlinks)
+	r.POST("/mission/:id/downlinks", short, missionsWrite, cheap, api.postMissionDownlink)
+	r.GET("/downlinks/overdue", short, missionsRead, cheap, api.getOverdueDownlinks)
+	r.GET("/mission/:id/timeline", short, missionsRead, cheap, api.getMissionTimeline)
+	r.GET("/conjunctions", short, missionsRead, cheap, api.getConjunctions)
+	r.GET("/conjunction/:id", short, missionsRead, cheap, api.getConjunction)
+	r.GET("/satellites", short, missionsRead, cheap, api.getSatellites)
+	r.POST("/satellites", short, admin, api.sharedCatalog, cheap, api.postSatellite)
+	r.GET("/satellite/:id", short, missionsRead, cheap, api.getSatellite)
+	r.GET("/satellite/:id/tle", short, missionsRead, cheap, api.getSatelliteTLE)
+	r.GET("/satellite/:id/ephemeris", short, missionsRead, cheap, api.getEphemeris)
+	r.GET("/satellite/:id/groundtrack", short, missionsRead, cheap, api.getGroundTrack)
+	r.GET("/satellite/:id/missions", short, missionsRead, cheap, api.getSatelliteMissions)
+	r.GET("/satellite/:id/characterization", long, imagesRead, cheap, api.getCharacterization)
+	r.GET("/satellite/:id/sensor", short, missionsRead, cheap, api.getSensor)
+	r.PUT("/satellite/:id/sensor", short, admin, api.sharedCatalog, cheap, api.putSensor)
+	r.DELETE("/satellite/:id/sensor", short, admin, api.sharedCatalog, cheap, api.deleteSensor)
+	r.GET("/sensors", short, missionsRead, cheap, api.getSensors)
+	r.GET("/mission/:id", short, missionsRead, cheap, api.getMissionById)
+	r.POST("/mission/:id/invalidate", short, missionsWrite, cheap, api.postMissionInvalidate)
+	r.POST("/mission/:id/approve", short, missionsApprove, cheap, api.postMissionApprove)
+	r.POST("/mission/:id/cancel", short, missionsWrite, cheap, api.postMissionCancel)
+	r.GET("/mission/:id/pointing-plan", short, missionsRead, cheap, api.getPointingPlan)
+	r.GET("/mission/:id/detections", short, missionsRead, cheap, api.getMissionDetections)
+	r.GET("/mission/:id/images", short, missionsRead, cheap, api.getMissionImages)
+	r.GET("/mission/:id/images.zip", long, imagesRead, costly, api.getMissionArchive)
+	r.GET("/mission/:id/contact-sheet", long, imagesRead, costly, api.getContactSheet)
+	r.GET("/mission/:id/timelapse", long, imagesRead, costly, api.getTimelapse)
+	r.GET("/mission/:id/lightcurve", long, imagesRead, costly, api.getLightCurve)
+	r.GET("/image/:id", long, api.requireSignedOr(imagesRead), costly, acceptCustomerKey, api.getSatImageByID)
+	r.HEAD("/image/:id", long, api.requireSignedOr(imagesRead), costly, acceptCustomerKey, api.getSatImageByID)
+	r.POST("/image/:id/signed-url", short, imagesRead, cheap, api.postSignedURL)
+	r.GET("/image/:id/metadata", short, imagesRead, cheap, api.getImageMetadata)
+	r.GET("/image/:id/versions", short, imagesRead, cheap, api.getImageVersions)
+	r.GET("/image/:id/versions/:version", long, imagesRead, costly, api.getImageVersion)
+	r.GET("/image/:id/photometry", long, imagesRead, costly, api.getPhotometry)
+	r.GET("/image/:id/geometry", short, imagesRead, cheap, api.getImageGeometry)
+	r.POST("/image/:id/platesolve", short, imagesWrite, cheap, api.postPlateSolve)
+	r.GET("/image/:id/detections", short, imagesRead, cheap, api.getImageDetections)
+	r.PUT("/image/:id/detections", short, imagesWrite, cheap, api.putImageDetections)
+	r.GET("/image/:id/annotations", short, imagesRead, cheap, api.getAnnotations)
+	r.PUT("/image/:id/annotations", short, imagesWrite, cheap, api.putAnnotations)
+	r.GET("/image/:id/thumbnail", long, imagesRead, costly, api.getThumbnail)
+	r.GET("/image/:id/tiles", short, imagesRead, api.requireFlag(FlagTiles), cheap, api.getTileManifest)
+	r.GET("/image/:id/tiles/:z/:x/:y", long, imagesRead, api.requireFlag(FlagTiles), costly, api.getTile)
+	r.POST("/images/:id/derivatives", short, imagesWrite, cheap, api.postDerivatives)
+	r.GET("/images/diff", long, imagesRead, costly, api.getImageDiff)
+	r.GET("/images/similar/:id", short, imagesRead, cheap, api.getSimilarImages)
+	r.GET("/images/search", short, imagesRead, cheap, api.getImageSearch)
+	r.POST("/images/stack", long, imagesRead, costly, api.postStack)
+	r.GET("/stac", short, missionsRead, cheap, api.getSTACCatalog)
+	r.GET("/stac/conformance", short, missionsRead, cheap, getSTACConformance)
+	r.GET("/stac/collections", short, missionsRead, cheap, api.getSTACCollections)
+	r.GET("/stac/collections/:id", short, missionsRead, cheap, api.getSTACCollection)
+	r.GET("/stac/collections/:id/items", short, missionsRead, cheap, api.getSTACItems)
+	r.GET("/stac/collections/:id/items/:itemId", short, missionsRead, cheap, api.getSTACItem)
+	r.GET("/stac/search", short, missionsRead, cheap, api.getSTACSearch)
+	r.POST("/stac/search", short, missionsRead, cheap, api.postSTACSearch)
+	r.GET("/jobs", short, imagesRead, cheap, api.getJobs)
+	r.POST("/jobs/process", short, imagesRead, costly, api.postProcessJob)
+	r.GET("/jobs/:id", short, imagesRead, cheap, api.getJob)
+	r.GET("/jobs/:id/output", long, imagesRead, costly, api.getJobOutput)
+	r.POST("/webhooks", short, admin, cheap, api.postWebhook)
+	r.GET("/webhooks", short, admin, cheap, api.getWebhooks)
+	r.GET("/webhooks/:id", short, admin, cheap, api.getWebhook)
+	r.DELETE("/webhooks/:id", short, admin, cheap, api.deleteWebhook)
+	r.GET("/webhooks/:id/deliveries", short, admin, cheap, api.getWebhookDeliveries)
+	r.POST("/api-keys", short, admin, cheap, api.postAPIKey)
+	r.GET("/api-keys", short, admin, cheap, api.getAPIKeys)
+	r.GET("/api-keys/:id", short, admin, cheap, api.getAPIKey)
+	r.DELETE("/api-keys/:id", short, admin, cheap, api.deleteAPIKey)
+	r.GET("/roles", short, admin, cheap, getRoles)
+	r.GET("/role-assignments", short, admin, cheap, api.getRoleAssignments)
+	r.GET("/role-assignments/:subject", short, admin, cheap, api.getRoleAssignment)
+	r.PUT("/role-assignments/:subject", short, admin, cheap, api.putRoleAssignment)
+	r.DELETE("/role-assignments/:subject", short, admin, cheap, api.deleteRoleAssignment)
+}
+
+// deprecatedRoute marks the unversioned aliases of the v1 routes with
+// Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and links each to
+// its /api/v1 successor. A zero sunset sends no Sunset header.
+func deprecatedRoute(sunset time.Time) gin.HandlerFunc {
+	deprecation := "@" + strconv.FormatInt(legacyDeprecatedAt.Unix(), 10)
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", "<"+apiV1Prefix+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// jobStatusURL is where a client polls a job started by a v1 request.
+func jobStatusURL(id string) string {
+	return apiV1Prefix + "/jobs/" + id
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVersionedRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{Config: &Config{}, Jobs: newJobStore(nil, "", defaultJobStatusIndex, defaultJobAttempts, defaultJobWorkers)}
+	sunset := time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+	router := gin.New()
+	router.NoRoute(routeNotFound)
+	api.routesV1(router.Group(apiV1Prefix), routeTimeout(0), routeTimeout(0))
+	api.routesV1(router.Group("", deprecatedRoute(sunset)), routeTimeout(0), routeTimeout(0))
+
+	tests := []struct {
+		path           string
+		wantCode       int
+		wantDeprecated bool
+	}{
+		{"/api/v1/jobs", http.StatusOK, false},
+		{"/jobs", http.StatusOK, true},
+		{"/api/v1/jobs/nope", http.StatusNotFound, false},
+		{"/jobs/nope", http.StatusNotFound, true},
+		{"/api/v2/jobs", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			h := w.Header()
+			if !tt.wantDeprecated {
+				if h.Get("Deprecation") != "" || h.Get("Sunset") != "" {
+					t.Errorf("unexpected deprecation headers %v", h)
+				}
+				return
+			}
+			if got := h.Get("Deprecation"); got != "@1791936000" {
+				t.Errorf("Deprecation = %q", got)
+			}
+			if got := h.Get("Sunset"); got != "Fri, 30 Apr 2027 00:00:00 GMT" {
+				t.Errorf("Sunset = %q", got)
+			}
+			if got, want := h.Get("Link"), "</api/v1"+tt.path+`>; rel="successor-version"`; got != want {
+				t.Errorf("Link = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	defaultWebhookAttempts = 6
+	// Retries wait webhookRetryBase, doubling per attempt, up to
+	// webhookRetryMax.
+	webhookRetryBase = 10 * time.Second
+	webhookRetryMax  = 10 * time.Minute
+	webhookTimeout   = 10 * time.Second
+	// With WEBHOOKS_TABLE set, the registrations are reread every
+	// webhookRefresh to pick up changes made through other instances.
+	webhookRefresh = time.Minute
+	// webhookDeliveryLog is how many recent deliveries are kept per webhook.
+	webhookDeliveryLog = 100
+	webhookBuffer      = 256
+	// webhookSenders bounds the requests in flight to all endpoints.
+	webhookSenders  = 16
+	maxWebhooks     = 100
+	minSecretLength = 16
+	webhookUA       = "sat-image-server-webhooks"
+)
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+// Webhook is an endpoint that events are POSTed to.
+type Webhook struct {
+	ID  string `dynamodbav:"id" json:"id"`
+	URL string `dynamodbav:"url" json:"url"`
+	// Events are the event types delivered; empty means all of them.
+	Events []string `dynamodbav:"events,omitempty" json:"events"`
+	// Tenant is the tenant the registering request acted for, whose events
+	// alone are delivered.
+	Tenant string `dynamodbav:"tenant,omitempty" json:"tenant,omitempty"`
+	// Secret signs the deliveries. It is only returned by the request that
+	// registered the webhook.
+	Secret  string `dynamodbav:"secret" json:"secret,omitempty"`
+	Created int64  `dynamodbav:"created" json:"created"`
+}
+
+func (w *Webhook) wants(e Event) bool {
+	return e.Tenant == w.Tenant && (len(w.Events) == 0 || slices.Contains(w.Events, e.Type))
+}
+
+// WebhookDelivery is one event sent, or being sent, to one webhook.
+type WebhookDelivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	EventID   uint64 `json:"event_id"`
+	EventType string `json:"event_type"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	// StatusCode is the endpoint's response to the last attempt; it is
+	// absent when the request got no response.
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// NextAttempt is when a delivery waiting to be retried is sent again.
+	NextAttempt int64 `json:"next_attempt,omitempty"`
+	Created     int64 `json:"created"`
+	Updated     int64 `json:"updated"`
+}
+
+// WebhookStore holds the registered webhooks and delivers events to them.
+// When WEBHOOKS_TABLE is set the registrations are saved to DynamoDB, so
+// they survive a restart and every instance delivers the events it
+// publishes. Without it they live only in this process. The delivery log is
+// kept in memory by the instance that made the deliveries.
+type WebhookStore struct {
+	mu         sync.RWMutex
+	hooks      map[string]*Webhook
+	deliveries map[string][]*WebhookDelivery
+	client     *http.Client
+	attempts   int
+	retryBase  time.Duration
+	senders    chan struct{}
+
+	db    *dynamodb.Client
+	table string
+}
+
+// newWebhookStore returns a store that sends each delivery up to attempts
+// times.
+func newWebhookStore(db *dynamodb.Client, table string, attempts int) *WebhookStore {
+	s := &WebhookStore{
+		hooks:      make(map[string]*Webhook),
+		deliveries: make(map[string][]*WebhookDelivery),
+		client:     &http.Client{Timeout: webhookTimeout},
+		attempts:   attempts,
+		retryBase:  webhookRetryBase,
+		senders:    make(chan struct{}, webhookSenders),
+		table:      table,
+	}
+	if s.table != "" {
+		s.db = db
+	}
+	return s
+}
+
+// Start loads the registrations and delivers the events published on bus
+// until ctx is done.
+func (s *WebhookStore) Start(ctx context.Context, bus *EventBus) {
+	if s.db != nil {
+		if err := s.refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to load webhooks", "err", err)
+		}
+		go func() {
+			ticker := time.NewTicker(webhookRefresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := s.refresh(ctx); err != nil {
+						slog.ErrorContext(ctx, "failed to reload webhooks", "err", err)
+					}
+				}
+			}
+		}()
+	}
+	go consumeEvents(ctx, bus, webhookBuffer, "webhook dispatcher", func(e Event) { s.publish(ctx, e) })
+}
+
+// publish starts a delivery of e to every webhook that wants it.
+func (s *WebhookStore) publish(ctx context.Context, e Event) {
+	var hooks []Webhook
+	s.mu.RLock()
+	for _, w := range s.hooks {
+		if w.wants(e) {
+			hooks = append(hooks, *w)
+		}
+	}
+	s.mu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode event", "id", e.ID, "err", err)
+		return
+	}
+	now := time.Now().Unix()
+	for _, w := range hooks {
+		d := &WebhookDelivery{
+			ID:        newJobID(),
+			WebhookID: w.ID,
+			EventID:   e.ID,
+			EventType: e.Type,
+			Status:    DeliveryPending,
+			Created:   now,
+			Updated:   now,
+		}
+		s.mu.Lock()
+		log := append(s.deliveries[w.ID], d)
+		if len(log) > webhookDeliveryLog {
+			log = slices.Delete(log, 0, len(log)-webhookDeliveryLog)
+		}
+		s.deliveries[w.ID] = log
+		s.mu.Unlock()
+		go s.deliver(ctx, w, d, body)
+	}
+}
+
+// deliver sends body to w until it is accepted, the endpoint refuses it for
+// good, the attempts run out or the webhook is deleted.
+func (s *WebhookStore) deliver(ctx context.Context, w Webhook, d *WebhookDelivery, body []byte) {
+	for {
+		if _, err := s.Get(w.ID); err != nil {
+			s.update(d, func(d *WebhookDelivery) {
+				d.Status = DeliveryFailed
+				d.Error = "webhook deleted"
+				d.NextAttempt = 0
+			})
+			return
+		}
+		select {
+		case s.senders <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		code, err := s.send(ctx, w, d, body)
+		<-s.senders
+
+		var attempts int
+		s.update(d, func(d *WebhookDelivery) {
+			d.Attempts++
+			d.StatusCode = code
+			d.NextAttempt = 0
+			d.Error = ""
+			if err != nil {
+				d.Error = err.Error()
+			}
+			attempts = d.Attempts
+		})
+		if err == nil {
+			s.update(d, func(d *WebhookDelivery) { d.Status = DeliverySucceeded })
+			webhookDeliveries.WithLabelValues(DeliverySucceeded).Inc()
+			return
+		}
+		if !retryableStatus(code) || attempts >= s.attempts {
+			slog.WarnContext(ctx, "webhook delivery failed", "webhook", w.ID, "event", d.EventID, "attempt", attempts, "err", err)
+			s.update(d, func(d *WebhookDelivery) { d.Status = DeliveryFailed })
+			webhookDeliveries.WithLabelValues(DeliveryFailed).Inc()
+			return
+		}
+
+		delay := min(webhookRetryMax, s.retryBase<<(attempts-1))
+		s.update(d, func(d *WebhookDelivery) { d.NextAttempt = time.Now().Add(delay).Unix() })
+		webhookDeliveries.WithLabelValues("retried").Inc()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send makes one attempt and returns the endpoint's status code, if it
+// answered.
+func (s *WebhookStore) send(ctx context.Context, w Webhook, d *WebhookDelivery, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUA)
+	req.Header.Set("X-Sat-Event", d.EventType)
+	req.Header.Set("X-Sat-Delivery", d.ID)
+	req.Header.Set("X-Sat-Signature", signWebhook(w.Secret, time.Now().Unix(), body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryableStatus reports whether a delivery answered with code, or with no
+// response when code is zero, may succeed if sent again. Other client errors
+// mean the endpoint will keep refusing it.
+func retryableStatus(code int) bool {
+	return code == 0 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// signWebhook is the X-Sat-Signature header of a delivery of body sent at
+// unix time t: "t=<t>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed by secret>".
+func signWebhook(secret string, t int64, body []byte) string {
+	ts := strconv.FormatInt(t, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookStore) update(d *WebhookDelivery, fn func(*WebhookDelivery)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(d)
+	d.Updated = time.Now().Unix()
+}
+
+// Create registers w, filling in its ID and creation time.
+func (s *WebhookStore) Create(ctx context.Context, w Webhook) (Webhook, error) {
+	s.mu.RLock()
+	n := len(s.hooks)
+	s.mu.RUnlock()
+	if n >= maxWebhooks {
+		return Webhook{}, newProblem(http.StatusBadRequest, CodeLimitExceeded, fmt.Sprintf("At most %d webhooks may be registered.", maxWebhooks))
+	}
+	w.ID = newJobID()
+	w.Created = time.Now().Unix()
+	if s.db != nil {
+		item, err := attributevalue.MarshalMap(w)
+		if err != nil {
+			return Webhook{}, fmt.Errorf("marshal webhook: %w", err)
+		}
+		if _, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+			return Webhook{}, err
+		}
+	}
+	s.mu.Lock()
+	s.hooks[w.ID] = &w
+	s.mu.Unlock()
+	return w, nil
+}
+
+// Get returns the webhook without its secret.
+func (s *WebhookStore) Get(id string) (Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, ok := s.hooks[id]
+	if !ok {
+		return Webhook{}, errWebhookNotFound
+	}
+	hook := *w
+	hook.Secret = ""
+	return hook, nil
+}
+
+// List returns every webhook, oldest first, without their secrets.
+func (s *WebhookStore) List() []Webhook {
+	s.mu.RLock()
+	hooks := make([]Webhook, 0, len(s.hooks))
+	for _, w := range s.hooks {
+		hook := *w
+		hook.Secret = ""
+		hooks = append(hooks, hook)
+	}
+	s.mu.RUnlock()
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Created != hooks[j].Created {
+			return hooks[i].Created < hooks[j].Created
+		}
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks
+}
+
+// Delete removes a webhook. Deliveries still being retried stop before their
+// next attempt.
+func (s *WebhookStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	if s.db != nil {
+		_, err := s.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.table),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	delete(s.hooks, id)
+	delete(s.deliveries, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Deliveries returns the webhook's recent deliveries, newest first.
+func (s *WebhookStore) Deliveries(id string) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.hooks[id]; !ok {
+		return nil, errWebhookNotFound
+	}
+	log := s.deliveries[id]
+	deliveries := make([]WebhookDelivery, len(log))
+	for i, d := range log {
+		deliveries[len(log)-1-i] = *d
+	}
+	return deliveries, nil
+}
+
+// refresh replaces the registrations with those in WEBHOOKS_TABLE.
+func (s *WebhookStore) refresh(ctx context.Context) error {
+	hooks := make(map[string]*Webhook)
+	paginator := dynamodb.NewScanPaginator(s.db, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []Webhook
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		for i := range items {
+			hooks[items[i].ID] = &items[i]
+		}
+	}
+	s.mu.Lock()
+	s.hooks = hooks
+	for id := range s.deliveries {
+		if hooks[id] == nil {
+			delete(s.deliveries, id)
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// webhookRequest is the body of POST /webhooks.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// validate checks req and returns it as a webhook, with a generated secret
+// if it has none.
+func (req webhookRequest) validate() (Webhook, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, newProblem(http.StatusBadRequest, CodeInvalidBody, "'url' must be an absolute http or https URL.")
+	}
+	for _, t := range req.Events {
+		if !slices.Contains(eventTypes, t) {
+			return Webhook{}, newProblem(http.StatusBadRequest, CodeInvalidBody,
+				fmt.Sprintf("Unknown event type %q. Must be one of %s.", t, strings.Join(eventTypes, ", ")))
+		}
+	}
+	secret := req.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		secret = hex.EncodeToString(b)
+	} else if len(secret) < minSecretLength {
+		return Webhook{}, newProblem(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("'secret' must be at least %d characters.", minSecretLength))
+	}
+	events := slices.Compact(slices.Sorted(slices.Values(req.Events)))
+	if events == nil {
+		events = []string{}
+	}
+	return Webhook{URL: req.URL, Events: events, Secret: secret}, nil
+}
+
+// WebhookListResponse is the body of GET /webhooks.
+type WebhookListResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookDeliveriesResponse is the body of GET /webhooks/:id/deliveries.
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// postWebhook registers a webhook. The response carries its secret, which
+// is not shown again.
+func (api *API) postWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidBody, "Invalid request body. Expected {\"url\": \"...\", \"events\": [...]}.")
+		return
+	}
+	hook, err := req.validate()
+	if err == nil {
+		hook.Tenant = tenantFrom(c.Request.Context())
+		hook, err = api.Webhooks.Create(c.Request.Context(), hook)
+	}
+	if err != nil {
+		var p *Problem
+		if !errors.As(err, &p) {
+			slog.ErrorContext(c.Request.Context(), "failed to save webhook", "err", err)
+		}
+		respondProblem(c, problemFor(err))
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, hook)
+}
+
+// getWebhooks lists the webhooks of the caller's tenant, or every webhook
+// for ADMIN_TOKEN.
+func (api *API) getWebhooks(c *gin.Context) {
+	hooks := []Webhook{}
+	for _, hook := range api.Webhooks.List() {
+		if api.ownsTenant(c.Request.Context(), hook.Tenant) {
+			hooks = append(hooks, hook)
+		}
+	}
+	c.IndentedJSON(http.StatusOK, WebhookListResponse{Webhooks: hooks})
+}
+
+// tenantWebhook returns the webhook id of the caller's tenant. Another
+// tenant's is not found.
+func (api *API) tenantWebhook(ctx context.Context, id string) (Webhook, error) {
+	hook, err := api.Webhooks.Get(id)
+	if err == nil && !api.ownsTenant(ctx, hook.Tenant) {
+		return Webhook{}, errWebhookNotFound
+	}
+	return hook, err
+}
+
+func (api *API) getWebhook(c *gin.Context) {
+	hook, err := api.tenantWebhook(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeWebhookNotFound, "webhook not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, hook)
+}
+
+func (api *API) deleteWebhook(c *gin.Context) {
+	_, err := api.tenantWebhook(c.Request.Context(), c.Param("id"))
+	if err == nil {
+		err = api.Webhooks.Delete(c.Request.Context(), c.Param("id"))
+	}
+	if err != nil {
+		if errors.Is(err, errWebhookNotFound) {
+			respondError(c, http.StatusNotFound, CodeWebhookNotFound, "webhook not found")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "failed to delete webhook", "id", c.Param("id"), "err", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete webhook")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getWebhookDeliveries lists the webhook's last deliveries from this
+// instance, newest first.
+func (api *API) getWebhookDeliveries(c *gin.Context) {
+	_, err := api.tenantWebhook(c.Request.Context(), c.Param("id"))
+	var deliveries []WebhookDelivery
+	if err == nil {
+		deliveries, err = api.Webhooks.Deliveries(c.Param("id"))
+	}
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeWebhookNotFound, "webhook not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, WebhookDeliveriesResponse{Deliveries: deliveries})
+}
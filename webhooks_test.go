@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignWebhook(t *testing.T) {
+	got := signWebhook("0123456789abcdef", 1791936000, []byte(`{"id":1}`))
+	want := "t=1791936000,v1=56e11ddb1fb7850a1664f4a6901fd7e202407af4b7d6487284aef0790fa307b0"
+	if got != want {
+		t.Errorf("signWebhook = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookRequestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		req  webhookRequest
+		ok   bool
+	}{
+		{"all events", webhookRequest{URL: "https://example.com/hook"}, true},
+		{"some events", webhookRequest{URL: "http://scheduler:8080/hook", Events: []string{EventMissionCompleted, EventMissionCompleted}}, true},
+		{"own secret", webhookRequest{URL: "https://example.com/hook", Secret: "0123456789abcdef"}, true},
+		{"short secret", webhookRequest{URL: "https://example.com/hook", Secret: "short"}, false},
+		{"unknown event", webhookRequest{URL: "https://example.com/hook", Events: []string{"mission.launched"}}, false},
+		{"relative url", webhookRequest{URL: "/hook"}, false},
+		{"other scheme", webhookRequest{URL: "ftp://example.com/hook"}, false},
+		{"no url", webhookRequest{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook, err := tt.req.validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("validate() error = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil {
+				if p := problemFor(err); p.Code != CodeInvalidBody {
+					t.Errorf("code = %s", p.Code)
+				}
+				return
+			}
+			if len(hook.Secret) < minSecretLength || (tt.req.Secret != "" && hook.Secret != tt.req.Secret) {
+				t.Errorf("secret = %q", hook.Secret)
+			}
+			if hook.Events == nil || len(hook.Events) > 1 {
+				t.Errorf("events = %v", hook.Events)
+			}
+		})
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan *http.Request, 4)
+	bodies := make(chan []byte, 4)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, so the delivery is retried.
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer endpoint.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus()
+	store := newWebhookStore(nil, "", defaultWebhookAttempts)
+	store.retryBase = time.Millisecond
+	completed, err := store.Create(ctx, Webhook{URL: endpoint.URL, Events: []string{EventMissionCompleted}, Secret: "0123456789abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer refused.Close()
+	gone, err := store.Create(ctx, Webhook{URL: refused.URL, Secret: "fedcba9876543210"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Start(ctx, bus)
+
+	// The dispatcher subscribes in the background; publish until it has.
+	var sent Event
+	deadline := time.After(5 * time.Second)
+	for sent.ID == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("dispatcher never subscribed")
+		case <-time.After(time.Millisecond):
+		}
+		bus.mu.Lock()
+		subscribed := len(bus.subs) > 0
+		bus.mu.Unlock()
+		if subscribed {
+			bus.Publish(Event{Type: EventMissionUpdated, MissionID: "m1"})
+			sent = bus.Publish(Event{Type: EventMissionCompleted, MissionID: "m1"})
+		}
+	}
+
+	var r *http.Request
+	select {
+	case r = <-received:
+	case <-deadline:
+		t.Fatal("no delivery")
+	}
+	body := <-bodies
+	var got Event
+	if err := json.Unmarshal(body, &got); err != nil || got.ID != sent.ID || got.Type != EventMissionCompleted {
+		t.Errorf("delivered %s, err %v", body, err)
+	}
+	ts, _, _ := strings.Cut(strings.TrimPrefix(r.Header.Get("X-Sat-Signature"), "t="), ",")
+	unix, _ := strconv.ParseInt(ts, 10, 64)
+	if want := signWebhook("0123456789abcdef", unix, body); r.Header.Get("X-Sat-Signature") != want {
+		t.Errorf("signature %s, want %s", r.Header.Get("X-Sat-Signature"), want)
+	}
+	if r.Header.Get("X-Sat-Event") != EventMissionCompleted || r.Header.Get("X-Sat-Delivery") == "" {
+		t.Errorf("headers = %v", r.Header)
+	}
+
+	waitFor := func(id string, n int, done func([]WebhookDelivery) bool) []WebhookDelivery {
+		t.Helper()
+		for {
+			deliveries, err := store.Deliveries(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) == n && done(deliveries) {
+				return deliveries
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("deliveries to %s = %+v", id, deliveries)
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	// Only mission.completed was delivered to the first webhook, on the
+	// second attempt.
+	d := waitFor(completed.ID, 1, func(d []WebhookDelivery) bool { return d[0].Status == DeliverySucceeded })[0]
+	if d.Attempts != 2 || d.StatusCode != http.StatusOK || d.EventID != sent.ID || d.Error != "" {
+		t.Errorf("delivery = %+v", d)
+	}
+	// A 410 is not retried.
+	for _, d := range waitFor(gone.ID, 2, func(d []WebhookDelivery) bool {
+		return d[0].Status == DeliveryFailed && d[1].Status == DeliveryFailed
+	}) {
+		if d.Attempts != 1 || d.StatusCode != http.StatusGone {
+			t.Errorf("refused delivery = %+v", d)
+		}
+	}
+}
+
+func TestWebhookRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &API{Config: &Config{AdminToken: "secret"}, Webhooks: newWebhookStore(nil, "", defaultWebhookAttempts)}
+	router := gin.New()
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated list: status = %d", w.Code)
+	}
+
+	w = do(http.MethodPost, "/webhooks", `{"url": "https://scheduler.example.com/hook", "events": ["mission.completed"]}`)
+	var created Webhook
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil || created.ID == "" || created.Secret == "" {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/webhooks", `{"url": "not a url"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid create: status = %d", w.Code)
+	}
+
+	w = do(http.MethodGet, "/webhooks", "")
+	var list WebhookListResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list.Webhooks) != 1 || list.Webhooks[0].Secret != "" {
+		t.Errorf("list: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/webhooks/"+created.ID, ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Secret) {
+		t.Errorf("get: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/webhooks/"+created.ID+"/deliveries", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deliveries": []`) {
+		t.Errorf("deliveries: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/webhooks/"+created.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", w.Code)
+	}
+	for _, path := range []string{"/webhooks/" + created.ID, "/webhooks/" + created.ID + "/deliveries"} {
+		w := do(http.MethodGet, path, "")
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), string(CodeWebhookNotFound)) {
+			t.Errorf("GET %s after delete: %d %s", path, w.Code, w.Body)
+		}
+	}
+}
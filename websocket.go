@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsBuffer is how many events a connection may fall behind by before it
+	// is closed; the client reconnects and refetches /missions.
+	wsBuffer       = 64
+	wsPingInterval = 30 * time.Second
+	wsWriteWait    = 10 * time.Second
+)
+
+// The CORS middleware has already refused origins outside CORS_ORIGINS by the
+// time a request gets here, so the upgrader accepts whatever reaches it.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  512,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// getWebSocket upgrades to a WebSocket and sends each mission event as a JSON
+// text message. Messages from the client are read only to notice its close.
+func (api *API) getWebSocket(c *gin.Context) {
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParameter, "expected a WebSocket upgrade request")
+		return
+	}
+	// Subscribing first means no event published during the handshake is
+	// missed.
+	events, cancel := api.Events.Subscribe(wsBuffer, tenantEvents(c.Request.Context(), isMissionEvent))
+	defer cancel()
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already sent the client a 400.
+		slog.WarnContext(c.Request.Context(), "websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	eventSubscribers.WithLabelValues("websocket").Inc()
+	defer eventSubscribers.WithLabelValues("websocket").Dec()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case e, ok := <-events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client fell behind"))
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
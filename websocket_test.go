@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketEvents(t *testing.T) {
+	api := testGRPCAPI(t)
+	api.Config.AdminToken = "secret"
+	api.Events = newEventBus()
+	db := api.MissionDB.(*sqlStore)
+	data, _ := json.Marshal(Mission{ID: "m1", Name: "one"})
+	if _, err := db.db.Exec("INSERT INTO missions (id, data) VALUES ($1, $2)", "m1", string(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// The upgrade has to hijack through the writers the middleware wraps.
+	router.Use(compressResponses(), assignRequestID(), logRequests())
+	router.GET("/ws", api.getWebSocket)
+	api.routesV1(router, routeTimeout(0), routeTimeout(0))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	invalidate := func(id string) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/mission/"+id+"/invalidate", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("invalidate %s: %v %v", id, resp, err)
+		}
+		resp.Body.Close()
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	invalidate("m1")
+	var e Event
+	if err := conn.ReadJSON(&e); err != nil || e.Type != EventMissionUpdated || e.Mission == nil || e.Mission.Name != "one" {
+		t.Errorf("update event = %+v, %v", e, err)
+	}
+	invalidate("gone")
+	e = Event{}
+	if err := conn.ReadJSON(&e); err != nil || e.Type != EventMissionDeleted || e.MissionID != "gone" || e.Mission != nil {
+		t.Errorf("delete event = %+v, %v", e, err)
+	}
+
+	resp, err := http.Get(srv.URL + "/ws")
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET /ws = %v, %v", resp, err)
+	}
+}